@@ -0,0 +1,183 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkproto"
+	"amattu2/blink-middleware/pkg/credstore"
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	region := flag.String("region", "", "Blink account region (e.g., u011); detected if omitted")
+	apiToken := flag.String("token", "", "Blink API token")
+	deviceType := flag.String("device-type", "", "Device type (camera, owl, hawk, doorbell, lotus); detected automatically if omitted")
+	accountId := flag.Int("account-id", 0, "Blink account ID")
+	networkId := flag.Int("network-id", 0, "Network ID")
+	cameraId := flag.Int("camera-id", 0, "Camera ID")
+	duration := flag.Duration("duration", 30*time.Second, "How long to record the stream")
+	credentialsPath := flag.String("credentials", "", "Encrypted credentials file (defaults to the user configuration directory); the passphrase is read from $BLINK_PASSPHRASE")
+	media := flag.String("media", "", "When replaying, write the decoded MPEG-TS data to this file")
+	payloads := flag.Bool("payloads", false, "When replaying, print the payloads of control frames and sent data in hex")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] record <file> | replay <file>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	log.SetOutput(os.Stderr)
+
+	args := flag.Args()
+	if len(args) != 2 || (args[0] != "record" && args[0] != "replay") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if args[0] == "replay" {
+		if err := replay(args[1], *media, *payloads); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	// Fill missing credentials from the credentials file
+	if *credentialsPath == "" {
+		*credentialsPath, _ = credstore.DefaultPath()
+	}
+	if *apiToken == "" && *credentialsPath != "" {
+		creds, err := credstore.Load(*credentialsPath, os.Getenv(credstore.PASSPHRASE_ENV))
+		if err != nil && !errors.Is(err, credstore.ErrNotFound) {
+			log.Fatalf("Error loading credentials: %v", err)
+		}
+		if err == nil {
+			*apiToken = creds.ApiToken
+			if *region == "" {
+				*region = creds.Region
+			}
+			if *accountId == 0 {
+				*accountId = creds.AccountId
+			}
+		}
+	}
+
+	if *apiToken == "" || *accountId == 0 || *networkId == 0 || *cameraId == 0 {
+		log.Fatal("Error: --token, --account-id, --network-id, and --camera-id are required")
+	}
+	if *region == "" {
+		detected, err := liveview.ResolveRegion(*apiToken, *accountId)
+		if err != nil {
+			log.Fatalf("Error: cannot detect the region, pass --region: %v", err)
+		}
+		*region = detected
+		log.Printf("Detected region %s", *region)
+	}
+
+	file, err := os.Create(args[1])
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer file.Close()
+
+	config := liveview.DefaultClientConfig()
+	config.Capture = file
+	client := liveview.NewClientWithConfig(*region, *apiToken, *deviceType, *accountId, *networkId, *cameraId, config)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancelDuration := context.WithTimeout(ctx, *duration)
+	defer cancelDuration()
+
+	log.Printf("Capturing the stream of camera %d to %s for %s...", *cameraId, args[1], *duration)
+	if err := client.Stream(ctx, io.Discard); err != nil {
+		log.Printf("Stream ended: %v", err)
+	}
+	log.Printf("Saved capture to %s", args[1])
+}
+
+// replay feeds the received data of a capture through the frame decoder and prints
+// the frames and sent data in order
+func replay(path string, mediaPath string, payloads bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := blinkproto.NewCaptureReader(file)
+	if err != nil {
+		return err
+	}
+
+	media := io.Discard
+	if mediaPath != "" {
+		out, err := os.Create(mediaPath)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		media = out
+	}
+
+	var mediaBytes int
+	countMedia := writerFunc(func(p []byte) (int, error) {
+		mediaBytes += len(p)
+		return media.Write(p)
+	})
+
+	// Each connection has its own framing
+	decoders := map[uint32]*blinkproto.Decoder{}
+	var prefix string
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		prefix = fmt.Sprintf("%10.3fs  conn %d", record.Offset.Seconds(), record.Connection)
+		if record.Direction == blinkproto.CAPTURE_SENT {
+			fmt.Printf("%s  sent %d bytes\n", prefix, len(record.Data))
+			if payloads {
+				fmt.Print(hex.Dump(record.Data))
+			}
+			continue
+		}
+
+		decoder, ok := decoders[record.Connection]
+		if !ok {
+			decoder = blinkproto.NewDecoder(countMedia, func(frame blinkproto.Frame) {
+				fmt.Printf("%s  control frame type 0x%02x seq %d, %d bytes\n", prefix, frame.Type, frame.Sequence, len(frame.Payload))
+				if payloads {
+					fmt.Print(hex.Dump(frame.Payload))
+				}
+			})
+			decoders[record.Connection] = decoder
+		}
+		if _, err := decoder.Write(record.Data); err != nil {
+			fmt.Printf("%s  %v\n", prefix, err)
+		}
+	}
+
+	fmt.Printf("Decoded %d bytes of MPEG-TS data from %d connections\n", mediaBytes, len(decoders))
+
+	return nil
+}
+
+// writerFunc adapts a function to an io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
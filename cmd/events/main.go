@@ -0,0 +1,216 @@
+package main
+
+import (
+	"amattu2/blink-middleware/internal/cli"
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/credstore"
+	"amattu2/blink-middleware/pkg/events"
+	"amattu2/blink-middleware/pkg/hooks"
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/notify"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var webhooks, doorbellWebhooks, headers, networks cli.ListFlag
+
+	region := flag.String("region", "", "Blink account region (e.g., u011); detected if omitted")
+	apiToken := flag.String("token", "", "Blink API token")
+	accountId := flag.Int("account-id", 0, "Blink account ID")
+	interval := flag.Duration("interval", 30*time.Second, "Interval between polls for new events")
+	credentialsPath := flag.String("credentials", "", "Encrypted credentials file (defaults to the user configuration directory); the passphrase is read from $BLINK_PASSPHRASE")
+	flag.Var(&networks, "network-id", "Only report events of this network ID (repeatable)")
+	flag.Var(&webhooks, "webhook", "POST each event as JSON to this URL (repeatable)")
+	flag.Var(&doorbellWebhooks, "doorbell-webhook", "POST only doorbell presses as JSON to this URL (repeatable)")
+	flag.Var(&headers, "webhook-header", "Header added to webhook requests, as \"Name: value\" (repeatable)")
+	notifyPath := flag.String("notify", "", "Send Telegram, Pushover or Slack notifications configured in this JSON file")
+	hooksPath := flag.String("hooks", "", "Run the on_motion and on_error commands configured in this JSON file")
+	ffmpeg := flag.String("ffmpeg", "ffmpeg", "The ffmpeg command encoding the GIFs attached to notifications")
+
+	flag.Parse()
+
+	log.SetOutput(os.Stderr)
+
+	// Fill missing credentials from the credentials file
+	if *credentialsPath == "" {
+		*credentialsPath, _ = credstore.DefaultPath()
+	}
+	if *apiToken == "" && *credentialsPath != "" {
+		creds, err := credstore.Load(*credentialsPath, os.Getenv(credstore.PASSPHRASE_ENV))
+		if err != nil && !errors.Is(err, credstore.ErrNotFound) {
+			log.Fatalf("Error loading credentials: %v", err)
+		}
+		if err == nil {
+			*apiToken = creds.ApiToken
+			if *region == "" {
+				*region = creds.Region
+			}
+			if *accountId == 0 {
+				*accountId = creds.AccountId
+			}
+		}
+	}
+
+	if *apiToken == "" || *accountId == 0 {
+		log.Fatal("Error: --token and --account-id are required")
+	}
+	if *region == "" {
+		detected, err := liveview.ResolveRegion(*apiToken, *accountId)
+		if err != nil {
+			log.Fatalf("Error: cannot detect the region, pass --region: %v", err)
+		}
+		*region = detected
+		log.Printf("Detected region %s", *region)
+	}
+
+	networkIds := make([]int, 0, len(networks))
+	for _, network := range networks {
+		id, err := strconv.Atoi(network)
+		if err != nil {
+			log.Fatalf("Error: invalid --network-id %q", network)
+		}
+		networkIds = append(networkIds, id)
+	}
+
+	headerValues := map[string]string{}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			log.Fatalf("Error: invalid --webhook-header %q", header)
+		}
+		headerValues[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	onLog := func(msg string) {
+		log.Println(msg)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	webhook := events.NewWebhook(events.WebhookConfig{
+		URLs:    webhooks,
+		Headers: headerValues,
+		OnLog:   onLog,
+	})
+
+	doorbellWebhook := events.NewWebhook(events.WebhookConfig{
+		URLs:    doorbellWebhooks,
+		Headers: headerValues,
+		OnLog:   onLog,
+	})
+
+	var dispatcher *notify.Dispatcher
+	if *notifyPath != "" {
+		notifyConfig, err := notify.Load(*notifyPath)
+		if err != nil {
+			log.Fatalf("Error loading notifications: %v", err)
+		}
+
+		cc := blinkapi.ClientCredentials{Region: *region, ApiToken: *apiToken, AccountId: *accountId}
+		api := blinkapi.NewBlinkAPI(blinkapi.APIConfig{})
+		notifyConfig.Attach = attachMedia(api, cc, *ffmpeg)
+		notifyConfig.OnLog = onLog
+		dispatcher = notify.New(notifyConfig)
+
+		if dispatcher.Wants(notify.TRIGGER_OFFLINE) {
+			go watchOffline(ctx, api, cc, *interval, networkIds, dispatcher)
+		}
+	}
+	dispatch := func(trigger string, event events.Event) {
+		if dispatcher == nil {
+			return
+		}
+
+		go func() {
+			err := dispatcher.Dispatch(ctx, notify.Event{
+				Trigger:      trigger,
+				Camera:       event.Camera,
+				CameraId:     event.CameraId,
+				NetworkId:    event.NetworkId,
+				Timestamp:    event.Timestamp,
+				ThumbnailURL: event.ThumbnailURL,
+				ClipURL:      event.ClipURL,
+			})
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	runner := hooks.New(hooks.Config{OnLog: onLog})
+	if *hooksPath != "" {
+		hooksConfig, err := hooks.Load(*hooksPath)
+		if err != nil {
+			log.Fatalf("Error loading hooks: %v", err)
+		}
+		hooksConfig.OnLog = onLog
+		runner = hooks.New(hooksConfig)
+	}
+
+	// Events are printed as JSON lines on stdout
+	encoder := json.NewEncoder(os.Stdout)
+	watcher := events.NewWatcher(events.WatcherConfig{
+		Region:       *region,
+		ApiToken:     *apiToken,
+		AccountId:    *accountId,
+		NetworkIds:   networkIds,
+		PollInterval: *interval,
+		OnEvent: func(event events.Event) {
+			encoder.Encode(event)
+			runner.Fire(hooks.Event{
+				Hook:         hooks.HOOK_MOTION,
+				Timestamp:    event.Timestamp,
+				CameraId:     event.CameraId,
+				NetworkId:    event.NetworkId,
+				Camera:       event.Camera,
+				ClipURL:      event.ClipURL,
+				ThumbnailURL: event.ThumbnailURL,
+			})
+			if !event.DoorbellPress {
+				dispatch(notify.TRIGGER_MOTION, event)
+			}
+			if len(webhooks) == 0 {
+				return
+			}
+
+			go func() {
+				if err := webhook.Send(ctx, event); err != nil {
+					log.Println(err)
+				}
+			}()
+		},
+		OnDoorbellPress: func(event events.Event) {
+			log.Printf("Doorbell %s was pressed", event.Camera)
+			dispatch(notify.TRIGGER_DOORBELL, event)
+			if len(doorbellWebhooks) == 0 {
+				return
+			}
+
+			go func() {
+				if err := doorbellWebhook.Send(ctx, event); err != nil {
+					log.Println(err)
+				}
+			}()
+		},
+		OnError: func(err error) {
+			log.Println(err)
+			runner.Fire(hooks.Event{Hook: hooks.HOOK_ERROR, Error: err.Error()})
+		},
+		OnLog: onLog,
+	})
+
+	if err := watcher.Run(ctx); err != nil {
+		log.Fatalf("Error watching events: %v", err)
+	}
+}
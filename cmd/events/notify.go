@@ -0,0 +1,109 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/notify"
+	"amattu2/blink-middleware/pkg/transcode"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// attachMedia returns the Attach callback of a dispatcher, fetching the thumbnail
+// of an event as its snapshot or encoding its clip into a GIF with ffmpeg
+func attachMedia(api *blinkapi.BlinkAPI, cc blinkapi.ClientCredentials, ffmpeg string) func(context.Context, notify.Event, string) (*notify.Attachment, error) {
+	return func(ctx context.Context, event notify.Event, kind string) (*notify.Attachment, error) {
+		switch kind {
+		case notify.ATTACH_SNAPSHOT:
+			if event.ThumbnailURL == "" {
+				return nil, fmt.Errorf("the event has no thumbnail")
+			}
+
+			var thumbnail bytes.Buffer
+			if err := api.DownloadMediaContext(ctx, cc, event.ThumbnailURL, &thumbnail); err != nil {
+				return nil, err
+			}
+			return &notify.Attachment{
+				Name:        "snapshot.jpg",
+				ContentType: http.DetectContentType(thumbnail.Bytes()),
+				Data:        thumbnail.Bytes(),
+			}, nil
+		case notify.ATTACH_ANIMATION:
+			if event.ClipURL == "" {
+				return nil, fmt.Errorf("the event has no clip")
+			}
+
+			// ffmpeg seeks the MP4 clip, which needs a file rather than a pipe
+			clip, err := os.CreateTemp("", "blink-clip-*.mp4")
+			if err != nil {
+				return nil, err
+			}
+			defer os.Remove(clip.Name())
+			err = api.DownloadMediaContext(ctx, cc, event.ClipURL, clip)
+			clip.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			image, err := transcode.AnimateFile(ctx, transcode.AnimationConfig{Format: transcode.FORMAT_GIF, FFmpeg: ffmpeg}, clip.Name())
+			if err != nil {
+				return nil, err
+			}
+			return &notify.Attachment{Name: "motion.gif", ContentType: "image/gif", Data: image}, nil
+		default:
+			return nil, fmt.Errorf("unknown attachment %q", kind)
+		}
+	}
+}
+
+// watchOffline polls the homescreen until the context is cancelled and notifies
+// when a camera goes offline. Cameras offline at the first poll are not reported.
+func watchOffline(ctx context.Context, api *blinkapi.BlinkAPI, cc blinkapi.ClientCredentials, interval time.Duration, networkIds []int, dispatcher *notify.Dispatcher) {
+	// The status of each camera at the previous poll
+	statuses := map[int]string{}
+	first := true
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		homescreen, err := api.GetHomescreenContext(ctx, cc)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error checking the cameras: %v", err)
+		}
+		if err == nil {
+			devices := slices.Concat(homescreen.Cameras, homescreen.Owls, homescreen.Doorbells)
+			for _, device := range devices {
+				if len(networkIds) > 0 && !slices.Contains(networkIds, device.NetworkId) {
+					continue
+				}
+
+				previous, seen := statuses[device.Id]
+				statuses[device.Id] = device.Status
+				if first || !seen || device.Status != "offline" || previous == "offline" {
+					continue
+				}
+
+				log.Printf("Camera %s went offline", device.Name)
+				go func() {
+					event := notify.Event{Trigger: notify.TRIGGER_OFFLINE, Camera: device.Name, CameraId: device.Id, NetworkId: device.NetworkId}
+					if err := dispatcher.Dispatch(ctx, event); err != nil {
+						log.Println(err)
+					}
+				}()
+			}
+			first = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"amattu2/blink-middleware/pkg/transcode"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runAnimate saves an animated GIF or WebP image of a recording, or of the next
+// seconds of the livestream, e.g. for notifications that cannot embed video. The
+// image format follows the file extension.
+func runAnimate(name string, args []string) {
+	fs := newFlagSet(name, "[flags] [file]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	input := fs.String("input", "", "Animate this recording (MPEG-TS or MP4) instead of the livestream")
+	start := fs.Duration("start", 0, "Offset of the animation from the start of --input")
+	duration := fs.Duration("duration", transcode.DEFAULT_ANIMATION_DURATION, "Length of the animation, at most 30s")
+	width := fs.Int("width", transcode.DEFAULT_ANIMATION_WIDTH, "Maximum width of the animation in pixels, at most 1280")
+	fps := fs.Int("fps", transcode.DEFAULT_ANIMATION_FPS, "Frame rate of the animation, at most 15")
+	ffmpeg := fs.String("ffmpeg", "ffmpeg", "The ffmpeg command encoding the animation")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time to wait for the livestream")
+	fs.Parse(args)
+
+	file := "animation.gif"
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+	format, err := transcode.AnimationFormat(file)
+	if err != nil {
+		exit(EXIT_USAGE, "Error: %v", err)
+	}
+	if *duration > transcode.MAX_ANIMATION_DURATION {
+		exit(EXIT_USAGE, "Error: --duration must be at most %s", transcode.MAX_ANIMATION_DURATION)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	config := transcode.AnimationConfig{
+		Format:   format,
+		Width:    *width,
+		FPS:      *fps,
+		Duration: *duration,
+		FFmpeg:   *ffmpeg,
+		OnLog: func(msg string) {
+			log.Println(msg)
+		},
+	}
+
+	var image []byte
+	if *input != "" {
+		config.Start = *start
+		image, err = transcode.AnimateFile(ctx, config, *input)
+	} else {
+		account.resolve()
+		if *camera.networkId == 0 || *camera.cameraId == 0 {
+			exit(EXIT_USAGE, "Error: --network-id and --camera-id are required without --input")
+		}
+
+		clientConfig := account.clientConfig()
+		clientConfig.Streams = mpegts.STREAMS_VIDEO
+		clientConfig.OnLog = func(string) {}
+		client := liveview.NewClientWithConfig(
+			*account.region,
+			*account.apiToken,
+			*camera.deviceType,
+			*account.accountId,
+			*camera.networkId,
+			*camera.cameraId,
+			clientConfig,
+		)
+
+		log.Printf("Capturing %s of the camera...", *duration)
+		var stream []byte
+		stream, err = captureClip(ctx, client, *duration, *timeout)
+		if err != nil {
+			exit(EXIT_CONNECT, "Error: %v", err)
+		}
+		image, err = transcode.Animate(ctx, config, bytes.NewReader(stream))
+	}
+	if err != nil {
+		exit(EXIT_FAILURE, "Error: %v", err)
+	}
+
+	if err := os.WriteFile(file, image, 0o644); err != nil {
+		exit(EXIT_OUTPUT, "Error: %v", err)
+	}
+	log.Printf("Saved animation to %s", file)
+}
+
+// clipBuffer collects the livestream until it spans the duration after its first
+// data
+type clipBuffer struct {
+	// The length of the clip
+	duration time.Duration
+	// Closed once the clip is complete
+	done chan struct{}
+	// Guards the fields below
+	mu sync.Mutex
+	// The collected stream
+	data []byte
+	// When the first data arrived
+	first time.Time
+}
+
+func (b *clipBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.first.IsZero() {
+		b.first = time.Now()
+	}
+	select {
+	case <-b.done:
+	default:
+		b.data = append(b.data, p...)
+		if time.Since(b.first) >= b.duration {
+			close(b.done)
+		}
+	}
+
+	return len(p), nil
+}
+
+// captureClip streams the camera until the duration of stream data was received,
+// which ends the livestream session
+//
+// ctx: cancels the capture
+//
+// client: the livestream client of the camera
+//
+// duration: the length of the clip
+//
+// timeout: the maximum time to wait for the livestream to start
+//
+// Example: captureClip(ctx, client, 5*time.Second, 30*time.Second) = []byte{...}, nil
+func captureClip(ctx context.Context, client *liveview.Client, duration time.Duration, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout+duration)
+	defer cancel()
+
+	buffer := &clipBuffer{duration: duration, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-buffer.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	streamErr := client.Stream(ctx, buffer)
+
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	select {
+	case <-buffer.done:
+		return buffer.data, nil
+	default:
+	}
+	if len(buffer.data) > 0 {
+		// A stream that ended early still makes a shorter clip
+		return buffer.data, nil
+	}
+	if streamErr == nil {
+		streamErr = ctx.Err()
+	}
+
+	return nil, streamErr
+}
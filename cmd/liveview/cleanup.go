@@ -0,0 +1,106 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runCleanup stops the liveview commands a camera or network was left with, e.g.
+// after a crash, recovering cameras Blink reports as busy. Without --camera-id every
+// liveview command of the network is stopped.
+func runCleanup(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	list := fs.Bool("list", false, "List the commands of the network without stopping any")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time for the API requests")
+	fs.Parse(args)
+
+	account.resolve()
+	if *camera.networkId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cc := account.credentials()
+	cc.NetworkId = *camera.networkId
+	if *list {
+		commands, err := account.api().ListCommandsContext(ctx, cc)
+		if err != nil {
+			exit(EXIT_CONNECT, "Error: %v", err)
+		}
+		printCommands(commands)
+		return
+	}
+
+	var stopped []blinkapi.Command
+	var err error
+	if *camera.cameraId != 0 {
+		config := account.clientConfig()
+		config.OnLog = func(string) {}
+		client := liveview.NewClientWithConfig(
+			*account.region,
+			*account.apiToken,
+			*camera.deviceType,
+			*account.accountId,
+			*camera.networkId,
+			*camera.cameraId,
+			config,
+		)
+		stopped, err = client.Sweep(ctx)
+	} else {
+		stopped, err = sweepNetwork(ctx, account.api(), cc)
+	}
+
+	if len(stopped) == 0 && err == nil {
+		log.Println("No liveview commands were left running")
+		return
+	}
+	printCommands(stopped)
+	if err != nil {
+		exit(EXIT_FAILURE, "Error: %v", err)
+	}
+	log.Printf("Stopped %d liveview commands", len(stopped))
+}
+
+// sweepNetwork stops every in-flight liveview command of the network
+func sweepNetwork(ctx context.Context, api blinkapi.API, cc blinkapi.ClientCredentials) ([]blinkapi.Command, error) {
+	commands, err := api.ListCommandsContext(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	var stopped []blinkapi.Command
+	var errs []error
+	for _, command := range commands {
+		if command.Command != blinkapi.COMMAND_LIVEVIEW || !command.InFlight() {
+			continue
+		}
+		if err := api.StopCommandContext(ctx, cc, command.Id); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping command %d: %w", command.Id, err))
+			continue
+		}
+		stopped = append(stopped, command)
+	}
+
+	return stopped, errors.Join(errs...)
+}
+
+// printCommands prints commands as a table
+func printCommands(commands []blinkapi.Command) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND ID\tCOMMAND\tTARGET\tTARGET ID\tSTATE\tCREATED")
+	for _, command := range commands {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", command.Id, command.Command, command.Target, command.TargetId, command.StateCondition, command.CreatedAt.Local().Format(time.DateTime))
+	}
+	w.Flush()
+}
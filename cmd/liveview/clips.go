@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// runClips lists or downloads the clips stored on the USB drive of a sync module
+func runClips(name string, args []string) {
+	fs := newFlagSet(name, "[flags] list | download <clip-id> <file>")
+	account := addAccountFlags(fs)
+	networkId := fs.Int("network-id", 0, "Network ID of the sync module")
+	syncModuleId := fs.Int("sync-module-id", 0, "Sync module ID (detected from the network if omitted)")
+	timeout := fs.Duration("timeout", 2*time.Minute, "Maximum time to wait for the sync module to upload the manifest or clip")
+	fs.Parse(args)
+
+	action := fs.Arg(0)
+	if (action != "list" || fs.NArg() != 1) && (action != "download" || fs.NArg() != 3) {
+		fs.Usage()
+		os.Exit(EXIT_USAGE)
+	}
+
+	account.resolve()
+	if *networkId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id is required")
+	}
+
+	api := account.api()
+	cc := account.credentials()
+	cc.NetworkId = *networkId
+
+	if *syncModuleId == 0 {
+		homescreen, err := api.GetHomescreenContext(context.Background(), cc)
+		if err != nil {
+			exit(EXIT_CONNECT, "Error: %v", err)
+		}
+		syncModule, err := homescreen.SyncModule(*networkId)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: %v", err)
+		}
+		if !syncModule.LocalStorageEnabled {
+			exit(EXIT_USAGE, "Error: local storage is not enabled on sync module %s", syncModule.Name)
+		}
+		*syncModuleId = syncModule.Id
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, *timeout)
+	defer cancelTimeout()
+
+	log.Println("Requesting the clip manifest from the sync module...")
+	manifest, err := api.ListLocalStorageClips(ctx, cc, *syncModuleId)
+	if err != nil {
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+
+	if action == "list" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCAMERA\tCREATED\tSIZE")
+		for _, clip := range manifest.Clips {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", clip.Id, clip.CameraName, clip.CreatedAt.Local().Format(time.DateTime), clip.Size)
+		}
+		w.Flush()
+		return
+	}
+
+	clipId, path := fs.Arg(1), fs.Arg(2)
+	file, err := os.Create(path)
+	if err != nil {
+		exit(EXIT_OUTPUT, "Error: %v", err)
+	}
+
+	log.Printf("Requesting clip %s from the sync module...", clipId)
+	err = api.DownloadLocalStorageClip(ctx, cc, *syncModuleId, manifest.ManifestId, clipId, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+	log.Printf("Saved clip to %s", path)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// device is a camera listed by the devices command
+type device struct {
+	NetworkId int    `json:"network_id"`
+	Network   string `json:"network"`
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	Battery   string `json:"battery,omitempty"`
+	Firmware  string `json:"firmware,omitempty"`
+}
+
+// runDevices lists the cameras of the account with the IDs the other commands take
+func runDevices(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	networkId := fs.Int("network-id", 0, "Only list the cameras of this network")
+	asJSON := fs.Bool("json", false, "Print the cameras as JSON")
+	fs.Parse(args)
+
+	account.resolve()
+
+	homescreen, err := account.api().GetHomescreenContext(context.Background(), account.credentials())
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	networks := map[int]string{}
+	for _, network := range homescreen.Networks {
+		networks[network.Id] = network.Name
+	}
+
+	devices := []device{}
+	lists := []struct {
+		deviceType string
+		devices    []blinkapi.HomescreenDevice
+	}{
+		{"camera", homescreen.Cameras},
+		{"owl", homescreen.Owls},
+		{"doorbell", homescreen.Doorbells},
+	}
+	for _, list := range lists {
+		for _, d := range list.devices {
+			if *networkId != 0 && d.NetworkId != *networkId {
+				continue
+			}
+			devices = append(devices, device{
+				NetworkId: d.NetworkId,
+				Network:   networks[d.NetworkId],
+				Id:        d.Id,
+				Name:      d.Name,
+				Type:      list.deviceType,
+				Status:    d.Status,
+				Battery:   d.Battery,
+				Firmware:  d.FwVersion,
+			})
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(devices); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NETWORK ID\tNETWORK\tCAMERA ID\tNAME\tTYPE\tSTATUS\tBATTERY\tFIRMWARE")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", d.NetworkId, d.Network, d.Id, d.Name, d.Type, d.Status, d.Battery, d.Firmware)
+	}
+	w.Flush()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Results of a doctor check
+const (
+	CHECK_OK   = "ok"
+	CHECK_WARN = "warn"
+	CHECK_FAIL = "fail"
+	CHECK_SKIP = "skip"
+)
+
+// check is a step of the doctor report
+type check struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+	// The exit code of a failed check
+	code int
+}
+
+// doctor runs the checks in order, skipping the ones whose prerequisite failed
+type doctor struct {
+	checks []check
+	// Whether a check failed, so the dependent checks are skipped
+	failed bool
+}
+
+// run runs a check and records its result
+func (d *doctor) run(name string, fn func() check) {
+	if d.failed {
+		d.skip(name, "skipped after the failure above")
+		return
+	}
+
+	c := fn()
+	c.Name = name
+	d.checks = append(d.checks, c)
+	if c.Result == CHECK_FAIL {
+		d.failed = true
+	}
+}
+
+// skip records a check that was not run
+func (d *doctor) skip(name string, detail string) {
+	d.checks = append(d.checks, check{Name: name, Result: CHECK_SKIP, Detail: detail})
+}
+
+// passed, warned, and failed return the result of a check
+func passed(format string, args ...any) check {
+	return check{Result: CHECK_OK, Detail: fmt.Sprintf(format, args...)}
+}
+
+func warned(format string, args ...any) check {
+	return check{Result: CHECK_WARN, Detail: fmt.Sprintf(format, args...)}
+}
+
+func failed(code int, format string, args ...any) check {
+	return check{Result: CHECK_FAIL, Detail: fmt.Sprintf(format, args...), code: code}
+}
+
+// exitCode returns the exit code of the first failed check, or 0
+func (d *doctor) exitCode() int {
+	for _, c := range d.checks {
+		if c.Result == CHECK_FAIL {
+			return c.code
+		}
+	}
+
+	return 0
+}
+
+// runDoctor verifies the credentials, the API and relay connectivity, and the camera,
+// and prints a report of each check
+func runDoctor(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	timeout := fs.Duration("timeout", 15*time.Second, "Maximum time for each check")
+	noLiveview := fs.Bool("no-liveview", false, "Skip starting a liveview command, which wakes battery cameras")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	d := &doctor{}
+	api := account.api()
+	withTimeout := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), *timeout)
+	}
+
+	d.run("credentials", func() check {
+		loaded, err := account.load()
+		if err != nil {
+			return failed(EXIT_AUTH, "cannot load %s: %v", *account.credentialsPath, err)
+		}
+		if *account.apiToken == "" || *account.accountId == 0 {
+			return failed(EXIT_AUTH, "--token and --account-id are required; run '%s login' to save them", programName())
+		}
+		if loaded {
+			return passed("account %d loaded from %s", *account.accountId, *account.credentialsPath)
+		}
+		return passed("account %d from the flags", *account.accountId)
+	})
+
+	d.run("region", func() check {
+		if *account.region != "" {
+			return passed("%s", *account.region)
+		}
+		ctx, cancel := withTimeout()
+		defer cancel()
+		detected, err := api.ResolveRegionContext(ctx, *account.apiToken, *account.accountId)
+		if err != nil {
+			return failed(EXIT_AUTH, "cannot detect the region, pass --region: %v", err)
+		}
+		*account.region = detected
+		return passed("%s (detected)", detected)
+	})
+
+	d.run("dns", func() check {
+		parsed, err := url.Parse(api.CreateURL(account.credentials(), "/"))
+		if err != nil {
+			return failed(EXIT_CONNECT, "%v", err)
+		}
+		ctx, cancel := withTimeout()
+		defer cancel()
+		addresses, err := net.DefaultResolver.LookupHost(ctx, parsed.Hostname())
+		if err != nil {
+			return failed(EXIT_CONNECT, "cannot resolve %s: %v", parsed.Hostname(), err)
+		}
+		return passed("%s resolves to %s", parsed.Hostname(), addresses[0])
+	})
+
+	d.run("token", func() check {
+		ctx, cancel := withTimeout()
+		defer cancel()
+		err := api.CheckAccount(ctx, account.credentials())
+		if errors.Is(err, blinkapi.ErrUnauthorized) {
+			return failed(EXIT_AUTH, "the token was rejected for account %d; run '%s login' again", *account.accountId, programName())
+		}
+		if err != nil {
+			return failed(EXIT_CONNECT, "%v", err)
+		}
+		return passed("accepted by the account endpoint")
+	})
+
+	credentials := account.credentials()
+	if *camera.cameraId == 0 {
+		d.skip("camera", "pass --camera-id to check a camera")
+		d.skip("liveview", "pass --camera-id to check a camera")
+	} else {
+		d.run("camera", func() check {
+			ctx, cancel := withTimeout()
+			defer cancel()
+			homescreen, err := api.GetHomescreenContext(ctx, credentials)
+			if err != nil {
+				return failed(EXIT_CONNECT, "%v", err)
+			}
+			device, err := homescreen.Device(*camera.cameraId, *camera.networkId)
+			if err != nil {
+				return failed(EXIT_USAGE, "%v", err)
+			}
+
+			credentials.NetworkId = device.NetworkId
+			credentials.CameraId = device.Id
+			credentials.DeviceType = *camera.deviceType
+			if credentials.DeviceType == "" {
+				credentials.DeviceType, _ = homescreen.DeviceType(device.Id, device.NetworkId)
+			}
+
+			detail := fmt.Sprintf("%q on network %d, status %s", device.Name, device.NetworkId, device.Status)
+			switch {
+			case device.Status == "offline":
+				return failed(EXIT_CONNECT, "%s", detail)
+			case device.Battery == "low":
+				return warned("%s, battery low", detail)
+			case !device.Enabled:
+				return warned("%s, disabled", detail)
+			}
+			return passed("%s", detail)
+		})
+
+		if *noLiveview {
+			d.skip("liveview", "disabled by --no-liveview")
+		} else {
+			d.run("liveview", func() check {
+				return checkLiveview(api, credentials, *timeout)
+			})
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(d.checks); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+		for _, c := range d.checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Result, c.Detail)
+		}
+		w.Flush()
+	}
+
+	if code := d.exitCode(); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// checkLiveview starts a liveview command, checks that its relay accepts connections,
+// and stops the command right away
+func checkLiveview(api *blinkapi.BlinkAPI, credentials blinkapi.ClientCredentials, timeout time.Duration) check {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := api.InitiateLiveViewContext(ctx, credentials, blinkapi.LiveviewInput{})
+	if err != nil {
+		return failed(EXIT_CONNECT, "%v", err)
+	}
+	defer func() {
+		if err := api.StopCommandContext(context.Background(), credentials, resp.CommandId); err != nil {
+			log.Printf("Error stopping command %d: %v", resp.CommandId, err)
+		}
+	}()
+
+	connection, err := blinkapi.ParseConnectionInfo(resp.Server)
+	if err != nil {
+		return failed(EXIT_CONNECT, "command %d returned an invalid server: %v", resp.CommandId, err)
+	}
+
+	address := connection.Address()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return failed(EXIT_CONNECT, "command %d started, but relay %s is unreachable: %v", resp.CommandId, address, err)
+	}
+	conn.Close()
+
+	return passed("command %d started and stopped, relay %s reachable", resp.CommandId, address)
+}
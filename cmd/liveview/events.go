@@ -0,0 +1,144 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Formats of --log-format
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// EVENT_LOG is the event of plain log messages in the JSON log format
+const EVENT_LOG = "log"
+
+// setLogFormat switches the log to NDJSON on stderr for LOG_FORMAT_JSON. Every line
+// is an object with an "event" field: "log" for plain messages, and "connected",
+// "disconnected", "bytes", "stats", or "error" for the events reported by
+// reportEvents and reportStats.
+//
+// format: the log format (LOG_FORMAT_TEXT or LOG_FORMAT_JSON)
+//
+// Example: setLogFormat("json") = nil
+func setLogFormat(format string) error {
+	switch format {
+	case LOG_FORMAT_TEXT:
+		return nil
+	case LOG_FORMAT_JSON:
+		handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		slog.SetDefault(slog.New(&eventHandler{Handler: handler}))
+		return nil
+	default:
+		return fmt.Errorf("unsupported log format %q (text, json)", format)
+	}
+}
+
+// eventHandler tags records without an event as plain log messages, which covers
+// the lines written through the log package
+type eventHandler struct {
+	slog.Handler
+}
+
+func (h *eventHandler) Handle(ctx context.Context, record slog.Record) error {
+	tagged := false
+	record.Attrs(func(attr slog.Attr) bool {
+		tagged = attr.Key == "event"
+		return !tagged
+	})
+	if !tagged {
+		record.AddAttrs(slog.String("event", EVENT_LOG))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *eventHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *eventHandler) WithGroup(name string) slog.Handler {
+	return &eventHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// reportError logs a stream error, as an "error" event in the JSON log format
+func reportError(format string, err error) {
+	if format != LOG_FORMAT_JSON {
+		log.Println(err)
+		return
+	}
+
+	slog.Error(err.Error(), "event", "error")
+}
+
+// reportStats reports the quality of the livestream as a "stats" event
+func reportStats(stats liveview.Stats) {
+	slog.Info(
+		fmt.Sprintf("Receiving %.0f kbps at %.1f fps", stats.Bitrate/1000, stats.FrameRate),
+		"event", "stats",
+		"kbps", stats.Bitrate/1000,
+		"packets_per_second", stats.PacketRate,
+		"fps", stats.FrameRate,
+		"received_bytes", stats.Bytes,
+		"connected_seconds", stats.Connected.Seconds(),
+	)
+}
+
+// reportEvents reports "connected" and "disconnected" events when the livestream
+// starts and ends, and a "bytes" event with the data written to the outputs every
+// interval, until the context is cancelled
+//
+// ctx: stops the reporting
+//
+// client: the client whose livestream is reported
+//
+// watched: the writer counting the data written to the outputs
+//
+// interval: how often the "bytes" event is reported
+func reportEvents(ctx context.Context, client *liveview.Client, watched *watchedWriter, interval time.Duration) {
+	stateTicker := time.NewTicker(time.Second)
+	defer stateTicker.Stop()
+	bytesTicker := time.NewTicker(interval)
+	defer bytesTicker.Stop()
+
+	connected := false
+	var connectedAt time.Time
+	var reported int64
+	lastReport := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stateTicker.C:
+			// The client stays idle while the stream is received from a shared session
+			streaming := client.IsConnected()
+			switch {
+			case streaming && !connected:
+				connectedAt = time.Now()
+				slog.Info("Livestream connected", "event", "connected")
+			case !streaming && connected:
+				slog.Info("Livestream disconnected", "event", "disconnected", "duration_seconds", time.Since(connectedAt).Seconds())
+			}
+			connected = streaming
+		case <-bytesTicker.C:
+			total := watched.written.Load()
+			elapsed := time.Since(lastReport)
+			bytes := total - reported
+			slog.Info(
+				fmt.Sprintf("Wrote %d bytes to the outputs", bytes),
+				"event", "bytes",
+				"bytes", bytes,
+				"total_bytes", total,
+				"kbps", float64(bytes*8)/elapsed.Seconds()/1000,
+			)
+			reported = total
+			lastReport = time.Now()
+		}
+	}
+}
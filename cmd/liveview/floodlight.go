@@ -0,0 +1,60 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"errors"
+	"log"
+)
+
+// runFloodlight switches the light of a Wired Floodlight Camera or sets its
+// brightness
+func runFloodlight(name string, args []string) {
+	fs := newFlagSet(name, "[flags] [on|off]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	brightness := fs.Int("brightness", 0, "Set the brightness of the light in percent (1 to 100)")
+	fs.Parse(args)
+
+	state := fs.Arg(0)
+	if fs.NArg() > 1 || (state != "" && state != "on" && state != "off") || (state == "" && *brightness == 0) {
+		exit(EXIT_USAGE, "Error: pass on, off, or --brightness")
+	}
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id and --camera-id are required")
+	}
+
+	config := account.clientConfig()
+	config.OnLog = func(string) {}
+	client := liveview.NewClientWithConfig(
+		*account.region,
+		*account.apiToken,
+		*camera.deviceType,
+		*account.accountId,
+		*camera.networkId,
+		*camera.cameraId,
+		config,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), PTZ_TIMEOUT)
+	defer cancel()
+
+	var err error
+	if *brightness != 0 {
+		err = client.SetFloodlightBrightness(ctx, *brightness)
+	}
+	if err == nil && state != "" {
+		err = client.SetFloodlight(ctx, state == "on")
+	}
+	if errors.Is(err, liveview.ErrNoFloodlight) {
+		exit(EXIT_USAGE, "Error: camera %d has no floodlight", *camera.cameraId)
+	}
+	if err != nil {
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+	if state != "" {
+		log.Printf("Switched the floodlight %s", state)
+	}
+}
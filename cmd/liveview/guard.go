@@ -0,0 +1,112 @@
+package main
+
+import (
+	"amattu2/blink-middleware/internal/cli"
+	"amattu2/blink-middleware/pkg/budget"
+	"amattu2/blink-middleware/pkg/guard"
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/output/record"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runGuard records the cameras of the account while they report motion
+func runGuard(name string, args []string) {
+	var networks, cameras cli.ListFlag
+
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	dir := fs.String("dir", "recordings", "Directory to write recordings to, in one subdirectory per camera")
+	duration := fs.Duration("duration", 60*time.Second, "How long to keep recording after the last motion event")
+	interval := fs.Duration("interval", 30*time.Second, "Interval between polls for motion events")
+	dailyBudget := fs.Duration("daily-budget", 0, "Maximum recording time per camera per day (e.g., 30m) to save their batteries; unlimited if omitted")
+	budgetPath := fs.String("budget-file", "", "State file tracking the daily budget (defaults to the user configuration directory)")
+	retain := fs.Duration("retain", 0, "Remove recordings older than this (e.g., 168h); kept regardless of age if omitted")
+	retainSize := fs.String("retain-size", "", "Remove the oldest recordings of each camera beyond this total size (e.g., 50GB); unlimited if omitted")
+	metricsAddr := fs.String("metrics", "", "Serve Prometheus metrics on this address at /metrics (e.g., :9090)")
+	fs.Var(&networks, "network-id", "Only watch this network ID (repeatable)")
+	fs.Var(&cameras, "camera-id", "Only record this camera ID (repeatable)")
+	fs.Parse(args)
+
+	networkIds, err := cli.ParseIds(networks)
+	if err != nil {
+		exit(EXIT_USAGE, "Error: --network-id: %v", err)
+	}
+	cameraIds, err := cli.ParseIds(cameras)
+	if err != nil {
+		exit(EXIT_USAGE, "Error: --camera-id: %v", err)
+	}
+	var maxSize int64
+	if *retainSize != "" {
+		maxSize, err = record.ParseSize(*retainSize)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --retain-size: %v", err)
+		}
+	}
+
+	account.resolve()
+
+	clientConfig := account.clientConfig()
+	if *dailyBudget > 0 {
+		if *budgetPath == "" {
+			*budgetPath, _ = budget.DefaultPath()
+		}
+		clientConfig.Budget, err = budget.New(budget.Config{
+			Limit: *dailyBudget,
+			Path:  *budgetPath,
+			OnLog: func(msg string) {
+				log.Println(msg)
+			},
+		})
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --daily-budget: %v", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var collector metrics.Metrics = metrics.Noop
+	if *metricsAddr != "" {
+		prometheus := metrics.NewPrometheus()
+		collector = prometheus
+		serveMetrics(prometheus, *metricsAddr)
+	}
+
+	// Prune the recordings of every camera in the background
+	if *retain > 0 || maxSize > 0 {
+		retention, err := record.NewRetention(record.RetentionConfig{
+			Dir:     *dir,
+			MaxAge:  *retain,
+			MaxSize: maxSize,
+			Metrics: collector,
+			OnLog: func(msg string) {
+				log.Println(msg)
+			},
+		})
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --retain: %v", err)
+		}
+		go retention.Run(ctx)
+	}
+
+	g := guard.NewGuard(guard.Config{
+		Region:       *account.region,
+		ApiToken:     *account.apiToken,
+		AccountId:    *account.accountId,
+		NetworkIds:   networkIds,
+		CameraIds:    cameraIds,
+		Dir:          *dir,
+		Duration:     *duration,
+		PollInterval: *interval,
+		ClientConfig: clientConfig,
+	})
+
+	if err := g.Run(ctx); err != nil {
+		exit(EXIT_FAILURE, "Error: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/hooks"
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"time"
+)
+
+// runHooks runs the on_connect and on_disconnect hooks when the livestream starts
+// and ends, until the context is cancelled
+//
+// ctx: stops watching the livestream
+//
+// client: the client whose livestream is watched
+//
+// runner: the runner of the hook commands
+//
+// cameraId, networkId: the camera passed to the commands
+func runHooks(ctx context.Context, client *liveview.Client, runner *hooks.Runner, cameraId int, networkId int) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	connected := false
+	var connectedAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The client stays idle while the stream is received from a shared session
+			streaming := client.IsConnected()
+			switch {
+			case streaming && !connected:
+				connectedAt = time.Now()
+				runner.Fire(hooks.Event{Hook: hooks.HOOK_CONNECT, CameraId: cameraId, NetworkId: networkId})
+			case !streaming && connected:
+				runner.Fire(hooks.Event{
+					Hook:      hooks.HOOK_DISCONNECT,
+					CameraId:  cameraId,
+					NetworkId: networkId,
+					Duration:  time.Since(connectedAt).Seconds(),
+				})
+			}
+			connected = streaming
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/credstore"
+	"amattu2/blink-middleware/pkg/liveview"
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runLogin verifies the account credentials against the Blink API and saves them
+// to the encrypted credentials file, so the other commands only need the camera
+func runLogin(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	fs.Parse(args)
+
+	passphrase := os.Getenv(credstore.PASSPHRASE_ENV)
+	if passphrase == "" {
+		log.Fatalf("Error: set $%s to the passphrase encrypting the credentials file", credstore.PASSPHRASE_ENV)
+	}
+	if *account.credentialsPath == "" {
+		*account.credentialsPath, _ = credstore.DefaultPath()
+	}
+
+	// Prompt for what was not passed as flags
+	stdin := bufio.NewReader(os.Stdin)
+	if *account.apiToken == "" {
+		*account.apiToken = prompt(stdin, "API token: ")
+	}
+	if *account.accountId == 0 {
+		id, err := strconv.Atoi(prompt(stdin, "Account ID: "))
+		if err != nil {
+			log.Fatalf("Error: invalid account ID: %v", err)
+		}
+		*account.accountId = id
+	}
+	if *account.apiToken == "" || *account.accountId == 0 {
+		log.Fatal("Error: the API token and account ID are required")
+	}
+
+	// The identity of the client is kept across logins
+	if saved, err := credstore.Load(*account.credentialsPath, passphrase); err == nil {
+		account.fillIdentity(saved)
+	}
+	if *account.uniqueId == "" {
+		id, err := liveview.NewUniqueId()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		*account.uniqueId = id
+	}
+
+	if *account.region == "" {
+		detected, err := account.api().ResolveRegionContext(context.Background(), *account.apiToken, *account.accountId)
+		if err != nil {
+			log.Fatalf("Error: cannot detect the region, pass --region: %v", err)
+		}
+		*account.region = detected
+	}
+
+	homescreen, err := account.api().GetHomescreenContext(context.Background(), account.credentials())
+	if err != nil {
+		log.Fatalf("Error: the credentials were not accepted: %v", err)
+	}
+
+	err = credstore.Save(*account.credentialsPath, passphrase, credstore.Credentials{
+		Region:     *account.region,
+		ApiToken:   *account.apiToken,
+		AccountId:  *account.accountId,
+		ClientId:   account.clientId,
+		UniqueId:   *account.uniqueId,
+		DeviceName: *account.deviceName,
+		AppBuild:   *account.appBuild,
+	})
+	if err != nil {
+		log.Fatalf("Error saving credentials: %v", err)
+	}
+
+	cameras := len(homescreen.Cameras) + len(homescreen.Owls) + len(homescreen.Doorbells)
+	log.Printf("Logged in to account %d in region %s (%d networks, %d cameras)", *account.accountId, *account.region, len(homescreen.Networks), cameras)
+	log.Printf("Saved credentials to %s", *account.credentialsPath)
+}
+
+// prompt asks for a value on the terminal and returns the line entered
+func prompt(stdin *bufio.Reader, label string) string {
+	fmt.Fprint(os.Stderr, label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalf("Error reading input: %v", err)
+	}
+
+	return strings.TrimSpace(line)
+}
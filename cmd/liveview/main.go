@@ -1,74 +1,291 @@
 package main
 
 import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/credstore"
 	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"strings"
 )
 
+// Exit codes distinguishing the failure classes, e.g. for the restart policy of a
+// service
+const (
+	// Any other failure
+	EXIT_FAILURE = 1
+	// Invalid flags or configuration, as for flag parsing errors
+	EXIT_USAGE = 2
+	// Credentials missing, unreadable, or rejected
+	EXIT_AUTH = 3
+	// The livestream could not be established
+	EXIT_CONNECT = 4
+	// An output could not be started, or its reader went away in daemon mode
+	EXIT_OUTPUT = 5
+	// The daily streaming budget of the camera is used up
+	EXIT_BUDGET = 6
+)
+
+// TRACE_STREAM_BYTES is the number of bytes of each stream connection dumped by
+// --trace in each direction
+const TRACE_STREAM_BYTES = 512
+
+// command is a subcommand of the binary
+type command struct {
+	// The name of the command
+	name string
+	// One line description shown in the usage
+	summary string
+	// Runs the command with the arguments following its name
+	run func(name string, args []string)
+}
+
+var commands = []command{
+	{"login", "Verify and save the account credentials", runLogin},
+	{"devices", "List the networks and cameras of the account", runDevices},
+	{"networks", "List the networks of the account with their armed state and sync modules", runNetworks},
+	{"doctor", "Check the credentials, connectivity, and camera, and print a report", runDoctor},
+	{"cleanup", "Stop the liveview commands a crashed run left behind, freeing busy cameras", runCleanup},
+	{"stream", "Stream a camera to a player or other outputs (the default)", runStream},
+	{"record", "Record a camera to rotating MPEG-TS segments or an MP4 file", runStream},
+	{"snapshot", "Save a still image of a camera", runSnapshot},
+	{"timelapse", "Save a still image of a camera every interval", runTimelapse},
+	{"animate", "Save an animated GIF or WebP of a camera or a recording", runAnimate},
+	{"ptz", "Turn a camera on the Mini Pan-Tilt mount or move it to a preset", runPTZ},
+	{"floodlight", "Switch the light of a Wired Floodlight Camera or set its brightness", runFloodlight},
+	{"settings", "Print or change the settings of a camera", runSettings},
+	{"health", "Print the battery, signal, and temperature of a camera", runHealth},
+	{"clips", "List or download the clips stored on the USB drive of a sync module", runClips},
+	{"guard", "Record the cameras of the account while they report motion", runGuard},
+	{"serve", "Serve a camera over RTSP, reconnecting when the stream ends", runStream},
+}
+
 func main() {
-	region := flag.String("region", "", "Blink account region (e.g., u011)")
-	apiToken := flag.String("token", "", "Blink API token")
-	deviceType := flag.String("device-type", "", "Device type (camera, owl, hawk, doorbell, lotus)")
-	accountId := flag.Int("account-id", 0, "Blink account ID")
-	networkId := flag.Int("network-id", 0, "Network ID")
-	cameraId := flag.Int("camera-id", 0, "Camera ID")
-
-	flag.Parse()
-
-	// Validate required flags
-	if *region == "" || *apiToken == "" || *accountId == 0 || *networkId == 0 || *cameraId == 0 {
-		log.Fatal("Error: --region, --token, --account-id, --network-id, and --camera-id are required")
-	}
-
-	// Initialize the client
-	client := liveview.NewClient(
-		*region,
-		*apiToken,
-		*deviceType,
-		*accountId,
-		*networkId,
-		*cameraId,
-	)
-
-	ffplayCmd := exec.Command("ffplay",
-		"-f", "mpegts",
-		"-err_detect", "ignore_err",
-		"-window_title", "Blink Liveview Middleware",
-		"-",
-	)
-	inputPipe, err := ffplayCmd.StdinPipe()
+	// Logs must never be interleaved with the media stream
+	log.SetOutput(os.Stderr)
+
+	args := os.Args[1:]
+
+	// Support the positional form `liveview stdout [flags]` used by exec sources
+	if len(args) > 0 && args[0] == "stdout" {
+		runStream("stream", append([]string{"--output", "stdout"}, args[1:]...))
+		return
+	}
+
+	// Without a command the camera is streamed
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runStream("stream", args)
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(cmd.name, args[1:])
+			return
+		}
+	}
+
+	if args[0] != "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+	usage()
+}
+
+// exit logs the error and exits with the code of its failure class
+func exit(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// usage prints the available commands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", programName())
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-12s%s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n", programName())
+}
+
+// programName returns the name the binary was invoked with
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// newFlagSet returns the flags of a command, printing its usage on errors
+func newFlagSet(name string, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n", programName(), name, arguments)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// accountFlags identify the Blink account
+type accountFlags struct {
+	region          *string
+	apiToken        *string
+	accountId       *int
+	credentialsPath *string
+	apiURL          *string
+	appBuild        *string
+	deviceName      *string
+	uniqueId        *string
+	trace           *bool
+	// The ID of the client the saved token was issued to
+	clientId int
+}
+
+func addAccountFlags(fs *flag.FlagSet) *accountFlags {
+	return &accountFlags{
+		region:          fs.String("region", "", "Blink account region (e.g., u011); detected if omitted"),
+		apiToken:        fs.String("token", "", "Blink API token"),
+		accountId:       fs.Int("account-id", 0, "Blink account ID"),
+		credentialsPath: fs.String("credentials", "", "Encrypted credentials file (defaults to the user configuration directory); the passphrase is read from $BLINK_PASSPHRASE"),
+		apiURL:          fs.String("api-url", "", "Blink API base URL with a %s placeholder for the region, or a full URL for every region (e.g., a debugging proxy)"),
+		appBuild:        fs.String("app-build", "", "App build sent with API requests to identify the client (e.g., ANDROID_28373244); saved by login"),
+		deviceName:      fs.String("device-name", "", "Device name sent with API requests to identify the client (e.g., \"Garage NVR\"); saved by login"),
+		uniqueId:        fs.String("unique-id", "", "Unique client identifier sent with API requests; login generates and saves one if omitted"),
+		trace:           fs.Bool("trace", false, "Log every API request and response with the secrets redacted, and hex dumps of the start of the stream connection, for bug reports"),
+	}
+}
+
+// load fills missing credentials from the credentials file. It reports whether the
+// file was used.
+func (a *accountFlags) load() (bool, error) {
+	if *a.credentialsPath == "" {
+		*a.credentialsPath, _ = credstore.DefaultPath()
+	}
+	if *a.apiToken != "" || *a.credentialsPath == "" {
+		return false, nil
+	}
+
+	creds, err := credstore.Load(*a.credentialsPath, os.Getenv(credstore.PASSPHRASE_ENV))
+	if errors.Is(err, credstore.ErrNotFound) {
+		return false, nil
+	}
 	if err != nil {
-		log.Println("error creating ffplay stdin pipe", err)
+		return false, err
 	}
-	defer inputPipe.Close()
 
-	if err := ffplayCmd.Start(); err != nil {
-		log.Println("error starting ffplay", err)
+	*a.apiToken = creds.ApiToken
+	if *a.region == "" {
+		*a.region = creds.Region
 	}
-	defer ffplayCmd.Process.Kill()
+	if *a.accountId == 0 {
+		*a.accountId = creds.AccountId
+	}
+	a.fillIdentity(creds)
+
+	return true, nil
+}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+// resolve fills missing credentials from the credentials file, requires the token
+// and account ID, and detects the region if it is missing
+func (a *accountFlags) resolve() {
+	if _, err := a.load(); err != nil {
+		exit(EXIT_AUTH, "Error loading credentials: %v", err)
+	}
 
-	go func() {
-		<-sigChan
-		log.Println("Shutdown signal received...")
-		if err := client.Disconnect(); err != nil {
-			log.Printf("Error disconnecting: %v", err)
+	if *a.apiToken == "" || *a.accountId == 0 {
+		exit(EXIT_AUTH, "Error: --token and --account-id are required; run '%s login' to save them", programName())
+	}
+	if *a.region == "" {
+		detected, err := a.api().ResolveRegionContext(context.Background(), *a.apiToken, *a.accountId)
+		if err != nil {
+			exit(EXIT_AUTH, "Error: cannot detect the region, pass --region: %v", err)
 		}
-		os.Exit(0)
-	}()
+		*a.region = detected
+		log.Printf("Detected region %s", *a.region)
+	}
+}
+
+// fillIdentity fills the identity flags that were not passed from the saved
+// credentials
+func (a *accountFlags) fillIdentity(creds credstore.Credentials) {
+	if *a.appBuild == "" {
+		*a.appBuild = creds.AppBuild
+	}
+	if *a.deviceName == "" {
+		*a.deviceName = creds.DeviceName
+	}
+	if *a.uniqueId == "" {
+		*a.uniqueId = creds.UniqueId
+	}
+	a.clientId = creds.ClientId
+}
 
-	// Connect to the livestream
-	if err := client.Connect(inputPipe); err != nil {
-		log.Fatalf("Connection failed: %v", err)
+// identity returns the identification of the client sent with API requests
+func (a *accountFlags) identity() liveview.ClientIdentity {
+	return liveview.ClientIdentity{
+		AppBuild:   *a.appBuild,
+		DeviceName: *a.deviceName,
+		UniqueId:   *a.uniqueId,
+		ClientId:   a.clientId,
 	}
+}
+
+// clientConfig returns the default livestream client configuration with the base
+// URL and identity of the account
+func (a *accountFlags) clientConfig() liveview.ClientConfig {
+	config := liveview.DefaultClientConfig()
+	config.BaseURL = *a.apiURL
+	config.Identity = a.identity()
+	config.Middleware = a.middleware()
+	if *a.trace {
+		config.TraceStream = TRACE_STREAM_BYTES
+	}
+
+	return config
+}
+
+// middleware returns the API middleware of the account flags
+func (a *accountFlags) middleware() []blinkapi.Middleware {
+	if !*a.trace {
+		return nil
+	}
+
+	return []blinkapi.Middleware{blinkapi.TraceRequests(func(trace blinkapi.Trace) {
+		log.Printf("API trace:\n%s", trace)
+	})}
+}
 
-	select {}
+// api returns the Blink API client of the account flags
+func (a *accountFlags) api() *blinkapi.BlinkAPI {
+	return blinkapi.NewBlinkAPI(blinkapi.APIConfig{BaseURL: *a.apiURL, Middleware: a.middleware()})
+}
+
+// credentials returns the resolved account credentials
+func (a *accountFlags) credentials() blinkapi.ClientCredentials {
+	return blinkapi.ClientCredentials{
+		Region:    *a.region,
+		BaseURL:   *a.apiURL,
+		ApiToken:  *a.apiToken,
+		AccountId: *a.accountId,
+		Identity:  a.identity(),
+	}
+}
+
+// cameraFlags identify a camera of the account
+type cameraFlags struct {
+	deviceType *string
+	networkId  *int
+	cameraId   *int
+}
+
+func addCameraFlags(fs *flag.FlagSet) *cameraFlags {
+	return &cameraFlags{
+		deviceType: fs.String("device-type", "", "Device type (camera, owl, hawk, doorbell, lotus); detected automatically if omitted"),
+		networkId:  fs.Int("network-id", 0, "Network ID"),
+		cameraId:   fs.Int("camera-id", 0, "Camera ID"),
+	}
 }
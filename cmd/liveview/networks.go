@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// syncModule is a sync module listed by the networks command
+type syncModule struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Serial   string `json:"serial"`
+	Status   string `json:"status"`
+	Firmware string `json:"firmware"`
+	Wifi     int    `json:"wifi"`
+}
+
+// network is a network listed by the networks command
+type network struct {
+	Id          int          `json:"id"`
+	Name        string       `json:"name"`
+	Armed       bool         `json:"armed"`
+	TimeZone    string       `json:"time_zone,omitempty"`
+	Cameras     int          `json:"cameras"`
+	SyncModules []syncModule `json:"sync_modules"`
+}
+
+// runNetworks lists the networks of the account with their armed state, sync
+// modules, and firmware versions
+func runNetworks(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the networks as JSON")
+	fs.Parse(args)
+
+	account.resolve()
+
+	homescreen, err := account.api().GetHomescreenContext(context.Background(), account.credentials())
+	if err != nil {
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+
+	networks := []network{}
+	for _, n := range homescreen.Networks {
+		listed := network{
+			Id:          n.Id,
+			Name:        n.Name,
+			Armed:       n.Armed,
+			TimeZone:    n.TimeZone,
+			Cameras:     len(homescreen.Devices(n.Id)),
+			SyncModules: []syncModule{},
+		}
+		for _, module := range homescreen.SyncModules {
+			if module.NetworkId != n.Id {
+				continue
+			}
+			listed.SyncModules = append(listed.SyncModules, syncModule{
+				Id:       module.Id,
+				Name:     module.Name,
+				Type:     module.Type,
+				Serial:   module.Serial,
+				Status:   module.Status,
+				Firmware: module.FwVersion,
+				Wifi:     module.WifiStrength,
+			})
+		}
+		networks = append(networks, listed)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(networks); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NETWORK ID\tNETWORK\tARMED\tCAMERAS\tSYNC MODULE ID\tSYNC MODULE\tSERIAL\tSTATUS\tFIRMWARE")
+	for _, n := range networks {
+		armed := "no"
+		if n.Armed {
+			armed = "yes"
+		}
+		if len(n.SyncModules) == 0 {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t-\t-\t-\t-\t-\n", n.Id, n.Name, armed, n.Cameras)
+		}
+		for _, module := range n.SyncModules {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", n.Id, n.Name, armed, n.Cameras, module.Id, module.Name, module.Serial, module.Status, module.Firmware)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PTZ_TIMEOUT bounds the requests of the ptz and floodlight commands
+const PTZ_TIMEOUT = 30 * time.Second
+
+// runPTZ turns a camera on the Mini Pan-Tilt mount, or lists and selects its
+// presets
+func runPTZ(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	move := fs.String("move", "", "Turn the camera one step (left, right, up, down)")
+	preset := fs.String("preset", "", "Turn the camera to a preset saved in the Blink app, by ID or name")
+	listPresets := fs.Bool("presets", false, "List the presets of the camera")
+	fs.Parse(args)
+
+	actions := 0
+	for _, set := range []bool{*move != "", *preset != "", *listPresets} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		exit(EXIT_USAGE, "Error: pass one of --move, --preset, or --presets")
+	}
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id and --camera-id are required")
+	}
+
+	config := account.clientConfig()
+	config.OnLog = func(string) {}
+	client := liveview.NewClientWithConfig(
+		*account.region,
+		*account.apiToken,
+		*camera.deviceType,
+		*account.accountId,
+		*camera.networkId,
+		*camera.cameraId,
+		config,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), PTZ_TIMEOUT)
+	defer cancel()
+
+	var err error
+	switch {
+	case *move != "":
+		err = client.Move(ctx, *move)
+	case *preset != "":
+		err = gotoPreset(ctx, client, *preset)
+	default:
+		var presets []liveview.PTZPreset
+		if presets, err = client.Presets(ctx); err == nil {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME")
+			for _, p := range presets {
+				fmt.Fprintf(w, "%d\t%s\n", p.Id, p.Name)
+			}
+			w.Flush()
+		}
+	}
+	if errors.Is(err, liveview.ErrPTZUnsupported) {
+		exit(EXIT_USAGE, "Error: camera %d has no pan-tilt mount", *camera.cameraId)
+	}
+	if err != nil {
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+}
+
+// gotoPreset turns the camera to a preset given by ID or case-insensitive name
+func gotoPreset(ctx context.Context, client *liveview.Client, preset string) error {
+	if id, err := strconv.Atoi(preset); err == nil {
+		return client.GotoPreset(ctx, id)
+	}
+
+	presets, err := client.Presets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range presets {
+		if strings.EqualFold(p.Name, preset) {
+			return client.GotoPreset(ctx, p.Id)
+		}
+	}
+
+	return fmt.Errorf("camera has no preset %q", preset)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// runSettings prints or changes the settings of a camera
+func runSettings(name string, args []string) {
+	fs := newFlagSet(name, "[flags] [get | set <setting>=<value>...]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the settings as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] [get | set <setting>=<value>...]\n", programName(), name)
+		fmt.Fprintln(fs.Output(), "Settings: motion-sensitivity (1-9), status-led (on, off, auto), video-quality (saver, standard, best), clip-length (5-60 seconds)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	action := fs.Arg(0)
+	if action == "" {
+		action = "get"
+	}
+	if (action != "get" && action != "set") || (action == "get" && fs.NArg() > 1) || (action == "set" && fs.NArg() < 2) {
+		fs.Usage()
+		os.Exit(EXIT_USAGE)
+	}
+
+	// Parse the changes before making any request
+	var update blinkapi.CameraSettingsUpdate
+	if action == "set" {
+		for _, arg := range fs.Args()[1:] {
+			if err := parseSetting(&update, arg); err != nil {
+				exit(EXIT_USAGE, "Error: %v", err)
+			}
+		}
+		if err := update.Validate(); err != nil {
+			exit(EXIT_USAGE, "Error: %v", err)
+		}
+	}
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id and --camera-id are required")
+	}
+
+	api := account.api()
+	cc := account.credentials()
+	cc.DeviceType = *camera.deviceType
+	cc.NetworkId = *camera.networkId
+	cc.CameraId = *camera.cameraId
+
+	if action == "set" {
+		if err := api.UpdateCameraSettingsContext(context.Background(), cc, update); err != nil {
+			exit(EXIT_CONNECT, "Error: %v", err)
+		}
+		log.Printf("Updated the settings of camera %d", *camera.cameraId)
+	}
+
+	settings, err := api.GetCameraSettingsContext(context.Background(), cc)
+	if err != nil {
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(settings)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE")
+	fmt.Fprintf(w, "name\t%s\n", settings.Name)
+	fmt.Fprintf(w, "motion-sensitivity\t%d\n", settings.MotionSensitivity)
+	fmt.Fprintf(w, "status-led\t%s\n", settings.StatusLED)
+	fmt.Fprintf(w, "video-quality\t%s\n", settings.VideoQuality)
+	fmt.Fprintf(w, "clip-length\t%d\n", settings.ClipLength)
+	fmt.Fprintf(w, "motion-alert\t%t\n", settings.MotionAlert)
+	fmt.Fprintf(w, "record-audio\t%t\n", settings.RecordAudio)
+	w.Flush()
+}
+
+// parseSetting sets the field of the update named by a <setting>=<value> argument
+func parseSetting(update *blinkapi.CameraSettingsUpdate, arg string) error {
+	name, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return fmt.Errorf("invalid setting %q, expected <setting>=<value>", arg)
+	}
+
+	switch name {
+	case "motion-sensitivity", "clip-length":
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		if name == "motion-sensitivity" {
+			update.MotionSensitivity = &number
+		} else {
+			update.ClipLength = &number
+		}
+	case "status-led":
+		update.StatusLED = &value
+	case "video-quality":
+		update.VideoQuality = &value
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+
+	return nil
+}
+
+// runHealth prints the battery, signal, and temperature of a camera
+func runHealth(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the health as JSON")
+	fs.Parse(args)
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id and --camera-id are required")
+	}
+
+	config := account.clientConfig()
+	config.OnLog = func(string) {}
+	client := liveview.NewClientWithConfig(
+		*account.region,
+		*account.apiToken,
+		*camera.deviceType,
+		*account.accountId,
+		*camera.networkId,
+		*camera.cameraId,
+		config,
+	)
+	health, err := client.Health()
+	if err != nil {
+		exit(EXIT_CONNECT, "Error: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(health)
+		return
+	}
+
+	battery := health.Battery
+	if battery == "" {
+		battery = "wired"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tVALUE")
+	fmt.Fprintf(w, "battery\t%s (%d/5)\n", battery, health.BatteryBars)
+	if health.BatteryVoltage > 0 {
+		fmt.Fprintf(w, "battery-voltage\t%.2f V\n", health.BatteryVoltage)
+	}
+	fmt.Fprintf(w, "wifi\t%d/5\n", health.WifiBars)
+	if health.WifiStrength != 0 {
+		fmt.Fprintf(w, "wifi-strength\t%d dBm\n", health.WifiStrength)
+	}
+	fmt.Fprintf(w, "sync-module\t%d/5\n", health.LfrBars)
+	if health.LfrStrength != 0 {
+		fmt.Fprintf(w, "sync-module-strength\t%d dBm\n", health.LfrStrength)
+	}
+	fmt.Fprintf(w, "temperature\t%d °F\n", health.Temperature)
+	w.Flush()
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// The time allowed for stopping the stream and closing the outputs
+	shutdownTimeout = 10 * time.Second
+	// The time the player is given to exit after its input is closed
+	playerExitTimeout = 5 * time.Second
+)
+
+// notifyShutdown relays the signals that end the process. SIGHUP is sent when the
+// terminal is closed, and SIGPIPE is captured so that a closed reader surfaces as a
+// write error instead of terminating the process abruptly.
+func notifyShutdown(c chan os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGPIPE)
+}
+
+// isBrokenPipe returns whether the signal reports a closed reader rather than a
+// request to shut down
+func isBrokenPipe(sig os.Signal) bool {
+	return sig == syscall.SIGPIPE
+}
+
+// isReload returns whether the signal requests reloading the configuration, which
+// SIGHUP does in daemon mode
+func isReload(sig os.Signal) bool {
+	return sig == syscall.SIGHUP
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// The time allowed for stopping the stream and closing the outputs. Windows
+	// terminates the process about 5 seconds after the console window is closed
+	shutdownTimeout = 4 * time.Second
+	// The time the player is given to exit after its input is closed
+	playerExitTimeout = 2 * time.Second
+)
+
+// notifyShutdown relays the signals that end the process. Closing the console
+// window, logging off, and shutting down are delivered as SIGTERM.
+func notifyShutdown(c chan os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+// isBrokenPipe returns whether the signal reports a closed reader rather than a
+// request to shut down. Windows reports closed pipes through write errors only.
+func isBrokenPipe(sig os.Signal) bool {
+	return false
+}
+
+// isReload returns whether the signal requests reloading the configuration.
+// Windows has no such signal.
+func isReload(sig os.Signal) bool {
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/mpegts"
+	execOutput "amattu2/blink-middleware/pkg/output/exec"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runSnapshot saves the first frame of the livestream as an image, decoded by
+// ffmpeg. The image format follows the file extension.
+func runSnapshot(name string, args []string) {
+	fs := newFlagSet(name, "[flags] [file]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	ffmpeg := fs.String("ffmpeg", "ffmpeg", "The ffmpeg command decoding the frame")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time to wait for a frame")
+	fs.Parse(args)
+
+	file := "snapshot.jpg"
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		log.Fatal("Error: --network-id and --camera-id are required")
+	}
+
+	config := account.clientConfig()
+	config.Streams = mpegts.STREAMS_VIDEO
+	config.OnLog = func(string) {}
+	client := liveview.NewClientWithConfig(
+		*account.region,
+		*account.apiToken,
+		*camera.deviceType,
+		*account.accountId,
+		*camera.networkId,
+		*camera.cameraId,
+		config,
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	log.Println("Waiting for a frame from the camera...")
+	if err := captureFrame(ctx, client, *ffmpeg, file, *timeout); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	log.Printf("Saved snapshot to %s", file)
+}
+
+// captureFrame streams the camera into ffmpeg until it decoded the first frame into
+// the file, which ends the livestream session
+//
+// ctx: cancels the capture
+//
+// client: the livestream client of the camera
+//
+// ffmpeg: the ffmpeg command decoding the frame
+//
+// file: the image file to write, whose extension selects the format
+//
+// timeout: the maximum time to wait for a frame
+//
+// Example: captureFrame(ctx, client, "ffmpeg", "front.jpg", 30*time.Second) = nil
+func captureFrame(ctx context.Context, client *liveview.Client, ffmpeg string, file string, timeout time.Duration) error {
+	// ffmpeg exits after writing the first frame, which ends the stream
+	decoder, err := execOutput.Start(execOutput.Config{
+		Command:     ffmpeg,
+		Args:        []string{"-loglevel", "error", "-f", "mpegts", "-i", "-", "-frames:v", "1", "-update", "1", "-y", file},
+		MaxRestarts: -1,
+		Output:      os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	streamErr := client.Stream(ctx, decoder)
+	decoder.Close()
+
+	// An existing file is only replaced once ffmpeg decoded a frame
+	if info, err := os.Stat(file); err != nil || info.Size() == 0 || info.ModTime().Before(started) {
+		if streamErr == nil {
+			streamErr = ctx.Err()
+		}
+		return fmt.Errorf("no frame was received: %w", streamErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/output/record"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// STATUS_INTERVAL is how often the status line is redrawn
+	STATUS_INTERVAL = time.Second
+	// STATUS_WINDOW is the period the bitrate of the status line is averaged over
+	STATUS_WINDOW = 5 * time.Second
+	// MAX_STATUS_ERROR is the length the last error is shortened to on the status
+	// line
+	MAX_STATUS_ERROR = 60
+)
+
+// statusLine draws a single line on the terminal with the elapsed time, bytes,
+// and bitrate of the stream and the last error, redrawn in place. Log messages
+// written through it are printed above the line.
+type statusLine struct {
+	// The terminal the line is drawn on
+	out io.Writer
+	// When the status started
+	started time.Time
+	// Guards the fields below
+	mu sync.Mutex
+	// The line currently drawn, empty before the first draw and once stopped
+	line string
+	// Whether the line is no longer drawn
+	stopped bool
+	// The last error of the stream
+	lastErr string
+}
+
+// newStatusLine returns the status line drawn on a terminal
+//
+// out: the terminal, usually os.Stderr
+//
+// Example: newStatusLine(os.Stderr) = &statusLine{...}
+func newStatusLine(out io.Writer) *statusLine {
+	return &statusLine{out: out, started: time.Now()}
+}
+
+// isTerminal reports whether a file is an interactive terminal rather than a
+// pipe or a file
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Write prints a log message above the status line, for log.SetOutput
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clear()
+	n, err := s.out.Write(p)
+	if !s.stopped {
+		io.WriteString(s.out, s.line)
+	}
+
+	return n, err
+}
+
+// setError shows an error of the stream on the status line until the next one
+func (s *statusLine) setError(err error) {
+	message := strings.Join(strings.Fields(err.Error()), " ")
+	if len(message) > MAX_STATUS_ERROR {
+		message = message[:MAX_STATUS_ERROR-3] + "..."
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = message
+}
+
+// run redraws the status line every STATUS_INTERVAL from the bytes written to
+// the outputs until the context is cancelled, then removes it
+//
+// ctx: stops the status line
+//
+// watched: the writer counting the data written to the outputs
+func (s *statusLine) run(ctx context.Context, watched *watchedWriter) {
+	ticker := time.NewTicker(STATUS_INTERVAL)
+	defer ticker.Stop()
+
+	// The byte counts of the last STATUS_WINDOW, oldest first
+	type sample struct {
+		at    time.Time
+		bytes int64
+	}
+	samples := []sample{{time.Now(), watched.written.Load()}}
+	for {
+		select {
+		case <-ctx.Done():
+			s.stop()
+			return
+		case now := <-ticker.C:
+			total := watched.written.Load()
+			samples = append(samples, sample{now, total})
+			for len(samples) > 2 && now.Sub(samples[1].at) >= STATUS_WINDOW {
+				samples = samples[1:]
+			}
+			oldest := samples[0]
+			kbps := float64(total-oldest.bytes) * 8 / now.Sub(oldest.at).Seconds() / 1000
+
+			state := "Streaming"
+			switch {
+			case total == 0:
+				state = "Connecting"
+			case total == oldest.bytes:
+				state = "No data"
+			}
+			s.draw(fmt.Sprintf("%s %s | %s | %.0f kbps", state, formatElapsed(now.Sub(s.started)), record.FormatSize(total), kbps))
+		}
+	}
+}
+
+// draw replaces the status line
+func (s *statusLine) draw(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+	if s.lastErr != "" {
+		status += " | last error: " + s.lastErr
+	}
+	s.clear()
+	s.line = status
+	io.WriteString(s.out, s.line)
+}
+
+// stop removes the status line, after which log messages are printed as is
+func (s *statusLine) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clear()
+	s.line = ""
+	s.stopped = true
+}
+
+// clear erases the status line, with spaces since not every terminal supports
+// the erase sequence
+func (s *statusLine) clear() {
+	if s.line == "" {
+		return
+	}
+	fmt.Fprintf(s.out, "\r%s\r", strings.Repeat(" ", len(s.line)))
+}
+
+// formatElapsed formats a duration as hours, minutes, and seconds
+//
+// d: the duration to format
+//
+// Example: formatElapsed(83 * time.Second) = "00:01:23"
+func formatElapsed(d time.Duration) string {
+	seconds := int(d.Seconds())
+
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,981 @@
+package main
+
+import (
+	"amattu2/blink-middleware/internal/cli"
+	"amattu2/blink-middleware/pkg/broker"
+	"amattu2/blink-middleware/pkg/budget"
+	"amattu2/blink-middleware/pkg/credstore"
+	"amattu2/blink-middleware/pkg/hooks"
+	"amattu2/blink-middleware/pkg/integrations/onvif"
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"amattu2/blink-middleware/pkg/output/buffer"
+	execOutput "amattu2/blink-middleware/pkg/output/exec"
+	"amattu2/blink-middleware/pkg/output/mp4"
+	"amattu2/blink-middleware/pkg/output/namedpipe"
+	"amattu2/blink-middleware/pkg/output/obs"
+	"amattu2/blink-middleware/pkg/output/record"
+	rtmpOutput "amattu2/blink-middleware/pkg/output/rtmp"
+	"amattu2/blink-middleware/pkg/output/rtsp"
+	"amattu2/blink-middleware/pkg/output/socket"
+	"amattu2/blink-middleware/pkg/output/srt"
+	"amattu2/blink-middleware/pkg/output/udp"
+	"amattu2/blink-middleware/pkg/pipeline"
+	"amattu2/blink-middleware/pkg/systemd"
+	"amattu2/blink-middleware/pkg/transcode"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// rtspSink is the stream served by the RTSP server, which is closed with it
+type rtspSink struct {
+	io.Writer
+	server *rtsp.Server
+}
+
+func (r *rtspSink) Close() error {
+	return r.server.Close()
+}
+
+func (r *rtspSink) Discontinuity() {
+	if d, ok := r.Writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// runStream streams a camera to the outputs. The record and serve commands stream
+// to a recording directory and an RTSP server by default.
+//
+// command: the name of the command (stream, record, or serve)
+//
+// args: the command line arguments following the command
+func runStream(command string, args []string) {
+	fs := newFlagSet(command, "[flags]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	var recordDir, serveAddr *string
+	switch command {
+	case "record":
+		recordDir = fs.String("dir", "recordings", "Directory to record rotating MPEG-TS segments to (shorthand for --output record:<dir>); use --output <path>.mp4 for a single MP4 file")
+	case "serve":
+		serveAddr = fs.String("addr", rtsp.DEFAULT_ADDR, "Serve the stream over RTSP on this address (shorthand for --output rtsp:<addr>)")
+	}
+	var outputs, filters, tlsPins cli.ListFlag
+	fs.Var(&outputs, "output", "Stream output, repeatable to feed several outputs (ffplay, stdout, obs[:addr], rtsp[:addr], rtmp://<url>, srt://[host]:port, udp://host:port, record:<dir>, mp4:<path> or <path>.mp4, file:<path>, exec:<command>, stt:<command>, pipe:<name>, unix://<path>); defaults to ffplay")
+	fs.Var(&filters, "filter", "Filter applied to the stream before the outputs, repeatable and applied in order (streams:<audio|video|both>, repair, audio[:aac|opus], bitrate[:interval])")
+	playerCmd := fs.String("player-cmd", "ffplay", "Player command run by the ffplay output (e.g., ffplay, ffmpeg, vlc)")
+	playerArgs := fs.String("player-args", "-f mpegts -err_detect ignore_err -window_title {title} -", "Player arguments; {title} and {camera} are substituted")
+	rtmpUrl := fs.String("rtmp", "", "Publish the stream to this RTMP URL (shorthand for --output rtmp://...)")
+	streamName := fs.String("stream-name", "", "Path of the stream on the RTSP server (e.g. front-door); defaults to camera-<id>")
+	rtspToken := fs.String("rtsp-token", "", "Token required to play the RTSP stream, as the password of any user or the token query parameter")
+	rtspUser := fs.String("rtsp-user", "", "Credentials required to play the RTSP stream as <user>:<password>")
+	onvifAddr := fs.String("onvif", "", "Serve an ONVIF device service on this address (requires the rtsp output)")
+	reconnect := fs.Bool("reconnect", false, "Reconnect automatically when the stream ends (implied by obs, rtmp, and srt)")
+	streams := fs.String("streams", mpegts.STREAMS_BOTH, "Elementary streams to output (audio, video, both)")
+	metricsAddr := fs.String("metrics", "", "Serve Prometheus metrics on this address at /metrics (e.g., :9090)")
+	locale := fs.String("locale", liveview.DefaultClientConfig().Locale, "Locale sent with API requests (e.g., en_US, de_DE)")
+	country := fs.String("country", "", "Optional country code sent with API requests (e.g., US, DE)")
+	timeZone := fs.String("time-zone", "", "Optional IANA time zone sent with API requests (e.g., Europe/Berlin)")
+	saveCredentials := fs.Bool("save-credentials", false, "Save the region, token, and account ID to the credentials file")
+	apiVersions := fs.String("api-versions", "", "API versions to try per endpoint (e.g., camera_liveview=6,5;owl_liveview=3,2)")
+	quality := fs.String("quality", liveview.QUALITY_AUTO, "Requested stream quality (auto, low, high); low reduces the bitrate on constrained networks")
+	upload := fs.String("upload", "", "Archive finalized segments of the record outputs to s3://<bucket>/<prefix>[?region=..&endpoint=..] (credentials from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY) or to a directory")
+	retain := fs.Duration("retain", 0, "Remove recordings of the record outputs older than this (e.g., 168h); kept regardless of age if omitted")
+	retainSize := fs.String("retain-size", "", "Remove the oldest recordings of the record outputs beyond this total size (e.g., 50GB); unlimited if omitted")
+	keepLocal := fs.Bool("keep-local", false, "Keep segments in the recording directory after --upload stored them")
+	duration := fs.Duration("duration", 0, "Stop once the MP4 outputs recorded this much video, or after streaming this long without one (e.g., 60s); unlimited if omitted")
+	maxSession := fs.Duration("max-session", 0, "Maximum livestream session length (e.g., 5m); unlimited if omitted")
+	renewSession := fs.Bool("renew-session", false, "Renew the session behind the same output when --max-session is reached instead of stopping")
+	startupBuffer := fs.Duration("startup-buffer", 0, "Delay the stream by this much (e.g., 2s) to smooth the start of playback; at most 10s")
+	writeBatch := fs.String("write-batch", "", "Coalesce the stream into writes of this size (e.g., 64KB) to the outputs, cutting syscalls when many cameras stream; every read is written right away if omitted")
+	writeBatchInterval := fs.Duration("write-batch-interval", liveview.DEFAULT_WRITE_BATCH_INTERVAL, "Longest time data is held for --write-batch before it is written anyway")
+	dialTimeout := fs.Duration("dial-timeout", 10*time.Second, "Maximum time to connect to the livestream server, trying its IPv6 and IPv4 addresses in parallel")
+	tcpKeepAlive := fs.Duration("tcp-keepalive", 0, "Idle time before each TCP keep-alive probe on the livestream connection (e.g., 5s); 0 uses 15s and -1s disables the probes")
+	tcpKeepAliveCount := fs.Int("tcp-keepalive-count", 0, "Unanswered TCP keep-alive probes before the livestream connection is dropped (0 uses 9)")
+	sourceAddress := fs.String("source-address", "", "Local IP address to connect to the livestream server from, on hosts with several networks")
+	iface := fs.String("interface", "", "Network interface to connect to the livestream server from (e.g., eth1)")
+	fs.Var(&tlsPins, "tls-pin", "Only accept a livestream server presenting a certificate with this SHA-256 fingerprint (as logged on connect), repeatable")
+	tlsMinVersion := fs.String("tls-min-version", "", "Minimum TLS version of the livestream connection (1.2 or 1.3)")
+	tlsServerName := fs.String("tls-server-name", "", "SNI sent to the livestream server instead of its hostname")
+	tlsCert := fs.String("tls-cert", "", "Client certificate file presented to the livestream server, with --tls-key")
+	tlsKey := fs.String("tls-key", "", "Private key file of --tls-cert")
+	pingInterval := fs.Duration("ping-interval", liveview.DEFAULT_PING_INTERVAL, "Interval between keep-alive pings on the livestream connection (250ms to 5s)")
+	rawStream := fs.Bool("raw-stream", false, "Output the undecoded stream including the Blink framing, for debugging")
+	bufferSize := fs.Int("buffer-size", buffer.DEFAULT_SIZE, "Bytes buffered for an output that falls behind the stream; 0 writes to the output directly")
+	overflow := fs.String("overflow", buffer.POLICY_DROP, "What happens when the output buffer is full (drop, disconnect)")
+	dailyBudget := fs.Duration("daily-budget", 0, "Maximum livestream time of the camera per day (e.g., 30m) to save its battery; unlimited if omitted")
+	budgetPolicy := fs.String("budget-policy", budget.POLICY_REFUSE, "What happens when the daily budget is used up (refuse, warn)")
+	shared := fs.Bool("shared", false, "Share the livestream with other processes on this host: the first one owns the Blink session and relays it to the others")
+	sharedAddr := fs.String("shared-addr", "", "Unix socket path or tcp://<host:port> of the shared livestream relay (defaults to a socket per camera in the temporary directory)")
+	daemon := fs.Bool("daemon", false, "Run as a service: reload the credentials file on SIGHUP and exit with a failure code when the outputs go away")
+	pidFile := fs.String("pid-file", "", "Write the process ID to this file while running")
+	logFormat := fs.String("log-format", LOG_FORMAT_TEXT, "Log format (text, json); json writes NDJSON events (connected, disconnected, bytes, stats, error) to stderr")
+	eventInterval := fs.Duration("event-interval", 10*time.Second, "How often the bytes and stats events are written with --log-format json")
+	dryRun := fs.Bool("dry-run", false, "Request a liveview command, print the connection details of its server, and stop it without streaming")
+	sweep := fs.Bool("sweep", false, "Stop the liveview commands of the camera a previous run left running before connecting")
+	hooksPath := fs.String("hooks", "", "Run the on_connect, on_disconnect, and on_error commands configured in this JSON file")
+	quiet := fs.Bool("quiet", false, "Do not show the status line with the elapsed time, bytes, bitrate, and last error of the stream on the terminal")
+	budgetPath := fs.String("budget-file", "", "State file tracking the daily budget (defaults to the user configuration directory)")
+
+	fs.Parse(args)
+
+	if err := setLogFormat(*logFormat); err != nil {
+		exit(EXIT_USAGE, "Error: --log-format: %v", err)
+	}
+	var maxRecordingSize int64
+	if *retainSize != "" {
+		size, err := record.ParseSize(*retainSize)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --retain-size: %v", err)
+		}
+		maxRecordingSize = size
+	}
+	var writeBatchSize int64
+	if *writeBatch != "" {
+		size, err := record.ParseSize(*writeBatch)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --write-batch: %v", err)
+		}
+		writeBatchSize = size
+	}
+	if *pidFile != "" {
+		if err := systemd.WritePIDFile(*pidFile); err != nil {
+			exit(EXIT_FAILURE, "Error: --pid-file: %v", err)
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	switch {
+	case *rtmpUrl != "":
+		outputs = append(outputs, *rtmpUrl)
+	case len(outputs) > 0:
+	case command == "record":
+		outputs = cli.ListFlag{"record:" + *recordDir}
+	case command == "serve":
+		outputs = cli.ListFlag{"rtsp:" + *serveAddr}
+	default:
+		outputs = cli.ListFlag{"ffplay"}
+	}
+	// A server keeps serving after the camera drops the stream
+	if command == "serve" {
+		*reconnect = true
+	}
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id and --camera-id are required")
+	}
+	region, apiToken, accountId, credentialsPath := account.region, account.apiToken, account.accountId, account.credentialsPath
+	deviceType, networkId, cameraId := camera.deviceType, camera.networkId, camera.cameraId
+	if *saveCredentials {
+		err := credstore.Save(*credentialsPath, os.Getenv(credstore.PASSPHRASE_ENV), credstore.Credentials{
+			Region:    *region,
+			ApiToken:  *apiToken,
+			AccountId: *accountId,
+		})
+		if err != nil {
+			exit(EXIT_AUTH, "Error saving credentials: %v", err)
+		}
+		log.Printf("Saved credentials to %s", *credentialsPath)
+	}
+	if _, err := mpegts.NewFilter(io.Discard, *streams); err != nil {
+		exit(EXIT_USAGE, "Error: --streams: %v", err)
+	}
+	switch *quality {
+	case liveview.QUALITY_AUTO, liveview.QUALITY_LOW, liveview.QUALITY_HIGH:
+	default:
+		exit(EXIT_USAGE, "Error: --quality must be auto, low, or high")
+	}
+	if *overflow != buffer.POLICY_DROP && *overflow != buffer.POLICY_DISCONNECT {
+		exit(EXIT_USAGE, "Error: --overflow must be drop or disconnect")
+	}
+	if *sourceAddress != "" && *iface != "" {
+		exit(EXIT_USAGE, "Error: --source-address cannot be combined with --interface")
+	}
+	if *sourceAddress != "" && net.ParseIP(*sourceAddress) == nil {
+		exit(EXIT_USAGE, "Error: --source-address must be an IP address")
+	}
+	tlsConfig := &tls.Config{ServerName: *tlsServerName}
+	if len(tlsPins) > 0 {
+		tlsConfig.VerifyPeerCertificate = liveview.PinCertificates(tlsPins...)
+	}
+	if *tlsMinVersion != "" {
+		version, err := liveview.ParseTLSVersion(*tlsMinVersion)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --tls-min-version: %v", err)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --tls-cert: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	var streamBudget *budget.Budget
+	if *dailyBudget > 0 {
+		if *budgetPath == "" {
+			*budgetPath, _ = budget.DefaultPath()
+		}
+		var err error
+		streamBudget, err = budget.New(budget.Config{
+			Limit:  *dailyBudget,
+			Policy: *budgetPolicy,
+			Path:   *budgetPath,
+			OnLog: func(msg string) {
+				log.Println(msg)
+			},
+		})
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --daily-budget: %v", err)
+		}
+	}
+	versions, err := liveview.ParseAPIVersions(*apiVersions)
+	if err != nil {
+		exit(EXIT_USAGE, "Error: --api-versions: %v", err)
+	}
+
+	// Initialize the client
+	onLog := func(msg string) {
+		log.Println(msg)
+	}
+
+	var collector metrics.Metrics = metrics.Noop
+	if *metricsAddr != "" {
+		prometheus := metrics.NewPrometheus()
+		collector = prometheus
+		serveMetrics(prometheus, *metricsAddr)
+	}
+
+	runner := hooks.New(hooks.Config{OnLog: onLog})
+	if *hooksPath != "" {
+		hooksConfig, err := hooks.Load(*hooksPath)
+		if err != nil {
+			exit(EXIT_USAGE, "Error: --hooks: %v", err)
+		}
+		hooksConfig.OnLog = onLog
+		runner = hooks.New(hooksConfig)
+	}
+
+	// The status line is only drawn for people watching the terminal
+	var status *statusLine
+	if !*quiet && *logFormat == LOG_FORMAT_TEXT && isTerminal(os.Stderr) {
+		status = newStatusLine(os.Stderr)
+		log.SetOutput(status)
+	}
+
+	config := account.clientConfig()
+	config.OnError = func(err error) {
+		if status != nil {
+			status.setError(err)
+		}
+		reportError(*logFormat, err)
+		runner.Fire(hooks.Event{Hook: hooks.HOOK_ERROR, CameraId: *cameraId, NetworkId: *networkId, Error: err.Error()})
+	}
+	config.Metrics = collector
+	config.Streams = *streams
+	config.Locale = *locale
+	config.Country = *country
+	config.TimeZone = *timeZone
+	config.ApiVersions = versions
+	config.Quality = *quality
+	config.RawStream = *rawStream
+	config.PingInterval = *pingInterval
+	config.StartupBuffer = *startupBuffer
+	config.WriteBatchSize = int(writeBatchSize)
+	config.WriteBatchInterval = *writeBatchInterval
+	config.DialTimeout = *dialTimeout
+	config.TCPKeepAliveIdle = *tcpKeepAlive
+	config.TCPKeepAliveInterval = *tcpKeepAlive
+	config.TCPKeepAliveCount = *tcpKeepAliveCount
+	config.SourceAddress = *sourceAddress
+	config.Interface = *iface
+	config.TLSConfig = tlsConfig
+	config.OnTLSHandshake = func(state tls.ConnectionState) {
+		onLog("TLS: " + liveview.DescribeTLS(state))
+	}
+	config.MaxSessionDuration = *maxSession
+	config.Budget = streamBudget
+	if *renewSession {
+		config.SessionLimitPolicy = liveview.RenewAtLimit
+	}
+	if *logFormat == LOG_FORMAT_JSON {
+		config.OnStats = reportStats
+		config.StatsInterval = *eventInterval
+	}
+	client := liveview.NewClientWithConfig(
+		*region,
+		*apiToken,
+		*deviceType,
+		*accountId,
+		*networkId,
+		*cameraId,
+		config,
+	)
+	if *dryRun {
+		printServer(client, *logFormat)
+		return
+	}
+	if *sweep {
+		// Another process sharing the livestream would lose its command
+		if *shared {
+			exit(EXIT_USAGE, "Error: --sweep cannot be combined with --shared")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if _, err := client.Sweep(ctx); err != nil {
+			log.Printf("Error stopping orphaned commands: %v", err)
+		}
+		cancel()
+	}
+
+	// Compose the outputs and filters into a pipeline, so that every output receives
+	// the stream through its own buffer and a slow one does not stall the camera
+	var sinks []pipeline.Sink
+	closeSinks := func() {
+		for _, sink := range sinks {
+			sink.Close()
+		}
+	}
+	serving := false
+	var recordings []*mp4.Writer
+	for _, output := range outputs {
+		if dir, ok := strings.CutPrefix(output, "record:"); ok && (*retain > 0 || maxRecordingSize > 0) {
+			retention, err := record.NewRetention(record.RetentionConfig{
+				Dir:     dir,
+				MaxAge:  *retain,
+				MaxSize: maxRecordingSize,
+				Metrics: collector,
+				OnLog:   onLog,
+			})
+			if err != nil {
+				exit(EXIT_USAGE, "Error: --retain: %v", err)
+			}
+			go retention.Run(context.Background())
+		}
+
+		var sink pipeline.Sink
+		switch {
+		case output == "ffplay":
+			args, err := execOutput.SplitArgs(*playerArgs)
+			if err != nil {
+				exit(EXIT_USAGE, "Error: invalid --player-args: %v", err)
+			}
+
+			player, err := execOutput.Start(execOutput.Config{
+				Command: *playerCmd,
+				Args:    args,
+				Vars: map[string]string{
+					"title":  "Blink Liveview Middleware",
+					"camera": strconv.Itoa(*cameraId),
+				},
+				ExitTimeout: playerExitTimeout,
+				OnLog:       onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting player: %v", err)
+			}
+			sink = pipeline.Named("ffplay", player)
+		case output == "stdout":
+			sink = pipeline.Named("stdout", pipeline.NopCloser(os.Stdout))
+		case strings.HasPrefix(output, "pipe:"):
+			pipe, err := namedpipe.Listen(strings.TrimPrefix(output, "pipe:"), onLog)
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error creating named pipe: %v", err)
+			}
+			log.Printf("Serving stream on %s", pipe.Path())
+			sink = pipeline.Named("pipe", pipe)
+		case strings.HasPrefix(output, "unix://"):
+			sock, err := socket.Listen(socket.Config{
+				Address: strings.TrimPrefix(output, "unix://"),
+				OnLog:   onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error creating Unix socket: %v", err)
+			}
+			log.Printf("Serving stream on %s", sock.Addr())
+			sink = pipeline.Named("unix", sock)
+		case output == "obs" || strings.HasPrefix(output, "obs:"):
+			profile, err := obs.Listen(obs.Config{
+				Addr:    strings.TrimPrefix(strings.TrimPrefix(output, "obs"), ":"),
+				OnLog:   onLog,
+				Metrics: collector,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting OBS output: %v", err)
+			}
+			log.Printf("Add a Media Source in OBS with the input %s", profile.URL())
+			sink = pipeline.Named("obs", profile)
+			*reconnect = true
+		case output == "rtsp" || strings.HasPrefix(output, "rtsp:"):
+			name := strings.Trim(*streamName, "/")
+			if name == "" {
+				name = fmt.Sprintf("camera-%d", *cameraId)
+			}
+			access := rtsp.Access{Token: *rtspToken}
+			if *rtspUser != "" {
+				username, password, ok := strings.Cut(*rtspUser, ":")
+				if !ok || username == "" {
+					exit(EXIT_USAGE, "Error: --rtsp-user must be <user>:<password>")
+				}
+				access.Users = map[string]rtsp.Credentials{name: {Username: username, Password: password}}
+			}
+			server, err := rtsp.ListenWithConfig(rtsp.Config{
+				Addr:    strings.TrimPrefix(strings.TrimPrefix(output, "rtsp"), ":"),
+				OnLog:   onLog,
+				Metrics: collector,
+				Access:  access,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting RTSP server: %v", err)
+			}
+			log.Printf("Serving stream on %s", server.URL(localIP(), name))
+			sink = pipeline.Named("rtsp", &rtspSink{Writer: server.Stream(name), server: server})
+			serving = true
+
+			if *onvifAddr != "" {
+				serveONVIF(server, name, *onvifAddr, onLog)
+			}
+		case strings.HasPrefix(output, "rtmp://") || strings.HasPrefix(output, "rtmps://"):
+			push, err := rtmpOutput.Push(rtmpOutput.Config{
+				URL:   output,
+				OnLog: onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting RTMP output: %v", err)
+			}
+			log.Printf("Publishing stream to %s", push.Server())
+			sink = pipeline.Named("rtmp", push)
+			*reconnect = true
+		case strings.HasPrefix(output, "srt://"):
+			config, err := srt.ParseURL(output)
+			if err != nil {
+				exit(EXIT_USAGE, "Error: %v", err)
+			}
+			config.OnLog = onLog
+
+			out, err := srt.Open(config)
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting SRT output: %v", err)
+			}
+			if config.Mode == srt.MODE_LISTENER {
+				log.Printf("Serving stream over SRT on %s", out.Addr())
+			}
+			sink = pipeline.Named("srt", out)
+			*reconnect = true
+		case strings.HasPrefix(output, "udp://"):
+			config, err := udp.ParseURL(output)
+			if err != nil {
+				exit(EXIT_USAGE, "Error: %v", err)
+			}
+			config.OnLog = onLog
+
+			out, err := udp.Open(config)
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting UDP output: %v", err)
+			}
+			log.Printf("Sending stream over UDP to %s", out.Addr())
+			sink = pipeline.Named("udp", out)
+		case strings.HasPrefix(output, "mp4:") || isMP4Path(output):
+			path := strings.TrimPrefix(output, "mp4:")
+			recording, err := mp4.Create(mp4.Config{
+				Path:     path,
+				Duration: *duration,
+				OnLog:    onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error creating MP4 file: %v", err)
+			}
+			log.Printf("Recording to %s", path)
+			sink = pipeline.Named("mp4", recording)
+			recordings = append(recordings, recording)
+		case strings.HasPrefix(output, "stt:"):
+			args, err := execOutput.SplitArgs(strings.TrimPrefix(output, "stt:"))
+			if err != nil || len(args) == 0 {
+				exit(EXIT_USAGE, "Error: output %q: expecting stt:<command> [args]", output)
+			}
+			speech, err := transcode.NewSpeech(transcode.SpeechConfig{
+				Command: args[0],
+				Args:    args[1:],
+				OnTranscript: func(text string) {
+					runner.Fire(hooks.Event{Hook: hooks.HOOK_TRANSCRIPT, CameraId: *cameraId, NetworkId: *networkId, Transcript: text})
+				},
+				OnLog: onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting speech-to-text: %v", err)
+			}
+			sink = pipeline.Named("stt", speech)
+		case strings.HasPrefix(output, "record:") && *upload != "":
+			backend, err := openBackend(*upload)
+			if err != nil {
+				exit(EXIT_USAGE, "Error: --upload: %v", err)
+			}
+			recorder, err := record.Open(record.Config{
+				Dir:       strings.TrimPrefix(output, "record:"),
+				Backend:   backend,
+				KeepLocal: *keepLocal,
+				OnLog:     onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error starting recording: %v", err)
+			}
+			log.Printf("Archiving segments to %s", *upload)
+			sink = pipeline.Named("record", recorder)
+		default:
+			// Other outputs come from the sinks registered with the pipeline package
+			registered, err := pipeline.OpenSink(output, pipeline.Options{
+				StreamName: fmt.Sprintf("camera-%d", *cameraId),
+				Metrics:    collector,
+				OnLog:      onLog,
+			})
+			if err != nil {
+				exit(EXIT_OUTPUT, "Error: output %q: %v", output, err)
+			}
+			sink = registered
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if *onvifAddr != "" && !serving {
+		closeSinks()
+		exit(EXIT_USAGE, "Error: --onvif requires the rtsp output")
+	}
+
+	var stages []pipeline.Filter
+	for _, spec := range filters {
+		filter, err := pipeline.NewFilter(spec, pipeline.Options{Metrics: collector, OnLog: onLog})
+		if err != nil {
+			closeSinks()
+			exit(EXIT_USAGE, "Error: --filter: %v", err)
+		}
+		stages = append(stages, filter)
+	}
+
+	streamPipeline, err := pipeline.New(pipeline.Config{
+		Filters:    stages,
+		Sinks:      sinks,
+		BufferSize: *bufferSize,
+		Policy:     *overflow,
+		// The player gets its own exit timeout once its buffer is closed
+		FlushTimeout: playerExitTimeout,
+		Metrics:      collector,
+		// The other outputs keep streaming when one fails, e.g. a closed player
+		OnSinkError: func(name string, err error) {
+			runner.Fire(hooks.Event{Hook: hooks.HOOK_ERROR, CameraId: *cameraId, NetworkId: *networkId, Error: fmt.Sprintf("output %s failed: %v", name, err)})
+		},
+		OnLog: onLog,
+	})
+	if err != nil {
+		closeSinks()
+		exit(EXIT_USAGE, "Error: %v", err)
+	}
+
+	// Outputs are closed once the stream has stopped
+	var writer io.Writer = streamPipeline
+	closers := []io.Closer{streamPipeline}
+
+	// Handle graceful shutdown with the signals of the platform
+	sigChan := make(chan os.Signal, 1)
+	notifyShutdown(sigChan)
+
+	// Services are ready once the first media bytes reach the outputs
+	watched := &watchedWriter{writer: writer, failed: make(chan struct{})}
+	watched.onFirstWrite = func() {
+		if err := systemd.Notify(systemd.READY, systemd.Status(fmt.Sprintf("Streaming camera %d", *cameraId))); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Connect to the livestream, or to the process sharing it
+	sharedCtx, cancelShared := context.WithCancel(context.Background())
+	defer cancelShared()
+	if *logFormat == LOG_FORMAT_JSON {
+		go reportEvents(sharedCtx, client, watched, *eventInterval)
+	}
+	if status != nil {
+		go status.run(sharedCtx, watched)
+	}
+	if runner.Has(hooks.HOOK_CONNECT) || runner.Has(hooks.HOOK_DISCONNECT) {
+		go runHooks(sharedCtx, client, runner, *cameraId, *networkId)
+	}
+	if *shared {
+		brokerConfig := broker.Config{
+			Address: broker.DefaultAddress(*cameraId),
+			OnLog:   onLog,
+		}
+		if *sharedAddr != "" {
+			brokerConfig.Address = *sharedAddr
+		}
+		if addr, ok := strings.CutPrefix(brokerConfig.Address, "tcp://"); ok {
+			brokerConfig.Network = broker.NETWORK_TCP
+			brokerConfig.Address = addr
+		}
+		go streamShared(sharedCtx, client, brokerConfig, watched, writer, *reconnect)
+	} else if err := client.Connect(watched); err != nil {
+		// The process exits right away, so the hook runs before it does
+		if err := runner.Run(context.Background(), hooks.Event{Hook: hooks.HOOK_ERROR, CameraId: *cameraId, NetworkId: *networkId, Error: err.Error()}); err != nil {
+			log.Println(err)
+		}
+		if errors.Is(err, budget.ErrExhausted) {
+			exit(EXIT_BUDGET, "Connection failed: %v", err)
+		}
+		exit(EXIT_CONNECT, "Connection failed: %v", err)
+	}
+
+	// Periodically check whether the stream ended and needs to be re-established
+	reconnectTicker := time.NewTicker(5 * time.Second)
+	defer reconnectTicker.Stop()
+
+	// With --duration the stream stops once it is recorded
+	var finished <-chan struct{}
+	if *duration > 0 {
+		finished = durationReached(recordings, *duration)
+	}
+
+	exitCode := 0
+wait:
+	for {
+		select {
+		case <-finished:
+			log.Printf("Recorded %s, stopping", *duration)
+			break wait
+		case <-reconnectTicker.C:
+			if finished != nil && !*reconnect && !*shared && !client.IsConnected() {
+				// The outputs are still closed, which leaves a playable partial MP4
+				log.Printf("Stream ended before reaching --duration %s", *duration)
+				exitCode = EXIT_CONNECT
+				break wait
+			}
+			if !*reconnect || *shared || client.IsConnected() {
+				continue
+			}
+
+			log.Println("Stream ended, reconnecting...")
+			if d, ok := writer.(interface{ Discontinuity() }); ok {
+				d.Discontinuity()
+			}
+			if err := client.Connect(watched); err != nil {
+				if errors.Is(err, budget.ErrExhausted) {
+					log.Printf("Not reconnecting: %v", err)
+					exitCode = EXIT_BUDGET
+					break wait
+				}
+				log.Printf("Reconnect failed: %v", err)
+			}
+		case sig := <-sigChan:
+			if isBrokenPipe(sig) {
+				// Wait for the failed write to be reported
+				continue
+			}
+			if *daemon && isReload(sig) {
+				reloadCredentials(client, *credentialsPath)
+				continue
+			}
+			log.Println("Shutdown signal received...")
+			break wait
+		case <-watched.failed:
+			log.Println("Output closed by reader...")
+			if *daemon {
+				exitCode = EXIT_OUTPUT
+			}
+			break wait
+		}
+	}
+
+	cancelShared()
+	systemd.Notify(systemd.STOPPING)
+	shutdown(client, closers, sigChan)
+
+	if exitCode != 0 {
+		if *pidFile != "" {
+			os.Remove(*pidFile)
+		}
+		os.Exit(exitCode)
+	}
+}
+
+// printServer prints the connection details of a liveview command without
+// streaming it, as JSON with LOG_FORMAT_JSON
+func printServer(client *liveview.Client, format string) {
+	server, err := client.Prepare(context.Background())
+	if server == nil {
+		exit(EXIT_CONNECT, "Connection failed: %v", err)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	if format == LOG_FORMAT_JSON {
+		if err := json.NewEncoder(os.Stdout).Encode(server); err != nil {
+			exit(EXIT_FAILURE, "Error: %v", err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Command ID:\t%d\n", server.CommandId)
+	fmt.Fprintf(w, "Server:\t%s\n", server.Server)
+	fmt.Fprintf(w, "Host:\t%s\n", server.Host)
+	fmt.Fprintf(w, "Port:\t%s\n", server.Port)
+	fmt.Fprintf(w, "Client ID:\t%d\n", server.ClientId)
+	fmt.Fprintf(w, "Connection ID:\t%s\n", server.ConnectionId)
+	fmt.Fprintf(w, "Polling interval:\t%ds\n", server.PollingInterval)
+	w.Flush()
+}
+
+// durationReached returns a channel that is closed once every MP4 recording reached
+// its duration, or after the duration when there is no MP4 output
+//
+// recordings: the MP4 outputs
+//
+// duration: the value of --duration
+//
+// Example: durationReached(nil, time.Minute) = a channel closed after a minute
+func durationReached(recordings []*mp4.Writer, duration time.Duration) <-chan struct{} {
+	reached := make(chan struct{})
+	go func() {
+		defer close(reached)
+
+		if len(recordings) == 0 {
+			time.Sleep(duration)
+			return
+		}
+		for _, recording := range recordings {
+			<-recording.Done()
+		}
+	}()
+
+	return reached
+}
+
+// isMP4Path reports whether an output is the bare path of an MP4 file rather than a
+// URL or a prefixed output such as file:clip.mp4
+//
+// output: the value of --output
+//
+// Example: isMP4Path("clip.mp4") = true
+func isMP4Path(output string) bool {
+	if !strings.EqualFold(filepath.Ext(output), ".mp4") {
+		return false
+	}
+	// A single letter before the colon is a Windows drive
+	prefix, _, found := strings.Cut(output, ":")
+
+	return !found || len(prefix) == 1
+}
+
+// reloadCredentials hands the token and region saved in the credentials file to the
+// running client, e.g. after they were refreshed by another process
+func reloadCredentials(client *liveview.Client, path string) {
+	systemd.Notify(systemd.RELOADING)
+	defer systemd.Notify(systemd.READY)
+
+	creds, err := credstore.Load(path, os.Getenv(credstore.PASSPHRASE_ENV))
+	if err != nil {
+		log.Printf("Error reloading credentials: %v", err)
+		return
+	}
+	if err := client.UpdateCredentials(creds.Region, creds.ApiToken); err != nil {
+		log.Printf("Error reloading credentials: %v", err)
+		return
+	}
+	log.Printf("Reloaded credentials from %s", path)
+}
+
+// streamShared copies the livestream shared through the local broker to the watched
+// writer, opening it again after it ends if reconnect is set
+func streamShared(ctx context.Context, client *liveview.Client, config broker.Config, watched io.Writer, writer io.Writer, reconnect bool) {
+	for {
+		stream, err := broker.Open(ctx, client, config)
+		if err != nil {
+			log.Printf("Error opening the shared livestream: %v", err)
+		} else {
+			if !stream.Owner() {
+				log.Printf("Receiving the livestream from the process sharing it on %s", config.Address)
+			}
+			_, err = io.Copy(watched, stream)
+			stream.Close()
+			if errors.Is(err, budget.ErrExhausted) {
+				log.Printf("Not reconnecting: %v", err)
+				return
+			}
+		}
+
+		if !reconnect {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+
+		log.Println("Stream ended, reconnecting...")
+		if d, ok := writer.(interface{ Discontinuity() }); ok {
+			d.Discontinuity()
+		}
+	}
+}
+
+// shutdown stops the livestream before closing the outputs, so that they flush
+// what they received and players see the end of the stream and exit on their own.
+// The process exits early if this takes longer than shutdownTimeout or another
+// signal is received.
+func shutdown(client *liveview.Client, closers []io.Closer, sigChan chan os.Signal) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		// Wait for the stream to stop writing so the outputs receive all of it
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := client.DisconnectContext(ctx); err != nil {
+			log.Printf("Error disconnecting: %v", err)
+		}
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i].Close(); err != nil {
+				log.Printf("Error closing output: %v", err)
+			}
+		}
+	}()
+
+	timeout := time.After(shutdownTimeout)
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			log.Printf("Shutdown did not complete within %s, exiting", shutdownTimeout)
+			os.Exit(1)
+		case sig := <-sigChan:
+			if isBrokenPipe(sig) {
+				continue
+			}
+			log.Println("Second shutdown signal received, exiting")
+			os.Exit(1)
+		}
+	}
+}
+
+// serveMetrics serves the Prometheus metrics at /metrics
+func serveMetrics(handler http.Handler, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error starting metrics server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	log.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
+
+// serveONVIF exposes the RTSP stream through an ONVIF device service and answers
+// WS-Discovery probes so that NVRs can find it
+func serveONVIF(server *rtsp.Server, name string, addr string, onLog func(string)) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error starting ONVIF service: %v", err)
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	advertised := net.JoinHostPort(localIP(), port)
+	service := onvif.NewService(onvif.Config{
+		RTSP:    server,
+		Cameras: []onvif.Camera{{Name: name}},
+		Addr:    advertised,
+		OnLog:   onLog,
+	})
+	xaddr := fmt.Sprintf("http://%s%s", advertised, onvif.DEVICE_SERVICE_PATH)
+	log.Printf("Serving ONVIF device service on %s", xaddr)
+
+	go func() {
+		if err := http.Serve(listener, service.Handler()); err != nil {
+			log.Printf("ONVIF service stopped: %v", err)
+		}
+	}()
+	go func() {
+		if err := service.ServeDiscovery(context.Background(), xaddr); err != nil {
+			log.Printf("WS-Discovery stopped: %v", err)
+		}
+	}()
+}
+
+// localIP returns the preferred outbound IP address of this host
+func localIP() string {
+	conn, err := net.Dial("udp", onvif.WS_DISCOVERY_ADDR)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// watchedWriter signals when the underlying writer fails, e.g. when the reader
+// on the other end of stdout or a pipe goes away
+type watchedWriter struct {
+	writer io.Writer
+	failed chan struct{}
+	once   sync.Once
+	// The bytes written so far
+	written atomic.Int64
+	// Optional callback run once the first bytes were written
+	onFirstWrite func()
+	first        sync.Once
+}
+
+func (w *watchedWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if w.written.Add(int64(n)) == int64(n) && n > 0 && w.onFirstWrite != nil {
+		w.first.Do(w.onFirstWrite)
+	}
+	if err != nil {
+		w.once.Do(func() {
+			close(w.failed)
+		})
+	}
+
+	return n, err
+}
+
+// openBackend returns the backend archiving recorded segments to an s3:// URL or a
+// directory
+//
+// spec: the --upload value
+//
+// Example: openBackend("s3://recordings/front-door") = &record.S3Backend{...}, nil
+func openBackend(spec string) (record.Backend, error) {
+	if !strings.HasPrefix(spec, "s3://") {
+		return record.DiskBackend{Dir: spec}, nil
+	}
+
+	config, err := record.ParseS3URL(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.NewS3Backend(config)
+}
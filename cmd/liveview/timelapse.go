@@ -0,0 +1,95 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// TIMELAPSE_TIME_FORMAT is the timestamp in the names of timelapse frames, which
+// sorts them in capture order
+const TIMELAPSE_TIME_FORMAT = "20060102-150405"
+
+// runTimelapse saves a frame of the camera every interval. Each frame opens a short
+// livestream session that ends once the frame is decoded, so battery cameras are
+// not kept streaming between frames.
+func runTimelapse(name string, args []string) {
+	fs := newFlagSet(name, "[flags]")
+	account := addAccountFlags(fs)
+	camera := addCameraFlags(fs)
+	dir := fs.String("dir", "timelapse", "Directory the frames are saved to as camera-<id>-<timestamp>.jpg")
+	interval := fs.Duration("interval", 5*time.Minute, "Time between frames (e.g., 10m)")
+	count := fs.Int("count", 0, "Number of frames to capture before exiting; unlimited if omitted")
+	ffmpeg := fs.String("ffmpeg", "ffmpeg", "The ffmpeg command decoding the frames")
+	timeout := fs.Duration("timeout", 30*time.Second, "Maximum time to wait for each frame")
+	fs.Parse(args)
+
+	if *interval <= *timeout {
+		exit(EXIT_USAGE, "Error: --interval must be longer than --timeout (%s)", *timeout)
+	}
+	if *count < 0 {
+		exit(EXIT_USAGE, "Error: --count must not be negative")
+	}
+
+	account.resolve()
+	if *camera.networkId == 0 || *camera.cameraId == 0 {
+		exit(EXIT_USAGE, "Error: --network-id and --camera-id are required")
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		exit(EXIT_OUTPUT, "Error creating the timelapse directory: %v", err)
+	}
+
+	config := account.clientConfig()
+	config.Streams = mpegts.STREAMS_VIDEO
+	config.OnLog = func(string) {}
+	client := liveview.NewClientWithConfig(
+		*account.region,
+		*account.apiToken,
+		*camera.deviceType,
+		*account.accountId,
+		*camera.networkId,
+		*camera.cameraId,
+		config,
+	)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	log.Printf("Capturing a frame every %s to %s", *interval, *dir)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	captured := 0
+	for {
+		file := filepath.Join(*dir, fmt.Sprintf("camera-%d-%s.jpg", *camera.cameraId, time.Now().Format(TIMELAPSE_TIME_FORMAT)))
+		if err := captureFrame(ctx, client, *ffmpeg, file, *timeout); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error capturing a frame, retrying at the next interval: %v", err)
+		} else {
+			captured++
+			log.Printf("Saved frame %d to %s", captured, file)
+			if *count > 0 && captured >= *count {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	log.Printf("Captured %d frames", captured)
+}
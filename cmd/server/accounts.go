@@ -0,0 +1,55 @@
+package main
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/control"
+	"amattu2/blink-middleware/pkg/credstore"
+	"amattu2/blink-middleware/pkg/liveview"
+	"fmt"
+	"log"
+	"os"
+)
+
+// loadAccounts reads the accounts file, fills the missing credentials of each
+// account from its credentials file, and detects the missing regions
+func loadAccounts(path string) ([]control.Account, error) {
+	accounts, err := control.LoadAccounts(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		account := &accounts[i]
+		if account.Credentials != "" {
+			creds, err := credstore.Load(account.Credentials, os.Getenv(credstore.PASSPHRASE_ENV))
+			if err != nil {
+				return nil, fmt.Errorf("account %s: %w", account.Name, err)
+			}
+			if account.ApiToken == "" {
+				account.ApiToken = creds.ApiToken
+			}
+			if account.AccountId == 0 {
+				account.AccountId = creds.AccountId
+			}
+			if account.Region == "" {
+				account.Region = creds.Region
+			}
+			account.Identity = &blinkapi.ClientIdentity{
+				AppBuild:   creds.AppBuild,
+				DeviceName: creds.DeviceName,
+				UniqueId:   creds.UniqueId,
+				ClientId:   creds.ClientId,
+			}
+		}
+		if account.Region == "" && account.ApiToken != "" && account.AccountId != 0 {
+			detected, err := liveview.ResolveRegion(account.ApiToken, account.AccountId)
+			if err != nil {
+				return nil, fmt.Errorf("account %s: cannot detect the region, set it in the file: %w", account.Name, err)
+			}
+			account.Region = detected
+			log.Printf("Detected region %s of account %s", account.Region, account.Name)
+		}
+	}
+
+	return accounts, control.ValidateAccounts(accounts)
+}
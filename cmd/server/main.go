@@ -0,0 +1,412 @@
+package main
+
+import (
+	"amattu2/blink-middleware/internal/cli"
+	"amattu2/blink-middleware/pkg/acme"
+	"amattu2/blink-middleware/pkg/control"
+	"amattu2/blink-middleware/pkg/credstore"
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/notify"
+	"amattu2/blink-middleware/pkg/output/record"
+	"amattu2/blink-middleware/pkg/output/rtsp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TRACE_STREAM_BYTES is the number of bytes of each stream connection dumped by
+// --trace in each direction
+const TRACE_STREAM_BYTES = 512
+
+func main() {
+	var rtspUsers, streamNames, apiKeys, idleTimeouts, alwaysOn, tlsPins cli.ListFlag
+	region := flag.String("region", "", "Blink account region (e.g., u011); detected if omitted")
+	apiToken := flag.String("token", "", "Blink API token")
+	accountId := flag.Int("account-id", 0, "Blink account ID")
+	addr := flag.String("grpc", control.DEFAULT_ADDR, "Serve the gRPC control API on this address")
+	certFile := flag.String("cert", "", "TLS certificate file for the gRPC server; a self-signed certificate is generated if omitted")
+	keyFile := flag.String("key", "", "TLS private key file for the gRPC server")
+	startParallelism := flag.Int("start-parallelism", control.DEFAULT_START_PARALLELISM, "Number of cameras a StartLiveviews call connects at once")
+	healthAddr := flag.String("health", "", "Serve the /healthz and /readyz endpoints over plain HTTP on this address (e.g. :8080)")
+	healthTLS := flag.Bool("health-tls", false, "Serve the --health endpoints and posters over HTTPS with the gRPC certificate")
+	redirectAddr := flag.String("redirect", "", "Redirect plain HTTP on this address (e.g. :80) to the HTTPS health endpoints; defaults to :80 with --acme-domain")
+	acmeDomains := flag.String("acme-domain", "", "Obtain and renew the certificate from Let's Encrypt for these comma-separated domains, instead of --cert and --key")
+	acmeEmail := flag.String("acme-email", "", "Contact email of the ACME account, for expiry notices")
+	acmeDir := flag.String("acme-dir", "", "Directory caching the ACME account key and certificate (defaults to the user configuration directory)")
+	acmeDirectory := flag.String("acme-directory", acme.LETSENCRYPT_URL, "ACME directory URL, e.g. the Let's Encrypt staging directory for testing")
+	ffmpeg := flag.String("ffmpeg", "ffmpeg", "The ffmpeg command encoding the poster images served with --health")
+	probe := flag.String("probe", "", "Request this health URL and exit with status 0 if it succeeds, for container health checks")
+	rtspAddr := flag.String("rtsp", "", "Serve every started livestream over RTSP on this address (e.g. :8554), at a path named after the camera")
+	rtspToken := flag.String("rtsp-token", "", "Token required to play the RTSP streams, as the password of any user or the token query parameter")
+	flag.Var(&rtspUsers, "rtsp-user", "Credentials required to play an RTSP stream as <path>=<user>:<password> (e.g. front-door=viewer:secret), repeatable")
+	flag.Var(&streamNames, "stream-name", "RTSP path of a camera as <camera ID>=<path> (e.g. 11111=front-door), repeatable; defaults to the camera name")
+	flag.Var(&apiKeys, "api-key", "API key required for the gRPC calls and posters as <key>:<role> with the role read or admin, repeatable; only admin keys start and stop livestreams")
+	maxViewers := flag.Int("max-viewers", 0, "Maximum concurrent viewers of a camera, over gRPC and RTSP (0 is unlimited)")
+	maxTotalViewers := flag.Int("max-total-viewers", 0, "Maximum concurrent viewers across every camera (0 is unlimited)")
+	startRate := flag.Float64("start-rate", 0, "Livestreams started per minute across every camera, protecting the Blink API (0 is unlimited)")
+	startBurst := flag.Int("start-burst", control.DEFAULT_START_BURST, "Livestreams started at once before --start-rate applies")
+	cameraStartRate := flag.Float64("camera-start-rate", 0, "Livestreams started per minute for each camera (0 is unlimited)")
+	cameraStartBurst := flag.Int("camera-start-burst", control.DEFAULT_CAMERA_START_BURST, "Livestreams of a camera started at once before --camera-start-rate applies")
+	onDemand := flag.Bool("on-demand", false, "Start the livestream of a camera when a StreamMedia call or RTSP client asks for it, instead of failing")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Stop a livestream after it had no viewers for this long (e.g. 2m); 0 runs it until StopLiveview")
+	flag.Var(&idleTimeouts, "camera-idle-timeout", "Idle timeout of a camera as <camera ID>=<duration> (e.g. 11111=10m), repeatable; 0 runs the camera until StopLiveview")
+	profilesPath := flag.String("profiles", "", "JSON file with the profile of each camera: its outputs, recording retention, quality, and idle timeout")
+	journalPath := flag.String("journal", "", "Journal the liveview commands of the running livestreams to this file, and stop the commands a crash left running on start")
+	resume := flag.Bool("resume", false, "Start the livestreams interrupted by a crash again, as found in --journal")
+	flag.Var(&alwaysOn, "always-on", "Start the livestream of this camera ID when the server starts, repeatable")
+	schedulesPath := flag.String("schedules", "", "JSON file with cron expressions of the minutes each camera streams (and records, per its --profiles), e.g. \"* 8-17 * * mon-fri\"")
+	memoryBudget := flag.String("memory-budget", "", "Memory budget of the server (e.g. 512MB) for running unattended: a soft limit of the Go runtime, smaller buffers per livestream, and a memory report every 10 minutes")
+	notifyPath := flag.String("notify", "", "Send Telegram, Pushover or Slack notifications configured in this JSON file when a livestream fails to start")
+	writeBatch := flag.String("write-batch", "", "Coalesce each livestream into writes of this size (e.g. 64KB) to its viewers and outputs, cutting syscalls when many cameras stream; every read is written right away if omitted")
+	writeBatchInterval := flag.Duration("write-batch-interval", liveview.DEFAULT_WRITE_BATCH_INTERVAL, "Longest time data is held for --write-batch before it is written anyway")
+	dialTimeout := flag.Duration("dial-timeout", 10*time.Second, "Maximum time to connect to a livestream server, trying its IPv6 and IPv4 addresses in parallel")
+	tcpKeepAlive := flag.Duration("tcp-keepalive", 0, "Idle time before each TCP keep-alive probe on the livestream connections (e.g., 5s); 0 uses 15s and -1s disables the probes")
+	tcpKeepAliveCount := flag.Int("tcp-keepalive-count", 0, "Unanswered TCP keep-alive probes before a livestream connection is dropped (0 uses 9)")
+	sourceAddress := flag.String("source-address", "", "Local IP address to connect to the livestream servers from, on hosts with several networks")
+	iface := flag.String("interface", "", "Network interface to connect to the livestream servers from (e.g., eth1)")
+	flag.Var(&tlsPins, "stream-tls-pin", "Only accept livestream servers presenting a certificate with this SHA-256 fingerprint, repeatable")
+	tlsMinVersion := flag.String("stream-tls-min-version", "", "Minimum TLS version of the livestream connections (1.2 or 1.3)")
+	trace := flag.Bool("trace", false, "Log every Blink API request and response with the secrets redacted, and hex dumps of the start of each stream connection, for bug reports")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus metrics on this address at /metrics (e.g., :9090)")
+	accountsPath := flag.String("accounts", "", "JSON file listing additional Blink accounts to serve, whose cameras are namespaced by the account name")
+	credentialsPath := flag.String("credentials", "", "Encrypted credentials file (defaults to the user configuration directory); the passphrase is read from $BLINK_PASSPHRASE")
+
+	flag.Parse()
+
+	log.SetOutput(os.Stderr)
+
+	if *probe != "" {
+		// The probe checks that the server answers, not who it is, so self-signed
+		// certificates and certificates for other names are accepted
+		client := &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+		resp, err := client.Get(*probe)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Error: %s returned HTTP Status Code %d", *probe, resp.StatusCode)
+		}
+		return
+	}
+
+	// Fill missing credentials and the client identity from the credentials file
+	var identity liveview.ClientIdentity
+	if *credentialsPath == "" {
+		*credentialsPath, _ = credstore.DefaultPath()
+	}
+	if *apiToken == "" && *credentialsPath != "" {
+		creds, err := credstore.Load(*credentialsPath, os.Getenv(credstore.PASSPHRASE_ENV))
+		if err != nil && !errors.Is(err, credstore.ErrNotFound) {
+			log.Fatalf("Error loading credentials: %v", err)
+		}
+		if err == nil {
+			*apiToken = creds.ApiToken
+			if *region == "" {
+				*region = creds.Region
+			}
+			if *accountId == 0 {
+				*accountId = creds.AccountId
+			}
+			identity = liveview.ClientIdentity{
+				AppBuild:   creds.AppBuild,
+				DeviceName: creds.DeviceName,
+				UniqueId:   creds.UniqueId,
+				ClientId:   creds.ClientId,
+			}
+		}
+	}
+
+	var accounts []control.Account
+	if *accountsPath != "" {
+		var err error
+		if accounts, err = loadAccounts(*accountsPath); err != nil {
+			log.Fatalf("Error loading accounts: %v", err)
+		}
+	}
+
+	if (*apiToken == "" || *accountId == 0) && len(accounts) == 0 {
+		log.Fatal("Error: --token and --account-id are required")
+	}
+	if *apiToken != "" && *region == "" {
+		detected, err := liveview.ResolveRegion(*apiToken, *accountId)
+		if err != nil {
+			log.Fatalf("Error: cannot detect the region, pass --region: %v", err)
+		}
+		*region = detected
+		log.Printf("Detected region %s", *region)
+	}
+
+	var tlsConfig *tls.Config
+	if *certFile != "" || *keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if *healthTLS && *healthAddr == "" {
+		log.Fatal("Error: --health-tls requires --health")
+	}
+
+	access := rtsp.Access{Token: *rtspToken, Users: map[string]rtsp.Credentials{}}
+	for _, value := range rtspUsers {
+		path, credentials, ok := strings.Cut(value, "=")
+		username, password, ok2 := strings.Cut(credentials, ":")
+		if !ok || !ok2 || path == "" || username == "" {
+			log.Fatalf("Error: --rtsp-user %q must be <path>=<user>:<password>", value)
+		}
+		access.Users[strings.Trim(path, "/")] = rtsp.Credentials{Username: username, Password: password}
+	}
+	names := map[int64]string{}
+	for _, value := range streamNames {
+		id, path, ok := strings.Cut(value, "=")
+		cameraId, err := strconv.ParseInt(id, 10, 64)
+		if !ok || err != nil || strings.Trim(path, "/") == "" {
+			log.Fatalf("Error: --stream-name %q must be <camera ID>=<path>", value)
+		}
+		names[cameraId] = path
+	}
+	timeouts := map[int64]time.Duration{}
+	for _, value := range idleTimeouts {
+		id, duration, ok := strings.Cut(value, "=")
+		cameraId, err := strconv.ParseInt(id, 10, 64)
+		timeout, err2 := time.ParseDuration(duration)
+		if !ok || err != nil || err2 != nil {
+			log.Fatalf("Error: --camera-idle-timeout %q must be <camera ID>=<duration>", value)
+		}
+		timeouts[cameraId] = timeout
+	}
+	var defaultProfile control.Profile
+	var profiles map[int64]control.Profile
+	if *profilesPath != "" {
+		var err error
+		if defaultProfile, profiles, err = control.LoadProfiles(*profilesPath); err != nil {
+			log.Fatalf("Error loading profiles: %v", err)
+		}
+	}
+	var schedules []control.Schedule
+	if *schedulesPath != "" {
+		var err error
+		if schedules, err = control.LoadSchedules(*schedulesPath); err != nil {
+			log.Fatalf("Error loading schedules: %v", err)
+		}
+	}
+	var budget int64
+	if *memoryBudget != "" {
+		var err error
+		if budget, err = record.ParseSize(*memoryBudget); err != nil || budget <= 0 {
+			log.Fatalf("Invalid --memory-budget %q, expecting a size like 512MB", *memoryBudget)
+		}
+	}
+	var writeBatchSize int64
+	if *writeBatch != "" {
+		var err error
+		if writeBatchSize, err = record.ParseSize(*writeBatch); err != nil || writeBatchSize <= 0 {
+			log.Fatalf("Invalid --write-batch %q, expecting a size like 64KB", *writeBatch)
+		}
+	}
+	alwaysOnIds := make([]int64, 0, len(alwaysOn))
+	for _, value := range alwaysOn {
+		cameraId, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("Error: invalid --always-on %q", value)
+		}
+		alwaysOnIds = append(alwaysOnIds, cameraId)
+	}
+	if *resume && *journalPath == "" {
+		log.Fatal("Error: --resume requires --journal")
+	}
+	if *sourceAddress != "" && *iface != "" {
+		log.Fatal("Error: --source-address cannot be combined with --interface")
+	}
+	if *sourceAddress != "" && net.ParseIP(*sourceAddress) == nil {
+		log.Fatal("Error: --source-address must be an IP address")
+	}
+	keys := map[string]string{}
+	for _, value := range apiKeys {
+		i := strings.LastIndex(value, ":")
+		if i <= 0 {
+			log.Fatalf("Error: --api-key must be <key>:<role>")
+		}
+		role, err := control.ParseRole(value[i+1:])
+		if err != nil {
+			log.Fatalf("Error: --api-key: %v", err)
+		}
+		keys[value[:i]] = role
+	}
+
+	clientConfig := liveview.DefaultClientConfig()
+	clientConfig.Identity = identity
+	clientConfig.DialTimeout = *dialTimeout
+	clientConfig.WriteBatchSize = int(writeBatchSize)
+	clientConfig.WriteBatchInterval = *writeBatchInterval
+	clientConfig.TCPKeepAliveIdle = *tcpKeepAlive
+	clientConfig.TCPKeepAliveInterval = *tcpKeepAlive
+	clientConfig.TCPKeepAliveCount = *tcpKeepAliveCount
+	clientConfig.SourceAddress = *sourceAddress
+	clientConfig.Interface = *iface
+	if len(tlsPins) > 0 || *tlsMinVersion != "" {
+		clientConfig.TLSConfig = &tls.Config{}
+		if len(tlsPins) > 0 {
+			clientConfig.TLSConfig.VerifyPeerCertificate = liveview.PinCertificates(tlsPins...)
+		}
+		if *tlsMinVersion != "" {
+			version, err := liveview.ParseTLSVersion(*tlsMinVersion)
+			if err != nil {
+				log.Fatalf("Error: --stream-tls-min-version: %v", err)
+			}
+			clientConfig.TLSConfig.MinVersion = version
+		}
+	}
+	if *trace {
+		clientConfig.Middleware = append(clientConfig.Middleware, liveview.TraceRequests(func(trace liveview.APITrace) {
+			log.Printf("API trace:\n%s", trace)
+		}))
+		clientConfig.TraceStream = TRACE_STREAM_BYTES
+	}
+	if *metricsAddr != "" {
+		prometheus := metrics.NewPrometheus()
+		clientConfig.Metrics = prometheus
+		serveMetrics(prometheus, *metricsAddr)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// ACME certificates are served to both the gRPC and HTTPS clients, and are
+	// validated through the challenges of the redirect server
+	var challenges http.Handler
+	if *acmeDomains != "" {
+		if tlsConfig != nil {
+			log.Fatal("Error: --acme-domain cannot be combined with --cert and --key")
+		}
+		if *acmeDir == "" {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				log.Fatalf("Error: pass --acme-dir: %v", err)
+			}
+			*acmeDir = filepath.Join(dir, "blink-middleware", "acme")
+		}
+		manager, err := acme.New(acme.Config{
+			Domains:      strings.Split(*acmeDomains, ","),
+			Email:        *acmeEmail,
+			CacheDir:     *acmeDir,
+			DirectoryURL: *acmeDirectory,
+			OnLog: func(msg string) {
+				log.Println(msg)
+			},
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		go manager.Run(ctx)
+		tlsConfig = manager.TLSConfig()
+		challenges = manager
+		if *redirectAddr == "" {
+			*redirectAddr = ":80"
+		}
+	}
+
+	var dispatcher *notify.Dispatcher
+	if *notifyPath != "" {
+		notifyConfig, err := notify.Load(*notifyPath)
+		if err != nil {
+			log.Fatalf("Error loading notifications: %v", err)
+		}
+		notifyConfig.OnLog = func(msg string) {
+			log.Println(msg)
+		}
+		dispatcher = notify.New(notifyConfig)
+	}
+
+	server := control.NewServer(control.Config{
+		Addr:             *addr,
+		Region:           *region,
+		ApiToken:         *apiToken,
+		AccountId:        *accountId,
+		Accounts:         accounts,
+		ClientConfig:     clientConfig,
+		StartParallelism: *startParallelism,
+		TLSConfig:        tlsConfig,
+		HealthAddr:       *healthAddr,
+		HealthTLS:        *healthTLS,
+		RedirectAddr:     *redirectAddr,
+		Challenges:       challenges,
+		FFmpeg:           *ffmpeg,
+		RTSPAddr:         *rtspAddr,
+		RTSPAccess:       access,
+		StreamNames:      names,
+		APIKeys:          keys,
+		Limits: control.Limits{
+			MaxViewers:            *maxViewers,
+			MaxTotalViewers:       *maxTotalViewers,
+			StartsPerMinute:       *startRate,
+			StartBurst:            *startBurst,
+			CameraStartsPerMinute: *cameraStartRate,
+			CameraStartBurst:      *cameraStartBurst,
+		},
+		OnDemand:       *onDemand,
+		IdleTimeout:    *idleTimeout,
+		IdleTimeouts:   timeouts,
+		Profiles:       profiles,
+		DefaultProfile: defaultProfile,
+		JournalPath:    *journalPath,
+		Resume:         *resume,
+		AlwaysOn:       alwaysOnIds,
+		Schedules:      schedules,
+		MemoryBudget:   budget,
+		OnStartError: func(cameraId int64, networkId int64, err error) {
+			if dispatcher == nil {
+				return
+			}
+
+			go func() {
+				event := notify.Event{Trigger: notify.TRIGGER_STREAM_FAILED, CameraId: int(cameraId), NetworkId: int(networkId), Detail: err.Error()}
+				if err := dispatcher.Dispatch(ctx, event); err != nil {
+					log.Println(err)
+				}
+			}()
+		},
+		OnLog: func(msg string) {
+			log.Println(msg)
+		},
+	})
+
+	if err := server.Run(ctx); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// serveMetrics serves the Prometheus metrics at /metrics
+func serveMetrics(handler http.Handler, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error starting metrics server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	log.Printf("Serving metrics on http://%s/metrics", listener.Addr())
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("Metrics server stopped: %v", err)
+		}
+	}()
+}
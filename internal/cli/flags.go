@@ -0,0 +1,37 @@
+// Package cli holds the flag helpers shared by the binaries of cmd.
+package cli
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ListFlag collects the values of a flag that may be repeated
+type ListFlag []string
+
+func (l *ListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *ListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// ParseIds parses the values of a repeated ID flag
+//
+// values: the values of the flag
+//
+// Example: ParseIds([]string{"123", "456"}) = []int{123, 456}, nil
+func ParseIds(values []string) ([]int, error) {
+	ids := make([]int, 0, len(values))
+	for _, value := range values {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
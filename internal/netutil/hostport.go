@@ -0,0 +1,21 @@
+package netutil
+
+import (
+	"net"
+	"net/url"
+)
+
+// HostPort returns the host:port of the URL, using the default port if none is set
+//
+// u: the URL to connect to
+//
+// defaultPort: the port used when the URL has none
+//
+// Example: HostPort(&url.URL{Host: "localhost"}, "1883") = "localhost:1883"
+func HostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	return u.Host
+}
@@ -0,0 +1,201 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// AMF0 type markers
+const (
+	amfNumber      byte = 0x00
+	amfBoolean     byte = 0x01
+	amfString      byte = 0x02
+	amfObject      byte = 0x03
+	amfNull        byte = 0x05
+	amfUndefined   byte = 0x06
+	amfEcmaArray   byte = 0x08
+	amfObjectEnd   byte = 0x09
+	amfStrictArray byte = 0x0a
+)
+
+// Object is an AMF0 object. Keys are encoded in sorted order.
+type Object map[string]any
+
+// EcmaArray is an AMF0 associative array, as used by onMetaData.
+type EcmaArray map[string]any
+
+// EncodeAMF encodes the values as a sequence of AMF0 values
+//
+// values: the values to encode (float64, int, bool, string, Object, EcmaArray or nil)
+//
+// Example: EncodeAMF("_result", 1, nil) = []byte{0x02, ...}
+func EncodeAMF(values ...any) []byte {
+	var buf []byte
+	for _, value := range values {
+		buf = appendAMF(buf, value)
+	}
+
+	return buf
+}
+
+func appendAMF(buf []byte, value any) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, amfNull)
+	case bool:
+		if v {
+			return append(buf, amfBoolean, 0x01)
+		}
+		return append(buf, amfBoolean, 0x00)
+	case int:
+		return appendAMF(buf, float64(v))
+	case float64:
+		buf = append(buf, amfNumber)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+	case string:
+		buf = append(buf, amfString)
+		return appendAMFString(buf, v)
+	case Object:
+		buf = append(buf, amfObject)
+		return appendAMFProperties(buf, v)
+	case EcmaArray:
+		buf = append(buf, amfEcmaArray)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		return appendAMFProperties(buf, v)
+	default:
+		return append(buf, amfUndefined)
+	}
+}
+
+func appendAMFString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func appendAMFProperties(buf []byte, properties map[string]any) []byte {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		buf = appendAMFString(buf, key)
+		buf = appendAMF(buf, properties[key])
+	}
+
+	return append(buf, 0x00, 0x00, amfObjectEnd)
+}
+
+// DecodeAMF decodes a sequence of AMF0 values
+//
+// data: the encoded values
+//
+// Example: DecodeAMF([]byte{0x02, 0x00, 0x04, 'p', 'l', 'a', 'y'}) = []any{"play"}, nil
+func DecodeAMF(data []byte) ([]any, error) {
+	var values []any
+	for len(data) > 0 {
+		value, n, err := decodeAMF(data)
+		if err != nil {
+			return values, err
+		}
+
+		values = append(values, value)
+		data = data[n:]
+	}
+
+	return values, nil
+}
+
+var errAMFTruncated = errors.New("truncated AMF data")
+
+func decodeAMF(data []byte) (any, int, error) {
+	if len(data) == 0 {
+		return nil, 0, errAMFTruncated
+	}
+
+	switch data[0] {
+	case amfNumber:
+		if len(data) < 9 {
+			return nil, 0, errAMFTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 9, nil
+	case amfBoolean:
+		if len(data) < 2 {
+			return nil, 0, errAMFTruncated
+		}
+		return data[1] != 0, 2, nil
+	case amfString:
+		s, n, err := decodeAMFString(data[1:])
+		return s, 1 + n, err
+	case amfNull, amfUndefined:
+		return nil, 1, nil
+	case amfObject:
+		properties, n, err := decodeAMFProperties(data[1:])
+		return Object(properties), 1 + n, err
+	case amfEcmaArray:
+		if len(data) < 5 {
+			return nil, 0, errAMFTruncated
+		}
+		properties, n, err := decodeAMFProperties(data[5:])
+		return EcmaArray(properties), 5 + n, err
+	case amfStrictArray:
+		if len(data) < 5 {
+			return nil, 0, errAMFTruncated
+		}
+		count := int(binary.BigEndian.Uint32(data[1:]))
+		offset := 5
+		values := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			value, n, err := decodeAMF(data[offset:])
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset += n
+		}
+		return values, offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported AMF type 0x%02x", data[0])
+	}
+}
+
+func decodeAMFString(data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, errAMFTruncated
+	}
+
+	length := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+length {
+		return "", 0, errAMFTruncated
+	}
+
+	return string(data[2 : 2+length]), 2 + length, nil
+}
+
+func decodeAMFProperties(data []byte) (map[string]any, int, error) {
+	properties := map[string]any{}
+	offset := 0
+	for {
+		if len(data[offset:]) >= 3 && data[offset] == 0 && data[offset+1] == 0 && data[offset+2] == amfObjectEnd {
+			return properties, offset + 3, nil
+		}
+
+		key, n, err := decodeAMFString(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+
+		value, n, err := decodeAMF(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += n
+
+		properties[key] = value
+	}
+}
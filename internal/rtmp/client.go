@@ -0,0 +1,365 @@
+package rtmp
+
+import (
+	"amattu2/blink-middleware/internal/netutil"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PUBLISH_QUEUE_SIZE is the number of media messages buffered for a publisher
+const PUBLISH_QUEUE_SIZE = 1024
+
+// PUBLISH_CLOSE_TIMEOUT is how long Close waits for the queued messages to be sent
+const PUBLISH_CLOSE_TIMEOUT = 2 * time.Second
+
+// Publisher publishes a live stream to a remote RTMP server
+type Publisher struct {
+	conn     *Conn
+	streamId uint32
+	key      string
+	queue    chan Message
+	done     chan struct{}
+	// Closed when the writer goroutine exits
+	written chan struct{}
+
+	mu  sync.Mutex
+	err error
+	// Whether video is dropped until the next keyframe
+	waitKeyframe bool
+	closed       bool
+}
+
+// Publish connects to the RTMP URL and starts publishing a live stream. The last
+// path segment of the URL is the stream key; everything before it is the app.
+//
+// rawUrl: the rtmp:// or rtmps:// URL to publish to
+//
+// timeout: the maximum time to wait for the connection and publish handshake
+//
+// Example: Publish("rtmp://localhost/live/blink", 10*time.Second) = &Publisher{...}, nil
+func Publish(rawUrl string, timeout time.Duration) (*Publisher, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RTMP URL: %w", err)
+	}
+
+	index := strings.LastIndex(u.Path, "/")
+	if index <= 0 || index == len(u.Path)-1 {
+		return nil, fmt.Errorf("RTMP URL %q must include an app and a stream key", rawUrl)
+	}
+	app := strings.TrimPrefix(u.Path[:index], "/")
+	key := u.Path[index+1:]
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+
+	var raw net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "rtmp":
+		raw, err = dialer.Dial("tcp", netutil.HostPort(u, "1935"))
+	case "rtmps":
+		raw, err = tls.DialWithDialer(dialer, "tcp", netutil.HostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported RTMP scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", u.Host, err)
+	}
+
+	p := &Publisher{
+		conn:         newConn(raw),
+		key:          key,
+		queue:        make(chan Message, PUBLISH_QUEUE_SIZE),
+		done:         make(chan struct{}),
+		written:      make(chan struct{}),
+		waitKeyframe: true,
+	}
+
+	raw.SetDeadline(time.Now().Add(timeout))
+	if err := p.handshake(app, fmt.Sprintf("%s://%s/%s", u.Scheme, u.Host, app)); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+
+	go p.read()
+	go p.write()
+
+	return p, nil
+}
+
+// handshake performs the connect, createStream and publish command exchange
+func (p *Publisher) handshake(app string, tcUrl string) error {
+	if err := p.conn.clientHandshake(); err != nil {
+		return err
+	}
+	if err := p.conn.SetChunkSize(outChunkSize); err != nil {
+		return err
+	}
+
+	if err := p.conn.WriteCommand(0, "connect", 1, Object{
+		"app":      app,
+		"type":     "nonprivate",
+		"flashVer": "FMLE/3.0 (compatible; blink-middleware)",
+		"tcUrl":    tcUrl,
+	}); err != nil {
+		return err
+	}
+	if _, err := p.awaitResult(1); err != nil {
+		return fmt.Errorf("connect rejected: %w", err)
+	}
+
+	// releaseStream and FCPublish are expected by nginx-rtmp and most CDNs
+	p.conn.WriteCommand(0, "releaseStream", 2, nil, p.key)
+	p.conn.WriteCommand(0, "FCPublish", 3, nil, p.key)
+	if err := p.conn.WriteCommand(0, "createStream", 4, nil); err != nil {
+		return err
+	}
+	args, err := p.awaitResult(4)
+	if err != nil {
+		return fmt.Errorf("createStream rejected: %w", err)
+	}
+	if len(args) > 1 {
+		if id, ok := args[1].(float64); ok {
+			p.streamId = uint32(id)
+		}
+	}
+	if p.streamId == 0 {
+		return errors.New("createStream did not return a stream ID")
+	}
+
+	if err := p.conn.WriteCommand(p.streamId, "publish", 5, nil, p.key, "live"); err != nil {
+		return err
+	}
+	for {
+		msg, err := p.conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("error awaiting publish status: %w", err)
+		}
+		if msg.Type != MSG_COMMAND_AMF0 && msg.Type != MSG_COMMAND_AMF3 {
+			continue
+		}
+
+		name, _, args, err := ParseCommand(msg)
+		if err != nil || name != "onStatus" || len(args) < 2 {
+			continue
+		}
+
+		info, _ := args[1].(Object)
+		code, _ := info["code"].(string)
+		switch {
+		case code == "NetStream.Publish.Start":
+			return p.conn.WriteMessage(CSID_COMMAND, Message{
+				Type:     MSG_DATA_AMF0,
+				StreamId: p.streamId,
+				Payload: EncodeAMF("@setDataFrame", "onMetaData", EcmaArray{
+					"videocodecid": int(flvCodecAVC),
+					"audiocodecid": int(flvFormatAAC),
+					"encoder":      "blink-middleware",
+				}),
+			})
+		case info["level"] == "error":
+			return fmt.Errorf("publish rejected: %s", code)
+		}
+	}
+}
+
+// awaitResult waits for the _result or _error response to the given transaction
+func (p *Publisher) awaitResult(txId float64) ([]any, error) {
+	for {
+		msg, err := p.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Type != MSG_COMMAND_AMF0 && msg.Type != MSG_COMMAND_AMF3 {
+			continue
+		}
+
+		name, id, args, err := ParseCommand(msg)
+		if err != nil || id != txId {
+			continue
+		}
+
+		switch name {
+		case "_result":
+			return args, nil
+		case "_error":
+			if len(args) > 1 {
+				if info, ok := args[1].(Object); ok {
+					return nil, fmt.Errorf("%v", info["code"])
+				}
+			}
+			return nil, errors.New("server returned an error")
+		}
+	}
+}
+
+// Send queues media messages for publishing without blocking. When the server
+// cannot keep up, the backlog is dropped up to its next keyframe. Returns the
+// number of messages dropped.
+func (p *Publisher) Send(messages ...Message) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0
+	}
+
+	dropped := 0
+	for _, msg := range messages {
+		video := msg.Type == MSG_VIDEO && !IsSequenceHeader(msg)
+		if video {
+			if p.waitKeyframe && !IsKeyframe(msg) {
+				continue
+			}
+			p.waitKeyframe = false
+		}
+
+		select {
+		case p.queue <- msg:
+			continue
+		default:
+		}
+
+		// Only Send fills the queue, so it has room once the backlog is dropped
+		dropped += p.dropToKeyframe()
+		if video && p.waitKeyframe && !IsKeyframe(msg) {
+			dropped++
+			continue
+		}
+		p.waitKeyframe = false
+		p.queue <- msg
+	}
+
+	return dropped
+}
+
+// dropToKeyframe drops the queued messages before the next video keyframe after
+// the first message, so the server resumes with a decodable picture. Without one,
+// the whole queue is dropped and video waits for the next keyframe. p.mu must be
+// held.
+func (p *Publisher) dropToKeyframe() int {
+	dropped := 0
+	var kept []Message
+	for len(p.queue) > 0 {
+		msg := <-p.queue
+		if kept == nil && (dropped == 0 || msg.Type != MSG_VIDEO || !IsKeyframe(msg)) {
+			dropped++
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	for _, msg := range kept {
+		p.queue <- msg
+	}
+	if kept == nil {
+		p.waitKeyframe = true
+	}
+
+	return dropped
+}
+
+// Done returns a channel that is closed when the publisher stops
+func (p *Publisher) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the error that stopped the publisher, if any
+func (p *Publisher) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Close unpublishes the stream and closes the connection
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	// Let the writer flush the queue before unpublishing
+	select {
+	case <-p.written:
+		p.conn.WriteCommand(0, "FCUnpublish", 6, nil, p.key)
+		p.conn.WriteCommand(0, "deleteStream", 7, nil, float64(p.streamId))
+	case <-time.After(PUBLISH_CLOSE_TIMEOUT):
+		// The writer is still blocked on the connection, which closing unblocks.
+		// Unpublishing would interleave the commands with its message.
+	}
+
+	return p.conn.Close()
+}
+
+// read consumes server messages so that acknowledgements are sent and a closed
+// connection is detected
+func (p *Publisher) read() {
+	for {
+		msg, err := p.conn.ReadMessage()
+		if err != nil {
+			p.stop(err)
+			p.conn.Close()
+			return
+		}
+		if msg.Type != MSG_COMMAND_AMF0 && msg.Type != MSG_COMMAND_AMF3 {
+			continue
+		}
+
+		name, _, args, err := ParseCommand(msg)
+		if err != nil || name != "onStatus" || len(args) < 2 {
+			continue
+		}
+		if info, ok := args[1].(Object); ok && info["level"] == "error" {
+			p.stop(fmt.Errorf("server stopped the stream: %v", info["code"]))
+			p.conn.Close()
+			return
+		}
+	}
+}
+
+func (p *Publisher) write() {
+	defer close(p.written)
+
+	for msg := range p.queue {
+		msg.StreamId = p.streamId
+		csid := CSID_VIDEO
+		if msg.Type == MSG_AUDIO {
+			csid = CSID_AUDIO
+		}
+
+		if err := p.conn.WriteMessage(csid, msg); err != nil {
+			p.stop(err)
+			p.conn.Close()
+			break
+		}
+	}
+
+	p.stop(nil)
+}
+
+// stop records the first error and signals that the publisher stopped
+func (p *Publisher) stop(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	p.err = err
+	close(p.done)
+}
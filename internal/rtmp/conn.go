@@ -0,0 +1,401 @@
+// Package rtmp implements the subset of the RTMP protocol needed to serve and
+// publish FLV-wrapped H.264/AAC streams.
+package rtmp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// RTMP message type IDs
+const (
+	MSG_SET_CHUNK_SIZE     byte = 1
+	MSG_ABORT              byte = 2
+	MSG_ACKNOWLEDGEMENT    byte = 3
+	MSG_USER_CONTROL       byte = 4
+	MSG_WINDOW_ACK_SIZE    byte = 5
+	MSG_SET_PEER_BANDWIDTH byte = 6
+	MSG_AUDIO              byte = 8
+	MSG_VIDEO              byte = 9
+	MSG_DATA_AMF3          byte = 15
+	MSG_COMMAND_AMF3       byte = 17
+	MSG_DATA_AMF0          byte = 18
+	MSG_COMMAND_AMF0       byte = 20
+)
+
+// Chunk stream IDs used for outgoing messages
+const (
+	CSID_CONTROL uint32 = 2
+	CSID_COMMAND uint32 = 3
+	CSID_AUDIO   uint32 = 4
+	CSID_VIDEO   uint32 = 6
+)
+
+const (
+	handshakeSize    = 1536
+	defaultChunkSize = 128
+	outChunkSize     = 4096
+	maxMessageSize   = 16 * 1024 * 1024
+)
+
+// Message is a complete RTMP message
+type Message struct {
+	// The message type ID
+	Type byte
+	// The message stream ID
+	StreamId uint32
+	// The message timestamp in milliseconds
+	Timestamp uint32
+	// The message payload
+	Payload []byte
+}
+
+type chunkStream struct {
+	header    Message
+	length    int
+	delta     uint32
+	extended  bool
+	payload   []byte
+	inMessage bool
+}
+
+// Conn is an RTMP connection that reads and writes chunked messages
+type Conn struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	writeMu       sync.Mutex
+	inChunkSize   int
+	outChunkSize  int
+	inStreams     map[uint32]*chunkStream
+	bytesRead     uint32
+	ackWindow     uint32
+	lastAckedRead uint32
+}
+
+func newConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:         conn,
+		reader:       bufio.NewReaderSize(conn, 64*1024),
+		inChunkSize:  defaultChunkSize,
+		outChunkSize: defaultChunkSize,
+		inStreams:    map[uint32]*chunkStream{},
+	}
+}
+
+// RemoteAddr returns the address of the peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// serverHandshake performs the server side of the simple RTMP handshake
+func (c *Conn) serverHandshake() error {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(c.reader, c0c1); err != nil {
+		return fmt.Errorf("error reading C0/C1: %w", err)
+	}
+	if c0c1[0] != 0x03 {
+		return fmt.Errorf("unsupported RTMP version %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = 0x03
+	rand.Read(s0s1s2[9 : 1+handshakeSize])
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+	if _, err := c.conn.Write(s0s1s2); err != nil {
+		return fmt.Errorf("error writing S0/S1/S2: %w", err)
+	}
+
+	if _, err := io.ReadFull(c.reader, make([]byte, handshakeSize)); err != nil {
+		return fmt.Errorf("error reading C2: %w", err)
+	}
+
+	return nil
+}
+
+// clientHandshake performs the client side of the simple RTMP handshake
+func (c *Conn) clientHandshake() error {
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c0c1 := make([]byte, 1+handshakeSize)
+	c0c1[0] = 0x03
+	rand.Read(c0c1[9:])
+	if _, err := c.conn.Write(c0c1); err != nil {
+		return fmt.Errorf("error writing C0/C1: %w", err)
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	if _, err := io.ReadFull(c.reader, s0s1s2); err != nil {
+		return fmt.Errorf("error reading S0/S1/S2: %w", err)
+	}
+
+	// C2 echoes S1
+	if _, err := c.conn.Write(s0s1s2[1 : 1+handshakeSize]); err != nil {
+		return fmt.Errorf("error writing C2: %w", err)
+	}
+
+	return nil
+}
+
+// ReadMessage reads the next complete message, handling protocol control messages
+func (c *Conn) ReadMessage() (Message, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return Message{}, err
+		}
+		if msg == nil {
+			continue
+		}
+
+		switch msg.Type {
+		case MSG_SET_CHUNK_SIZE:
+			if len(msg.Payload) < 4 {
+				return Message{}, errors.New("invalid set chunk size message")
+			}
+			c.inChunkSize = int(binary.BigEndian.Uint32(msg.Payload) & 0x7fffffff)
+		case MSG_WINDOW_ACK_SIZE:
+			if len(msg.Payload) >= 4 {
+				c.ackWindow = binary.BigEndian.Uint32(msg.Payload)
+			}
+		case MSG_ABORT:
+			if len(msg.Payload) >= 4 {
+				delete(c.inStreams, binary.BigEndian.Uint32(msg.Payload))
+			}
+		}
+
+		return *msg, nil
+	}
+}
+
+func (c *Conn) readByte() (byte, error) {
+	b, err := c.reader.ReadByte()
+	c.bytesRead++
+	return b, err
+}
+
+func (c *Conn) readFull(buf []byte) error {
+	n, err := io.ReadFull(c.reader, buf)
+	c.bytesRead += uint32(n)
+	return err
+}
+
+// readChunk reads one chunk and returns the message if it completes one
+func (c *Conn) readChunk() (*Message, error) {
+	b, err := c.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	format := b >> 6
+	csid := uint32(b & 0x3f)
+	switch csid {
+	case 0:
+		next, err := c.readByte()
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(next)
+	case 1:
+		next := make([]byte, 2)
+		if err := c.readFull(next); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(next[0]) + uint32(next[1])*256
+	}
+
+	stream, ok := c.inStreams[csid]
+	if !ok {
+		if format != 0 {
+			return nil, fmt.Errorf("chunk stream %d started without a full header", csid)
+		}
+		stream = &chunkStream{}
+		c.inStreams[csid] = stream
+	}
+
+	headerSize := []int{11, 7, 3, 0}[format]
+	header := make([]byte, headerSize)
+	if err := c.readFull(header); err != nil {
+		return nil, err
+	}
+
+	var timestamp uint32
+	if format < 3 {
+		timestamp = uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+		stream.extended = timestamp == 0xffffff
+	}
+	if format <= 1 {
+		stream.length = int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+		stream.header.Type = header[6]
+		if stream.length > maxMessageSize {
+			return nil, fmt.Errorf("message of %d bytes exceeds limit", stream.length)
+		}
+	}
+	if format == 0 {
+		stream.header.StreamId = binary.LittleEndian.Uint32(header[7:11])
+	}
+
+	if stream.extended {
+		ext := make([]byte, 4)
+		if err := c.readFull(ext); err != nil {
+			return nil, err
+		}
+		if format < 3 {
+			timestamp = binary.BigEndian.Uint32(ext)
+		}
+	}
+
+	if !stream.inMessage {
+		switch format {
+		case 0:
+			stream.header.Timestamp = timestamp
+		case 1, 2:
+			stream.delta = timestamp
+			stream.header.Timestamp += timestamp
+		case 3:
+			stream.header.Timestamp += stream.delta
+		}
+		stream.payload = make([]byte, 0, stream.length)
+		stream.inMessage = true
+	}
+
+	size := min(c.inChunkSize, stream.length-len(stream.payload))
+	chunk := make([]byte, size)
+	if err := c.readFull(chunk); err != nil {
+		return nil, err
+	}
+	stream.payload = append(stream.payload, chunk...)
+
+	if err := c.acknowledge(); err != nil {
+		return nil, err
+	}
+
+	if len(stream.payload) < stream.length {
+		return nil, nil
+	}
+
+	stream.inMessage = false
+	msg := stream.header
+	msg.Payload = stream.payload
+
+	return &msg, nil
+}
+
+// acknowledge sends an acknowledgement once the peer's window has been received
+func (c *Conn) acknowledge() error {
+	if c.ackWindow == 0 || c.bytesRead-c.lastAckedRead < c.ackWindow {
+		return nil
+	}
+
+	c.lastAckedRead = c.bytesRead
+	return c.WriteMessage(CSID_CONTROL, Message{
+		Type:    MSG_ACKNOWLEDGEMENT,
+		Payload: binary.BigEndian.AppendUint32(nil, c.bytesRead),
+	})
+}
+
+// WriteMessage writes a message on the given chunk stream
+//
+// csid: the chunk stream ID to write on
+//
+// msg: the message to write
+func (c *Conn) WriteMessage(csid uint32, msg Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	extended := msg.Timestamp >= 0xffffff
+	timestamp := msg.Timestamp
+	if extended {
+		timestamp = 0xffffff
+	}
+
+	buf := make([]byte, 0, len(msg.Payload)+len(msg.Payload)/c.outChunkSize*5+16)
+	buf = append(buf, byte(csid&0x3f))
+	buf = append(buf, byte(timestamp>>16), byte(timestamp>>8), byte(timestamp))
+	buf = append(buf, byte(len(msg.Payload)>>16), byte(len(msg.Payload)>>8), byte(len(msg.Payload)))
+	buf = append(buf, msg.Type)
+	buf = binary.LittleEndian.AppendUint32(buf, msg.StreamId)
+	if extended {
+		buf = binary.BigEndian.AppendUint32(buf, msg.Timestamp)
+	}
+
+	for offset := 0; offset < len(msg.Payload); offset += c.outChunkSize {
+		if offset > 0 {
+			buf = append(buf, 0xc0|byte(csid&0x3f))
+			if extended {
+				buf = binary.BigEndian.AppendUint32(buf, msg.Timestamp)
+			}
+		}
+		buf = append(buf, msg.Payload[offset:min(offset+c.outChunkSize, len(msg.Payload))]...)
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(buf)
+
+	return err
+}
+
+// WriteCommand writes an AMF0 command message
+//
+// streamId: the message stream ID
+//
+// values: the command name, transaction ID and arguments
+func (c *Conn) WriteCommand(streamId uint32, values ...any) error {
+	return c.WriteMessage(CSID_COMMAND, Message{
+		Type:     MSG_COMMAND_AMF0,
+		StreamId: streamId,
+		Payload:  EncodeAMF(values...),
+	})
+}
+
+// SetChunkSize announces and applies a new outgoing chunk size
+func (c *Conn) SetChunkSize(size int) error {
+	if err := c.WriteMessage(CSID_CONTROL, Message{
+		Type:    MSG_SET_CHUNK_SIZE,
+		Payload: binary.BigEndian.AppendUint32(nil, uint32(size)),
+	}); err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	c.outChunkSize = size
+	c.writeMu.Unlock()
+
+	return nil
+}
+
+// ParseCommand decodes an AMF0/AMF3 command message into its name, transaction ID, and arguments
+func ParseCommand(msg Message) (string, float64, []any, error) {
+	payload := msg.Payload
+	if msg.Type == MSG_COMMAND_AMF3 && len(payload) > 0 {
+		payload = payload[1:]
+	}
+
+	values, err := DecodeAMF(payload)
+	if len(values) < 2 {
+		if err == nil {
+			err = errors.New("command is missing a name or transaction ID")
+		}
+		return "", 0, nil, err
+	}
+
+	name, _ := values[0].(string)
+	txId, _ := values[1].(float64)
+
+	return name, txId, values[2:], nil
+}
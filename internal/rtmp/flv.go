@@ -0,0 +1,160 @@
+package rtmp
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bytes"
+	"encoding/binary"
+)
+
+// FLV codec identifiers
+const (
+	flvCodecAVC   byte = 7
+	flvFormatAAC  byte = 10
+	flvAACHeader  byte = flvFormatAAC<<4 | 0x0f // 44kHz, 16-bit, stereo (fixed for AAC)
+	flvKeyframe   byte = 1
+	flvInterframe byte = 2
+)
+
+// Muxer converts transport stream access units into FLV-wrapped RTMP media messages
+type Muxer struct {
+	// The DTS of the first access unit, or -1 before the first unit
+	base int64
+	// The current AVC sequence header, used to detect changes
+	avcConfig []byte
+	// The current AAC sequence header, used to detect changes
+	aacConfig []byte
+}
+
+// NewMuxer initializes a new Muxer.
+func NewMuxer() *Muxer {
+	return &Muxer{base: -1}
+}
+
+// Mux converts an access unit into zero or more RTMP media messages. Sequence
+// headers are emitted whenever the codec configuration changes.
+//
+// au: the access unit to convert. Only H.264 and AAC are supported
+//
+// Example: Mux(mpegts.AccessUnit{...}) = []Message{...}
+func (m *Muxer) Mux(au mpegts.AccessUnit) []Message {
+	if m.base < 0 {
+		m.base = au.DTS
+	}
+
+	switch au.StreamType {
+	case mpegts.STREAM_TYPE_H264:
+		return m.muxH264(au)
+	case mpegts.STREAM_TYPE_AAC:
+		return m.muxAAC(au)
+	}
+
+	return nil
+}
+
+func (m *Muxer) timestamp(ts int64) uint32 {
+	delta := mpegts.TimestampDelta(m.base, ts)
+	if delta < 0 {
+		return 0
+	}
+
+	return uint32(delta / 90)
+}
+
+func (m *Muxer) muxH264(au mpegts.AccessUnit) []Message {
+	var messages []Message
+	var sps, pps, avcc []byte
+	keyframe := false
+
+	for _, nalu := range mpegts.SplitAnnexB(au.Data) {
+		switch mpegts.H264NALType(nalu) {
+		case mpegts.H264_NAL_SPS:
+			sps = nalu
+		case mpegts.H264_NAL_PPS:
+			pps = nalu
+		case mpegts.H264_NAL_AUD:
+			// Access unit delimiters are not carried in FLV
+		default:
+			if mpegts.H264NALType(nalu) == mpegts.H264_NAL_IDR {
+				keyframe = true
+			}
+			avcc = binary.BigEndian.AppendUint32(avcc, uint32(len(nalu)))
+			avcc = append(avcc, nalu...)
+		}
+	}
+
+	timestamp := m.timestamp(au.DTS)
+	if sps != nil && pps != nil {
+		if config, err := mpegts.AVCDecoderConfig(sps, pps); err == nil && !bytes.Equal(config, m.avcConfig) {
+			m.avcConfig = config
+			messages = append(messages, Message{
+				Type:      MSG_VIDEO,
+				Timestamp: timestamp,
+				Payload:   append([]byte{flvKeyframe<<4 | flvCodecAVC, 0x00, 0x00, 0x00, 0x00}, config...),
+			})
+		}
+	}
+
+	// Frames cannot be decoded before the first sequence header
+	if len(avcc) == 0 || m.avcConfig == nil {
+		return messages
+	}
+
+	frameType := flvInterframe
+	if keyframe {
+		frameType = flvKeyframe
+	}
+	cts := mpegts.TimestampDelta(au.DTS, au.PTS) / 90
+
+	payload := []byte{frameType<<4 | flvCodecAVC, 0x01, byte(cts >> 16), byte(cts >> 8), byte(cts)}
+	messages = append(messages, Message{
+		Type:      MSG_VIDEO,
+		Timestamp: timestamp,
+		Payload:   append(payload, avcc...),
+	})
+
+	return messages
+}
+
+func (m *Muxer) muxAAC(au mpegts.AccessUnit) []Message {
+	var messages []Message
+
+	for i, frame := range mpegts.SplitADTS(au.Data) {
+		sampleRate := frame.SampleRate()
+		if sampleRate == 0 {
+			continue
+		}
+		timestamp := m.timestamp(au.PTS + int64(i)*1024*mpegts.CLOCK_RATE/int64(sampleRate))
+
+		if config := frame.AudioSpecificConfig(); !bytes.Equal(config, m.aacConfig) {
+			m.aacConfig = config
+			messages = append(messages, Message{
+				Type:      MSG_AUDIO,
+				Timestamp: timestamp,
+				Payload:   append([]byte{flvAACHeader, 0x00}, config...),
+			})
+		}
+
+		messages = append(messages, Message{
+			Type:      MSG_AUDIO,
+			Timestamp: timestamp,
+			Payload:   append([]byte{flvAACHeader, 0x01}, frame.Data...),
+		})
+	}
+
+	return messages
+}
+
+// IsSequenceHeader returns whether the media message carries codec configuration
+func IsSequenceHeader(msg Message) bool {
+	switch msg.Type {
+	case MSG_VIDEO, MSG_AUDIO:
+		return len(msg.Payload) > 1 && msg.Payload[1] == 0x00
+	}
+
+	return false
+}
+
+// IsKeyframe returns whether the media message is a video keyframe
+func IsKeyframe(msg Message) bool {
+	return msg.Type == MSG_VIDEO && len(msg.Payload) > 1 && msg.Payload[0]>>4 == flvKeyframe && msg.Payload[1] == 0x01
+}
@@ -0,0 +1,310 @@
+package rtmp
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// PLAYER_QUEUE_SIZE is the number of media messages buffered per player
+const PLAYER_QUEUE_SIZE = 1024
+
+// Server is an RTMP server that broadcasts a single live stream to players
+type Server struct {
+	listener net.Listener
+	onLog    func(string)
+	metrics  metrics.Metrics
+
+	mu      sync.Mutex
+	players map[*player]struct{}
+	// Latest video/audio sequence headers sent to players on join
+	videoHeader *Message
+	audioHeader *Message
+	closed      bool
+}
+
+type player struct {
+	conn  *Conn
+	queue chan Message
+	// Whether the player is waiting for a keyframe before receiving video
+	waitKeyframe bool
+}
+
+// Listen starts an RTMP server on the given address.
+//
+// addr: the TCP address to listen on
+//
+// onLog: callback for player connection messages
+//
+// m: optional metrics backend reporting the number of players
+//
+// Example: Listen("127.0.0.1:1935", log, nil) = &Server{...}, nil
+func Listen(addr string, onLog func(string), m metrics.Metrics) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+
+	if onLog == nil {
+		onLog = func(string) {}
+	}
+	if m == nil {
+		m = metrics.Noop
+	}
+
+	s := &Server{
+		listener: listener,
+		onLog:    onLog,
+		metrics:  m,
+		players:  map[*player]struct{}{},
+	}
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Players returns the number of connected players
+func (s *Server) Players() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.players)
+}
+
+// Broadcast sends the media messages to every connected player. Players that
+// cannot keep up have their backlog dropped and resume at the next keyframe.
+func (s *Server) Broadcast(messages ...Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range messages {
+		if IsSequenceHeader(msg) {
+			header := msg
+			if msg.Type == MSG_VIDEO {
+				s.videoHeader = &header
+			} else {
+				s.audioHeader = &header
+			}
+		}
+
+		for p := range s.players {
+			if msg.Type == MSG_VIDEO && !IsSequenceHeader(msg) {
+				if p.waitKeyframe && !IsKeyframe(msg) {
+					continue
+				}
+				p.waitKeyframe = false
+			}
+
+			select {
+			case p.queue <- msg:
+			default:
+				// Drain the backlog and wait for the next keyframe
+				for len(p.queue) > 0 {
+					<-p.queue
+				}
+				p.waitKeyframe = true
+			}
+		}
+	}
+}
+
+// Close stops the server and disconnects all players
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for p := range s.players {
+		p.conn.Close()
+	}
+	s.mu.Unlock()
+
+	return s.listener.Close()
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			if err := s.serve(newConn(conn)); err != nil {
+				s.onLog(fmt.Sprintf("RTMP player %s disconnected: %v", conn.RemoteAddr(), err))
+			}
+		}()
+	}
+}
+
+// serve handles the command exchange of a single player connection
+func (s *Server) serve(conn *Conn) error {
+	defer conn.Close()
+
+	if err := conn.serverHandshake(); err != nil {
+		return err
+	}
+
+	var p *player
+	defer func() {
+		if p != nil {
+			s.mu.Lock()
+			delete(s.players, p)
+			s.metrics.Gauge(metrics.RTMP_PLAYERS, float64(len(s.players)), nil)
+			s.mu.Unlock()
+			close(p.queue)
+		}
+	}()
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if msg.Type != MSG_COMMAND_AMF0 && msg.Type != MSG_COMMAND_AMF3 {
+			continue
+		}
+
+		name, txId, args, err := ParseCommand(msg)
+		if err != nil {
+			return fmt.Errorf("invalid command: %w", err)
+		}
+
+		switch name {
+		case "connect":
+			if err := s.handleConnect(conn, txId); err != nil {
+				return err
+			}
+		case "createStream":
+			if err := conn.WriteCommand(0, "_result", txId, nil, 1); err != nil {
+				return err
+			}
+		case "play":
+			if p != nil {
+				continue
+			}
+
+			streamName := ""
+			if len(args) > 1 {
+				streamName, _ = args[1].(string)
+			}
+
+			p, err = s.handlePlay(conn, msg.StreamId, streamName)
+			if err != nil {
+				return err
+			}
+			s.onLog(fmt.Sprintf("RTMP player %s started playing %q", conn.RemoteAddr(), streamName))
+		case "publish":
+			conn.WriteCommand(msg.StreamId, "onStatus", 0, nil, Object{
+				"level":       "error",
+				"code":        "NetStream.Publish.BadName",
+				"description": "Publishing is not supported",
+			})
+			return errors.New("client attempted to publish")
+		case "deleteStream", "closeStream":
+			return nil
+		}
+	}
+}
+
+func (s *Server) handleConnect(conn *Conn, txId float64) error {
+	if err := conn.WriteMessage(CSID_CONTROL, Message{
+		Type:    MSG_WINDOW_ACK_SIZE,
+		Payload: binary.BigEndian.AppendUint32(nil, 2500000),
+	}); err != nil {
+		return err
+	}
+
+	if err := conn.WriteMessage(CSID_CONTROL, Message{
+		Type:    MSG_SET_PEER_BANDWIDTH,
+		Payload: append(binary.BigEndian.AppendUint32(nil, 2500000), 0x02),
+	}); err != nil {
+		return err
+	}
+
+	if err := conn.SetChunkSize(outChunkSize); err != nil {
+		return err
+	}
+
+	return conn.WriteCommand(0, "_result", txId, Object{
+		"fmsVer":       "FMS/3,0,1,123",
+		"capabilities": 31,
+	}, Object{
+		"level":          "status",
+		"code":           "NetConnection.Connect.Success",
+		"description":    "Connection succeeded.",
+		"objectEncoding": 0,
+	})
+}
+
+func (s *Server) handlePlay(conn *Conn, streamId uint32, streamName string) (*player, error) {
+	// User control: StreamBegin
+	if err := conn.WriteMessage(CSID_CONTROL, Message{
+		Type:    MSG_USER_CONTROL,
+		Payload: binary.BigEndian.AppendUint32([]byte{0x00, 0x00}, streamId),
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, code := range []string{"NetStream.Play.Reset", "NetStream.Play.Start"} {
+		if err := conn.WriteCommand(streamId, "onStatus", 0, nil, Object{
+			"level":       "status",
+			"code":        code,
+			"description": "Playing " + streamName,
+			"details":     streamName,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := conn.WriteMessage(CSID_COMMAND, Message{
+		Type:     MSG_DATA_AMF0,
+		StreamId: streamId,
+		Payload:  EncodeAMF("onMetaData", EcmaArray{"videocodecid": int(flvCodecAVC), "audiocodecid": int(flvFormatAAC)}),
+	}); err != nil {
+		return nil, err
+	}
+
+	p := &player{
+		conn:         conn,
+		queue:        make(chan Message, PLAYER_QUEUE_SIZE),
+		waitKeyframe: true,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("server closed")
+	}
+	for _, header := range []*Message{s.videoHeader, s.audioHeader} {
+		if header != nil {
+			p.queue <- *header
+		}
+	}
+	s.players[p] = struct{}{}
+	s.metrics.Gauge(metrics.RTMP_PLAYERS, float64(len(s.players)), nil)
+	s.mu.Unlock()
+
+	go func() {
+		for msg := range p.queue {
+			msg.StreamId = streamId
+			csid := CSID_VIDEO
+			if msg.Type == MSG_AUDIO {
+				csid = CSID_AUDIO
+			}
+
+			if err := conn.WriteMessage(csid, msg); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	return p, nil
+}
@@ -0,0 +1,243 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// DEFAULT_DIAL_TIMEOUT bounds resolving the server and establishing the connection
+	DEFAULT_DIAL_TIMEOUT = 10 * time.Second
+	// DEFAULT_FALLBACK_DELAY is how long a connection attempt gets before the next
+	// address is tried in parallel, as recommended by RFC 8305
+	DEFAULT_FALLBACK_DELAY = 250 * time.Millisecond
+)
+
+// DialConfig configures how the connection to the server is established
+type DialConfig struct {
+	// Maximum time to resolve the server and connect (defaults to
+	// DEFAULT_DIAL_TIMEOUT)
+	Timeout time.Duration
+	// How long each attempt gets before the next address is tried in parallel
+	// (defaults to DEFAULT_FALLBACK_DELAY)
+	FallbackDelay time.Duration
+	// Optional local IP address the connection is made from, e.g. on a multi-homed
+	// host. Only servers of its address family are dialed
+	SourceAddress string
+	// Optional network interface the connection is made from, e.g. "eth1". Its
+	// first IPv4 and IPv6 addresses are used as the source of each family
+	Interface string
+	// Idle time before the first TCP keep-alive probe. Zero uses the default of
+	// 15 seconds; negative disables the probes
+	KeepAliveIdle time.Duration
+	// Time between TCP keep-alive probes (defaults to 15 seconds)
+	KeepAliveInterval time.Duration
+	// Unanswered TCP keep-alive probes after which the connection is dropped
+	// (defaults to 9)
+	KeepAliveCount int
+	// Whether small writes are coalesced (Nagle's algorithm). By default
+	// TCP_NODELAY is set, so pings and auth frames leave right away
+	Nagle bool
+}
+
+// Dial connects to a server over TCP, racing its IPv6 and IPv4 addresses
+// ("happy eyeballs"). The addresses are tried alternating between the families,
+// starting with the first one resolved; each attempt starts when the previous one
+// failed or after the fallback delay, and the first connection established wins.
+// This avoids hanging on an address family that is unreachable from the host.
+//
+// ctx: the context cancelling the dial
+//
+// config: the dial configuration
+//
+// host: the server hostname or IP address
+//
+// port: the server port
+//
+// Example: Dial(ctx, DialConfig{Interface: "eth1"}, "relay.example.com", "443") = net.Conn, nil
+func Dial(ctx context.Context, config DialConfig, host string, port string) (net.Conn, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if config.FallbackDelay <= 0 {
+		config.FallbackDelay = DEFAULT_FALLBACK_DELAY
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	sources, err := sourceAddresses(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := interleave(ips, sources)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address of %s is reachable from the source address", host)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	attempts, pending := 0, 0
+	var errs []error
+
+	timer := time.NewTimer(config.FallbackDelay)
+	defer timer.Stop()
+
+	start := func() {
+		addr := addrs[attempts]
+		attempts++
+		pending++
+		dialer := &net.Dialer{LocalAddr: localAddr(sources, addr.IP)}
+		if config.KeepAliveIdle < 0 {
+			dialer.KeepAlive = -1
+		} else {
+			dialer.KeepAliveConfig = net.KeepAliveConfig{
+				Enable:   true,
+				Idle:     config.KeepAliveIdle,
+				Interval: config.KeepAliveInterval,
+				Count:    config.KeepAliveCount,
+			}
+		}
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.IP.String(), port))
+			results <- result{conn, err}
+		}()
+		timer.Reset(config.FallbackDelay)
+	}
+
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// The slower attempts are cancelled, and closed if they connected anyway
+				cancel()
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				if tcp, ok := r.conn.(*net.TCPConn); ok && config.Nagle {
+					tcp.SetNoDelay(false)
+				}
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			// A failed attempt starts the next one right away
+			if attempts < len(addrs) {
+				start()
+			}
+		case <-timer.C:
+			if attempts < len(addrs) {
+				start()
+			}
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// sourceAddresses returns the local address of each address family (4 or 6) the
+// connection may be made from, or nil if any is allowed
+func sourceAddresses(config DialConfig) (map[int]*net.TCPAddr, error) {
+	if config.SourceAddress != "" {
+		ip := net.ParseIP(config.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", config.SourceAddress)
+		}
+		return map[int]*net.TCPAddr{family(ip): {IP: ip}}, nil
+	}
+	if config.Interface == "" {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(config.Interface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[int]*net.TCPAddr{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		// Link-local addresses only reach the local link
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if _, ok := sources[family(ipNet.IP)]; !ok {
+			sources[family(ipNet.IP)] = &net.TCPAddr{IP: ipNet.IP}
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable address", config.Interface)
+	}
+
+	return sources, nil
+}
+
+// localAddr returns the source address of the family of an IP, or nil if any is
+// allowed
+func localAddr(sources map[int]*net.TCPAddr, ip net.IP) net.Addr {
+	if source, ok := sources[family(ip)]; ok {
+		return source
+	}
+
+	return nil
+}
+
+// interleave orders the resolved addresses alternating between the address
+// families, starting with the family of the first one, and drops those of families
+// without a source address
+func interleave(ips []net.IPAddr, sources map[int]*net.TCPAddr) []net.IPAddr {
+	var first, second []net.IPAddr
+	for _, ip := range ips {
+		if sources != nil {
+			if _, ok := sources[family(ip.IP)]; !ok {
+				continue
+			}
+		}
+		if len(first) == 0 || family(ip.IP) == family(first[0].IP) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+
+	addrs := make([]net.IPAddr, 0, len(first)+len(second))
+	for i := 0; i < max(len(first), len(second)); i++ {
+		if i < len(first) {
+			addrs = append(addrs, first[i])
+		}
+		if i < len(second) {
+			addrs = append(addrs, second[i])
+		}
+	}
+
+	return addrs
+}
+
+// family returns the address family of an IP, 4 or 6
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+
+	return 6
+}
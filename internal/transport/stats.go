@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DEFAULT_STATS_INTERVAL is how often OnStats is called by default
+const DEFAULT_STATS_INTERVAL = 5 * time.Second
+
+// STATS_WINDOW is the rolling window the stream rates are averaged over
+const STATS_WINDOW = 10 * time.Second
+
+// Stats describes the quality of the stream over the last STATS_WINDOW
+type Stats struct {
+	// The average bitrate in bits per second
+	Bitrate float64
+	// The average number of MPEG-TS packets per second
+	PacketRate float64
+	// The average number of video frames per second, or 0 if the frames are not
+	// counted (e.g. for a raw stream)
+	FrameRate float64
+	// The bytes received since the connection was established
+	Bytes int64
+	// How long the connection has been established
+	Connected time.Duration
+}
+
+// RateMeter estimates the rate of a quantity (e.g. bytes) over a rolling window of
+// one second buckets. It is safe for concurrent use.
+type RateMeter struct {
+	// Guards the fields below
+	mu sync.Mutex
+	// The amounts added per second, indexed by Unix second modulo the window
+	buckets []float64
+	// The Unix second of the newest bucket
+	newest int64
+	// When the meter was created, as rates are averaged over a shorter window until
+	// the window is filled
+	started time.Time
+}
+
+// NewRateMeter initializes a rate meter averaging over the window, rounded up to
+// whole seconds.
+//
+// window: the rolling window of the rate
+//
+// Example: NewRateMeter(10 * time.Second) = &RateMeter{...}
+func NewRateMeter(window time.Duration) *RateMeter {
+	seconds := max(int((window+time.Second-1)/time.Second), 1)
+	now := time.Now()
+
+	return &RateMeter{
+		buckets: make([]float64, seconds),
+		newest:  now.Unix(),
+		started: now,
+	}
+}
+
+// Add records an amount at the current time
+func (m *RateMeter) Add(amount float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().Unix()
+	m.advance(now)
+	m.buckets[now%int64(len(m.buckets))] += amount
+}
+
+// Rate returns the average amount per second over the window
+func (m *RateMeter) Rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.advance(now.Unix())
+
+	var total float64
+	for _, amount := range m.buckets {
+		total += amount
+	}
+
+	// Until the window is filled, only the elapsed time counts, but at least one
+	// second so the first amounts do not spike the rate
+	window := time.Duration(len(m.buckets)) * time.Second
+	elapsed := min(max(now.Sub(m.started), time.Second), window)
+
+	return total / elapsed.Seconds()
+}
+
+// advance clears the buckets of the seconds that passed since the newest bucket.
+// m.mu must be held.
+func (m *RateMeter) advance(now int64) {
+	if now <= m.newest {
+		return
+	}
+
+	for second := m.newest + 1; second <= now && second-m.newest <= int64(len(m.buckets)); second++ {
+		m.buckets[second%int64(len(m.buckets))] = 0
+	}
+	m.newest = now
+}
+
+// streamStats measures a connection for OnStats
+type streamStats struct {
+	// The bytes received per second
+	bytes *RateMeter
+	// The bytes received in total
+	total atomic.Int64
+	// When the connection was established
+	connected time.Time
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{
+		bytes:     NewRateMeter(STATS_WINDOW),
+		connected: time.Now(),
+	}
+}
+
+// add records bytes received from the connection
+func (s *streamStats) add(n int) {
+	s.bytes.Add(float64(n))
+	s.total.Add(int64(n))
+}
+
+// snapshot returns the current stats of the connection
+func (s *streamStats) snapshot() Stats {
+	rate := s.bytes.Rate()
+
+	return Stats{
+		Bitrate:    rate * 8,
+		PacketRate: rate / mpegts.PACKET_SIZE,
+		Bytes:      s.total.Load(),
+		Connected:  time.Since(s.connected),
+	}
+}
@@ -1,16 +1,35 @@
 package transport
 
 import (
+	"amattu2/blink-middleware/pkg/metrics"
 	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// DEFAULT_READ_BUFFER_SIZE is the default size of the buffer the stream is read into.
+// A 1 Mbps stream fills it a few times per second, keeping the read syscalls low.
+const DEFAULT_READ_BUFFER_SIZE = 32 << 10
+
+// REPORT_INTERVAL is how often the received bytes are reported to the metrics
+const REPORT_INTERVAL = time.Second
+
+// readBuffers pools the default size read buffers across sessions, so multi-camera
+// deployments reuse them as streams stop and start
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, DEFAULT_READ_BUFFER_SIZE)
+		return &buf
+	},
+}
+
 type StreamConfig struct {
 	// The output writer for the stream
 	Writer io.Writer
@@ -18,16 +37,74 @@ type StreamConfig struct {
 	Ctx context.Context
 	// Read timeout duration for the initial TCP connection
 	ReadTimeout time.Duration
-	// Interval for sending keep-alive pings
+	// Interval for sending keep-alive pings (defaults to 1 second)
 	PingInterval time.Duration
+	// Optional strategy returning the delay until the next ping after each ping.
+	// Non-positive delays fall back to PingInterval
+	KeepAlive func(KeepAliveState) time.Duration
 	// Callback for handling ping actions, if necessary
-	OnPing func(*tls.Conn) error
+	OnPing func(net.Conn) error
 	// Callback for handling actions upon successful connection
-	OnConnect func(*tls.Conn) error
+	OnConnect func(net.Conn) error
 	// Error callback for handling stream-level errors
 	OnError func(error)
 	// Log callback for handling stream-level logs
 	OnLog func(string)
+	// Optional metrics backend for transport measurements
+	Metrics metrics.Metrics
+	// The size of the read buffer in bytes (defaults to DEFAULT_READ_BUFFER_SIZE)
+	ReadBufferSize int
+	// Optional callback receiving the raw bytes read from (sent false) and written to
+	// (sent true) the connection, e.g. to capture the protocol
+	OnCapture func(sent bool, data []byte)
+	// Optional callback receiving the stream quality every StatsInterval while connected
+	OnStats func(Stats)
+	// How often OnStats is called (defaults to DEFAULT_STATS_INTERVAL)
+	StatsInterval time.Duration
+	// How the connection to the server is established, e.g. its timeout and source
+	// address
+	Dial DialConfig
+	// Optional TLS configuration, cloned for each connection. Its ServerName
+	// defaults to the host, and the server certificate is only verified when
+	// RootCAs are set. VerifyPeerCertificate runs either way
+	TLSConfig *tls.Config
+	// Optional callback receiving the state of each completed TLS handshake, e.g.
+	// the negotiated version and cipher suite and the server certificates
+	OnHandshake func(tls.ConnectionState)
+}
+
+// KeepAliveState describes the connection when the keep-alive strategy is consulted
+type KeepAliveState struct {
+	// How long the connection has been established
+	Connected time.Duration
+	// How long ago stream data was last received
+	SinceLastRead time.Duration
+	// The pings sent since stream data was last received, including this one
+	PingsSinceRead int
+}
+
+// capturedConn passes the data read from and written to a connection to a callback
+type capturedConn struct {
+	net.Conn
+	onCapture func(sent bool, data []byte)
+}
+
+func (c *capturedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.onCapture(false, p[:n])
+	}
+
+	return n, err
+}
+
+func (c *capturedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.onCapture(true, p[:n])
+	}
+
+	return n, err
 }
 
 // Stream connects to the liveview server using a TCP connection.
@@ -41,29 +118,170 @@ type StreamConfig struct {
 //
 // Example: Stream(config, "0.0.0.0", "443") = nil
 func Stream(config StreamConfig, host string, port string) error {
+	if config.Metrics == nil {
+		config.Metrics = metrics.Noop
+	}
+	if config.PingInterval <= 0 {
+		config.PingInterval = time.Second
+	}
+	if config.StatsInterval <= 0 {
+		config.StatsInterval = DEFAULT_STATS_INTERVAL
+	}
+	if config.Dial.Timeout <= 0 {
+		config.Dial.Timeout = DEFAULT_DIAL_TIMEOUT
+	}
+
 	config.OnLog(fmt.Sprintf("Connecting to %s:%s", host, port))
 
-	client, err := tls.Dial("tcp", fmt.Sprintf("%s:%s", host, port), &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
-		Certificates:       []tls.Certificate{},
-	})
+	raw, err := Dial(config.Ctx, config.Dial, host, port)
 	if err != nil {
 		return fmt.Errorf("unable to initialize stream: %w", err)
-	} else {
-		config.OnLog(fmt.Sprintf("Connected to %s", client.RemoteAddr()))
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if config.TLSConfig != nil {
+		tlsConfig = config.TLSConfig.Clone()
+		tlsConfig.InsecureSkipVerify = tlsConfig.InsecureSkipVerify || tlsConfig.RootCAs == nil
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	conn := tls.Client(raw, tlsConfig)
+	handshakeCtx, cancelHandshake := context.WithTimeout(config.Ctx, config.Dial.Timeout)
+	err = conn.HandshakeContext(handshakeCtx)
+	cancelHandshake()
+	if err != nil {
+		raw.Close()
+		return fmt.Errorf("unable to initialize stream: %w", err)
+	}
+	config.OnLog(fmt.Sprintf("Connected to %s", conn.RemoteAddr()))
+	if config.OnHandshake != nil {
+		config.OnHandshake(conn.ConnectionState())
+	}
+
+	var client net.Conn = conn
+	if config.OnCapture != nil {
+		client = &capturedConn{Conn: conn, onCapture: config.OnCapture}
 	}
 	defer client.Close()
 	defer config.OnLog(fmt.Sprintf("Disconnected from %s", client.RemoteAddr()))
 
-	start := time.Now()
-	if err := config.OnConnect(client); err != nil {
+	// Writes to the connection are serialized, as each one sets its own deadline
+	var writeMu sync.Mutex
+	writeMu.Lock()
+	err = config.OnConnect(client)
+	writeMu.Unlock()
+	if err != nil {
 		return fmt.Errorf("error on connect: %w", err)
 	}
 
-	buf := make([]byte, 64)
+	// Pings are sent on their own schedule so a quiet stream still keeps the
+	// connection alive. A failed ping closes the connection to end the read loop.
+	connected := time.Now()
+	// When stream data was last received, in Unix nanoseconds
+	var lastRead atomic.Int64
+	lastRead.Store(connected.UnixNano())
+
+	pingCtx, stopPing := context.WithCancel(config.Ctx)
+	pingErr := make(chan error, 1)
+	pingDone := make(chan struct{})
+	go func() {
+		defer close(pingDone)
+
+		timer := time.NewTimer(config.PingInterval)
+		defer timer.Stop()
+
+		var pings int
+		var seenRead int64
+		for {
+			select {
+			case <-pingCtx.Done():
+				return
+			case <-timer.C:
+				writeMu.Lock()
+				err := config.OnPing(client)
+				writeMu.Unlock()
+				if err != nil {
+					pingErr <- err
+					client.Close()
+					return
+				}
+				config.Metrics.Counter(metrics.STREAM_PINGS_TOTAL, 1, nil)
+
+				next := config.PingInterval
+				if config.KeepAlive != nil {
+					read := lastRead.Load()
+					if read != seenRead {
+						seenRead = read
+						pings = 0
+					}
+					pings++
+
+					now := time.Now()
+					delay := config.KeepAlive(KeepAliveState{
+						Connected:      now.Sub(connected),
+						SinceLastRead:  now.Sub(time.Unix(0, read)),
+						PingsSinceRead: pings,
+					})
+					if delay > 0 {
+						next = delay
+					}
+				}
+				timer.Reset(next)
+			}
+		}
+	}()
+	defer func() {
+		stopPing()
+		<-pingDone
+	}()
+
+	// The stream quality is reported on its own schedule, like the pings
+	var stats *streamStats
+	if config.OnStats != nil {
+		stats = newStreamStats()
+		statsDone := make(chan struct{})
+		go func() {
+			defer close(statsDone)
+
+			ticker := time.NewTicker(config.StatsInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-pingCtx.Done():
+					return
+				case <-ticker.C:
+					config.OnStats(stats.snapshot())
+				}
+			}
+		}()
+		defer func() {
+			stopPing()
+			<-statsDone
+		}()
+	}
+
+	lastReport := time.Now()
+
+	var buf []byte
+	if config.ReadBufferSize <= 0 || config.ReadBufferSize == DEFAULT_READ_BUFFER_SIZE {
+		pooled := readBuffers.Get().(*[]byte)
+		defer readBuffers.Put(pooled)
+		buf = *pooled
+	} else {
+		buf = make([]byte, config.ReadBufferSize)
+	}
 	var streamErr error
 	var readTimeout = config.ReadTimeout
+
+	// Received bytes are reported in batches to keep the read loop cheap
+	var received int
+	defer func() {
+		config.Metrics.Counter(metrics.STREAM_BYTES_TOTAL, float64(received), nil)
+	}()
+	reportError := func(reason string) {
+		config.Metrics.Counter(metrics.STREAM_ERRORS_TOTAL, 1, metrics.Labels{"reason": reason})
+	}
 stream:
 	for {
 		select {
@@ -78,32 +296,45 @@ stream:
 
 			n, err := client.Read(buf)
 			if err != nil {
+				select {
+				case err := <-pingErr:
+					streamErr = fmt.Errorf("error sending keep-alive: %w", err)
+					reportError("ping")
+					break stream
+				default:
+				}
+
 				if errors.Is(err, io.EOF) {
 					streamErr = fmt.Errorf("connection closed gracefully by peer: %w", err)
+					reportError("eof")
 				} else if errors.Is(err, syscall.ECONNRESET) {
 					streamErr = fmt.Errorf("connection reset by peer: %w", err)
+					reportError("reset")
 				} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					streamErr = fmt.Errorf("read timeout: %w", err)
+					reportError("timeout")
 				} else {
 					streamErr = fmt.Errorf("error reading from server: %w", err)
+					reportError("read")
 				}
 				break stream
 			}
 
+			received += n
+			lastRead.Store(time.Now().UnixNano())
+			if stats != nil {
+				stats.add(n)
+			}
 			if _, err := config.Writer.Write(buf[:n]); err != nil {
 				streamErr = fmt.Errorf("error writing to writer: %w", err)
+				reportError("write")
 				break stream
 			}
 
-			// Send a keep-alive ping to the server
-			if time.Since(start) > config.PingInterval {
-				if err := config.OnPing(client); err != nil {
-					streamErr = fmt.Errorf("error sending keep-alive: %w", err)
-					break stream
-				}
-
-				// Reset the timer
-				start = time.Now()
+			if time.Since(lastReport) > REPORT_INTERVAL {
+				config.Metrics.Counter(metrics.STREAM_BYTES_TOTAL, float64(received), nil)
+				received = 0
+				lastReport = time.Now()
 			}
 
 			// After the initial connection, reduce the read timeout tolerance
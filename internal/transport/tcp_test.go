@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BENCHMARK_STREAM_BYTES is the size of the stream each camera receives per
+// iteration of BenchmarkStream, about 8 seconds of a 1 Mbps livestream
+const BENCHMARK_STREAM_BYTES = 1 << 20
+
+// LEGACY_READ_BUFFER_SIZE is the read buffer size before DEFAULT_READ_BUFFER_SIZE
+// and the pooled buffers
+const LEGACY_READ_BUFFER_SIZE = 64
+
+// BenchmarkStream measures the CPU cost of receiving the livestreams of several
+// cameras at once, with the legacy 64 byte read buffer against the pooled
+// DEFAULT_READ_BUFFER_SIZE buffer. Each camera streams BENCHMARK_STREAM_BYTES over
+// its own TLS connection, so ns/op is the cost of one round of all of them.
+func BenchmarkStream(b *testing.B) {
+	listener := newStreamServer(b)
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	buffers := []struct {
+		name string
+		size int
+	}{
+		{"legacy-64B", LEGACY_READ_BUFFER_SIZE},
+		{"pooled-32KiB", 0},
+	}
+	for _, cameras := range []int{1, 4, 16} {
+		for _, buffer := range buffers {
+			b.Run(fmt.Sprintf("cameras=%d/%s", cameras, buffer.name), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(cameras) * BENCHMARK_STREAM_BYTES)
+
+				for i := 0; i < b.N; i++ {
+					var wg sync.WaitGroup
+					errs := make(chan error, cameras)
+					for range cameras {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							errs <- streamOnce(host, port, buffer.size)
+						}()
+					}
+					wg.Wait()
+					close(errs)
+					for err := range errs {
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// streamOnce receives one stream from the benchmark server, checking that all of
+// it arrived before the server closed the connection
+func streamOnce(host string, port string, readBufferSize int) error {
+	var writer countWriter
+	err := Stream(StreamConfig{
+		Writer:         &writer,
+		Ctx:            context.Background(),
+		ReadTimeout:    5 * time.Second,
+		PingInterval:   time.Hour,
+		OnPing:         func(net.Conn) error { return nil },
+		OnConnect:      func(net.Conn) error { return nil },
+		OnLog:          func(string) {},
+		ReadBufferSize: readBufferSize,
+	}, host, port)
+	if !errors.Is(err, io.EOF) {
+		return fmt.Errorf("expecting the server to close the stream, got %v", err)
+	}
+	if writer != BENCHMARK_STREAM_BYTES {
+		return fmt.Errorf("received %d bytes, expecting %d", writer, BENCHMARK_STREAM_BYTES)
+	}
+
+	return nil
+}
+
+// countWriter discards the stream, counting its bytes
+type countWriter int
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+
+	return len(p), nil
+}
+
+// newStreamServer starts a TLS server sending BENCHMARK_STREAM_BYTES of MPEG-TS
+// sized chunks to each connection, then closing it
+func newStreamServer(b *testing.B) net.Listener {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { listener.Close() })
+
+	// The livestream arrives in bursts of a few TS packets
+	chunk := make([]byte, 7*188)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for sent := 0; sent < BENCHMARK_STREAM_BYTES; sent += len(chunk) {
+					if _, err := conn.Write(chunk[:min(len(chunk), BENCHMARK_STREAM_BYTES-sent)]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener
+}
@@ -0,0 +1,545 @@
+// Package acme obtains and renews TLS certificates from an ACME certificate
+// authority such as Let's Encrypt (RFC 8555), using HTTP-01 challenges.
+//
+// The Manager serves the challenges under /.well-known/acme-challenge/ on a plain
+// HTTP server that must be reachable on port 80 of every domain, and hands the
+// certificate to TLS servers through GetCertificate. The account key and the
+// certificate are cached in a directory, so restarts do not request new ones.
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LETSENCRYPT_URL is the directory of the Let's Encrypt production CA
+	LETSENCRYPT_URL = "https://acme-v02.api.letsencrypt.org/directory"
+	// LETSENCRYPT_STAGING_URL is the directory of the Let's Encrypt staging CA, for
+	// testing without its rate limits
+	LETSENCRYPT_STAGING_URL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	// CHALLENGE_PATH is the path prefix of the HTTP-01 challenges
+	CHALLENGE_PATH = "/.well-known/acme-challenge/"
+	// DEFAULT_RENEW_BEFORE is how long before its expiry a certificate is renewed
+	DEFAULT_RENEW_BEFORE = 30 * 24 * time.Hour
+	// DEFAULT_ORDER_TIMEOUT bounds obtaining a certificate
+	DEFAULT_ORDER_TIMEOUT = 5 * time.Minute
+	// CHECK_INTERVAL is how often Run checks whether the certificate is due
+	CHECK_INTERVAL = 12 * time.Hour
+	// RETRY_INTERVAL is the delay before Run retries a failed order
+	RETRY_INTERVAL = time.Hour
+	// POLL_INTERVAL is the delay between polls of a pending authorization or order
+	POLL_INTERVAL = 2 * time.Second
+)
+
+// ErrNoCertificate is returned by GetCertificate before a certificate was obtained
+var ErrNoCertificate = errors.New("no certificate was obtained yet")
+
+type Config struct {
+	// The domains of the certificate (e.g. "cams.example.com")
+	Domains []string
+	// Optional contact email of the account, for expiry notices
+	Email string
+	// Directory of the account key and certificate (e.g.
+	// "~/.config/blink-middleware/acme")
+	CacheDir string
+	// The ACME directory URL (defaults to LETSENCRYPT_URL)
+	DirectoryURL string
+	// How long before its expiry the certificate is renewed (defaults to
+	// DEFAULT_RENEW_BEFORE)
+	RenewBefore time.Duration
+	// Optional HTTP client for the requests to the CA
+	HTTPClient *http.Client
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Manager struct {
+	// Configuration options for the manager
+	config Config
+	// The HTTP client of the requests to the CA
+	client *http.Client
+	// Guards the fields below
+	mu sync.Mutex
+	// The current certificate, or nil before the first one
+	certificate *tls.Certificate
+	// The key authorizations of the pending challenges keyed by token
+	challenges map[string]string
+}
+
+// directory lists the endpoints of the CA
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type authorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []struct {
+		Type  string `json:"type"`
+		URL   string `json:"url"`
+		Token string `json:"token"`
+	} `json:"challenges"`
+}
+
+// problem is an error document of the CA (RFC 7807)
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// New initializes a certificate manager and loads the cached certificate, if any.
+//
+// config: the manager configuration
+//
+// Example: New(Config{Domains: []string{"cams.example.com"}, CacheDir: "acme"}) = &Manager{...}, nil
+func New(config Config) (*Manager, error) {
+	if len(config.Domains) == 0 {
+		return nil, errors.New("at least one domain is required")
+	}
+	if config.CacheDir == "" {
+		return nil, errors.New("a cache directory is required")
+	}
+	if config.DirectoryURL == "" {
+		config.DirectoryURL = LETSENCRYPT_URL
+	}
+	if config.RenewBefore <= 0 {
+		config.RenewBefore = DEFAULT_RENEW_BEFORE
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	m := &Manager{
+		config:     config,
+		client:     config.HTTPClient,
+		challenges: map[string]string{},
+	}
+	if m.client == nil {
+		m.client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if err := os.MkdirAll(config.CacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", config.CacheDir, err)
+	}
+
+	if cert, err := tls.LoadX509KeyPair(m.certPath(), m.keyPath()); err == nil {
+		m.certificate = &cert
+	}
+
+	return m, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.certificate == nil {
+		return nil, ErrNoCertificate
+	}
+
+	return m.certificate, nil
+}
+
+// TLSConfig returns a TLS configuration serving the current certificate
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: m.GetCertificate}
+}
+
+// ServeHTTP answers the HTTP-01 challenges of the pending orders
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, CHALLENGE_PATH)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.mu.Lock()
+	keyAuthorization, ok := m.challenges[token]
+	m.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, keyAuthorization)
+}
+
+// Run obtains the certificate if it is missing or due for renewal, and checks
+// again every CHECK_INTERVAL until the context is cancelled. Failed orders are
+// retried after RETRY_INTERVAL.
+//
+// ctx: the context controlling the manager lifecycle
+//
+// Example: go manager.Run(ctx)
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		wait := CHECK_INTERVAL
+		if m.due() {
+			if err := m.Obtain(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				m.config.OnLog(fmt.Sprintf("Error obtaining a certificate for %s: %v", strings.Join(m.config.Domains, ", "), err))
+				wait = RETRY_INTERVAL
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// due reports whether the certificate is missing or expires within RenewBefore
+func (m *Manager) due() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.certificate == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(m.certificate.Certificate[0])
+	if err != nil {
+		return true
+	}
+
+	return time.Until(leaf.NotAfter) < m.config.RenewBefore
+}
+
+// Obtain orders a new certificate for the domains and caches it.
+//
+// ctx: the context of the order, bounded by DEFAULT_ORDER_TIMEOUT
+//
+// Example: Obtain(ctx) = nil
+func (m *Manager) Obtain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, DEFAULT_ORDER_TIMEOUT)
+	defer cancel()
+
+	accountKey, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	c := &conn{manager: m, key: accountKey}
+	if err := c.getJSON(ctx, m.config.DirectoryURL, &c.directory); err != nil {
+		return fmt.Errorf("error reading the ACME directory: %w", err)
+	}
+
+	// The account is created, or found by its key
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if m.config.Email != "" {
+		account["contact"] = []string{"mailto:" + m.config.Email}
+	}
+	resp, err := c.post(ctx, c.directory.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("error registering the account: %w", err)
+	}
+	c.kid = resp.Header.Get("Location")
+
+	identifiers := []map[string]string{}
+	for _, domain := range m.config.Domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	var o order
+	resp, err = c.post(ctx, c.directory.NewOrder, map[string]any{"identifiers": identifiers}, &o)
+	if err != nil {
+		return fmt.Errorf("error creating the order: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(ctx, authzURL); err != nil {
+			return err
+		}
+	}
+
+	// The certificate key is new for every order
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.config.Domains[0]},
+		DNSNames: m.config.Domains,
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	if _, err := c.post(ctx, o.Finalize, map[string]string{"csr": encode(csr)}, &o); err != nil {
+		return fmt.Errorf("error finalizing the order: %w", err)
+	}
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return errors.New("the order was rejected")
+		}
+		if err := sleep(ctx, POLL_INTERVAL); err != nil {
+			return err
+		}
+		if _, err := c.post(ctx, orderURL, nil, &o); err != nil {
+			return fmt.Errorf("error polling the order: %w", err)
+		}
+	}
+
+	resp, err = c.post(ctx, o.Certificate, nil, nil)
+	if err != nil {
+		return fmt.Errorf("error downloading the certificate: %w", err)
+	}
+	chain := resp.body
+
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(chain, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	if err := os.WriteFile(m.keyPath(), keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.certPath(), chain, 0o600); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.certificate = &cert
+	m.mu.Unlock()
+	m.config.OnLog(fmt.Sprintf("Obtained a certificate for %s", strings.Join(m.config.Domains, ", ")))
+
+	return nil
+}
+
+// accountKey loads the cached account key, or generates and caches a new one
+func (m *Manager) accountKey() (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(m.config.CacheDir, "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// certPath and keyPath return the cached certificate chain and its key
+func (m *Manager) certPath() string {
+	return filepath.Join(m.config.CacheDir, m.config.Domains[0]+".crt")
+}
+
+func (m *Manager) keyPath() string {
+	return filepath.Join(m.config.CacheDir, m.config.Domains[0]+".key")
+}
+
+// conn is the state of the requests of an order
+type conn struct {
+	manager *Manager
+	// The account key and its URL, empty until the account is registered
+	key *ecdsa.PrivateKey
+	kid string
+	// The endpoints of the CA
+	directory directory
+	// The nonce of the next request
+	nonce string
+}
+
+// response is a response of the CA with its body read
+type response struct {
+	*http.Response
+	body []byte
+}
+
+// authorize completes the HTTP-01 challenge of an authorization
+func (c *conn) authorize(ctx context.Context, url string) error {
+	var authz authorization
+	if _, err := c.post(ctx, url, nil, &authz); err != nil {
+		return fmt.Errorf("error reading the authorization: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	for _, challenge := range authz.Challenges {
+		if challenge.Type != "http-01" {
+			continue
+		}
+
+		c.manager.mu.Lock()
+		c.manager.challenges[challenge.Token] = challenge.Token + "." + thumbprint(c.key)
+		c.manager.mu.Unlock()
+		defer func() {
+			c.manager.mu.Lock()
+			delete(c.manager.challenges, challenge.Token)
+			c.manager.mu.Unlock()
+		}()
+
+		if _, err := c.post(ctx, challenge.URL, struct{}{}, nil); err != nil {
+			return fmt.Errorf("error accepting the challenge for %s: %w", authz.Identifier.Value, err)
+		}
+		for authz.Status != "valid" {
+			if authz.Status == "invalid" {
+				return fmt.Errorf("the challenge for %s failed; is port 80 of the domain forwarded to the redirect server?", authz.Identifier.Value)
+			}
+			if err := sleep(ctx, POLL_INTERVAL); err != nil {
+				return err
+			}
+			if _, err := c.post(ctx, url, nil, &authz); err != nil {
+				return fmt.Errorf("error polling the authorization: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("the CA offered no http-01 challenge for %s", authz.Identifier.Value)
+}
+
+// post sends a signed request and decodes the JSON response into out if set. A nil
+// payload sends a POST-as-GET request. A rejected nonce is retried once.
+func (c *conn) post(ctx context.Context, url string, payload any, out any) (*response, error) {
+	for attempt := 0; ; attempt++ {
+		if c.nonce == "" {
+			if err := c.newNonce(ctx); err != nil {
+				return nil, err
+			}
+		}
+		body, err := sign(c.key, c.kid, c.nonce, url, payload)
+		if err != nil {
+			return nil, err
+		}
+		c.nonce = ""
+
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode >= 400 {
+			var p problem
+			json.Unmarshal(resp.body, &p)
+			if p.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("HTTP Status Code %d: %s", resp.StatusCode, p.Detail)
+		}
+		if out != nil {
+			if err := json.Unmarshal(resp.body, out); err != nil {
+				return nil, err
+			}
+		}
+
+		return resp, nil
+	}
+}
+
+// getJSON requests a document that needs no signature
+func (c *conn) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP Status Code %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(resp.body, out)
+}
+
+// newNonce requests a fresh nonce
+func (c *conn) newNonce(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", c.directory.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(req); err != nil {
+		return err
+	}
+	if c.nonce == "" {
+		return errors.New("the CA returned no nonce")
+	}
+
+	return nil
+}
+
+// do sends a request, reads its body, and keeps the nonce of the response
+func (c *conn) do(req *http.Request) (*response, error) {
+	resp, err := c.manager.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.nonce = nonce
+	}
+
+	return &response{Response: resp, body: body}, nil
+}
+
+// sleep waits for the duration or the context, whichever ends first
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// jwk returns the JSON Web Key of the account key, with its members in the
+// lexicographic order required for the thumbprint (RFC 7638)
+func jwk(key *ecdsa.PrivateKey) string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := key.X.FillBytes(make([]byte, size))
+	y := key.Y.FillBytes(make([]byte, size))
+
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, encode(x), encode(y))
+}
+
+// thumbprint returns the JWK thumbprint of the account key, which the key
+// authorizations of the challenges end with
+func thumbprint(key *ecdsa.PrivateKey) string {
+	sum := sha256.Sum256([]byte(jwk(key)))
+
+	return encode(sum[:])
+}
+
+// sign returns the flattened JWS of a request to the URL. The account key is
+// identified by its JWK until the account exists, and by the account URL (kid)
+// afterwards. A nil payload signs a POST-as-GET request.
+func sign(key *ecdsa.PrivateKey, kid string, nonce string, url string, payload any) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": nonce, "url": url}
+	if kid != "" {
+		protected["kid"] = kid
+	} else {
+		protected["jwk"] = json.RawMessage(jwk(key))
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	body := ""
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = encode(data)
+	}
+
+	signed := encode(header) + "." + body
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// ES256 signatures are the fixed size big endian r and s (RFC 7518)
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	return json.Marshal(map[string]string{
+		"protected": encode(header),
+		"payload":   body,
+		"signature": encode(signature),
+	})
+}
+
+// encode returns unpadded base64url
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
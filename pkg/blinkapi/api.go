@@ -0,0 +1,233 @@
+package blinkapi
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_TIMEOUT is the timeout of API requests when no HTTP client is configured
+const DEFAULT_TIMEOUT = 10 * time.Second
+
+// DEFAULT_MAX_IDLE_CONNS_PER_HOST is the number of idle keep-alive connections the
+// shared transport keeps per API host
+const DEFAULT_MAX_IDLE_CONNS_PER_HOST = 16
+
+// SharedTransport sends the requests of every BlinkAPI whose HTTP client has no
+// transport. Sharing it lets the clients of many cameras reuse the keep-alive
+// connections to the API instead of each opening its own.
+var SharedTransport = newSharedTransport()
+
+func newSharedTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = DEFAULT_MAX_IDLE_CONNS_PER_HOST
+
+	return transport
+}
+
+// Middleware wraps the transport of API requests, e.g. to log, retry, or measure
+// them, or to inject headers
+type Middleware = func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to an http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type APIConfig struct {
+	// Optional HTTP client sending the requests. Its timeout bounds every request
+	// except clip downloads (defaults to a client with DEFAULT_TIMEOUT). Without a
+	// transport, SharedTransport is used
+	HTTPClient *http.Client
+	// Optional base URL with a %s placeholder for the region, or a full URL used for
+	// every region (defaults to DEFAULT_BASE_URL). ClientCredentials.BaseURL
+	// overrides it per request
+	BaseURL string
+	// Middleware applied to every request, outermost first
+	Middleware []Middleware
+}
+
+// BlinkAPI sends requests to the Blink API through a configurable HTTP client and
+// middleware chain
+type BlinkAPI struct {
+	// The base URL with a %s placeholder for the region, or empty for DEFAULT_BASE_URL
+	baseURL string
+	// The client sending requests bounded by its timeout
+	client *http.Client
+	// The client sending downloads, which are bounded by their context instead
+	downloadClient *http.Client
+}
+
+// DefaultAPI is a BlinkAPI using the default configuration
+var DefaultAPI = NewBlinkAPI(APIConfig{})
+
+// NewBlinkAPI initializes a new BlinkAPI with the provided configuration.
+//
+// config: the API configuration
+//
+// Example: NewBlinkAPI(APIConfig{Middleware: []Middleware{RetryRequests(2, time.Second)}}) = &BlinkAPI{...}
+func NewBlinkAPI(config APIConfig) *BlinkAPI {
+	client := &http.Client{Timeout: DEFAULT_TIMEOUT}
+	if config.HTTPClient != nil {
+		copied := *config.HTTPClient
+		client = &copied
+	}
+
+	transport := client.Transport
+	if transport == nil {
+		transport = SharedTransport
+	}
+	for i := len(config.Middleware) - 1; i >= 0; i-- {
+		transport = config.Middleware[i](transport)
+	}
+	client.Transport = transport
+
+	downloadClient := *client
+	downloadClient.Timeout = 0
+
+	return &BlinkAPI{
+		baseURL:        config.BaseURL,
+		client:         client,
+		downloadClient: &downloadClient,
+	}
+}
+
+// regionURL returns the API URL of the region of the credentials. The base URL of
+// the credentials takes precedence over the one of the API.
+func (api *BlinkAPI) regionURL(cc ClientCredentials) string {
+	baseURL := cc.BaseURL
+	if baseURL == "" {
+		baseURL = api.baseURL
+	}
+	if baseURL == "" {
+		baseURL = DEFAULT_BASE_URL
+	}
+
+	// A full URL is used for every region
+	if strings.Contains(baseURL, "%s") {
+		baseURL = fmt.Sprintf(baseURL, cc.Region)
+	}
+
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// do sends the request through the middleware chain, bounded by the client timeout
+func (api *BlinkAPI) do(req *http.Request) (*http.Response, error) {
+	return api.client.Do(req)
+}
+
+// download sends the request through the middleware chain without a timeout
+func (api *BlinkAPI) download(req *http.Request) (*http.Response, error) {
+	return api.downloadClient.Do(req)
+}
+
+// RequestHeaders returns a middleware setting the headers on every request, e.g.
+// to identify a deployment to a proxy
+//
+// headers: the headers to set
+//
+// Example: RequestHeaders(map[string]string{"X-Deployment": "garage"}) = Middleware
+func RequestHeaders(headers map[string]string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// LogRequests returns a middleware logging the method, path, status, and duration
+// of every request. The token and query parameters are not logged.
+//
+// onLog: the callback receiving the log lines
+//
+// Example: LogRequests(log.Println) = Middleware
+func LogRequests(onLog func(string)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				onLog(fmt.Sprintf("%s %s failed after %s: %v", req.Method, req.URL.Path, time.Since(start).Round(time.Millisecond), err))
+				return resp, err
+			}
+
+			onLog(fmt.Sprintf("%s %s %d (%s)", req.Method, req.URL.Path, resp.StatusCode, time.Since(start).Round(time.Millisecond)))
+			return resp, err
+		})
+	}
+}
+
+// RetryRequests returns a middleware retrying requests that fail with a network
+// error or a 5xx or 429 status. Requests with a body are retried only if the body
+// can be replayed.
+//
+// retries: the number of retries after the first attempt
+//
+// delay: the delay before the first retry, doubling after each attempt
+//
+// Example: RetryRequests(2, time.Second) = Middleware
+func RetryRequests(retries int, delay time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for attempt := 0; ; attempt++ {
+				resp, err := next.RoundTrip(req)
+				retryable := err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+				if !retryable || attempt >= retries || (req.Body != nil && req.GetBody == nil) {
+					return resp, err
+				}
+				if resp != nil {
+					resp.Body.Close()
+				}
+
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(delay << attempt):
+				}
+
+				if req.GetBody != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req = req.Clone(req.Context())
+					req.Body = body
+				}
+			}
+		})
+	}
+}
+
+// MeasureRequests returns a middleware reporting the count and duration of requests
+// to the metrics backend, labelled by method and status
+//
+// m: the metrics backend
+//
+// Example: MeasureRequests(metrics.Noop) = Middleware
+func MeasureRequests(m metrics.Metrics) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+
+			status := "error"
+			if err == nil {
+				status = strconv.Itoa(resp.StatusCode)
+			}
+			m.Counter(metrics.API_REQUESTS_TOTAL, 1, metrics.Labels{"method": req.Method, "status": status})
+			m.Histogram(metrics.API_REQUEST_SECONDS, time.Since(start).Seconds(), metrics.Labels{"method": req.Method})
+
+			return resp, err
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package blinkapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestAPI serves the handler and returns a BlinkAPI sending requests to it, with
+// the region as the first path segment of the base URL
+func newTestAPI(t *testing.T, handler http.HandlerFunc) (*BlinkAPI, *httptest.Server) {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return NewBlinkAPI(APIConfig{HTTPClient: ts.Client(), BaseURL: ts.URL + "/%s"}), ts
+}
+
+func testCredentials(accountId int) ClientCredentials {
+	return ClientCredentials{
+		Region:     "u011",
+		ApiToken:   "token",
+		DeviceType: "camera",
+		AccountId:  accountId,
+		NetworkId:  3,
+		CameraId:   4,
+	}
+}
+
+// TestMiddlewareOrder checks that the middleware wraps the transport of the HTTP
+// client outermost first, and that the client of the configuration is not changed
+func TestMiddlewareOrder(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	named := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				record(name + " request")
+				resp, err := next.RoundTrip(req)
+				record(name + " response")
+				return resp, err
+			})
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("server " + r.Header.Get("X-Deployment"))
+		fmt.Fprint(w, `{"code": 902}`)
+	}))
+	defer ts.Close()
+
+	transport := ts.Client().Transport
+	client := &http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		record("transport")
+		return transport.RoundTrip(req)
+	})}
+	api := NewBlinkAPI(APIConfig{
+		HTTPClient: client,
+		BaseURL:    ts.URL + "/%s",
+		Middleware: []Middleware{named("outer"), RequestHeaders(map[string]string{"X-Deployment": "garage"}), named("inner")},
+	})
+
+	if err := api.StopCommand(testCredentials(1), 5); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"outer request", "inner request", "transport", "server garage", "inner response", "outer response"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls %q, want %q", calls, want)
+	}
+	if _, ok := client.Transport.(RoundTripperFunc); !ok || client.Timeout != 0 {
+		t.Error("NewBlinkAPI changed the configured HTTP client")
+	}
+}
+
+// TestBaseURL checks that requests go to the configured base URL of the region,
+// to the base URL of the credentials over it, and to DEFAULT_BASE_URL without one
+func TestBaseURL(t *testing.T) {
+	var path string
+	api, ts := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"code": 902}`)
+	})
+	if err := api.StopCommand(testCredentials(1), 5); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/u011/network/3/command/5/done" {
+		t.Errorf("requested %s", path)
+	}
+
+	// A full URL of the credentials is used for every region
+	cc := testCredentials(1)
+	cc.BaseURL = ts.URL + "/proxy/"
+	if err := api.StopCommand(cc, 5); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/proxy/network/3/command/5/done" {
+		t.Errorf("requested %s with the base URL of the credentials", path)
+	}
+
+	url, _ := NewBlinkAPI(APIConfig{}).CreatePollingURI(testCredentials(1), 5)
+	if want := fmt.Sprintf(DEFAULT_BASE_URL, "u011") + "/network/3/command/5"; url != want {
+		t.Errorf("CreatePollingURI = %s, want %s", url, want)
+	}
+}
+
+// TestInitiateLiveView checks the API version fallback and the errors of a
+// liveview request
+func TestInitiateLiveView(t *testing.T) {
+	tests := []struct {
+		name string
+		// The status and body of each requested API version
+		responses map[string]string
+		quality   string
+		// The API versions requested, in order
+		requested []string
+		err       string
+	}{
+		{
+			name:      "fallback",
+			responses: map[string]string{"v7": "404", "v5": `200 {"command_id": 8, "polling_interval": 1, "server": "immis://1.2.3.4:443/abc_1?client_id=9"}`},
+			requested: []string{"v7", "v5"},
+		},
+		{
+			name:      "no version available",
+			responses: map[string]string{"v7": "410", "v5": "404"},
+			requested: []string{"v7", "v5"},
+			err:       "API version v5 of camera_liveview is not available: error from API. HTTP Status Code 404",
+		},
+		{
+			name:      "server error",
+			responses: map[string]string{"v7": "500"},
+			requested: []string{"v7"},
+			err:       "error from API. HTTP Status Code 500",
+		},
+		{
+			name:      "no command",
+			responses: map[string]string{"v7": `200 {"message": "Camera is busy"}`},
+			requested: []string{"v7"},
+			err:       `error sending liveview command: {"message": "Camera is busy"}`,
+		},
+		{
+			name:      "invalid response",
+			responses: map[string]string{"v7": "200 <html>"},
+			requested: []string{"v7"},
+			err:       "invalid character '<' looking for beginning of value",
+		},
+		{
+			name:    "unsupported quality",
+			quality: "ultra",
+			err:     `unsupported liveview quality "ultra"`,
+		},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requested []string
+			api, _ := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				version := strings.Split(r.URL.Path, "/")[3]
+				requested = append(requested, version)
+				status, body, _ := strings.Cut(test.responses[version], " ")
+				var code int
+				fmt.Sscan(status, &code)
+				w.WriteHeader(code)
+				fmt.Fprint(w, body)
+			})
+
+			// Each test uses its own account, as the working version is remembered
+			cc := testCredentials(100 + i)
+			cc.ApiVersions = map[string][]int{ENDPOINT_CAMERA_LIVEVIEW: {7, 5}}
+			result, err := api.InitiateLiveView(cc, LiveviewInput{Quality: test.quality})
+			if !reflect.DeepEqual(requested, test.requested) {
+				t.Errorf("requested %v, want %v", requested, test.requested)
+			}
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("error %v, want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.CommandId != 8 || result.PollingInterval != 1 {
+				t.Errorf("result %+v", result)
+			}
+			if versions := APIVersions(cc, ENDPOINT_CAMERA_LIVEVIEW); versions[0] != 5 {
+				t.Errorf("versions %v after v5 worked, want v5 first", versions)
+			}
+		})
+	}
+}
+
+// TestPollCommand checks how polling maps the command responses to
+// POLL_COMPLETED, POLL_CANCELLED, and POLL_FAILED
+func TestPollCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		// The status and body of each poll
+		responses []string
+		cancel    bool
+		want      PollResult
+		err       string
+	}{
+		{
+			name:      "completed",
+			responses: []string{`200 {"complete": false}`, `200 {"complete": true, "status_code": 908, "message": "Command is stale"}`},
+			want:      PollResult{Outcome: POLL_COMPLETED, State: COMMAND_STATE_STALE, HttpStatus: 200, StatusCode: 908, Message: "Command is stale"},
+		},
+		{
+			name:      "HTTP error",
+			responses: []string{`401 {"code": 101, "message": "Unauthorized Access"}`},
+			want:      PollResult{Outcome: POLL_FAILED, State: COMMAND_STATE_UNKNOWN, HttpStatus: 401, Code: 101, Message: "Unauthorized Access"},
+			err:       "error polling command. HTTP Status Code 401",
+		},
+		{
+			name:      "invalid response",
+			responses: []string{"200 <html>"},
+			want:      PollResult{Outcome: POLL_FAILED, State: COMMAND_STATE_UNKNOWN, HttpStatus: 200},
+			err:       "invalid character '<' looking for beginning of value",
+		},
+		{
+			name:      "cancelled during a poll",
+			responses: []string{`200 {"complete": false}`},
+			cancel:    true,
+			want:      PollResult{Outcome: POLL_CANCELLED},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			polls := 0
+			api, _ := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/u011/network/3/command/5" || r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("polled %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+				}
+				if test.cancel {
+					// The poll fails because the context ended, not the API
+					cancel()
+					<-r.Context().Done()
+					return
+				}
+				status, body, _ := strings.Cut(test.responses[polls], " ")
+				polls++
+				var code int
+				fmt.Sscan(status, &code)
+				w.WriteHeader(code)
+				fmt.Fprint(w, body)
+			})
+
+			result := api.PollCommand(ctx, func() ClientCredentials { return testCredentials(1) }, 5, 1)
+			err := result.Err
+			result.Err = nil
+			if result != test.want {
+				t.Errorf("result %+v, want %+v", result, test.want)
+			}
+			if (err == nil) != (test.err == "") || (err != nil && err.Error() != test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+			if !test.cancel && polls != len(test.responses) {
+				t.Errorf("polled %d times, want %d", polls, len(test.responses))
+			}
+		})
+	}
+
+	t.Run("cancelled before polling", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		api, _ := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Error("polled after the context was cancelled")
+		})
+		if result := api.PollCommand(ctx, func() ClientCredentials { return testCredentials(1) }, 5, 1); result.Outcome != POLL_CANCELLED || result.Reason() != POLL_CANCELLED {
+			t.Errorf("result %+v, want %s", result, POLL_CANCELLED)
+		}
+	})
+}
+
+// TestStopCommand checks the errors of stopping a command
+func TestStopCommand(t *testing.T) {
+	tests := map[string]struct {
+		response string
+		err      string
+	}{
+		"done":        {`200 {"code": 902, "message": "Command is done"}`, ""},
+		"HTTP error":  {`404 {"code": 404}`, "cannot stop command. HTTP Status Code 404"},
+		"API error":   {`200 {"code": 907, "message": "Command not found"}`, "cannot stop command. API code 907 with message Command not found"},
+		"no response": {"200 ", "unexpected end of JSON input"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			api, _ := newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/u011/network/3/command/5/done" {
+					t.Errorf("requested %s %s", r.Method, r.URL.Path)
+				}
+				status, body, _ := strings.Cut(test.response, " ")
+				var code int
+				fmt.Sscan(status, &code)
+				w.WriteHeader(code)
+				fmt.Fprint(w, body)
+			})
+
+			err := api.StopCommand(testCredentials(1), 5)
+			if (err == nil) != (test.err == "") || (err != nil && err.Error() != test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		api, ts := newTestAPI(t, func(http.ResponseWriter, *http.Request) {})
+		ts.Close()
+		if err := api.StopCommand(testCredentials(1), 5); err == nil || !strings.HasPrefix(err.Error(), "cannot stop command: ") {
+			t.Errorf("error %v, want the request error", err)
+		}
+	})
+}
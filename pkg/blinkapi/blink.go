@@ -0,0 +1,594 @@
+package blinkapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DEFAULT_BASE_URL is the URL of the Blink API, with a %s placeholder for the region
+const DEFAULT_BASE_URL = "https://rest-%s.immedia-semi.com"
+
+// DEFAULT_LOCALE is the locale sent when none is configured
+const DEFAULT_LOCALE = "en_US"
+
+type ClientCredentials struct {
+	// Region to use for the API URL (e.g. "u011")
+	Region string
+	// Optional base URL of the API with a %s placeholder for the region, or a full
+	// URL used for every region (e.g. a debugging proxy). Defaults to the base URL of
+	// the BlinkAPI
+	BaseURL string
+	// Blink Authentication token to use for the API requests
+	ApiToken string
+	// Type of device to connect to (e.g. "owl"). Detected from the homescreen if empty
+	DeviceType string
+	// The ID of the account that the camera belongs to
+	AccountId int
+	// The ID of the network that the camera is associated with
+	NetworkId int
+	// The ID of the camera to connect to
+	CameraId int
+	// Locale sent with every request (e.g. "en_US"). Defaults to DEFAULT_LOCALE
+	Locale string
+	// Optional ISO 3166 country code sent with every request (e.g. "US")
+	Country string
+	// Optional IANA time zone sent with every request (e.g. "America/New_York")
+	TimeZone string
+	// Optional API versions to try per endpoint, overriding DEFAULT_API_VERSIONS
+	ApiVersions map[string][]int
+	// Optional identification of the client sent with every request
+	Identity ClientIdentity
+}
+
+// CreateLiveViewURIContext returns the live view path based on the device type, using the
+// preferred API version for the account. The device type is detected from the
+// homescreen if it is not set.
+//
+// ctx: the context of the request
+//
+// cc: the client credentials to use for building the URL
+//
+// Example: api.CreateLiveViewURIContext(ctx, ClientCredentials{...}) = ".../api/v5/accounts/X/networks/X/cameras/X/liveview"
+func (api *BlinkAPI) CreateLiveViewURIContext(ctx context.Context, cc ClientCredentials) (string, error) {
+	cc, err := api.withDeviceType(ctx, cc)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint, err := LiveViewEndpoint(cc.DeviceType)
+	if err != nil {
+		return "", err
+	}
+
+	return api.createLiveViewURI(cc, endpoint, APIVersions(cc, endpoint)[0]), nil
+}
+
+// CreateLiveViewURI is CreateLiveViewURIContext with a background context.
+//
+// Deprecated: use CreateLiveViewURIContext, which can be canceled.
+func (api *BlinkAPI) CreateLiveViewURI(cc ClientCredentials) (string, error) {
+	return api.CreateLiveViewURIContext(context.Background(), cc)
+}
+
+func (api *BlinkAPI) createLiveViewURI(cc ClientCredentials, endpoint string, version int) string {
+	var path string
+	switch endpoint {
+	case ENDPOINT_CAMERA_LIVEVIEW:
+		path = "/api/v%d/accounts/%d/networks/%d/cameras/%d/liveview"
+	case ENDPOINT_OWL_LIVEVIEW:
+		path = "/api/v%d/accounts/%d/networks/%d/owls/%d/liveview"
+	case ENDPOINT_DOORBELL_LIVEVIEW:
+		path = "/api/v%d/accounts/%d/networks/%d/doorbells/%d/liveview"
+	}
+
+	return fmt.Sprintf(api.regionURL(cc)+path, version, cc.AccountId, cc.NetworkId, cc.CameraId)
+}
+
+// CreatePollingURI returns the polling URL for the given command ID
+//
+// cc: the client credentials to use for building the URL
+//
+// commandId: the command ID to poll
+//
+// Example: api.CreatePollingURI(ClientCredentials{...}, 123) = ".../api/v5/networks/%d/command/%d"
+func (api *BlinkAPI) CreatePollingURI(cc ClientCredentials, commandId int) (string, error) {
+	return fmt.Sprintf(api.regionURL(cc)+"/network/%d/command/%d", cc.NetworkId, commandId), nil
+}
+
+// ParseConnectionString parses the connection string to extract the host, port,
+// client ID, and connection ID of the liveview server
+//
+// server: the connection string to parse
+//
+// Example: ParseConnectionString("immis://1.2.3.4:443/abcd1234_0?client_id=5") = "1.2.3.4", "443", 5, "abcd1234", nil
+//
+// Deprecated: use ParseConnectionInfo, which also returns the scheme and query.
+func ParseConnectionString(server string) (string, string, int, string, error) {
+	info, err := ParseConnectionInfo(server)
+	if err != nil {
+		return "", "", 0, "", err
+	}
+
+	return info.Host, info.Port, info.ClientId, info.ConnectionId, nil
+}
+
+// SetRequestHeaders appends the required headers to the request
+//
+// req: the request to append headers to
+//
+// cc: the client credentials providing the token, locale settings, and identity
+//
+// Example: SetRequestHeaders(req, ClientCredentials{...})
+func SetRequestHeaders(req *http.Request, cc ClientCredentials) {
+	locale := cc.Locale
+	if locale == "" {
+		locale = DEFAULT_LOCALE
+	}
+
+	req.Header.Set("locale", locale)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cc.ApiToken))
+	req.Header.Set("content-type", "application/json; charset=UTF-8")
+	if cc.Country != "" {
+		req.Header.Set("country", cc.Country)
+	}
+	if cc.TimeZone != "" {
+		req.Header.Set("x-blink-time-zone", cc.TimeZone)
+	}
+	cc.Identity.setHeaders(req)
+}
+
+type CommandResponse struct {
+	Code       CommandCode `json:"code"`
+	StatusCode CommandCode `json:"status_code"`
+	Message    string      `json:"message"`
+	Complete   bool        `json:"complete"`
+}
+
+// Outcomes of polling a command
+const (
+	// Blink marked the command as complete, e.g. because the camera ended the liveview
+	POLL_COMPLETED = "completed"
+	// Polling was stopped by the context
+	POLL_CANCELLED = "cancelled"
+	// Polling failed because the command could not be read
+	POLL_FAILED = "failed"
+)
+
+// Command status codes reported by Blink when it ends a command.
+//
+// Deprecated: use the CommandCode constants, e.g. CODE_COMMAND_STALE.
+const (
+	COMMAND_STATUS_STOPPED        = CODE_COMMAND_DONE
+	COMMAND_STATUS_STALE          = CODE_COMMAND_STALE
+	COMMAND_STATUS_TIMED_OUT      = CODE_COMMAND_TIMED_OUT
+	COMMAND_STATUS_CAMERA_OFFLINE = CODE_CAMERA_OFFLINE
+)
+
+// CommandState classifies why Blink ended or rejected a polled command
+type CommandState string
+
+// Command states. Stale and expired commands are worth replacing with a new one,
+// the others end the livestream for good.
+const (
+	// Blink completed the command without a specific reason, e.g. because the camera
+	// ended the liveview
+	COMMAND_STATE_COMPLETED CommandState = "completed"
+	// The command was stopped or superseded by another client
+	COMMAND_STATE_STOPPED CommandState = "stopped"
+	// The command went stale
+	COMMAND_STATE_STALE CommandState = "stale"
+	// The command timed out on the server or no longer exists
+	COMMAND_STATE_EXPIRED CommandState = "expired"
+	// The camera is offline
+	COMMAND_STATE_CAMERA_OFFLINE CommandState = "camera_offline"
+	// The command could not be read for another reason
+	COMMAND_STATE_UNKNOWN CommandState = "unknown"
+)
+
+// ClassifyCommand returns the state of a command from the response of polling it
+//
+// httpStatus: the HTTP status code of the poll
+//
+// statusCode: the command status code reported by Blink
+//
+// complete: whether Blink marked the command as complete
+//
+// Example: ClassifyCommand(200, CODE_COMMAND_STALE, true) = COMMAND_STATE_STALE
+func ClassifyCommand(httpStatus int, statusCode CommandCode, complete bool) CommandState {
+	switch statusCode {
+	case CODE_COMMAND_DONE:
+		return COMMAND_STATE_STOPPED
+	case CODE_COMMAND_STALE:
+		return COMMAND_STATE_STALE
+	case CODE_COMMAND_TIMED_OUT:
+		return COMMAND_STATE_EXPIRED
+	case CODE_CAMERA_OFFLINE:
+		return COMMAND_STATE_CAMERA_OFFLINE
+	}
+
+	switch httpStatus {
+	case http.StatusNotFound, http.StatusGone:
+		// The command is no longer known to the server
+		return COMMAND_STATE_EXPIRED
+	case http.StatusConflict:
+		return COMMAND_STATE_STOPPED
+	}
+
+	if complete {
+		return COMMAND_STATE_COMPLETED
+	}
+
+	return COMMAND_STATE_UNKNOWN
+}
+
+// Retryable returns whether a new command is likely to succeed where a command in
+// this state was ended
+func (s CommandState) Retryable() bool {
+	return s == COMMAND_STATE_STALE || s == COMMAND_STATE_EXPIRED
+}
+
+// Terminal returns whether the state ends the command for good, as opposed to a poll
+// that failed for an unknown reason
+func (s CommandState) Terminal() bool {
+	return s != "" && s != COMMAND_STATE_UNKNOWN
+}
+
+// PollResult describes how polling a command ended
+type PollResult struct {
+	// How polling ended (POLL_COMPLETED, POLL_CANCELLED, or POLL_FAILED)
+	Outcome string
+	// The state of the command (e.g. COMMAND_STATE_STALE). A failed poll has a
+	// terminal state when the response shows that the command is gone
+	State CommandState
+	// The HTTP status code of the last poll, if any
+	HttpStatus int
+	// The API code reported for the command (e.g. 0 for success)
+	Code CommandCode
+	// The command status code reported by Blink (e.g. CODE_COMMAND_STALE)
+	StatusCode CommandCode
+	// The message reported by Blink, if any
+	Message string
+	// The error that stopped polling, for POLL_FAILED
+	Err error
+}
+
+// Reason describes why polling ended
+func (r PollResult) Reason() string {
+	switch {
+	case r.Err != nil:
+		return r.Err.Error()
+	case r.Message != "":
+		return r.Message
+	case r.StatusCode != 0:
+		return r.StatusCode.String()
+	case r.State.Terminal():
+		return string(r.State)
+	}
+
+	return r.Outcome
+}
+
+// PollCommand will repeatedly poll the command URL with the provided token until
+// Blink marks the command as complete, polling fails, or the context is cancelled.
+//
+// ctx: the context to use for the command
+//
+// credentials: returns the client credentials to poll with. It is called before
+// every poll, so a rotated token is picked up by a running poller
+//
+// commandId: the command ID to poll
+//
+// pollInterval: the interval (in seconds) to poll the command at
+//
+// Example: api.PollCommand(ctx, func() ClientCredentials { return cc }, 123, 5) = PollResult{Outcome: POLL_COMPLETED, State: COMMAND_STATE_STALE, StatusCode: CODE_COMMAND_STALE}
+func (api *BlinkAPI) PollCommand(ctx context.Context, credentials func() ClientCredentials, commandId int, pollInterval int) PollResult {
+	ticker := time.NewTicker(time.Duration(pollInterval) * time.Second)
+	defer ticker.Stop()
+
+	failed := func(result PollResult, err error) PollResult {
+		if ctx.Err() != nil {
+			return PollResult{Outcome: POLL_CANCELLED}
+		}
+		result.Outcome = POLL_FAILED
+		result.Err = err
+
+		return result
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return PollResult{Outcome: POLL_CANCELLED}
+		case <-ticker.C:
+			cc := credentials()
+			url, err := api.CreatePollingURI(cc, commandId)
+			if err != nil {
+				return failed(PollResult{}, fmt.Errorf("error creating polling URL: %w", err))
+			}
+
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				return failed(PollResult{}, err)
+			}
+
+			SetRequestHeaders(req, cc)
+
+			resp, err := api.do(req)
+			if err != nil {
+				return failed(PollResult{}, fmt.Errorf("error polling command: %w", err))
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return failed(PollResult{HttpStatus: resp.StatusCode}, err)
+			}
+
+			// Error responses carry the API code and message in the same shape
+			var command CommandResponse
+			jsonErr := json.Unmarshal(body, &command)
+			result := PollResult{
+				State:      ClassifyCommand(resp.StatusCode, command.StatusCode, command.Complete),
+				HttpStatus: resp.StatusCode,
+				Code:       command.Code,
+				StatusCode: command.StatusCode,
+				Message:    command.Message,
+			}
+
+			switch {
+			case resp.StatusCode != http.StatusOK:
+				return failed(result, fmt.Errorf("error polling command. HTTP Status Code %d", resp.StatusCode))
+			case jsonErr != nil:
+				return failed(result, jsonErr)
+			case command.Complete:
+				result.Outcome = POLL_COMPLETED
+				return result
+			}
+		}
+	}
+}
+
+// INTENT_LIVEVIEW is the intent of a liveview request
+const INTENT_LIVEVIEW = "liveview"
+
+// Liveview stream qualities. QUALITY_AUTO leaves the choice to the camera, while
+// QUALITY_LOW requests the low bandwidth stream used by the app on cellular networks.
+const (
+	QUALITY_AUTO = "auto"
+	QUALITY_LOW  = "low"
+	QUALITY_HIGH = "high"
+)
+
+type LiveviewInput struct {
+	// The purpose of the request (defaults to INTENT_LIVEVIEW)
+	Intent string `json:"intent"`
+	// The requested stream quality. Omitted for QUALITY_AUTO
+	Quality string `json:"quality,omitempty"`
+}
+
+type LiveviewResponse struct {
+	CommandId       int    `json:"command_id"`
+	PollingInterval int    `json:"polling_interval"`
+	Server          string `json:"server"`
+}
+
+// InitiateLiveViewContext starts the liveview intention for the camera. The configured API
+// versions are tried in order until one is not rejected as unknown (HTTP 404 or 410),
+// and the working version is remembered for the account. The device type is detected
+// from the homescreen if it is not set.
+//
+// ctx: the context of the request
+//
+// cc: the client credentials to use for building the URL
+//
+// input: the intent parameters of the request (e.g. LiveviewInput{Quality: QUALITY_LOW})
+//
+// Example: api.InitiateLiveViewContext(ctx, ClientCredentials{...}, LiveviewInput{}) = &LiveviewResponse{...}, nil
+func (api *BlinkAPI) InitiateLiveViewContext(ctx context.Context, cc ClientCredentials, input LiveviewInput) (*LiveviewResponse, error) {
+	cc, err := api.withDeviceType(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Intent == "" {
+		input.Intent = INTENT_LIVEVIEW
+	}
+	switch input.Quality {
+	case QUALITY_AUTO:
+		input.Quality = ""
+	case "", QUALITY_LOW, QUALITY_HIGH:
+	default:
+		return nil, fmt.Errorf("unsupported liveview quality %q", input.Quality)
+	}
+
+	endpoint, err := LiveViewEndpoint(cc.DeviceType)
+	if err != nil {
+		return nil, fmt.Errorf("error getting liveview path: %w", err)
+	}
+
+	var lastErr error
+	for _, version := range APIVersions(cc, endpoint) {
+		result, status, err := api.sendLiveView(ctx, cc, api.createLiveViewURI(cc, endpoint, version), input)
+		if status == http.StatusNotFound || status == http.StatusGone {
+			lastErr = fmt.Errorf("API version v%d of %s is not available: %w", version, endpoint, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rememberAPIVersion(cc, endpoint, version)
+		return result, nil
+	}
+
+	return nil, lastErr
+}
+
+// InitiateLiveView is InitiateLiveViewContext with a background context.
+//
+// Deprecated: use InitiateLiveViewContext, which can be canceled.
+func (api *BlinkAPI) InitiateLiveView(cc ClientCredentials, input LiveviewInput) (*LiveviewResponse, error) {
+	return api.InitiateLiveViewContext(context.Background(), cc, input)
+}
+
+// sendLiveView sends the liveview command to the URL and returns the HTTP status code
+func (api *BlinkAPI) sendLiveView(ctx context.Context, cc ClientCredentials, url string, input LiveviewInput) (*LiveviewResponse, int, error) {
+	jsonBody, _ := json.Marshal(&input)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("error from API. HTTP Status Code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	var result LiveviewResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	} else if result.CommandId == 0 {
+		return nil, resp.StatusCode, fmt.Errorf("error sending liveview command: %s", body)
+	}
+
+	return &result, resp.StatusCode, nil
+}
+
+// StopCommandContext marks the command (liveview) as completed
+//
+// ctx: the context of the request
+//
+// cc: the client credentials to use for building the URL
+//
+// commandId: the command ID to stop
+//
+// Example: api.StopCommandContext(ctx, ClientCredentials{...}, 123)
+func (api *BlinkAPI) StopCommandContext(ctx context.Context, cc ClientCredentials, commandId int) error {
+	url, err := api.CreatePollingURI(cc, commandId)
+	if err != nil {
+		return fmt.Errorf("error creating polling URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url+"/done", nil)
+	if err != nil {
+		return err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return fmt.Errorf("cannot stop command: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot stop command. HTTP Status Code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var result CommandResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return err
+	}
+
+	if result.Code != CODE_COMMAND_DONE {
+		return fmt.Errorf("cannot stop command. API %s with message %s", result.Code, result.Message)
+	}
+
+	return nil
+}
+
+// COMMAND_LIVEVIEW is the command of a livestream in the command list of a network
+const COMMAND_LIVEVIEW = "lv_relay"
+
+// Command is a command listed for a network
+type Command struct {
+	Id int `json:"id"`
+	// The kind of command (e.g. COMMAND_LIVEVIEW)
+	Command string `json:"command"`
+	// The device the command targets (e.g. "camera") and its ID
+	Target   string `json:"target"`
+	TargetId int    `json:"target_id"`
+	// The stage and condition of the command (e.g. "lv" and "running")
+	StateStage     string    `json:"state_stage"`
+	StateCondition string    `json:"state_condition"`
+	Complete       bool      `json:"complete"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// InFlight reports whether Blink still considers the command active
+func (c Command) InFlight() bool {
+	return !c.Complete && c.StateCondition != "done"
+}
+
+type CommandsResponse struct {
+	Commands []Command `json:"commands"`
+}
+
+// ListCommandsContext returns the commands of the network of the credentials,
+// including those other clients started
+//
+// ctx: the context of the request
+//
+// cc: the client credentials to use for building the URL
+//
+// Example: api.ListCommandsContext(ctx, ClientCredentials{...}) = []Command{{Id: 123, Command: "lv_relay", ...}}, nil
+func (api *BlinkAPI) ListCommandsContext(ctx context.Context, cc ClientCredentials) ([]Command, error) {
+	uri := fmt.Sprintf(api.regionURL(cc)+"/network/%d/commands", cc.NetworkId)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error listing commands. HTTP Status Code %d", resp.StatusCode)
+	}
+
+	var result CommandsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding commands: %w", err)
+	}
+
+	return result.Commands, nil
+}
+
+// StopCommand is StopCommandContext with a background context.
+//
+// Deprecated: use StopCommandContext, which can be canceled.
+func (api *BlinkAPI) StopCommand(cc ClientCredentials, commandId int) error {
+	return api.StopCommandContext(context.Background(), cc, commandId)
+}
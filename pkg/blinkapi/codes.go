@@ -0,0 +1,73 @@
+package blinkapi
+
+import "fmt"
+
+// CommandCode is a code Blink reports in the code and status_code fields of a
+// command or an error response
+type CommandCode int
+
+// Known command codes
+const (
+	// The API token was rejected ("Unauthorized Access"). Logging in again is
+	// required
+	CODE_UNAUTHORIZED CommandCode = 101
+	// The system is busy with another command, e.g. a liveview of another client.
+	// Retrying after a short wait usually succeeds
+	CODE_SYSTEM_BUSY CommandCode = 307
+	// The command is done: the answer to stopping a command, and the status of a
+	// command stopped by another client, e.g. the liveview was closed in the app or
+	// replaced by a liveview of another client
+	CODE_COMMAND_DONE CommandCode = 902
+	// The command went stale. Requesting a new command usually resolves it
+	CODE_COMMAND_STALE CommandCode = 908
+	// The command ran into a server-side timeout
+	CODE_COMMAND_TIMED_OUT CommandCode = 909
+	// The camera did not respond to the command, e.g. because it is offline
+	CODE_CAMERA_OFFLINE CommandCode = 910
+)
+
+// commandCodes names the known command codes and whether a new command is likely
+// to succeed after each
+var commandCodes = map[CommandCode]struct {
+	name      string
+	retryable bool
+}{
+	CODE_UNAUTHORIZED:      {"unauthorized", false},
+	CODE_SYSTEM_BUSY:       {"system busy", true},
+	CODE_COMMAND_DONE:      {"command done", false},
+	CODE_COMMAND_STALE:     {"command stale", true},
+	CODE_COMMAND_TIMED_OUT: {"command timed out", true},
+	CODE_CAMERA_OFFLINE:    {"camera offline", false},
+}
+
+// String names the code, e.g. "command stale (908)"
+func (c CommandCode) String() string {
+	if known, ok := commandCodes[c]; ok {
+		return fmt.Sprintf("%s (%d)", known.name, int(c))
+	}
+
+	return fmt.Sprintf("code %d", int(c))
+}
+
+// Known returns whether the meaning of the code is known
+func (c CommandCode) Known() bool {
+	_, ok := commandCodes[c]
+
+	return ok
+}
+
+// Retryable returns whether a new command is likely to succeed where one ended or
+// was rejected with the code
+func (c CommandCode) Retryable() bool {
+	return commandCodes[c].retryable
+}
+
+// IsRetryable returns whether a new command is likely to succeed where one ended
+// or was rejected with the code
+//
+// code: the code or status code of the command
+//
+// Example: IsRetryable(908) = true
+func IsRetryable(code int) bool {
+	return CommandCode(code).Retryable()
+}
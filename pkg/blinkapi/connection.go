@@ -0,0 +1,107 @@
+package blinkapi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DEFAULT_LIVEVIEW_PORT is the port of a liveview server whose connection string
+// has none
+const DEFAULT_LIVEVIEW_PORT = "443"
+
+// DEFAULT_LIVEVIEW_SCHEME is assumed for a connection string without a scheme
+const DEFAULT_LIVEVIEW_SCHEME = "immis"
+
+// ErrInvalidConnection is wrapped by the errors of ParseConnectionInfo
+var ErrInvalidConnection = errors.New("invalid liveview connection string")
+
+// ConnectionInfo is the liveview server returned for a liveview command
+type ConnectionInfo struct {
+	// The scheme of the connection string (e.g. "immis" or "immis2"), lower case
+	Scheme string
+	// The host name or IP address of the server, without brackets for IPv6
+	Host string
+	// The port of the server (defaults to DEFAULT_LIVEVIEW_PORT)
+	Port string
+	// The path of the connection string (e.g. "/abcd1234_0")
+	Path string
+	// The connection ID sent in the authentication frame
+	ConnectionId string
+	// The client ID sent in the authentication frame
+	ClientId int
+	// Every query parameter of the connection string, including client_id
+	Query url.Values
+}
+
+// Address returns the host and port to dial (e.g. "1.2.3.4:443")
+func (ci ConnectionInfo) Address() string {
+	return net.JoinHostPort(ci.Host, ci.Port)
+}
+
+// ParseConnectionInfo parses the connection string of a liveview server. The
+// shapes returned by the regions differ, so the grammar is tolerant:
+//
+//   - The scheme is optional and any scheme is accepted (immis://, immis2://, ...)
+//   - The port is optional and defaults to DEFAULT_LIVEVIEW_PORT
+//   - The connection ID is the last path segment up to its first underscore
+//     ("abcd1234_0" or "abcd1234"), or the connection_id query parameter when the
+//     path is empty
+//   - The client ID is the client_id query parameter
+//
+// server: the connection string returned by the liveview command
+//
+// Example: ParseConnectionInfo("immis://1.2.3.4:443/abcd1234_0?client_id=5") = &ConnectionInfo{Host: "1.2.3.4", Port: "443", ConnectionId: "abcd1234", ClientId: 5, ...}, nil
+func ParseConnectionInfo(server string) (*ConnectionInfo, error) {
+	server = strings.TrimSpace(server)
+	if !strings.Contains(server, "://") {
+		server = DEFAULT_LIVEVIEW_SCHEME + "://" + strings.TrimPrefix(server, "//")
+	}
+
+	parsed, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConnection, err)
+	}
+	if parsed.Hostname() == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrInvalidConnection)
+	}
+
+	info := &ConnectionInfo{
+		Scheme: strings.ToLower(parsed.Scheme),
+		Host:   parsed.Hostname(),
+		Port:   parsed.Port(),
+		Path:   parsed.Path,
+		Query:  parsed.Query(),
+	}
+	if info.Port == "" {
+		info.Port = DEFAULT_LIVEVIEW_PORT
+	}
+	if port, err := strconv.Atoi(info.Port); err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidConnection, info.Port)
+	}
+
+	var segment string
+	for _, s := range strings.Split(parsed.Path, "/") {
+		if s != "" {
+			segment = s
+		}
+	}
+	if segment == "" {
+		segment = info.Query.Get("connection_id")
+	}
+	info.ConnectionId, _, _ = strings.Cut(segment, "_")
+	if info.ConnectionId == "" {
+		return nil, fmt.Errorf("%w: missing connection ID", ErrInvalidConnection)
+	}
+
+	clientId, err := strconv.Atoi(info.Query.Get("client_id"))
+	if err != nil || clientId <= 0 {
+		return nil, fmt.Errorf("%w: invalid client ID %q", ErrInvalidConnection, info.Query.Get("client_id"))
+	}
+	info.ClientId = clientId
+
+	return info, nil
+}
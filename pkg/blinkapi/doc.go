@@ -0,0 +1,10 @@
+// Package blinkapi is a client of the Blink REST API: region discovery, the
+// homescreen, livestream commands (initiate, poll, and stop), camera settings and
+// status, media changes, and the local storage of Sync Modules.
+//
+// Requests are sent by a BlinkAPI, configured with an HTTP client and middleware
+// (see APIConfig), on behalf of the account described by ClientCredentials. The
+// API interface covers the context-aware calls, so programs can depend on it and
+// substitute a fake in tests. The livestream connection itself is implemented by
+// pkg/liveview, which uses this package for the REST calls.
+package blinkapi
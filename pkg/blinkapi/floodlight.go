@@ -0,0 +1,147 @@
+package blinkapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Accepted range of the floodlight brightness, in percent
+const (
+	MIN_FLOODLIGHT_BRIGHTNESS = 1
+	MAX_FLOODLIGHT_BRIGHTNESS = 100
+)
+
+// ErrNoFloodlight is returned for cameras without a floodlight accessory
+var ErrNoFloodlight = errors.New("the camera has no floodlight")
+
+// HomescreenAccessory is an accessory attached to a camera, e.g. the light of the
+// Wired Floodlight Camera
+type HomescreenAccessory struct {
+	Id        int `json:"id"`
+	NetworkId int `json:"network_id"`
+	// The ID of the camera the accessory is attached to
+	TargetId int    `json:"target_id"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Enabled  bool   `json:"enabled"`
+	// The brightness of a floodlight in percent, if reported
+	Brightness int `json:"brightness"`
+}
+
+// HomescreenAccessories are the accessories of the account by kind
+type HomescreenAccessories struct {
+	// The floodlights of Wired Floodlight Cameras (accessory type "storm")
+	Storm []HomescreenAccessory `json:"storm"`
+}
+
+// FloodlightService switches the floodlights attached to cameras. BlinkAPI
+// implements it; a BlinkService standing in for Blink may implement it too.
+type FloodlightService interface {
+	// SetFloodlightContext switches a floodlight on or off
+	SetFloodlightContext(ctx context.Context, cc ClientCredentials, accessoryId int, on bool) error
+	// SetFloodlightBrightnessContext sets the brightness of a floodlight
+	SetFloodlightBrightnessContext(ctx context.Context, cc ClientCredentials, accessoryId int, brightness int) error
+}
+
+// Floodlight returns the floodlight attached to a camera
+//
+// cameraId: the ID of the camera
+//
+// Example: Floodlight(11111) = &HomescreenAccessory{Id: 321, TargetId: 11111, ...}, nil
+func (h *Homescreen) Floodlight(cameraId int) (*HomescreenAccessory, error) {
+	for i := range h.Accessories.Storm {
+		if h.Accessories.Storm[i].TargetId == cameraId {
+			return &h.Accessories.Storm[i], nil
+		}
+	}
+
+	return nil, ErrNoFloodlight
+}
+
+// SetFloodlightContext switches the floodlight attached to the camera of the
+// credentials on or off
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the camera
+//
+// accessoryId: the ID of the floodlight, see Homescreen.Floodlight
+//
+// on: whether the light is switched on
+//
+// Example: api.SetFloodlightContext(ctx, ClientCredentials{...}, 321, true) = nil
+func (api *BlinkAPI) SetFloodlightContext(ctx context.Context, cc ClientCredentials, accessoryId int, on bool) error {
+	action := "/lights/off"
+	if on {
+		action = "/lights/on"
+	}
+	if err := api.floodlightRequest(ctx, cc, accessoryId, action, nil); err != nil {
+		return fmt.Errorf("error switching floodlight: %w", err)
+	}
+
+	return nil
+}
+
+// SetFloodlightBrightnessContext sets the brightness of the floodlight attached to
+// the camera of the credentials
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the camera
+//
+// accessoryId: the ID of the floodlight, see Homescreen.Floodlight
+//
+// brightness: the brightness in percent, from MIN_FLOODLIGHT_BRIGHTNESS to
+// MAX_FLOODLIGHT_BRIGHTNESS
+//
+// Example: api.SetFloodlightBrightnessContext(ctx, ClientCredentials{...}, 321, 80) = nil
+func (api *BlinkAPI) SetFloodlightBrightnessContext(ctx context.Context, cc ClientCredentials, accessoryId int, brightness int) error {
+	if brightness < MIN_FLOODLIGHT_BRIGHTNESS || brightness > MAX_FLOODLIGHT_BRIGHTNESS {
+		return fmt.Errorf("brightness %d is not between %d and %d", brightness, MIN_FLOODLIGHT_BRIGHTNESS, MAX_FLOODLIGHT_BRIGHTNESS)
+	}
+
+	jsonBody, _ := json.Marshal(map[string]int{"brightness": brightness})
+	if err := api.floodlightRequest(ctx, cc, accessoryId, "/config", jsonBody); err != nil {
+		return fmt.Errorf("error setting floodlight brightness: %w", err)
+	}
+
+	return nil
+}
+
+// floodlightRequest sends a request to a floodlight accessory endpoint
+func (api *BlinkAPI) floodlightRequest(ctx context.Context, cc ClientCredentials, accessoryId int, action string, jsonBody []byte) error {
+	uri := fmt.Sprintf(api.regionURL(cc)+"/api/v1/accounts/%d/networks/%d/cameras/%d/accessories/storm/%d%s", cc.AccountId, cc.NetworkId, cc.CameraId, accessoryId, action)
+
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, reqBody)
+	if err != nil {
+		return err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNoFloodlight
+	}
+
+	return fmt.Errorf("HTTP Status Code %d", resp.StatusCode)
+}
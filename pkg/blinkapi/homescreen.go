@@ -0,0 +1,253 @@
+package blinkapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type HomescreenDevice struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	NetworkId int    `json:"network_id"`
+	Type      string `json:"type"`
+	Serial    string `json:"serial"`
+	Enabled   bool   `json:"enabled"`
+	Status    string `json:"status"`
+	FwVersion string `json:"fw_version"`
+	// Battery state ("ok" or "low"), empty for wired devices
+	Battery string            `json:"battery"`
+	Signals HomescreenSignals `json:"signals"`
+	// API path of the latest thumbnail of the camera (see CreateURL), with its Unix
+	// time in the ts query parameter
+	Thumbnail string `json:"thumbnail"`
+}
+
+// HomescreenSignals are the signal strengths reported by a device, in bars from 0 to 5,
+// and its temperature
+type HomescreenSignals struct {
+	// Signal strength between the camera and the sync module
+	Lfr int `json:"lfr"`
+	// Wi-Fi signal strength
+	Wifi int `json:"wifi"`
+	// Battery level
+	Battery int `json:"battery"`
+	// Temperature in degrees Fahrenheit
+	Temp int `json:"temp"`
+}
+
+type HomescreenNetwork struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Armed bool   `json:"armed"`
+	// IANA time zone of the network, e.g. "America/New_York"
+	TimeZone string `json:"time_zone"`
+}
+
+type HomescreenSyncModule struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	NetworkId int    `json:"network_id"`
+	Serial    string `json:"serial"`
+	Status    string `json:"status"`
+	FwVersion string `json:"fw_version"`
+	// Hardware model, e.g. "sm2"
+	Type string `json:"type"`
+	// Wi-Fi signal strength in bars from 0 to 5
+	WifiStrength        int    `json:"wifi_strength"`
+	LocalStorageEnabled bool   `json:"local_storage_enabled"`
+	LocalStorageStatus  string `json:"local_storage_status"`
+}
+
+type Homescreen struct {
+	Networks    []HomescreenNetwork    `json:"networks"`
+	SyncModules []HomescreenSyncModule `json:"sync_modules"`
+	Cameras     []HomescreenDevice     `json:"cameras"`
+	Owls        []HomescreenDevice     `json:"owls"`
+	Doorbells   []HomescreenDevice     `json:"doorbells"`
+	Accessories HomescreenAccessories  `json:"accessories"`
+}
+
+// GetHomescreenContext returns the account overview listing the networks and devices
+//
+// ctx: the context of the request
+//
+// cc: the client credentials to use for building the URL
+//
+// Example: api.GetHomescreenContext(ctx, ClientCredentials{...}) = &Homescreen{...}, nil
+func (api *BlinkAPI) GetHomescreenContext(ctx context.Context, cc ClientCredentials) (*Homescreen, error) {
+	uri := fmt.Sprintf(api.regionURL(cc)+"/api/v3/accounts/%d/homescreen", cc.AccountId)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting homescreen. HTTP Status Code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Homescreen
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetHomescreen is GetHomescreenContext with a background context.
+//
+// Deprecated: use GetHomescreenContext, which can be canceled.
+func (api *BlinkAPI) GetHomescreen(cc ClientCredentials) (*Homescreen, error) {
+	return api.GetHomescreenContext(context.Background(), cc)
+}
+
+// DeviceType returns the liveview device type of a camera listed on the homescreen
+//
+// cameraId: the ID of the camera
+//
+// networkId: the ID of the network the camera belongs to, or 0 to match any network
+//
+// Example: DeviceType(11111, 67890) = "owl", nil
+func (h *Homescreen) DeviceType(cameraId int, networkId int) (string, error) {
+	lists := []struct {
+		deviceType string
+		devices    []HomescreenDevice
+	}{
+		{"camera", h.Cameras},
+		{"owl", h.Owls},
+		{"doorbell", h.Doorbells},
+	}
+
+	for _, list := range lists {
+		for _, device := range list.devices {
+			if device.Id == cameraId && (networkId == 0 || device.NetworkId == networkId) {
+				return list.deviceType, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("camera %d not found on network %d", cameraId, networkId)
+}
+
+// Device returns a camera listed on the homescreen, of any device type
+//
+// cameraId: the ID of the camera
+//
+// networkId: the ID of the network the camera belongs to, or 0 to match any network
+//
+// Example: Device(11111, 67890) = &HomescreenDevice{...}, nil
+func (h *Homescreen) Device(cameraId int, networkId int) (*HomescreenDevice, error) {
+	for _, devices := range [][]HomescreenDevice{h.Cameras, h.Owls, h.Doorbells} {
+		for i := range devices {
+			if devices[i].Id == cameraId && (networkId == 0 || devices[i].NetworkId == networkId) {
+				return &devices[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("camera %d not found on network %d", cameraId, networkId)
+}
+
+// Network returns a network of the account
+//
+// networkId: the ID of the network
+//
+// Example: Network(67890) = &HomescreenNetwork{...}, nil
+func (h *Homescreen) Network(networkId int) (*HomescreenNetwork, error) {
+	for i := range h.Networks {
+		if h.Networks[i].Id == networkId {
+			return &h.Networks[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("network %d not found", networkId)
+}
+
+// Devices returns the cameras, owls, and doorbells of a network, or of every
+// network if networkId is 0
+//
+// networkId: the ID of the network, or 0
+//
+// Example: Devices(67890) = []HomescreenDevice{...}
+func (h *Homescreen) Devices(networkId int) []HomescreenDevice {
+	var devices []HomescreenDevice
+	for _, list := range [][]HomescreenDevice{h.Cameras, h.Owls, h.Doorbells} {
+		for _, device := range list {
+			if networkId == 0 || device.NetworkId == networkId {
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	return devices
+}
+
+// SyncModule returns the sync module of a network
+//
+// networkId: the ID of the network
+//
+// Example: SyncModule(67890) = &HomescreenSyncModule{...}, nil
+func (h *Homescreen) SyncModule(networkId int) (*HomescreenSyncModule, error) {
+	for i := range h.SyncModules {
+		if h.SyncModules[i].NetworkId == networkId {
+			return &h.SyncModules[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no sync module found on network %d", networkId)
+}
+
+// ResolveDeviceTypeContext looks up the device type of the camera in the credentials
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the camera
+//
+// Example: api.ResolveDeviceTypeContext(ctx, ClientCredentials{...}) = "owl", nil
+func (api *BlinkAPI) ResolveDeviceTypeContext(ctx context.Context, cc ClientCredentials) (string, error) {
+	homescreen, err := api.GetHomescreenContext(ctx, cc)
+	if err != nil {
+		return "", err
+	}
+
+	return homescreen.DeviceType(cc.CameraId, cc.NetworkId)
+}
+
+// ResolveDeviceType is ResolveDeviceTypeContext with a background context.
+//
+// Deprecated: use ResolveDeviceTypeContext, which can be canceled.
+func (api *BlinkAPI) ResolveDeviceType(cc ClientCredentials) (string, error) {
+	return api.ResolveDeviceTypeContext(context.Background(), cc)
+}
+
+// withDeviceType returns the credentials with the device type resolved from the
+// homescreen if it is not set
+func (api *BlinkAPI) withDeviceType(ctx context.Context, cc ClientCredentials) (ClientCredentials, error) {
+	if cc.DeviceType != "" {
+		return cc, nil
+	}
+
+	deviceType, err := api.ResolveDeviceTypeContext(ctx, cc)
+	if err != nil {
+		return cc, fmt.Errorf("error detecting device type: %w", err)
+	}
+	cc.DeviceType = deviceType
+
+	return cc, nil
+}
@@ -0,0 +1,66 @@
+package blinkapi
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Headers identifying the client, as sent by the Blink apps
+const (
+	HEADER_APP_BUILD   = "app-build"
+	HEADER_DEVICE_NAME = "x-blink-device-name"
+	HEADER_UNIQUE_ID   = "x-blink-unique-id"
+	HEADER_CLIENT_ID   = "x-blink-client-id"
+)
+
+// ClientIdentity identifies the deployment to the Blink API. Blink ties tokens to
+// the client that logged in, so sending the identity of that client makes it less
+// likely that sessions created by the middleware invalidate the token. Empty
+// fields are not sent.
+type ClientIdentity struct {
+	// The app build reported to Blink (e.g. "ANDROID_28373244")
+	AppBuild string
+	// The device name shown in the Blink app's list of clients (e.g. "Garage NVR")
+	DeviceName string
+	// A unique identifier of the deployment that stays the same across runs (see
+	// NewUniqueId)
+	UniqueId string
+	// The ID of the client the token was issued to
+	ClientId int
+	// Optional User-Agent replacing the default of the Go HTTP client
+	UserAgent string
+}
+
+// setHeaders sets the identification headers of the identity on the request
+func (i ClientIdentity) setHeaders(req *http.Request) {
+	for name, value := range map[string]string{
+		HEADER_APP_BUILD:   i.AppBuild,
+		HEADER_DEVICE_NAME: i.DeviceName,
+		HEADER_UNIQUE_ID:   i.UniqueId,
+		"User-Agent":       i.UserAgent,
+	} {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	if i.ClientId != 0 {
+		req.Header.Set(HEADER_CLIENT_ID, strconv.Itoa(i.ClientId))
+	}
+}
+
+// NewUniqueId generates a random identifier for ClientIdentity.UniqueId in the
+// UUID format used by the Blink apps
+//
+// Example: NewUniqueId() = "0b6e8a4f-3c1d-4f2a-9e7b-5d8c1a2b3c4d", nil
+func NewUniqueId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating unique ID: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // Version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
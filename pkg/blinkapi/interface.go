@@ -0,0 +1,53 @@
+package blinkapi
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// BlinkService is the part of the Blink API a livestream client depends on:
+// starting, polling, and stopping liveview commands, and the device lookups
+// around them. BlinkAPI implements it over REST; tests and alternative backends
+// (e.g. one replaying recorded responses) implement it to stand in for Blink.
+type BlinkService interface {
+	// GetHomescreenContext returns the networks, sync modules, and devices of the account
+	GetHomescreenContext(ctx context.Context, cc ClientCredentials) (*Homescreen, error)
+	// ResolveDeviceTypeContext returns the device type of the camera of the credentials
+	ResolveDeviceTypeContext(ctx context.Context, cc ClientCredentials) (string, error)
+	// InitiateLiveViewContext starts a livestream command for the camera
+	InitiateLiveViewContext(ctx context.Context, cc ClientCredentials, input LiveviewInput) (*LiveviewResponse, error)
+	// PollCommand polls a command until it is ready, fails, or the context is done
+	PollCommand(ctx context.Context, credentials func() ClientCredentials, commandId int, pollInterval int) PollResult
+	// StopCommandContext stops a command
+	StopCommandContext(ctx context.Context, cc ClientCredentials, commandId int) error
+	// ListCommandsContext returns the commands of the network
+	ListCommandsContext(ctx context.Context, cc ClientCredentials) ([]Command, error)
+	// GetCameraStatusContext returns the battery, signal, and temperature of the camera
+	GetCameraStatusContext(ctx context.Context, cc ClientCredentials) (*CameraStatus, error)
+}
+
+// API is the Blink REST API as implemented by BlinkAPI
+type API interface {
+	BlinkService
+	PTZService
+	FloodlightService
+	// ResolveRegionContext returns the region of the account
+	ResolveRegionContext(ctx context.Context, apiToken string, accountId int) (string, error)
+	// CheckAccount returns nil if the API is reachable and accepts the token
+	CheckAccount(ctx context.Context, cc ClientCredentials) error
+	// GetCameraSettingsContext returns the settings of the camera
+	GetCameraSettingsContext(ctx context.Context, cc ClientCredentials) (*CameraSettings, error)
+	// UpdateCameraSettingsContext changes the settings of the camera
+	UpdateCameraSettingsContext(ctx context.Context, cc ClientCredentials, update CameraSettingsUpdate) error
+	// GetChangedMediaContext returns a page of the media changed since the time
+	GetChangedMediaContext(ctx context.Context, cc ClientCredentials, since time.Time, page int) (*MediaResponse, error)
+	// DownloadMediaContext writes a thumbnail or clip of the account to the writer
+	DownloadMediaContext(ctx context.Context, cc ClientCredentials, uri string, writer io.Writer) error
+	// ListLocalStorageClips returns the manifest of the clips stored on a sync module
+	ListLocalStorageClips(ctx context.Context, cc ClientCredentials, syncModuleId int) (*LocalStorageManifest, error)
+	// DownloadLocalStorageClip writes a clip stored on a sync module to the writer
+	DownloadLocalStorageClip(ctx context.Context, cc ClientCredentials, syncModuleId int, manifestId string, clipId string, writer io.Writer) error
+}
+
+var _ API = (*BlinkAPI)(nil)
@@ -0,0 +1,235 @@
+package blinkapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LOCAL_STORAGE_POLL_INTERVAL is the interval between checks for a manifest or clip
+// the sync module is still uploading
+const LOCAL_STORAGE_POLL_INTERVAL = 2 * time.Second
+
+type LocalStorageClip struct {
+	Id         string      `json:"id"`
+	Size       json.Number `json:"size"`
+	CameraName string      `json:"camera_name"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+type LocalStorageManifest struct {
+	Version    string             `json:"version"`
+	ManifestId string             `json:"manifest_id"`
+	Clips      []LocalStorageClip `json:"clips"`
+}
+
+type localStorageRequest struct {
+	Id int `json:"id"`
+}
+
+// createLocalStorageURI returns the local storage URL of a sync module
+func (api *BlinkAPI) createLocalStorageURI(cc ClientCredentials, syncModuleId int, path string) string {
+	return fmt.Sprintf(api.regionURL(cc)+"/api/v1/accounts/%d/networks/%d/sync_modules/%d/local_storage", cc.AccountId, cc.NetworkId, syncModuleId) + path
+}
+
+// RequestLocalStorageManifestContext asks the sync module to upload the manifest of the
+// clips on its local storage, and returns the ID of the manifest request
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the network
+//
+// syncModuleId: the ID of the sync module
+//
+// Example: api.RequestLocalStorageManifestContext(ctx, ClientCredentials{...}, 123) = 456, nil
+func (api *BlinkAPI) RequestLocalStorageManifestContext(ctx context.Context, cc ClientCredentials, syncModuleId int) (int, error) {
+	body, err := api.localStorageRequestJSON(ctx, cc, "POST", api.createLocalStorageURI(cc, syncModuleId, "/manifest/request"))
+	if err != nil {
+		return 0, fmt.Errorf("error requesting manifest: %w", err)
+	}
+
+	var result localStorageRequest
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, err
+	}
+	if result.Id == 0 {
+		return 0, fmt.Errorf("error requesting manifest: %s", body)
+	}
+
+	return result.Id, nil
+}
+
+// RequestLocalStorageManifest is RequestLocalStorageManifestContext with a background context.
+//
+// Deprecated: use RequestLocalStorageManifestContext, which can be canceled.
+func (api *BlinkAPI) RequestLocalStorageManifest(cc ClientCredentials, syncModuleId int) (int, error) {
+	return api.RequestLocalStorageManifestContext(context.Background(), cc, syncModuleId)
+}
+
+// GetLocalStorageManifestContext returns the manifest of a manifest request, or nil if the
+// sync module has not uploaded it yet
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the network
+//
+// syncModuleId: the ID of the sync module
+//
+// requestId: the ID returned by RequestLocalStorageManifest
+//
+// Example: api.GetLocalStorageManifestContext(ctx, ClientCredentials{...}, 123, 456) = &LocalStorageManifest{...}, nil
+func (api *BlinkAPI) GetLocalStorageManifestContext(ctx context.Context, cc ClientCredentials, syncModuleId int, requestId int) (*LocalStorageManifest, error) {
+	body, err := api.localStorageRequestJSON(ctx, cc, "GET", api.createLocalStorageURI(cc, syncModuleId, fmt.Sprintf("/manifest/request/%d", requestId)))
+	if err != nil {
+		return nil, fmt.Errorf("error getting manifest: %w", err)
+	}
+
+	var result LocalStorageManifest
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if result.ManifestId == "" {
+		return nil, nil
+	}
+
+	return &result, nil
+}
+
+// GetLocalStorageManifest is GetLocalStorageManifestContext with a background context.
+//
+// Deprecated: use GetLocalStorageManifestContext, which can be canceled.
+func (api *BlinkAPI) GetLocalStorageManifest(cc ClientCredentials, syncModuleId int, requestId int) (*LocalStorageManifest, error) {
+	return api.GetLocalStorageManifestContext(context.Background(), cc, syncModuleId, requestId)
+}
+
+// ListLocalStorageClips requests the manifest of the clips on the sync module's
+// local storage and waits until it is available
+//
+// ctx: the context bounding the wait
+//
+// cc: the client credentials identifying the network
+//
+// syncModuleId: the ID of the sync module
+//
+// Example: api.ListLocalStorageClips(ctx, ClientCredentials{...}, 123) = &LocalStorageManifest{...}, nil
+func (api *BlinkAPI) ListLocalStorageClips(ctx context.Context, cc ClientCredentials, syncModuleId int) (*LocalStorageManifest, error) {
+	requestId, err := api.RequestLocalStorageManifestContext(ctx, cc, syncModuleId)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(LOCAL_STORAGE_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for manifest: %w", ctx.Err())
+		case <-ticker.C:
+			manifest, err := api.GetLocalStorageManifestContext(ctx, cc, syncModuleId, requestId)
+			if err != nil {
+				return nil, err
+			}
+			if manifest != nil {
+				return manifest, nil
+			}
+		}
+	}
+}
+
+// DownloadLocalStorageClip asks the sync module to upload a clip from its local
+// storage, waits until it is available, and writes the MP4 data to the writer
+//
+// ctx: the context bounding the wait and download
+//
+// cc: the client credentials identifying the network
+//
+// syncModuleId: the ID of the sync module
+//
+// manifestId: the ID of the manifest listing the clip
+//
+// clipId: the ID of the clip
+//
+// writer: the writer receiving the clip
+//
+// Example: api.DownloadLocalStorageClip(ctx, ClientCredentials{...}, 123, "abc", "def", file) = nil
+func (api *BlinkAPI) DownloadLocalStorageClip(ctx context.Context, cc ClientCredentials, syncModuleId int, manifestId string, clipId string, writer io.Writer) error {
+	uri := api.createLocalStorageURI(cc, syncModuleId, fmt.Sprintf("/manifest/%s/clip/request/%s", manifestId, clipId))
+	if _, err := api.localStorageRequestJSON(ctx, cc, "POST", uri); err != nil {
+		return fmt.Errorf("error requesting clip: %w", err)
+	}
+
+	ticker := time.NewTicker(LOCAL_STORAGE_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for clip: %w", ctx.Err())
+		case <-ticker.C:
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		if err != nil {
+			return err
+		}
+
+		SetRequestHeaders(req, cc)
+
+		// Clips can be large; the context bounds the download instead of a timeout
+		resp, err := api.download(req)
+		if err != nil {
+			return fmt.Errorf("error from API: %w", err)
+		}
+
+		// The clip is answered with JSON until the sync module has uploaded it
+		if resp.StatusCode == http.StatusNotFound || strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			resp.Body.Close()
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("error downloading clip. HTTP Status Code %d", resp.StatusCode)
+		}
+
+		_, err = io.Copy(writer, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error downloading clip: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// localStorageRequestJSON sends a request to a local storage endpoint and returns
+// the response body
+func (api *BlinkAPI) localStorageRequestJSON(ctx context.Context, cc ClientCredentials, method string, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP Status Code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
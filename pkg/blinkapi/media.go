@@ -0,0 +1,139 @@
+package blinkapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type Media struct {
+	Id          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Deleted     bool      `json:"deleted"`
+	Device      string    `json:"device"`
+	DeviceId    int       `json:"device_id"`
+	DeviceName  string    `json:"device_name"`
+	NetworkId   int       `json:"network_id"`
+	NetworkName string    `json:"network_name"`
+	Type        string    `json:"type"`
+	Source      string    `json:"source"`
+	Thumbnail   string    `json:"thumbnail"`
+	Media       string    `json:"media"`
+}
+
+type MediaResponse struct {
+	Limit int     `json:"limit"`
+	Media []Media `json:"media"`
+}
+
+// CreateURL returns the absolute URL of an API path returned by Blink (e.g. a
+// thumbnail or clip path)
+//
+// cc: the client credentials providing the region
+//
+// path: the API path
+//
+// Example: api.CreateURL(ClientCredentials{...}, "/api/v2/accounts/1/media/thumb/x") = "https://rest-u011.immedia-semi.com/api/v2/accounts/1/media/thumb/x"
+func (api *BlinkAPI) CreateURL(cc ClientCredentials, path string) string {
+	if path == "" {
+		return ""
+	}
+
+	return api.regionURL(cc) + path
+}
+
+// GetChangedMediaContext returns a page of the media (motion clips) created or updated
+// since the provided time
+//
+// ctx: the context of the request
+//
+// cc: the client credentials to use for building the URL
+//
+// since: only media changed after this time is returned
+//
+// page: the page to fetch, starting at 1
+//
+// Example: api.GetChangedMediaContext(ctx, ClientCredentials{...}, time.Now().Add(-time.Hour), 1) = &MediaResponse{...}, nil
+func (api *BlinkAPI) GetChangedMediaContext(ctx context.Context, cc ClientCredentials, since time.Time, page int) (*MediaResponse, error) {
+	query := url.Values{}
+	query.Set("since", since.UTC().Format(time.RFC3339))
+	query.Set("page", fmt.Sprint(page))
+	uri := fmt.Sprintf(api.regionURL(cc)+"/api/v1/accounts/%d/media/changed?%s", cc.AccountId, query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error listing media. HTTP Status Code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result MediaResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// DownloadMediaContext writes a thumbnail or clip of the account to the writer
+//
+// ctx: the context bounding the download
+//
+// cc: the client credentials authorizing the request
+//
+// uri: the absolute URL of the media, e.g. from CreateURL
+//
+// writer: the writer receiving the media
+//
+// Example: api.DownloadMediaContext(ctx, ClientCredentials{...}, event.ThumbnailURL, file) = nil
+func (api *BlinkAPI) DownloadMediaContext(ctx context.Context, cc ClientCredentials, uri string, writer io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	// Clips can be large; the context bounds the download instead of a timeout
+	resp, err := api.download(req)
+	if err != nil {
+		return fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading media. HTTP Status Code %d", resp.StatusCode)
+	}
+	if _, err := io.Copy(writer, resp.Body); err != nil {
+		return fmt.Errorf("error downloading media: %w", err)
+	}
+
+	return nil
+}
+
+// GetChangedMedia is GetChangedMediaContext with a background context.
+//
+// Deprecated: use GetChangedMediaContext, which can be canceled.
+func (api *BlinkAPI) GetChangedMedia(cc ClientCredentials, since time.Time, page int) (*MediaResponse, error) {
+	return api.GetChangedMediaContext(context.Background(), cc, since, page)
+}
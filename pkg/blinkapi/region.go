@@ -0,0 +1,109 @@
+package blinkapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// KNOWN_REGIONS are the regions probed by ResolveRegion, most common first
+var KNOWN_REGIONS = []string{
+	"u011", "u014", "u001", "u002", "u003", "u004", "u005", "u006", "u007", "u008",
+	"u009", "u010", "u012", "u013", "u015", "u016", "u017",
+	"e001", "e002", "e003", "e004", "e005", "e006",
+	"prod",
+}
+
+// ErrUnauthorized is returned by CheckAccount and the camera settings requests when
+// Blink rejects the token
+var ErrUnauthorized = errors.New("the API token was rejected")
+
+// ResolveRegionContext discovers the region of an account by probing the account endpoint
+// of each known region concurrently. The first region that accepts the token for the
+// account is returned.
+//
+// ctx: the context of the request
+//
+// apiToken: the Blink API token
+//
+// accountId: the ID of the account
+//
+// Example: api.ResolveRegionContext(ctx, "abc", 12345) = "u011", nil
+func (api *BlinkAPI) ResolveRegionContext(ctx context.Context, apiToken string, accountId int) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type probe struct {
+		region string
+		err    error
+	}
+	results := make(chan probe, len(KNOWN_REGIONS))
+	for _, region := range KNOWN_REGIONS {
+		go func() {
+			results <- probe{region, api.CheckAccount(ctx, ClientCredentials{
+				Region:    region,
+				ApiToken:  apiToken,
+				AccountId: accountId,
+			})}
+		}()
+	}
+
+	unauthorized := false
+	for range KNOWN_REGIONS {
+		result := <-results
+		if result.err == nil {
+			return result.region, nil
+		}
+		if result.err == ErrUnauthorized {
+			unauthorized = true
+		}
+	}
+
+	if unauthorized {
+		return "", fmt.Errorf("account %d was not found in any known region; check the API token", accountId)
+	}
+
+	return "", fmt.Errorf("account %d was not found in any known region", accountId)
+}
+
+// ResolveRegion is ResolveRegionContext with a background context.
+//
+// Deprecated: use ResolveRegionContext, which can be canceled.
+func (api *BlinkAPI) ResolveRegion(apiToken string, accountId int) (string, error) {
+	return api.ResolveRegionContext(context.Background(), apiToken, accountId)
+}
+
+// CheckAccount verifies that the API of the region in the credentials is reachable
+// and accepts the token for the account
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the account
+//
+// Example: CheckAccount(ctx, ClientCredentials{...}) = ErrUnauthorized
+func (api *BlinkAPI) CheckAccount(ctx context.Context, cc ClientCredentials) error {
+	uri := fmt.Sprintf(api.regionURL(cc)+"/api/v3/accounts/%d/homescreen", cc.AccountId)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	}
+
+	return fmt.Errorf("HTTP Status Code %d", resp.StatusCode)
+}
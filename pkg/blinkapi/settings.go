@@ -0,0 +1,262 @@
+package blinkapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// Values of CameraSettings.VideoQuality
+const (
+	VIDEO_QUALITY_SAVER    = "saver"
+	VIDEO_QUALITY_STANDARD = "standard"
+	VIDEO_QUALITY_BEST     = "best"
+)
+
+// Values of CameraSettings.StatusLED
+const (
+	STATUS_LED_ON   = "on"
+	STATUS_LED_OFF  = "off"
+	STATUS_LED_AUTO = "auto"
+)
+
+// Accepted ranges of the numeric camera settings
+const (
+	MIN_MOTION_SENSITIVITY = 1
+	MAX_MOTION_SENSITIVITY = 9
+	MIN_CLIP_LENGTH        = 5
+	MAX_CLIP_LENGTH        = 60
+)
+
+type CameraSettings struct {
+	// The camera name
+	Name string `json:"name"`
+	// Motion sensitivity, from MIN_MOTION_SENSITIVITY to MAX_MOTION_SENSITIVITY
+	MotionSensitivity int `json:"motion_sensitivity"`
+	// Status LED mode (e.g. STATUS_LED_ON)
+	StatusLED string `json:"led_state"`
+	// Recording quality of clips (e.g. VIDEO_QUALITY_BEST)
+	VideoQuality string `json:"video_quality"`
+	// Maximum length of motion clips in seconds
+	ClipLength int `json:"clip_length"`
+	// Whether motion detection is enabled
+	MotionAlert bool `json:"motion_alert"`
+	// Whether audio is recorded with clips
+	RecordAudio bool `json:"record_audio_enable"`
+}
+
+// CameraSettingsUpdate lists the settings to change. Nil fields are left unchanged.
+type CameraSettingsUpdate struct {
+	MotionSensitivity *int    `json:"motion_sensitivity,omitempty"`
+	StatusLED         *string `json:"led_state,omitempty"`
+	VideoQuality      *string `json:"video_quality,omitempty"`
+	ClipLength        *int    `json:"clip_length,omitempty"`
+}
+
+// Validate checks the values of the update before they are sent
+func (u CameraSettingsUpdate) Validate() error {
+	if u.MotionSensitivity != nil && (*u.MotionSensitivity < MIN_MOTION_SENSITIVITY || *u.MotionSensitivity > MAX_MOTION_SENSITIVITY) {
+		return fmt.Errorf("motion sensitivity %d is not between %d and %d", *u.MotionSensitivity, MIN_MOTION_SENSITIVITY, MAX_MOTION_SENSITIVITY)
+	}
+	if u.StatusLED != nil && !slices.Contains([]string{STATUS_LED_ON, STATUS_LED_OFF, STATUS_LED_AUTO}, *u.StatusLED) {
+		return fmt.Errorf("unsupported status LED mode %q", *u.StatusLED)
+	}
+	if u.VideoQuality != nil && !slices.Contains([]string{VIDEO_QUALITY_SAVER, VIDEO_QUALITY_STANDARD, VIDEO_QUALITY_BEST}, *u.VideoQuality) {
+		return fmt.Errorf("unsupported video quality %q", *u.VideoQuality)
+	}
+	if u.ClipLength != nil && (*u.ClipLength < MIN_CLIP_LENGTH || *u.ClipLength > MAX_CLIP_LENGTH) {
+		return fmt.Errorf("clip length %d is not between %d and %d seconds", *u.ClipLength, MIN_CLIP_LENGTH, MAX_CLIP_LENGTH)
+	}
+
+	return nil
+}
+
+// createSettingsURI returns the URL reading (update false) or changing (update true)
+// the settings of the camera, which differs between device types
+func (api *BlinkAPI) createSettingsURI(cc ClientCredentials, update bool) (string, error) {
+	switch cc.DeviceType {
+	case "camera":
+		action := "config"
+		if update {
+			action = "update"
+		}
+		return fmt.Sprintf(api.regionURL(cc)+"/network/%d/camera/%d/%s", cc.NetworkId, cc.CameraId, action), nil
+	case "owl", "hawk":
+		return fmt.Sprintf(api.regionURL(cc)+"/api/v1/accounts/%d/networks/%d/owls/%d/config", cc.AccountId, cc.NetworkId, cc.CameraId), nil
+	case "doorbell", "lotus":
+		return fmt.Sprintf(api.regionURL(cc)+"/api/v1/accounts/%d/networks/%d/doorbells/%d/config", cc.AccountId, cc.NetworkId, cc.CameraId), nil
+	}
+
+	return "", fmt.Errorf("cannot build settings path for unknown device type: %s", cc.DeviceType)
+}
+
+// GetCameraSettingsContext returns the settings of the camera. The device type is detected
+// from the homescreen if it is not set.
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the camera
+//
+// Example: api.GetCameraSettingsContext(ctx, ClientCredentials{...}) = &CameraSettings{...}, nil
+func (api *BlinkAPI) GetCameraSettingsContext(ctx context.Context, cc ClientCredentials) (*CameraSettings, error) {
+	cc, err := api.withDeviceType(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	uri, err := api.createSettingsURI(cc, false)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := api.settingsRequest(ctx, cc, "GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting camera settings: %w", err)
+	}
+
+	// Cameras wrap their settings in a list, owls and doorbells do not
+	if cc.DeviceType == "camera" {
+		var result struct {
+			Camera []CameraSettings `json:"camera"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		if len(result.Camera) == 0 {
+			return nil, fmt.Errorf("no settings returned for camera %d", cc.CameraId)
+		}
+		return &result.Camera[0], nil
+	}
+
+	var result CameraSettings
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetCameraSettings is GetCameraSettingsContext with a background context.
+//
+// Deprecated: use GetCameraSettingsContext, which can be canceled.
+func (api *BlinkAPI) GetCameraSettings(cc ClientCredentials) (*CameraSettings, error) {
+	return api.GetCameraSettingsContext(context.Background(), cc)
+}
+
+// UpdateCameraSettingsContext changes the settings of the camera that are set in the update.
+// The device type is detected from the homescreen if it is not set.
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the camera
+//
+// update: the settings to change
+//
+// Example: api.UpdateCameraSettingsContext(ctx, ClientCredentials{...}, CameraSettingsUpdate{ClipLength: &length}) = nil
+func (api *BlinkAPI) UpdateCameraSettingsContext(ctx context.Context, cc ClientCredentials, update CameraSettingsUpdate) error {
+	if err := update.Validate(); err != nil {
+		return err
+	}
+
+	cc, err := api.withDeviceType(ctx, cc)
+	if err != nil {
+		return err
+	}
+
+	uri, err := api.createSettingsURI(cc, true)
+	if err != nil {
+		return err
+	}
+
+	jsonBody, _ := json.Marshal(&update)
+	if _, err := api.settingsRequest(ctx, cc, "POST", uri, jsonBody); err != nil {
+		return fmt.Errorf("error updating camera settings: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateCameraSettings is UpdateCameraSettingsContext with a background context.
+//
+// Deprecated: use UpdateCameraSettingsContext, which can be canceled.
+func (api *BlinkAPI) UpdateCameraSettings(cc ClientCredentials, update CameraSettingsUpdate) error {
+	return api.UpdateCameraSettingsContext(context.Background(), cc, update)
+}
+
+// settingsRequest sends a settings request and returns the response body
+func (api *BlinkAPI) settingsRequest(ctx context.Context, cc ClientCredentials, method string, uri string, jsonBody []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	SetRequestHeaders(req, cc)
+
+	resp, err := api.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error from API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP Status Code %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+type CameraStatus struct {
+	// Battery voltage in hundredths of a volt
+	BatteryVoltage int `json:"battery_voltage"`
+	// Temperature in degrees Fahrenheit
+	Temperature int `json:"temperature"`
+	// Wi-Fi signal strength in dBm
+	WifiStrength int `json:"wifi_strength"`
+	// Signal strength between the camera and the sync module in dBm
+	LfrStrength int `json:"lfr_strength"`
+}
+
+// GetCameraStatusContext returns the detailed status of the camera. Only devices of type
+// "camera" report it; the homescreen covers the others.
+//
+// ctx: the context of the request
+//
+// cc: the client credentials identifying the camera
+//
+// Example: api.GetCameraStatusContext(ctx, ClientCredentials{...}) = &CameraStatus{...}, nil
+func (api *BlinkAPI) GetCameraStatusContext(ctx context.Context, cc ClientCredentials) (*CameraStatus, error) {
+	uri := fmt.Sprintf(api.regionURL(cc)+"/network/%d/camera/%d", cc.NetworkId, cc.CameraId)
+
+	body, err := api.settingsRequest(ctx, cc, "GET", uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting camera status: %w", err)
+	}
+
+	var result struct {
+		CameraStatus CameraStatus `json:"camera_status"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	return &result.CameraStatus, nil
+}
+
+// GetCameraStatus is GetCameraStatusContext with a background context.
+//
+// Deprecated: use GetCameraStatusContext, which can be canceled.
+func (api *BlinkAPI) GetCameraStatus(cc ClientCredentials) (*CameraStatus, error) {
+	return api.GetCameraStatusContext(context.Background(), cc)
+}
@@ -0,0 +1,237 @@
+package blinkapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MAX_TRACE_BODY is the maximum number of bytes of a body included in a Trace
+const MAX_TRACE_BODY = 64 << 10
+
+// REDACTED replaces the secrets in a Trace
+const REDACTED = "[REDACTED]"
+
+// Headers and query parameters whose values are secrets
+var secretNames = []string{"authorization", "token-auth", "cookie", "set-cookie", "token", "access_token", "refresh_token", "password"}
+
+// secretFields matches the JSON string fields holding secrets, e.g. "password": "..."
+var secretFields = regexp.MustCompile(`("(?i:[a-z_]*token|password|secret|pin|code_verifier|client_secret)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// Trace is a Blink API request and its response, with the secrets redacted
+type Trace struct {
+	Method string
+	URL    string
+	// The request headers and body
+	RequestHeader http.Header
+	RequestBody   string
+	// The response status code, headers, and body. The status is 0 if the request
+	// failed
+	Status         int
+	ResponseHeader http.Header
+	ResponseBody   string
+	// How long the request took until the response headers arrived
+	Duration time.Duration
+	// The error of a failed request
+	Err error
+}
+
+// String formats the trace like an HTTP exchange, for bug reports
+func (t Trace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "> %s %s\n", t.Method, t.URL)
+	writeHeader(&b, "> ", t.RequestHeader)
+	if t.RequestBody != "" {
+		fmt.Fprintf(&b, ">\n%s\n", t.RequestBody)
+	}
+	if t.Err != nil {
+		fmt.Fprintf(&b, "< error after %s: %v\n", t.Duration.Round(time.Millisecond), t.Err)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "< %d %s (%s)\n", t.Status, http.StatusText(t.Status), t.Duration.Round(time.Millisecond))
+	writeHeader(&b, "< ", t.ResponseHeader)
+	if t.ResponseBody != "" {
+		fmt.Fprintf(&b, "<\n%s\n", t.ResponseBody)
+	}
+
+	return b.String()
+}
+
+// writeHeader writes the headers sorted by name, each prefixed
+func writeHeader(b *strings.Builder, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+// TraceRequests returns a middleware handing the full request and response of
+// every call, with tokens, passwords, and cookies redacted, to a callback. Bodies
+// are truncated at MAX_TRACE_BODY bytes and binary bodies (e.g. clips) are left
+// out. It is meant for debugging and protocol bug reports, not for production.
+//
+// onTrace: the callback receiving the traces
+//
+// Example: TraceRequests(func(t Trace) { log.Print(t) }) = Middleware
+func TraceRequests(onTrace func(Trace)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			trace := Trace{
+				Method:        req.Method,
+				URL:           redactURL(req.URL),
+				RequestHeader: redactHeader(req.Header),
+			}
+			if req.Body != nil && req.GetBody != nil {
+				if body, err := req.GetBody(); err == nil {
+					data, _ := io.ReadAll(io.LimitReader(body, MAX_TRACE_BODY+1))
+					body.Close()
+					trace.RequestBody = traceBody(req.Header.Get("Content-Type"), data)
+				}
+			}
+
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			trace.Duration = time.Since(start)
+			if err != nil {
+				trace.Err = err
+				onTrace(trace)
+				return resp, err
+			}
+
+			trace.Status = resp.StatusCode
+			trace.ResponseHeader = redactHeader(resp.Header)
+			if text(resp.Header.Get("Content-Type")) {
+				// The body read for the trace is replayed to the caller
+				data, readErr := io.ReadAll(io.LimitReader(resp.Body, MAX_TRACE_BODY+1))
+				resp.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(data), errReader{readErr}, resp.Body), resp.Body}
+				trace.ResponseBody = traceBody(resp.Header.Get("Content-Type"), data)
+			} else if resp.ContentLength > 0 {
+				trace.ResponseBody = fmt.Sprintf("[%d bytes of %s]", resp.ContentLength, resp.Header.Get("Content-Type"))
+			}
+			onTrace(trace)
+
+			return resp, nil
+		})
+	}
+}
+
+// errReader returns an error once read, or io.EOF if it is nil
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return 0, io.EOF
+}
+
+// text reports whether a content type is readable text, treating a missing one
+// as text since the API omits it on some errors
+func text(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || mediaType == "application/x-www-form-urlencoded"
+}
+
+// traceBody returns a body for a trace, redacted and truncated
+func traceBody(contentType string, data []byte) string {
+	if !text(contentType) {
+		return fmt.Sprintf("[%d bytes of %s]", len(data), contentType)
+	}
+
+	truncated := len(data) > MAX_TRACE_BODY
+	if truncated {
+		data = data[:MAX_TRACE_BODY]
+	}
+	body := Redact(string(data))
+	if truncated {
+		body += fmt.Sprintf("\n[truncated at %d bytes]", MAX_TRACE_BODY)
+	}
+
+	return body
+}
+
+// Redact replaces the values of the token, password, and secret fields of a JSON
+// or form body with REDACTED
+//
+// body: the body to redact
+//
+// Example: Redact(`{"email":"me@example.com","password":"hunter2"}`) = `{"email":"me@example.com","password":"[REDACTED]"}`
+func Redact(body string) string {
+	body = secretFields.ReplaceAllString(body, `$1"`+REDACTED+`"`)
+
+	// Form bodies, e.g. of the OAuth token endpoint
+	if values, err := url.ParseQuery(body); err == nil && strings.Contains(body, "=") && !strings.ContainsAny(body, "{[\n") {
+		for name := range values {
+			if secret(name) || strings.Contains(strings.ToLower(name), "token") {
+				values.Set(name, REDACTED)
+			}
+		}
+		return values.Encode()
+	}
+
+	return body
+}
+
+// secret reports whether a header or parameter name holds a secret
+func secret(name string) bool {
+	name = strings.ToLower(name)
+	for _, secretName := range secretNames {
+		if name == secretName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactHeader returns a copy of the headers with the secret values redacted
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for name := range redacted {
+		if secret(name) {
+			redacted[name] = []string{REDACTED}
+		}
+	}
+
+	return redacted
+}
+
+// redactURL returns the URL with the secret query parameters redacted
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	for name := range query {
+		if secret(name) {
+			query.Set(name, REDACTED)
+		}
+	}
+	if len(query) > 0 {
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.String()
+}
@@ -0,0 +1,94 @@
+package blinkapi
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// API endpoints whose version is negotiated
+const (
+	ENDPOINT_CAMERA_LIVEVIEW   = "camera_liveview"
+	ENDPOINT_OWL_LIVEVIEW      = "owl_liveview"
+	ENDPOINT_DOORBELL_LIVEVIEW = "doorbell_liveview"
+)
+
+// DEFAULT_API_VERSIONS lists the API versions tried for each endpoint, in order.
+// When Blink bumps an endpoint, add the new version here or override it through
+// ClientCredentials.ApiVersions.
+var DEFAULT_API_VERSIONS = map[string][]int{
+	ENDPOINT_CAMERA_LIVEVIEW:   {5},
+	ENDPOINT_OWL_LIVEVIEW:      {2},
+	ENDPOINT_DOORBELL_LIVEVIEW: {2},
+}
+
+type versionKey struct {
+	region    string
+	accountId int
+	endpoint  string
+}
+
+var (
+	negotiatedMu sync.Mutex
+	// The API version that last worked per account and endpoint
+	negotiated = map[versionKey]int{}
+)
+
+// LiveViewEndpoint returns the liveview endpoint for the device type
+//
+// deviceType: the device type (e.g. "owl")
+//
+// Example: LiveViewEndpoint("owl") = "owl_liveview", nil
+func LiveViewEndpoint(deviceType string) (string, error) {
+	switch deviceType {
+	case "camera":
+		return ENDPOINT_CAMERA_LIVEVIEW, nil
+	case "owl", "hawk":
+		return ENDPOINT_OWL_LIVEVIEW, nil
+	case "doorbell", "lotus":
+		return ENDPOINT_DOORBELL_LIVEVIEW, nil
+	}
+
+	return "", fmt.Errorf("cannot build path for unknown device type: %s", deviceType)
+}
+
+// APIVersions returns the API versions to try for the endpoint, starting with the
+// version that last worked for the account
+//
+// cc: the client credentials providing the account and any overrides
+//
+// endpoint: the endpoint name (e.g. ENDPOINT_CAMERA_LIVEVIEW)
+//
+// Example: APIVersions(ClientCredentials{...}, ENDPOINT_CAMERA_LIVEVIEW) = []int{5}
+func APIVersions(cc ClientCredentials, endpoint string) []int {
+	versions := cc.ApiVersions[endpoint]
+	if len(versions) == 0 {
+		versions = DEFAULT_API_VERSIONS[endpoint]
+	}
+	versions = slices.Clone(versions)
+
+	negotiatedMu.Lock()
+	version, ok := negotiated[versionKey{cc.Region, cc.AccountId, endpoint}]
+	negotiatedMu.Unlock()
+
+	if index := slices.Index(versions, version); ok && index > 0 {
+		versions = append([]int{version}, slices.Delete(versions, index, index+1)...)
+	}
+
+	return versions
+}
+
+// ResetAPIVersions forgets the negotiated API versions, e.g. after changing overrides
+func ResetAPIVersions() {
+	negotiatedMu.Lock()
+	defer negotiatedMu.Unlock()
+
+	negotiated = map[versionKey]int{}
+}
+
+func rememberAPIVersion(cc ClientCredentials, endpoint string, version int) {
+	negotiatedMu.Lock()
+	defer negotiatedMu.Unlock()
+
+	negotiated[versionKey{cc.Region, cc.AccountId, endpoint}] = version
+}
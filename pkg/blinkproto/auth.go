@@ -1,9 +1,9 @@
-package blink
+package blinkproto
 
 import (
-	"crypto/tls"
 	"encoding/binary"
 	"fmt"
+	"net"
 	"time"
 )
 
@@ -79,7 +79,7 @@ func GenerateAuthFrames(connectionId string, clientId int) [][]byte {
 // clientId: the Blink client ID to use in the header
 //
 // Example: SendAuthFrames(client, "connection-id", 123) = nil
-func SendAuthFrames(client *tls.Conn, connectionId string, clientId int) error {
+func SendAuthFrames(client net.Conn, connectionId string, clientId int) error {
 	if err := client.SetWriteDeadline(time.Now().Add(1 * time.Second)); err != nil {
 		return fmt.Errorf("error setting write deadline: %w", err)
 	}
@@ -99,7 +99,7 @@ func SendAuthFrames(client *tls.Conn, connectionId string, clientId int) error {
 // client: the client connection to send the ping on
 //
 // Example: SendPing(client) = nil
-func SendPing(client *tls.Conn) (err error) {
+func SendPing(client net.Conn) (err error) {
 	if err := client.SetWriteDeadline(time.Now().Add(1 * time.Second)); err != nil {
 		return fmt.Errorf("error setting write deadline: %w", err)
 	}
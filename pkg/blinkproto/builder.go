@@ -0,0 +1,94 @@
+package blinkproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// KEEPALIVE_SEQUENCE is the sequence number of the keep-alive frame sent by the
+// official apps
+const KEEPALIVE_SEQUENCE = 1000
+
+// Encode serializes the frame with its header
+//
+// Example: Frame{Type: MSG_TYPE_MEDIA, Sequence: 1, Payload: ts}.Encode() = []byte{0x00, 0x00, 0x00, 0x00, 0x01, ...}
+func (f Frame) Encode() []byte {
+	out := make([]byte, FRAME_HEADER_SIZE, FRAME_HEADER_SIZE+len(f.Payload))
+	out[0] = f.Type
+	binary.BigEndian.PutUint32(out[1:5], f.Sequence)
+	binary.BigEndian.PutUint32(out[5:9], uint32(len(f.Payload)))
+
+	return append(out, f.Payload...)
+}
+
+// DecodeFrame parses a single frame with its header
+//
+// data: the serialized frame
+//
+// Example: DecodeFrame(FRAMES_KEEPALIVE) = Frame{Type: MSG_TYPE_LATENCY_STATS, Sequence: 1000, ...}, nil
+func DecodeFrame(data []byte) (Frame, error) {
+	if len(data) < FRAME_HEADER_SIZE {
+		return Frame{}, errors.New("frame is shorter than its header")
+	}
+	length := binary.BigEndian.Uint32(data[5:9])
+	if uint32(len(data)-FRAME_HEADER_SIZE) != length {
+		return Frame{}, fmt.Errorf("frame announces %d bytes but has %d", length, len(data)-FRAME_HEADER_SIZE)
+	}
+
+	return Frame{
+		Type:     data[0],
+		Sequence: binary.BigEndian.Uint32(data[1:5]),
+		Payload:  append([]byte(nil), data[FRAME_HEADER_SIZE:]...),
+	}, nil
+}
+
+// FrameBuilder assembles the frames a client sends on a livestream connection
+type FrameBuilder struct {
+	// The Blink connection ID from the liveview URL
+	ConnectionId string
+	// The Blink client ID
+	ClientId int
+	// The sequence number of the next frame built by Frame
+	Sequence uint32
+}
+
+// NewFrameBuilder initializes a new FrameBuilder for a connection.
+//
+// connectionId: the Blink connection ID from the liveview URL
+//
+// clientId: the Blink client ID
+//
+// Example: NewFrameBuilder("connection-id", 123) = &FrameBuilder{...}
+func NewFrameBuilder(connectionId string, clientId int) *FrameBuilder {
+	return &FrameBuilder{ConnectionId: connectionId, ClientId: clientId}
+}
+
+// Auth returns the authentication frames that open the connection
+//
+// Example: Auth() = [][]byte{...}
+func (b *FrameBuilder) Auth() [][]byte {
+	return GenerateAuthFrames(b.ConnectionId, b.ClientId)
+}
+
+// Keepalive returns the keep-alive frame
+//
+// Example: Keepalive() = FRAMES_KEEPALIVE
+func (b *FrameBuilder) Keepalive() []byte {
+	return append([]byte(nil), FRAMES_KEEPALIVE...)
+}
+
+// Frame returns a frame of the message type with the next sequence number, e.g.
+// for experimenting with control frames
+//
+// msgType: the message type
+//
+// payload: the frame payload
+//
+// Example: Frame(MSG_TYPE_LATENCY_STATS, payload) = []byte{0x12, ...}
+func (b *FrameBuilder) Frame(msgType byte, payload []byte) []byte {
+	frame := Frame{Type: msgType, Sequence: b.Sequence, Payload: payload}
+	b.Sequence++
+
+	return frame.Encode()
+}
@@ -0,0 +1,142 @@
+package blinkproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// CAPTURE_MAGIC starts every capture file
+var CAPTURE_MAGIC = []byte("BLKCAP01")
+
+// Directions of captured data
+const (
+	CAPTURE_RECEIVED = 0
+	CAPTURE_SENT     = 1
+)
+
+// captureRecordHeaderSize is the size of a record header: the direction, the
+// connection number, the offset in nanoseconds, and the data length
+const captureRecordHeaderSize = 1 + 4 + 8 + 4
+
+// CaptureRecord is the data of one read or write on a stream connection
+type CaptureRecord struct {
+	// CAPTURE_RECEIVED or CAPTURE_SENT
+	Direction byte
+	// The connection the data belongs to, numbered from 1 in the order they opened
+	Connection uint32
+	// The time since the capture started
+	Offset time.Duration
+	// The raw bytes
+	Data []byte
+}
+
+// CaptureWriter records the raw bytes of stream connections with their timestamps
+// and direction, so the protocol can be studied and replayed without a camera
+type CaptureWriter struct {
+	// Guards the fields below
+	mu sync.Mutex
+	// The writer receiving the capture file
+	writer io.Writer
+	// When the capture started
+	start time.Time
+	// The number of the last opened connection
+	connections uint32
+}
+
+// NewCaptureWriter writes the capture file header and returns a CaptureWriter.
+//
+// writer: the writer receiving the capture file
+//
+// Example: NewCaptureWriter(file) = &CaptureWriter{...}, nil
+func NewCaptureWriter(writer io.Writer) (*CaptureWriter, error) {
+	if _, err := writer.Write(CAPTURE_MAGIC); err != nil {
+		return nil, fmt.Errorf("error writing capture header: %w", err)
+	}
+
+	return &CaptureWriter{writer: writer, start: time.Now()}, nil
+}
+
+// Connection returns the number of a newly opened connection, for Record
+func (c *CaptureWriter) Connection() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connections++
+
+	return c.connections
+}
+
+// Record appends the data of a read or write to the capture.
+//
+// direction: CAPTURE_RECEIVED or CAPTURE_SENT
+//
+// connection: the number returned by Connection
+//
+// data: the raw bytes
+//
+// Example: Record(CAPTURE_SENT, 1, FRAMES_KEEPALIVE) = nil
+func (c *CaptureWriter) Record(direction byte, connection uint32, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record := make([]byte, captureRecordHeaderSize, captureRecordHeaderSize+len(data))
+	record[0] = direction
+	binary.BigEndian.PutUint32(record[1:5], connection)
+	binary.BigEndian.PutUint64(record[5:13], uint64(time.Since(c.start)))
+	binary.BigEndian.PutUint32(record[13:17], uint32(len(data)))
+	_, err := c.writer.Write(append(record, data...))
+
+	return err
+}
+
+// CaptureReader reads the records of a capture file
+type CaptureReader struct {
+	// The reader of the capture file
+	reader io.Reader
+}
+
+// NewCaptureReader validates the capture file header and returns a CaptureReader.
+//
+// reader: the reader of the capture file
+//
+// Example: NewCaptureReader(file) = &CaptureReader{...}, nil
+func NewCaptureReader(reader io.Reader) (*CaptureReader, error) {
+	magic := make([]byte, len(CAPTURE_MAGIC))
+	if _, err := io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, CAPTURE_MAGIC) {
+		return nil, errors.New("not a capture file")
+	}
+
+	return &CaptureReader{reader: reader}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the capture
+func (c *CaptureReader) Next() (CaptureRecord, error) {
+	header := make([]byte, captureRecordHeaderSize)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return CaptureRecord{}, fmt.Errorf("truncated capture record: %w", err)
+		}
+		return CaptureRecord{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[13:17])
+	if length > MAX_FRAME_SIZE {
+		return CaptureRecord{}, fmt.Errorf("capture record of %d bytes is too large", length)
+	}
+	record := CaptureRecord{
+		Direction:  header[0],
+		Connection: binary.BigEndian.Uint32(header[1:5]),
+		Offset:     time.Duration(binary.BigEndian.Uint64(header[5:13])),
+		Data:       make([]byte, length),
+	}
+	if _, err := io.ReadFull(c.reader, record.Data); err != nil {
+		return CaptureRecord{}, fmt.Errorf("truncated capture record: %w", err)
+	}
+
+	return record, nil
+}
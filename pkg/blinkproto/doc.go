@@ -0,0 +1,27 @@
+// Package blinkproto implements the framing of the Blink livestream protocol, as
+// far as it has been worked out, so that tools outside this module can build on it.
+//
+// After the TLS connection to the address returned by the liveview API is
+// established, the client sends the authentication frames (see GenerateAuthFrames):
+//
+//	offset  size  content
+//	0       24    00 00 00 28, then zeros (meaning unknown)
+//	24      4     the client ID, big endian
+//	28      74    01 08, zeros, 00 10 (meaning unknown; the last byte matches the
+//	              usual length of the connection ID)
+//	102     n     the connection ID from the liveview URL
+//	102+n   13    00 00 00 01 0a, then zeros (meaning unknown)
+//
+// The server then sends the stream as frames, each preceded by a 9 byte header:
+//
+//	offset  size  content
+//	0       1     the message type (MSG_TYPE_MEDIA or MSG_TYPE_LATENCY_STATS)
+//	1       4     the sequence number, big endian
+//	5       4     the payload length, big endian
+//	9       n     the payload; MPEG-TS packets for media frames
+//
+// The client keeps the connection alive by sending a latency stats frame
+// (FRAMES_KEEPALIVE) about once a second. Decoder splits the stream into frames,
+// FrameBuilder assembles the frames a client sends, and CaptureWriter and
+// CaptureReader record and replay connections for offline analysis.
+package blinkproto
@@ -0,0 +1,106 @@
+package blinkproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FRAME_HEADER_SIZE is the size of the header preceding every frame on the stream:
+// the message type, a big endian sequence number, and the big endian payload length
+const FRAME_HEADER_SIZE = 9
+
+// MAX_FRAME_SIZE is the largest payload accepted before the framing is considered lost
+const MAX_FRAME_SIZE = 1 << 20
+
+// Message types of the stream frames
+const (
+	// MSG_TYPE_MEDIA frames carry MPEG-TS data
+	MSG_TYPE_MEDIA = 0x00
+	// MSG_TYPE_LATENCY_STATS frames carry latency statistics, as in FRAMES_KEEPALIVE
+	MSG_TYPE_LATENCY_STATS = 0x12
+)
+
+// MPEG-TS sync byte starting the payload of media frames
+const tsSyncByte = 0x47
+
+// Frame is a frame of the stream
+type Frame struct {
+	// The message type (e.g. MSG_TYPE_MEDIA)
+	Type byte
+	// The sequence number assigned by the server
+	Sequence uint32
+	// The frame payload
+	Payload []byte
+}
+
+// Decoder splits the stream into frames, writing the payload of media frames to
+// the writer and passing every other frame to a callback
+type Decoder struct {
+	// The writer receiving the MPEG-TS data
+	writer io.Writer
+	// Callback receiving the non-media frames
+	onControl func(Frame)
+	// Bytes of the current frame received so far
+	pending []byte
+}
+
+// NewDecoder initializes a new Decoder.
+//
+// writer: the writer receiving the MPEG-TS data of media frames
+//
+// onControl: the callback receiving the other frames, or nil to discard them
+//
+// Example: NewDecoder(writer, func(f Frame) { ... }) = &Decoder{...}
+func NewDecoder(writer io.Writer, onControl func(Frame)) *Decoder {
+	if onControl == nil {
+		onControl = func(Frame) {}
+	}
+
+	return &Decoder{
+		writer:    writer,
+		onControl: onControl,
+	}
+}
+
+// Write decodes the complete frames of the data, keeping an incomplete frame
+// until the rest arrives. It fails if a frame header announces an implausible
+// payload length, which means the framing was lost.
+func (d *Decoder) Write(p []byte) (int, error) {
+	d.pending = append(d.pending, p...)
+
+	offset := 0
+	for len(d.pending)-offset >= FRAME_HEADER_SIZE {
+		header := d.pending[offset : offset+FRAME_HEADER_SIZE]
+		length := binary.BigEndian.Uint32(header[5:9])
+		if length > MAX_FRAME_SIZE {
+			d.pending = d.pending[:0]
+			return 0, fmt.Errorf("stream framing lost: frame of type 0x%02x announces %d bytes", header[0], length)
+		}
+		end := offset + FRAME_HEADER_SIZE + int(length)
+		if len(d.pending) < end {
+			break
+		}
+
+		frame := Frame{
+			Type:     header[0],
+			Sequence: binary.BigEndian.Uint32(header[1:5]),
+			Payload:  d.pending[offset+FRAME_HEADER_SIZE : end],
+		}
+		offset = end
+
+		if frame.Type == MSG_TYPE_MEDIA && len(frame.Payload) > 0 && frame.Payload[0] == tsSyncByte {
+			if _, err := d.writer.Write(frame.Payload); err != nil {
+				d.pending = append(d.pending[:0], d.pending[offset:]...)
+				return 0, err
+			}
+			continue
+		}
+
+		frame.Payload = append([]byte(nil), frame.Payload...)
+		d.onControl(frame)
+	}
+	d.pending = append(d.pending[:0], d.pending[offset:]...)
+
+	return len(p), nil
+}
@@ -0,0 +1,168 @@
+// Package broker shares the livestream of a camera between processes on the same
+// host. Blink only allows one viewer per camera, so the first process to open the
+// stream owns the Blink session and relays the stream over a local Unix socket (or
+// TCP address). Later processes receive the same stream from the relay instead of
+// requesting their own session, which Blink would reject as busy.
+//
+// When the owner stops, the relayed streams end. Opening the stream again makes one
+// of the remaining processes the new owner.
+package broker
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/output/socket"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Networks of the relay address
+const (
+	NETWORK_UNIX = socket.NETWORK_UNIX
+	NETWORK_TCP  = socket.NETWORK_TCP
+)
+
+type Config struct {
+	// The network of the relay, NETWORK_UNIX or NETWORK_TCP (defaults to NETWORK_UNIX)
+	Network string
+	// The socket path or TCP address of the relay (e.g. DefaultAddress(cameraId))
+	Address string
+	// The bytes buffered for each relayed process before it is disconnected for
+	// falling behind (defaults to buffer.DEFAULT_SIZE)
+	BufferSize int
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// DefaultAddress returns the default Unix socket path of the relay for a camera
+//
+// cameraId: the ID of the camera
+//
+// Example: DefaultAddress(11111) = "/tmp/blink-11111.sock"
+func DefaultAddress(cameraId int) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("blink-%d.sock", cameraId))
+}
+
+// Stream is a shared livestream opened by Open
+type Stream struct {
+	io.Reader
+	// Whether this process owns the Blink session
+	owner bool
+	// Ends the stream
+	close func() error
+}
+
+// Owner returns whether this process owns the Blink session and relays the stream,
+// as opposed to receiving it from another process
+func (s *Stream) Owner() bool {
+	return s.owner
+}
+
+// Close ends the stream. The owner also ends the Blink session and the streams it
+// relays.
+func (s *Stream) Close() error {
+	return s.close()
+}
+
+// Open returns a reader of the camera livestream. If another process already owns
+// the session at the relay address, the stream is received from it. Otherwise the
+// client connects to Blink and the stream is relayed to the processes opening it
+// later. The stream ends when the context is cancelled, the stream is closed, or the
+// owner's livestream ends.
+//
+// ctx: the context controlling the stream lifecycle
+//
+// client: the liveview client used if this process becomes the owner
+//
+// config: the broker configuration
+//
+// Example: Open(ctx, client, Config{Address: DefaultAddress(11111)}) = &Stream{...}, nil
+func Open(ctx context.Context, client *liveview.Client, config Config) (*Stream, error) {
+	if config.Network == "" {
+		config.Network = NETWORK_UNIX
+	}
+	if config.Network != NETWORK_UNIX && config.Network != NETWORK_TCP {
+		return nil, fmt.Errorf("unsupported relay network %q", config.Network)
+	}
+	if config.Address == "" {
+		return nil, errors.New("no relay address configured")
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	if conn, err := net.Dial(config.Network, config.Address); err == nil {
+		return subscribe(ctx, conn), nil
+	}
+
+	relay, err := socket.Listen(socket.Config{
+		Network:    config.Network,
+		Address:    config.Address,
+		BufferSize: config.BufferSize,
+		OnLog:      config.OnLog,
+	})
+	if err != nil {
+		// Two processes starting together may both fail to connect, so the one
+		// losing the race for the address connects to the winner
+		if conn, dialErr := net.Dial(config.Network, config.Address); dialErr == nil {
+			return subscribe(ctx, conn), nil
+		}
+		return nil, fmt.Errorf("error starting relay: %w", err)
+	}
+
+	return serve(ctx, client, relay, config), nil
+}
+
+// subscribe returns the stream relayed by the owner over the connection
+func subscribe(ctx context.Context, conn net.Conn) *Stream {
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	return &Stream{
+		Reader: conn,
+		close: func() error {
+			stop()
+			return conn.Close()
+		},
+	}
+}
+
+// serve streams the livestream with the client to the local reader and the relay
+func serve(ctx context.Context, client *liveview.Client, relay *socket.Writer, config Config) *Stream {
+	reader, writer := io.Pipe()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		err := client.Stream(ctx, io.MultiWriter(relay, writer))
+		relay.Close()
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		writer.CloseWithError(err)
+	}()
+
+	config.OnLog(fmt.Sprintf("Relaying the livestream on %s", config.Address))
+
+	return &Stream{
+		Reader: reader,
+		owner:  true,
+		close: func() error {
+			// Closing the pipe first unblocks a pending write from the stream
+			reader.Close()
+			cancel()
+			<-done
+			return nil
+		},
+	}
+}
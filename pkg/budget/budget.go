@@ -0,0 +1,213 @@
+// Package budget limits the cumulative liveview time of each camera per day, to
+// protect battery powered cameras from being drained by automated consumers.
+//
+// The time used today is kept in a small JSON state file so that the budget holds
+// across restarts and between processes sharing the file. The counts start over at
+// local midnight.
+package budget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Policies applied when a camera has used its budget
+const (
+	// POLICY_REFUSE refuses new livestreams and stops the running ones
+	POLICY_REFUSE = "refuse"
+	// POLICY_WARN logs a warning but keeps streaming
+	POLICY_WARN = "warn"
+)
+
+// ErrExhausted is returned when a camera has used its budget with POLICY_REFUSE
+var ErrExhausted = errors.New("daily streaming budget exhausted")
+
+type Config struct {
+	// The maximum liveview time per camera per day
+	Limit time.Duration
+	// The policy once the limit is reached, POLICY_REFUSE or POLICY_WARN (defaults
+	// to POLICY_REFUSE)
+	Policy string
+	// Optional state file recording the time used today. Without it, the budget
+	// only lasts as long as the process
+	Path string
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// state is the content of the state file
+type state struct {
+	// The local date the counts belong to (e.g. "2026-10-16")
+	Day string `json:"day"`
+	// The liveview seconds used per camera ID
+	Seconds map[string]float64 `json:"seconds"`
+}
+
+// Budget tracks the liveview time used by each camera today
+type Budget struct {
+	// Configuration options for the budget
+	config Config
+	// Guards the state file and the state below
+	mu sync.Mutex
+	// The state when there is no state file
+	memory state
+}
+
+// New initializes a new Budget.
+//
+// config: the budget configuration
+//
+// Example: New(Config{Limit: 30 * time.Minute, Path: "budget.json"}) = &Budget{...}, nil
+func New(config Config) (*Budget, error) {
+	if config.Limit <= 0 {
+		return nil, fmt.Errorf("invalid budget limit %s", config.Limit)
+	}
+	switch config.Policy {
+	case "":
+		config.Policy = POLICY_REFUSE
+	case POLICY_REFUSE, POLICY_WARN:
+	default:
+		return nil, fmt.Errorf("unsupported budget policy %q", config.Policy)
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	return &Budget{config: config}, nil
+}
+
+// DefaultPath returns the default state file in the user configuration directory
+//
+// Example: DefaultPath() = "/home/user/.config/blink-middleware/budget.json", nil
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to locate configuration directory: %w", err)
+	}
+
+	return filepath.Join(dir, "blink-middleware", "budget.json"), nil
+}
+
+// Policy returns the policy applied once the limit is reached
+func (b *Budget) Policy() string {
+	return b.config.Policy
+}
+
+// Remaining returns the liveview time the camera has left today
+//
+// cameraId: the ID of the camera
+//
+// Example: Remaining(11111) = 12 * time.Minute, nil
+func (b *Budget) Remaining(cameraId int) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, err := b.load()
+	if err != nil {
+		return 0, err
+	}
+	used := time.Duration(current.Seconds[strconv.Itoa(cameraId)] * float64(time.Second))
+
+	return max(b.config.Limit-used, 0), nil
+}
+
+// Allow checks whether the camera may start a livestream and returns the time it has
+// left today. With POLICY_REFUSE it returns ErrExhausted once no time is left, with
+// POLICY_WARN it logs a warning instead.
+//
+// cameraId: the ID of the camera
+//
+// Example: Allow(11111) = 12 * time.Minute, nil
+func (b *Budget) Allow(cameraId int) (time.Duration, error) {
+	remaining, err := b.Remaining(cameraId)
+	if err != nil {
+		return 0, err
+	}
+	if remaining > 0 {
+		return remaining, nil
+	}
+
+	if b.config.Policy == POLICY_REFUSE {
+		return 0, fmt.Errorf("camera %d: %w (%s per day)", cameraId, ErrExhausted, b.config.Limit)
+	}
+	b.config.OnLog(fmt.Sprintf("Camera %d has used its daily streaming budget of %s", cameraId, b.config.Limit))
+
+	return 0, nil
+}
+
+// Record adds liveview time used by the camera
+//
+// cameraId: the ID of the camera
+//
+// used: the liveview time to add
+//
+// Example: Record(11111, 90 * time.Second) = nil
+func (b *Budget) Record(cameraId int, used time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, err := b.load()
+	if err != nil {
+		return err
+	}
+	current.Seconds[strconv.Itoa(cameraId)] += used.Seconds()
+
+	return b.save(current)
+}
+
+// load reads the state of today, starting over if it belongs to another day
+func (b *Budget) load() (state, error) {
+	today := time.Now().Format(time.DateOnly)
+
+	current := b.memory
+	if b.config.Path != "" {
+		current = state{}
+		data, err := os.ReadFile(b.config.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return state{}, fmt.Errorf("error reading budget state: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &current); err != nil {
+				return state{}, fmt.Errorf("error parsing budget state %s: %w", b.config.Path, err)
+			}
+		}
+	}
+
+	if current.Day != today || current.Seconds == nil {
+		current = state{Day: today, Seconds: map[string]float64{}}
+	}
+
+	return current, nil
+}
+
+// save stores the state, replacing the state file atomically
+func (b *Budget) save(current state) error {
+	if b.config.Path == "" {
+		b.memory = current
+		return nil
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.config.Path), 0o700); err != nil {
+		return fmt.Errorf("error creating budget directory: %w", err)
+	}
+
+	temp := b.config.Path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return fmt.Errorf("error writing budget state: %w", err)
+	}
+	if err := os.Rename(temp, b.config.Path); err != nil {
+		return fmt.Errorf("error writing budget state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Roles of the API keys
+const (
+	// ROLE_READ may list the devices, read the stats, and receive the streams and
+	// posters
+	ROLE_READ = "read"
+	// ROLE_ADMIN may also start and stop livestreams
+	ROLE_ADMIN = "admin"
+)
+
+// STREAM_NAME_TIMEOUT bounds the homescreen request naming the RTSP streams
+const STREAM_NAME_TIMEOUT = 10 * time.Second
+
+// adminMethods are the gRPC methods that require ROLE_ADMIN
+var adminMethods = map[string]bool{
+	"StartLiveview":  true,
+	"StartLiveviews": true,
+	"StopLiveview":   true,
+}
+
+// ParseRole validates the role of an API key
+//
+// role: the role name
+//
+// Example: ParseRole("admin") = "admin", nil
+func ParseRole(role string) (string, error) {
+	switch role {
+	case ROLE_READ, ROLE_ADMIN:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q, expecting %s or %s", role, ROLE_READ, ROLE_ADMIN)
+	}
+}
+
+// authorize checks that the key of the request grants the role. Every request is
+// allowed while no API keys are configured. The key is read from the
+// "authorization: Bearer <key>" header (gRPC metadata), or from the key query
+// parameter for the HTTP endpoints.
+func (s *Server) authorize(r *http.Request, role string) error {
+	if len(s.config.APIKeys) == 0 {
+		return nil
+	}
+
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		key = r.URL.Query().Get("key")
+	}
+	if key == "" {
+		return statusError(CODE_UNAUTHENTICATED, "an API key is required")
+	}
+
+	granted := ""
+	for candidate, candidateRole := range s.config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(key)), []byte(candidate)) == 1 {
+			granted = candidateRole
+		}
+	}
+	switch {
+	case granted == "":
+		return statusError(CODE_UNAUTHENTICATED, "invalid API key")
+	case role == ROLE_ADMIN && granted != ROLE_ADMIN:
+		return statusError(CODE_PERMISSION_DENIED, "the API key is read-only")
+	}
+
+	return nil
+}
+
+// requireKey wraps an HTTP handler with the API key check for the role
+func (s *Server) requireKey(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var status *Status
+		if errors.As(s.authorize(r, role), &status) {
+			code := http.StatusUnauthorized
+			if status.Code == CODE_PERMISSION_DENIED {
+				code = http.StatusForbidden
+			}
+			http.Error(w, status.Message, code)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// streamName returns the RTSP path of a camera: its name in StreamNames, the slug
+// of its name on the homescreen (e.g. "front-door"), or "camera-<id>" if neither
+// is known. Cameras sharing a name get their ID appended, and the paths of the
+// cameras of named accounts are prefixed with the account (e.g. "cabin/porch").
+func (s *Server) streamName(account string, cameraId int64) string {
+	if name := s.config.StreamNames[cameraId]; name != "" {
+		return strings.Trim(name, "/")
+	}
+
+	s.mu.Lock()
+	name, ok := s.names[cameraId]
+	s.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	if err := s.loadNames(); err != nil {
+		s.config.OnLog(fmt.Sprintf("Error requesting the camera names: %v", err))
+		return accountPath(account, fmt.Sprintf("camera-%d", cameraId))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name, ok := s.names[cameraId]; ok {
+		return name
+	}
+
+	return accountPath(account, fmt.Sprintf("camera-%d", cameraId))
+}
+
+// loadNames names the cameras of the homescreens after the slugs of their names.
+// It fails only if no homescreen could be requested.
+func (s *Server) loadNames() error {
+	ctx, cancel := context.WithTimeout(context.Background(), STREAM_NAME_TIMEOUT)
+	defer cancel()
+
+	homescreens := map[string]*blinkapi.Homescreen{}
+	var errs []error
+	for _, account := range s.accountNames() {
+		homescreen, err := s.homescreen(ctx, account)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", accountLabel(account), err))
+			continue
+		}
+		homescreens[account] = homescreen
+	}
+	if len(homescreens) == 0 {
+		return errors.Join(errs...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used := map[string]bool{}
+	for _, name := range s.config.StreamNames {
+		used[strings.Trim(name, "/")] = true
+	}
+	for _, account := range s.accountNames() {
+		homescreen, ok := homescreens[account]
+		if !ok {
+			continue
+		}
+		for _, d := range homescreen.Devices(0) {
+			name := accountPath(account, slug(d.Name))
+			if slug(d.Name) == "" || used[name] {
+				name = accountPath(account, strings.Trim(fmt.Sprintf("%s-%d", slug(d.Name), d.Id), "-"))
+			}
+			used[name] = true
+			s.names[int64(d.Id)] = name
+		}
+	}
+
+	return nil
+}
+
+// slug converts a camera name into a URL path segment
+//
+// Example: slug("Front Door #2") = "front-door-2"
+func slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,186 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Account is a Blink account served alongside the one of Config, e.g. of another
+// household or site. Its cameras are namespaced by its name: their RTSP paths are
+// prefixed with "<name>/", and devices and sessions report the name.
+type Account struct {
+	// The unique name of the account, e.g. "cabin"
+	Name string `json:"name"`
+	// Region of the account (e.g. "u011")
+	Region string `json:"region,omitempty"`
+	// Blink API token
+	ApiToken string `json:"token,omitempty"`
+	// The ID of the account
+	AccountId int `json:"account_id,omitempty"`
+	// Optional encrypted credentials file filling the missing token, account ID,
+	// and region, read by the caller of LoadAccounts (e.g. cmd/server)
+	Credentials string `json:"credentials,omitempty"`
+	// Optional identity of the client for the account, e.g. from its credentials
+	// file. Defaults to ClientConfig.Identity
+	Identity *blinkapi.ClientIdentity `json:"-"`
+}
+
+// accountsFile is the JSON file read by LoadAccounts
+type accountsFile struct {
+	Accounts []Account `json:"accounts"`
+}
+
+// LoadAccounts reads the accounts from a JSON file with an "accounts" list. The
+// accounts are validated by ValidateAccounts once their credentials are filled.
+//
+// path: the file path
+//
+// Example: LoadAccounts("accounts.json") = []Account{{Name: "cabin", ...}}, nil
+func LoadAccounts(path string) ([]Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file accountsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if len(file.Accounts) == 0 {
+		return nil, fmt.Errorf("%s lists no accounts", path)
+	}
+
+	return file.Accounts, nil
+}
+
+// ValidateAccounts checks that each account has a token, an account ID, a region,
+// and a unique name usable in an RTSP path
+//
+// accounts: the accounts to check
+//
+// Example: ValidateAccounts([]Account{{Name: "Cabin Lake", ...}}) = error
+func ValidateAccounts(accounts []Account) error {
+	names := map[string]bool{}
+	for i, account := range accounts {
+		switch {
+		case account.Name == "":
+			return fmt.Errorf("account %d has no name", i+1)
+		case slug(account.Name) != account.Name:
+			return fmt.Errorf("account name %q must be lowercase letters, digits, and dashes, e.g. %q", account.Name, slug(account.Name))
+		case names[account.Name]:
+			return fmt.Errorf("account name %q is used twice", account.Name)
+		case account.ApiToken == "" || account.AccountId == 0:
+			return fmt.Errorf("account %s requires a token and an account ID", account.Name)
+		case account.Region == "":
+			return fmt.Errorf("account %s requires a region", account.Name)
+		}
+		names[account.Name] = true
+	}
+
+	return nil
+}
+
+// accountNames returns the names of the accounts, the account of Config first
+func (s *Server) accountNames() []string {
+	names := make([]string, 0, len(s.accounts))
+	for name := range s.accounts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// credentialsOf returns the credentials of an account
+func (s *Server) credentialsOf(account string) (blinkapi.ClientCredentials, error) {
+	cc, ok := s.accounts[account]
+	if !ok {
+		return blinkapi.ClientCredentials{}, statusError(CODE_NOT_FOUND, "unknown account %q", account)
+	}
+
+	return cc, nil
+}
+
+// homescreen requests the homescreen of an account, remembering the account of
+// each of its cameras
+func (s *Server) homescreen(ctx context.Context, account string) (*blinkapi.Homescreen, error) {
+	cc, err := s.credentialsOf(account)
+	if err != nil {
+		return nil, err
+	}
+	homescreen, err := s.api.GetHomescreenContext(ctx, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, device := range homescreen.Devices(0) {
+		s.cameraAccounts[int64(device.Id)] = account
+	}
+
+	return homescreen, nil
+}
+
+// accountOf returns the account a camera belongs to: the requested account if
+// set, the only account, or the account whose homescreen lists the camera
+func (s *Server) accountOf(ctx context.Context, account string, cameraId int64) (string, error) {
+	if account != "" {
+		_, err := s.credentialsOf(account)
+		return account, err
+	}
+	if len(s.accounts) == 1 {
+		return s.accountNames()[0], nil
+	}
+
+	s.mu.Lock()
+	known, ok := s.cameraAccounts[cameraId]
+	s.mu.Unlock()
+	if ok {
+		return known, nil
+	}
+
+	var errs []error
+	for _, name := range s.accountNames() {
+		if _, err := s.homescreen(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", accountLabel(name), err))
+		}
+	}
+
+	s.mu.Lock()
+	known, ok = s.cameraAccounts[cameraId]
+	s.mu.Unlock()
+	if ok {
+		return known, nil
+	}
+	if len(errs) > 0 {
+		return "", statusError(CODE_UNAVAILABLE, "error looking up the account of camera %d: %v", cameraId, errors.Join(errs...))
+	}
+
+	return "", statusError(CODE_NOT_FOUND, "camera %d is not in any account", cameraId)
+}
+
+// accountPath prefixes an RTSP path with the name of the account of the camera
+func accountPath(account string, path string) string {
+	if account == "" {
+		return path
+	}
+
+	return account + "/" + strings.Trim(path, "/")
+}
+
+// accountLabel names an account in messages
+func accountLabel(account string) string {
+	if account == "" {
+		return "the default account"
+	}
+
+	return "account " + account
+}
@@ -0,0 +1,115 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// SetFloodlight switches the light of a Wired Floodlight Camera on or off
+//
+// ctx: the context of the requests
+//
+// cameraId: the ID of the camera
+//
+// on: whether the light is switched on
+//
+// Example: SetFloodlight(ctx, 11111, true) = nil
+func (s *Server) SetFloodlight(ctx context.Context, cameraId int64, on bool) error {
+	cc, accessoryId, err := s.floodlight(ctx, cameraId)
+	if err != nil {
+		return err
+	}
+
+	return floodlightError(cameraId, s.api.SetFloodlightContext(ctx, cc, accessoryId, on))
+}
+
+// SetFloodlightBrightness sets the brightness of the light of a Wired Floodlight
+// Camera
+//
+// ctx: the context of the requests
+//
+// cameraId: the ID of the camera
+//
+// brightness: the brightness in percent, from 1 to 100
+//
+// Example: SetFloodlightBrightness(ctx, 11111, 80) = nil
+func (s *Server) SetFloodlightBrightness(ctx context.Context, cameraId int64, brightness int) error {
+	if brightness < blinkapi.MIN_FLOODLIGHT_BRIGHTNESS || brightness > blinkapi.MAX_FLOODLIGHT_BRIGHTNESS {
+		return statusError(CODE_INVALID_ARGUMENT, "brightness %d is not between %d and %d", brightness, blinkapi.MIN_FLOODLIGHT_BRIGHTNESS, blinkapi.MAX_FLOODLIGHT_BRIGHTNESS)
+	}
+	cc, accessoryId, err := s.floodlight(ctx, cameraId)
+	if err != nil {
+		return err
+	}
+
+	return floodlightError(cameraId, s.api.SetFloodlightBrightnessContext(ctx, cc, accessoryId, brightness))
+}
+
+// floodlight returns the credentials of a camera and the ID of its floodlight
+func (s *Server) floodlight(ctx context.Context, cameraId int64) (blinkapi.ClientCredentials, int, error) {
+	cc, err := s.cameraCredentials(ctx, cameraId)
+	if err != nil {
+		return cc, 0, err
+	}
+	homescreen, err := s.api.GetHomescreenContext(ctx, cc)
+	if err != nil {
+		return cc, 0, statusError(CODE_UNAVAILABLE, "error requesting the homescreen: %v", err)
+	}
+	accessory, err := homescreen.Floodlight(int(cameraId))
+	if err != nil {
+		return cc, 0, floodlightError(cameraId, err)
+	}
+
+	return cc, accessory.Id, nil
+}
+
+// floodlightError converts the error of a floodlight request into a status
+func floodlightError(cameraId int64, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, blinkapi.ErrNoFloodlight):
+		return statusError(CODE_NOT_FOUND, "camera %d has no floodlight", cameraId)
+	}
+
+	return statusError(CODE_UNAVAILABLE, "%v", err)
+}
+
+// serveFloodlight serves POST /cameras/{id}/floodlight, switching the light with
+// the state query parameter (on or off) and setting its brightness with the
+// brightness query parameter
+func (s *Server) serveFloodlight(w http.ResponseWriter, r *http.Request) {
+	cameraId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+
+	state, brightness := r.URL.Query().Get("state"), r.URL.Query().Get("brightness")
+	if (state != "" && state != "on" && state != "off") || (state == "" && brightness == "") {
+		http.Error(w, "expecting the state (on, off) or the brightness query parameter", http.StatusBadRequest)
+		return
+	}
+	if brightness != "" {
+		percent, err := strconv.Atoi(brightness)
+		if err != nil {
+			http.Error(w, "invalid brightness", http.StatusBadRequest)
+			return
+		}
+		if err := s.SetFloodlightBrightness(r.Context(), cameraId, percent); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	}
+	if state != "" {
+		if err := s.SetFloodlight(r.Context(), cameraId, state == "on"); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
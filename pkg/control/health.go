@@ -0,0 +1,296 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// READY_CHECK_INTERVAL is how long the result of the Blink API check is reused
+	// by /readyz, so frequent probes do not hit the API
+	READY_CHECK_INTERVAL = 30 * time.Second
+	// READY_CHECK_TIMEOUT bounds the Blink API check
+	READY_CHECK_TIMEOUT = 5 * time.Second
+	// SESSION_STALL_TIMEOUT is how long a streaming session may go without data
+	// before it is reported as stalled
+	SESSION_STALL_TIMEOUT = 30 * time.Second
+)
+
+// Health codes of the health report and its sessions
+const (
+	HEALTH_OK            = "ok"
+	HEALTH_UNAVAILABLE   = "unavailable"
+	HEALTH_TOKEN_INVALID = "token_invalid"
+	HEALTH_STOPPING      = "stopping"
+	HEALTH_CONNECTING    = "connecting"
+	HEALTH_STALLED       = "stalled"
+)
+
+// HealthReport is the JSON body of the /healthz and /readyz endpoints
+type HealthReport struct {
+	// The overall health code (HEALTH_OK, HEALTH_UNAVAILABLE, HEALTH_TOKEN_INVALID,
+	// or HEALTH_STOPPING)
+	Status string `json:"status"`
+	// The server uptime in seconds
+	Uptime int64 `json:"uptime"`
+	// The result of the Blink API check. Only reported by /readyz
+	API *APIHealth `json:"api,omitempty"`
+	// The active livestream sessions
+	Sessions []SessionHealth `json:"sessions"`
+}
+
+type APIHealth struct {
+	// Whether the Blink API answered the check
+	Reachable bool `json:"reachable"`
+	// Whether the Blink API accepted the token, of every account
+	TokenValid bool `json:"token_valid"`
+	// When the check ran
+	CheckedAt time.Time `json:"checked_at"`
+	// The error of the check, if any
+	Error string `json:"error,omitempty"`
+}
+
+type SessionHealth struct {
+	// The name of the account of the camera, empty for the default account
+	Account   string `json:"account,omitempty"`
+	CameraId  int64  `json:"camera_id"`
+	NetworkId int64  `json:"network_id"`
+	// The client state (connecting, streaming, stopping)
+	State string `json:"state"`
+	// The health code (HEALTH_OK, HEALTH_CONNECTING, or HEALTH_STALLED)
+	Health string `json:"health"`
+	// The number of stream bytes received
+	Bytes uint64 `json:"bytes"`
+	// The liveview server the session streams from, omitted before it connected
+	Server *SessionServerHealth `json:"server,omitempty"`
+}
+
+// SessionServerHealth is the liveview server of a session in the health report
+type SessionServerHealth struct {
+	Host            string `json:"host"`
+	ConnectionId    string `json:"connection_id"`
+	ClientId        int64  `json:"client_id"`
+	CommandId       int64  `json:"command_id"`
+	PollingInterval int32  `json:"polling_interval"`
+}
+
+// readiness caches the result of the Blink API check
+type readiness struct {
+	// Guards the fields below and serializes the checks
+	mu sync.Mutex
+	// The latest check, or nil before the first one
+	api *APIHealth
+	// Whether the server is shutting down
+	stopping bool
+}
+
+// HealthHandler returns the handler serving the health endpoints, for embedding
+// them in another HTTP server:
+//
+//   - /healthz reports the sessions and always succeeds while the server runs
+//   - /readyz also checks that the Blink API is reachable and accepts the token,
+//     and fails with 503 when it does not or the server is shutting down
+//   - /cameras/{id}/poster.jpg returns the first keyframe of the latest livestream
+//     of the camera, and 404 until one was captured. It requires an API key once
+//     any is configured
+//   - /cameras/{id}/snapshot.jpg returns a current image of the camera, from its
+//     running livestream or else its latest thumbnail, cached for the max_age
+//     query parameter. It requires an API key too
+//   - /cameras/{id}/recordings lists the segments the record: outputs of the
+//     camera profile stored, and /recordings/{id}/play serves one of them, both
+//     also as HLS VOD playlists with format=hls. They require an API key too
+//   - /cameras/{id}/ptz/presets lists the presets of a camera on the Mini
+//     Pan-Tilt mount, and POST /cameras/{id}/ptz turns it with the move (left,
+//     right, up, down) or preset query parameter, which requires an admin key
+//   - POST /cameras/{id}/floodlight switches the light of a Wired Floodlight
+//     Camera with the state (on, off) and brightness query parameters, and
+//     requires an admin key too
+//
+// Example: http.Handle("/", server.HealthHandler())
+func (s *Server) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, s.Health())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, s.Ready(r.Context()))
+	})
+	mux.HandleFunc("GET /cameras/{id}/poster.jpg", s.requireKey(ROLE_READ, s.servePoster))
+	mux.HandleFunc("GET /cameras/{id}/snapshot.jpg", s.requireKey(ROLE_READ, s.serveSnapshot))
+	mux.HandleFunc("GET /cameras/{id}/recordings", s.requireKey(ROLE_READ, s.serveRecordings))
+	mux.HandleFunc("GET /recordings/{id}/play", s.requireKey(ROLE_READ, s.servePlayback))
+	mux.HandleFunc("GET /cameras/{id}/ptz/presets", s.requireKey(ROLE_READ, s.servePTZPresets))
+	mux.HandleFunc("POST /cameras/{id}/ptz", s.requireKey(ROLE_ADMIN, s.servePTZ))
+	mux.HandleFunc("POST /cameras/{id}/floodlight", s.requireKey(ROLE_ADMIN, s.serveFloodlight))
+
+	return mux
+}
+
+// redirectHandler answers the ACME challenges and redirects every other request
+// to the same path on the HTTPS port, or returns 404 when nothing is served
+// over HTTPS
+func (s *Server) redirectHandler(httpsPort string) http.Handler {
+	mux := http.NewServeMux()
+	if s.config.Challenges != nil {
+		mux.Handle("/.well-known/acme-challenge/", s.config.Challenges)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if httpsPort == "" {
+			http.NotFound(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	return mux
+}
+
+// Health reports the liveness of the server and the health of its sessions.
+//
+// Example: Health() = HealthReport{Status: "ok", ...}
+func (s *Server) Health() HealthReport {
+	return HealthReport{
+		Status:   HEALTH_OK,
+		Uptime:   int64(time.Since(s.started).Seconds()),
+		Sessions: s.sessionHealth(),
+	}
+}
+
+// Ready reports whether the server can serve livestreams: the Blink API is
+// reachable and accepts the token, and the server is not shutting down. The API
+// check is reused for READY_CHECK_INTERVAL.
+//
+// ctx: the context bounding the API check
+//
+// Example: Ready(ctx) = HealthReport{Status: "token_invalid", ...}
+func (s *Server) Ready(ctx context.Context) HealthReport {
+	report := s.Health()
+
+	s.readiness.mu.Lock()
+	defer s.readiness.mu.Unlock()
+
+	if s.readiness.stopping {
+		report.Status = HEALTH_STOPPING
+		return report
+	}
+
+	if s.readiness.api == nil || time.Since(s.readiness.api.CheckedAt) >= READY_CHECK_INTERVAL {
+		ctx, cancel := context.WithTimeout(ctx, READY_CHECK_TIMEOUT)
+		defer cancel()
+
+		api := &APIHealth{Reachable: true, TokenValid: true, CheckedAt: time.Now()}
+		var errs []string
+		for _, account := range s.accountNames() {
+			err := s.api.CheckAccount(ctx, s.accounts[account])
+			if err == nil {
+				continue
+			}
+			api.Reachable = api.Reachable && errors.Is(err, blinkapi.ErrUnauthorized)
+			api.TokenValid = false
+			if len(s.accounts) > 1 {
+				err = fmt.Errorf("%s: %w", accountLabel(account), err)
+			}
+			errs = append(errs, err.Error())
+		}
+		api.Error = strings.Join(errs, "; ")
+		// A cancelled probe says nothing about the API
+		if ctx.Err() == nil || s.readiness.api == nil {
+			s.readiness.api = api
+		}
+	}
+
+	report.API = s.readiness.api
+	switch {
+	case !report.API.Reachable:
+		report.Status = HEALTH_UNAVAILABLE
+	case !report.API.TokenValid:
+		report.Status = HEALTH_TOKEN_INVALID
+	}
+
+	return report
+}
+
+// drain marks the server as shutting down, failing the readiness check
+func (s *Server) drain() {
+	s.readiness.mu.Lock()
+	defer s.readiness.mu.Unlock()
+
+	s.readiness.stopping = true
+}
+
+func (s *Server) sessionHealth() []SessionHealth {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	health := make([]SessionHealth, 0, len(sessions))
+	for _, sess := range sessions {
+		health = append(health, sess.health())
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].CameraId < health[j].CameraId
+	})
+
+	return health
+}
+
+func (sess *session) health() SessionHealth {
+	state := sess.client.State()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	code := HEALTH_OK
+	switch {
+	case state == liveview.STATE_CONNECTING:
+		code = HEALTH_CONNECTING
+	case state == liveview.STATE_STREAMING:
+		last := sess.received
+		if last.IsZero() {
+			last = sess.started
+		}
+		if time.Since(last) > SESSION_STALL_TIMEOUT {
+			code = HEALTH_STALLED
+		}
+	}
+
+	return SessionHealth{
+		Account:   sess.account,
+		CameraId:  sess.cameraId,
+		NetworkId: sess.networkId,
+		State:     string(state),
+		Health:    code,
+		Bytes:     sess.bytes,
+		Server:    (*SessionServerHealth)(sess.server()),
+	}
+}
+
+// writeHealth writes the report, with a 503 status unless it is HEALTH_OK
+func writeHealth(w http.ResponseWriter, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != HEALTH_OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,172 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// RECOVERY_TIMEOUT bounds stopping the commands left in the journal by a crash
+	RECOVERY_TIMEOUT = 30 * time.Second
+	// JOURNAL_MAX_AGE is how long a command that cannot be stopped is kept in the
+	// journal. Blink expires commands long before
+	JOURNAL_MAX_AGE = 24 * time.Hour
+)
+
+// JournalEntry is a liveview command of a running livestream, journaled so that it
+// can be stopped after a crash
+type JournalEntry struct {
+	// The name of the account of the camera, empty for the account of Config
+	Account   string    `json:"account,omitempty"`
+	CameraId  int64     `json:"camera_id"`
+	NetworkId int64     `json:"network_id"`
+	CommandId int       `json:"command_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// journal persists the liveview commands of the running livestreams. Without a
+// path it keeps them in memory only.
+type journal struct {
+	// The journal file, or empty
+	path string
+	// Guards the fields below
+	mu sync.Mutex
+	// The running commands
+	entries []JournalEntry
+}
+
+// readJournal returns the entries of a journal file, or none if it does not exist
+func readJournal(path string) ([]JournalEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []JournalEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing journal %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// command records a command being created (running) or stopped
+func (j *journal) command(account string, cameraId int64, networkId int64, commandId int, running bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = slices.DeleteFunc(j.entries, func(entry JournalEntry) bool {
+		return entry.CommandId == commandId
+	})
+	if running {
+		j.entries = append(j.entries, JournalEntry{
+			Account:   account,
+			CameraId:  cameraId,
+			NetworkId: networkId,
+			CommandId: commandId,
+			StartedAt: time.Now().UTC(),
+		})
+	}
+
+	return j.write()
+}
+
+// write replaces the journal file with the current entries. j.mu must be held.
+func (j *journal) write() error {
+	if j.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(j.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+		return err
+	}
+
+	// A crash while writing leaves the previous journal in place
+	temp := j.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, j.path)
+}
+
+// recover stops the liveview commands left in the journal by a previous run, so
+// that Blink does not keep the cameras busy, and returns the cameras they
+// streamed. Commands that cannot be stopped stay in the journal for the next run,
+// up to JOURNAL_MAX_AGE.
+func (s *Server) recover(ctx context.Context) ([]JournalEntry, error) {
+	entries, err := readJournal(s.journal.path)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, RECOVERY_TIMEOUT)
+	defer cancel()
+
+	s.journal.mu.Lock()
+	defer s.journal.mu.Unlock()
+
+	var stopped []JournalEntry
+	for _, entry := range entries {
+		cc, err := s.credentialsOf(entry.Account)
+		if err != nil {
+			// The account was removed from the configuration
+			s.config.OnLog(fmt.Sprintf("Dropping orphaned command %d of camera %d: %v", entry.CommandId, entry.CameraId, err))
+			continue
+		}
+		cc.NetworkId = int(entry.NetworkId)
+		cc.CameraId = int(entry.CameraId)
+		if err := s.api.StopCommandContext(ctx, cc, entry.CommandId); err != nil {
+			s.config.OnLog(fmt.Sprintf("Error stopping orphaned command %d of camera %d: %v", entry.CommandId, entry.CameraId, err))
+			if time.Since(entry.StartedAt) < JOURNAL_MAX_AGE {
+				s.journal.entries = append(s.journal.entries, entry)
+			}
+			continue
+		}
+		s.config.OnLog(fmt.Sprintf("Stopped orphaned command %d of camera %d, started at %s", entry.CommandId, entry.CameraId, entry.StartedAt.Local().Format(time.DateTime)))
+		stopped = append(stopped, entry)
+	}
+
+	return stopped, s.journal.write()
+}
+
+// resume starts the livestreams of the AlwaysOn cameras and, with Resume, of the
+// cameras whose commands were recovered, one after another
+func (s *Server) resume(ctx context.Context, recovered []JournalEntry) {
+	cameras := map[int64]JournalEntry{}
+	for _, cameraId := range s.config.AlwaysOn {
+		cameras[cameraId] = JournalEntry{CameraId: cameraId}
+	}
+	if s.config.Resume {
+		for _, entry := range recovered {
+			cameras[entry.CameraId] = entry
+		}
+	}
+
+	for cameraId, entry := range cameras {
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.config.OnLog(fmt.Sprintf("Resuming camera %d", cameraId))
+		startCtx, cancel := context.WithTimeout(ctx, ON_DEMAND_START_TIMEOUT)
+		if err := s.startCamera(startCtx, entry.Account, cameraId, entry.NetworkId); err != nil {
+			s.config.OnLog(fmt.Sprintf("Error resuming camera %d: %v", cameraId, err))
+		}
+		cancel()
+	}
+}
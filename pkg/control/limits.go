@@ -0,0 +1,196 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Names of the limits, reported in the limit label of the rejection metric
+const (
+	LIMIT_VIEWERS       = "viewers"
+	LIMIT_TOTAL_VIEWERS = "total_viewers"
+	LIMIT_STARTS        = "starts"
+	LIMIT_CAMERA_STARTS = "camera_starts"
+)
+
+const (
+	// DEFAULT_START_BURST is the default number of livestreams started at once
+	// across cameras before the start rate applies
+	DEFAULT_START_BURST = 1
+	// DEFAULT_CAMERA_START_BURST is the default number of livestreams of a camera
+	// started at once before its start rate applies
+	DEFAULT_CAMERA_START_BURST = 1
+)
+
+// Limits caps the viewers of the livestreams and the rate of liveview commands
+// sent to Blink. Zero values are unlimited.
+type Limits struct {
+	// Maximum concurrent viewers of a camera, counting StreamMedia calls and RTSP
+	// sessions
+	MaxViewers int
+	// Maximum concurrent viewers across every camera
+	MaxTotalViewers int
+	// Livestreams started per minute across every camera. Joining a started
+	// livestream does not count
+	StartsPerMinute float64
+	// Livestreams started at once before StartsPerMinute applies (defaults to
+	// DEFAULT_START_BURST)
+	StartBurst int
+	// Livestreams started per minute for each camera
+	CameraStartsPerMinute float64
+	// Livestreams of a camera started at once before CameraStartsPerMinute applies
+	// (defaults to DEFAULT_CAMERA_START_BURST)
+	CameraStartBurst int
+}
+
+// bucket is a token bucket refilling at a constant rate up to its burst
+type bucket struct {
+	// Tokens added per second
+	rate float64
+	// The maximum number of tokens
+	burst float64
+	// The tokens left at the last refill
+	tokens float64
+	// When the bucket was last refilled
+	last time.Time
+}
+
+func newBucket(perMinute float64, burst int, now time.Time) *bucket {
+	return &bucket{rate: perMinute / 60, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// wait refills the bucket and returns how long until a token is available, or 0
+// if one is
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limiter enforces the Limits of a server
+type limiter struct {
+	limits  Limits
+	metrics metrics.Metrics
+	// Guards the fields below
+	mu sync.Mutex
+	// The bucket of the livestreams started across cameras, or nil if unlimited
+	starts *bucket
+	// The buckets of the livestreams started per camera
+	cameraStarts map[int64]*bucket
+	// The current viewers per camera
+	viewers map[int64]int
+	// The current viewers across cameras
+	total int
+}
+
+func newLimiter(limits Limits, m metrics.Metrics) *limiter {
+	if limits.StartBurst <= 0 {
+		limits.StartBurst = DEFAULT_START_BURST
+	}
+	if limits.CameraStartBurst <= 0 {
+		limits.CameraStartBurst = DEFAULT_CAMERA_START_BURST
+	}
+
+	l := &limiter{
+		limits:       limits,
+		metrics:      m,
+		cameraStarts: map[int64]*bucket{},
+		viewers:      map[int64]int{},
+	}
+	if limits.StartsPerMinute > 0 {
+		l.starts = newBucket(limits.StartsPerMinute, limits.StartBurst, time.Now())
+	}
+
+	return l
+}
+
+// start takes a token for starting the livestream of a camera, or fails with
+// CODE_RESOURCE_EXHAUSTED. A token is only taken once both the camera and the
+// account allow the start.
+func (l *limiter) start(cameraId int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var buckets []*bucket
+	if l.limits.CameraStartsPerMinute > 0 {
+		b, ok := l.cameraStarts[cameraId]
+		if !ok {
+			b = newBucket(l.limits.CameraStartsPerMinute, l.limits.CameraStartBurst, now)
+			l.cameraStarts[cameraId] = b
+		}
+		if wait := b.wait(now); wait > 0 {
+			return l.reject(LIMIT_CAMERA_STARTS, cameraId, "camera %d was started too often, retry in %s", cameraId, wait.Round(time.Second))
+		}
+		buckets = append(buckets, b)
+	}
+	if l.starts != nil {
+		if wait := l.starts.wait(now); wait > 0 {
+			return l.reject(LIMIT_STARTS, cameraId, "too many livestreams were started, retry in %s", wait.Round(time.Second))
+		}
+		buckets = append(buckets, l.starts)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return nil
+}
+
+// view admits a viewer of a camera, or fails with CODE_RESOURCE_EXHAUSTED. The
+// returned function releases the viewer once it stops.
+func (l *limiter) view(cameraId int64) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits.MaxViewers > 0 && l.viewers[cameraId] >= l.limits.MaxViewers {
+		return nil, l.reject(LIMIT_VIEWERS, cameraId, "camera %d already has %d viewers", cameraId, l.viewers[cameraId])
+	}
+	if l.limits.MaxTotalViewers > 0 && l.total >= l.limits.MaxTotalViewers {
+		return nil, l.reject(LIMIT_TOTAL_VIEWERS, cameraId, "the server already has %d viewers", l.total)
+	}
+	l.viewers[cameraId]++
+	l.total++
+	l.report(cameraId)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.viewers[cameraId]--
+			l.total--
+			l.report(cameraId)
+			if l.viewers[cameraId] == 0 {
+				delete(l.viewers, cameraId)
+			}
+		})
+	}, nil
+}
+
+// viewing returns the current viewers of a camera
+func (l *limiter) viewing(cameraId int64) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.viewers[cameraId]
+}
+
+// report updates the viewer gauge of a camera. l.mu must be held.
+func (l *limiter) report(cameraId int64) {
+	l.metrics.Gauge(metrics.STREAM_VIEWERS, float64(l.viewers[cameraId]), metrics.Labels{"camera": strconv.FormatInt(cameraId, 10)})
+}
+
+// reject counts a rejection by a limit and returns its status. l.mu must be held.
+func (l *limiter) reject(limit string, cameraId int64, format string, args ...any) error {
+	l.metrics.Counter(metrics.LIMIT_REJECTIONS_TOTAL, 1, metrics.Labels{"limit": limit, "camera": strconv.FormatInt(cameraId, 10)})
+
+	return statusError(CODE_RESOURCE_EXHAUSTED, format, args...)
+}
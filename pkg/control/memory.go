@@ -0,0 +1,139 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/output/buffer"
+	"amattu2/blink-middleware/pkg/output/record"
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	// MEMORY_REPORT_INTERVAL is how often a server with a memory budget logs its
+	// memory use
+	MEMORY_REPORT_INTERVAL = 10 * time.Minute
+	// MEMORY_BUDGET_SESSIONS is the number of livestreams the memory budget is
+	// divided among for the buffers of each session
+	MEMORY_BUDGET_SESSIONS = 16
+	// MIN_MEDIA_QUEUE_SIZE is the fewest chunks a memory budget leaves the queue of
+	// a StreamMedia call
+	MIN_MEDIA_QUEUE_SIZE = 4
+	// PUMP_READ_SIZE is the size of the reads from the livestream, and so the
+	// largest chunk queued for a StreamMedia call
+	PUMP_READ_SIZE = 32 * 1024
+)
+
+// readBuffers pools the read buffers of the sessions, which come and go with the
+// viewers of on-demand and scheduled livestreams
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, PUMP_READ_SIZE)
+		return &buf
+	},
+}
+
+// MemoryStats is the memory use of the server, reported by GetStats
+type MemoryStats struct {
+	// The bytes of the heap in use
+	HeapBytes uint64
+	// The bytes the Go runtime obtained from the operating system
+	SysBytes uint64
+	// The number of goroutines
+	Goroutines int32
+	// The number of completed garbage collections
+	GCCycles uint32
+	// The memory budget in bytes, or 0 without one
+	Budget int64
+}
+
+// Memory samples the memory use of the server. Sampling briefly stops the
+// world, so it is not meant for tight loops.
+//
+// Example: Memory() = MemoryStats{HeapBytes: 12582912, Goroutines: 42, ...}
+func (s *Server) Memory() MemoryStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	return MemoryStats{
+		HeapBytes:  m.HeapAlloc,
+		SysBytes:   m.Sys,
+		Goroutines: int32(runtime.NumGoroutine()),
+		GCCycles:   m.NumGC,
+		Budget:     s.config.MemoryBudget,
+	}
+}
+
+// reportMemory logs the memory use every MEMORY_REPORT_INTERVAL and updates the
+// process metrics until the context is cancelled, warning when the heap exceeds
+// the budget
+func (s *Server) reportMemory(ctx context.Context) {
+	ticker := time.NewTicker(MEMORY_REPORT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		memory := s.Memory()
+		s.limiter.metrics.Gauge(metrics.PROCESS_HEAP_BYTES, float64(memory.HeapBytes), nil)
+		s.limiter.metrics.Gauge(metrics.PROCESS_GOROUTINES, float64(memory.Goroutines), nil)
+
+		s.mu.Lock()
+		sessions := len(s.sessions)
+		s.mu.Unlock()
+		message := fmt.Sprintf("Memory: %s heap of the %s budget, %s from the system, %d goroutines, %d livestreams", record.FormatSize(int64(memory.HeapBytes)), record.FormatSize(memory.Budget), record.FormatSize(int64(memory.SysBytes)), memory.Goroutines, sessions)
+		if int64(memory.HeapBytes) > memory.Budget {
+			message += "; over budget, lower it or run fewer livestreams"
+		}
+		s.config.OnLog(message)
+	}
+}
+
+// limitMemory sets the soft memory limit of the Go runtime to the budget, so the
+// garbage collector runs harder before the process exceeds it
+func (s *Server) limitMemory() {
+	if s.config.MemoryBudget > 0 {
+		debug.SetMemoryLimit(s.config.MemoryBudget)
+	}
+}
+
+// sessionBudget returns the bytes the buffers of each session may hold, or 0
+// without a memory budget
+func (s *Server) sessionBudget() int64 {
+	return s.config.MemoryBudget / MEMORY_BUDGET_SESSIONS
+}
+
+// outputBufferSize returns the size of the buffer in front of each output of a
+// camera profile: half the session budget shared by the outputs, at most
+// buffer.DEFAULT_SIZE
+func (s *Server) outputBufferSize(outputs int) int {
+	budget := s.sessionBudget()
+	if budget <= 0 || outputs == 0 {
+		return buffer.DEFAULT_SIZE
+	}
+
+	return int(max(min(budget/2/int64(outputs), buffer.DEFAULT_SIZE), 64*1024))
+}
+
+// mediaQueueSize returns the number of chunks queued for each StreamMedia call:
+// the other half of the session budget shared by the viewers a camera may have,
+// at most MEDIA_QUEUE_SIZE
+func (s *Server) mediaQueueSize() int {
+	budget := s.sessionBudget()
+	if budget <= 0 {
+		return MEDIA_QUEUE_SIZE
+	}
+	viewers := int64(s.config.Limits.MaxViewers)
+	if viewers <= 0 {
+		viewers = 4
+	}
+
+	return int(max(min(budget/2/viewers/PUMP_READ_SIZE, MEDIA_QUEUE_SIZE), MIN_MEDIA_QUEUE_SIZE))
+}
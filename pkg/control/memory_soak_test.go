@@ -0,0 +1,288 @@
+//go:build soak
+
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags soak -run TestMemorySoak -timeout 0 ./pkg/control -soak.duration 4h
+var (
+	soakDuration = flag.Duration("soak.duration", time.Hour, "how long the soak test streams")
+	soakInterval = flag.Duration("soak.interval", time.Minute, "how often the viewers change and the memory is sampled")
+)
+
+const (
+	// SOAK_CAMERAS is the number of livestreams of the soak test
+	SOAK_CAMERAS = 4
+	// SOAK_VIEWERS is the number of StreamMedia subscribers of each livestream
+	SOAK_VIEWERS = 3
+	// SOAK_BITRATE is the bitrate of each synthetic livestream in bits per second
+	SOAK_BITRATE = 4_000_000
+	// SOAK_HEAP_SLACK is the heap growth tolerated on top of 25% of the baseline,
+	// for the garbage the sessions leave between collections
+	SOAK_HEAP_SLACK = 4 << 20
+)
+
+// TestMemorySoak streams synthetic livestreams through sessions for
+// -soak.duration, replacing their viewers every -soak.interval, and checks that
+// the heap and the goroutines stay flat after the first interval.
+func TestMemorySoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the soak test runs for -soak.duration")
+	}
+
+	s := NewServer(Config{MemoryBudget: 256 << 20, OnLog: func(msg string) { t.Log(msg) }})
+	s.limitMemory()
+	t.Cleanup(func() { debug.SetMemoryLimit(math.MaxInt64) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.reportMemory(ctx)
+
+	var pumps sync.WaitGroup
+	sources := make([]*syntheticStream, SOAK_CAMERAS)
+	sessions := make([]*session, SOAK_CAMERAS)
+	for i := range sessions {
+		sources[i] = newSyntheticStream()
+		sessions[i] = newSession("", int64(i+1), 1, nil, s.mediaQueueSize())
+		s.mu.Lock()
+		s.sessions[sessions[i].cameraId] = sessions[i]
+		s.mu.Unlock()
+
+		pumps.Add(1)
+		go func() {
+			defer pumps.Done()
+			sessions[i].pump(sources[i])
+			s.remove(sessions[i])
+			sessions[i].close()
+		}()
+	}
+
+	var viewers []*soakViewer
+	var baseline MemoryStats
+	deadline := time.Now().Add(*soakDuration)
+	for sample := 0; time.Now().Before(deadline); sample++ {
+		for _, viewer := range viewers {
+			viewer.stop()
+		}
+		viewers = viewers[:0]
+		for _, sess := range sessions {
+			for range SOAK_VIEWERS {
+				viewers = append(viewers, newSoakViewer(sess))
+			}
+		}
+
+		time.Sleep(*soakInterval)
+
+		runtime.GC()
+		memory := s.Memory()
+		t.Logf("Sample %d: %d heap bytes, %d goroutines, %d collections", sample, memory.HeapBytes, memory.Goroutines, memory.GCCycles)
+		for _, sess := range sessions {
+			sess.mu.Lock()
+			received := sess.bytes
+			sess.mu.Unlock()
+			if received == 0 {
+				t.Fatalf("camera %d received no stream", sess.cameraId)
+			}
+		}
+		if sample == 0 {
+			// The first interval fills the pools and the queues
+			baseline = memory
+			continue
+		}
+		if limit := baseline.HeapBytes + baseline.HeapBytes/4 + SOAK_HEAP_SLACK; memory.HeapBytes > limit {
+			t.Errorf("sample %d: the heap grew to %d bytes from %d", sample, memory.HeapBytes, baseline.HeapBytes)
+		}
+		if memory.Goroutines != baseline.Goroutines {
+			t.Errorf("sample %d: %d goroutines, %d after the first interval", sample, memory.Goroutines, baseline.Goroutines)
+		}
+		if memory.HeapBytes > uint64(memory.Budget) {
+			t.Errorf("sample %d: the heap of %d bytes exceeds the budget of %d", sample, memory.HeapBytes, memory.Budget)
+		}
+	}
+
+	for _, viewer := range viewers {
+		viewer.stop()
+	}
+	for _, source := range sources {
+		source.Close()
+	}
+	pumps.Wait()
+
+	s.mu.Lock()
+	remaining := len(s.sessions)
+	s.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d sessions remain after their streams ended", remaining)
+	}
+}
+
+// soakViewer drains the chunks of a session like a StreamMedia call
+type soakViewer struct {
+	sess   *session
+	chunks chan []byte
+	done   chan struct{}
+}
+
+func newSoakViewer(sess *session) *soakViewer {
+	v := &soakViewer{sess: sess, chunks: sess.subscribe(), done: make(chan struct{})}
+	go func() {
+		defer close(v.done)
+		for range v.chunks {
+		}
+	}()
+
+	return v
+}
+
+// stop unsubscribes the viewer and waits for it to drain its queue
+func (v *soakViewer) stop() {
+	v.sess.unsubscribe(v.chunks)
+	v.sess.mu.Lock()
+	if !v.sess.closed {
+		close(v.chunks)
+	}
+	v.sess.mu.Unlock()
+	<-v.done
+}
+
+// syntheticStream is an endless H.264 livestream in MPEG-TS at SOAK_BITRATE, read
+// in chunks that do not align with the packets, like the liveview connection
+type syntheticStream struct {
+	// The stream bytes not read yet
+	pending []byte
+	// The next frame and its presentation timestamp
+	frame int
+	pts   int64
+	// The continuity counters by PID
+	counters map[uint16]byte
+	started  time.Time
+	sent     int
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newSyntheticStream() *syntheticStream {
+	return &syntheticStream{
+		counters: map[uint16]byte{},
+		started:  time.Now(),
+		closed:   make(chan struct{}),
+	}
+}
+
+const (
+	SYNTHETIC_PMT_PID   = 0x1000
+	SYNTHETIC_VIDEO_PID = 0x100
+	// The frames of a group of pictures, at 15 frames per second
+	SYNTHETIC_GOP = 30
+)
+
+func (s *syntheticStream) Read(p []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.EOF
+	default:
+	}
+
+	// Pace the stream to its bitrate
+	due := s.started.Add(time.Duration(float64(s.sent*8) / SOAK_BITRATE * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		select {
+		case <-s.closed:
+			return 0, io.EOF
+		case <-time.After(wait):
+		}
+	}
+
+	for len(s.pending) < 1500 {
+		s.pending = append(s.pending, s.nextFrame()...)
+	}
+	n := copy(p[:min(len(p), 1500)], s.pending)
+	s.pending = s.pending[:copy(s.pending, s.pending[n:])]
+	s.sent += n
+
+	return n, nil
+}
+
+// Close ends the stream
+func (s *syntheticStream) Close() error {
+	s.once.Do(func() { close(s.closed) })
+
+	return nil
+}
+
+// nextFrame returns the packets of the next frame, preceded by the PAT and PMT on
+// keyframes
+func (s *syntheticStream) nextFrame() []byte {
+	keyframe := s.frame%SYNTHETIC_GOP == 0
+	var out []byte
+	if keyframe {
+		out = append(out, s.packet(mpegts.PID_PAT, true, []byte{
+			0x00, 0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00,
+			0x00, 0x01, 0xe0 | SYNTHETIC_PMT_PID>>8, SYNTHETIC_PMT_PID & 0xff,
+			0, 0, 0, 0,
+		})...)
+		out = append(out, s.packet(SYNTHETIC_PMT_PID, true, []byte{
+			0x00, 0x02, 0xb0, 18, 0x00, 0x01, 0xc1, 0x00, 0x00,
+			0xe0 | SYNTHETIC_VIDEO_PID>>8, SYNTHETIC_VIDEO_PID & 0xff, 0xf0, 0x00,
+			mpegts.STREAM_TYPE_H264, 0xe0 | SYNTHETIC_VIDEO_PID>>8, SYNTHETIC_VIDEO_PID & 0xff, 0xf0, 0x00,
+			0, 0, 0, 0,
+		})...)
+	}
+
+	// Keyframes are larger, like those of a camera
+	size := 184*8 - 14
+	nalType := byte(mpegts.H264_NAL_IDR)
+	if !keyframe {
+		size = 184*3 - 14
+		nalType = 1
+	}
+	pts := s.pts
+	pes := []byte{
+		0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05,
+		0x21 | byte(pts>>29)&0x0e, byte(pts >> 22), byte(pts>>14) | 1, byte(pts >> 7), byte(pts<<1) | 1,
+	}
+	pes = append(pes, 0x00, 0x00, 0x00, 0x01, 0x60|nalType)
+	pes = append(pes, make([]byte, size-5)...)
+	for i := 0; i < len(pes); i += 184 {
+		out = append(out, s.packet(SYNTHETIC_VIDEO_PID, i == 0, pes[i:i+184])...)
+	}
+
+	s.frame++
+	s.pts += 6000
+
+	return out
+}
+
+// packet returns a transport stream packet of a PID, padding short payloads
+func (s *syntheticStream) packet(pid uint16, start bool, payload []byte) []byte {
+	pkt := make([]byte, mpegts.PACKET_SIZE)
+	for i := range pkt {
+		pkt[i] = 0xff
+	}
+	pkt[0] = mpegts.SYNC_BYTE
+	pkt[1] = byte(pid>>8) & 0x1f
+	if start {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | s.counters[pid]&0x0f
+	s.counters[pid]++
+	if len(payload) > 184 {
+		panic(fmt.Sprintf("payload of %d bytes", len(payload)))
+	}
+	copy(pkt[4:], payload)
+
+	return pkt
+}
@@ -0,0 +1,491 @@
+package control
+
+// Message types of the blink.control.v1 service defined in control.proto. Each type
+// encodes to and decodes from the protobuf wire format. messages_test.go checks the
+// types against the field numbers and types of control.proto, so both change
+// together.
+
+type StartLiveviewRequest struct {
+	NetworkId int64
+	CameraId  int64
+	// Optional device type (camera, owl, doorbell); detected if empty
+	DeviceType string
+	// Optional name of the account of the camera; looked up if empty
+	Account string
+}
+
+func (m *StartLiveviewRequest) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(m.NetworkId))
+	b = appendVarint(b, 2, uint64(m.CameraId))
+	b = appendBytes(b, 3, []byte(m.DeviceType))
+	b = appendBytes(b, 4, []byte(m.Account))
+
+	return b
+}
+
+func (m *StartLiveviewRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.NetworkId = int64(f.value)
+		case 2:
+			m.CameraId = int64(f.value)
+		case 3:
+			m.DeviceType = string(f.data)
+		case 4:
+			m.Account = string(f.data)
+		}
+		return nil
+	})
+}
+
+type StartLiveviewResponse struct {
+	Session Session
+}
+
+func (m *StartLiveviewResponse) Marshal() []byte {
+	return appendMessage(nil, 1, m.Session.Marshal())
+}
+
+func (m *StartLiveviewResponse) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		if f.number == 1 {
+			return m.Session.Unmarshal(f.data)
+		}
+		return nil
+	})
+}
+
+type StartLiveviewsRequest struct {
+	Cameras []StartLiveviewRequest
+}
+
+func (m *StartLiveviewsRequest) Marshal() []byte {
+	var b []byte
+	for i := range m.Cameras {
+		b = appendMessage(b, 1, m.Cameras[i].Marshal())
+	}
+
+	return b
+}
+
+func (m *StartLiveviewsRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		if f.number == 1 {
+			var camera StartLiveviewRequest
+			if err := camera.Unmarshal(f.data); err != nil {
+				return err
+			}
+			m.Cameras = append(m.Cameras, camera)
+		}
+		return nil
+	})
+}
+
+type StartError struct {
+	CameraId int64
+	// Why the camera did not start
+	Message string
+}
+
+func (m *StartError) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(m.CameraId))
+	b = appendBytes(b, 2, []byte(m.Message))
+
+	return b
+}
+
+func (m *StartError) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.CameraId = int64(f.value)
+		case 2:
+			m.Message = string(f.data)
+		}
+		return nil
+	})
+}
+
+type StartLiveviewsResponse struct {
+	// The sessions of the cameras that started
+	Sessions []Session
+	// The cameras that failed to start
+	Errors []StartError
+}
+
+func (m *StartLiveviewsResponse) Marshal() []byte {
+	var b []byte
+	for i := range m.Sessions {
+		b = appendMessage(b, 1, m.Sessions[i].Marshal())
+	}
+	for i := range m.Errors {
+		b = appendMessage(b, 2, m.Errors[i].Marshal())
+	}
+
+	return b
+}
+
+func (m *StartLiveviewsResponse) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			var session Session
+			if err := session.Unmarshal(f.data); err != nil {
+				return err
+			}
+			m.Sessions = append(m.Sessions, session)
+		case 2:
+			var startError StartError
+			if err := startError.Unmarshal(f.data); err != nil {
+				return err
+			}
+			m.Errors = append(m.Errors, startError)
+		}
+		return nil
+	})
+}
+
+type StopLiveviewRequest struct {
+	CameraId int64
+}
+
+func (m *StopLiveviewRequest) Marshal() []byte {
+	return appendVarint(nil, 1, uint64(m.CameraId))
+}
+
+func (m *StopLiveviewRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		if f.number == 1 {
+			m.CameraId = int64(f.value)
+		}
+		return nil
+	})
+}
+
+type StopLiveviewResponse struct{}
+
+func (m *StopLiveviewResponse) Marshal() []byte {
+	return nil
+}
+
+func (m *StopLiveviewResponse) Unmarshal(b []byte) error {
+	return decodeFields(b, func(field) error { return nil })
+}
+
+type StreamMediaRequest struct {
+	CameraId int64
+	// Optional network of the camera, for starting it on demand; looked up if zero
+	NetworkId int64
+	// Optional name of the account of the camera, for starting it on demand;
+	// looked up if empty
+	Account string
+}
+
+func (m *StreamMediaRequest) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(m.CameraId))
+	b = appendVarint(b, 2, uint64(m.NetworkId))
+	b = appendBytes(b, 3, []byte(m.Account))
+
+	return b
+}
+
+func (m *StreamMediaRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.CameraId = int64(f.value)
+		case 2:
+			m.NetworkId = int64(f.value)
+		case 3:
+			m.Account = string(f.data)
+		}
+		return nil
+	})
+}
+
+type MediaChunk struct {
+	// Whole MPEG-TS packets
+	Data []byte
+}
+
+func (m *MediaChunk) Marshal() []byte {
+	return appendBytes(nil, 1, m.Data)
+}
+
+func (m *MediaChunk) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		if f.number == 1 {
+			m.Data = append([]byte(nil), f.data...)
+		}
+		return nil
+	})
+}
+
+type ListDevicesRequest struct{}
+
+func (m *ListDevicesRequest) Marshal() []byte {
+	return nil
+}
+
+func (m *ListDevicesRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(field) error { return nil })
+}
+
+type Device struct {
+	Id        int64
+	NetworkId int64
+	Name      string
+	// The device type (camera, owl, doorbell)
+	Type   string
+	Status string
+	// The name of the account of the device, empty for the default account
+	Account string
+}
+
+func (m *Device) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(m.Id))
+	b = appendVarint(b, 2, uint64(m.NetworkId))
+	b = appendBytes(b, 3, []byte(m.Name))
+	b = appendBytes(b, 4, []byte(m.Type))
+	b = appendBytes(b, 5, []byte(m.Status))
+	b = appendBytes(b, 6, []byte(m.Account))
+
+	return b
+}
+
+func (m *Device) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Id = int64(f.value)
+		case 2:
+			m.NetworkId = int64(f.value)
+		case 3:
+			m.Name = string(f.data)
+		case 4:
+			m.Type = string(f.data)
+		case 5:
+			m.Status = string(f.data)
+		case 6:
+			m.Account = string(f.data)
+		}
+		return nil
+	})
+}
+
+type ListDevicesResponse struct {
+	Devices []Device
+}
+
+func (m *ListDevicesResponse) Marshal() []byte {
+	var b []byte
+	for i := range m.Devices {
+		b = appendMessage(b, 1, m.Devices[i].Marshal())
+	}
+
+	return b
+}
+
+func (m *ListDevicesResponse) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		if f.number == 1 {
+			var device Device
+			if err := device.Unmarshal(f.data); err != nil {
+				return err
+			}
+			m.Devices = append(m.Devices, device)
+		}
+		return nil
+	})
+}
+
+type GetStatsRequest struct{}
+
+func (m *GetStatsRequest) Marshal() []byte {
+	return nil
+}
+
+func (m *GetStatsRequest) Unmarshal(b []byte) error {
+	return decodeFields(b, func(field) error { return nil })
+}
+
+type Session struct {
+	CameraId  int64
+	NetworkId int64
+	// The client state (connecting, streaming, stopping)
+	State string
+	// When the session was started, in Unix seconds
+	StartedAt int64
+	// The number of stream bytes received
+	Bytes uint64
+	// The number of StreamMedia calls receiving the stream
+	Subscribers int32
+	// The number of chunks dropped for slow subscribers
+	DroppedChunks uint64
+	// The RTSP path the livestream is served at, or empty without RTSP
+	Stream string
+	// The name of the account of the camera, empty for the default account
+	Account string
+	// The liveview server the session streams from, or nil before it connected
+	Server *SessionServer
+}
+
+// SessionServer is the liveview server of the active command of a session
+type SessionServer struct {
+	// The host of the liveview server
+	Host string
+	// The connection and client IDs sent in the authentication frame
+	ConnectionId string
+	ClientId     int64
+	// The Blink command ID of the livestream
+	CommandId int64
+	// The command polling interval in seconds
+	PollingInterval int32
+}
+
+func (m *Session) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, uint64(m.CameraId))
+	b = appendVarint(b, 2, uint64(m.NetworkId))
+	b = appendBytes(b, 3, []byte(m.State))
+	b = appendVarint(b, 4, uint64(m.StartedAt))
+	b = appendVarint(b, 5, m.Bytes)
+	b = appendVarint(b, 6, uint64(m.Subscribers))
+	b = appendVarint(b, 7, m.DroppedChunks)
+	b = appendBytes(b, 8, []byte(m.Stream))
+	b = appendBytes(b, 9, []byte(m.Account))
+	if m.Server != nil {
+		b = appendMessage(b, 10, m.Server.Marshal())
+	}
+
+	return b
+}
+
+func (m *Session) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.CameraId = int64(f.value)
+		case 2:
+			m.NetworkId = int64(f.value)
+		case 3:
+			m.State = string(f.data)
+		case 4:
+			m.StartedAt = int64(f.value)
+		case 5:
+			m.Bytes = f.value
+		case 6:
+			m.Subscribers = int32(f.value)
+		case 7:
+			m.DroppedChunks = f.value
+		case 8:
+			m.Stream = string(f.data)
+		case 9:
+			m.Account = string(f.data)
+		case 10:
+			m.Server = &SessionServer{}
+			return m.Server.Unmarshal(f.data)
+		}
+		return nil
+	})
+}
+
+func (m *SessionServer) Marshal() []byte {
+	var b []byte
+	b = appendBytes(b, 1, []byte(m.Host))
+	b = appendBytes(b, 2, []byte(m.ConnectionId))
+	b = appendVarint(b, 3, uint64(m.ClientId))
+	b = appendVarint(b, 4, uint64(m.CommandId))
+	b = appendVarint(b, 5, uint64(m.PollingInterval))
+
+	return b
+}
+
+func (m *SessionServer) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.Host = string(f.data)
+		case 2:
+			m.ConnectionId = string(f.data)
+		case 3:
+			m.ClientId = int64(f.value)
+		case 4:
+			m.CommandId = int64(f.value)
+		case 5:
+			m.PollingInterval = int32(f.value)
+		}
+		return nil
+	})
+}
+
+type Stats struct {
+	Sessions []Session
+	// The server uptime in seconds
+	Uptime int64
+	// The memory use of the server
+	Memory MemoryStats
+}
+
+func (m *Stats) Marshal() []byte {
+	var b []byte
+	for i := range m.Sessions {
+		b = appendMessage(b, 1, m.Sessions[i].Marshal())
+	}
+	b = appendVarint(b, 2, uint64(m.Uptime))
+	b = appendMessage(b, 3, m.Memory.Marshal())
+
+	return b
+}
+
+func (m *Stats) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			var session Session
+			if err := session.Unmarshal(f.data); err != nil {
+				return err
+			}
+			m.Sessions = append(m.Sessions, session)
+		case 2:
+			m.Uptime = int64(f.value)
+		case 3:
+			return m.Memory.Unmarshal(f.data)
+		}
+		return nil
+	})
+}
+
+func (m *MemoryStats) Marshal() []byte {
+	var b []byte
+	b = appendVarint(b, 1, m.HeapBytes)
+	b = appendVarint(b, 2, m.SysBytes)
+	b = appendVarint(b, 3, uint64(m.Goroutines))
+	b = appendVarint(b, 4, uint64(m.GCCycles))
+	b = appendVarint(b, 5, uint64(m.Budget))
+
+	return b
+}
+
+func (m *MemoryStats) Unmarshal(b []byte) error {
+	return decodeFields(b, func(f field) error {
+		switch f.number {
+		case 1:
+			m.HeapBytes = f.value
+		case 2:
+			m.SysBytes = f.value
+		case 3:
+			m.Goroutines = int32(f.value)
+		case 4:
+			m.GCCycles = uint32(f.value)
+		case 5:
+			m.Budget = int64(f.value)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,262 @@
+package control
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// messageTypes are the Go types of the messages of control.proto
+var messageTypes = map[string]func() message{
+	"StartLiveviewRequest":   func() message { return &StartLiveviewRequest{} },
+	"StartLiveviewResponse":  func() message { return &StartLiveviewResponse{} },
+	"StartLiveviewsRequest":  func() message { return &StartLiveviewsRequest{} },
+	"StartError":             func() message { return &StartError{} },
+	"StartLiveviewsResponse": func() message { return &StartLiveviewsResponse{} },
+	"StopLiveviewRequest":    func() message { return &StopLiveviewRequest{} },
+	"StopLiveviewResponse":   func() message { return &StopLiveviewResponse{} },
+	"StreamMediaRequest":     func() message { return &StreamMediaRequest{} },
+	"MediaChunk":             func() message { return &MediaChunk{} },
+	"ListDevicesRequest":     func() message { return &ListDevicesRequest{} },
+	"Device":                 func() message { return &Device{} },
+	"ListDevicesResponse":    func() message { return &ListDevicesResponse{} },
+	"GetStatsRequest":        func() message { return &GetStatsRequest{} },
+	"Session":                func() message { return &Session{} },
+	"SessionServer":          func() message { return &SessionServer{} },
+	"Stats":                  func() message { return &Stats{} },
+	"MemoryStats":            func() message { return &MemoryStats{} },
+}
+
+// protoField is a field of a message declared in control.proto
+type protoField struct {
+	name     string
+	kind     string
+	number   int
+	repeated bool
+}
+
+var (
+	protoMessagePattern = regexp.MustCompile(`^message (\w+) \{(\})?$`)
+	protoFieldPattern   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+// parseProto returns the fields of each message declared in control.proto
+func parseProto(t *testing.T) map[string][]protoField {
+	source, err := os.ReadFile("control.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := map[string][]protoField{}
+	current := ""
+	for _, line := range strings.Split(string(source), "\n") {
+		line = strings.TrimSpace(line)
+		if m := protoMessagePattern.FindStringSubmatch(line); m != nil {
+			messages[m[1]] = nil
+			if m[2] == "" {
+				current = m[1]
+			}
+			continue
+		}
+		if current == "" {
+			continue
+		}
+		if line == "}" {
+			current = ""
+			continue
+		}
+		if m := protoFieldPattern.FindStringSubmatch(line); m != nil {
+			number, _ := strconv.Atoi(m[4])
+			messages[current] = append(messages[current], protoField{name: m[3], kind: m[2], number: number, repeated: m[1] != ""})
+		}
+	}
+
+	return messages
+}
+
+// goField returns the field of a Go message type named like a proto field, e.g.
+// NetworkId for network_id
+func goField(typ reflect.Type, name string) (reflect.StructField, bool) {
+	return typ.FieldByNameFunc(func(goName string) bool {
+		return strings.EqualFold(goName, strings.ReplaceAll(name, "_", ""))
+	})
+}
+
+// goKinds are the Go types of the scalar proto types
+var goKinds = map[string]reflect.Type{
+	"int64":  reflect.TypeOf(int64(0)),
+	"int32":  reflect.TypeOf(int32(0)),
+	"uint64": reflect.TypeOf(uint64(0)),
+	"uint32": reflect.TypeOf(uint32(0)),
+	"string": reflect.TypeOf(""),
+	"bytes":  reflect.TypeOf([]byte(nil)),
+}
+
+// fill sets every field of a message to a value derived from its field number, so
+// a field encoded under the wrong number decodes to a different value
+func fill(t *testing.T, messages map[string][]protoField, name string, v reflect.Value) {
+	for _, f := range messages[name] {
+		goF, _ := goField(v.Type(), f.name)
+		target := v.FieldByIndex(goF.Index)
+		if f.repeated {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			fillValue(t, messages, f, elem)
+			target.Set(reflect.Append(reflect.MakeSlice(target.Type(), 0, 2), elem, elem))
+			continue
+		}
+		fillValue(t, messages, f, target)
+	}
+}
+
+func fillValue(t *testing.T, messages map[string][]protoField, f protoField, v reflect.Value) {
+	n := int64(f.number)*1000 + 7
+	switch f.kind {
+	case "int64", "int32":
+		// Negative values check the sign extension of the varints
+		v.SetInt(-n)
+	case "uint64", "uint32":
+		v.SetUint(uint64(n))
+	case "string":
+		v.SetString(f.name + "-" + strconv.Itoa(f.number))
+	case "bytes":
+		v.SetBytes([]byte{byte(f.number), 0xff, 0x00})
+	default:
+		if v.Kind() == reflect.Pointer {
+			v.Set(reflect.New(v.Type().Elem()))
+			v = v.Elem()
+		}
+		fill(t, messages, f.kind, v)
+	}
+}
+
+// TestMessagesMatchProto checks that every message of control.proto has a Go type
+// with the same fields, types, and field numbers, so the hand-written codec cannot
+// drift from the contract the clients of other languages generate from.
+func TestMessagesMatchProto(t *testing.T) {
+	messages := parseProto(t)
+	for name := range messageTypes {
+		if _, ok := messages[name]; !ok {
+			t.Errorf("%s is not declared in control.proto", name)
+		}
+	}
+
+	for name, fields := range messages {
+		newMessage, ok := messageTypes[name]
+		if !ok {
+			t.Errorf("message %s of control.proto has no Go type", name)
+			continue
+		}
+
+		typ := reflect.TypeOf(newMessage()).Elem()
+		if typ.NumField() != len(fields) {
+			t.Errorf("%s has %d Go fields, control.proto declares %d", name, typ.NumField(), len(fields))
+		}
+		for _, f := range fields {
+			goF, ok := goField(typ, f.name)
+			if !ok {
+				t.Errorf("%s.%s has no Go field", name, f.name)
+				continue
+			}
+			goType := goF.Type
+			if f.repeated {
+				if goType.Kind() != reflect.Slice {
+					t.Errorf("%s.%s is repeated, its Go field is %s", name, f.name, goType)
+					continue
+				}
+				goType = goType.Elem()
+			}
+			if goType.Kind() == reflect.Pointer {
+				goType = goType.Elem()
+			}
+			if want, ok := goKinds[f.kind]; ok && goType != want {
+				t.Errorf("%s.%s is %s, its Go field is %s", name, f.name, f.kind, goType)
+			} else if !ok && goType.Name() != f.kind {
+				t.Errorf("%s.%s is %s, its Go field is %s", name, f.name, f.kind, goType)
+			}
+		}
+	}
+}
+
+// TestMessagesRoundTrip checks that every message encodes each field under the
+// number and wire type of control.proto and decodes back to the same value.
+func TestMessagesRoundTrip(t *testing.T) {
+	messages := parseProto(t)
+	for name, fields := range messages {
+		newMessage, ok := messageTypes[name]
+		if !ok {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			original := newMessage()
+			fill(t, messages, name, reflect.ValueOf(original).Elem())
+			encoded := original.Marshal()
+
+			seen := map[int]int{}
+			err := decodeFields(encoded, func(f field) error {
+				seen[f.number]++
+				for _, want := range fields {
+					if want.number != f.number {
+						continue
+					}
+					checkWireValue(t, name, want, f)
+					return nil
+				}
+				t.Errorf("field %d is not declared in control.proto", f.number)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range fields {
+				count := 1
+				if f.repeated {
+					count = 2
+				}
+				if seen[f.number] != count {
+					t.Errorf("field %s = %d was encoded %d times, want %d", f.name, f.number, seen[f.number], count)
+				}
+			}
+
+			decoded := newMessage()
+			if err := decoded.Unmarshal(encoded); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(original, decoded) {
+				t.Errorf("decoded %+v, want %+v", decoded, original)
+			}
+		})
+	}
+}
+
+// checkWireValue checks the wire type and value of an encoded field against the
+// value fill set
+func checkWireValue(t *testing.T, name string, want protoField, f field) {
+	n := int64(want.number)*1000 + 7
+	switch want.kind {
+	case "int64", "int32":
+		if f.data != nil || f.value != uint64(-n) {
+			t.Errorf("%s.%s encoded %d, want varint %d", name, want.name, int64(f.value), -n)
+		}
+	case "uint64", "uint32":
+		if f.data != nil || f.value != uint64(n) {
+			t.Errorf("%s.%s encoded %d, want varint %d", name, want.name, f.value, n)
+		}
+	case "string":
+		if string(f.data) != want.name+"-"+strconv.Itoa(want.number) {
+			t.Errorf("%s.%s encoded %q", name, want.name, f.data)
+		}
+	case "bytes":
+		if !bytes.Equal(f.data, []byte{byte(want.number), 0xff, 0x00}) {
+			t.Errorf("%s.%s encoded %x", name, want.name, f.data)
+		}
+	default:
+		if f.data == nil {
+			t.Errorf("%s.%s is a message, encoded as a varint", name, want.name)
+		}
+	}
+}
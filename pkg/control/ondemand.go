@@ -0,0 +1,215 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ON_DEMAND_START_TIMEOUT bounds how long an RTSP client waits for the livestream
+// it started
+const ON_DEMAND_START_TIMEOUT = time.Minute
+
+// IdleStop describes a livestream stopped for having no viewers
+type IdleStop struct {
+	CameraId  int64
+	NetworkId int64
+	// The idle timeout of the camera
+	Timeout time.Duration
+	// How long the livestream ran
+	Duration time.Duration
+}
+
+// idleTimeout returns how long the livestream of a camera runs without viewers,
+// or 0 if it runs until stopped. The profile of the camera comes first, then
+// IdleTimeouts, the default profile, and IdleTimeout.
+func (s *Server) idleTimeout(cameraId int64) time.Duration {
+	timeout, ok := s.config.IdleTimeouts[cameraId]
+	if profile, found := s.config.Profiles[cameraId]; found && profile.IdleTimeout != 0 {
+		timeout, ok = profile.IdleTimeout, true
+	}
+	if !ok && s.config.DefaultProfile.IdleTimeout != 0 {
+		timeout, ok = s.config.DefaultProfile.IdleTimeout, true
+	}
+	if !ok {
+		timeout = s.config.IdleTimeout
+	}
+
+	return max(timeout, 0)
+}
+
+// attach admits a viewer of a camera through the limits and cancels the idle
+// shutdown of its livestream. The returned function detaches the viewer.
+func (s *Server) attach(cameraId int64) (func(), error) {
+	release, err := s.limiter.view(cameraId)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	sess := s.sessions[cameraId]
+	s.mu.Unlock()
+	if sess != nil {
+		sess.mu.Lock()
+		if sess.idle != nil {
+			sess.idle.Stop()
+			sess.idle = nil
+		}
+		sess.mu.Unlock()
+	}
+
+	return func() {
+		release()
+
+		// The viewer may have outlived the livestream it attached to
+		s.mu.Lock()
+		sess := s.sessions[cameraId]
+		s.mu.Unlock()
+		if sess != nil {
+			s.idle(sess)
+		}
+	}, nil
+}
+
+// idle schedules the shutdown of a livestream without viewers after the idle
+// timeout of its camera
+func (s *Server) idle(sess *session) {
+	timeout := s.idleTimeout(sess.cameraId)
+	if timeout == 0 || s.limiter.viewing(sess.cameraId) > 0 || s.isScheduled(sess.cameraId) {
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.closed {
+		return
+	}
+	if sess.idle != nil {
+		sess.idle.Stop()
+	}
+	sess.idle = time.AfterFunc(timeout, func() {
+		sess.mu.Lock()
+		closed := sess.closed
+		sess.mu.Unlock()
+		if closed || s.limiter.viewing(sess.cameraId) > 0 || s.isScheduled(sess.cameraId) {
+			return
+		}
+
+		s.config.OnLog(fmt.Sprintf("Stopping camera %d after %s without viewers", sess.cameraId, timeout))
+		s.config.OnIdleStop(IdleStop{
+			CameraId:  sess.cameraId,
+			NetworkId: sess.networkId,
+			Timeout:   timeout,
+			Duration:  time.Since(sess.started),
+		})
+		sess.client.Disconnect()
+	})
+}
+
+// startOnDemand starts the livestream of a camera for its first viewer
+func (s *Server) startOnDemand(ctx context.Context, account string, cameraId int64, networkId int64) error {
+	s.config.OnLog(fmt.Sprintf("Starting camera %d on demand", cameraId))
+
+	return s.startCamera(ctx, account, cameraId, networkId)
+}
+
+// startCamera starts the livestream of a camera, looking up its account if it is
+// empty and its network on the homescreen if it is unknown
+func (s *Server) startCamera(ctx context.Context, account string, cameraId int64, networkId int64) error {
+	account, err := s.accountOf(ctx, account, cameraId)
+	if err != nil {
+		return err
+	}
+
+	req := &StartLiveviewRequest{NetworkId: networkId, CameraId: cameraId, Account: account}
+	if networkId == 0 {
+		homescreen, err := s.homescreen(ctx, account)
+		if err != nil {
+			return statusError(CODE_UNAVAILABLE, "error looking up camera %d: %v", cameraId, err)
+		}
+		device, err := homescreen.Device(int(cameraId), 0)
+		if err != nil {
+			return statusError(CODE_NOT_FOUND, "%v", err)
+		}
+		req.NetworkId = int64(device.NetworkId)
+		req.DeviceType, _ = homescreen.DeviceType(device.Id, device.NetworkId)
+	}
+
+	_, err = s.StartLiveview(ctx, req)
+
+	return err
+}
+
+// streamCamera returns the camera served at an RTSP path, or 0 if none is
+func (s *Server) streamCamera(path string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		sess.mu.Lock()
+		name := sess.streamName
+		sess.mu.Unlock()
+		if name == path {
+			return sess.cameraId
+		}
+	}
+	for cameraId, name := range s.config.StreamNames {
+		if strings.Trim(name, "/") == path {
+			return cameraId
+		}
+	}
+	for cameraId, name := range s.names {
+		if name == path {
+			return cameraId
+		}
+	}
+	// The cameras of named accounts are served at <account>/camera-<id>
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		path = path[i+1:]
+	}
+	if id, ok := strings.CutPrefix(path, "camera-"); ok {
+		if cameraId, err := strconv.ParseInt(id, 10, 64); err == nil {
+			return cameraId
+		}
+	}
+
+	return 0
+}
+
+// admitRTSP admits an RTSP session as a viewer of the camera streamed at the path
+func (s *Server) admitRTSP(path string) (func(), error) {
+	return s.attach(s.streamCamera(path))
+}
+
+// demandRTSP starts the livestream of the camera served at an RTSP path, unless it
+// is streaming already
+func (s *Server) demandRTSP(path string) error {
+	cameraId := s.streamCamera(path)
+	if cameraId == 0 {
+		// The cameras are named after the homescreen once it is requested
+		if err := s.loadNames(); err != nil {
+			return err
+		}
+		if cameraId = s.streamCamera(path); cameraId == 0 {
+			return fmt.Errorf("no camera is named %q", path)
+		}
+	}
+	if s.profile(cameraId).DisableRTSP {
+		return fmt.Errorf("camera %d is not served over RTSP", cameraId)
+	}
+
+	s.mu.Lock()
+	_, ok := s.sessions[cameraId]
+	s.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ON_DEMAND_START_TIMEOUT)
+	defer cancel()
+
+	return s.startOnDemand(ctx, "", cameraId, 0)
+}
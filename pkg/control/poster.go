@@ -0,0 +1,59 @@
+package control
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// posterImage is the JPEG preview of a camera
+type posterImage struct {
+	// The JPEG image
+	data []byte
+	// When the keyframe was captured
+	captured time.Time
+}
+
+// Poster returns the JPEG image of the first keyframe of the latest livestream of
+// the camera, and whether one was captured.
+//
+// cameraId: the ID of the camera
+//
+// Example: Poster(11111) = []byte{0xff, 0xd8, ...}, true
+func (s *Server) Poster(cameraId int64) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image, ok := s.posters[cameraId]
+
+	return image.data, ok
+}
+
+func (s *Server) setPoster(cameraId int64, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.posters[cameraId] = posterImage{data: data, captured: time.Now()}
+}
+
+// servePoster serves GET /cameras/{id}/poster.jpg
+func (s *Server) servePoster(w http.ResponseWriter, r *http.Request) {
+	cameraId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	image, ok := s.posters[cameraId]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "no poster for this camera yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "poster.jpg", image.captured, bytes.NewReader(image.data))
+}
@@ -0,0 +1,218 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/output/buffer"
+	"amattu2/blink-middleware/pkg/output/record"
+	"amattu2/blink-middleware/pkg/pipeline"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile configures the livestream of a camera: where its stream goes besides
+// the gRPC and RTSP viewers, how long its recordings are kept, its quality, and
+// its idle timeout. E.g. one camera may be recorded and served over RTSP while
+// another only feeds an HLS packager.
+type Profile struct {
+	// Optional sink specs the stream is written to, as accepted by pipeline.Build
+	// (e.g. "record:/recordings/{name}" or "exec:ffmpeg -i - -f hls ..."). "{camera}"
+	// is replaced by the camera ID and "{name}" by its RTSP path
+	Outputs []string
+	// Optional filter specs applied before the outputs (e.g. "streams:video")
+	Filters []string
+	// Whether the camera is left off the RTSP server
+	DisableRTSP bool
+	// Maximum age of the recordings of the record: outputs. Zero keeps them
+	Retain time.Duration
+	// Maximum total size of the recordings of each record: output in bytes. Zero
+	// is unlimited
+	MaxRecordingSize int64
+	// The requested stream quality (e.g. QUALITY_HIGH). Empty uses the quality of
+	// ClientConfig
+	Quality string
+	// How long the livestream runs without viewers. Zero uses the idle timeout of
+	// Config; negative runs it until StopLiveview
+	IdleTimeout time.Duration
+}
+
+// profileJSON is a Profile in the file read by LoadProfiles
+type profileJSON struct {
+	Outputs     []string `json:"outputs,omitempty"`
+	Filters     []string `json:"filters,omitempty"`
+	DisableRTSP bool     `json:"disable_rtsp,omitempty"`
+	Retain      string   `json:"retain,omitempty"`
+	RetainSize  string   `json:"retain_size,omitempty"`
+	Quality     string   `json:"quality,omitempty"`
+	IdleTimeout string   `json:"idle_timeout,omitempty"`
+}
+
+// profilesFile is the JSON file read by LoadProfiles
+type profilesFile struct {
+	// Profile of the cameras missing from Cameras
+	Default *profileJSON `json:"default,omitempty"`
+	// Profiles keyed by camera ID
+	Cameras map[string]profileJSON `json:"cameras"`
+}
+
+// LoadProfiles reads the camera profiles from a JSON file with an optional
+// "default" profile and the "cameras" profiles keyed by camera ID. Durations are
+// written like "10m" or "168h" and sizes like "50GB".
+//
+// path: the file path
+//
+// Example: LoadProfiles("profiles.json") = Profile{...}, map[int64]Profile{11111: {...}}, nil
+func LoadProfiles(path string) (Profile, map[int64]Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, nil, err
+	}
+
+	var file profilesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Profile{}, nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	var defaultProfile Profile
+	if file.Default != nil {
+		if defaultProfile, err = file.Default.profile(); err != nil {
+			return Profile{}, nil, fmt.Errorf("error in %s: default profile: %w", path, err)
+		}
+	}
+	profiles := map[int64]Profile{}
+	for camera, p := range file.Cameras {
+		cameraId, err := strconv.ParseInt(camera, 10, 64)
+		if err != nil || cameraId <= 0 {
+			return Profile{}, nil, fmt.Errorf("error in %s: %q is not a camera ID", path, camera)
+		}
+		if profiles[cameraId], err = p.profile(); err != nil {
+			return Profile{}, nil, fmt.Errorf("error in %s: camera %d: %w", path, cameraId, err)
+		}
+	}
+
+	return defaultProfile, profiles, nil
+}
+
+// profile validates the profile and parses its durations
+func (p profileJSON) profile() (Profile, error) {
+	profile := Profile{
+		Outputs:     p.Outputs,
+		Filters:     p.Filters,
+		DisableRTSP: p.DisableRTSP,
+		Quality:     p.Quality,
+	}
+
+	var err error
+	if p.Retain != "" {
+		if profile.Retain, err = time.ParseDuration(p.Retain); err != nil || profile.Retain < 0 {
+			return profile, fmt.Errorf("invalid retain %q", p.Retain)
+		}
+	}
+	if p.IdleTimeout != "" {
+		if profile.IdleTimeout, err = time.ParseDuration(p.IdleTimeout); err != nil {
+			return profile, fmt.Errorf("invalid idle_timeout %q", p.IdleTimeout)
+		}
+	}
+	if p.RetainSize != "" {
+		if profile.MaxRecordingSize, err = record.ParseSize(p.RetainSize); err != nil {
+			return profile, fmt.Errorf("invalid retain_size %q: %w", p.RetainSize, err)
+		}
+	}
+	if p.Quality != "" && !slices.Contains([]string{blinkapi.QUALITY_AUTO, blinkapi.QUALITY_LOW, blinkapi.QUALITY_HIGH}, p.Quality) {
+		return profile, fmt.Errorf("quality %q is not %s, %s or %s", p.Quality, blinkapi.QUALITY_AUTO, blinkapi.QUALITY_LOW, blinkapi.QUALITY_HIGH)
+	}
+	if (profile.Retain > 0 || profile.MaxRecordingSize > 0) && !slices.ContainsFunc(profile.Outputs, func(output string) bool {
+		return strings.HasPrefix(output, "record:")
+	}) {
+		return profile, fmt.Errorf("retain and retain_size require a record: output")
+	}
+
+	return profile, nil
+}
+
+// profile returns the profile of a camera, or the default profile
+func (s *Server) profile(cameraId int64) Profile {
+	if profile, ok := s.config.Profiles[cameraId]; ok {
+		return profile
+	}
+
+	return s.config.DefaultProfile
+}
+
+// outputs returns the output specs of the profile for a camera, with "{camera}"
+// and "{name}" replaced
+func (p Profile) outputs(cameraId int64, name string) []string {
+	replacer := strings.NewReplacer("{camera}", strconv.FormatInt(cameraId, 10), "{name}", name)
+	outputs := make([]string, len(p.Outputs))
+	for i, output := range p.Outputs {
+		outputs[i] = replacer.Replace(output)
+	}
+
+	return outputs
+}
+
+// openOutputs builds the pipeline writing the stream of a session to the outputs
+// of its profile, and starts the retention of its recordings. It returns nil if
+// the profile has no outputs.
+func (s *Server) openOutputs(sess *session, profile Profile) (*pipeline.Pipeline, error) {
+	if len(profile.Outputs) == 0 {
+		return nil, nil
+	}
+
+	name := s.streamName(sess.account, sess.cameraId)
+	outputs := profile.outputs(sess.cameraId, name)
+
+	p, err := pipeline.Build(pipeline.Spec{
+		Filters:    profile.Filters,
+		Sinks:      outputs,
+		BufferSize: s.outputBufferSize(len(outputs)),
+		Policy:     buffer.POLICY_DROP,
+	}, pipeline.Options{
+		StreamName: name,
+		Metrics:    s.config.ClientConfig.Metrics,
+		OnLog:      s.config.OnLog,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.Retain > 0 || profile.MaxRecordingSize > 0 {
+		for _, output := range outputs {
+			if dir, ok := strings.CutPrefix(output, "record:"); ok {
+				s.retain(dir, profile)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// retain starts the retention of a recording directory, once per directory for
+// the lifetime of the server
+func (s *Server) retain(dir string, profile Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retained[dir] {
+		return
+	}
+
+	retention, err := record.NewRetention(record.RetentionConfig{
+		Dir:     dir,
+		MaxAge:  profile.Retain,
+		MaxSize: profile.MaxRecordingSize,
+		Metrics: s.config.ClientConfig.Metrics,
+		OnLog:   s.config.OnLog,
+	})
+	if err != nil {
+		s.config.OnLog(fmt.Sprintf("Error keeping the recordings in %s: %v", dir, err))
+		return
+	}
+	s.retained[dir] = true
+	go retention.Run(context.Background())
+}
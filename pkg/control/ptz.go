@@ -0,0 +1,168 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// ptzResponse is the body of GET /cameras/{id}/ptz/presets
+type ptzResponse struct {
+	CameraId int64                `json:"camera_id"`
+	Presets  []blinkapi.PTZPreset `json:"presets"`
+}
+
+// MoveCamera turns a camera on the Mini Pan-Tilt mount one step in a direction
+//
+// ctx: the context of the request
+//
+// cameraId: the ID of the camera
+//
+// direction: blinkapi.PTZ_LEFT, PTZ_RIGHT, PTZ_UP, or PTZ_DOWN
+//
+// Example: MoveCamera(ctx, 11111, blinkapi.PTZ_LEFT) = nil
+func (s *Server) MoveCamera(ctx context.Context, cameraId int64, direction string) error {
+	if !slices.Contains([]string{blinkapi.PTZ_LEFT, blinkapi.PTZ_RIGHT, blinkapi.PTZ_UP, blinkapi.PTZ_DOWN}, direction) {
+		return statusError(CODE_INVALID_ARGUMENT, "unsupported direction %q (left, right, up, down)", direction)
+	}
+	cc, err := s.cameraCredentials(ctx, cameraId)
+	if err != nil {
+		return err
+	}
+
+	return ptzError(cameraId, s.api.MoveCameraContext(ctx, cc, direction))
+}
+
+// PTZPresets returns the positions saved in the Blink app for a camera on the Mini
+// Pan-Tilt mount
+//
+// ctx: the context of the request
+//
+// cameraId: the ID of the camera
+//
+// Example: PTZPresets(ctx, 11111) = []blinkapi.PTZPreset{{Id: 1, Name: "Driveway"}}, nil
+func (s *Server) PTZPresets(ctx context.Context, cameraId int64) ([]blinkapi.PTZPreset, error) {
+	cc, err := s.cameraCredentials(ctx, cameraId)
+	if err != nil {
+		return nil, err
+	}
+	presets, err := s.api.ListPTZPresetsContext(ctx, cc)
+
+	return presets, ptzError(cameraId, err)
+}
+
+// GotoPTZPreset turns a camera on the Mini Pan-Tilt mount to a saved position
+//
+// ctx: the context of the request
+//
+// cameraId: the ID of the camera
+//
+// presetId: the ID of the preset, as listed by PTZPresets
+//
+// Example: GotoPTZPreset(ctx, 11111, 1) = nil
+func (s *Server) GotoPTZPreset(ctx context.Context, cameraId int64, presetId int) error {
+	cc, err := s.cameraCredentials(ctx, cameraId)
+	if err != nil {
+		return err
+	}
+
+	return ptzError(cameraId, s.api.GotoPTZPresetContext(ctx, cc, presetId))
+}
+
+// cameraCredentials returns the credentials of a camera with its network and
+// device type, looked up on the homescreen of its account
+func (s *Server) cameraCredentials(ctx context.Context, cameraId int64) (blinkapi.ClientCredentials, error) {
+	account, err := s.accountOf(ctx, "", cameraId)
+	if err != nil {
+		return blinkapi.ClientCredentials{}, err
+	}
+	homescreen, err := s.homescreen(ctx, account)
+	if err != nil {
+		return blinkapi.ClientCredentials{}, statusError(CODE_UNAVAILABLE, "error requesting the homescreen: %v", err)
+	}
+	device, err := homescreen.Device(int(cameraId), 0)
+	if err != nil {
+		return blinkapi.ClientCredentials{}, statusError(CODE_NOT_FOUND, "%v", err)
+	}
+	deviceType, _ := homescreen.DeviceType(device.Id, device.NetworkId)
+
+	cc, err := s.credentialsOf(account)
+	if err != nil {
+		return blinkapi.ClientCredentials{}, err
+	}
+	cc.NetworkId = device.NetworkId
+	cc.CameraId = device.Id
+	cc.DeviceType = deviceType
+
+	return cc, nil
+}
+
+// ptzError converts the error of a pan-tilt request into a status
+func ptzError(cameraId int64, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, blinkapi.ErrPTZUnsupported):
+		return statusError(CODE_NOT_FOUND, "camera %d has no pan-tilt mount", cameraId)
+	}
+
+	return statusError(CODE_UNAVAILABLE, "%v", err)
+}
+
+// servePTZ serves POST /cameras/{id}/ptz, turning the camera one step with the move
+// query parameter or to a preset with the preset query parameter
+func (s *Server) servePTZ(w http.ResponseWriter, r *http.Request) {
+	cameraId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+
+	move, preset := r.URL.Query().Get("move"), r.URL.Query().Get("preset")
+	switch {
+	case move != "" && preset == "":
+		err = s.MoveCamera(r.Context(), cameraId, move)
+	case preset != "" && move == "":
+		presetId, parseErr := strconv.Atoi(preset)
+		if parseErr != nil {
+			http.Error(w, "invalid preset ID", http.StatusBadRequest)
+			return
+		}
+		err = s.GotoPTZPreset(r.Context(), cameraId, presetId)
+	default:
+		http.Error(w, "expecting the move or the preset query parameter", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// servePTZPresets serves GET /cameras/{id}/ptz/presets
+func (s *Server) servePTZPresets(w http.ResponseWriter, r *http.Request) {
+	cameraId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+
+	presets, err := s.PTZPresets(r.Context(), cameraId)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if presets == nil {
+		presets = []blinkapi.PTZPreset{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ptzResponse{CameraId: cameraId, Presets: presets})
+}
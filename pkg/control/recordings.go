@@ -0,0 +1,281 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// segmentName matches the segment files of the record outputs, capturing when the
+// segment started (e.g. "blink-20240102T150405Z.ts")
+var segmentName = regexp.MustCompile(`^[A-Za-z0-9_.-]+?-(\d{8}T\d{6}Z)(?:-\d+)?\.ts$`)
+
+// Recording is a segment recorded by a record: output of a camera profile
+type Recording struct {
+	// The ID of the recording, "<camera ID>-<segment>"
+	Id       string `json:"id"`
+	CameraId int64  `json:"camera_id"`
+	// When the segment started and when it was last written
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The length of the segment in seconds
+	Duration float64 `json:"duration"`
+	// The size of the segment in bytes
+	Size int64 `json:"size"`
+	// Whether the segment is still being recorded
+	InProgress bool `json:"in_progress,omitempty"`
+	// The segment file
+	path string
+}
+
+// recordingsResponse is the body of GET /cameras/{id}/recordings
+type recordingsResponse struct {
+	CameraId   int64       `json:"camera_id"`
+	Recordings []Recording `json:"recordings"`
+}
+
+// Recordings lists the segments the record: outputs of the profile of a camera
+// stored, oldest first, that overlap a time range.
+//
+// cameraId: the ID of the camera
+//
+// from: the start of the range, or the zero time for no start
+//
+// to: the end of the range, or the zero time for no end
+//
+// Example: Recordings(11111, time.Now().Add(-time.Hour), time.Time{}) = []Recording{{Id: "11111-blink-20240102T150405Z", ...}}, nil
+func (s *Server) Recordings(cameraId int64, from time.Time, to time.Time) ([]Recording, error) {
+	dirs := s.recordingDirs(cameraId)
+	if len(dirs) == 0 {
+		return nil, statusError(CODE_NOT_FOUND, "camera %d is not recorded", cameraId)
+	}
+
+	s.mu.Lock()
+	_, streaming := s.sessions[cameraId]
+	s.mu.Unlock()
+
+	recordings := []Recording{}
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		newest := -1
+		for _, entry := range entries {
+			match := segmentName.FindStringSubmatch(entry.Name())
+			if entry.IsDir() || match == nil {
+				continue
+			}
+			id := fmt.Sprintf("%d-%s", cameraId, strings.TrimSuffix(entry.Name(), ".ts"))
+			info, err := entry.Info()
+			if err != nil || seen[id] {
+				continue
+			}
+			start, err := time.Parse("20060102T150405Z", match[1])
+			if err != nil {
+				continue
+			}
+			seen[id] = true
+
+			recordings = append(recordings, Recording{
+				Id:       id,
+				CameraId: cameraId,
+				Start:    start,
+				End:      info.ModTime().UTC().Truncate(time.Second),
+				Duration: max(info.ModTime().Sub(start).Round(time.Millisecond).Seconds(), 0),
+				Size:     info.Size(),
+				path:     filepath.Join(dir, entry.Name()),
+			})
+			if newest < 0 || start.After(recordings[newest].Start) {
+				newest = len(recordings) - 1
+			}
+		}
+		// The newest segment of a running livestream is still being written
+		if newest >= 0 && streaming {
+			recordings[newest].InProgress = true
+		}
+	}
+
+	recordings = slices.DeleteFunc(recordings, func(recording Recording) bool {
+		return (!from.IsZero() && recording.End.Before(from)) || (!to.IsZero() && recording.Start.After(to))
+	})
+	slices.SortFunc(recordings, func(a, b Recording) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	return recordings, nil
+}
+
+// recordingDirs returns the directories of the record: outputs of the profile of
+// a camera
+func (s *Server) recordingDirs(cameraId int64) []string {
+	profile := s.profile(cameraId)
+	if !slices.ContainsFunc(profile.Outputs, func(output string) bool {
+		return strings.HasPrefix(output, "record:")
+	}) {
+		return nil
+	}
+
+	name := ""
+	if slices.ContainsFunc(profile.Outputs, func(output string) bool {
+		return strings.Contains(output, "{name}")
+	}) {
+		s.mu.Lock()
+		account := s.cameraAccounts[cameraId]
+		s.mu.Unlock()
+		name = s.streamName(account, cameraId)
+	}
+
+	var dirs []string
+	for _, output := range profile.outputs(cameraId, name) {
+		if dir, ok := strings.CutPrefix(output, "record:"); ok {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// serveRecordings serves GET /cameras/{id}/recordings, as JSON or, with
+// format=hls, as an HLS VOD playlist of the segments. The optional from and to
+// query parameters (RFC 3339) limit the time range.
+func (s *Server) serveRecordings(w http.ResponseWriter, r *http.Request) {
+	cameraId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+	var from, to time.Time
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if value := r.URL.Query().Get(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s time, expecting RFC 3339 (e.g. 2024-01-02T15:04:05Z)", name), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	recordings, err := s.Recordings(cameraId, from, to)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "hls" {
+		writePlaylist(w, r, recordings)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(recordingsResponse{CameraId: cameraId, Recordings: recordings})
+}
+
+// servePlayback serves GET /recordings/{id}/play, the segment as a progressive
+// download with range requests or, with format=hls, as an HLS VOD playlist
+func (s *Server) servePlayback(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	camera, _, _ := strings.Cut(id, "-")
+	cameraId, err := strconv.ParseInt(camera, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid recording ID", http.StatusBadRequest)
+		return
+	}
+
+	recordings, err := s.Recordings(cameraId, time.Time{}, time.Time{})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	index := slices.IndexFunc(recordings, func(recording Recording) bool {
+		return recording.Id == id
+	})
+	if index < 0 {
+		http.Error(w, fmt.Sprintf("no recording %s", id), http.StatusNotFound)
+		return
+	}
+	recording := recordings[index]
+
+	if r.URL.Query().Get("format") == "hls" {
+		writePlaylist(w, r, recordings[index:index+1])
+		return
+	}
+
+	file, err := os.Open(recording.path)
+	if err != nil {
+		http.Error(w, "the recording cannot be read", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	if recording.InProgress {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	http.ServeContent(w, r, recording.Id+".ts", recording.End, file)
+}
+
+// writePlaylist writes an HLS VOD playlist of recordings, each a segment played
+// through /recordings/{id}/play. The API key of the request is passed on to the
+// segment URLs, for players that cannot send headers.
+func writePlaylist(w http.ResponseWriter, r *http.Request, recordings []Recording) {
+	query := ""
+	if key := r.URL.Query().Get("key"); key != "" {
+		query = "?key=" + url.QueryEscape(key)
+	}
+
+	target := 1.0
+	for _, recording := range recordings {
+		target = max(target, math.Ceil(recording.Duration))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", int(target))
+	for i, recording := range recordings {
+		// Consecutive segments may belong to separate livestreams
+		if i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n/recordings/%s/play%s\n", recording.Duration, recording.Id, query)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
+
+// writeHTTPError answers an HTTP request with the status matching an error
+func writeHTTPError(w http.ResponseWriter, err error) {
+	var status *Status
+	if errors.As(err, &status) {
+		switch status.Code {
+		case CODE_NOT_FOUND:
+			http.Error(w, status.Message, http.StatusNotFound)
+			return
+		case CODE_INVALID_ARGUMENT:
+			http.Error(w, status.Message, http.StatusBadRequest)
+			return
+		case CODE_UNAVAILABLE, CODE_CANCELLED:
+			http.Error(w, status.Message, http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
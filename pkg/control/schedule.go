@@ -0,0 +1,298 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule runs the livestream of a camera during the minutes matching a cron
+// expression, e.g. to record the driveway on weekdays from 8am to 6pm with
+// "* 8-17 * * mon-fri". What the livestream does, such as recording, is set by
+// the profile of the camera.
+type Schedule struct {
+	// The ID of the camera
+	CameraId int64 `json:"camera_id"`
+	// Optional account of the camera, looked up if empty
+	Account string `json:"account,omitempty"`
+	// The cron expression of the minutes the camera streams in: minute, hour, day
+	// of month, month, and day of week, with lists, ranges, steps, and three-letter
+	// names (e.g. "*/30 22-23,0-5 * * *")
+	When string `json:"when"`
+	// Optional IANA time zone of the expression, e.g. "America/New_York"
+	// (defaults to the local time zone)
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// schedulesFile is the JSON file read by LoadSchedules
+type schedulesFile struct {
+	Schedules []Schedule `json:"schedules"`
+}
+
+// LoadSchedules reads the schedules from a JSON file with a "schedules" list and
+// validates them.
+//
+// path: the file path
+//
+// Example: LoadSchedules("schedules.json") = []Schedule{{CameraId: 11111, When: "* 8-17 * * mon-fri"}}, nil
+func LoadSchedules(path string) ([]Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file schedulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if _, err := compileSchedules(file.Schedules); err != nil {
+		return nil, fmt.Errorf("error in %s: %w", path, err)
+	}
+
+	return file.Schedules, nil
+}
+
+// ValidateSchedules checks the camera, cron expression, and time zone of each
+// schedule
+//
+// schedules: the schedules to check
+//
+// Example: ValidateSchedules([]Schedule{{CameraId: 11111, When: "* 25 * * *"}}) = error
+func ValidateSchedules(schedules []Schedule) error {
+	_, err := compileSchedules(schedules)
+
+	return err
+}
+
+// compiledSchedule is a schedule with its expression parsed
+type compiledSchedule struct {
+	Schedule
+	cron     *cron
+	location *time.Location
+}
+
+// compileSchedules parses the expressions and time zones of the schedules
+func compileSchedules(schedules []Schedule) ([]compiledSchedule, error) {
+	compiled := make([]compiledSchedule, 0, len(schedules))
+	for i, schedule := range schedules {
+		if schedule.CameraId <= 0 {
+			return nil, fmt.Errorf("schedule %d has no camera_id", i+1)
+		}
+		c, err := parseCron(schedule.When)
+		if err != nil {
+			return nil, fmt.Errorf("schedule of camera %d: %w", schedule.CameraId, err)
+		}
+		location := time.Local
+		if schedule.TimeZone != "" {
+			if location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+				return nil, fmt.Errorf("schedule of camera %d: %w", schedule.CameraId, err)
+			}
+		}
+		compiled = append(compiled, compiledSchedule{Schedule: schedule, cron: c, location: location})
+	}
+
+	return compiled, nil
+}
+
+// runSchedules starts the scheduled cameras at the start of each minute of their
+// schedules and stops them once it ends, until the context is cancelled. The
+// schedules are evaluated against the clock rather than remembered, so after a
+// restart the cameras within their schedule start right away, and a livestream
+// that ended early (e.g. at Blink's length limit) is started again the next
+// minute.
+func (s *Server) runSchedules(ctx context.Context, schedules []compiledSchedule) {
+	for {
+		now := time.Now()
+		active := map[int64]string{}
+		for _, schedule := range schedules {
+			if schedule.cron.match(now.In(schedule.location)) {
+				active[schedule.CameraId] = schedule.Account
+			}
+		}
+		s.schedule(ctx, active)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(time.Now())):
+		}
+	}
+}
+
+// schedule starts the cameras that are scheduled now and not streaming, and stops
+// those whose schedule ended unless they have viewers
+func (s *Server) schedule(ctx context.Context, active map[int64]string) {
+	s.mu.Lock()
+	var ended []*session
+	for cameraId := range s.scheduled {
+		if _, ok := active[cameraId]; !ok {
+			if sess, ok := s.sessions[cameraId]; ok {
+				ended = append(ended, sess)
+			}
+		}
+	}
+	s.scheduled = map[int64]bool{}
+	var starts []int64
+	for cameraId := range active {
+		s.scheduled[cameraId] = true
+		if sess, ok := s.sessions[cameraId]; ok {
+			// The schedule keeps the livestream running without viewers
+			sess.mu.Lock()
+			if sess.idle != nil {
+				sess.idle.Stop()
+				sess.idle = nil
+			}
+			sess.mu.Unlock()
+		} else {
+			starts = append(starts, cameraId)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, sess := range ended {
+		if s.limiter.viewing(sess.cameraId) > 0 {
+			// The viewers keep it running, up to the idle timeout once they leave
+			s.idle(sess)
+			continue
+		}
+		s.config.OnLog(fmt.Sprintf("Stopping camera %d at the end of its schedule", sess.cameraId))
+		sess.client.Disconnect()
+	}
+	for _, cameraId := range starts {
+		go func() {
+			s.config.OnLog(fmt.Sprintf("Starting camera %d on schedule", cameraId))
+			startCtx, cancel := context.WithTimeout(ctx, ON_DEMAND_START_TIMEOUT)
+			defer cancel()
+			if err := s.startCamera(startCtx, active[cameraId], cameraId, 0); err != nil {
+				s.config.OnLog(fmt.Sprintf("Error starting camera %d on schedule: %v", cameraId, err))
+			}
+		}()
+	}
+}
+
+// isScheduled reports whether the schedule of a camera runs it now
+func (s *Server) isScheduled(cameraId int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.scheduled[cameraId]
+}
+
+// cron is a parsed cron expression, with a bit set per field
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day of month or day of week field is "*"
+	anyDom, anyDow bool
+}
+
+// Names accepted in the month and day of week fields
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// parseCron parses a five-field cron expression, e.g. "* 8-17 * * mon-fri"
+func parseCron(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute, hour, day of month, month, and day of week", expr)
+	}
+
+	c := &cron{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	var err error
+	for _, field := range []struct {
+		bits     *uint64
+		spec     string
+		name     string
+		min, max int
+		names    map[string]int
+	}{
+		{&c.minute, fields[0], "minute", 0, 59, nil},
+		{&c.hour, fields[1], "hour", 0, 23, nil},
+		{&c.dom, fields[2], "day of month", 1, 31, nil},
+		{&c.month, fields[3], "month", 1, 12, monthNames},
+		{&c.dow, fields[4], "day of week", 0, 7, dayNames},
+	} {
+		if *field.bits, err = parseCronField(field.spec, field.min, field.max, field.names); err != nil {
+			return nil, fmt.Errorf("invalid %s %q in %q: %w", field.name, field.spec, expr, err)
+		}
+	}
+	// Both 0 and 7 are Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges, and steps
+// (e.g. "1-5", "*/15", or "mon,wed,fri") into a bit set
+func parseCronField(spec string, min int, max int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		span, stepSpec, stepped := strings.Cut(part, "/")
+		step := 1
+		if stepped {
+			var err error
+			if step, err = strconv.Atoi(stepSpec); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepSpec)
+			}
+		}
+
+		lo, hi := min, max
+		if span != "*" {
+			first, last, ranged := strings.Cut(span, "-")
+			var err error
+			if lo, err = value(first); err != nil {
+				return 0, err
+			}
+			switch {
+			case ranged:
+				if hi, err = value(last); err != nil {
+					return 0, err
+				}
+			case !stepped:
+				hi = lo
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %q is reversed", span)
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << n
+		}
+	}
+
+	return bits, nil
+}
+
+// match reports whether a time is within a minute of the expression. As in cron,
+// a day matches either day field when both are restricted.
+func (c *cron) match(t time.Time) bool {
+	if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+
+	return dom || dow
+}
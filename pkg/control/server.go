@@ -0,0 +1,1182 @@
+// Package control exposes a gRPC service for controlling livestreams, so other
+// services (e.g. a Node or Python frontend) can start and stop cameras, receive
+// their streams, and list devices through the typed contract in control.proto.
+//
+// The service is served over HTTP/2 with TLS by the standard library. Compressed
+// messages are not supported.
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"amattu2/blink-middleware/pkg/output/poster"
+	"amattu2/blink-middleware/pkg/output/rtsp"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SERVICE_NAME is the fully qualified name of the gRPC service
+const SERVICE_NAME = "blink.control.v1.Control"
+
+const (
+	// DEFAULT_ADDR is the default listen address of the gRPC server
+	DEFAULT_ADDR = ":50051"
+	// MAX_MESSAGE_SIZE is the largest request message accepted
+	MAX_MESSAGE_SIZE = 4 << 20
+	// MEDIA_QUEUE_SIZE is the number of chunks buffered per StreamMedia call before
+	// chunks are dropped, fewer with a memory budget
+	MEDIA_QUEUE_SIZE = 64
+	// DEFAULT_START_PARALLELISM is the default number of cameras StartLiveviews
+	// connects at once
+	DEFAULT_START_PARALLELISM = 4
+)
+
+// gRPC status codes returned by the service
+const (
+	CODE_OK                 = 0
+	CODE_CANCELLED          = 1
+	CODE_INVALID_ARGUMENT   = 3
+	CODE_NOT_FOUND          = 5
+	CODE_PERMISSION_DENIED  = 7
+	CODE_RESOURCE_EXHAUSTED = 8
+	CODE_UNIMPLEMENTED      = 12
+	CODE_INTERNAL           = 13
+	CODE_UNAVAILABLE        = 14
+	CODE_UNAUTHENTICATED    = 16
+)
+
+// Status is an error carrying a gRPC status code
+type Status struct {
+	// The gRPC status code (e.g. CODE_NOT_FOUND)
+	Code int
+	// The error message sent to the client
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", s.Code, s.Message)
+}
+
+func statusError(code int, format string, args ...any) error {
+	return &Status{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type Config struct {
+	// The listen address (defaults to DEFAULT_ADDR)
+	Addr string
+	// Region of the account (e.g. "u011")
+	Region string
+	// Blink API token. Optional when Accounts are set
+	ApiToken string
+	// The ID of the account
+	AccountId int
+	// Optional additional accounts, e.g. of other households or sites, whose
+	// cameras are namespaced by the account name. Camera IDs are unique across
+	// accounts, so the calls taking a camera ID find its account
+	Accounts []Account
+	// Configuration for the livestream clients
+	ClientConfig liveview.ClientConfig
+	// The number of cameras StartLiveviews connects at once (defaults to
+	// DEFAULT_START_PARALLELISM)
+	StartParallelism int
+	// Optional TLS configuration with the server certificate. Defaults to a
+	// self-signed certificate generated at startup
+	TLSConfig *tls.Config
+	// Optional listen address of the plain HTTP health endpoints (/healthz and
+	// /readyz) and the camera posters, e.g. ":8080". Empty disables them
+	HealthAddr string
+	// Whether the health endpoints and posters are served over HTTPS with the TLS
+	// configuration of the gRPC service instead of plain HTTP
+	HealthTLS bool
+	// Optional listen address of a plain HTTP server redirecting to the HTTPS
+	// health endpoints, e.g. ":80". It also answers the ACME challenges
+	RedirectAddr string
+	// Optional handler of the ACME HTTP-01 challenges, served by the redirect
+	// server under /.well-known/acme-challenge/ (e.g. an *acme.Manager)
+	Challenges http.Handler
+	// The ffmpeg command encoding the first keyframe of each livestream into its
+	// poster image (defaults to "ffmpeg")
+	FFmpeg string
+	// Optional listen address of an RTSP server serving every started livestream,
+	// e.g. ":8554". Empty disables it
+	RTSPAddr string
+	// Optional access control of the RTSP streams, by a shared token or
+	// credentials per stream path
+	RTSPAccess rtsp.Access
+	// Optional RTSP paths of cameras by camera ID (e.g. {11111: "front-door"}).
+	// Other cameras are served at the slug of their name
+	StreamNames map[int64]string
+	// Optional API keys and their roles (ROLE_READ or ROLE_ADMIN). Once any is set,
+	// gRPC calls and posters require a key, and starting or stopping livestreams
+	// requires ROLE_ADMIN
+	APIKeys map[string]string
+	// Optional caps on the viewers and the livestream starts, protecting the server
+	// and the Blink API. Unlimited by default
+	Limits Limits
+	// Whether StreamMedia calls and RTSP clients start the livestream of a camera
+	// that is not streaming, instead of failing
+	OnDemand bool
+	// How long a livestream runs without viewers (StreamMedia calls or RTSP
+	// sessions) before it is stopped. Zero runs livestreams until StopLiveview
+	IdleTimeout time.Duration
+	// Optional idle timeouts by camera ID, overriding IdleTimeout. Zero or negative
+	// runs the camera until StopLiveview
+	IdleTimeouts map[int64]time.Duration
+	// Optional profiles by camera ID, declaring the outputs, recording retention,
+	// quality, and idle timeout of each camera. A profile's idle timeout overrides
+	// IdleTimeouts
+	Profiles map[int64]Profile
+	// Profile of the cameras missing from Profiles. Its idle timeout applies when
+	// IdleTimeouts has none for the camera
+	DefaultProfile Profile
+	// Optional callback called when a livestream is stopped for having no viewers
+	OnIdleStop func(IdleStop)
+	// Optional callback called when the livestream of a camera fails to start
+	OnStartError func(cameraId int64, networkId int64, err error)
+	// Optional file journaling the liveview commands of the running livestreams.
+	// On start, the commands a crash left running are stopped, so Blink does not
+	// report the cameras as busy
+	JournalPath string
+	// Whether the livestreams interrupted by a crash, found in the journal, are
+	// started again
+	Resume bool
+	// Optional cameras whose livestreams are started when the server starts
+	AlwaysOn []int64
+	// Optional schedules running the livestreams of cameras during the minutes of
+	// their cron expressions, regardless of viewers
+	Schedules []Schedule
+	// Optional memory budget of the server in bytes, for running unattended. It
+	// sets the soft memory limit of the Go runtime, bounds the buffers of each
+	// livestream to a share of it, and logs the memory use every
+	// MEMORY_REPORT_INTERVAL. Zero leaves the memory unbounded
+	MemoryBudget int64
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Server struct {
+	// Configuration options for the server
+	config Config
+	// Credentials for the device API keyed by account name, the account of Config
+	// named ""
+	accounts map[string]blinkapi.ClientCredentials
+	// The identities of the accounts with their own
+	identities map[string]blinkapi.ClientIdentity
+	// The Blink API the device list is requested through
+	api *blinkapi.BlinkAPI
+	// When the server was created
+	started time.Time
+	// Guards the fields below
+	mu sync.Mutex
+	// Livestream sessions keyed by camera ID
+	sessions map[int64]*session
+	// The latest poster image of each camera, kept after its livestream stopped
+	posters map[int64]posterImage
+	// The RTSP paths of the cameras named after the homescreen
+	names map[int64]string
+	// The account of each camera seen on a homescreen
+	cameraAccounts map[int64]string
+	// The RTSP server, or nil if RTSPAddr is empty
+	rtsp *rtsp.Server
+	// The cached result of the readiness check
+	readiness readiness
+	// Enforces the configured limits
+	limiter *limiter
+	// The liveview commands of the running livestreams
+	journal *journal
+	// The recording directories of the profiles whose retention runs
+	retained map[string]bool
+	// The cameras whose schedules run them now
+	scheduled map[int64]bool
+	// The latest snapshot of each camera
+	snapshots map[int64]*snapshotCall
+	// The snapshots being taken, by camera ID
+	snapshotting map[int64]*snapshotCall
+}
+
+// NewServer initializes a new gRPC control server with the provided configuration.
+func NewServer(config Config) *Server {
+	if config.Addr == "" {
+		config.Addr = DEFAULT_ADDR
+	}
+	if config.StartParallelism <= 0 {
+		config.StartParallelism = DEFAULT_START_PARALLELISM
+	}
+	if config.FFmpeg == "" {
+		config.FFmpeg = "ffmpeg"
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+	if config.OnIdleStop == nil {
+		config.OnIdleStop = func(IdleStop) {}
+	}
+	if config.OnStartError == nil {
+		config.OnStartError = func(int64, int64, error) {}
+	}
+
+	serverMetrics := config.ClientConfig.Metrics
+	if serverMetrics == nil {
+		serverMetrics = metrics.Noop
+	}
+
+	locale := config.ClientConfig.Locale
+	if locale == "" {
+		locale = blinkapi.DEFAULT_LOCALE
+	}
+
+	credentials := func(region string, apiToken string, accountId int, identity blinkapi.ClientIdentity) blinkapi.ClientCredentials {
+		return blinkapi.ClientCredentials{
+			Region:    region,
+			BaseURL:   config.ClientConfig.BaseURL,
+			ApiToken:  apiToken,
+			AccountId: accountId,
+			Locale:    locale,
+			Country:   config.ClientConfig.Country,
+			TimeZone:  config.ClientConfig.TimeZone,
+			Identity:  identity,
+		}
+	}
+	accounts := map[string]blinkapi.ClientCredentials{}
+	identities := map[string]blinkapi.ClientIdentity{}
+	if config.ApiToken != "" || len(config.Accounts) == 0 {
+		accounts[""] = credentials(config.Region, config.ApiToken, config.AccountId, config.ClientConfig.Identity)
+	}
+	for _, account := range config.Accounts {
+		identity := config.ClientConfig.Identity
+		if account.Identity != nil {
+			identity = *account.Identity
+			identities[account.Name] = identity
+		}
+		accounts[account.Name] = credentials(account.Region, account.ApiToken, account.AccountId, identity)
+	}
+
+	return &Server{
+		config:     config,
+		accounts:   accounts,
+		identities: identities,
+		api: blinkapi.NewBlinkAPI(blinkapi.APIConfig{
+			HTTPClient: config.ClientConfig.HTTPClient,
+			Middleware: config.ClientConfig.Middleware,
+		}),
+		started:        time.Now(),
+		sessions:       map[int64]*session{},
+		posters:        map[int64]posterImage{},
+		names:          map[int64]string{},
+		cameraAccounts: map[int64]string{},
+		limiter:        newLimiter(config.Limits, serverMetrics),
+		journal:        &journal{path: config.JournalPath},
+		retained:       map[string]bool{},
+		scheduled:      map[int64]bool{},
+		snapshots:      map[int64]*snapshotCall{},
+		snapshotting:   map[int64]*snapshotCall{},
+	}
+}
+
+// Run serves the gRPC service until the context is cancelled, then stops every
+// livestream.
+//
+// ctx: the context controlling the server lifecycle
+//
+// Example: Run(ctx) = nil
+func (s *Server) Run(ctx context.Context) error {
+	schedules, err := compileSchedules(s.config.Schedules)
+	if err != nil {
+		return err
+	}
+
+	s.limitMemory()
+
+	recovered, err := s.recover(ctx)
+	if err != nil {
+		s.config.OnLog(fmt.Sprintf("Error recovering the journal: %v", err))
+	}
+
+	tlsConfig := s.config.TLSConfig
+	if tlsConfig == nil {
+		cert, fingerprint, err := selfSignedCertificate()
+		if err != nil {
+			return fmt.Errorf("error generating certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		s.config.OnLog(fmt.Sprintf("Using a self-signed certificate (SHA-256 %s)", fingerprint))
+	}
+
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", s.config.Addr, err)
+	}
+
+	server := &http.Server{Handler: s, TLSConfig: tlsConfig}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeTLS(listener, "", "")
+	}()
+	s.config.OnLog(fmt.Sprintf("Serving gRPC service %s on %s", SERVICE_NAME, listener.Addr()))
+
+	// The port the redirect server sends clients to, once the health endpoints
+	// are served over HTTPS
+	httpsPort := ""
+	if s.config.HealthAddr != "" {
+		healthListener, err := net.Listen("tcp", s.config.HealthAddr)
+		if err != nil {
+			server.Close()
+			return fmt.Errorf("unable to listen on %s: %w", s.config.HealthAddr, err)
+		}
+		healthServer := &http.Server{Handler: s.HealthHandler(), TLSConfig: tlsConfig}
+		defer healthServer.Close()
+		if s.config.HealthTLS {
+			go healthServer.ServeTLS(healthListener, "", "")
+			s.config.OnLog(fmt.Sprintf("Serving health endpoints over HTTPS on %s", healthListener.Addr()))
+			_, httpsPort, _ = net.SplitHostPort(healthListener.Addr().String())
+		} else {
+			go healthServer.Serve(healthListener)
+			s.config.OnLog(fmt.Sprintf("Serving health endpoints on %s", healthListener.Addr()))
+		}
+	}
+
+	if s.config.RedirectAddr != "" {
+		redirectListener, err := net.Listen("tcp", s.config.RedirectAddr)
+		if err != nil {
+			server.Close()
+			return fmt.Errorf("unable to listen on %s: %w", s.config.RedirectAddr, err)
+		}
+		redirectServer := &http.Server{Handler: s.redirectHandler(httpsPort)}
+		defer redirectServer.Close()
+		go redirectServer.Serve(redirectListener)
+		s.config.OnLog(fmt.Sprintf("Redirecting HTTP on %s to HTTPS", redirectListener.Addr()))
+	}
+
+	if s.config.RTSPAddr != "" {
+		var demand func(string) error
+		if s.config.OnDemand {
+			demand = s.demandRTSP
+		}
+		rtspServer, err := rtsp.ListenWithConfig(rtsp.Config{
+			Addr:    s.config.RTSPAddr,
+			Access:  s.config.RTSPAccess,
+			Admit:   s.admitRTSP,
+			Demand:  demand,
+			Metrics: s.limiter.metrics,
+			OnLog:   s.config.OnLog,
+		})
+		if err != nil {
+			server.Close()
+			return fmt.Errorf("error starting RTSP server: %w", err)
+		}
+		defer rtspServer.Close()
+		s.mu.Lock()
+		s.rtsp = rtspServer
+		s.mu.Unlock()
+		s.config.OnLog(fmt.Sprintf("Serving RTSP streams on %s", rtspServer.Addr()))
+	}
+
+	go s.resume(ctx, recovered)
+	if len(schedules) > 0 {
+		go s.runSchedules(ctx, schedules)
+	}
+	if s.config.MemoryBudget > 0 {
+		go s.reportMemory(ctx)
+	}
+
+	select {
+	case err := <-served:
+		s.stopAll()
+		return err
+	case <-ctx.Done():
+	}
+
+	// Stopping the livestreams ends the StreamMedia calls
+	s.drain()
+	s.stopAll()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+	}
+
+	return nil
+}
+
+// ServeHTTP dispatches gRPC calls to the service methods
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests require HTTP/2 and an application/grpc content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	unary := func(req message, call func() (message, error)) error {
+		if err := readMessage(r.Body, req); err != nil {
+			return err
+		}
+		resp, err := call()
+		if err != nil {
+			return err
+		}
+
+		return writeMessage(w, resp)
+	}
+
+	role := ROLE_READ
+	service, method, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if adminMethods[method] {
+		role = ROLE_ADMIN
+	}
+
+	err := s.authorize(r, role)
+	switch {
+	case err != nil:
+	case service != SERVICE_NAME:
+		err = statusError(CODE_UNIMPLEMENTED, "unknown service %s", service)
+	case method == "StartLiveview":
+		req := &StartLiveviewRequest{}
+		err = unary(req, func() (message, error) { return s.StartLiveview(r.Context(), req) })
+	case method == "StartLiveviews":
+		req := &StartLiveviewsRequest{}
+		err = unary(req, func() (message, error) { return s.StartLiveviews(r.Context(), req) })
+	case method == "StopLiveview":
+		req := &StopLiveviewRequest{}
+		err = unary(req, func() (message, error) { return s.StopLiveview(req) })
+	case method == "StreamMedia":
+		req := &StreamMediaRequest{}
+		if err = readMessage(r.Body, req); err == nil {
+			err = s.StreamMedia(r.Context(), req, func(chunk *MediaChunk) error {
+				return writeMessage(w, chunk)
+			})
+		}
+	case method == "ListDevices":
+		req := &ListDevicesRequest{}
+		err = unary(req, func() (message, error) { return s.ListDevices() })
+	case method == "GetStats":
+		req := &GetStatsRequest{}
+		err = unary(req, func() (message, error) { return s.GetStats(), nil })
+	default:
+		err = statusError(CODE_UNIMPLEMENTED, "unknown method %s", method)
+	}
+
+	writeStatus(w, err)
+}
+
+// StartLiveview connects to the livestream of a camera, or returns the current
+// session if the camera is already streaming.
+//
+// ctx: the context of the call; the livestream outlives it
+//
+// req: the camera to start
+//
+// Example: StartLiveview(ctx, &StartLiveviewRequest{NetworkId: 1, CameraId: 2}) = &StartLiveviewResponse{...}, nil
+func (s *Server) StartLiveview(ctx context.Context, req *StartLiveviewRequest) (*StartLiveviewResponse, error) {
+	if req.NetworkId <= 0 || req.CameraId <= 0 {
+		return nil, statusError(CODE_INVALID_ARGUMENT, "network_id and camera_id are required")
+	}
+
+	s.mu.Lock()
+	sess, ok := s.sessions[req.CameraId]
+	s.mu.Unlock()
+	if ok {
+		if err := sess.wait(ctx); err != nil {
+			return nil, err
+		}
+		return &StartLiveviewResponse{Session: sess.info()}, nil
+	}
+
+	account, err := s.accountOf(ctx, req.Account, req.CameraId)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := s.credentialsOf(account)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	sess, ok = s.sessions[req.CameraId]
+	if !ok {
+		if err := s.limiter.start(req.CameraId); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		clientConfig := s.config.ClientConfig
+		if quality := s.profile(req.CameraId).Quality; quality != "" {
+			clientConfig.Quality = quality
+		}
+		if identity, ok := s.identities[account]; ok {
+			clientConfig.Identity = identity
+		}
+		clientConfig.OnCommand = func(commandId int, running bool) {
+			if s.config.ClientConfig.OnCommand != nil {
+				s.config.ClientConfig.OnCommand(commandId, running)
+			}
+			if err := s.journal.command(account, req.CameraId, req.NetworkId, commandId, running); err != nil {
+				s.config.OnLog(fmt.Sprintf("Error journaling command %d: %v", commandId, err))
+			}
+		}
+		client := liveview.NewClientWithConfig(cc.Region, cc.ApiToken, req.DeviceType, cc.AccountId, int(req.NetworkId), int(req.CameraId), clientConfig)
+		sess = newSession(account, req.CameraId, req.NetworkId, client, s.mediaQueueSize())
+		s.sessions[req.CameraId] = sess
+		go s.open(sess)
+	}
+	s.mu.Unlock()
+
+	if err := sess.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return &StartLiveviewResponse{Session: sess.info()}, nil
+}
+
+// StartLiveviews connects to the livestreams of several cameras, up to
+// StartParallelism at once. The homescreen is requested once beforehand, which
+// detects the missing device types for every camera and opens the API connection
+// the clients then share. Cameras that fail to start are reported in the response
+// alongside the started sessions; an error is only returned if none started.
+//
+// ctx: the context of the call; the livestreams outlive it
+//
+// req: the cameras to start
+//
+// Example: StartLiveviews(ctx, &StartLiveviewsRequest{Cameras: []StartLiveviewRequest{...}}) = &StartLiveviewsResponse{...}, nil
+func (s *Server) StartLiveviews(ctx context.Context, req *StartLiveviewsRequest) (*StartLiveviewsResponse, error) {
+	if len(req.Cameras) == 0 {
+		return nil, statusError(CODE_INVALID_ARGUMENT, "cameras are required")
+	}
+
+	cameras := s.prewarm(ctx, req.Cameras)
+
+	sessions := make([]*Session, len(cameras))
+	errs := make([]error, len(cameras))
+	slots := make(chan struct{}, s.config.StartParallelism)
+	var wg sync.WaitGroup
+	for i := range cameras {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				errs[i] = statusError(CODE_CANCELLED, "%v", ctx.Err())
+				return
+			}
+
+			resp, err := s.StartLiveview(ctx, &cameras[i])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			sessions[i] = &resp.Session
+		}()
+	}
+	wg.Wait()
+
+	resp := &StartLiveviewsResponse{}
+	var failures []string
+	for i, camera := range cameras {
+		if errs[i] != nil {
+			message := errs[i].Error()
+			var status *Status
+			if errors.As(errs[i], &status) {
+				message = status.Message
+			}
+			resp.Errors = append(resp.Errors, StartError{CameraId: camera.CameraId, Message: message})
+			failures = append(failures, fmt.Sprintf("camera %d: %s", camera.CameraId, message))
+			continue
+		}
+		resp.Sessions = append(resp.Sessions, *sessions[i])
+	}
+	if len(resp.Sessions) == 0 {
+		return nil, statusError(CODE_UNAVAILABLE, "no camera started: %s", strings.Join(failures, "; "))
+	}
+	if len(failures) > 0 {
+		s.config.OnLog(fmt.Sprintf("Started %d of %d cameras: %s", len(resp.Sessions), len(cameras), strings.Join(failures, "; ")))
+	}
+
+	return resp, nil
+}
+
+// prewarm requests the homescreen of each account once and fills the accounts and
+// missing device types of the cameras from them. A failed request is logged and
+// leaves the detection to the clients.
+func (s *Server) prewarm(ctx context.Context, cameras []StartLiveviewRequest) []StartLiveviewRequest {
+	cameras = append([]StartLiveviewRequest(nil), cameras...)
+
+	accounts := s.accountNames()
+	if len(accounts) > 1 {
+		// Only the accounts named by the request are requested, unless a camera
+		// needs its account looked up
+		named := map[string]bool{}
+		for _, camera := range cameras {
+			if camera.Account == "" {
+				named = nil
+				break
+			}
+			named[camera.Account] = true
+		}
+		if named != nil {
+			accounts = slices.DeleteFunc(accounts, func(account string) bool { return !named[account] })
+		}
+	}
+
+	for _, account := range accounts {
+		homescreen, err := s.homescreen(ctx, account)
+		if err != nil {
+			s.config.OnLog(fmt.Sprintf("Error requesting the homescreen of %s before starting the cameras: %v", accountLabel(account), err))
+			continue
+		}
+		for i := range cameras {
+			if cameras[i].DeviceType != "" {
+				continue
+			}
+			if deviceType, err := homescreen.DeviceType(int(cameras[i].CameraId), int(cameras[i].NetworkId)); err == nil {
+				cameras[i].DeviceType = deviceType
+			}
+		}
+	}
+
+	return cameras
+}
+
+// StopLiveview disconnects the livestream of a camera.
+//
+// req: the camera to stop
+//
+// Example: StopLiveview(&StopLiveviewRequest{CameraId: 2}) = &StopLiveviewResponse{}, nil
+func (s *Server) StopLiveview(req *StopLiveviewRequest) (*StopLiveviewResponse, error) {
+	s.mu.Lock()
+	sess, ok := s.sessions[req.CameraId]
+	s.mu.Unlock()
+	if !ok {
+		return nil, statusError(CODE_NOT_FOUND, "camera %d is not streaming", req.CameraId)
+	}
+
+	sess.client.Disconnect()
+
+	return &StopLiveviewResponse{}, nil
+}
+
+// StreamMedia sends the stream of a started livestream until it ends or the
+// context is cancelled. Chunks are dropped while send falls behind.
+//
+// ctx: the context of the call
+//
+// req: the camera to receive
+//
+// send: delivers a chunk to the caller
+//
+// Example: StreamMedia(ctx, &StreamMediaRequest{CameraId: 2}, send) = nil
+func (s *Server) StreamMedia(ctx context.Context, req *StreamMediaRequest, send func(*MediaChunk) error) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[req.CameraId]
+	s.mu.Unlock()
+	if !ok && s.config.OnDemand {
+		if err := s.startOnDemand(ctx, req.Account, req.CameraId, req.NetworkId); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		sess, ok = s.sessions[req.CameraId]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return statusError(CODE_NOT_FOUND, "camera %d is not streaming; call StartLiveview first", req.CameraId)
+	}
+	if err := sess.wait(ctx); err != nil {
+		return err
+	}
+	detach, err := s.attach(req.CameraId)
+	if err != nil {
+		return err
+	}
+	defer detach()
+
+	chunks := sess.subscribe()
+	defer sess.unsubscribe(chunks)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return statusError(CODE_CANCELLED, "%v", ctx.Err())
+		case data, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+			if err := send(&MediaChunk{Data: data}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ListDevices lists the cameras of the accounts. The accounts whose homescreen
+// cannot be requested are logged and left out, unless none can be.
+//
+// Example: ListDevices() = &ListDevicesResponse{Devices: []Device{...}}, nil
+func (s *Server) ListDevices() (*ListDevicesResponse, error) {
+	resp := &ListDevicesResponse{}
+	var errs []error
+	for _, account := range s.accountNames() {
+		homescreen, err := s.homescreen(context.Background(), account)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", accountLabel(account), err))
+			continue
+		}
+
+		add := func(devices []blinkapi.HomescreenDevice, deviceType string) {
+			for _, d := range devices {
+				resp.Devices = append(resp.Devices, Device{
+					Id:        int64(d.Id),
+					NetworkId: int64(d.NetworkId),
+					Name:      d.Name,
+					Type:      deviceType,
+					Status:    d.Status,
+					Account:   account,
+				})
+			}
+		}
+		add(homescreen.Cameras, "camera")
+		add(homescreen.Owls, "owl")
+		add(homescreen.Doorbells, "doorbell")
+	}
+	if len(errs) == len(s.accounts) {
+		return nil, statusError(CODE_UNAVAILABLE, "%v", errors.Join(errs...))
+	}
+	if len(errs) > 0 {
+		s.config.OnLog(fmt.Sprintf("Error listing devices: %v", errors.Join(errs...)))
+	}
+
+	return resp, nil
+}
+
+// GetStats reports the active livestream sessions and the memory use of the
+// server.
+//
+// Example: GetStats() = &Stats{Sessions: []Session{...}, Uptime: 3600, Memory: MemoryStats{...}}
+func (s *Server) GetStats() *Stats {
+	memory := s.Memory()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := &Stats{Uptime: int64(time.Since(s.started).Seconds()), Memory: memory}
+	for _, sess := range s.sessions {
+		stats.Sessions = append(stats.Sessions, sess.info())
+	}
+
+	return stats
+}
+
+// open connects the session and forwards its stream to the subscribers and the
+// outputs of the camera profile
+func (s *Server) open(sess *session) {
+	profile := s.profile(sess.cameraId)
+	// The outputs are opened first, so a misconfigured one fails the start
+	// without connecting the camera
+	outputs, err := s.openOutputs(sess, profile)
+	if err != nil {
+		err = fmt.Errorf("error opening the outputs: %w", err)
+		s.remove(sess)
+		sess.opened(err)
+		s.config.OnLog(fmt.Sprintf("Error starting camera %d: %v", sess.cameraId, err))
+		s.config.OnStartError(sess.cameraId, sess.networkId, err)
+		return
+	}
+	if outputs != nil {
+		sess.outputs = outputs
+		defer outputs.Close()
+	}
+
+	stream, err := sess.client.Open(context.Background())
+	if err != nil {
+		s.remove(sess)
+		sess.opened(err)
+		s.config.OnLog(fmt.Sprintf("Error starting camera %d: %v", sess.cameraId, err))
+		s.config.OnStartError(sess.cameraId, sess.networkId, err)
+		return
+	}
+
+	// The stream is named before the session is reported as started
+	s.mu.Lock()
+	rtspServer := s.rtsp
+	s.mu.Unlock()
+	if rtspServer != nil && !profile.DisableRTSP {
+		name := s.streamName(sess.account, sess.cameraId)
+		sess.mu.Lock()
+		sess.streamName = name
+		sess.mu.Unlock()
+		sess.stream = rtspServer.Stream(name)
+		s.config.OnLog(fmt.Sprintf("Serving camera %d at %s", sess.cameraId, rtspServer.URL("localhost", name)))
+	}
+
+	sess.opened(nil)
+	s.config.OnLog(fmt.Sprintf("Started camera %d", sess.cameraId))
+	s.idle(sess)
+
+	// The first keyframe of the stream becomes the poster of the camera
+	sess.poster = poster.New(poster.Config{
+		FFmpeg: s.config.FFmpeg,
+		OnImage: func(image []byte) {
+			s.setPoster(sess.cameraId, image)
+		},
+		OnLog: s.config.OnLog,
+	})
+
+	sess.pump(stream)
+	s.remove(sess)
+	sess.close()
+	s.config.OnLog(fmt.Sprintf("Stopped camera %d", sess.cameraId))
+}
+
+func (s *Server) remove(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions[sess.cameraId] == sess {
+		delete(s.sessions, sess.cameraId)
+	}
+}
+
+func (s *Server) stopAll() {
+	s.mu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.client.Disconnect()
+	}
+}
+
+// session is a livestream started through StartLiveview
+type session struct {
+	// The name of the account of the camera, empty for the account of Config
+	account   string
+	cameraId  int64
+	networkId int64
+	client    *liveview.Client
+	started   time.Time
+	// Closed once the connection attempt completed
+	ready chan struct{}
+	// The error of the connection attempt. Set before ready is closed
+	err error
+	// Guards the fields below
+	mu sync.Mutex
+	// The number of stream bytes received
+	bytes uint64
+	// When stream data was last received
+	received time.Time
+	// The number of chunks dropped for slow subscribers
+	dropped uint64
+	// The chunk queues of the StreamMedia calls
+	subscribers map[chan []byte]struct{}
+	// The number of chunks queued for each StreamMedia call
+	queueSize int
+	// Whether the stream has ended
+	closed bool
+	// Extracts the poster image from the stream. Only used by pump
+	poster io.Writer
+	// The program tables of the stream, for snapshots
+	tables *programTables
+	// The RTSP stream of the camera, or nil without RTSP. Only used by pump
+	stream io.Writer
+	// The outputs of the camera profile, or nil without any. Only used by pump
+	outputs io.Writer
+	// The RTSP path of the camera, or empty without RTSP
+	streamName string
+	// Stops the livestream once it had no viewers for the idle timeout, or nil
+	idle *time.Timer
+}
+
+func newSession(account string, cameraId int64, networkId int64, client *liveview.Client, queueSize int) *session {
+	return &session{
+		account:     account,
+		cameraId:    cameraId,
+		networkId:   networkId,
+		client:      client,
+		started:     time.Now(),
+		ready:       make(chan struct{}),
+		subscribers: map[chan []byte]struct{}{},
+		queueSize:   queueSize,
+		tables:      newProgramTables(),
+	}
+}
+
+func (sess *session) opened(err error) {
+	sess.err = err
+	close(sess.ready)
+}
+
+// wait blocks until the connection attempt completed
+func (sess *session) wait(ctx context.Context) error {
+	select {
+	case <-sess.ready:
+	case <-ctx.Done():
+		return statusError(CODE_CANCELLED, "%v", ctx.Err())
+	}
+	if sess.err != nil {
+		return statusError(CODE_UNAVAILABLE, "error starting camera %d: %v", sess.cameraId, sess.err)
+	}
+
+	return nil
+}
+
+// pump forwards whole transport stream packets to the subscribers until the stream
+// ends
+func (sess *session) pump(stream io.ReadCloser) {
+	defer stream.Close()
+
+	pooled := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(pooled)
+	buf := *pooled
+	var remainder []byte
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			// The chunk is shared by the subscribers, so it is allocated once at
+			// its final size rather than pooled
+			chunk := make([]byte, 0, (len(remainder)+n)/mpegts.PACKET_SIZE*mpegts.PACKET_SIZE)
+			remainder = mpegts.AlignPackets(append(remainder, buf[:n]...), func(pkt mpegts.Packet) {
+				sess.tables.track(pkt)
+				chunk = append(chunk, pkt...)
+			})
+
+			sess.mu.Lock()
+			sess.bytes += uint64(n)
+			sess.received = time.Now()
+			for chunks := range sess.subscribers {
+				if len(chunk) == 0 {
+					continue
+				}
+				select {
+				case chunks <- chunk:
+				default:
+					sess.dropped++
+				}
+			}
+			sess.mu.Unlock()
+
+			if sess.poster != nil && len(chunk) > 0 {
+				sess.poster.Write(chunk)
+			}
+			if sess.stream != nil && len(chunk) > 0 {
+				sess.stream.Write(chunk)
+			}
+			if sess.outputs != nil && len(chunk) > 0 {
+				sess.outputs.Write(chunk)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (sess *session) subscribe() chan []byte {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	chunks := make(chan []byte, sess.queueSize)
+	if sess.closed {
+		close(chunks)
+		return chunks
+	}
+	sess.subscribers[chunks] = struct{}{}
+
+	return chunks
+}
+
+func (sess *session) unsubscribe(chunks chan []byte) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	delete(sess.subscribers, chunks)
+}
+
+// close ends every StreamMedia call once the stream has ended
+func (sess *session) close() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.closed = true
+	if sess.idle != nil {
+		sess.idle.Stop()
+	}
+	for chunks := range sess.subscribers {
+		close(chunks)
+		delete(sess.subscribers, chunks)
+	}
+}
+
+func (sess *session) info() Session {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	return Session{
+		CameraId:      sess.cameraId,
+		NetworkId:     sess.networkId,
+		State:         string(sess.client.State()),
+		StartedAt:     sess.started.Unix(),
+		Bytes:         sess.bytes,
+		Subscribers:   int32(len(sess.subscribers)),
+		DroppedChunks: sess.dropped,
+		Stream:        sess.streamName,
+		Account:       sess.account,
+		Server:        sess.server(),
+	}
+}
+
+// server returns the liveview server the session streams from, or nil before it
+// connected
+func (sess *session) server() *SessionServer {
+	info := sess.client.SessionInfo()
+	if info == nil {
+		return nil
+	}
+
+	return &SessionServer{
+		Host:            info.Host,
+		ConnectionId:    info.ConnectionId,
+		ClientId:        int64(info.ClientId),
+		CommandId:       int64(info.CommandId),
+		PollingInterval: int32(info.PollingInterval),
+	}
+}
+
+// message is a protobuf message of the service
+type message interface {
+	Marshal() []byte
+	Unmarshal([]byte) error
+}
+
+// readMessage reads a length-prefixed gRPC message from the request body
+func readMessage(r io.Reader, m message) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return statusError(CODE_INVALID_ARGUMENT, "error reading request: %v", err)
+	}
+	if header[0] != 0 {
+		return statusError(CODE_UNIMPLEMENTED, "compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MAX_MESSAGE_SIZE {
+		return statusError(CODE_RESOURCE_EXHAUSTED, "request of %d bytes exceeds the limit of %d bytes", length, MAX_MESSAGE_SIZE)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return statusError(CODE_INVALID_ARGUMENT, "error reading request: %v", err)
+	}
+	if err := m.Unmarshal(body); err != nil {
+		return statusError(CODE_INVALID_ARGUMENT, "invalid request: %v", err)
+	}
+
+	return nil
+}
+
+// writeMessage writes a length-prefixed gRPC message and flushes it to the client
+func writeMessage(w http.ResponseWriter, m message) error {
+	body := m.Marshal()
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	if _, err := w.Write(append(frame, body...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// writeStatus sets the gRPC status trailers for the result of a call
+func writeStatus(w http.ResponseWriter, err error) {
+	var status *Status
+	switch {
+	case err == nil:
+		status = &Status{Code: CODE_OK}
+	case errors.As(err, &status):
+	default:
+		status = &Status{Code: CODE_INTERNAL, Message: err.Error()}
+	}
+
+	w.Header().Set("Grpc-Status", fmt.Sprint(status.Code))
+	if status.Message != "" {
+		w.Header().Set("Grpc-Message", percentEncode(status.Message))
+	}
+}
+
+// percentEncode encodes a status message as required for the grpc-message trailer
+func percentEncode(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// selfSignedCertificate generates a certificate for localhost and returns it with
+// its SHA-256 fingerprint
+func selfSignedCertificate() (tls.Certificate, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "blink-middleware"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, "", err
+	}
+
+	sum := sha256.Sum256(der)
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, strings.Join(fingerprint, ":"), nil
+}
@@ -0,0 +1,156 @@
+package control
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer serves the control server over HTTP/2 with TLS, like Run
+func newTestServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewUnstartedServer(NewServer(Config{}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// call sends a gRPC request with the frame as its body and returns the response
+// and its body, after which the trailers are set
+func call(t *testing.T, ts *httptest.Server, method string, frame []byte) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/"+SERVICE_NAME+"/"+method, bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, body
+}
+
+// grpcFrame returns the message prefixed with the compression flag and its length
+func grpcFrame(m message) []byte {
+	body := m.Marshal()
+	frame := []byte{0}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+
+	return append(frame, body...)
+}
+
+// TestServeHTTPFraming checks that a unary call is answered over HTTP/2 with a
+// single length-prefixed message followed by the OK status trailers
+func TestServeHTTPFraming(t *testing.T) {
+	ts := newTestServer(t)
+	resp, body := call(t, ts, "GetStats", grpcFrame(&GetStatsRequest{}))
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("served over %s, want HTTP/2", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Errorf("status %d with content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if len(body) < 5 {
+		t.Fatalf("response %x is shorter than the message prefix", body)
+	}
+	if body[0] != 0 {
+		t.Errorf("response is flagged compressed: %x", body[0])
+	}
+	if length := binary.BigEndian.Uint32(body[1:5]); int(length) != len(body)-5 {
+		t.Errorf("prefix declares %d bytes, %d follow", length, len(body)-5)
+	}
+	var stats Stats
+	if err := stats.Unmarshal(body[5:]); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Sessions) != 0 {
+		t.Errorf("%d sessions, want none", len(stats.Sessions))
+	}
+	if status := resp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Grpc-Status = %q, want 0", status)
+	}
+	if msg := resp.Trailer.Get("Grpc-Message"); msg != "" {
+		t.Errorf("Grpc-Message = %q, want none", msg)
+	}
+}
+
+// TestServeHTTPStatus checks that failed calls send no message and report their
+// status code and percent-encoded message in the trailers
+func TestServeHTTPStatus(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name    string
+		method  string
+		frame   []byte
+		code    string
+		message string
+	}{
+		{"invalid argument", "StartLiveview", grpcFrame(&StartLiveviewRequest{}), "3", "network_id and camera_id are required"},
+		{"not found", "StopLiveview", grpcFrame(&StopLiveviewRequest{CameraId: 9}), "5", "camera 9 is not streaming"},
+		{"unknown method", "Reboot", grpcFrame(&GetStatsRequest{}), "12", "unknown method Reboot"},
+		{"compressed", "GetStats", []byte{1, 0, 0, 0, 0}, "12", "compressed messages are not supported"},
+		{"truncated prefix", "GetStats", []byte{0, 0, 0}, "3", "error reading request: unexpected EOF"},
+		{"truncated message", "StopLiveview", []byte{0, 0, 0, 0, 4, 0x08}, "3", "error reading request: unexpected EOF"},
+		{"malformed message", "StopLiveview", []byte{0, 0, 0, 0, 2, 0x08, 0x80}, "3", "invalid request: truncated protobuf message"},
+		{"oversized message", "GetStats", []byte{0, 0xff, 0xff, 0xff, 0xff}, "8", "request of 4294967295 bytes exceeds the limit of 4194304 bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := call(t, ts, test.method, test.frame)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("HTTP status %d, want the error in the trailers", resp.StatusCode)
+			}
+			if len(body) != 0 {
+				t.Errorf("failed call sent %x", body)
+			}
+			if code := resp.Trailer.Get("Grpc-Status"); code != test.code {
+				t.Errorf("Grpc-Status = %q, want %q", code, test.code)
+			}
+			if msg := resp.Trailer.Get("Grpc-Message"); msg != percentEncode(test.message) {
+				t.Errorf("Grpc-Message = %q, want %q", msg, percentEncode(test.message))
+			}
+		})
+	}
+
+	if encoded := percentEncode("100% é"); encoded != "100%25 %C3%A9" {
+		t.Errorf("percentEncode = %q", encoded)
+	}
+}
+
+// TestServeHTTPRequiresGRPC checks that requests other than gRPC over HTTP/2 are
+// rejected before reaching a method
+func TestServeHTTPRequiresGRPC(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, err := ts.Client().Post(ts.URL+"/"+SERVICE_NAME+"/GetStats", "application/json", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/"+SERVICE_NAME+"/GetStats", bytes.NewReader(grpcFrame(&GetStatsRequest{})))
+	req.Header.Set("Content-Type", "application/grpc")
+	NewServer(Config{}).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("HTTP/1.1 request answered with %d, want %d", recorder.Code, http.StatusUnsupportedMediaType)
+	}
+}
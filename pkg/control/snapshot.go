@@ -0,0 +1,263 @@
+package control
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"amattu2/blink-middleware/pkg/output/poster"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DEFAULT_SNAPSHOT_MAX_AGE is how long a snapshot is reused by default
+	DEFAULT_SNAPSHOT_MAX_AGE = 10 * time.Second
+	// SNAPSHOT_TIMEOUT bounds the capture of a snapshot from a livestream or the
+	// thumbnail API
+	SNAPSHOT_TIMEOUT = 15 * time.Second
+)
+
+// Sources of a snapshot, reported in the X-Snapshot-Source header
+const (
+	SNAPSHOT_SOURCE_STREAM    = "stream"
+	SNAPSHOT_SOURCE_CACHE     = "cache"
+	SNAPSHOT_SOURCE_THUMBNAIL = "thumbnail"
+)
+
+// snapshotCall is a snapshot being taken, shared by the requests arriving
+// meanwhile
+type snapshotCall struct {
+	// Closed once the snapshot was taken or failed
+	done  chan struct{}
+	image posterImage
+	// Where the image came from, one of the SNAPSHOT_SOURCE_ constants
+	source string
+	err    error
+	// When the snapshot was taken, which may be later than the image, e.g. of a
+	// thumbnail
+	taken time.Time
+}
+
+// Snapshot returns a current JPEG image of a camera, with when it was captured
+// and where it came from. A snapshot taken within maxAge is reused; otherwise the
+// next keyframe of a running livestream is decoded; otherwise the latest
+// thumbnail of the camera is downloaded, which does not wake the camera but may
+// be older. Concurrent calls for a camera share one capture.
+//
+// ctx: the context bounding the capture
+//
+// cameraId: the ID of the camera
+//
+// maxAge: how long a snapshot is reused
+//
+// Example: Snapshot(ctx, 11111, 30*time.Second) = []byte{0xff, 0xd8, ...}, time.Time{...}, "stream", nil
+func (s *Server) Snapshot(ctx context.Context, cameraId int64, maxAge time.Duration) ([]byte, time.Time, string, error) {
+	s.mu.Lock()
+	cached, ok := s.snapshots[cameraId]
+	if ok && time.Since(cached.taken) <= maxAge {
+		s.mu.Unlock()
+		return cached.image.data, cached.image.captured, SNAPSHOT_SOURCE_CACHE, nil
+	}
+	call, running := s.snapshotting[cameraId]
+	if !running {
+		call = &snapshotCall{done: make(chan struct{})}
+		s.snapshotting[cameraId] = call
+	}
+	sess := s.sessions[cameraId]
+	s.mu.Unlock()
+
+	if !running {
+		go s.takeSnapshot(cameraId, sess, call)
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, time.Time{}, "", statusError(CODE_CANCELLED, "%v", ctx.Err())
+	}
+	if call.err != nil {
+		return nil, time.Time{}, "", call.err
+	}
+
+	return call.image.data, call.image.captured, call.source, nil
+}
+
+// takeSnapshot captures a snapshot from the livestream of a session, or from the
+// thumbnail API without one, and caches it
+func (s *Server) takeSnapshot(cameraId int64, sess *session, call *snapshotCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), SNAPSHOT_TIMEOUT)
+	defer cancel()
+
+	if sess != nil && sess.wait(ctx) == nil {
+		call.source = SNAPSHOT_SOURCE_STREAM
+		call.image, call.err = s.streamSnapshot(ctx, sess)
+	} else {
+		call.source = SNAPSHOT_SOURCE_THUMBNAIL
+		call.image, call.err = s.thumbnail(ctx, cameraId)
+	}
+
+	call.taken = time.Now()
+	s.mu.Lock()
+	if call.err == nil {
+		s.snapshots[cameraId] = call
+	}
+	delete(s.snapshotting, cameraId)
+	s.mu.Unlock()
+	close(call.done)
+}
+
+// streamSnapshot decodes the next keyframe of the livestream of a session
+func (s *Server) streamSnapshot(ctx context.Context, sess *session) (posterImage, error) {
+	chunks := sess.subscribe()
+	defer sess.unsubscribe(chunks)
+
+	frame := poster.New(poster.Config{FFmpeg: s.config.FFmpeg, OnLog: s.config.OnLog})
+	frame.Write(sess.tables.packets())
+	for {
+		select {
+		case <-frame.Done():
+			image, err := frame.Image()
+			if err != nil {
+				return posterImage{}, statusError(CODE_UNAVAILABLE, "%v", err)
+			}
+			return posterImage{data: image, captured: time.Now()}, nil
+		case data, ok := <-chunks:
+			if !ok {
+				return posterImage{}, statusError(CODE_UNAVAILABLE, "the livestream of camera %d ended before a keyframe", sess.cameraId)
+			}
+			frame.Write(data)
+		case <-ctx.Done():
+			return posterImage{}, statusError(CODE_UNAVAILABLE, "no keyframe from camera %d within %s", sess.cameraId, SNAPSHOT_TIMEOUT)
+		}
+	}
+}
+
+// programTables keeps the latest PAT and PMT of a livestream, so a snapshot
+// joining mid-stream can demux it before the tables are repeated
+type programTables struct {
+	// Parses the tables to find the PMT. Only used by track
+	demuxer *mpegts.Demuxer
+	// Guards the fields below
+	mu sync.Mutex
+	// The latest PAT and PMT packets
+	pat, pmt []byte
+}
+
+func newProgramTables() *programTables {
+	return &programTables{demuxer: mpegts.NewDemuxer(func(mpegts.AccessUnit) {})}
+}
+
+// track remembers the packet if it starts a PAT or PMT
+func (t *programTables) track(pkt mpegts.Packet) {
+	pid := pkt.PID()
+	if pid != mpegts.PID_PAT && !t.demuxer.IsPMT(pid) {
+		return
+	}
+	t.demuxer.WritePacket(pkt)
+	if !pkt.PayloadUnitStart() {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pid == mpegts.PID_PAT {
+		t.pat = append(t.pat[:0], pkt...)
+	} else {
+		t.pmt = append(t.pmt[:0], pkt...)
+	}
+}
+
+// packets returns the latest PAT and PMT packets
+func (t *programTables) packets() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append(append([]byte(nil), t.pat...), t.pmt...)
+}
+
+// thumbnail downloads the latest thumbnail of a camera, dated by its ts query
+// parameter
+func (s *Server) thumbnail(ctx context.Context, cameraId int64) (posterImage, error) {
+	account, err := s.accountOf(ctx, "", cameraId)
+	if err != nil {
+		return posterImage{}, err
+	}
+	homescreen, err := s.homescreen(ctx, account)
+	if err != nil {
+		return posterImage{}, statusError(CODE_UNAVAILABLE, "error requesting the homescreen: %v", err)
+	}
+
+	var path string
+	for _, device := range homescreen.Devices(0) {
+		if int64(device.Id) == cameraId {
+			path = device.Thumbnail
+		}
+	}
+	if path == "" {
+		return posterImage{}, statusError(CODE_NOT_FOUND, "camera %d has no thumbnail", cameraId)
+	}
+	// Older thumbnail paths leave out the extension
+	if !strings.Contains(path, "?") && !strings.HasSuffix(path, ".jpg") {
+		path += ".jpg"
+	}
+
+	cc, err := s.credentialsOf(account)
+	if err != nil {
+		return posterImage{}, err
+	}
+	var image bytes.Buffer
+	if err := s.api.DownloadMediaContext(ctx, cc, s.api.CreateURL(cc, path), &image); err != nil {
+		return posterImage{}, statusError(CODE_UNAVAILABLE, "error downloading the thumbnail: %v", err)
+	}
+
+	return posterImage{data: image.Bytes(), captured: thumbnailTime(path)}, nil
+}
+
+// thumbnailTime returns when a thumbnail was taken from the ts query parameter of
+// its path, or now if it has none
+func thumbnailTime(path string) time.Time {
+	if u, err := url.Parse(path); err == nil {
+		if ts, err := strconv.ParseInt(u.Query().Get("ts"), 10, 64); err == nil && ts > 0 {
+			return time.Unix(ts, 0)
+		}
+	}
+
+	return time.Now()
+}
+
+// serveSnapshot serves GET /cameras/{id}/snapshot.jpg. The optional max_age
+// query parameter (e.g. "30s", or seconds) sets how long a snapshot is reused,
+// DEFAULT_SNAPSHOT_MAX_AGE by default.
+func (s *Server) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	cameraId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+	maxAge := DEFAULT_SNAPSHOT_MAX_AGE
+	if value := r.URL.Query().Get("max_age"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			maxAge = time.Duration(seconds) * time.Second
+		} else if maxAge, err = time.ParseDuration(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid max_age %q, expecting a duration like 30s", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	image, captured, source, err := s.Snapshot(r.Context(), cameraId, maxAge)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Snapshot-Source", source)
+	http.ServeContent(w, r, "snapshot.jpg", captured, bytes.NewReader(image))
+}
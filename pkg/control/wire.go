@@ -0,0 +1,105 @@
+package control
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendVarint appends a varint field, omitting the zero value as proto3 does
+func appendVarint(b []byte, field int, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+
+	return binary.AppendUvarint(b, value)
+}
+
+// appendBytes appends a length-delimited field, omitting empty values
+func appendBytes(b []byte, field int, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+
+	return appendMessage(b, field, value)
+}
+
+// appendMessage appends an embedded message, which is present even when empty
+func appendMessage(b []byte, field int, value []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+
+	return append(b, value...)
+}
+
+// field is a decoded protobuf field. Varint fields set value, length-delimited
+// fields set data.
+type field struct {
+	number int
+	value  uint64
+	data   []byte
+}
+
+// decodeFields calls onField for each field of a protobuf message. Fixed-size
+// fields are skipped.
+func decodeFields(b []byte, onField func(field) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+
+		f := field{number: int(tag >> 3)}
+		switch tag & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return errTruncated
+			}
+			f.data = b[n : n+int(length)]
+			b = b[n+int(length):]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+			continue
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+			continue
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", tag&7)
+		}
+
+		if err := onField(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
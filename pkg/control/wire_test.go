@@ -0,0 +1,153 @@
+package control
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestVarintRoundTrip checks the encoding of varints at the boundaries of their
+// byte lengths, including the ten bytes of negative int64 values
+func TestVarintRoundTrip(t *testing.T) {
+	tests := []struct {
+		value   uint64
+		encoded []byte
+	}{
+		{1, []byte{0x18, 0x01}},
+		{127, []byte{0x18, 0x7f}},
+		{128, []byte{0x18, 0x80, 0x01}},
+		{300, []byte{0x18, 0xac, 0x02}},
+		{1 << 35, []byte{0x18, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}},
+		{math.MaxUint64, []byte{0x18, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	}
+	for _, test := range tests {
+		encoded := appendVarint(nil, 3, test.value)
+		if !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("appendVarint(%d) = %x, want %x", test.value, encoded, test.encoded)
+		}
+
+		var decoded []field
+		if err := decodeFields(encoded, func(f field) error {
+			decoded = append(decoded, f)
+			return nil
+		}); err != nil {
+			t.Fatalf("decoding %x: %v", encoded, err)
+		}
+		if len(decoded) != 1 || decoded[0].number != 3 || decoded[0].value != test.value {
+			t.Errorf("decoded %x as %+v, want field 3 = %d", encoded, decoded, test.value)
+		}
+	}
+
+	// proto3 omits zero values
+	if encoded := appendVarint(nil, 3, 0); len(encoded) != 0 {
+		t.Errorf("appendVarint(0) = %x, want nothing", encoded)
+	}
+
+	original := StopLiveviewRequest{CameraId: -2}
+	var decoded StopLiveviewRequest
+	if err := decoded.Unmarshal(original.Marshal()); err != nil || decoded != original {
+		t.Errorf("decoded %+v, %v, want %+v", decoded, err, original)
+	}
+}
+
+// TestRepeatedFields checks that repeated messages decode in order, including
+// when other fields are interleaved between them
+func TestRepeatedFields(t *testing.T) {
+	original := ListDevicesResponse{Devices: []Device{
+		{Id: 1, NetworkId: 10, Name: "Front Door", Type: "doorbell", Status: "online"},
+		{Id: 2, NetworkId: 10, Name: "Garage"},
+		{},
+		{Id: 3, NetworkId: 11, Type: "owl"},
+	}}
+	var decoded ListDevicesResponse
+	if err := decoded.Unmarshal(original.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("decoded %+v, want %+v", decoded, original)
+	}
+
+	// An empty embedded message is still encoded, so the empty device is kept
+	var b []byte
+	b = appendMessage(b, 1, (&Device{Id: 1}).Marshal())
+	b = appendVarint(b, 9, 5)
+	b = appendMessage(b, 1, nil)
+	b = appendBytes(b, 10, []byte("ignored"))
+	b = appendMessage(b, 1, (&Device{Id: 3}).Marshal())
+	decoded = ListDevicesResponse{}
+	if err := decoded.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Device{{Id: 1}, {}, {Id: 3}}; !reflect.DeepEqual(decoded.Devices, want) {
+		t.Errorf("decoded %+v, want %+v", decoded.Devices, want)
+	}
+}
+
+// TestUnknownFieldsSkipped checks that fields of every wire type that a message
+// does not declare are skipped, as newer clients may send them
+func TestUnknownFieldsSkipped(t *testing.T) {
+	var b []byte
+	b = appendVarint(b, 15, 1<<40)
+	b = appendVarint(b, 1, 7)
+	b = appendBytes(b, 16, []byte{0x08, 0x01})
+	b = appendTag(b, 17, wireFixed64)
+	b = append(b, 1, 2, 3, 4, 5, 6, 7, 8)
+	b = appendVarint(b, 2, 9)
+	b = appendTag(b, 18, wireFixed32)
+	b = append(b, 1, 2, 3, 4)
+	b = appendBytes(b, 3, []byte("doorbell"))
+	b = appendTag(b, 19, wireFixed32)
+	b = append(b, 1, 2, 3, 4)
+
+	var decoded StartLiveviewRequest
+	if err := decoded.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if want := (StartLiveviewRequest{NetworkId: 7, CameraId: 9, DeviceType: "doorbell"}); decoded != want {
+		t.Errorf("decoded %+v, want %+v", decoded, want)
+	}
+}
+
+// TestTruncatedInput checks that every way a message can end early is reported
+// instead of decoding a partial message
+func TestTruncatedInput(t *testing.T) {
+	tests := map[string][]byte{
+		"tag":     {0x80},
+		"varint":  {0x08, 0x80, 0x80},
+		"length":  {0x1a},
+		"bytes":   {0x1a, 0x05, 'd', 'o', 'o'},
+		"fixed64": {0x09, 1, 2, 3, 4, 5, 6, 7},
+		"fixed32": {0x0d, 1, 2, 3},
+	}
+	for name, b := range tests {
+		err := decodeFields(b, func(field) error { return nil })
+		if !errors.Is(err, errTruncated) {
+			t.Errorf("%s: decoding %x returned %v, want %v", name, b, err, errTruncated)
+		}
+	}
+
+	var response ListDevicesResponse
+	if err := response.Unmarshal(appendMessage(nil, 1, []byte{0x08, 0x80})); !errors.Is(err, errTruncated) {
+		t.Errorf("decoding a truncated device returned %v, want %v", err, errTruncated)
+	}
+
+	// Every prefix of a message that ends inside a field is truncated
+	encoded := (&StartLiveviewRequest{NetworkId: 300, CameraId: -1, DeviceType: "doorbell"}).Marshal()
+	boundaries := map[int]bool{0: true, 3: true, 14: true, len(encoded): true}
+	for i := range encoded {
+		var decoded StartLiveviewRequest
+		err := decoded.Unmarshal(encoded[:i])
+		if boundaries[i] && err != nil {
+			t.Errorf("decoding the first %d bytes returned %v", i, err)
+		} else if !boundaries[i] && !errors.Is(err, errTruncated) {
+			t.Errorf("decoding the first %d bytes returned %v, want %v", i, err, errTruncated)
+		}
+	}
+
+	// Groups are not part of proto3
+	if err := decodeFields([]byte{0x0b}, func(field) error { return nil }); err == nil || errors.Is(err, errTruncated) {
+		t.Errorf("decoding a group returned %v, want an unsupported wire type", err)
+	}
+}
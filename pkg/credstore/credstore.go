@@ -0,0 +1,241 @@
+// Package credstore persists Blink credentials to disk, encrypted with a
+// passphrase, so they do not need to be passed on every invocation.
+//
+// The credentials are encrypted with AES-256-GCM using a key derived from the
+// passphrase with PBKDF2-HMAC-SHA256. The file is only readable by its owner.
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PASSPHRASE_ENV is the environment variable the command line tools read the
+// passphrase from
+const PASSPHRASE_ENV = "BLINK_PASSPHRASE"
+
+// KDF_ITERATIONS is the number of PBKDF2 iterations used for new files
+const KDF_ITERATIONS = 600000
+
+var (
+	// ErrNotFound is returned by Load when no credentials have been saved
+	ErrNotFound = errors.New("no saved credentials")
+	// ErrPassphrase is returned by Load when the passphrase does not decrypt the file
+	ErrPassphrase = errors.New("wrong passphrase or corrupted credentials")
+)
+
+type Credentials struct {
+	// Region of the account (e.g. "u011")
+	Region string `json:"region"`
+	// Blink API token
+	ApiToken string `json:"api_token"`
+	// Optional refresh token
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// The ID of the account
+	AccountId int `json:"account_id"`
+	// Optional ID of the client (device) the token was issued to
+	ClientId int `json:"client_id,omitempty"`
+	// Optional unique identifier of the deployment sent with API requests
+	UniqueId string `json:"unique_id,omitempty"`
+	// Optional device name sent with API requests
+	DeviceName string `json:"device_name,omitempty"`
+	// Optional app build sent with API requests
+	AppBuild string `json:"app_build,omitempty"`
+	// When the credentials were saved
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// envelope is the on-disk format of the encrypted credentials
+type envelope struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// DefaultPath returns the default location of the credentials file in the user's
+// configuration directory
+//
+// Example: DefaultPath() = "/home/user/.config/blink-middleware/credentials.json", nil
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to locate configuration directory: %w", err)
+	}
+
+	return filepath.Join(dir, "blink-middleware", "credentials.json"), nil
+}
+
+// Save encrypts the credentials with the passphrase and writes them to the path,
+// replacing any saved credentials
+//
+// path: the credentials file
+//
+// passphrase: the passphrase used to encrypt the credentials
+//
+// creds: the credentials to save
+//
+// Example: Save("credentials.json", "secret", Credentials{...}) = nil
+func Save(path string, passphrase string, creds Credentials) error {
+	if passphrase == "" {
+		return errors.New("a passphrase is required to save credentials")
+	}
+	if creds.SavedAt.IsZero() {
+		creds.SavedAt = time.Now().UTC()
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	env := envelope{
+		Version:    1,
+		Iterations: KDF_ITERATIONS,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(env.Salt); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return err
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("error creating credentials directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing credentials: %w", err)
+	}
+
+	return nil
+}
+
+// Load reads and decrypts the credentials saved at the path
+//
+// path: the credentials file
+//
+// passphrase: the passphrase the credentials were saved with
+//
+// Example: Load("credentials.json", "secret") = Credentials{...}, nil
+func Load(path string, passphrase string) (Credentials, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Credentials{}, ErrNotFound
+	} else if err != nil {
+		return Credentials{}, fmt.Errorf("error reading credentials: %w", err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Credentials{}, fmt.Errorf("error parsing credentials: %w", err)
+	}
+	if env.Version != 1 {
+		return Credentials{}, fmt.Errorf("unsupported credentials version %d", env.Version)
+	}
+	if passphrase == "" {
+		return Credentials{}, fmt.Errorf("%w: no passphrase provided", ErrPassphrase)
+	}
+
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return Credentials{}, ErrPassphrase
+	}
+
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return Credentials{}, ErrPassphrase
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("error parsing credentials: %w", err)
+	}
+
+	return creds, nil
+}
+
+// Remove deletes the saved credentials. It succeeds if none are saved.
+//
+// path: the credentials file
+//
+// Example: Remove("credentials.json") = nil
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if iterations <= 0 || len(salt) == 0 {
+		return nil, errors.New("invalid key derivation parameters")
+	}
+
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key with PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	key := make([]byte, 0, keyLength)
+	for block := uint32(1); len(key) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLength]
+}
@@ -0,0 +1,223 @@
+// Package events watches a Blink account for new motion events and dispatches them
+// to callbacks and webhooks.
+//
+// Blink does not push events to third parties, so the account's media list is
+// polled for clips created since the previous poll.
+package events
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// MAX_PAGES is the maximum number of media pages fetched per poll
+const MAX_PAGES = 10
+
+// SOURCE_DOORBELL_PRESS is the source of the media Blink records when the button of
+// a doorbell is pressed
+const SOURCE_DOORBELL_PRESS = "button_press"
+
+// Event is a motion event reported by a camera
+type Event struct {
+	// The Blink media ID of the event
+	Id int64 `json:"id"`
+	// The name of the camera
+	Camera string `json:"camera"`
+	// The ID of the camera
+	CameraId int `json:"camera_id"`
+	// The device type of the camera (e.g. "camera", "owl", "doorbell")
+	DeviceType string `json:"device_type"`
+	// The ID of the network the camera belongs to
+	NetworkId int `json:"network_id"`
+	// When the event occurred
+	Timestamp time.Time `json:"timestamp"`
+	// What triggered the event (e.g. "pir" for motion, "snapshot")
+	Source string `json:"source"`
+	// The URL of the event thumbnail. Fetching it requires the API token
+	ThumbnailURL string `json:"thumbnail_url"`
+	// The URL of the event clip. Fetching it requires the API token
+	ClipURL string `json:"clip_url"`
+	// Whether the event was triggered by pressing the button of a doorbell
+	DoorbellPress bool `json:"doorbell_press"`
+}
+
+type WatcherConfig struct {
+	// Region of the account (e.g. "u011")
+	Region string
+	// Blink API token
+	ApiToken string
+	// The ID of the account to watch
+	AccountId int
+	// Optional networks to watch. Empty watches every network of the account
+	NetworkIds []int
+	// Interval between polls (defaults to 30 seconds)
+	PollInterval time.Duration
+	// Callback invoked for each new event, oldest first
+	OnEvent func(Event)
+	// Optional callback invoked when the button of a doorbell is pressed, after
+	// OnEvent receives the event
+	OnDoorbellPress func(Event)
+	// Callback for handling polling errors
+	OnError func(error)
+	// Callback for logging messages
+	OnLog func(string)
+	// Optional HTTP client for Blink API requests
+	HTTPClient *http.Client
+	// Optional middleware wrapping every Blink API request, outermost first
+	Middleware []blinkapi.Middleware
+}
+
+type Watcher struct {
+	// Configuration options for the watcher
+	config WatcherConfig
+	// Credentials for the media API
+	credentials blinkapi.ClientCredentials
+	// The Blink API the watcher sends requests through
+	api *blinkapi.BlinkAPI
+	// When Run was called; older events are not reported
+	started time.Time
+	// The time of the newest media seen so far
+	since time.Time
+	// Events already dispatched, keyed by media ID, with their timestamps
+	seen map[int64]time.Time
+}
+
+// NewWatcher initializes a new Watcher with the provided configuration.
+func NewWatcher(config WatcherConfig) *Watcher {
+	if config.PollInterval <= 0 {
+		config.PollInterval = 30 * time.Second
+	}
+	if config.OnEvent == nil {
+		config.OnEvent = func(Event) {}
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			log.Println(err)
+		}
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(msg string) {
+			log.Println(msg)
+		}
+	}
+
+	return &Watcher{
+		config: config,
+		credentials: blinkapi.ClientCredentials{
+			Region:    config.Region,
+			ApiToken:  config.ApiToken,
+			AccountId: config.AccountId,
+		},
+		api: blinkapi.NewBlinkAPI(blinkapi.APIConfig{
+			HTTPClient: config.HTTPClient,
+			Middleware: config.Middleware,
+		}),
+		seen: map[int64]time.Time{},
+	}
+}
+
+// Run polls for new events until the context is cancelled. Events that occurred
+// before Run was called are not reported. Polling errors are reported through
+// OnError and retried on the next interval.
+//
+// ctx: the context controlling the watcher lifecycle
+//
+// Example: Run(ctx) = nil
+func (w *Watcher) Run(ctx context.Context) error {
+	w.started = time.Now()
+	w.since = w.started
+	w.config.OnLog(fmt.Sprintf("Watching account %d for motion events every %s", w.config.AccountId, w.config.PollInterval))
+
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := w.poll(ctx); err != nil {
+				w.config.OnError(fmt.Errorf("error polling motion events: %w", err))
+			}
+		}
+	}
+}
+
+// poll fetches media changed since the newest media seen and dispatches new events.
+// The context cancels the requests.
+func (w *Watcher) poll(ctx context.Context) error {
+	var media []blinkapi.Media
+	for page := 1; page <= MAX_PAGES; page++ {
+		resp, err := w.api.GetChangedMediaContext(ctx, w.credentials, w.since, page)
+		if err != nil {
+			return err
+		}
+		media = append(media, resp.Media...)
+		if len(resp.Media) == 0 || (resp.Limit > 0 && len(resp.Media) < resp.Limit) {
+			break
+		}
+	}
+
+	sort.Slice(media, func(i, j int) bool {
+		return media[i].CreatedAt.Before(media[j].CreatedAt)
+	})
+
+	for _, m := range media {
+		if m.UpdatedAt.After(w.since) {
+			w.since = m.UpdatedAt
+		}
+		if m.CreatedAt.After(w.since) {
+			w.since = m.CreatedAt
+		}
+
+		if _, ok := w.seen[m.Id]; ok || m.Deleted || m.CreatedAt.Before(w.started) {
+			continue
+		}
+		if len(w.config.NetworkIds) > 0 && !slices.Contains(w.config.NetworkIds, m.NetworkId) {
+			continue
+		}
+		w.seen[m.Id] = m.CreatedAt
+
+		event := Event{
+			Id:           m.Id,
+			Camera:       m.DeviceName,
+			CameraId:     m.DeviceId,
+			DeviceType:   m.Device,
+			NetworkId:    m.NetworkId,
+			Timestamp:    m.CreatedAt,
+			Source:       m.Source,
+			ThumbnailURL: w.api.CreateURL(w.credentials, m.Thumbnail),
+			ClipURL:      w.api.CreateURL(w.credentials, m.Media),
+		}
+		event.DoorbellPress = isDoorbellPress(event)
+
+		w.config.OnEvent(event)
+		if event.DoorbellPress && w.config.OnDoorbellPress != nil {
+			w.config.OnDoorbellPress(event)
+		}
+	}
+
+	// Media updated after creation is returned again; forget it once it is old
+	for id, createdAt := range w.seen {
+		if w.since.Sub(createdAt) > 24*time.Hour {
+			delete(w.seen, id)
+		}
+	}
+
+	return nil
+}
+
+// isDoorbellPress returns whether the event of a doorbell was triggered by its button
+func isDoorbellPress(event Event) bool {
+	if event.DeviceType != "doorbell" && event.DeviceType != "lotus" {
+		return false
+	}
+
+	return event.Source == SOURCE_DOORBELL_PRESS
+}
@@ -0,0 +1,130 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type WebhookConfig struct {
+	// The URLs each event is POSTed to
+	URLs []string
+	// Optional headers added to every request (e.g. "Authorization")
+	Headers map[string]string
+	// Timeout of each request (defaults to 10 seconds)
+	Timeout time.Duration
+	// The number of retries after a failed request (defaults to 2, negative disables retries)
+	Retries int
+	// The delay before the first retry, doubled after each attempt (defaults to 1 second)
+	RetryDelay time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Webhook POSTs events as JSON to user-configured URLs
+type Webhook struct {
+	// Configuration options for the webhook
+	config WebhookConfig
+	// The HTTP client used for requests
+	client *http.Client
+}
+
+// NewWebhook initializes a new Webhook with the provided configuration.
+func NewWebhook(config WebhookConfig) *Webhook {
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.Retries == 0 {
+		config.Retries = 2
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	return &Webhook{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Send POSTs the event to every URL concurrently, retrying failed requests. A
+// response with a 2xx status code counts as delivered.
+//
+// ctx: the context cancelling pending requests and retries
+//
+// event: the event to deliver
+//
+// Example: Send(ctx, Event{...}) = nil
+func (w *Webhook) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %w", err)
+	}
+
+	errs := make([]error, len(w.config.URLs))
+	var wg sync.WaitGroup
+	for i, url := range w.config.URLs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.deliver(ctx, url, body)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (w *Webhook) deliver(ctx context.Context, url string, body []byte) error {
+	delay := w.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := w.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= w.config.Retries || w.config.Retries < 0 {
+			return fmt.Errorf("error delivering webhook to %s: %w", url, err)
+		}
+
+		w.config.OnLog(fmt.Sprintf("Webhook to %s failed (%v), retrying in %s", url, err, delay))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "blink-middleware")
+	for name, value := range w.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP Status Code %d", resp.StatusCode)
+	}
+
+	return nil
+}
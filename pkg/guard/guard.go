@@ -0,0 +1,257 @@
+// Package guard records cameras on motion. It watches an account for motion events
+// and starts a liveview recording session for the triggering camera, which keeps
+// recording until no motion has been reported for a configurable duration.
+package guard
+
+import (
+	"amattu2/blink-middleware/pkg/events"
+	"amattu2/blink-middleware/pkg/liveview"
+	"amattu2/blink-middleware/pkg/output/record"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Region of the account (e.g. "u011")
+	Region string
+	// Blink API token
+	ApiToken string
+	// The ID of the account to watch
+	AccountId int
+	// Optional networks to watch. Empty watches every network of the account
+	NetworkIds []int
+	// Optional cameras to record. Empty records every camera reporting motion
+	CameraIds []int
+	// The directory recordings are written to, in one subdirectory per camera
+	Dir string
+	// How long to keep recording after the last motion event (defaults to 60 seconds)
+	Duration time.Duration
+	// Interval between polls for motion events (defaults to 30 seconds)
+	PollInterval time.Duration
+	// The configuration of the liveview clients (defaults to liveview.DefaultClientConfig)
+	ClientConfig liveview.ClientConfig
+	// Optional factory for the writer receiving a camera's stream. Defaults to a
+	// record.Recorder writing to Dir/camera-<id>
+	NewWriter func(event events.Event) (io.WriteCloser, error)
+	// Callback for handling session-level errors
+	OnError func(error)
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Guard struct {
+	// Configuration options for the guard
+	config Config
+	// Guards the fields below
+	mu sync.Mutex
+	// The context of the active Run call, or nil
+	ctx context.Context
+	// Active recording sessions keyed by camera ID
+	sessions map[int]*session
+	// Tracks running sessions so Run can wait for them to stop
+	wg sync.WaitGroup
+}
+
+type session struct {
+	// The event that started the session
+	event events.Event
+	// The liveview client of the camera
+	client *liveview.Client
+	// The writer receiving the stream
+	writer io.WriteCloser
+	// When the session stops unless extended by another event. Guarded by Guard.mu
+	deadline time.Time
+}
+
+// NewGuard initializes a new Guard with the provided configuration.
+func NewGuard(config Config) *Guard {
+	if config.Duration <= 0 {
+		config.Duration = 60 * time.Second
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			log.Println(err)
+		}
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(msg string) {
+			log.Println(msg)
+		}
+	}
+	if config.ClientConfig.OnLog == nil {
+		config.ClientConfig.OnLog = config.OnLog
+	}
+	if config.ClientConfig.OnError == nil {
+		config.ClientConfig.OnError = config.OnError
+	}
+	if config.NewWriter == nil {
+		config.NewWriter = func(event events.Event) (io.WriteCloser, error) {
+			return record.Open(record.Config{
+				Dir:   filepath.Join(config.Dir, "camera-"+strconv.Itoa(event.CameraId)),
+				OnLog: config.OnLog,
+			})
+		}
+	}
+
+	return &Guard{
+		config:   config,
+		sessions: map[int]*session{},
+	}
+}
+
+// Run watches for motion events and records the triggering cameras until the
+// context is cancelled. Active sessions are stopped before Run returns.
+//
+// ctx: the context controlling the guard lifecycle
+//
+// Example: Run(ctx) = nil
+func (g *Guard) Run(ctx context.Context) error {
+	g.mu.Lock()
+	g.ctx = ctx
+	g.mu.Unlock()
+
+	watcher := events.NewWatcher(events.WatcherConfig{
+		Region:       g.config.Region,
+		ApiToken:     g.config.ApiToken,
+		AccountId:    g.config.AccountId,
+		NetworkIds:   g.config.NetworkIds,
+		PollInterval: g.config.PollInterval,
+		OnEvent:      g.Trigger,
+		OnError:      g.config.OnError,
+		OnLog:        g.config.OnLog,
+		HTTPClient:   g.config.ClientConfig.HTTPClient,
+		Middleware:   g.config.ClientConfig.Middleware,
+	})
+
+	err := watcher.Run(ctx)
+	g.wg.Wait()
+
+	return err
+}
+
+// Trigger starts or extends a recording session for the camera of the event, as if
+// Blink had reported motion. It has no effect unless Run is active.
+//
+// event: the event describing the camera to record
+//
+// Example: Trigger(events.Event{Camera: "Front Door", CameraId: 11111, NetworkId: 67890, DeviceType: "owl"})
+func (g *Guard) Trigger(event events.Event) {
+	if len(g.config.CameraIds) > 0 && !slices.Contains(g.config.CameraIds, event.CameraId) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.ctx == nil || g.ctx.Err() != nil {
+		return
+	}
+
+	deadline := time.Now().Add(g.config.Duration)
+	if s, ok := g.sessions[event.CameraId]; ok {
+		s.deadline = deadline
+		g.config.OnLog(fmt.Sprintf("Motion on %s, recording extended", event.Camera))
+		return
+	}
+
+	writer, err := g.config.NewWriter(event)
+	if err != nil {
+		g.config.OnError(fmt.Errorf("error creating writer for %s: %w", event.Camera, err))
+		return
+	}
+
+	s := &session{
+		event: event,
+		client: liveview.NewClientWithConfig(
+			g.config.Region,
+			g.config.ApiToken,
+			event.DeviceType,
+			g.config.AccountId,
+			event.NetworkId,
+			event.CameraId,
+			g.config.ClientConfig,
+		),
+		writer:   writer,
+		deadline: deadline,
+	}
+	if err := s.client.Connect(s.writer); err != nil {
+		writer.Close()
+		g.config.OnError(fmt.Errorf("error starting recording of %s: %w", event.Camera, err))
+		return
+	}
+
+	g.config.OnLog(fmt.Sprintf("Motion on %s, recording for %s", event.Camera, g.config.Duration))
+	g.sessions[event.CameraId] = s
+	g.wg.Add(1)
+	go g.supervise(g.ctx, s)
+}
+
+// Recording returns the IDs of the cameras currently being recorded
+func (g *Guard) Recording() []int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ids := make([]int, 0, len(g.sessions))
+	for id := range g.sessions {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+// supervise reconnects a session whose stream ended early and stops it once its
+// deadline has passed
+func (g *Guard) supervise(ctx context.Context, s *session) {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			g.stop(s)
+			return
+		case <-ticker.C:
+			g.mu.Lock()
+			expired := time.Now().After(s.deadline)
+			g.mu.Unlock()
+			if expired {
+				g.stop(s)
+				return
+			}
+
+			if !s.client.IsConnected() {
+				g.config.OnLog(fmt.Sprintf("Stream of %s ended, reconnecting...", s.event.Camera))
+				if d, ok := s.writer.(interface{ Discontinuity() }); ok {
+					d.Discontinuity()
+				}
+				if err := s.client.Connect(s.writer); err != nil {
+					g.config.OnError(fmt.Errorf("error reconnecting %s: %w", s.event.Camera, err))
+				}
+			}
+		}
+	}
+}
+
+func (g *Guard) stop(s *session) {
+	g.mu.Lock()
+	delete(g.sessions, s.event.CameraId)
+	g.mu.Unlock()
+
+	if err := s.client.Disconnect(); err != nil {
+		g.config.OnError(fmt.Errorf("error disconnecting %s: %w", s.event.Camera, err))
+	}
+	if err := s.writer.Close(); err != nil {
+		g.config.OnError(fmt.Errorf("error closing writer of %s: %w", s.event.Camera, err))
+	}
+	g.config.OnLog(fmt.Sprintf("Recording of %s stopped", s.event.Camera))
+}
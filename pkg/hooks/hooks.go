@@ -0,0 +1,281 @@
+// Package hooks runs user-specified commands on lifecycle events, such as the
+// livestream connecting or a camera detecting motion, for automation without
+// changing the middleware.
+//
+// Commands run through the shell of the platform (sh on Unix, cmd on Windows).
+// They receive the event as BLINK_ environment variables and as JSON on their
+// standard input, e.g.:
+//
+//	BLINK_HOOK=on_motion BLINK_CAMERA_ID=123 BLINK_CAMERA="Front Door" ...
+//	{"hook":"on_motion","timestamp":"...","camera_id":123,"camera":"Front Door",...}
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lifecycle events that run hooks
+const (
+	// The livestream connected
+	HOOK_CONNECT = "on_connect"
+	// The livestream disconnected
+	HOOK_DISCONNECT = "on_disconnect"
+	// The livestream or the event watcher reported an error
+	HOOK_ERROR = "on_error"
+	// A camera detected motion
+	HOOK_MOTION = "on_motion"
+	// The speech-to-text command of an stt: output transcribed speech
+	HOOK_TRANSCRIPT = "on_transcript"
+)
+
+// DEFAULT_TIMEOUT bounds each hook command
+const DEFAULT_TIMEOUT = 30 * time.Second
+
+// MAX_OUTPUT is the maximum output of a failed command included in its error
+const MAX_OUTPUT = 512
+
+// Event is a lifecycle event passed to the hook commands
+type Event struct {
+	// The hook being run, one of the HOOK_ constants
+	Hook string `json:"hook"`
+	// When the event occurred (defaults to now)
+	Timestamp time.Time `json:"timestamp"`
+	// The ID of the camera
+	CameraId int `json:"camera_id,omitempty"`
+	// The ID of the network the camera belongs to
+	NetworkId int `json:"network_id,omitempty"`
+	// The name of the camera, if known
+	Camera string `json:"camera,omitempty"`
+	// The error of HOOK_ERROR
+	Error string `json:"error,omitempty"`
+	// How long the livestream was connected, for HOOK_DISCONNECT
+	Duration float64 `json:"duration_seconds,omitempty"`
+	// The URL of the clip of a motion event. Fetching it requires the API token
+	ClipURL string `json:"clip_url,omitempty"`
+	// The URL of the thumbnail of a motion event
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	// The transcribed speech of HOOK_TRANSCRIPT
+	Transcript string `json:"transcript,omitempty"`
+}
+
+// Commands is a list of shell commands, written in JSON as a list or as a single
+// string
+type Commands []string
+
+func (c *Commands) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*c = Commands{command}
+		return nil
+	}
+
+	var commands []string
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return fmt.Errorf("expecting a command or a list of commands")
+	}
+	*c = commands
+
+	return nil
+}
+
+// File is the JSON configuration file of the hooks, e.g.
+//
+//	{
+//	  "on_connect": "notify-send 'Camera $BLINK_CAMERA_ID is live'",
+//	  "on_motion": ["./turn-on-lights.sh", "curl -s -d @- https://example.com/motion"],
+//	  "timeout": "10s"
+//	}
+type File struct {
+	OnConnect    Commands `json:"on_connect,omitempty"`
+	OnDisconnect Commands `json:"on_disconnect,omitempty"`
+	OnError      Commands `json:"on_error,omitempty"`
+	OnMotion     Commands `json:"on_motion,omitempty"`
+	OnTranscript Commands `json:"on_transcript,omitempty"`
+	// Maximum run time of each command, e.g. "10s" (defaults to DEFAULT_TIMEOUT)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type Config struct {
+	// The shell commands run for each hook, keyed by the HOOK_ constants
+	Commands map[string][]string
+	// Maximum run time of each command (defaults to DEFAULT_TIMEOUT)
+	Timeout time.Duration
+	// Optional directory the commands run in (defaults to the working directory)
+	Dir string
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Runner runs the hook commands of events
+type Runner struct {
+	// Configuration options for the runner
+	config Config
+}
+
+// New initializes a new Runner with the provided configuration.
+func New(config Config) *Runner {
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_TIMEOUT
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	return &Runner{config: config}
+}
+
+// Load reads a hooks configuration file. The caller sets the remaining options of
+// the returned configuration, e.g. OnLog.
+//
+// path: the JSON configuration file
+//
+// Example: Load("hooks.json") = Config{Commands: {"on_motion": {"./lights.sh"}}, ...}, nil
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Config{}, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	config := Config{Commands: map[string][]string{
+		HOOK_CONNECT:    file.OnConnect,
+		HOOK_DISCONNECT: file.OnDisconnect,
+		HOOK_ERROR:      file.OnError,
+		HOOK_MOTION:     file.OnMotion,
+		HOOK_TRANSCRIPT: file.OnTranscript,
+	}}
+	if file.Timeout != "" {
+		if config.Timeout, err = time.ParseDuration(file.Timeout); err != nil {
+			return Config{}, fmt.Errorf("error in %s: invalid timeout: %w", path, err)
+		}
+	}
+
+	return config, nil
+}
+
+// Has reports whether any command runs for a hook, e.g. to skip watching for it
+//
+// hook: one of the HOOK_ constants
+//
+// Example: Has("on_motion") = true
+func (r *Runner) Has(hook string) bool {
+	return len(r.config.Commands[hook]) > 0
+}
+
+// Run runs the commands of the hook of an event in order, each with its own
+// timeout. Every command runs even if an earlier one failed; the errors of those
+// that failed are joined.
+//
+// ctx: the context killing the running command
+//
+// event: the event passed to the commands
+//
+// Example: Run(ctx, Event{Hook: HOOK_MOTION, CameraId: 123}) = nil
+func (r *Runner) Run(ctx context.Context, event Event) error {
+	commands := r.config.Commands[event.Hook]
+	if len(commands) == 0 {
+		return nil
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	input, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %w", err)
+	}
+	env := append(os.Environ(), environment(event)...)
+
+	var errs []error
+	for _, command := range commands {
+		if err := r.run(ctx, command, env, input); err != nil {
+			errs = append(errs, fmt.Errorf("error running %s hook %q: %w", event.Hook, command, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Fire runs the commands of the hook of an event in the background, logging
+// their errors
+//
+// event: the event passed to the commands
+//
+// Example: Fire(Event{Hook: HOOK_CONNECT, CameraId: 123})
+func (r *Runner) Fire(event Event) {
+	if !r.Has(event.Hook) {
+		return
+	}
+
+	go func() {
+		if err := r.Run(context.Background(), event); err != nil {
+			r.config.OnLog(err.Error())
+		}
+	}()
+}
+
+// run runs a command through the shell with the event on its standard input
+func (r *Runner) run(ctx context.Context, command string, env []string, input []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	name, args := shell(command)
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = r.config.Dir
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Children of the shell may keep the output open after it was killed
+	cmd.WaitDelay = time.Second
+	started := time.Now()
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", r.config.Timeout)
+		}
+		if detail := bytes.TrimSpace(output.Bytes()); len(detail) > 0 {
+			return fmt.Errorf("%w: %s", err, detail[:min(len(detail), MAX_OUTPUT)])
+		}
+		return err
+	}
+	r.config.OnLog(fmt.Sprintf("Ran hook %q in %s", command, time.Since(started).Round(time.Millisecond)))
+
+	return nil
+}
+
+// environment returns the BLINK_ environment variables of an event
+func environment(event Event) []string {
+	env := []string{
+		"BLINK_HOOK=" + event.Hook,
+		"BLINK_TIMESTAMP=" + event.Timestamp.Format(time.RFC3339),
+	}
+	add := func(name string, value string) {
+		if value != "" && value != "0" {
+			env = append(env, "BLINK_"+name+"="+value)
+		}
+	}
+	add("CAMERA_ID", strconv.Itoa(event.CameraId))
+	add("NETWORK_ID", strconv.Itoa(event.NetworkId))
+	add("CAMERA", event.Camera)
+	add("ERROR", strings.ReplaceAll(event.Error, "\n", " "))
+	add("DURATION", strconv.FormatFloat(event.Duration, 'f', -1, 64))
+	add("CLIP_URL", event.ClipURL)
+	add("THUMBNAIL_URL", event.ThumbnailURL)
+	add("TRANSCRIPT", event.Transcript)
+
+	return env
+}
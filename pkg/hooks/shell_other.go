@@ -0,0 +1,8 @@
+//go:build !windows
+
+package hooks
+
+// shell returns the command line running a command through sh
+func shell(command string) (string, []string) {
+	return "sh", []string{"-c", command}
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package hooks
+
+// shell returns the command line running a command through cmd
+func shell(command string) (string, []string) {
+	return "cmd", []string{"/C", command}
+}
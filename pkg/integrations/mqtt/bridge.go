@@ -0,0 +1,571 @@
+package mqtt
+
+import (
+	"amattu2/blink-middleware/pkg/liveview"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FLOODLIGHT_TIMEOUT bounds the Blink API requests switching a floodlight
+const FLOODLIGHT_TIMEOUT = 30 * time.Second
+
+type Camera struct {
+	// Unique name of the camera used in topic names (e.g. "front-door")
+	Name string
+	// The liveview client for the camera
+	Client *liveview.Client
+	// Factory for the writer that receives the stream when it is started
+	NewWriter func() (io.WriteCloser, error)
+	// Whether the camera is a doorbell, whose presses are published by DoorbellPressed
+	Doorbell bool
+	// Whether the camera is a Wired Floodlight Camera, whose light is exposed as a
+	// switch and a brightness setting
+	Floodlight bool
+}
+
+type BridgeConfig struct {
+	// Broker URL (e.g. "tcp://localhost:1883" or "tls://broker:8883")
+	Broker string
+	// MQTT client identifier
+	ClientId string
+	// Optional username for broker authentication
+	Username string
+	// Optional password for broker authentication
+	Password string
+	// Prefix for all bridge topics (defaults to "blink")
+	TopicPrefix string
+	// Home Assistant discovery prefix (e.g. "homeassistant"). Empty disables discovery
+	DiscoveryPrefix string
+	// Interval for publishing stream state and bitrate
+	StatusInterval time.Duration
+	// Optional interval for publishing the battery, signal, and temperature of each
+	// camera. Zero disables health reporting
+	HealthInterval time.Duration
+	// The cameras exposed by the bridge
+	Cameras []Camera
+	// Callback for handling bridge-level errors
+	OnError func(error)
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Bridge struct {
+	// Configuration options for the bridge
+	config BridgeConfig
+	// The underlying MQTT connection
+	conn *client
+	// The connection once Run has connected, for publishing from other goroutines
+	published atomic.Pointer[client]
+	// Per-camera stream state, keyed by camera name
+	streams map[string]*cameraStream
+}
+
+type cameraStream struct {
+	camera Camera
+	mu     sync.Mutex
+	writer io.WriteCloser
+	bytes  atomic.Int64
+	active bool
+}
+
+// NewBridge initializes a new MQTT bridge with the provided configuration.
+func NewBridge(config BridgeConfig) *Bridge {
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = "blink"
+	}
+	if config.ClientId == "" {
+		config.ClientId = "blink-middleware"
+	}
+	if config.StatusInterval <= 0 {
+		config.StatusInterval = 10 * time.Second
+	}
+	if config.OnError == nil {
+		config.OnError = func(err error) {
+			log.Println(err)
+		}
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(msg string) {
+			log.Println(msg)
+		}
+	}
+
+	streams := make(map[string]*cameraStream, len(config.Cameras))
+	for _, camera := range config.Cameras {
+		streams[camera.Name] = &cameraStream{camera: camera}
+	}
+
+	return &Bridge{
+		config:  config,
+		streams: streams,
+	}
+}
+
+// Run connects to the broker and serves stream control requests until the context
+// is cancelled or the broker connection is lost.
+//
+// ctx: the context controlling the bridge lifecycle
+//
+// Example: Run(ctx) = nil
+func (b *Bridge) Run(ctx context.Context) error {
+	conn, err := dial(clientOptions{
+		Broker:      b.config.Broker,
+		ClientId:    b.config.ClientId,
+		Username:    b.config.Username,
+		Password:    b.config.Password,
+		KeepAlive:   30 * time.Second,
+		WillTopic:   b.availabilityTopic(),
+		WillPayload: []byte("offline"),
+		OnMessage:   b.handleMessage,
+	})
+	if err != nil {
+		return fmt.Errorf("error connecting to broker: %w", err)
+	}
+	b.conn = conn
+	b.published.Store(conn)
+	defer b.shutdown()
+
+	b.config.OnLog(fmt.Sprintf("Connected to MQTT broker %s", b.config.Broker))
+
+	if err := conn.Subscribe(b.topic("+", "liveview/set"), b.topic("+", "floodlight/set"), b.topic("+", "floodlight/brightness/set")); err != nil {
+		return fmt.Errorf("error subscribing to command topics: %w", err)
+	}
+
+	// Health is read from the Blink API, so it is published from its own loop to
+	// keep the status updates on schedule
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if b.config.HealthInterval > 0 {
+		go b.publishHealthLoop(ctx)
+	}
+
+	if b.config.DiscoveryPrefix != "" {
+		for _, stream := range b.streams {
+			if err := b.publishDiscovery(stream.camera); err != nil {
+				b.config.OnError(fmt.Errorf("error publishing discovery for %s: %w", stream.camera.Name, err))
+			}
+		}
+	}
+
+	if err := conn.Publish(b.availabilityTopic(), []byte("online"), true); err != nil {
+		return fmt.Errorf("error publishing availability: %w", err)
+	}
+
+	ticker := time.NewTicker(b.config.StatusInterval)
+	defer ticker.Stop()
+
+	b.publishStatus(b.config.StatusInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-conn.Done():
+			return fmt.Errorf("broker connection lost: %w", conn.Err())
+		case <-ticker.C:
+			b.publishStatus(b.config.StatusInterval)
+		}
+	}
+}
+
+// shutdown stops all active streams and disconnects from the broker
+func (b *Bridge) shutdown() {
+	for _, stream := range b.streams {
+		b.stop(stream)
+		b.conn.Publish(b.topic(stream.camera.Name, "liveview/state"), []byte("OFF"), true)
+	}
+
+	b.conn.Publish(b.availabilityTopic(), []byte("offline"), true)
+	b.conn.Close()
+}
+
+// handleMessage processes an incoming stream or floodlight control message
+func (b *Bridge) handleMessage(msg message) {
+	segments := strings.Split(strings.TrimPrefix(msg.Topic, b.config.TopicPrefix+"/"), "/")
+	if len(segments) < 3 || segments[len(segments)-1] != "set" {
+		return
+	}
+
+	stream, ok := b.streams[segments[0]]
+	if !ok {
+		b.config.OnError(fmt.Errorf("received command for unknown camera: %s", segments[0]))
+		return
+	}
+
+	switch strings.Join(segments[1:len(segments)-1], "/") {
+	case "liveview":
+		b.handleLiveview(stream, msg.Payload)
+	case "floodlight":
+		b.handleFloodlight(stream, msg.Payload)
+	case "floodlight/brightness":
+		b.handleFloodlightBrightness(stream, msg.Payload)
+	}
+}
+
+// handleLiveview starts or stops the stream of a camera
+func (b *Bridge) handleLiveview(stream *cameraStream, payload []byte) {
+
+	// Avoid blocking the MQTT read loop on the Blink API calls
+	switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+	case "ON":
+		go func() {
+			if err := b.start(stream); err != nil {
+				b.config.OnError(fmt.Errorf("error starting %s: %w", stream.camera.Name, err))
+			}
+			b.publishState(stream)
+		}()
+	case "OFF":
+		go func() {
+			b.stop(stream)
+			b.publishState(stream)
+		}()
+	default:
+		b.config.OnError(fmt.Errorf("unsupported command payload for %s: %s", stream.camera.Name, payload))
+	}
+}
+
+// handleFloodlight switches the floodlight of a camera on or off
+func (b *Bridge) handleFloodlight(stream *cameraStream, payload []byte) {
+	if !stream.camera.Floodlight {
+		b.config.OnError(fmt.Errorf("received floodlight command for %s, which has no floodlight", stream.camera.Name))
+		return
+	}
+
+	state := strings.ToUpper(strings.TrimSpace(string(payload)))
+	if state != "ON" && state != "OFF" {
+		b.config.OnError(fmt.Errorf("unsupported floodlight payload for %s: %s", stream.camera.Name, payload))
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), FLOODLIGHT_TIMEOUT)
+		defer cancel()
+
+		if err := stream.camera.Client.SetFloodlight(ctx, state == "ON"); err != nil {
+			b.config.OnError(fmt.Errorf("error switching the floodlight of %s: %w", stream.camera.Name, err))
+			return
+		}
+		b.config.OnLog(fmt.Sprintf("Switched the floodlight of %s %s", stream.camera.Name, strings.ToLower(state)))
+		b.publishRetained(stream.camera.Name, "floodlight/state", state)
+	}()
+}
+
+// handleFloodlightBrightness sets the brightness of the floodlight of a camera
+func (b *Bridge) handleFloodlightBrightness(stream *cameraStream, payload []byte) {
+	if !stream.camera.Floodlight {
+		b.config.OnError(fmt.Errorf("received floodlight command for %s, which has no floodlight", stream.camera.Name))
+		return
+	}
+
+	// Home Assistant sends the values of number entities as floats
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		b.config.OnError(fmt.Errorf("unsupported brightness payload for %s: %s", stream.camera.Name, payload))
+		return
+	}
+	brightness := int(value)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), FLOODLIGHT_TIMEOUT)
+		defer cancel()
+
+		if err := stream.camera.Client.SetFloodlightBrightness(ctx, brightness); err != nil {
+			b.config.OnError(fmt.Errorf("error setting the floodlight brightness of %s: %w", stream.camera.Name, err))
+			return
+		}
+		b.publishRetained(stream.camera.Name, "floodlight/brightness/state", strconv.Itoa(brightness))
+	}()
+}
+
+// publishRetained publishes a retained state of a camera, if connected
+func (b *Bridge) publishRetained(camera string, suffix string, payload string) {
+	conn := b.published.Load()
+	if conn == nil {
+		return
+	}
+	if err := conn.Publish(b.topic(camera, suffix), []byte(payload), true); err != nil {
+		b.config.OnError(fmt.Errorf("error publishing %s for %s: %w", suffix, camera, err))
+	}
+}
+
+// start connects the camera stream to a new writer
+func (b *Bridge) start(stream *cameraStream) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if stream.active {
+		return nil
+	}
+
+	writer, err := stream.camera.NewWriter()
+	if err != nil {
+		return fmt.Errorf("error creating writer: %w", err)
+	}
+
+	stream.bytes.Store(0)
+	if err := stream.camera.Client.Connect(&countingWriter{writer: writer, count: &stream.bytes}); err != nil {
+		writer.Close()
+		return err
+	}
+
+	stream.writer = writer
+	stream.active = true
+	b.config.OnLog(fmt.Sprintf("Started liveview for %s", stream.camera.Name))
+
+	return nil
+}
+
+// stop disconnects the camera stream and closes its writer
+func (b *Bridge) stop(stream *cameraStream) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	b.release(stream)
+}
+
+// stopEnded closes the writer of a camera stream that ended on its own
+func (b *Bridge) stopEnded(stream *cameraStream) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	if !stream.camera.Client.IsConnected() {
+		b.release(stream)
+	}
+}
+
+// release disconnects the camera stream and closes its writer. stream.mu must be
+// held.
+func (b *Bridge) release(stream *cameraStream) {
+	if !stream.active {
+		return
+	}
+
+	if err := stream.camera.Client.Disconnect(); err != nil {
+		b.config.OnError(fmt.Errorf("error stopping %s: %w", stream.camera.Name, err))
+	}
+	if err := stream.writer.Close(); err != nil {
+		b.config.OnError(fmt.Errorf("error closing writer for %s: %w", stream.camera.Name, err))
+	}
+
+	stream.writer = nil
+	stream.active = false
+	b.config.OnLog(fmt.Sprintf("Stopped liveview for %s", stream.camera.Name))
+}
+
+// publishStatus publishes the state and bitrate of every camera
+//
+// interval: the time elapsed since the last status update
+func (b *Bridge) publishStatus(interval time.Duration) {
+	for _, stream := range b.streams {
+		// Release the writer if the stream ended on its own
+		b.stopEnded(stream)
+
+		b.publishState(stream)
+
+		kbps := float64(stream.bytes.Swap(0)*8) / interval.Seconds() / 1000
+		if err := b.conn.Publish(b.topic(stream.camera.Name, "bitrate"), []byte(fmt.Sprintf("%.1f", kbps)), false); err != nil {
+			b.config.OnError(fmt.Errorf("error publishing bitrate for %s: %w", stream.camera.Name, err))
+		}
+	}
+}
+
+// publishHealthLoop publishes the health of every camera until the context is cancelled
+func (b *Bridge) publishHealthLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, stream := range b.streams {
+			if ctx.Err() != nil {
+				return
+			}
+
+			health, err := stream.camera.Client.Health()
+			if err != nil {
+				b.config.OnError(fmt.Errorf("error reading health of %s: %w", stream.camera.Name, err))
+				continue
+			}
+			body, _ := json.Marshal(health)
+			if err := b.conn.Publish(b.topic(stream.camera.Name, "health"), body, true); err != nil {
+				b.config.OnError(fmt.Errorf("error publishing health for %s: %w", stream.camera.Name, err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bridge) publishState(stream *cameraStream) {
+	state := "OFF"
+	if stream.camera.Client.IsConnected() {
+		state = "ON"
+	}
+
+	if err := b.conn.Publish(b.topic(stream.camera.Name, "liveview/state"), []byte(state), true); err != nil {
+		b.config.OnError(fmt.Errorf("error publishing state for %s: %w", stream.camera.Name, err))
+	}
+}
+
+// publishDiscovery publishes the Home Assistant discovery payloads for a camera
+func (b *Bridge) publishDiscovery(camera Camera) error {
+	objectId := "blink_" + strings.ReplaceAll(camera.Name, "-", "_")
+	device := map[string]any{
+		"identifiers":  []string{objectId},
+		"name":         camera.Name,
+		"manufacturer": "Blink",
+	}
+
+	payloads := map[string]map[string]any{
+		fmt.Sprintf("%s/switch/%s/liveview/config", b.config.DiscoveryPrefix, objectId): {
+			"name":               "Liveview",
+			"unique_id":          objectId + "_liveview",
+			"command_topic":      b.topic(camera.Name, "liveview/set"),
+			"state_topic":        b.topic(camera.Name, "liveview/state"),
+			"availability_topic": b.availabilityTopic(),
+			"icon":               "mdi:cctv",
+			"device":             device,
+		},
+		fmt.Sprintf("%s/sensor/%s/bitrate/config", b.config.DiscoveryPrefix, objectId): {
+			"name":                "Bitrate",
+			"unique_id":           objectId + "_bitrate",
+			"state_topic":         b.topic(camera.Name, "bitrate"),
+			"availability_topic":  b.availabilityTopic(),
+			"unit_of_measurement": "kbit/s",
+			"state_class":         "measurement",
+			"device":              device,
+		},
+	}
+
+	if b.config.HealthInterval > 0 {
+		sensors := []struct {
+			key      string
+			name     string
+			template string
+			unit     string
+			class    string
+		}{
+			{"battery", "Battery", "{{ value_json.battery }}", "", ""},
+			{"battery_voltage", "Battery voltage", "{{ value_json.battery_voltage | default(0) }}", "V", "voltage"},
+			{"wifi", "Wi-Fi signal", "{{ value_json.wifi_bars }}", "bars", ""},
+			{"temperature", "Temperature", "{{ value_json.temperature }}", "°F", "temperature"},
+		}
+		for _, sensor := range sensors {
+			payload := map[string]any{
+				"name":               sensor.name,
+				"unique_id":          objectId + "_" + sensor.key,
+				"state_topic":        b.topic(camera.Name, "health"),
+				"value_template":     sensor.template,
+				"availability_topic": b.availabilityTopic(),
+				"entity_category":    "diagnostic",
+				"device":             device,
+			}
+			if sensor.unit != "" {
+				payload["unit_of_measurement"] = sensor.unit
+				payload["state_class"] = "measurement"
+			}
+			if sensor.class != "" {
+				payload["device_class"] = sensor.class
+			}
+			payloads[fmt.Sprintf("%s/sensor/%s/%s/config", b.config.DiscoveryPrefix, objectId, sensor.key)] = payload
+		}
+	}
+
+	if camera.Floodlight {
+		payloads[fmt.Sprintf("%s/switch/%s/floodlight/config", b.config.DiscoveryPrefix, objectId)] = map[string]any{
+			"name":               "Floodlight",
+			"unique_id":          objectId + "_floodlight",
+			"command_topic":      b.topic(camera.Name, "floodlight/set"),
+			"state_topic":        b.topic(camera.Name, "floodlight/state"),
+			"availability_topic": b.availabilityTopic(),
+			"icon":               "mdi:light-flood-down",
+			"device":             device,
+		}
+		payloads[fmt.Sprintf("%s/number/%s/floodlight_brightness/config", b.config.DiscoveryPrefix, objectId)] = map[string]any{
+			"name":                "Floodlight brightness",
+			"unique_id":           objectId + "_floodlight_brightness",
+			"command_topic":       b.topic(camera.Name, "floodlight/brightness/set"),
+			"state_topic":         b.topic(camera.Name, "floodlight/brightness/state"),
+			"availability_topic":  b.availabilityTopic(),
+			"min":                 liveview.MIN_FLOODLIGHT_BRIGHTNESS,
+			"max":                 liveview.MAX_FLOODLIGHT_BRIGHTNESS,
+			"unit_of_measurement": "%",
+			"icon":                "mdi:brightness-percent",
+			"device":              device,
+		}
+	}
+
+	if camera.Doorbell {
+		payloads[fmt.Sprintf("%s/device_automation/%s/doorbell/config", b.config.DiscoveryPrefix, objectId)] = map[string]any{
+			"automation_type": "trigger",
+			"topic":           b.topic(camera.Name, "doorbell"),
+			"type":            "button_short_press",
+			"subtype":         "button_1",
+			"payload":         "pressed",
+			"device":          device,
+		}
+	}
+
+	for topic, payload := range payloads {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if err := b.conn.Publish(topic, body, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DoorbellPressed publishes a press of the doorbell to its doorbell topic, e.g. from
+// the OnDoorbellPress callback of an events.Watcher. Home Assistant exposes it as a
+// device trigger when discovery is enabled.
+//
+// camera: the name of the camera
+//
+// Example: DoorbellPressed("front-door") = nil
+func (b *Bridge) DoorbellPressed(camera string) error {
+	stream, ok := b.streams[camera]
+	if !ok || !stream.camera.Doorbell {
+		return fmt.Errorf("unknown doorbell %q", camera)
+	}
+	conn := b.published.Load()
+	if conn == nil {
+		return fmt.Errorf("not connected to the broker")
+	}
+
+	return conn.Publish(b.topic(camera, "doorbell"), []byte("pressed"), false)
+}
+
+func (b *Bridge) topic(camera string, suffix string) string {
+	return fmt.Sprintf("%s/%s/%s", b.config.TopicPrefix, camera, suffix)
+}
+
+func (b *Bridge) availabilityTopic() string {
+	return b.config.TopicPrefix + "/availability"
+}
+
+// countingWriter tracks the number of bytes written to the underlying writer
+type countingWriter struct {
+	writer io.Writer
+	count  *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count.Add(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,352 @@
+package mqtt
+
+import (
+	"amattu2/blink-middleware/internal/netutil"
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect     byte = 0x10
+	packetConnack     byte = 0x20
+	packetPublish     byte = 0x30
+	packetSubscribe   byte = 0x82
+	packetSuback      byte = 0x90
+	packetPingreq     byte = 0xc0
+	packetPingresp    byte = 0xd0
+	packetDisconnect  byte = 0xe0
+	packetTypeMask    byte = 0xf0
+	publishRetainFlag byte = 0x01
+)
+
+type message struct {
+	// The topic the message was published on
+	Topic string
+	// The raw message payload
+	Payload []byte
+}
+
+type clientOptions struct {
+	// Broker URL (e.g. "tcp://localhost:1883" or "tls://broker:8883")
+	Broker string
+	// MQTT client identifier
+	ClientId string
+	// Optional username for broker authentication
+	Username string
+	// Optional password for broker authentication
+	Password string
+	// Keep-alive interval negotiated with the broker
+	KeepAlive time.Duration
+	// Topic of the last-will message, if any
+	WillTopic string
+	// Payload of the last-will message
+	WillPayload []byte
+	// Callback for incoming publish packets
+	OnMessage func(message)
+}
+
+// client is a minimal MQTT 3.1.1 client supporting QoS 0 publish/subscribe
+type client struct {
+	options clientOptions
+	conn    net.Conn
+	writeMu sync.Mutex
+	done    chan struct{}
+	err     error
+}
+
+// dial connects to the broker and performs the MQTT handshake
+//
+// options: the connection options
+//
+// Example: dial(clientOptions{Broker: "tcp://localhost:1883"}) = &client{...}, nil
+func dial(options clientOptions) (*client, error) {
+	brokerUrl, err := url.Parse(options.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+
+	var conn net.Conn
+	switch brokerUrl.Scheme {
+	case "tcp", "mqtt":
+		conn, err = net.DialTimeout("tcp", netutil.HostPort(brokerUrl, "1883"), 10*time.Second)
+	case "tls", "ssl", "mqtts":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", netutil.HostPort(brokerUrl, "8883"), &tls.Config{
+			ServerName: brokerUrl.Hostname(),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme: %s", brokerUrl.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to broker: %w", err)
+	}
+
+	c := &client{
+		options: options,
+		conn:    conn,
+		done:    make(chan struct{}),
+	}
+
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+	go c.pingLoop()
+
+	return c, nil
+}
+
+// handshake sends the CONNECT packet and waits for the CONNACK
+func (c *client) handshake() error {
+	var flags byte = 0x02 // Clean session
+	payload := encodeString(c.options.ClientId)
+	if c.options.WillTopic != "" {
+		flags |= 0x04 | 0x20 // Will flag, will retain
+		payload = append(payload, encodeString(c.options.WillTopic)...)
+		payload = append(payload, encodeBytes(c.options.WillPayload)...)
+	}
+	if c.options.Username != "" {
+		flags |= 0x80
+		payload = append(payload, encodeString(c.options.Username)...)
+	}
+	if c.options.Password != "" {
+		flags |= 0x40
+		payload = append(payload, encodeString(c.options.Password)...)
+	}
+
+	header := encodeString("MQTT")
+	header = append(header, 0x04, flags)
+	header = binary.BigEndian.AppendUint16(header, uint16(c.options.KeepAlive/time.Second))
+
+	if err := c.writePacket(packetConnect, append(header, payload...)); err != nil {
+		return fmt.Errorf("error sending connect: %w", err)
+	}
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return fmt.Errorf("error setting read deadline: %w", err)
+	}
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	packetType, body, err := readPacket(c.conn)
+	if err != nil {
+		return fmt.Errorf("error reading connack: %w", err)
+	}
+	if packetType&packetTypeMask != packetConnack || len(body) < 2 {
+		return fmt.Errorf("unexpected packet type 0x%x during handshake", packetType)
+	}
+	if body[1] != 0x00 {
+		return fmt.Errorf("broker refused connection with return code %d", body[1])
+	}
+
+	return nil
+}
+
+// readLoop dispatches incoming packets until the connection is closed
+func (c *client) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	for {
+		packetType, body, err := readPacket(reader)
+		if err != nil {
+			c.close(err)
+			return
+		}
+
+		if packetType&packetTypeMask != packetPublish {
+			continue
+		}
+
+		if len(body) < 2 {
+			continue
+		}
+		topicLen := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+topicLen {
+			continue
+		}
+		offset := 2 + topicLen
+		// Skip the packet identifier for QoS > 0
+		if (packetType>>1)&0x03 > 0 {
+			offset += 2
+		}
+		if offset > len(body) {
+			continue
+		}
+
+		if c.options.OnMessage != nil {
+			c.options.OnMessage(message{
+				Topic:   string(body[2 : 2+topicLen]),
+				Payload: body[offset:],
+			})
+		}
+	}
+}
+
+// pingLoop sends keep-alive pings at the negotiated interval
+func (c *client) pingLoop() {
+	if c.options.KeepAlive <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.options.KeepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.writePacket(packetPingreq, nil); err != nil {
+				c.close(fmt.Errorf("error sending ping: %w", err))
+				return
+			}
+		}
+	}
+}
+
+// Subscribe subscribes to the given topic filters at QoS 0
+//
+// topics: the topic filters to subscribe to
+//
+// Example: c.Subscribe("blink/+/liveview/set") = nil
+func (c *client) Subscribe(topics ...string) error {
+	body := []byte{0x00, 0x01} // Packet identifier
+	for _, topic := range topics {
+		body = append(body, encodeString(topic)...)
+		body = append(body, 0x00)
+	}
+
+	return c.writePacket(packetSubscribe, body)
+}
+
+// Publish publishes a QoS 0 message
+//
+// topic: the topic to publish to
+//
+// payload: the message payload
+//
+// retain: whether the broker should retain the message
+//
+// Example: c.Publish("blink/availability", []byte("online"), true) = nil
+func (c *client) Publish(topic string, payload []byte, retain bool) error {
+	header := packetPublish
+	if retain {
+		header |= publishRetainFlag
+	}
+
+	return c.writePacket(header, append(encodeString(topic), payload...))
+}
+
+// Close gracefully disconnects from the broker
+func (c *client) Close() error {
+	c.writePacket(packetDisconnect, nil)
+	c.close(nil)
+
+	return nil
+}
+
+// Done returns a channel closed when the connection terminates
+func (c *client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that terminated the connection, if any
+func (c *client) Err() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.err
+}
+
+func (c *client) close(err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+func (c *client) writePacket(header byte, body []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+
+	packet := append([]byte{header}, encodeLength(len(body))...)
+	_, err := c.conn.Write(append(packet, body...))
+
+	return err
+}
+
+// readPacket reads a single MQTT control packet from the reader
+func readPacket(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i >= 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+
+		length += int(b[0]&0x7f) * multiplier
+		multiplier *= 128
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], body, nil
+}
+
+func encodeLength(length int) []byte {
+	var encoded []byte
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		encoded = append(encoded, b)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+func encodeString(s string) []byte {
+	return encodeBytes([]byte(s))
+}
+
+func encodeBytes(b []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
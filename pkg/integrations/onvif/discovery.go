@@ -0,0 +1,99 @@
+package onvif
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"net"
+)
+
+// WS_DISCOVERY_ADDR is the WS-Discovery multicast group
+const WS_DISCOVERY_ADDR = "239.255.255.250:3702"
+
+const probeMatchTemplate = `<?xml version="1.0" encoding="UTF-8"?>` +
+	`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"` +
+	` xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing"` +
+	` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery"` +
+	` xmlns:dn="http://www.onvif.org/ver10/network/wsdl">` +
+	`<env:Header><wsa:MessageID>urn:uuid:%s</wsa:MessageID><wsa:RelatesTo>%s</wsa:RelatesTo>` +
+	`<wsa:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</wsa:To>` +
+	`<wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</wsa:Action></env:Header>` +
+	`<env:Body><d:ProbeMatches><d:ProbeMatch>` +
+	`<wsa:EndpointReference><wsa:Address>urn:uuid:%s</wsa:Address></wsa:EndpointReference>` +
+	`<d:Types>dn:NetworkVideoTransmitter</d:Types>` +
+	`<d:Scopes>onvif://www.onvif.org/Profile/Streaming onvif://www.onvif.org/name/%s</d:Scopes>` +
+	`<d:XAddrs>%s</d:XAddrs><d:MetadataVersion>1</d:MetadataVersion>` +
+	`</d:ProbeMatch></d:ProbeMatches></env:Body></env:Envelope>`
+
+type probe struct {
+	Header struct {
+		MessageID string `xml:"MessageID"`
+	} `xml:"Header"`
+	Body struct {
+		Probe *struct{} `xml:"Probe"`
+	} `xml:"Body"`
+}
+
+// ServeDiscovery answers WS-Discovery probes so that NVRs can find the device
+// service automatically. It blocks until the context is cancelled.
+//
+// ctx: the context controlling the responder lifecycle
+//
+// xaddr: the device service URL advertised to clients (e.g. "http://192.168.1.10:8080/onvif/device_service")
+//
+// Example: ServeDiscovery(ctx, "http://192.168.1.10:8080/onvif/device_service") = nil
+func (s *Service) ServeDiscovery(ctx context.Context, xaddr string) error {
+	group, err := net.ResolveUDPAddr("udp4", WS_DISCOVERY_ADDR)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("unable to join WS-Discovery group: %w", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	endpoint := uuid()
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error reading WS-Discovery probe: %w", err)
+		}
+
+		var msg probe
+		if err := xml.Unmarshal(buf[:n], &msg); err != nil || msg.Body.Probe == nil {
+			continue
+		}
+
+		// Only answer probes for any type or network video transmitters
+		if !bytes.Contains(buf[:n], []byte("NetworkVideoTransmitter")) && bytes.Contains(buf[:n], []byte("Types>")) {
+			continue
+		}
+
+		response := fmt.Sprintf(probeMatchTemplate, uuid(), escape(msg.Header.MessageID), endpoint, escape(s.config.Model), escape(xaddr))
+		if _, err := conn.WriteToUDP([]byte(response), addr); err != nil {
+			s.config.OnLog(fmt.Sprintf("Error answering WS-Discovery probe from %s: %v", addr, err))
+		}
+	}
+}
+
+func uuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
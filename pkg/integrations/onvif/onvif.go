@@ -0,0 +1,335 @@
+// Package onvif implements a minimal ONVIF Profile S device and media service so
+// that NVRs (Synology Surveillance Station, Blue Iris, etc.) can add the cameras
+// served by the RTSP output.
+package onvif
+
+import (
+	"amattu2/blink-middleware/pkg/output/rtsp"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service paths advertised to clients
+const (
+	DEVICE_SERVICE_PATH = "/onvif/device_service"
+	MEDIA_SERVICE_PATH  = "/onvif/media_service"
+)
+
+// CREATED_TOLERANCE is how far the Created time of a UsernameToken may be from the
+// clock of the service. Nonces are remembered for as long, so a captured request
+// cannot be replayed.
+const CREATED_TOLERANCE = 5 * time.Minute
+
+type Camera struct {
+	// The camera name, used as the RTSP stream path and profile token
+	Name string
+	// The video width reported to clients (defaults to 1920)
+	Width int
+	// The video height reported to clients (defaults to 1080)
+	Height int
+}
+
+type Config struct {
+	// The RTSP server serving the camera streams
+	RTSP *rtsp.Server
+	// The cameras exposed through the media service
+	Cameras []Camera
+	// The host:port clients reach the device service at, advertised in the service
+	// and stream URLs (defaults to the local address of each connection)
+	Addr string
+	// Optional username required via WS-Security UsernameToken
+	Username string
+	// Optional password required via WS-Security UsernameToken
+	Password string
+	// Manufacturer reported by GetDeviceInformation (defaults to "Blink")
+	Manufacturer string
+	// Model reported by GetDeviceInformation (defaults to "blink-middleware")
+	Model string
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Service struct {
+	// Configuration options for the service
+	config Config
+	// Guards nonces
+	mu sync.Mutex
+	// The UsernameToken nonces seen within CREATED_TOLERANCE, by their Created time
+	nonces map[string]time.Time
+}
+
+// NewService initializes a new ONVIF service with the provided configuration.
+func NewService(config Config) *Service {
+	if config.Manufacturer == "" {
+		config.Manufacturer = "Blink"
+	}
+	if config.Model == "" {
+		config.Model = "blink-middleware"
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(msg string) {
+			log.Println(msg)
+		}
+	}
+	for i := range config.Cameras {
+		if config.Cameras[i].Width == 0 {
+			config.Cameras[i].Width = 1920
+		}
+		if config.Cameras[i].Height == 0 {
+			config.Cameras[i].Height = 1080
+		}
+	}
+
+	return &Service{config: config, nonces: map[string]time.Time{}}
+}
+
+// Handler returns the HTTP handler serving the device and media services
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DEVICE_SERVICE_PATH, s.serveSOAP)
+	mux.HandleFunc(MEDIA_SERVICE_PATH, s.serveSOAP)
+
+	return mux
+}
+
+type envelope struct {
+	Header struct {
+		Security struct {
+			UsernameToken struct {
+				Username string `xml:"Username"`
+				Password string `xml:"Password"`
+				Nonce    string `xml:"Nonce"`
+				Created  string `xml:"Created"`
+			} `xml:"UsernameToken"`
+		} `xml:"Security"`
+	} `xml:"Header"`
+	Body struct {
+		Content []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+type profileRequest struct {
+	ProfileToken string `xml:"ProfileToken"`
+}
+
+func (s *Service) serveSOAP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "error reading request", http.StatusBadRequest)
+		return
+	}
+
+	var env envelope
+	if err := xml.Unmarshal(body, &env); err != nil {
+		writeFault(w, "env:Sender", "ter:WellFormed", "malformed SOAP envelope")
+		return
+	}
+
+	action := actionName(env.Body.Content)
+
+	// Clients query the clock before authenticating to compute the digest
+	if action != "GetSystemDateAndTime" && !s.authenticate(env) {
+		writeFault(w, "env:Sender", "ter:NotAuthorized", "sender not authorized")
+		return
+	}
+
+	addr := s.addr(r)
+	host, _, _ := net.SplitHostPort(addr)
+	var response string
+	switch action {
+	case "GetSystemDateAndTime":
+		response = systemDateAndTime(time.Now().UTC())
+	case "GetDeviceInformation":
+		response = fmt.Sprintf(deviceInformationTemplate, escape(s.config.Manufacturer), escape(s.config.Model))
+	case "GetCapabilities":
+		response = fmt.Sprintf(capabilitiesTemplate, serviceUrl(addr, DEVICE_SERVICE_PATH), serviceUrl(addr, MEDIA_SERVICE_PATH))
+	case "GetServices":
+		response = fmt.Sprintf(servicesTemplate, serviceUrl(addr, DEVICE_SERVICE_PATH), serviceUrl(addr, MEDIA_SERVICE_PATH))
+	case "GetScopes":
+		response = fmt.Sprintf(scopesTemplate, escape(s.config.Model))
+	case "GetVideoSources":
+		response = s.videoSources()
+	case "GetProfiles":
+		response = s.profiles("")
+	case "GetProfile":
+		var req profileRequest
+		xml.Unmarshal(env.Body.Content, &req)
+		response = s.profiles(req.ProfileToken)
+	case "GetStreamUri":
+		var req profileRequest
+		xml.Unmarshal(env.Body.Content, &req)
+
+		camera, ok := s.camera(req.ProfileToken)
+		if !ok {
+			writeFault(w, "env:Sender", "ter:NoProfile", "profile does not exist")
+			return
+		}
+		response = fmt.Sprintf(streamUriTemplate, escape(s.config.RTSP.URL(host, camera.Name)))
+	default:
+		writeFault(w, "env:Receiver", "ter:ActionNotSupported", fmt.Sprintf("%s is not supported", action))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	io.WriteString(w, soapEnvelope(response))
+}
+
+// authenticate verifies the WS-Security UsernameToken password digest, rejecting
+// tokens created outside CREATED_TOLERANCE and nonces that were already used
+func (s *Service) authenticate(env envelope) bool {
+	if s.config.Username == "" {
+		return true
+	}
+
+	token := env.Header.Security.UsernameToken
+	if token.Username != s.config.Username {
+		return false
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(token.Nonce)
+	if err != nil {
+		return false
+	}
+
+	digest := sha1.Sum(append(append(nonce, token.Created...), s.config.Password...))
+	expected := base64.StdEncoding.EncodeToString(digest[:])
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.TrimSpace(token.Password))) != 1 {
+		return false
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(token.Created))
+	if err != nil {
+		return false
+	}
+	if age := time.Since(created); age > CREATED_TOLERANCE || age < -CREATED_TOLERANCE {
+		return false
+	}
+
+	return s.useNonce(token.Nonce, created)
+}
+
+// useNonce records a nonce, returning false if it was seen within
+// CREATED_TOLERANCE
+func (s *Service) useNonce(nonce string, created time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for seen, at := range s.nonces {
+		if now.Sub(at) > CREATED_TOLERANCE {
+			delete(s.nonces, seen)
+		}
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = created
+
+	return true
+}
+
+func (s *Service) camera(token string) (Camera, bool) {
+	for _, camera := range s.config.Cameras {
+		if camera.Name == token {
+			return camera, true
+		}
+	}
+
+	return Camera{}, false
+}
+
+// addr returns the host:port advertised to the client. The Host header is not
+// used, as the client controls it.
+func (s *Service) addr(r *http.Request) string {
+	if s.config.Addr != "" {
+		return s.config.Addr
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return local.String()
+	}
+
+	return net.JoinHostPort("127.0.0.1", "80")
+}
+
+func serviceUrl(addr string, path string) string {
+	return escape(fmt.Sprintf("http://%s%s", addr, path))
+}
+
+func (s *Service) videoSources() string {
+	var b strings.Builder
+	b.WriteString("<trt:GetVideoSourcesResponse>")
+	for _, camera := range s.config.Cameras {
+		fmt.Fprintf(&b, videoSourceTemplate, escape(camera.Name), camera.Width, camera.Height)
+	}
+	b.WriteString("</trt:GetVideoSourcesResponse>")
+
+	return b.String()
+}
+
+// profiles renders every profile, or only the profile with the given token
+func (s *Service) profiles(token string) string {
+	element := "GetProfilesResponse"
+	tag := "trt:Profiles"
+	if token != "" {
+		element = "GetProfileResponse"
+		tag = "trt:Profile"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<trt:%s>", element)
+	for _, camera := range s.config.Cameras {
+		if token != "" && camera.Name != token {
+			continue
+		}
+
+		name := escape(camera.Name)
+		fmt.Fprintf(&b, profileTemplate, tag, name, name, name, name, camera.Width, camera.Height, name, camera.Width, camera.Height, tag)
+	}
+	fmt.Fprintf(&b, "</trt:%s>", element)
+
+	return b.String()
+}
+
+// actionName returns the local name of the first element in the SOAP body
+func actionName(body []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+func writeFault(w http.ResponseWriter, code string, subcode string, reason string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	io.WriteString(w, soapEnvelope(fmt.Sprintf(faultTemplate, code, subcode, escape(reason))))
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package onvif
+
+import (
+	"fmt"
+	"time"
+)
+
+func soapEnvelope(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:tds="http://www.onvif.org/ver10/device/wsdl"` +
+		` xmlns:trt="http://www.onvif.org/ver10/media/wsdl"` +
+		` xmlns:tt="http://www.onvif.org/ver10/schema"` +
+		` xmlns:ter="http://www.onvif.org/ver10/error">` +
+		`<env:Body>` + body + `</env:Body></env:Envelope>`
+}
+
+func systemDateAndTime(now time.Time) string {
+	return fmt.Sprintf(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>`+
+		`<tt:DateTimeType>NTP</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings>`+
+		`<tt:TimeZone><tt:TZ>UTC</tt:TZ></tt:TimeZone>`+
+		`<tt:UTCDateTime><tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`+
+		`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date></tt:UTCDateTime>`+
+		`</tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`,
+		now.Hour(), now.Minute(), now.Second(), now.Year(), int(now.Month()), now.Day())
+}
+
+const deviceInformationTemplate = `<tds:GetDeviceInformationResponse>` +
+	`<tds:Manufacturer>%s</tds:Manufacturer><tds:Model>%s</tds:Model>` +
+	`<tds:FirmwareVersion>1.0</tds:FirmwareVersion><tds:SerialNumber>0</tds:SerialNumber>` +
+	`<tds:HardwareId>blink-middleware</tds:HardwareId></tds:GetDeviceInformationResponse>`
+
+const capabilitiesTemplate = `<tds:GetCapabilitiesResponse><tds:Capabilities>` +
+	`<tt:Device><tt:XAddr>%s</tt:XAddr></tt:Device>` +
+	`<tt:Media><tt:XAddr>%s</tt:XAddr><tt:StreamingCapabilities>` +
+	`<tt:RTPMulticast>false</tt:RTPMulticast><tt:RTP_TCP>true</tt:RTP_TCP><tt:RTP_RTSP_TCP>true</tt:RTP_RTSP_TCP>` +
+	`</tt:StreamingCapabilities></tt:Media></tds:Capabilities></tds:GetCapabilitiesResponse>`
+
+const servicesTemplate = `<tds:GetServicesResponse>` +
+	`<tds:Service><tds:Namespace>http://www.onvif.org/ver10/device/wsdl</tds:Namespace><tds:XAddr>%s</tds:XAddr>` +
+	`<tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service>` +
+	`<tds:Service><tds:Namespace>http://www.onvif.org/ver10/media/wsdl</tds:Namespace><tds:XAddr>%s</tds:XAddr>` +
+	`<tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service>` +
+	`</tds:GetServicesResponse>`
+
+const scopesTemplate = `<tds:GetScopesResponse>` +
+	`<tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>onvif://www.onvif.org/Profile/Streaming</tt:ScopeItem></tds:Scopes>` +
+	`<tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>onvif://www.onvif.org/hardware/%s</tt:ScopeItem></tds:Scopes>` +
+	`</tds:GetScopesResponse>`
+
+// videoSourceTemplate arguments: token, width, height
+const videoSourceTemplate = `<trt:VideoSources token="%s"><tt:Framerate>15</tt:Framerate>` +
+	`<tt:Resolution><tt:Width>%d</tt:Width><tt:Height>%d</tt:Height></tt:Resolution></trt:VideoSources>`
+
+// profileTemplate arguments: tag, token, name, source token, source token, width, height, encoder token, width, height, tag
+const profileTemplate = `<%s token="%s" fixed="true"><tt:Name>%s</tt:Name>` +
+	`<tt:VideoSourceConfiguration token="%s"><tt:Name>source</tt:Name><tt:UseCount>1</tt:UseCount>` +
+	`<tt:SourceToken>%s</tt:SourceToken><tt:Bounds x="0" y="0" width="%d" height="%d"/></tt:VideoSourceConfiguration>` +
+	`<tt:VideoEncoderConfiguration token="%s"><tt:Name>H264</tt:Name><tt:UseCount>1</tt:UseCount>` +
+	`<tt:Encoding>H264</tt:Encoding><tt:Resolution><tt:Width>%d</tt:Width><tt:Height>%d</tt:Height></tt:Resolution>` +
+	`<tt:Quality>5</tt:Quality><tt:RateControl><tt:FrameRateLimit>15</tt:FrameRateLimit>` +
+	`<tt:EncodingInterval>1</tt:EncodingInterval><tt:BitrateLimit>2048</tt:BitrateLimit></tt:RateControl>` +
+	`<tt:H264><tt:GovLength>30</tt:GovLength><tt:H264Profile>Main</tt:H264Profile></tt:H264>` +
+	`<tt:SessionTimeout>PT60S</tt:SessionTimeout></tt:VideoEncoderConfiguration></%s>`
+
+const streamUriTemplate = `<trt:GetStreamUriResponse><trt:MediaUri><tt:Uri>%s</tt:Uri>` +
+	`<tt:InvalidAfterConnect>false</tt:InvalidAfterConnect><tt:InvalidAfterReboot>false</tt:InvalidAfterReboot>` +
+	`<tt:Timeout>PT0S</tt:Timeout></trt:MediaUri></trt:GetStreamUriResponse>`
+
+// faultTemplate arguments: code, subcode, reason
+const faultTemplate = `<env:Fault><env:Code><env:Value>%s</env:Value><env:Subcode><env:Value>%s</env:Value></env:Subcode></env:Code>` +
+	`<env:Reason><env:Text xml:lang="en">%s</env:Text></env:Reason></env:Fault>`
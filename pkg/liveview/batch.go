@@ -0,0 +1,107 @@
+package liveview
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DEFAULT_WRITE_BATCH_INTERVAL is how long data is held for ClientConfig.WriteBatchSize
+// before it is written anyway
+const DEFAULT_WRITE_BATCH_INTERVAL = 20 * time.Millisecond
+
+// batcher coalesces the small reads of the livestream connection into larger writes
+// to the writer, cutting the syscalls of the outputs. Data is written once size bytes
+// were collected or the oldest of them was held for the interval, whichever comes
+// first.
+type batcher struct {
+	writer   io.Writer
+	size     int
+	interval time.Duration
+	// Guards the fields below and serializes the writes to the writer
+	mu sync.Mutex
+	// The data collected since the last write
+	pending []byte
+	// Writes the pending data once the interval has passed, or nil while none is
+	// pending
+	timer *time.Timer
+	// The error of the writer, returned by later writes
+	err error
+}
+
+func newBatcher(writer io.Writer, size int, interval time.Duration) *batcher {
+	if interval <= 0 {
+		interval = DEFAULT_WRITE_BATCH_INTERVAL
+	}
+
+	return &batcher{
+		writer:   writer,
+		size:     size,
+		interval: interval,
+		pending:  make([]byte, 0, size),
+	}
+}
+
+func (b *batcher) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	b.pending = append(b.pending, data...)
+	if len(b.pending) >= b.size {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.expire)
+	}
+
+	return len(data), nil
+}
+
+// Discontinuity writes the pending data and passes the discontinuity on to the
+// writer
+func (b *batcher) Discontinuity() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flush()
+	if d, ok := b.writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// Close writes the pending data, returning the error of the writer
+func (b *batcher) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.flush()
+}
+
+// expire writes the pending data once it was held for the interval
+func (b *batcher) expire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.flush()
+}
+
+// flush writes the pending data to the writer. b.mu must be held.
+func (b *batcher) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if b.err != nil || len(b.pending) == 0 {
+		return b.err
+	}
+
+	_, b.err = b.writer.Write(b.pending)
+	b.pending = b.pending[:0]
+
+	return b.err
+}
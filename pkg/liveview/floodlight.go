@@ -0,0 +1,70 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+)
+
+// Accepted range of the floodlight brightness, in percent
+const (
+	MIN_FLOODLIGHT_BRIGHTNESS = blinkapi.MIN_FLOODLIGHT_BRIGHTNESS
+	MAX_FLOODLIGHT_BRIGHTNESS = blinkapi.MAX_FLOODLIGHT_BRIGHTNESS
+)
+
+// ErrNoFloodlight is returned for cameras without a floodlight, and when the
+// BlinkService of the client does not implement blinkapi.FloodlightService
+var ErrNoFloodlight = blinkapi.ErrNoFloodlight
+
+// SetFloodlight switches the light of a Wired Floodlight Camera on or off
+//
+// ctx: the context of the requests
+//
+// on: whether the light is switched on
+//
+// Example: SetFloodlight(ctx, true) = nil
+func (c *Client) SetFloodlight(ctx context.Context, on bool) error {
+	floodlight, credentials, accessoryId, err := c.floodlight(ctx)
+	if err != nil {
+		return err
+	}
+
+	return floodlight.SetFloodlightContext(ctx, credentials, accessoryId, on)
+}
+
+// SetFloodlightBrightness sets the brightness of the light of a Wired Floodlight
+// Camera
+//
+// ctx: the context of the requests
+//
+// brightness: the brightness in percent, from 1 to 100
+//
+// Example: SetFloodlightBrightness(ctx, 80) = nil
+func (c *Client) SetFloodlightBrightness(ctx context.Context, brightness int) error {
+	floodlight, credentials, accessoryId, err := c.floodlight(ctx)
+	if err != nil {
+		return err
+	}
+
+	return floodlight.SetFloodlightBrightnessContext(ctx, credentials, accessoryId, brightness)
+}
+
+// floodlight returns the floodlight API of the client, the credentials of the
+// camera, and the ID of its floodlight, looked up on the homescreen
+func (c *Client) floodlight(ctx context.Context) (blinkapi.FloodlightService, blinkapi.ClientCredentials, int, error) {
+	floodlight, ok := c.api.(blinkapi.FloodlightService)
+	if !ok {
+		return nil, blinkapi.ClientCredentials{}, 0, ErrNoFloodlight
+	}
+
+	credentials := c.credentialsSnapshot()
+	homescreen, err := c.api.GetHomescreenContext(ctx, credentials)
+	if err != nil {
+		return nil, blinkapi.ClientCredentials{}, 0, err
+	}
+	accessory, err := homescreen.Floodlight(credentials.CameraId)
+	if err != nil {
+		return nil, blinkapi.ClientCredentials{}, 0, err
+	}
+
+	return floodlight, credentials, accessory.Id, nil
+}
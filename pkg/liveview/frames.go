@@ -0,0 +1,160 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/internal/transport"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"io"
+	"time"
+)
+
+// FRAME_QUEUE_SIZE is the number of video frames buffered for OnVideoFrame before
+// frames are dropped
+const FRAME_QUEUE_SIZE = 8
+
+type videoFrame struct {
+	data []byte
+	pts  time.Duration
+}
+
+// frameTap forwards the stream to the writer and delivers video access units to the
+// OnVideoFrame callback from a separate goroutine, so a slow callback drops frames
+// instead of stalling the livestream.
+type frameTap struct {
+	// The writer receiving the stream
+	writer io.Writer
+	// Reassembles video access units from the stream
+	demuxer *mpegts.Demuxer
+	// Whether only keyframes are delivered
+	keyframesOnly bool
+	// The latest H.264 parameter sets, prepended to keyframes that lack them
+	sps []byte
+	pps []byte
+	// Frames waiting for the callback
+	frames chan videoFrame
+	// Whether frames are currently being dropped
+	dropping bool
+	// Callback for logging messages
+	onLog func(string)
+}
+
+func newFrameTap(writer io.Writer, config ClientConfig) *frameTap {
+	t := &frameTap{
+		writer:        writer,
+		keyframesOnly: config.KeyframesOnly,
+		frames:        make(chan videoFrame, FRAME_QUEUE_SIZE),
+		onLog:         config.OnLog,
+	}
+	t.demuxer = mpegts.NewDemuxer(t.handleAccessUnit)
+
+	go func() {
+		for frame := range t.frames {
+			config.OnVideoFrame(frame.data, frame.pts)
+		}
+	}()
+
+	return t
+}
+
+// Write extracts video frames from the stream and forwards it to the writer
+func (t *frameTap) Write(p []byte) (int, error) {
+	t.demuxer.Write(p)
+
+	return t.writer.Write(p)
+}
+
+// Discontinuity discards partial frames after the livestream was re-established, and
+// forwards the signal to the underlying writer if supported
+func (t *frameTap) Discontinuity() {
+	t.demuxer.Reset()
+
+	if d, ok := t.writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// Close delivers the access unit still buffered by the demuxer and stops delivering
+// frames once the stream has ended
+func (t *frameTap) Close() {
+	t.demuxer.Flush()
+	close(t.frames)
+}
+
+func (t *frameTap) handleAccessUnit(au mpegts.AccessUnit) {
+	if !au.IsVideo() {
+		return
+	}
+
+	keyframe := au.IsKeyframe()
+	if au.StreamType == mpegts.STREAM_TYPE_H264 {
+		au.Data = t.withParameterSets(au.Data, keyframe)
+	}
+	if t.keyframesOnly && !keyframe {
+		return
+	}
+
+	frame := videoFrame{
+		data: au.Data,
+		pts:  mpegts.Duration(au.PTS),
+	}
+
+	select {
+	case t.frames <- frame:
+		t.dropping = false
+	default:
+		if !t.dropping {
+			t.onLog("OnVideoFrame is falling behind, dropping video frames")
+			t.dropping = true
+		}
+	}
+}
+
+// withParameterSets records the SPS and PPS carried by the access unit, and prepends
+// the latest ones to keyframes that lack them so each keyframe decodes on its own
+func (t *frameTap) withParameterSets(data []byte, keyframe bool) []byte {
+	hasSPS, hasPPS := false, false
+	for _, nalu := range mpegts.SplitAnnexB(data) {
+		switch mpegts.H264NALType(nalu) {
+		case mpegts.H264_NAL_SPS:
+			t.sps, hasSPS = nalu, true
+		case mpegts.H264_NAL_PPS:
+			t.pps, hasPPS = nalu, true
+		}
+	}
+
+	if !keyframe || (hasSPS && hasPPS) || t.sps == nil || t.pps == nil {
+		return data
+	}
+
+	startCode := []byte{0, 0, 0, 1}
+	prefixed := make([]byte, 0, len(t.sps)+len(t.pps)+8+len(data))
+	prefixed = append(append(prefixed, startCode...), t.sps...)
+	prefixed = append(append(prefixed, startCode...), t.pps...)
+
+	return append(prefixed, data...)
+}
+
+// frameCounter forwards the stream to the writer and counts its video access units
+// for the frame rate of OnStats
+type frameCounter struct {
+	// The writer receiving the stream
+	writer io.Writer
+	// Reassembles access units from the stream
+	demuxer *mpegts.Demuxer
+}
+
+func newFrameCounter(writer io.Writer, meter *transport.RateMeter) *frameCounter {
+	return &frameCounter{
+		writer: writer,
+		demuxer: mpegts.NewDemuxer(func(au mpegts.AccessUnit) {
+			if au.IsVideo() {
+				meter.Add(1)
+			}
+		}),
+	}
+}
+
+func (f *frameCounter) Write(p []byte) (int, error) {
+	f.demuxer.Write(p)
+
+	return f.writer.Write(p)
+}
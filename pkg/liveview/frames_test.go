@@ -0,0 +1,107 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_PMT_PID   = 0x1000
+	TEST_VIDEO_PID = 0x100
+)
+
+// TestFrameTapCloseDeliversLastFrame checks that the frame still buffered by the
+// demuxer when the stream ends, which no following PES closes, reaches
+// OnVideoFrame on Close.
+func TestFrameTapCloseDeliversLastFrame(t *testing.T) {
+	received := make(chan videoFrame, FRAME_QUEUE_SIZE)
+	tap := newFrameTap(io.Discard, ClientConfig{
+		OnVideoFrame: func(frame []byte, pts time.Duration) {
+			received <- videoFrame{data: frame, pts: pts}
+		},
+		OnLog: func(string) {},
+	})
+
+	counters := map[uint16]byte{}
+	var stream []byte
+	stream = append(stream, testPacket(mpegts.PID_PAT, true, counters, []byte{
+		0x00, 0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0x00, 0x01, 0xe0 | TEST_PMT_PID>>8, TEST_PMT_PID & 0xff,
+		0, 0, 0, 0,
+	})...)
+	stream = append(stream, testPacket(TEST_PMT_PID, true, counters, []byte{
+		0x00, 0x02, 0xb0, 18, 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0xe0 | TEST_VIDEO_PID>>8, TEST_VIDEO_PID & 0xff, 0xf0, 0x00,
+		mpegts.STREAM_TYPE_H264, 0xe0 | TEST_VIDEO_PID>>8, TEST_VIDEO_PID & 0xff, 0xf0, 0x00,
+		0, 0, 0, 0,
+	})...)
+	keyframe := []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84}
+	frame := []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9a, 0x02}
+	stream = append(stream, testPacket(TEST_VIDEO_PID, true, counters, testPES(90000, keyframe))...)
+	stream = append(stream, testPacket(TEST_VIDEO_PID, true, counters, testPES(93000, frame))...)
+
+	// The keyframe ends where the second PES starts, which nothing ends
+	tap.Write(stream)
+	expectFrame(t, received, keyframe, time.Second)
+	select {
+	case f := <-received:
+		t.Fatalf("received the buffered frame %x before Close", f.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tap.Close()
+	expectFrame(t, received, frame, time.Second+time.Second/30)
+}
+
+func expectFrame(t *testing.T, received <-chan videoFrame, data []byte, pts time.Duration) {
+	t.Helper()
+
+	select {
+	case f := <-received:
+		if !bytes.Equal(f.data, data) || f.pts != pts {
+			t.Errorf("received frame %x at %v, want %x at %v", f.data, f.pts, data, pts)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("frame %x was not delivered", data)
+	}
+}
+
+// testPES returns a video PES packet carrying the data with the presentation
+// timestamp
+func testPES(pts int64, data []byte) []byte {
+	pes := []byte{
+		0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05,
+		0x21 | byte(pts>>29)&0x0e, byte(pts >> 22), byte(pts>>14) | 1, byte(pts >> 7), byte(pts<<1) | 1,
+	}
+
+	return append(pes, data...)
+}
+
+// testPacket returns a transport stream packet of a PID carrying the payload,
+// stuffing the adaptation field so the payload fills the rest of the packet
+func testPacket(pid uint16, start bool, counters map[uint16]byte, payload []byte) []byte {
+	pkt := make([]byte, mpegts.PACKET_SIZE)
+	pkt[0] = mpegts.SYNC_BYTE
+	pkt[1] = byte(pid>>8) & 0x1f
+	if start {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	pkt[3] = 0x30 | counters[pid]&0x0f
+	counters[pid]++
+
+	stuffing := mpegts.PACKET_SIZE - 5 - len(payload)
+	pkt[4] = byte(stuffing)
+	if stuffing > 0 {
+		pkt[5] = 0x00
+		for i := 6; i < 5+stuffing; i++ {
+			pkt[i] = 0xff
+		}
+	}
+	copy(pkt[5+stuffing:], payload)
+
+	return pkt
+}
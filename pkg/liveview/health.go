@@ -0,0 +1,94 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"context"
+	"fmt"
+	"time"
+)
+
+// CameraHealth is the battery, signal, and temperature state of a camera. Long
+// streaming sessions drain batteries quickly, so it is worth watching while streaming.
+type CameraHealth struct {
+	// Battery state ("ok" or "low"), empty for wired cameras
+	Battery string `json:"battery"`
+	// Battery level in bars from 0 to 5
+	BatteryBars int `json:"battery_bars"`
+	// Battery voltage in volts, if the camera reports it
+	BatteryVoltage float64 `json:"battery_voltage,omitempty"`
+	// Wi-Fi signal strength in bars from 0 to 5
+	WifiBars int `json:"wifi_bars"`
+	// Wi-Fi signal strength in dBm, if the camera reports it
+	WifiStrength int `json:"wifi_dbm,omitempty"`
+	// Strength of the signal to the sync module in bars from 0 to 5
+	LfrBars int `json:"lfr_bars"`
+	// Strength of the signal to the sync module in dBm, if the camera reports it
+	LfrStrength int `json:"lfr_dbm,omitempty"`
+	// Temperature in degrees Fahrenheit
+	Temperature int `json:"temperature"`
+	// When the health was read
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Health reads the battery, signal, and temperature state of the camera from the
+// homescreen, adding the detailed camera status for devices of type "camera". The
+// values are also reported to the metrics backend.
+//
+// Example: Health() = &CameraHealth{Battery: "ok", WifiBars: 4, ...}, nil
+func (c *Client) Health() (*CameraHealth, error) {
+	deviceType, err := c.DeviceType()
+	if err != nil {
+		return nil, err
+	}
+
+	c.state.mu.Lock()
+	credentials := c.credentials
+	c.state.mu.Unlock()
+
+	homescreen, err := c.api.GetHomescreenContext(context.Background(), credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error reading camera health: %w", err)
+	}
+	device, err := homescreen.Device(credentials.CameraId, credentials.NetworkId)
+	if err != nil {
+		return nil, fmt.Errorf("error reading camera health: %w", err)
+	}
+
+	health := &CameraHealth{
+		Battery:     device.Battery,
+		BatteryBars: device.Signals.Battery,
+		WifiBars:    device.Signals.Wifi,
+		LfrBars:     device.Signals.Lfr,
+		Temperature: device.Signals.Temp,
+		UpdatedAt:   time.Now(),
+	}
+
+	// The detailed status is a bonus, the homescreen values stand on their own
+	if deviceType == "camera" {
+		status, err := c.api.GetCameraStatusContext(context.Background(), credentials)
+		if err != nil {
+			c.config.OnLog(fmt.Sprintf("Cannot read the detailed status of camera %d: %v", credentials.CameraId, err))
+		} else {
+			health.BatteryVoltage = float64(status.BatteryVoltage) / 100
+			health.WifiStrength = status.WifiStrength
+			health.LfrStrength = status.LfrStrength
+			health.Temperature = status.Temperature
+		}
+	}
+
+	if health.Battery != "" {
+		low := 0.0
+		if health.Battery == "low" {
+			low = 1
+		}
+		c.config.Metrics.Gauge(metrics.CAMERA_BATTERY_LOW, low, nil)
+	}
+	if health.BatteryVoltage > 0 {
+		c.config.Metrics.Gauge(metrics.CAMERA_BATTERY_VOLTS, health.BatteryVoltage, nil)
+	}
+	c.config.Metrics.Gauge(metrics.CAMERA_SIGNAL_BARS, float64(health.WifiBars), metrics.Labels{"signal": "wifi"})
+	c.config.Metrics.Gauge(metrics.CAMERA_SIGNAL_BARS, float64(health.LfrBars), metrics.Labels{"signal": "lfr"})
+	c.config.Metrics.Gauge(metrics.CAMERA_TEMPERATURE_FAHRENHEIT, float64(health.Temperature), nil)
+
+	return health, nil
+}
@@ -1,26 +1,57 @@
 package liveview
 
 import (
-	blinkAdapter "amattu2/blink-middleware/internal/adapters/blink"
-	blinkProtocol "amattu2/blink-middleware/internal/protocol/blink"
 	"amattu2/blink-middleware/internal/transport"
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/blinkproto"
+	"amattu2/blink-middleware/pkg/budget"
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/mpegts"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
 	// Credentials for connecting to the client service
-	credentials blinkAdapter.ClientCredentials
+	credentials blinkapi.ClientCredentials
+	// The Blink API the client sends requests through
+	api BlinkService
 	// Configuration options for the client
 	config ClientConfig
 	// Internal state of the client
 	state clientState
+	// Creates the capture writer on the first connection
+	captureOnce sync.Once
+	// The capture writer, or nil if capturing is disabled or failed
+	capture *blinkproto.CaptureWriter
 }
 
+// API endpoints whose version can be overridden through ClientConfig.ApiVersions
+const (
+	ENDPOINT_CAMERA_LIVEVIEW   = blinkapi.ENDPOINT_CAMERA_LIVEVIEW
+	ENDPOINT_OWL_LIVEVIEW      = blinkapi.ENDPOINT_OWL_LIVEVIEW
+	ENDPOINT_DOORBELL_LIVEVIEW = blinkapi.ENDPOINT_DOORBELL_LIVEVIEW
+)
+
+// Stream qualities for ClientConfig.Quality
+const (
+	QUALITY_AUTO = blinkapi.QUALITY_AUTO
+	QUALITY_LOW  = blinkapi.QUALITY_LOW
+	QUALITY_HIGH = blinkapi.QUALITY_HIGH
+)
+
 type ClientConfig struct {
 	// Initial connection read timeout duration
 	ConnectTimeout time.Duration
@@ -28,123 +59,1159 @@ type ClientConfig struct {
 	OnError func(error)
 	// Callback for logging messages
 	OnLog func(string)
+	// Locale sent with every API request (e.g. "en_US")
+	Locale string
+	// Optional ISO 3166 country code sent with every API request (e.g. "US")
+	Country string
+	// Optional IANA time zone sent with every API request (e.g. "America/New_York")
+	TimeZone string
+	// Optional base URL of the Blink API with a %s placeholder for the region, or a
+	// full URL used for every region (e.g. a debugging proxy). Defaults to
+	// DEFAULT_BASE_URL; SetBaseURL changes it on the fly
+	BaseURL string
+	// Optional identification of the deployment sent with every API request (app
+	// build, device name, unique ID), so Blink recognizes the client the token was
+	// issued to
+	Identity ClientIdentity
+	// Metrics backend for client and transport measurements (defaults to metrics.Noop)
+	Metrics metrics.Metrics
+	// The elementary streams written to the writer: mpegts.STREAMS_BOTH (default),
+	// mpegts.STREAMS_VIDEO, or mpegts.STREAMS_AUDIO
+	Streams string
+	// Optional API versions to try per endpoint (e.g. ENDPOINT_CAMERA_LIVEVIEW: {6, 5}).
+	// Endpoints without an override use the built-in versions.
+	ApiVersions map[string][]int
+	// Optional callback invoked with the reason when Blink ends the livestream
+	// server-side by completing its command (e.g. the camera stopped). The stream is
+	// torn down right away instead of waiting for a read timeout.
+	OnCommandComplete func(reason string)
+	// Optional callback receiving each video access unit (Annex B H.264 or H.265) with
+	// its presentation timestamp, e.g. to feed an object detector. It runs on its own
+	// goroutine; frames are dropped while it falls behind.
+	OnVideoFrame func(frame []byte, pts time.Duration)
+	// Whether OnVideoFrame receives only keyframes. H.264 keyframes are prefixed with
+	// the latest SPS and PPS so each one can be decoded on its own.
+	KeyframesOnly bool
+	// The requested stream quality: QUALITY_AUTO (default), QUALITY_LOW for
+	// constrained networks, or QUALITY_HIGH. Cameras without a choice ignore it
+	Quality string
+	// Optional maximum length of a livestream session, e.g. the limit enforced by Blink
+	MaxSessionDuration time.Duration
+	// What happens when MaxSessionDuration is reached (defaults to StopAtLimit)
+	SessionLimitPolicy SessionLimitPolicy
+	// The size of the buffer the livestream is read into (defaults to 32 KiB).
+	// Buffers of the default size are shared between clients
+	ReadBufferSize int
+	// Optional callback receiving the control frames the server interleaves with
+	// the media, which are not written to the writer
+	OnControlMessage func(ControlMessage)
+	// Whether the writer receives the undecoded stream, including the framing and
+	// control frames, instead of clean MPEG-TS
+	RawStream bool
+	// Optional writer receiving a capture of the raw bytes of every stream
+	// connection, with timestamps and direction. See cmd/capture for replaying it
+	Capture io.Writer
+	// Optional HTTP client for Blink API requests, e.g. with a custom timeout or
+	// proxy. The livestream connection itself does not use it
+	HTTPClient *http.Client
+	// Optional middleware wrapping every Blink API request, outermost first (e.g.
+	// LogRequests, RetryRequests, RequestHeaders)
+	Middleware []APIMiddleware
+	// Optional implementation of the Blink API calls, e.g. a mock in tests or a
+	// backend replaying recorded responses. Defaults to the REST API, configured
+	// with HTTPClient and Middleware, which are ignored when it is set
+	Service BlinkService
+	// The interval between keep-alive pings on the livestream connection (defaults
+	// to DEFAULT_PING_INTERVAL). It is clamped to MIN_PING_INTERVAL and
+	// MAX_PING_INTERVAL; the connection is dropped after 2 seconds without data, so
+	// intervals near the maximum rely on the stream itself keeping it busy
+	PingInterval time.Duration
+	// Optional keep-alive strategy returning the delay until the next ping after
+	// each ping, e.g. AdaptiveKeepAlive. Non-positive delays fall back to
+	// PingInterval. Intended for experimenting with the protocol
+	KeepAlive func(KeepAliveState) time.Duration
+	// Optional daily streaming budget of the camera. With budget.POLICY_REFUSE,
+	// connecting fails with budget.ErrExhausted once it is used up and a running
+	// livestream is stopped when it runs out
+	Budget *budget.Budget
+	// Optional callback receiving the bitrate, packet rate, and frame rate of the
+	// livestream every StatsInterval while it is connected. The frame rate is not
+	// measured for a RawStream
+	OnStats func(Stats)
+	// How often OnStats is called (defaults to 5 seconds)
+	StatsInterval time.Duration
+	// Optional callback called with the ID of each liveview command once Blink
+	// created it (running true), including renewals and replacements, and once the
+	// client stopped it (running false), e.g. to journal the commands so they can be
+	// stopped after a crash
+	OnCommand func(commandId int, running bool)
+	// Optional delay of the stream before the writer, e.g. 2 seconds, smoothing the
+	// start of playback. The first data is held for the delay, then released at the
+	// pace it arrived; the rest is written right away when the stream ends or
+	// Disconnect is called. At most MAX_STARTUP_BUFFER
+	StartupBuffer time.Duration
+	// Optional number of bytes the small reads of the livestream connection are
+	// coalesced into before each write to the writer, e.g. 64 KiB, cutting the
+	// syscalls of the outputs when many cameras stream. Zero writes every read right
+	// away, which keeps the latency lowest for live viewing
+	WriteBatchSize int
+	// Longest time data is held for WriteBatchSize before it is written anyway
+	// (defaults to DEFAULT_WRITE_BATCH_INTERVAL)
+	WriteBatchInterval time.Duration
+	// Optional number of bytes sent and received at the start of each stream
+	// connection that are logged through OnLog as hex dumps, e.g. 512 for a protocol
+	// bug report. See TraceRequests for the API requests
+	TraceStream int
+	// Maximum time to resolve the livestream server and connect to it, including the
+	// TLS handshake (defaults to 10 seconds)
+	DialTimeout time.Duration
+	// Optional local IP address the livestream connection is made from, e.g. on a
+	// multi-homed host. Only servers of its address family are dialed
+	SourceAddress string
+	// Optional network interface the livestream connection is made from, e.g.
+	// "eth1", using its first IPv4 and IPv6 addresses
+	Interface string
+	// Idle time of the livestream connection before the first TCP keep-alive probe.
+	// Zero uses the default of 15 seconds; negative disables the probes. Shorter
+	// times detect a dead connection sooner, e.g. on a flaky Wi-Fi backhaul
+	TCPKeepAliveIdle time.Duration
+	// Time between TCP keep-alive probes (defaults to 15 seconds)
+	TCPKeepAliveInterval time.Duration
+	// Unanswered TCP keep-alive probes after which the livestream connection is
+	// dropped (defaults to 9)
+	TCPKeepAliveCount int
+	// Whether small writes on the livestream connection are coalesced (Nagle's
+	// algorithm). By default TCP_NODELAY is set, so pings leave right away
+	TCPNagle bool
+	// Optional TLS configuration of the livestream connection, e.g. requiring
+	// TLS 1.3 (MinVersion), restricting CipherSuites, presenting a client
+	// certificate, overriding the SNI (ServerName), or capturing and pinning the
+	// server certificate (VerifyPeerCertificate, see PinCertificates). The server
+	// certificate is only verified against the RootCAs when they are set
+	TLSConfig *tls.Config
+	// Optional callback receiving the state of each TLS handshake of the livestream
+	// connection, e.g. to log the cipher suite and the server certificates
+	OnTLSHandshake func(tls.ConnectionState)
+}
+
+// DEFAULT_BASE_URL is the URL of the Blink API, with a %s placeholder for the region
+const DEFAULT_BASE_URL = blinkapi.DEFAULT_BASE_URL
+
+// ClientIdentity identifies the deployment to the Blink API
+type ClientIdentity = blinkapi.ClientIdentity
+
+// NewUniqueId generates a random identifier for ClientIdentity.UniqueId
+var NewUniqueId = blinkapi.NewUniqueId
+
+// APIMiddleware wraps the transport of Blink API requests
+type APIMiddleware = blinkapi.Middleware
+
+// BlinkService is the part of the Blink API a Client calls, for
+// ClientConfig.Service
+type BlinkService = blinkapi.BlinkService
+
+// Built-in API middleware for ClientConfig.Middleware
+var (
+	LogRequests     = blinkapi.LogRequests
+	RetryRequests   = blinkapi.RetryRequests
+	RequestHeaders  = blinkapi.RequestHeaders
+	MeasureRequests = blinkapi.MeasureRequests
+)
+
+// KeepAliveState describes the livestream connection when the keep-alive strategy
+// is consulted
+type KeepAliveState = transport.KeepAliveState
+
+// Stats describes the quality of the livestream, averaged over the last 10 seconds
+type Stats = transport.Stats
+
+// Keep-alive ping intervals of the livestream connection
+const (
+	DEFAULT_PING_INTERVAL = 1 * time.Second
+	MIN_PING_INTERVAL     = 250 * time.Millisecond
+	MAX_PING_INTERVAL     = 5 * time.Second
+)
+
+// AdaptiveKeepAlive returns a keep-alive strategy that pings every maxInterval while
+// stream data is flowing, and halves the interval with every ping sent once the
+// stream goes quiet, down to minInterval, so a stalled connection is kept open more
+// aggressively.
+//
+// minInterval: the interval used once the stream has been quiet for a while
+//
+// maxInterval: the interval used while stream data is flowing
+//
+// Example: AdaptiveKeepAlive(250*time.Millisecond, 2*time.Second) = func(KeepAliveState) time.Duration
+func AdaptiveKeepAlive(minInterval time.Duration, maxInterval time.Duration) func(KeepAliveState) time.Duration {
+	return func(state KeepAliveState) time.Duration {
+		if state.SinceLastRead < maxInterval {
+			return clampPingInterval(maxInterval)
+		}
+
+		interval := maxInterval
+		for i := 1; i < state.PingsSinceRead && interval > minInterval; i++ {
+			interval /= 2
+		}
+
+		return clampPingInterval(max(interval, minInterval))
+	}
+}
+
+// clampPingInterval limits a ping interval to the supported range
+func clampPingInterval(interval time.Duration) time.Duration {
+	return min(max(interval, MIN_PING_INTERVAL), MAX_PING_INTERVAL)
+}
+
+// ControlMessage is a non-media frame of the livestream
+type ControlMessage struct {
+	// The message type (e.g. 0x12 for latency statistics)
+	Type byte
+	// The sequence number assigned by the server
+	Sequence uint32
+	// The frame payload
+	Payload []byte
+}
+
+// State is the lifecycle state of a Client
+type State string
+
+// Client states. A client moves from STATE_IDLE to STATE_CONNECTING when Connect is
+// called, to STATE_STREAMING once the livestream is established, and through
+// STATE_STOPPING back to STATE_IDLE when it is disconnected or the stream ends.
+const (
+	STATE_IDLE       State = "idle"
+	STATE_CONNECTING State = "connecting"
+	STATE_STREAMING  State = "streaming"
+	STATE_STOPPING   State = "stopping"
+)
+
+// STALE_COMMAND_RETRIES is the number of times a new liveview command is requested
+// after Blink ends the stream because its command went stale or expired
+const STALE_COMMAND_RETRIES = 2
+
+// CommandState classifies why Blink ended a liveview command
+type CommandState = blinkapi.CommandState
+
+// Command states reported by CommandError.State
+const (
+	COMMAND_STATE_COMPLETED      = blinkapi.COMMAND_STATE_COMPLETED
+	COMMAND_STATE_STOPPED        = blinkapi.COMMAND_STATE_STOPPED
+	COMMAND_STATE_STALE          = blinkapi.COMMAND_STATE_STALE
+	COMMAND_STATE_EXPIRED        = blinkapi.COMMAND_STATE_EXPIRED
+	COMMAND_STATE_CAMERA_OFFLINE = blinkapi.COMMAND_STATE_CAMERA_OFFLINE
+)
+
+// CommandCode is a code Blink reports for a command, e.g. CODE_COMMAND_STALE
+type CommandCode = blinkapi.CommandCode
+
+// Command codes reported by CommandError.StatusCode and CommandError.Code
+const (
+	CODE_UNAUTHORIZED      = blinkapi.CODE_UNAUTHORIZED
+	CODE_SYSTEM_BUSY       = blinkapi.CODE_SYSTEM_BUSY
+	CODE_COMMAND_DONE      = blinkapi.CODE_COMMAND_DONE
+	CODE_COMMAND_STALE     = blinkapi.CODE_COMMAND_STALE
+	CODE_COMMAND_TIMED_OUT = blinkapi.CODE_COMMAND_TIMED_OUT
+	CODE_CAMERA_OFFLINE    = blinkapi.CODE_CAMERA_OFFLINE
+)
+
+// STOP_COMMAND_TIMEOUT bounds the request marking a liveview command as done. It is
+// sent after the stream context is cancelled, so it gets a context of its own
+const STOP_COMMAND_TIMEOUT = 10 * time.Second
+
+// CommandError is the terminal error of a stream that Blink ended by completing its
+// liveview command. It carries the status reported by Blink so callers can decide
+// whether to reconnect.
+type CommandError struct {
+	// Why Blink ended the command (e.g. COMMAND_STATE_STOPPED when another client
+	// stopped the liveview)
+	State CommandState
+	// The command status code reported by Blink (e.g. CODE_COMMAND_STALE)
+	StatusCode CommandCode
+	// The API code reported by Blink
+	Code CommandCode
+	// The message reported by Blink, if any
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("command %s with status %s: %s", e.State, e.StatusCode, e.Message)
+	}
+
+	return fmt.Sprintf("command %s with status %s", e.State, e.StatusCode)
+}
+
+// Stale returns whether Blink ended the command as stale, which a new liveview
+// command usually resolves
+func (e *CommandError) Stale() bool {
+	return e.State == COMMAND_STATE_STALE
+}
+
+// Retryable returns whether a new liveview command is likely to succeed, i.e. the
+// command went stale or expired rather than being stopped elsewhere or failing
+// because the camera is offline
+func (e *CommandError) Retryable() bool {
+	return e.State.Retryable()
 }
 
 type clientState struct {
-	// Whether the client is currently connected
-	connected bool
-	// The Blink command ID for the live view request
-	lvCommandId int
-	// Context for managing the stream lifecycle
-	streamContext context.Context
-	// Cancel function for the stream context
-	streamCancel context.CancelFunc
+	// Guards the fields below and the credentials, whose device type, region, and
+	// token may change
+	mu sync.Mutex
+	// The current lifecycle state
+	state State
+	// The current livestream session, or nil when idle
+	session *session
+	// When the current session was established
+	connectedAt time.Time
+}
+
+// DefaultClientConfig returns the configuration used by NewClient.
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		ConnectTimeout: 15 * time.Second,
+		OnError: func(err error) {
+			log.Println(err)
+		},
+		OnLog: func(msg string) {
+			log.Println(msg)
+		},
+		Locale:  blinkapi.DEFAULT_LOCALE,
+		Metrics: metrics.Noop,
+		Streams: mpegts.STREAMS_BOTH,
+		Quality: QUALITY_AUTO,
+
+		SessionLimitPolicy: StopAtLimit,
+		PingInterval:       DEFAULT_PING_INTERVAL,
+	}
 }
 
 // NewClient initializes a new Client instance with the provided details.
 func NewClient(region string, apiToken string, deviceType string, accountId int, networkId int, cameraId int) *Client {
+	return NewClientWithConfig(region, apiToken, deviceType, accountId, networkId, cameraId, DefaultClientConfig())
+}
+
+// NewClientWithConfig initializes a new Client instance with the provided details and
+// configuration. Unset configuration fields fall back to DefaultClientConfig.
+func NewClientWithConfig(region string, apiToken string, deviceType string, accountId int, networkId int, cameraId int, config ClientConfig) *Client {
+	defaults := DefaultClientConfig()
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = defaults.ConnectTimeout
+	}
+	if config.OnError == nil {
+		config.OnError = defaults.OnError
+	}
+	if config.OnLog == nil {
+		config.OnLog = defaults.OnLog
+	}
+	if config.Locale == "" {
+		config.Locale = defaults.Locale
+	}
+	if config.Metrics == nil {
+		config.Metrics = defaults.Metrics
+	}
+	if config.Streams == "" {
+		config.Streams = defaults.Streams
+	}
+	if config.Quality == "" {
+		config.Quality = defaults.Quality
+	}
+	if config.SessionLimitPolicy == "" {
+		config.SessionLimitPolicy = defaults.SessionLimitPolicy
+	}
+	if config.PingInterval <= 0 {
+		config.PingInterval = defaults.PingInterval
+	}
+	config.PingInterval = clampPingInterval(config.PingInterval)
+	config.Metrics = metrics.WithLabels(config.Metrics, metrics.Labels{"camera": strconv.Itoa(cameraId)})
+	service := config.Service
+	if service == nil {
+		service = blinkapi.NewBlinkAPI(blinkapi.APIConfig{
+			HTTPClient: config.HTTPClient,
+			Middleware: append([]APIMiddleware{MeasureRequests(config.Metrics)}, config.Middleware...),
+		})
+	}
+
 	return &Client{
-		credentials: blinkAdapter.ClientCredentials{
-			Region:     region,
-			ApiToken:   apiToken,
-			DeviceType: deviceType,
-			AccountId:  accountId,
-			NetworkId:  networkId,
-			CameraId:   cameraId,
-		},
-		config: ClientConfig{
-			ConnectTimeout: 15 * time.Second,
-			OnError: func(err error) {
-				// TODO: Make configurable
-				log.Println(err)
-			},
-			OnLog: func(msg string) {
-				// TODO: Make configurable
-				log.Println(msg)
-			},
+		credentials: blinkapi.ClientCredentials{
+			Region:      region,
+			BaseURL:     config.BaseURL,
+			ApiToken:    apiToken,
+			DeviceType:  deviceType,
+			AccountId:   accountId,
+			NetworkId:   networkId,
+			CameraId:    cameraId,
+			Locale:      config.Locale,
+			Country:     config.Country,
+			TimeZone:    config.TimeZone,
+			ApiVersions: config.ApiVersions,
+			Identity:    config.Identity,
 		},
+		api:    service,
+		config: config,
 		state: clientState{
-			connected:     false,
-			lvCommandId:   0,
-			streamContext: nil,
-			streamCancel:  nil,
+			state: STATE_IDLE,
 		},
 	}
 }
 
+// ResolveRegion discovers the region of an account (e.g. "u011") by probing the
+// account endpoint of each known region, for when only the token and account ID are
+// known.
+//
+// apiToken: the Blink API token
+//
+// accountId: the ID of the account
+//
+// Example: ResolveRegion("abc", 12345) = "u011", nil
+func ResolveRegion(apiToken string, accountId int) (string, error) {
+	return blinkapi.DefaultAPI.ResolveRegionContext(context.Background(), apiToken, accountId)
+}
+
 // Connect establishes a connection to the livestream.
 //
 // writer: the pipe to write the stream data to. This will not be closed by the function.
 //
 // Example: Connect(writer) = nil
 func (c *Client) Connect(writer io.Writer) error {
-	if c.state.connected {
-		return fmt.Errorf("error during connect: client is already connected")
+	_, err := c.begin(context.Background(), writer)
+
+	return err
+}
+
+// begin connects to the livestream and returns the started session. The context
+// cancels the API requests made while connecting.
+func (c *Client) begin(ctx context.Context, writer io.Writer) (*session, error) {
+	c.state.mu.Lock()
+	if c.state.state != STATE_IDLE {
+		state := c.state.state
+		c.state.mu.Unlock()
+		return nil, fmt.Errorf("error during connect: client is %s", state)
+	}
+	c.state.state = STATE_CONNECTING
+	cameraId := c.credentials.CameraId
+	c.state.mu.Unlock()
+
+	var remaining time.Duration
+	if c.config.Budget != nil {
+		var err error
+		remaining, err = c.config.Budget.Allow(cameraId)
+		if err != nil {
+			c.state.mu.Lock()
+			c.state.state = STATE_IDLE
+			c.state.mu.Unlock()
+			return nil, fmt.Errorf("error during connect: %w", err)
+		}
+	}
+
+	session, err := c.connect(ctx, writer)
+	if err != nil {
+		c.state.mu.Lock()
+		c.state.state = STATE_IDLE
+		c.state.mu.Unlock()
+		return nil, fmt.Errorf("error during connect: %w", err)
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	// Disconnect was called while connecting
+	if c.state.state == STATE_STOPPING {
+		session.cancel()
+		c.state.state = STATE_IDLE
+		go func(credentials blinkapi.ClientCredentials) {
+			if err := c.stopCommand(credentials, session.commandId); err != nil {
+				log.Printf("Error stopping command: %v", err)
+			}
+		}(c.credentials)
+		return nil, fmt.Errorf("error during connect: client was disconnected")
+	}
+
+	c.state.state = STATE_STREAMING
+	c.state.session = session
+	c.state.connectedAt = time.Now()
+	c.config.Metrics.Gauge(metrics.LIVEVIEW_CONNECTED, 1, nil)
+	session.budget = remaining
+	session.start()
+
+	return session, nil
+}
+
+// session is a livestream established by connect
+type session struct {
+	// The Blink command ID for the live view request. Guarded by the client mutex
+	// once started, since renewal replaces it
+	commandId int
+	// The liveview currently streamed, guarded by the client mutex like commandId
+	liveView *liveView
+	// Context for managing the stream lifecycle
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Starts polling the command and streaming
+	start func()
+	// Closed once the stream has ended and the session was stopped
+	done chan struct{}
+	// The error that ended the stream, or nil if it was stopped by the client. Set
+	// before done is closed
+	err error
+	// The streaming budget left when the session started, or 0 if unlimited or
+	// already used up
+	budget time.Duration
+	// The writer passed to Connect, flushed by DisconnectContext
+	writer io.Writer
+}
+
+// liveView is a livestream connection requested from the Blink API
+type liveView struct {
+	// The Blink command ID for the live view request
+	commandId int
+	// The command polling interval in seconds
+	pollingInterval int
+	// The connection string of the liveview server
+	server string
+	// The connection details of the liveview server
+	connection *blinkapi.ConnectionInfo
+}
+
+// connect requests the livestream and prepares the session without starting it
+func (c *Client) connect(ctx context.Context, writer io.Writer) (*session, error) {
+	output := writer
+	var batch *batcher
+	if c.config.WriteBatchSize > 0 {
+		batch = newBatcher(writer, c.config.WriteBatchSize, c.config.WriteBatchInterval)
+		writer = batch
+	}
+	if c.config.Streams != mpegts.STREAMS_BOTH {
+		filter, err := mpegts.NewFilter(writer, c.config.Streams)
+		if err != nil {
+			return nil, err
+		}
+		writer = filter
 	}
 
-	resp, err := blinkAdapter.InitiateLiveView(c.credentials)
+	if _, err := c.deviceType(ctx); err != nil {
+		return nil, err
+	}
+
+	lv, err := c.requestLiveView(ctx)
 	if err != nil {
-		return fmt.Errorf("error during connect: %w", err)
+		return nil, err
 	}
 
-	c.state.streamContext, c.state.streamCancel = context.WithCancel(context.Background())
-	c.state.lvCommandId = resp.CommandId
-	c.state.connected = true
-	go blinkAdapter.PollCommand(c.state.streamContext, c.credentials, resp.CommandId, resp.PollingInterval)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		commandId: lv.commandId,
+		liveView:  lv,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		writer:    output,
+	}
+
+	s.start = func() {
+		var delayed *prebuffer
+		if c.config.StartupBuffer > 0 {
+			delayed = newPrebuffer(writer, c.config.StartupBuffer)
+			writer = delayed
+		}
+		var tap *frameTap
+		if c.config.OnVideoFrame != nil {
+			tap = newFrameTap(writer, c.config)
+			writer = tap
+		}
+
+		go func() {
+			// Set when the session is stopped because the budget ran out
+			var exhausted atomic.Bool
+			if s.budget > 0 {
+				timer := time.AfterFunc(s.budget, func() {
+					if c.config.Budget.Policy() == budget.POLICY_WARN {
+						c.config.OnLog("The daily streaming budget of the camera is used up")
+						return
+					}
+					c.config.OnLog("Stopping livestream, the daily streaming budget of the camera is used up")
+					exhausted.Store(true)
+					cancel()
+				})
+				defer timer.Stop()
+			}
+
+			err := c.run(s, lv, writer)
+
+			// A stale command is replaced with a new one behind the same writer
+			for retries := 0; retries < STALE_COMMAND_RETRIES && ctx.Err() == nil; retries++ {
+				var commandErr *CommandError
+				if !errors.As(err, &commandErr) || !commandErr.Retryable() {
+					break
+				}
+
+				c.config.OnLog(fmt.Sprintf("Blink reported the command as %s, requesting a new livestream", commandErr.State))
+				next, requestErr := c.requestLiveView(ctx)
+				if requestErr != nil {
+					err = requestErr
+					break
+				}
+				if !c.replaceCommand(s, next) {
+					if err := c.stopCommand(c.credentialsSnapshot(), next.commandId); err != nil {
+						log.Printf("Error stopping command: %v", err)
+					}
+					break
+				}
+				if d, ok := writer.(interface{ Discontinuity() }); ok {
+					d.Discontinuity()
+				}
+				err = c.run(s, next, writer)
+			}
+
+			// Errors caused by stopping the stream are not terminal errors
+			if err != nil && ctx.Err() == nil {
+				s.err = err
+			}
+			if exhausted.Load() {
+				s.err = budget.ErrExhausted
+			}
+			if tap != nil {
+				tap.Close()
+			}
+			if delayed != nil {
+				if err := delayed.Close(); err != nil && s.err == nil && ctx.Err() == nil {
+					s.err = err
+				}
+			}
+			if batch != nil {
+				if err := batch.Close(); err != nil && s.err == nil && ctx.Err() == nil {
+					s.err = err
+				}
+			}
+
+			// Force disconnect on stream end if not directly cancelled
+			c.stop(s)
+			close(s.done)
+		}()
+	}
+
+	return s, nil
+}
+
+// requestLiveView initiates a liveview command and parses its connection details.
+// The context cancels the request.
+func (c *Client) requestLiveView(ctx context.Context) (*liveView, error) {
+	credentials := c.credentialsSnapshot()
+
+	start := time.Now()
+	resp, err := c.api.InitiateLiveViewContext(ctx, credentials, blinkapi.LiveviewInput{
+		Quality: c.config.Quality,
+	})
+	if err != nil {
+		c.config.Metrics.Counter(metrics.LIVEVIEW_CONNECTS_TOTAL, 1, metrics.Labels{"result": "error"})
+		return nil, err
+	}
+	c.config.Metrics.Counter(metrics.LIVEVIEW_CONNECTS_TOTAL, 1, metrics.Labels{"result": "success"})
+	c.config.Metrics.Histogram(metrics.LIVEVIEW_CONNECT_SECONDS, time.Since(start).Seconds(), nil)
+	if c.config.OnCommand != nil {
+		c.config.OnCommand(resp.CommandId, true)
+	}
 
 	// Get the connection details
-	host, port, clientId, connId, err := blinkAdapter.ParseConnectionString(resp.Server)
+	connection, err := blinkapi.ParseConnectionInfo(resp.Server)
 	if err != nil {
-		return fmt.Errorf("error during connect: parsing connection string: %w", err)
+		if err := c.stopCommand(credentials, resp.CommandId); err != nil {
+			log.Printf("Error stopping command: %v", err)
+		}
+		return nil, fmt.Errorf("parsing connection string: %w", err)
+	}
+
+	return &liveView{
+		commandId:       resp.CommandId,
+		pollingInterval: resp.PollingInterval,
+		server:          resp.Server,
+		connection:      connection,
+	}, nil
+}
+
+// stream polls the liveview command and streams its connection to the writer until
+// the context is cancelled, the stream ends, or Blink completes the command
+func (c *Client) stream(ctx context.Context, lv *liveView, writer io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// Set before the stream is cancelled when Blink completes the command or the poll
+	// shows that it is gone
+	var completed *CommandError
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+
+		result := c.api.PollCommand(ctx, c.credentialsSnapshot, lv.commandId, lv.pollingInterval)
+		switch result.Outcome {
+		case blinkapi.POLL_COMPLETED:
+			c.config.OnLog(fmt.Sprintf("Command %d was completed by Blink: %s", lv.commandId, result.Reason()))
+			if c.config.OnCommandComplete != nil {
+				c.config.OnCommandComplete(result.Reason())
+			}
+			completed = &CommandError{State: result.State, StatusCode: result.StatusCode, Code: result.Code, Message: result.Message}
+			cancel()
+		case blinkapi.POLL_FAILED:
+			c.config.OnError(fmt.Errorf("error polling command %d: %w", lv.commandId, result.Err))
+			if result.State.Terminal() {
+				c.config.OnLog(fmt.Sprintf("Command %d is %s", lv.commandId, result.State))
+				completed = &CommandError{State: result.State, StatusCode: result.StatusCode, Code: result.Code, Message: result.Message}
+				cancel()
+			}
+		}
+	}()
+
+	// Video frames are counted on the decoded stream for the frame rate
+	var frames *transport.RateMeter
+	if c.config.OnStats != nil && !c.config.RawStream {
+		frames = transport.NewRateMeter(transport.STATS_WINDOW)
+		writer = newFrameCounter(writer, frames)
+	}
+
+	// The stream interleaves control frames with the media frames
+	if !c.config.RawStream {
+		writer = blinkproto.NewDecoder(writer, func(frame blinkproto.Frame) {
+			if c.config.OnControlMessage != nil {
+				c.config.OnControlMessage(ControlMessage{
+					Type:     frame.Type,
+					Sequence: frame.Sequence,
+					Payload:  frame.Payload,
+				})
+			}
+		})
 	}
 
 	streamConfig := transport.StreamConfig{
 		Writer:       writer,
-		Ctx:          c.state.streamContext,
+		Ctx:          ctx,
 		ReadTimeout:  c.config.ConnectTimeout,
-		PingInterval: 1 * time.Second,
-		OnPing:       blinkProtocol.SendPing,
-		OnConnect: func(conn *tls.Conn) error {
-			return blinkProtocol.SendAuthFrames(conn, connId, clientId)
+		PingInterval: c.config.PingInterval,
+		KeepAlive:    c.config.KeepAlive,
+		OnPing:       blinkproto.SendPing,
+		OnConnect: func(conn net.Conn) error {
+			return blinkproto.SendAuthFrames(conn, lv.connection.ConnectionId, lv.connection.ClientId)
 		},
 		OnError: c.config.OnError,
 		OnLog:   c.config.OnLog,
+		Metrics: c.config.Metrics,
+
+		ReadBufferSize: c.config.ReadBufferSize,
+		StatsInterval:  c.config.StatsInterval,
+		Dial: transport.DialConfig{
+			Timeout:       c.config.DialTimeout,
+			SourceAddress: c.config.SourceAddress,
+			Interface:     c.config.Interface,
+
+			KeepAliveIdle:     c.config.TCPKeepAliveIdle,
+			KeepAliveInterval: c.config.TCPKeepAliveInterval,
+			KeepAliveCount:    c.config.TCPKeepAliveCount,
+			Nagle:             c.config.TCPNagle,
+		},
+		TLSConfig:   c.config.TLSConfig,
+		OnHandshake: c.config.OnTLSHandshake,
+	}
+
+	if capture := c.captureWriter(); capture != nil {
+		connection := capture.Connection()
+		var failed atomic.Bool
+		streamConfig.OnCapture = func(sent bool, data []byte) {
+			direction := byte(blinkproto.CAPTURE_RECEIVED)
+			if sent {
+				direction = blinkproto.CAPTURE_SENT
+			}
+			if err := capture.Record(direction, connection, data); err != nil && !failed.Swap(true) {
+				c.config.OnError(fmt.Errorf("error writing capture: %w", err))
+			}
+		}
+	}
+	if c.config.TraceStream > 0 {
+		tracer := newStreamTracer(c.config.TraceStream, c.config.OnLog)
+		onCapture := streamConfig.OnCapture
+		streamConfig.OnCapture = func(sent bool, data []byte) {
+			tracer.trace(sent, data)
+			if onCapture != nil {
+				onCapture(sent, data)
+			}
+		}
+	}
+
+	if c.config.OnStats != nil {
+		streamConfig.OnStats = func(stats Stats) {
+			if frames != nil {
+				stats.FrameRate = frames.Rate()
+			}
+			c.config.OnStats(stats)
+		}
 	}
 
 	// Connect to the TCP server
-	go func() {
-		if err := transport.Stream(streamConfig, host, port); err != nil {
-			c.config.OnError(fmt.Errorf("stream error: %w", err))
+	err := transport.Stream(streamConfig, lv.connection.Host, lv.connection.Port)
+
+	cancel()
+	<-polled
+
+	// The stream was torn down because the command was completed
+	if completed != nil {
+		return fmt.Errorf("livestream ended by Blink: %w", completed)
+	}
+	if err != nil {
+		c.config.OnError(fmt.Errorf("stream error: %w", err))
+		return fmt.Errorf("stream error: %w", err)
+	}
+
+	return nil
+}
+
+// captureWriter returns the writer capturing the stream connections, if configured
+func (c *Client) captureWriter() *blinkproto.CaptureWriter {
+	c.captureOnce.Do(func() {
+		if c.config.Capture == nil {
+			return
+		}
+
+		capture, err := blinkproto.NewCaptureWriter(c.config.Capture)
+		if err != nil {
+			c.config.OnError(err)
+			return
 		}
+		c.capture = capture
+	})
 
-		// Force disconnect on stream end if not directly cancelled
-		c.Disconnect()
+	return c.capture
+}
+
+// Open establishes a connection to the livestream and returns a reader of the
+// MPEG-TS stream, for consumers that pull the data (e.g. io.Copy into their own
+// pipeline) instead of passing a writer to Connect. The connection is bridged to the
+// reader through a pipe, so the stream waits for the reader. The stream ends when
+// the context is cancelled, the reader is closed, or the livestream ends; reads then
+// return the context error, io.ErrClosedPipe, or the error that ended the livestream
+// (io.EOF if it ended cleanly).
+//
+// ctx: the context controlling the stream lifecycle
+//
+// Example: Open(ctx) = io.ReadCloser, nil
+func (c *Client) Open(ctx context.Context) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	session, err := c.begin(ctx, writer)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.stop(session)
+			writer.CloseWithError(ctx.Err())
+		case <-session.ctx.Done():
+			// The stream winds down once the pending write was read or the reader
+			// was closed. A nil error makes the reader return io.EOF
+			<-session.done
+			writer.CloseWithError(session.err)
+		}
 	}()
 
+	return &streamReader{PipeReader: reader, client: c, session: session}, nil
+}
+
+// streamReader is the reader returned by Open. Closing it ends the livestream.
+type streamReader struct {
+	*io.PipeReader
+	client *Client
+	// The session opened by Open
+	session *session
+}
+
+func (r *streamReader) Close() error {
+	// Closing the pipe first unblocks a pending write from the stream
+	r.PipeReader.Close()
+
+	// The client may have been reconnected by the caller since
+	r.client.stop(r.session)
+
 	return nil
 }
 
-// Disconnect terminates the connection to the livestream.
+// Stream connects to the livestream and blocks until it ends. It returns nil when
+// the context is cancelled or Disconnect is called, and the error that ended the
+// stream otherwise.
+//
+// ctx: the context controlling the stream lifecycle
+//
+// writer: the pipe to write the stream data to. This will not be closed by the function.
+//
+// Example: Stream(ctx, writer) = nil
+func (c *Client) Stream(ctx context.Context, writer io.Writer) error {
+	session, err := c.begin(ctx, writer)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.stop(session)
+		<-session.done
+		return nil
+	case <-session.done:
+		return session.err
+	}
+}
+
+// Disconnect terminates the connection to the livestream. It is safe to call at any
+// time and from any goroutine; a pending Connect is aborted. It does not wait for
+// the stream to wind down, see DisconnectContext.
 func (c *Client) Disconnect() error {
-	if !c.state.connected {
+	c.stop(nil)
+
+	return nil
+}
+
+// DisconnectContext terminates the connection to the livestream and waits until the
+// stream has stopped writing, flushes the writer passed to Connect if it implements
+// Flush() error, and confirms that Blink stopped the command. It is safe to call at
+// any time and from any goroutine; a pending Connect is aborted.
+//
+// ctx: bounds how long to wait for the stream to wind down
+//
+// Example: DisconnectContext(ctx) = nil
+func (c *Client) DisconnectContext(ctx context.Context) error {
+	c.state.mu.Lock()
+	session := c.state.session
+	streaming := c.state.state == STATE_STREAMING
+	c.state.mu.Unlock()
+
+	// Nothing was written yet by a pending Connect
+	if !streaming || session == nil {
+		c.stop(nil)
 		return nil
 	}
 
-	c.state.streamCancel()
-	c.state.connected = false
+	stopErr := c.stop(session)
+
+	select {
+	case <-session.done:
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for the stream to stop: %w", ctx.Err())
+	}
+
+	if flusher, ok := session.writer.(interface{ Flush() error }); ok {
+		flushed := make(chan error, 1)
+		go func() {
+			flushed <- flusher.Flush()
+		}()
+
+		select {
+		case err := <-flushed:
+			if err != nil {
+				return errors.Join(stopErr, fmt.Errorf("error flushing output: %w", err))
+			}
+		case <-ctx.Done():
+			return errors.Join(stopErr, fmt.Errorf("error flushing output: %w", ctx.Err()))
+		}
+	}
+
+	return stopErr
+}
+
+// stopCommand marks the liveview command as done, bounded by STOP_COMMAND_TIMEOUT
+func (c *Client) stopCommand(credentials blinkapi.ClientCredentials, commandId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), STOP_COMMAND_TIMEOUT)
+	defer cancel()
 
-	if err := blinkAdapter.StopCommand(c.credentials, c.state.lvCommandId); err != nil {
+	err := c.api.StopCommandContext(ctx, credentials, commandId)
+	if err == nil && c.config.OnCommand != nil {
+		c.config.OnCommand(commandId, false)
+	}
+
+	return err
+}
+
+// stop ends the session, or the current session if nil, and returns the error of
+// stopping its command
+func (c *Client) stop(session *session) error {
+	c.state.mu.Lock()
+	switch {
+	case c.state.state == STATE_CONNECTING && session == nil:
+		// Connect cleans up once the request completes
+		c.state.state = STATE_STOPPING
+		c.state.mu.Unlock()
+		return nil
+	case c.state.state != STATE_STREAMING:
+		c.state.mu.Unlock()
+		return nil
+	case session != nil && session != c.state.session:
+		c.state.mu.Unlock()
+		return nil
+	}
+
+	c.state.state = STATE_STOPPING
+	session, connectedAt := c.state.session, c.state.connectedAt
+	c.state.mu.Unlock()
+
+	// A renewal in progress gives up once the session is cancelled, so the command
+	// read afterwards is the last one in use
+	session.cancel()
+	c.state.mu.Lock()
+	credentials, commandId := c.credentials, session.commandId
+	c.state.mu.Unlock()
+
+	c.config.Metrics.Gauge(metrics.LIVEVIEW_CONNECTED, 0, nil)
+	c.config.Metrics.Histogram(metrics.LIVEVIEW_SESSION_SECONDS, time.Since(connectedAt).Seconds(), nil)
+	if c.config.Budget != nil {
+		if err := c.config.Budget.Record(credentials.CameraId, time.Since(connectedAt)); err != nil {
+			c.config.OnError(fmt.Errorf("error recording streaming time: %w", err))
+		}
+	}
+
+	err := c.stopCommand(credentials, commandId)
+	if err != nil {
 		log.Printf("Error stopping command: %v", err)
+		err = fmt.Errorf("error stopping command %d: %w", commandId, err)
+	}
+
+	c.state.mu.Lock()
+	c.state.state = STATE_IDLE
+	c.state.session = nil
+	c.state.mu.Unlock()
+
+	return err
+}
+
+// UpdateToken replaces the API token of the client, e.g. after it was refreshed. The
+// running livestream is not interrupted: its command poller and every later API
+// request use the new token.
+//
+// apiToken: the new Blink API token
+//
+// Example: UpdateToken("new-token") = nil
+func (c *Client) UpdateToken(apiToken string) error {
+	return c.UpdateCredentials("", apiToken)
+}
+
+// UpdateCredentials replaces the region and API token of the client, e.g. after
+// logging in again. The running livestream is not interrupted: its command poller
+// and every later API request use the new credentials.
+//
+// region: the new account region (e.g. "u011"), or empty to keep the current one
+//
+// apiToken: the new Blink API token
+//
+// Example: UpdateCredentials("u011", "new-token") = nil
+func (c *Client) UpdateCredentials(region string, apiToken string) error {
+	if apiToken == "" {
+		return errors.New("error updating credentials: the API token is empty")
+	}
+
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	c.credentials.ApiToken = apiToken
+	if region != "" {
+		c.credentials.Region = region
+	}
+
+	return nil
+}
+
+// SetBaseURL replaces the base URL of the Blink API, e.g. to route requests through
+// a debugging proxy. Like UpdateCredentials, it applies to the running livestream's
+// command poller and every later API request.
+//
+// baseURL: the base URL with a %s placeholder for the region, a full URL used for
+// every region, or empty for DEFAULT_BASE_URL
+//
+// Example: SetBaseURL("http://127.0.0.1:8080") = nil
+func (c *Client) SetBaseURL(baseURL string) error {
+	if baseURL != "" {
+		if _, err := url.Parse(strings.ReplaceAll(baseURL, "%s", "region")); err != nil {
+			return fmt.Errorf("error updating base URL: %w", err)
+		}
 	}
 
-	c.state.streamContext = nil
-	c.state.streamCancel = nil
-	c.state.lvCommandId = 0
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	c.credentials.BaseURL = baseURL
 
 	return nil
 }
 
+// DeviceType returns the device type of the camera (e.g. "owl"). When the client was
+// created without a device type, it is detected from the account's homescreen on
+// first use and remembered.
+//
+// Example: DeviceType() = "owl", nil
+func (c *Client) DeviceType() (string, error) {
+	return c.deviceType(context.Background())
+}
+
+// deviceType returns the device type of the camera, detecting it with a request
+// canceled by the context if needed
+func (c *Client) deviceType(ctx context.Context) (string, error) {
+	c.state.mu.Lock()
+	credentials := c.credentials
+	c.state.mu.Unlock()
+
+	if credentials.DeviceType != "" {
+		return credentials.DeviceType, nil
+	}
+
+	deviceType, err := c.api.ResolveDeviceTypeContext(ctx, credentials)
+	if err != nil {
+		return "", fmt.Errorf("error detecting device type: %w", err)
+	}
+	c.config.OnLog(fmt.Sprintf("Detected device type %q for camera %d", deviceType, credentials.CameraId))
+
+	c.state.mu.Lock()
+	c.credentials.DeviceType = deviceType
+	c.state.mu.Unlock()
+
+	return deviceType, nil
+}
+
+// State returns the lifecycle state of the client (e.g. STATE_STREAMING).
+func (c *Client) State() State {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return c.state.state
+}
+
 // IsConnected returns whether the client is currently connected to the livestream.
 func (c *Client) IsConnected() bool {
-	return c.state.connected
+	return c.State() == STATE_STREAMING
+}
+
+// ParseAPIVersions parses API version overrides of the form
+// "endpoint=version,version;endpoint=version". A "v" prefix on versions is optional.
+//
+// value: the overrides to parse
+//
+// Example: ParseAPIVersions("camera_liveview=v6,v5") = map[string][]int{"camera_liveview": {6, 5}}, nil
+func ParseAPIVersions(value string) (map[string][]int, error) {
+	versions := map[string][]int{}
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		endpoint, list, ok := strings.Cut(entry, "=")
+		endpoint = strings.TrimSpace(endpoint)
+		if !ok {
+			return nil, fmt.Errorf("invalid API version override %q", entry)
+		}
+		if _, ok := blinkapi.DEFAULT_API_VERSIONS[endpoint]; !ok {
+			return nil, fmt.Errorf("unknown API endpoint %q", endpoint)
+		}
+
+		for _, item := range strings.Split(list, ",") {
+			version, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(item), "v"))
+			if err != nil || version <= 0 {
+				return nil, fmt.Errorf("invalid API version %q for %s", item, endpoint)
+			}
+			versions[endpoint] = append(versions[endpoint], version)
+		}
+	}
+
+	return versions, nil
 }
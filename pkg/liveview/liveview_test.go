@@ -0,0 +1,255 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeService is a BlinkService starting liveview commands numbered from 1, all
+// streamed by the same local relay. Starting and stopping a command each wait for
+// a value on their gate, so a test can look at the client in between.
+type fakeService struct {
+	// The calls the client is not expected to make
+	BlinkService
+
+	// The address of the relay and the number of connections it accepted
+	addr        string
+	connections *atomic.Int32
+	// The commands Blink reports as stale once their stream is connected
+	stale map[int]bool
+
+	initiateGate chan struct{}
+	stopGate     chan struct{}
+
+	mu      sync.Mutex
+	started []int
+	stopped []int
+}
+
+func (f *fakeService) InitiateLiveViewContext(ctx context.Context, cc blinkapi.ClientCredentials, input blinkapi.LiveviewInput) (*blinkapi.LiveviewResponse, error) {
+	select {
+	case <-f.initiateGate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commandId := len(f.started) + 1
+	f.started = append(f.started, commandId)
+
+	return &blinkapi.LiveviewResponse{
+		CommandId:       commandId,
+		PollingInterval: 1,
+		Server:          fmt.Sprintf("immis://%s/abcd%d_0?client_id=1", f.addr, commandId),
+	}, nil
+}
+
+func (f *fakeService) PollCommand(ctx context.Context, credentials func() blinkapi.ClientCredentials, commandId int, pollInterval int) blinkapi.PollResult {
+	// The command runs until the client stops it, or goes stale once streamed
+	for ctx.Err() == nil {
+		if f.stale[commandId] && int(f.connections.Load()) >= commandId {
+			return blinkapi.PollResult{
+				Outcome:    blinkapi.POLL_COMPLETED,
+				State:      COMMAND_STATE_STALE,
+				HttpStatus: 200,
+				StatusCode: CODE_COMMAND_STALE,
+				Message:    "Command is stale",
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return blinkapi.PollResult{Outcome: blinkapi.POLL_CANCELLED}
+}
+
+func (f *fakeService) StopCommandContext(ctx context.Context, cc blinkapi.ClientCredentials, commandId int) error {
+	select {
+	case <-f.stopGate:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = append(f.stopped, commandId)
+
+	return nil
+}
+
+// commands returns the IDs of the commands started and stopped so far
+func (f *fakeService) commands() ([]int, []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]int(nil), f.started...), append([]int(nil), f.stopped...)
+}
+
+// TestClientLifecycle drives a client through STATE_CONNECTING, STATE_STREAMING,
+// and STATE_STOPPING back to STATE_IDLE against a fake BlinkService, replacing the
+// command Blink reports as stale without leaving STATE_STREAMING.
+func TestClientLifecycle(t *testing.T) {
+	addr, connections := startTestRelay(t)
+	service := &fakeService{
+		addr:         addr,
+		connections:  connections,
+		stale:        map[int]bool{1: true},
+		initiateGate: make(chan struct{}),
+		stopGate:     make(chan struct{}),
+	}
+
+	var mu sync.Mutex
+	var reasons []string
+	config := DefaultClientConfig()
+	config.Service = service
+	config.RawStream = true
+	config.OnLog = func(string) {}
+	config.OnError = func(error) {}
+	config.OnCommandComplete = func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons = append(reasons, reason)
+	}
+	client := NewClientWithConfig("u011", "token", "camera", 1, 2, 3, config)
+	if state := client.State(); state != STATE_IDLE {
+		t.Fatalf("new client is %s, want %s", state, STATE_IDLE)
+	}
+
+	connected := make(chan error, 1)
+	go func() {
+		connected <- client.Connect(io.Discard)
+	}()
+	waitForState(t, client, STATE_CONNECTING)
+	service.initiateGate <- struct{}{}
+	if err := <-connected; err != nil {
+		t.Fatal(err)
+	}
+	if state := client.State(); state != STATE_STREAMING {
+		t.Fatalf("connected client is %s, want %s", state, STATE_STREAMING)
+	}
+
+	// Command 1 goes stale once streamed, and a new command continues the stream
+	service.initiateGate <- struct{}{}
+	waitFor(t, "the stream of the new command", func() bool {
+		return connections.Load() == 2
+	})
+	if state := client.State(); state != STATE_STREAMING {
+		t.Errorf("client is %s after the stale command was replaced, want %s", state, STATE_STREAMING)
+	}
+	mu.Lock()
+	if want := []string{"Command is stale"}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("commands completed with %q, want %q", reasons, want)
+	}
+	mu.Unlock()
+
+	disconnected := make(chan error, 1)
+	go func() {
+		disconnected <- client.Disconnect()
+	}()
+	waitForState(t, client, STATE_STOPPING)
+	service.stopGate <- struct{}{}
+	if err := <-disconnected; err != nil {
+		t.Fatal(err)
+	}
+	if state := client.State(); state != STATE_IDLE {
+		t.Errorf("disconnected client is %s, want %s", state, STATE_IDLE)
+	}
+
+	started, stopped := service.commands()
+	if want := []int{1, 2}; !reflect.DeepEqual(started, want) {
+		t.Errorf("started commands %v, want %v", started, want)
+	}
+	if want := []int{2}; !reflect.DeepEqual(stopped, want) {
+		t.Errorf("stopped commands %v, want %v", stopped, want)
+	}
+}
+
+func waitForState(t *testing.T, client *Client, state State) {
+	t.Helper()
+
+	waitFor(t, string(state), func() bool {
+		return client.State() == state
+	})
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startTestRelay starts a TLS server standing in for the liveview server, which
+// sends a null TS packet to each connection every 10 milliseconds. It returns its
+// address and the number of connections it accepted.
+func startTestRelay(t *testing.T) (string, *atomic.Int32) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	packet := make([]byte, mpegts.PACKET_SIZE)
+	packet[0], packet[1], packet[2], packet[3] = mpegts.SYNC_BYTE, 0x1f, 0xff, 0x10
+
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			go func() {
+				defer conn.Close()
+				// The authentication frames and pings of the client are ignored
+				go io.Copy(io.Discard, conn)
+				for {
+					if _, err := conn.Write(packet); err != nil {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), &connections
+}
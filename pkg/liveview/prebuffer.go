@@ -0,0 +1,181 @@
+package liveview
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// MAX_STARTUP_BUFFER bounds ClientConfig.StartupBuffer
+	MAX_STARTUP_BUFFER = 10 * time.Second
+	// MAX_STARTUP_BUFFER_BYTES bounds the data held by the startup buffer. Writes
+	// wait while an output that falls behind leaves more queued
+	MAX_STARTUP_BUFFER_BYTES = 16 << 20
+)
+
+// prebuffer delays the stream by a constant duration before the writer. The first
+// data is held until the duration has passed, then every chunk is released as long
+// after its arrival, so the outputs receive the stream at the pace of the camera
+// with the bursts and gaps of the connection evened out.
+type prebuffer struct {
+	writer io.Writer
+	delay  time.Duration
+	// Signals the release loop that the queue or closed changed
+	wake chan struct{}
+	// Closed once the release loop has returned
+	done chan struct{}
+	// Guards the fields below
+	mu sync.Mutex
+	// Signalled when queued data was released
+	released *sync.Cond
+	// The chunks waiting for their release
+	queue []delayedChunk
+	// The bytes of the queued chunks
+	queued int
+	// The error of the writer, returned by later writes
+	err error
+	// Whether the remaining chunks are released right away
+	closed bool
+}
+
+// delayedChunk is data held by a prebuffer, or a discontinuity of the stream
+type delayedChunk struct {
+	arrived       time.Time
+	data          []byte
+	discontinuity bool
+}
+
+func newPrebuffer(writer io.Writer, delay time.Duration) *prebuffer {
+	p := &prebuffer{
+		writer: writer,
+		delay:  min(delay, MAX_STARTUP_BUFFER),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	p.released = sync.NewCond(&p.mu)
+	go p.run()
+
+	return p
+}
+
+func (p *prebuffer) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.queued > MAX_STARTUP_BUFFER_BYTES && p.err == nil && !p.closed {
+		p.released.Wait()
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	p.push(delayedChunk{arrived: time.Now(), data: append([]byte(nil), data...)})
+
+	return len(data), nil
+}
+
+// Discontinuity is passed on to the writer once the data before it was released
+func (p *prebuffer) Discontinuity() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.push(delayedChunk{arrived: time.Now(), discontinuity: true})
+}
+
+// push queues a chunk. p.mu must be held.
+func (p *prebuffer) push(chunk delayedChunk) {
+	p.queue = append(p.queue, chunk)
+	p.queued += len(chunk.data)
+	if len(p.queue) == 1 {
+		p.signal()
+	}
+}
+
+// signal wakes the release loop without blocking
+func (p *prebuffer) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close releases the queued data right away and waits until it was written,
+// returning the error of the writer
+func (p *prebuffer) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.released.Broadcast()
+	p.signal()
+	p.mu.Unlock()
+
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// run releases the queued chunks once they were held for the delay
+func (p *prebuffer) run() {
+	defer close(p.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 || p.err != nil {
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return
+			}
+			<-p.wake
+			continue
+		}
+		chunk := p.queue[0]
+		wait := time.Until(chunk.arrived.Add(p.delay))
+		if p.closed {
+			wait = 0
+		}
+		p.mu.Unlock()
+
+		if wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-p.wake:
+				// Closing releases the chunk right away
+				timer.Stop()
+			}
+			continue
+		}
+
+		var err error
+		if chunk.discontinuity {
+			if d, ok := p.writer.(interface{ Discontinuity() }); ok {
+				d.Discontinuity()
+			}
+		} else {
+			_, err = p.writer.Write(chunk.data)
+		}
+
+		p.mu.Lock()
+		p.queue[0] = delayedChunk{}
+		p.queue = p.queue[1:]
+		p.queued -= len(chunk.data)
+		if err != nil {
+			// The stream sees the error on its next write
+			p.err = err
+			p.queue = nil
+			p.queued = 0
+		}
+		p.released.Broadcast()
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,80 @@
+package liveview
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// LiveViewServer describes the liveview server returned for a liveview command
+type LiveViewServer struct {
+	// The Blink command ID of the liveview request
+	CommandId int `json:"command_id"`
+	// The command polling interval in seconds
+	PollingInterval int `json:"polling_interval"`
+	// The connection string returned by the API (e.g. "immis://1.2.3.4:443/abcd_1234?client_id=5")
+	Server string `json:"server"`
+	// The scheme, host, and port of the liveview server
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"`
+	Port   string `json:"port"`
+	// The client and connection IDs sent in the authentication frame
+	ClientId     int    `json:"client_id"`
+	ConnectionId string `json:"connection_id"`
+	// Every query parameter of the connection string
+	Query url.Values `json:"query"`
+}
+
+// Prepare initiates a liveview command, parses the connection details of its
+// server, and stops the command again without connecting to the server. It is a dry
+// run of Connect for debugging the region and credentials, or for tools that connect
+// to the server themselves. The command wakes the camera like a stream would, but is
+// not counted against the daily budget.
+//
+// ctx: the context of the API requests
+//
+// Example: Prepare(ctx) = &LiveViewServer{Host: "1.2.3.4", Port: "443", ...}, nil
+func (c *Client) Prepare(ctx context.Context) (*LiveViewServer, error) {
+	lv, err := c.requestLiveView(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error during prepare: %w", err)
+	}
+
+	server := lv.describe()
+	if err := c.stopCommand(c.credentialsSnapshot(), lv.commandId); err != nil {
+		return server, fmt.Errorf("error stopping command: %w", err)
+	}
+
+	return server, nil
+}
+
+// SessionInfo returns the liveview server of the active session, which changes
+// when the session renews or replaces its command. It returns nil when no session
+// is active.
+//
+// Example: SessionInfo() = &LiveViewServer{Host: "1.2.3.4", CommandId: 1234, ...}
+func (c *Client) SessionInfo() *LiveViewServer {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if c.state.session == nil || c.state.session.liveView == nil {
+		return nil
+	}
+
+	return c.state.session.liveView.describe()
+}
+
+// describe returns the connection details of the liveview
+func (lv *liveView) describe() *LiveViewServer {
+	return &LiveViewServer{
+		CommandId:       lv.commandId,
+		PollingInterval: lv.pollingInterval,
+		Server:          lv.server,
+		Scheme:          lv.connection.Scheme,
+		Host:            lv.connection.Host,
+		Port:            lv.connection.Port,
+		ClientId:        lv.connection.ClientId,
+		ConnectionId:    lv.connection.ConnectionId,
+		Query:           lv.connection.Query,
+	}
+}
@@ -0,0 +1,83 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+)
+
+// Directions of Move
+const (
+	PTZ_LEFT  = blinkapi.PTZ_LEFT
+	PTZ_RIGHT = blinkapi.PTZ_RIGHT
+	PTZ_UP    = blinkapi.PTZ_UP
+	PTZ_DOWN  = blinkapi.PTZ_DOWN
+)
+
+// ErrPTZUnsupported is returned for cameras without a pan-tilt mount, and when the
+// BlinkService of the client does not implement blinkapi.PTZService
+var ErrPTZUnsupported = blinkapi.ErrPTZUnsupported
+
+// PTZPreset is a position saved in the Blink app for a camera on a pan-tilt mount
+type PTZPreset = blinkapi.PTZPreset
+
+// Move turns the camera one step in a direction on the Mini Pan-Tilt mount. It
+// works while streaming, so the turn can be watched.
+//
+// ctx: the context of the request
+//
+// direction: PTZ_LEFT, PTZ_RIGHT, PTZ_UP, or PTZ_DOWN
+//
+// Example: Move(ctx, PTZ_LEFT) = nil
+func (c *Client) Move(ctx context.Context, direction string) error {
+	ptz, credentials, err := c.ptz(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ptz.MoveCameraContext(ctx, credentials, direction)
+}
+
+// Presets returns the positions saved in the Blink app for the camera on the Mini
+// Pan-Tilt mount
+//
+// ctx: the context of the request
+//
+// Example: Presets(ctx) = []PTZPreset{{Id: 1, Name: "Driveway"}}, nil
+func (c *Client) Presets(ctx context.Context) ([]PTZPreset, error) {
+	ptz, credentials, err := c.ptz(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ptz.ListPTZPresetsContext(ctx, credentials)
+}
+
+// GotoPreset turns the camera on the Mini Pan-Tilt mount to a saved position
+//
+// ctx: the context of the request
+//
+// presetId: the ID of the preset, as listed by Presets
+//
+// Example: GotoPreset(ctx, 1) = nil
+func (c *Client) GotoPreset(ctx context.Context, presetId int) error {
+	ptz, credentials, err := c.ptz(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ptz.GotoPTZPresetContext(ctx, credentials, presetId)
+}
+
+// ptz returns the pan-tilt API of the client and the credentials of the camera,
+// with its device type detected
+func (c *Client) ptz(ctx context.Context) (blinkapi.PTZService, blinkapi.ClientCredentials, error) {
+	ptz, ok := c.api.(blinkapi.PTZService)
+	if !ok {
+		return nil, blinkapi.ClientCredentials{}, ErrPTZUnsupported
+	}
+	if _, err := c.deviceType(ctx); err != nil {
+		return nil, blinkapi.ClientCredentials{}, err
+	}
+
+	return ptz, c.credentialsSnapshot(), nil
+}
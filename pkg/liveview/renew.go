@@ -0,0 +1,299 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// SessionLimitPolicy is what happens when a session reaches MaxSessionDuration
+type SessionLimitPolicy string
+
+// Session limit policies. StopAtLimit ends the stream with ErrSessionLimit, while
+// RenewAtLimit requests a new liveview command and switches to its connection
+// behind the same writer.
+const (
+	StopAtLimit  SessionLimitPolicy = "stop"
+	RenewAtLimit SessionLimitPolicy = "renew"
+)
+
+// ErrSessionLimit is the terminal error of a session stopped at MaxSessionDuration
+var ErrSessionLimit = errors.New("session duration limit reached")
+
+const (
+	// RENEW_BUFFER_SIZE bounds the data of a renewed connection that is held back
+	// until the switch to it
+	RENEW_BUFFER_SIZE = 1 << 20
+	// RENEW_RETRY_INTERVAL is the delay before a failed renewal is attempted again
+	RENEW_RETRY_INTERVAL = 10 * time.Second
+)
+
+// connection is a liveview connection streaming in the background
+type connection struct {
+	// The liveview being streamed
+	liveView *liveView
+	// Ends the stream
+	cancel context.CancelFunc
+	// Receives the result of the stream
+	result chan error
+}
+
+// startConnection streams the liveview to the writer in the background
+func (c *Client) startConnection(ctx context.Context, lv *liveView, writer io.Writer) *connection {
+	ctx, cancel := context.WithCancel(ctx)
+	conn := &connection{
+		liveView: lv,
+		cancel:   cancel,
+		result:   make(chan error, 1),
+	}
+
+	go func() {
+		conn.result <- c.stream(ctx, lv, writer)
+	}()
+
+	return conn
+}
+
+// run streams the session until it ends, stopping or renewing it whenever
+// MaxSessionDuration is reached
+func (c *Client) run(s *session, lv *liveView, writer io.Writer) error {
+	if c.config.MaxSessionDuration <= 0 {
+		return c.stream(s.ctx, lv, writer)
+	}
+
+	var r *relay
+	if c.config.SessionLimitPolicy == RenewAtLimit {
+		r = newRelay(writer)
+		writer = r.current
+	}
+
+	current := c.startConnection(s.ctx, lv, writer)
+	defer func() {
+		current.cancel()
+	}()
+
+	limit := time.NewTimer(c.config.MaxSessionDuration)
+	defer limit.Stop()
+
+	for {
+		select {
+		case err := <-current.result:
+			return err
+		case <-limit.C:
+		}
+
+		if r == nil {
+			c.config.OnLog(fmt.Sprintf("Stopping livestream after %s", c.config.MaxSessionDuration))
+			current.cancel()
+			<-current.result
+			return ErrSessionLimit
+		}
+
+		next, err := c.renew(s, r)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				c.config.OnLog(fmt.Sprintf("Error renewing livestream, retrying in %s: %v", RENEW_RETRY_INTERVAL, err))
+			}
+			limit.Reset(RENEW_RETRY_INTERVAL)
+			continue
+		}
+
+		// The replaced connection is no longer written to; end it in the background
+		go func(old *connection, credentials blinkapi.ClientCredentials) {
+			old.cancel()
+			<-old.result
+			if err := c.stopCommand(credentials, old.liveView.commandId); err != nil {
+				log.Printf("Error stopping command: %v", err)
+			}
+		}(current, c.credentialsSnapshot())
+
+		current = next
+		limit.Reset(c.config.MaxSessionDuration)
+		c.config.OnLog(fmt.Sprintf("Renewed livestream with command %d", next.liveView.commandId))
+	}
+}
+
+// renew requests a new liveview while the current one keeps streaming, and switches
+// the relay to it once it delivers data
+func (c *Client) renew(s *session, r *relay) (*connection, error) {
+	lv, err := c.requestLiveView(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	next := c.startConnection(s.ctx, lv, r.prepare())
+	abort := func(err error) (*connection, error) {
+		r.abandon()
+		next.cancel()
+		<-next.result
+		if err := c.stopCommand(c.credentialsSnapshot(), lv.commandId); err != nil {
+			log.Printf("Error stopping command: %v", err)
+		}
+
+		return nil, err
+	}
+
+	select {
+	case <-r.ready:
+	case err := <-next.result:
+		next.result <- err
+		if err == nil {
+			err = errors.New("stream ended before delivering data")
+		}
+		return abort(err)
+	case <-time.After(c.config.ConnectTimeout):
+		return abort(errors.New("timed out waiting for stream data"))
+	case <-s.ctx.Done():
+		return abort(s.ctx.Err())
+	}
+
+	if !c.replaceCommand(s, lv) {
+		return abort(s.ctx.Err())
+	}
+
+	r.cutover()
+
+	return next, nil
+}
+
+// replaceCommand makes the command of the liveview the one stopped with the
+// session. It returns false if the session was stopped in the meantime, leaving the
+// command to the caller.
+func (c *Client) replaceCommand(s *session, lv *liveView) bool {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return false
+	}
+	s.commandId = lv.commandId
+	s.liveView = lv
+
+	return true
+}
+
+// credentialsSnapshot returns a copy of the client credentials
+func (c *Client) credentialsSnapshot() blinkapi.ClientCredentials {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	return c.credentials
+}
+
+// relay forwards whole transport stream packets from the current connection to the
+// session writer. During a renewal, packets of the next connection are held in a
+// small buffer so that the switch happens at a packet boundary without losing the
+// start of the new stream.
+type relay struct {
+	// The session writer
+	writer io.Writer
+	// Guards the fields below
+	mu sync.Mutex
+	// The input of the connection being forwarded
+	current *relayInput
+	// The input of the connection being switched to, if any
+	next *relayInput
+	// Packets of the next connection waiting for the switch
+	buffer []byte
+	// Closed once the next connection delivered data
+	ready chan struct{}
+	// The error of flushing the buffer, returned to the current connection
+	err error
+}
+
+func newRelay(writer io.Writer) *relay {
+	r := &relay{writer: writer}
+	r.current = &relayInput{relay: r}
+
+	return r
+}
+
+// prepare returns the input for the next connection
+func (r *relay) prepare() *relayInput {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.next = &relayInput{relay: r}
+	r.buffer = nil
+	r.ready = make(chan struct{})
+
+	return r.next
+}
+
+// abandon discards the next connection
+func (r *relay) abandon() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.next = nil
+	r.buffer = nil
+}
+
+// cutover switches to the next connection, signalling the discontinuity to the
+// writer before flushing the buffered packets
+func (r *relay) cutover() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current, r.next = r.next, nil
+	if d, ok := r.writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+
+	if len(r.buffer) > 0 {
+		_, r.err = r.writer.Write(r.buffer)
+	}
+	r.buffer = nil
+}
+
+// relayInput is the writer of a single connection
+type relayInput struct {
+	relay *relay
+	// Incomplete packet bytes carried over between writes
+	remainder []byte
+	// Whole packets of the current write
+	packets []byte
+}
+
+// Write forwards or buffers the whole packets of the connection's data. Data of a
+// replaced connection is discarded.
+func (in *relayInput) Write(p []byte) (int, error) {
+	r := in.relay
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	in.packets = in.packets[:0]
+	in.remainder = mpegts.AlignPackets(append(in.remainder, p...), func(pkt mpegts.Packet) {
+		in.packets = append(in.packets, pkt...)
+	})
+	if len(in.packets) == 0 {
+		return len(p), nil
+	}
+
+	switch in {
+	case r.current:
+		if r.err != nil {
+			return 0, r.err
+		}
+		if _, err := r.writer.Write(in.packets); err != nil {
+			return 0, err
+		}
+	case r.next:
+		select {
+		case <-r.ready:
+		default:
+			close(r.ready)
+		}
+		if len(r.buffer)+len(in.packets) <= RENEW_BUFFER_SIZE {
+			r.buffer = append(r.buffer, in.packets...)
+		}
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,49 @@
+package liveview
+
+import (
+	"amattu2/blink-middleware/pkg/blinkapi"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sweep marks the liveview commands of the camera that clients left running, e.g.
+// after a crash, as done, recovering a camera Blink reports as busy. The command
+// of a livestream this client is running is kept. Call it before Connect when a
+// previous run may have exited uncleanly.
+//
+// ctx: the context of the API requests
+//
+// Example: Sweep(ctx) = []blinkapi.Command{{Id: 123, Command: "lv_relay", ...}}, nil
+func (c *Client) Sweep(ctx context.Context) ([]blinkapi.Command, error) {
+	credentials := c.credentialsSnapshot()
+	commands, err := c.api.ListCommandsContext(ctx, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("error listing commands: %w", err)
+	}
+
+	c.state.mu.Lock()
+	current := 0
+	if c.state.session != nil {
+		current = c.state.session.commandId
+	}
+	c.state.mu.Unlock()
+
+	var stopped []blinkapi.Command
+	var errs []error
+	for _, command := range commands {
+		if command.Command != blinkapi.COMMAND_LIVEVIEW || !command.InFlight() || command.TargetId != credentials.CameraId || command.Id == current {
+			continue
+		}
+
+		if err := c.api.StopCommandContext(ctx, credentials, command.Id); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping command %d: %w", command.Id, err))
+			continue
+		}
+		c.config.OnLog(fmt.Sprintf("Stopped orphaned command %d, started at %s", command.Id, command.CreatedAt.Local().Format(time.DateTime)))
+		stopped = append(stopped, command)
+	}
+
+	return stopped, errors.Join(errs...)
+}
@@ -0,0 +1,94 @@
+package liveview
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLS versions accepted by ParseTLSVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a DER-encoded
+// certificate as colon-separated hex, e.g. to log the certificate of a livestream
+// server and pin it with PinCertificates
+//
+// der: the certificate
+//
+// Example: CertificateFingerprint(state.PeerCertificates[0].Raw) = "3A:0F:...:9C"
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	fingerprint := make([]string, len(sum))
+	for i, b := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(fingerprint, ":")
+}
+
+// PinCertificates returns a tls.Config.VerifyPeerCertificate callback accepting
+// only servers presenting a certificate with one of the SHA-256 fingerprints, in
+// hex with or without colons. Pinning the livestream servers detects interception
+// even though their certificates are not verified against the system roots.
+//
+// fingerprints: the accepted fingerprints
+//
+// Example: PinCertificates("3A:0F:...:9C") = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+func PinCertificates(fingerprints ...string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	pins := map[string]bool{}
+	for _, fingerprint := range fingerprints {
+		pins[strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))] = true
+	}
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("the server presented no certificate")
+		}
+		for _, der := range rawCerts {
+			sum := sha256.Sum256(der)
+			if pins[hex.EncodeToString(sum[:])] {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("the server certificate %s is not pinned", CertificateFingerprint(rawCerts[0]))
+	}
+}
+
+// ParseTLSVersion parses a TLS version for tls.Config.MinVersion
+//
+// version: the version, e.g. "1.3"
+//
+// Example: ParseTLSVersion("1.3") = tls.VersionTLS13, nil
+func ParseTLSVersion(version string) (uint16, error) {
+	parsed, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expecting 1.0, 1.1, 1.2 or 1.3", version)
+	}
+
+	return parsed, nil
+}
+
+// DescribeTLS summarizes a TLS handshake for logs: the version, the cipher suite,
+// and the fingerprint of the server certificate
+//
+// state: the state of the connection
+//
+// Example: DescribeTLS(state) = "TLS 1.3, TLS_AES_128_GCM_SHA256, server certificate SHA-256 3A:0F:...:9C"
+func DescribeTLS(state tls.ConnectionState) string {
+	description := tls.VersionName(state.Version) + ", " + tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) > 0 {
+		description += ", server certificate SHA-256 " + CertificateFingerprint(state.PeerCertificates[0].Raw)
+	}
+
+	return description
+}
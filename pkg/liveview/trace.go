@@ -0,0 +1,51 @@
+package liveview
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"amattu2/blink-middleware/pkg/blinkapi"
+)
+
+// TraceRequests is the API middleware handing every request and response, with
+// the secrets redacted, to a callback
+var TraceRequests = blinkapi.TraceRequests
+
+// APITrace is a Blink API request and its response, as passed to TraceRequests
+type APITrace = blinkapi.Trace
+
+// streamTracer logs hex dumps of the first bytes sent and received on a stream
+// connection
+type streamTracer struct {
+	// The number of bytes dumped in each direction
+	limit int
+	onLog func(string)
+	// Guards the fields below
+	mu       sync.Mutex
+	sent     int
+	received int
+}
+
+func newStreamTracer(limit int, onLog func(string)) *streamTracer {
+	return &streamTracer{limit: limit, onLog: onLog}
+}
+
+// trace logs the part of the data within the limit of its direction
+func (t *streamTracer) trace(sent bool, data []byte) {
+	t.mu.Lock()
+	count, direction := &t.received, "Received"
+	if sent {
+		count, direction = &t.sent, "Sent"
+	}
+	offset := *count
+	n := min(len(data), t.limit-offset)
+	if n <= 0 {
+		t.mu.Unlock()
+		return
+	}
+	*count += n
+	t.mu.Unlock()
+
+	t.onLog(fmt.Sprintf("%s %d bytes at offset %d of the stream connection:\n%s", direction, n, offset, hex.Dump(data[:n])))
+}
@@ -0,0 +1,120 @@
+// Package metrics defines the telemetry interface used by the client, transport,
+// and servers, so that any backend (Prometheus, statsd, OTLP, etc.) can be plugged in.
+//
+// A Prometheus implementation and a no-op implementation are provided.
+package metrics
+
+import "maps"
+
+// Metric names reported by this module
+const (
+	LIVEVIEW_CONNECTS_TOTAL       = "blink_liveview_connects_total"
+	LIVEVIEW_CONNECTED            = "blink_liveview_connected"
+	LIVEVIEW_CONNECT_SECONDS      = "blink_liveview_connect_seconds"
+	LIVEVIEW_SESSION_SECONDS      = "blink_liveview_session_seconds"
+	STREAM_BYTES_TOTAL            = "blink_stream_bytes_total"
+	STREAM_PINGS_TOTAL            = "blink_stream_pings_total"
+	STREAM_ERRORS_TOTAL           = "blink_stream_errors_total"
+	RTSP_SESSIONS                 = "blink_rtsp_sessions"
+	RTMP_PLAYERS                  = "blink_rtmp_players"
+	API_REQUESTS_TOTAL            = "blink_api_requests_total"
+	API_REQUEST_SECONDS           = "blink_api_request_seconds"
+	OUTPUT_DROPPED_BYTES_TOTAL    = "blink_output_dropped_bytes_total"
+	CAMERA_BATTERY_VOLTS          = "blink_camera_battery_volts"
+	CAMERA_BATTERY_LOW            = "blink_camera_battery_low"
+	CAMERA_SIGNAL_BARS            = "blink_camera_signal_bars"
+	CAMERA_TEMPERATURE_FAHRENHEIT = "blink_camera_temperature_fahrenheit"
+	RECORDING_BYTES               = "blink_recording_bytes"
+	RECORDING_PRUNED_FILES_TOTAL  = "blink_recording_pruned_files_total"
+	RECORDING_PRUNED_BYTES_TOTAL  = "blink_recording_pruned_bytes_total"
+	STREAM_VIEWERS                = "blink_stream_viewers"
+	LIMIT_REJECTIONS_TOTAL        = "blink_limit_rejections_total"
+	PROCESS_HEAP_BYTES            = "blink_process_heap_bytes"
+	PROCESS_GOROUTINES            = "blink_process_goroutines"
+)
+
+// Descriptions maps the metric names to their help text
+var Descriptions = map[string]string{
+	LIVEVIEW_CONNECTS_TOTAL:       "Livestream connection attempts by result.",
+	LIVEVIEW_CONNECTED:            "Whether the livestream is connected (1) or not (0).",
+	LIVEVIEW_CONNECT_SECONDS:      "Time taken to initiate the livestream.",
+	LIVEVIEW_SESSION_SECONDS:      "Duration of livestream sessions.",
+	STREAM_BYTES_TOTAL:            "Bytes received from the livestream server.",
+	STREAM_PINGS_TOTAL:            "Keep-alive pings sent to the livestream server.",
+	STREAM_ERRORS_TOTAL:           "Livestream transport errors by reason.",
+	RTSP_SESSIONS:                 "RTSP sessions currently playing a stream.",
+	RTMP_PLAYERS:                  "RTMP players currently connected.",
+	API_REQUESTS_TOTAL:            "Blink API requests by method and status.",
+	API_REQUEST_SECONDS:           "Duration of Blink API requests.",
+	OUTPUT_DROPPED_BYTES_TOTAL:    "Bytes dropped by output buffers that could not keep up.",
+	CAMERA_BATTERY_VOLTS:          "Battery voltage of the camera.",
+	CAMERA_BATTERY_LOW:            "Whether the camera reports a low battery (1) or not (0).",
+	CAMERA_SIGNAL_BARS:            "Signal strength of the camera in bars from 0 to 5, by signal.",
+	CAMERA_TEMPERATURE_FAHRENHEIT: "Temperature reported by the camera.",
+	RECORDING_BYTES:               "Size of the recordings kept per camera after retention.",
+	RECORDING_PRUNED_FILES_TOTAL:  "Recorded files removed by retention.",
+	RECORDING_PRUNED_BYTES_TOTAL:  "Bytes of recordings removed by retention.",
+	STREAM_VIEWERS:                "Viewers currently receiving the livestream of a camera from the server.",
+	LIMIT_REJECTIONS_TOTAL:        "Stream and liveview start requests rejected by the server limits, by limit.",
+	PROCESS_HEAP_BYTES:            "Bytes of heap in use by the server, sampled with a memory budget.",
+	PROCESS_GOROUTINES:            "Goroutines of the server, sampled with a memory budget.",
+}
+
+// Labels are the dimensions of a single series
+type Labels map[string]string
+
+// Metrics receives measurements. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Counter adds delta to a monotonically increasing counter
+	Counter(name string, delta float64, labels Labels)
+	// Gauge sets the current value of a gauge
+	Gauge(name string, value float64, labels Labels)
+	// Histogram records an observation, e.g. a duration in seconds
+	Histogram(name string, value float64, labels Labels)
+}
+
+type noop struct{}
+
+func (noop) Counter(string, float64, Labels)   {}
+func (noop) Gauge(string, float64, Labels)     {}
+func (noop) Histogram(string, float64, Labels) {}
+
+// Noop discards all measurements
+var Noop Metrics = noop{}
+
+type labeled struct {
+	metrics Metrics
+	labels  Labels
+}
+
+// WithLabels returns a Metrics that adds the labels to every measurement. Labels
+// passed to individual measurements take precedence.
+//
+// m: the underlying metrics backend
+//
+// labels: the labels to add
+//
+// Example: WithLabels(m, Labels{"camera": "123"}) = Metrics
+func WithLabels(m Metrics, labels Labels) Metrics {
+	return labeled{metrics: m, labels: labels}
+}
+
+func (l labeled) merge(labels Labels) Labels {
+	merged := make(Labels, len(l.labels)+len(labels))
+	maps.Copy(merged, l.labels)
+	maps.Copy(merged, labels)
+
+	return merged
+}
+
+func (l labeled) Counter(name string, delta float64, labels Labels) {
+	l.metrics.Counter(name, delta, l.merge(labels))
+}
+
+func (l labeled) Gauge(name string, value float64, labels Labels) {
+	l.metrics.Gauge(name, value, l.merge(labels))
+}
+
+func (l labeled) Histogram(name string, value float64, labels Labels) {
+	l.metrics.Histogram(name, value, l.merge(labels))
+}
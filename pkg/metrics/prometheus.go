@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DEFAULT_BUCKETS are the histogram bucket upper bounds, suited to durations in seconds
+var DEFAULT_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+type series struct {
+	labels Labels
+	value  float64
+	// Histogram state
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type family struct {
+	kind   string
+	series map[string]*series
+}
+
+// Prometheus collects measurements in memory and serves them in the Prometheus
+// text exposition format. It implements http.Handler.
+type Prometheus struct {
+	mu       sync.Mutex
+	families map[string]*family
+	buckets  []float64
+}
+
+// NewPrometheus initializes a new Prometheus collector.
+//
+// Example: http.Handle("/metrics", NewPrometheus())
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		families: map[string]*family{},
+		buckets:  DEFAULT_BUCKETS,
+	}
+}
+
+func (p *Prometheus) Counter(name string, delta float64, labels Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.series(name, typeCounter, labels).value += delta
+}
+
+func (p *Prometheus) Gauge(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.series(name, typeGauge, labels).value = value
+}
+
+func (p *Prometheus) Histogram(name string, value float64, labels Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.series(name, typeHistogram, labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(p.buckets))
+	}
+	for i, bound := range p.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// series returns the series for the labels, creating it if necessary. p.mu must be held.
+func (p *Prometheus) series(name string, kind string, labels Labels) *series {
+	f, ok := p.families[name]
+	if !ok {
+		f = &family{kind: kind, series: map[string]*series{}}
+		p.families[name] = f
+	}
+
+	key := formatLabels(labels, "", "")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: labels}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// ServeHTTP writes every metric in the text exposition format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// WriteTo writes every metric in the text exposition format
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := p.families[name]
+		if help, ok := Descriptions[name]; ok {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != typeHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", name, key, formatValue(s.value))
+				continue
+			}
+
+			for i, bound := range p.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(s.labels, "le", formatValue(bound)), s.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, key, formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, key, s.count)
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// formatLabels renders the labels in sorted order, with an optional extra label
+func formatLabels(labels Labels, extraName string, extraValue string) string {
+	pairs := make([]string, 0, len(labels)+1)
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	if extraName != "" {
+		pairs = append(pairs, extraName+"="+strconv.Quote(extraValue))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,329 @@
+package mpegts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// H.264 NAL unit types
+const (
+	H264_NAL_SLICE byte = 1
+	H264_NAL_IDR   byte = 5
+	H264_NAL_SEI   byte = 6
+	H264_NAL_SPS   byte = 7
+	H264_NAL_PPS   byte = 8
+	H264_NAL_AUD   byte = 9
+)
+
+// SplitAnnexB splits an Annex B byte stream into NAL units without start codes
+//
+// data: the Annex B formatted data
+//
+// Example: SplitAnnexB([]byte{0, 0, 0, 1, 0x65, ...}) = [][]byte{{0x65, ...}}
+func SplitAnnexB(data []byte) [][]byte {
+	var nalus [][]byte
+
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			i++
+			continue
+		}
+
+		if start >= 0 {
+			nalus = append(nalus, bytes.TrimRight(data[start:i], "\x00"))
+		}
+		i += 3
+		start = i
+	}
+
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	}
+
+	return nalus
+}
+
+// H264NALType returns the type of an H.264 NAL unit
+func H264NALType(nalu []byte) byte {
+	if len(nalu) == 0 {
+		return 0
+	}
+
+	return nalu[0] & 0x1f
+}
+
+// H265NALType returns the type of an H.265 NAL unit
+func H265NALType(nalu []byte) byte {
+	if len(nalu) == 0 {
+		return 0
+	}
+
+	return nalu[0] >> 1 & 0x3f
+}
+
+// AVCDecoderConfig builds an AVCDecoderConfigurationRecord (ISO/IEC 14496-15)
+//
+// sps: the sequence parameter set NAL unit
+//
+// pps: the picture parameter set NAL unit
+//
+// Example: AVCDecoderConfig(sps, pps) = []byte{0x01, ...}, nil
+func AVCDecoderConfig(sps []byte, pps []byte) ([]byte, error) {
+	if len(sps) < 4 || len(pps) == 0 {
+		return nil, errors.New("invalid SPS or PPS")
+	}
+
+	record := []byte{
+		0x01,   // Configuration version
+		sps[1], // Profile
+		sps[2], // Profile compatibility
+		sps[3], // Level
+		0xff,   // 4-byte NAL unit lengths
+		0xe1,   // One SPS
+	}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(sps)))
+	record = append(record, sps...)
+	record = append(record, 0x01) // One PPS
+	record = binary.BigEndian.AppendUint16(record, uint16(len(pps)))
+	record = append(record, pps...)
+
+	return record, nil
+}
+
+// ADTSFrame is a single AAC frame extracted from an ADTS stream
+type ADTSFrame struct {
+	// The MPEG-4 audio object type (e.g. 2 for AAC-LC)
+	ObjectType byte
+	// The sampling frequency index
+	FrequencyIndex byte
+	// The channel configuration
+	Channels byte
+	// The raw AAC frame without the ADTS header
+	Data []byte
+}
+
+// ADTS_SAMPLE_RATES maps ADTS sampling frequency indexes to sample rates
+var ADTS_SAMPLE_RATES = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// SampleRate returns the sample rate of the frame in Hz
+func (f ADTSFrame) SampleRate() int {
+	if int(f.FrequencyIndex) >= len(ADTS_SAMPLE_RATES) {
+		return 0
+	}
+
+	return ADTS_SAMPLE_RATES[f.FrequencyIndex]
+}
+
+// AudioSpecificConfig returns the MPEG-4 AudioSpecificConfig for the frame
+func (f ADTSFrame) AudioSpecificConfig() []byte {
+	return []byte{
+		f.ObjectType<<3 | f.FrequencyIndex>>1,
+		f.FrequencyIndex<<7 | f.Channels<<3,
+	}
+}
+
+// SplitADTS splits an ADTS byte stream into raw AAC frames
+//
+// data: the ADTS formatted data
+//
+// Example: SplitADTS([]byte{0xff, 0xf1, ...}) = []ADTSFrame{...}
+func SplitADTS(data []byte) []ADTSFrame {
+	var frames []ADTSFrame
+
+	for len(data) >= 7 {
+		if data[0] != 0xff || data[1]&0xf0 != 0xf0 {
+			break
+		}
+
+		headerLength := 7
+		if data[1]&0x01 == 0 {
+			headerLength = 9 // CRC present
+		}
+		frameLength := int(data[3]&0x03)<<11 | int(data[4])<<3 | int(data[5])>>5
+		if frameLength < headerLength || frameLength > len(data) {
+			break
+		}
+
+		frames = append(frames, ADTSFrame{
+			ObjectType:     data[2]>>6 + 1,
+			FrequencyIndex: data[2] >> 2 & 0x0f,
+			Channels:       data[2]&0x01<<2 | data[3]>>6,
+			Data:           data[headerLength:frameLength],
+		})
+		data = data[frameLength:]
+	}
+
+	return frames
+}
+
+// H264Resolution returns the display size coded in an H.264 sequence parameter set,
+// after cropping
+//
+// sps: the sequence parameter set NAL unit
+//
+// Example: H264Resolution(sps) = 1920, 1080, nil
+func H264Resolution(sps []byte) (int, int, error) {
+	if len(sps) < 4 {
+		return 0, 0, errors.New("invalid SPS")
+	}
+
+	r := &bitReader{data: unescapeRBSP(sps[1:])}
+	profile := r.bits(8)
+	r.bits(16) // Constraint flags and level
+	r.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint32(1)
+	separatePlanes := false
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			separatePlanes = r.bits(1) == 1
+		}
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.bits(1) // qpprime_y_zero_transform_bypass_flag
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bits(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bits(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.bits(1) // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMbsOnly := r.bits(1)
+	if frameMbsOnly == 0 {
+		r.bits(1) // mb_adaptive_frame_field_flag
+	}
+	r.bits(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if r.bits(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return 0, 0, fmt.Errorf("invalid SPS: %w", r.err)
+	}
+
+	// Crop offsets are in chroma sample units
+	cropX, cropY := uint32(1), 2-frameMbsOnly
+	if chromaFormat != 0 && !separatePlanes {
+		if chromaFormat != 3 {
+			cropX = 2
+		}
+		if chromaFormat == 1 {
+			cropY *= 2
+		}
+	}
+
+	width := int(widthMbs*16) - int((cropLeft+cropRight)*cropX)
+	height := int((2-frameMbsOnly)*heightMapUnits*16) - int((cropTop+cropBottom)*cropY)
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("invalid SPS dimensions")
+	}
+
+	return width, height, nil
+}
+
+// unescapeRBSP removes the emulation prevention bytes from a NAL unit payload
+func unescapeRBSP(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+
+	return out
+}
+
+// bitReader reads the bits and Exp-Golomb codes of a parameter set. Reading past
+// the end sets err and returns zeros.
+type bitReader struct {
+	data []byte
+	// The offset of the next bit
+	pos int
+	err error
+}
+
+func (r *bitReader) bits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		v = v<<1 | uint32(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return v
+}
+
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros >= 31 {
+			r.err = errors.New("invalid Exp-Golomb code")
+			return 0
+		}
+		zeros++
+	}
+
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v&1 == 1 {
+		return int32(v+1) / 2
+	}
+
+	return -int32(v / 2)
+}
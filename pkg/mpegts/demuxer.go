@@ -0,0 +1,247 @@
+package mpegts
+
+import "bytes"
+
+// AccessUnit is a complete elementary stream payload (one PES packet)
+type AccessUnit struct {
+	// The packet identifier of the elementary stream
+	PID uint16
+	// The stream type from the program map table
+	StreamType byte
+	// Presentation timestamp in 90kHz units
+	PTS int64
+	// Decode timestamp in 90kHz units (equal to PTS when absent)
+	DTS int64
+	// The elementary stream data (e.g. Annex B H.264 or ADTS AAC)
+	Data []byte
+}
+
+// IsVideo returns whether the access unit belongs to a video stream
+func (au AccessUnit) IsVideo() bool {
+	return isVideoStreamType(au.StreamType)
+}
+
+// IsAudio returns whether the access unit belongs to an audio stream
+func (au AccessUnit) IsAudio() bool {
+	return isAudioStreamType(au.StreamType)
+}
+
+// IsKeyframe returns whether the access unit is a video random access point
+func (au AccessUnit) IsKeyframe() bool {
+	switch au.StreamType {
+	case STREAM_TYPE_H264:
+		for _, nalu := range SplitAnnexB(au.Data) {
+			if H264NALType(nalu) == H264_NAL_IDR {
+				return true
+			}
+		}
+	case STREAM_TYPE_H265:
+		for _, nalu := range SplitAnnexB(au.Data) {
+			if t := H265NALType(nalu); t >= 16 && t <= 21 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isVideoStreamType(streamType byte) bool {
+	return streamType == STREAM_TYPE_H264 || streamType == STREAM_TYPE_H265
+}
+
+func isAudioStreamType(streamType byte) bool {
+	switch streamType {
+	case STREAM_TYPE_AAC, STREAM_TYPE_MPEG1_AUDIO, STREAM_TYPE_MPEG2_AUDIO:
+		return true
+	}
+
+	return false
+}
+
+type pesBuffer struct {
+	streamType byte
+	data       []byte
+}
+
+// Demuxer reassembles elementary stream access units from a transport stream.
+// It implements io.Writer, so raw stream bytes may be written to it directly.
+// Bytes that are not part of a transport stream packet are skipped.
+type Demuxer struct {
+	// Callback invoked for each complete access unit
+	onAccessUnit func(AccessUnit)
+	// Incomplete packet bytes carried over between writes
+	pending []byte
+	// PIDs carrying program map tables
+	pmtPIDs map[uint16]bool
+	// Elementary streams keyed by PID
+	streams map[uint16]*pesBuffer
+}
+
+// NewDemuxer initializes a new Demuxer.
+//
+// onAccessUnit: the callback invoked for each complete access unit
+//
+// Example: NewDemuxer(func(au AccessUnit) { ... })
+func NewDemuxer(onAccessUnit func(AccessUnit)) *Demuxer {
+	return &Demuxer{
+		onAccessUnit: onAccessUnit,
+		pmtPIDs:      map[uint16]bool{},
+		streams:      map[uint16]*pesBuffer{},
+	}
+}
+
+// Write feeds raw transport stream bytes to the demuxer.
+func (d *Demuxer) Write(p []byte) (int, error) {
+	d.pending = AlignPackets(append(d.pending, p...), d.WritePacket)
+
+	return len(p), nil
+}
+
+// WritePacket feeds a single aligned transport stream packet to the demuxer.
+func (d *Demuxer) WritePacket(pkt Packet) {
+	if pkt.TransportError() {
+		return
+	}
+
+	pid := pkt.PID()
+	payload := pkt.Payload()
+	if payload == nil {
+		return
+	}
+
+	switch {
+	case pid == PID_PAT:
+		d.parsePAT(payload, pkt.PayloadUnitStart())
+	case d.pmtPIDs[pid]:
+		d.parsePMT(payload, pkt.PayloadUnitStart())
+	default:
+		stream, ok := d.streams[pid]
+		if !ok {
+			return
+		}
+
+		if pkt.PayloadUnitStart() {
+			d.emit(pid, stream)
+			stream.data = append(stream.data[:0], payload...)
+		} else if len(stream.data) > 0 {
+			stream.data = append(stream.data, payload...)
+		}
+	}
+}
+
+// Flush emits any buffered access units. Call when the stream ends.
+func (d *Demuxer) Flush() {
+	for pid, stream := range d.streams {
+		d.emit(pid, stream)
+	}
+}
+
+// Reset discards all buffered data and stream state, e.g. after a reconnect
+func (d *Demuxer) Reset() {
+	d.pending = d.pending[:0]
+	d.pmtPIDs = map[uint16]bool{}
+	d.streams = map[uint16]*pesBuffer{}
+}
+
+// IsPMT returns whether the PID carries a program map table
+func (d *Demuxer) IsPMT(pid uint16) bool {
+	return d.pmtPIDs[pid]
+}
+
+// StreamType returns the stream type of an elementary stream PID
+func (d *Demuxer) StreamType(pid uint16) (byte, bool) {
+	stream, ok := d.streams[pid]
+	if !ok {
+		return 0, false
+	}
+
+	return stream.streamType, true
+}
+
+func (d *Demuxer) emit(pid uint16, stream *pesBuffer) {
+	if len(stream.data) == 0 {
+		return
+	}
+	defer func() {
+		stream.data = stream.data[:0]
+	}()
+
+	header, err := ParsePESHeader(stream.data)
+	if err != nil || header.PTS < 0 {
+		return
+	}
+
+	dts := header.DTS
+	if dts < 0 {
+		dts = header.PTS
+	}
+
+	d.onAccessUnit(AccessUnit{
+		PID:        pid,
+		StreamType: stream.streamType,
+		PTS:        header.PTS,
+		DTS:        dts,
+		Data:       bytes.Clone(stream.data[header.HeaderLength:]),
+	})
+}
+
+// psiSection returns the section data following the pointer field
+func psiSection(payload []byte, unitStart bool) []byte {
+	if !unitStart || len(payload) < 1 {
+		return nil
+	}
+
+	pointer := int(payload[0])
+	if 1+pointer+8 > len(payload) {
+		return nil
+	}
+	section := payload[1+pointer:]
+
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	if 3+length > len(section) || length < 9 {
+		return nil
+	}
+
+	// Exclude the trailing CRC
+	return section[:3+length-4]
+}
+
+func (d *Demuxer) parsePAT(payload []byte, unitStart bool) {
+	section := psiSection(payload, unitStart)
+	if section == nil {
+		return
+	}
+
+	for i := 8; i+4 <= len(section); i += 4 {
+		program := uint16(section[i])<<8 | uint16(section[i+1])
+		if program == 0 {
+			continue // Network information table
+		}
+
+		pid := uint16(section[i+2]&0x1f)<<8 | uint16(section[i+3])
+		d.pmtPIDs[pid] = true
+	}
+}
+
+func (d *Demuxer) parsePMT(payload []byte, unitStart bool) {
+	section := psiSection(payload, unitStart)
+	if section == nil || len(section) < 12 {
+		return
+	}
+
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	for i := 12 + programInfoLength; i+5 <= len(section); {
+		streamType := section[i]
+		pid := uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2])
+		infoLength := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+
+		if stream, ok := d.streams[pid]; ok {
+			stream.streamType = streamType
+		} else {
+			d.streams[pid] = &pesBuffer{streamType: streamType}
+		}
+
+		i += 5 + infoLength
+	}
+}
@@ -0,0 +1,208 @@
+package mpegts
+
+import (
+	"fmt"
+	"io"
+)
+
+// Elementary stream selections for Filter
+const (
+	STREAMS_BOTH  = "both"
+	STREAMS_VIDEO = "video"
+	STREAMS_AUDIO = "audio"
+)
+
+// Filter is an io.Writer that forwards only the selected elementary streams of a
+// transport stream. The program map table is rewritten to list only the selected
+// streams, and the program clock reference is preserved when it is carried by a
+// removed stream, so the output remains a valid transport stream.
+type Filter struct {
+	// The writer receiving the filtered stream
+	writer io.Writer
+	// The selected streams (STREAMS_VIDEO or STREAMS_AUDIO)
+	selection string
+	// Tracks the program tables; only PSI packets are fed to it
+	demuxer *Demuxer
+	// The PID carrying the program clock reference
+	pcrPID uint16
+	// Incomplete packet bytes carried over between writes
+	pending []byte
+	// Output buffer reused between writes
+	out []byte
+}
+
+// NewFilter initializes a new Filter.
+//
+// writer: the writer receiving the filtered stream
+//
+// selection: the streams to keep (STREAMS_BOTH, STREAMS_VIDEO, or STREAMS_AUDIO)
+//
+// Example: NewFilter(os.Stdout, STREAMS_AUDIO) = &Filter{...}, nil
+func NewFilter(writer io.Writer, selection string) (*Filter, error) {
+	switch selection {
+	case STREAMS_BOTH, STREAMS_VIDEO, STREAMS_AUDIO:
+	default:
+		return nil, fmt.Errorf("unsupported stream selection %q", selection)
+	}
+
+	return &Filter{
+		writer:    writer,
+		selection: selection,
+		demuxer:   NewDemuxer(func(AccessUnit) {}),
+		pcrPID:    PID_NULL,
+	}, nil
+}
+
+// Write filters raw transport stream bytes and writes the selected packets
+func (f *Filter) Write(p []byte) (int, error) {
+	if f.selection == STREAMS_BOTH {
+		return f.writer.Write(p)
+	}
+
+	f.out = f.out[:0]
+	f.pending = AlignPackets(append(f.pending, p...), f.writePacket)
+	if len(f.out) == 0 {
+		return len(p), nil
+	}
+
+	if _, err := f.writer.Write(f.out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Discontinuity discards buffered data and program state after the livestream was
+// re-established, and forwards the signal to the underlying writer if supported
+func (f *Filter) Discontinuity() {
+	f.pending = f.pending[:0]
+	f.demuxer.Reset()
+	f.pcrPID = PID_NULL
+
+	if d, ok := f.writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+func (f *Filter) writePacket(pkt Packet) {
+	pid := pkt.PID()
+	switch {
+	case pid == PID_PAT:
+		f.demuxer.WritePacket(pkt)
+		f.out = append(f.out, pkt...)
+	case f.demuxer.IsPMT(pid):
+		f.demuxer.WritePacket(pkt)
+		f.out = append(f.out, f.rewritePMT(pkt)...)
+	case pid == PID_NULL:
+		return
+	default:
+		streamType, ok := f.demuxer.StreamType(pid)
+		if !ok || f.selected(streamType) {
+			f.out = append(f.out, pkt...)
+			return
+		}
+
+		// Keep the clock of a removed stream so players can still synchronize
+		if pid == f.pcrPID {
+			if pcr := pcrOnlyPacket(pkt); pcr != nil {
+				f.out = append(f.out, pcr...)
+			}
+		}
+	}
+}
+
+func (f *Filter) selected(streamType byte) bool {
+	switch f.selection {
+	case STREAMS_VIDEO:
+		return isVideoStreamType(streamType)
+	case STREAMS_AUDIO:
+		return isAudioStreamType(streamType)
+	}
+
+	return true
+}
+
+// rewritePMT returns the PMT packet listing only the selected streams. Tables that
+// span multiple packets are forwarded unchanged.
+func (f *Filter) rewritePMT(pkt Packet) Packet {
+	if pkt.HasAdaptationField() {
+		return pkt
+	}
+
+	section := psiSection(pkt.Payload(), pkt.PayloadUnitStart())
+	if section == nil || len(section) < 12 {
+		return pkt
+	}
+
+	f.pcrPID = uint16(section[8]&0x1f)<<8 | uint16(section[9])
+	programInfoLength := int(section[10]&0x0f)<<8 | int(section[11])
+	if 12+programInfoLength > len(section) {
+		return pkt
+	}
+
+	rewritten := append([]byte(nil), section[:12+programInfoLength]...)
+	for i := 12 + programInfoLength; i+5 <= len(section); {
+		infoLength := int(section[i+3]&0x0f)<<8 | int(section[i+4])
+		end := min(i+5+infoLength, len(section))
+		if f.selected(section[i]) {
+			rewritten = append(rewritten, section[i:end]...)
+		}
+		i = end
+	}
+
+	// The section length covers everything after the length field, including the CRC
+	length := len(rewritten) - 3 + 4
+	rewritten[1] = rewritten[1]&0xf0 | byte(length>>8)&0x0f
+	rewritten[2] = byte(length)
+	crc := crc32MPEG(rewritten)
+	rewritten = append(rewritten, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	out := make(Packet, PACKET_SIZE)
+	copy(out, pkt[:4])
+	out[4] = 0 // Pointer field
+	n := copy(out[5:], rewritten)
+	for i := 5 + n; i < PACKET_SIZE; i++ {
+		out[i] = 0xff
+	}
+
+	return out
+}
+
+// pcrOnlyPacket returns an adaptation-field-only packet carrying the PCR of the
+// packet, or nil if it has none
+func pcrOnlyPacket(pkt Packet) Packet {
+	if _, ok := pkt.PCR(); !ok {
+		return nil
+	}
+
+	out := make(Packet, PACKET_SIZE)
+	out[0] = SYNC_BYTE
+	out[1] = pkt[1] & 0x1f
+	out[2] = pkt[2]
+	out[3] = pkt[3]&0xc0 | 0x20 // Adaptation field only; the counter does not advance
+	out[4] = PACKET_SIZE - 5
+	out[5] = 0x10 // PCR flag
+	copy(out[6:12], pkt[6:12])
+	for i := 12; i < PACKET_SIZE; i++ {
+		out[i] = 0xff
+	}
+
+	return out
+}
+
+// crc32MPEG computes the CRC-32/MPEG-2 checksum used by PSI sections
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
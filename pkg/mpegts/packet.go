@@ -0,0 +1,170 @@
+// Package mpegts provides MPEG transport stream parsing for the livestream data.
+package mpegts
+
+import "bytes"
+
+// PACKET_SIZE is the size of a single transport stream packet.
+const PACKET_SIZE = 188
+
+// SYNC_BYTE is the first byte of every transport stream packet.
+const SYNC_BYTE = 0x47
+
+// Well-known packet identifiers
+const (
+	PID_PAT  uint16 = 0x0000
+	PID_NULL uint16 = 0x1fff
+)
+
+// Elementary stream types found in the program map table
+const (
+	STREAM_TYPE_MPEG1_AUDIO byte = 0x03
+	STREAM_TYPE_MPEG2_AUDIO byte = 0x04
+	STREAM_TYPE_AAC         byte = 0x0f
+	STREAM_TYPE_H264        byte = 0x1b
+	STREAM_TYPE_H265        byte = 0x24
+)
+
+// Packet is a single 188-byte transport stream packet
+type Packet []byte
+
+// PID returns the packet identifier
+func (p Packet) PID() uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+// PayloadUnitStart returns whether the packet starts a new PES packet or PSI section
+func (p Packet) PayloadUnitStart() bool {
+	return p[1]&0x40 != 0
+}
+
+// TransportError returns whether the transport error indicator is set
+func (p Packet) TransportError() bool {
+	return p[1]&0x80 != 0
+}
+
+// HasAdaptationField returns whether the packet carries an adaptation field
+func (p Packet) HasAdaptationField() bool {
+	return p[3]&0x20 != 0
+}
+
+// HasPayload returns whether the packet carries a payload
+func (p Packet) HasPayload() bool {
+	return p[3]&0x10 != 0
+}
+
+// ContinuityCounter returns the 4-bit continuity counter
+func (p Packet) ContinuityCounter() byte {
+	return p[3] & 0x0f
+}
+
+// Payload returns the packet payload, or nil if the packet has none
+func (p Packet) Payload() []byte {
+	if !p.HasPayload() {
+		return nil
+	}
+
+	offset := 4
+	if p.HasAdaptationField() {
+		offset += 1 + int(p[4])
+	}
+	if offset >= PACKET_SIZE {
+		return nil
+	}
+
+	return p[offset:]
+}
+
+// RandomAccess returns whether the adaptation field marks a random access point
+func (p Packet) RandomAccess() bool {
+	return p.HasAdaptationField() && p[4] > 0 && p[5]&0x40 != 0
+}
+
+// PCR returns the program clock reference base (90kHz) if present
+func (p Packet) PCR() (int64, bool) {
+	if !p.HasAdaptationField() || p[4] < 7 || p[5]&0x10 == 0 {
+		return 0, false
+	}
+
+	return int64(p[6])<<25 | int64(p[7])<<17 | int64(p[8])<<9 | int64(p[9])<<1 | int64(p[10])>>7, true
+}
+
+// SetPCR overwrites the program clock reference base, if present
+func (p Packet) SetPCR(pcr int64) bool {
+	if !p.HasAdaptationField() || p[4] < 7 || p[5]&0x10 == 0 {
+		return false
+	}
+
+	pcr &= TIMESTAMP_MASK
+	p[6] = byte(pcr >> 25)
+	p[7] = byte(pcr >> 17)
+	p[8] = byte(pcr >> 9)
+	p[9] = byte(pcr >> 1)
+	p[10] = byte(pcr<<7) | p[10]&0x7f
+
+	return true
+}
+
+// AlignPackets invokes onPacket for every complete packet in the data, skipping
+// bytes until the next sync byte when the stream is misaligned.
+//
+// data: the raw stream bytes
+//
+// onPacket: the callback invoked for each aligned packet
+//
+// Returns the trailing bytes of an incomplete packet, which should be prepended to
+// the next chunk of data. The returned slice shares storage with data.
+//
+// Example: AlignPackets(data, func(pkt Packet) { ... }) = []byte{0x47, ...}
+func AlignPackets(data []byte, onPacket func(Packet)) []byte {
+	offset := 0
+	for len(data)-offset >= PACKET_SIZE {
+		if data[offset] != SYNC_BYTE {
+			next := bytes.IndexByte(data[offset+1:], SYNC_BYTE)
+			if next < 0 {
+				return data[:0]
+			}
+			offset += 1 + next
+			continue
+		}
+
+		onPacket(Packet(data[offset : offset+PACKET_SIZE]))
+		offset += PACKET_SIZE
+	}
+
+	return append(data[:0], data[offset:]...)
+}
+
+// IsKeyframeStart returns whether the packet starts a video access unit that can be
+// decoded independently, based on the random access indicator or the NAL units
+// present in the first packet of the PES payload.
+//
+// pkt: the packet to inspect
+//
+// streamType: the stream type of the packet's PID
+//
+// Example: IsKeyframeStart(pkt, STREAM_TYPE_H264) = true
+func IsKeyframeStart(pkt Packet, streamType byte) bool {
+	if !pkt.PayloadUnitStart() {
+		return false
+	}
+	if pkt.RandomAccess() {
+		return true
+	}
+
+	header, err := ParsePESHeader(pkt.Payload())
+	if err != nil {
+		return false
+	}
+
+	data := pkt.Payload()[header.HeaderLength:]
+	if streamType == STREAM_TYPE_H264 {
+		// The IDR slice may start in a later packet, but the SPS precedes it
+		for _, nalu := range SplitAnnexB(data) {
+			if t := H264NALType(nalu); t == H264_NAL_SPS || t == H264_NAL_IDR {
+				return true
+			}
+		}
+	}
+
+	return AccessUnit{StreamType: streamType, Data: data}.IsKeyframe()
+}
@@ -0,0 +1,120 @@
+package mpegts
+
+import (
+	"errors"
+	"time"
+)
+
+// TIMESTAMP_MASK masks a 33-bit PTS/DTS/PCR base value
+const TIMESTAMP_MASK int64 = 1<<33 - 1
+
+// CLOCK_RATE is the frequency of PTS/DTS timestamps
+const CLOCK_RATE = 90000
+
+// DISCONTINUITY_THRESHOLD is the timestamp jump treated as a new session by the
+// outputs that rebase timestamps
+const DISCONTINUITY_THRESHOLD = 2 * time.Second
+
+// PESHeader describes the header of a PES packet
+type PESHeader struct {
+	// The PES stream ID (e.g. 0xe0 for video, 0xc0 for audio)
+	StreamId byte
+	// Presentation timestamp in 90kHz units, or -1 if absent
+	PTS int64
+	// Decode timestamp in 90kHz units, or -1 if absent
+	DTS int64
+	// Length of the PES header, including the start code
+	HeaderLength int
+}
+
+// ParsePESHeader parses the header at the start of a PES packet
+//
+// data: the PES packet data, starting with the 0x000001 start code
+//
+// Example: ParsePESHeader([]byte{0x00, 0x00, 0x01, 0xe0, ...}) = PESHeader{...}, nil
+func ParsePESHeader(data []byte) (PESHeader, error) {
+	header := PESHeader{PTS: -1, DTS: -1}
+	if len(data) < 9 || data[0] != 0x00 || data[1] != 0x00 || data[2] != 0x01 {
+		return header, errors.New("invalid PES start code")
+	}
+
+	header.StreamId = data[3]
+	header.HeaderLength = 9 + int(data[8])
+	if len(data) < header.HeaderLength {
+		return header, errors.New("truncated PES header")
+	}
+
+	flags := data[7] >> 6
+	if flags&0x02 != 0 && len(data) >= 14 {
+		header.PTS = readTimestamp(data[9:14])
+	}
+	if flags == 0x03 && len(data) >= 19 {
+		header.DTS = readTimestamp(data[14:19])
+	}
+
+	return header, nil
+}
+
+// RewritePESTimestamps applies the given function to the PTS and DTS in place
+//
+// data: the PES packet data, starting with the 0x000001 start code
+//
+// rewrite: the function returning the new value for a timestamp
+//
+// Example: RewritePESTimestamps(data, func(ts int64) int64 { return ts + 900 }) = true
+func RewritePESTimestamps(data []byte, rewrite func(int64) int64) bool {
+	header, err := ParsePESHeader(data)
+	if err != nil || header.PTS < 0 {
+		return false
+	}
+
+	writeTimestamp(data[9:14], rewrite(header.PTS))
+	if header.DTS >= 0 {
+		writeTimestamp(data[14:19], rewrite(header.DTS))
+	}
+
+	return true
+}
+
+// Duration converts a 90kHz timestamp delta to a time.Duration
+//
+// ticks: the timestamp delta in 90kHz units
+//
+// Example: Duration(90000) = time.Second
+func Duration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / CLOCK_RATE
+}
+
+// Ticks converts a time.Duration to 90kHz timestamp units
+//
+// d: the duration to convert
+//
+// Example: Ticks(time.Second) = 90000
+func Ticks(d time.Duration) int64 {
+	return int64(d) * CLOCK_RATE / int64(time.Second)
+}
+
+// TimestampDelta returns b-a for two 33-bit timestamps, accounting for wrap-around
+//
+// Example: TimestampDelta(TIMESTAMP_MASK, 10) = 11
+func TimestampDelta(a int64, b int64) int64 {
+	delta := (b - a) & TIMESTAMP_MASK
+	if delta > TIMESTAMP_MASK/2 {
+		delta -= TIMESTAMP_MASK + 1
+	}
+
+	return delta
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func writeTimestamp(b []byte, ts int64) {
+	ts &= TIMESTAMP_MASK
+	b[0] = b[0]&0xf1 | byte(ts>>29)&0x0e
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14)&0xfe | 0x01
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 0x01
+}
@@ -0,0 +1,72 @@
+package mpegts
+
+import "time"
+
+// Rebaser rewrites access unit timestamps so that they start at zero and never
+// jump backwards, even when the source restarts or its clock jumps.
+type Rebaser struct {
+	// Timestamp jumps larger than this are treated as a discontinuity
+	threshold int64
+	// Offset applied to incoming timestamps
+	offset int64
+	// The last incoming DTS, or -1 before the first access unit
+	lastIn int64
+	// The last outgoing DTS
+	lastOut int64
+	// Whether the next access unit starts a new session
+	rebase bool
+}
+
+// NewRebaser initializes a new Rebaser.
+//
+// threshold: the timestamp jump treated as a discontinuity
+//
+// Example: NewRebaser(2 * time.Second) = &Rebaser{...}
+func NewRebaser(threshold time.Duration) *Rebaser {
+	return &Rebaser{
+		threshold: Ticks(threshold),
+		lastIn:    -1,
+	}
+}
+
+// Discontinuity signals that the next access unit starts a new session. Its
+// timestamps continue from where the previous session ended.
+func (r *Rebaser) Discontinuity() {
+	r.rebase = true
+}
+
+// Rebase rewrites the access unit timestamps in place. It returns whether a
+// discontinuity was applied.
+//
+// au: the access unit to rewrite
+//
+// Example: Rebase(&AccessUnit{...}) = false
+func (r *Rebaser) Rebase(au *AccessUnit) bool {
+	rebased := false
+	switch {
+	case r.lastIn < 0:
+		r.offset = -au.DTS
+	case r.rebase || abs(TimestampDelta(r.lastIn, au.DTS)) > r.threshold:
+		// Continue one frame interval after the last output timestamp
+		r.offset = r.lastOut + Ticks(40*time.Millisecond) - au.DTS
+		rebased = true
+	}
+	r.rebase = false
+	r.lastIn = au.DTS
+
+	au.DTS = (au.DTS + r.offset) & TIMESTAMP_MASK
+	au.PTS = (au.PTS + r.offset) & TIMESTAMP_MASK
+	if TimestampDelta(r.lastOut, au.DTS) > 0 {
+		r.lastOut = au.DTS
+	}
+
+	return rebased
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
@@ -0,0 +1,171 @@
+package mpegts
+
+import (
+	"io"
+)
+
+// PSI_REPEAT_PACKETS is the largest number of packets a Repairer forwards between
+// repetitions of the program tables, about 100ms at the livestream bitrate
+const PSI_REPEAT_PACKETS = 64
+
+// Repairer is an io.Writer that cleans up a transport stream for muxers and
+// players that reject damaged input. Packets are renumbered with continuous
+// continuity counters, packets flagged with a transport error or carrying an
+// invalid adaptation field are dropped (misaligned bytes are skipped until the
+// next sync byte), and the program tables are repeated before every keyframe and
+// at least every PSI_REPEAT_PACKETS packets.
+type Repairer struct {
+	// The writer receiving the repaired stream
+	writer io.Writer
+	// Tracks the program tables; only PSI packets are fed to it
+	demuxer *Demuxer
+	// The continuity counter of the last packet written per PID
+	counters map[uint16]byte
+	// The latest single-packet PAT and PMT, repeated into the stream
+	pat Packet
+	pmt Packet
+	// The number of packets written since the program tables were last written
+	sinceTables int
+	// The number of packets dropped as corrupt
+	dropped int64
+	// Incomplete packet bytes carried over between writes
+	pending []byte
+	// Output buffer reused between writes
+	out []byte
+}
+
+// NewRepairer initializes a new Repairer.
+//
+// writer: the writer receiving the repaired stream
+//
+// Example: NewRepairer(recorder) = &Repairer{...}
+func NewRepairer(writer io.Writer) *Repairer {
+	return &Repairer{
+		writer:   writer,
+		demuxer:  NewDemuxer(func(AccessUnit) {}),
+		counters: map[uint16]byte{},
+	}
+}
+
+// Write repairs raw transport stream bytes and writes the resulting packets
+func (r *Repairer) Write(p []byte) (int, error) {
+	r.out = r.out[:0]
+	r.pending = AlignPackets(append(r.pending, p...), r.writePacket)
+	if len(r.out) == 0 {
+		return len(p), nil
+	}
+
+	if _, err := r.writer.Write(r.out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Dropped returns the number of packets dropped as corrupt
+func (r *Repairer) Dropped() int64 {
+	return r.dropped
+}
+
+// Discontinuity discards buffered data and program state after the livestream was
+// re-established, and forwards the signal to the underlying writer if supported.
+// The continuity counters carry on, so the output stays continuous.
+func (r *Repairer) Discontinuity() {
+	r.pending = r.pending[:0]
+	r.demuxer.Reset()
+	r.pat, r.pmt = nil, nil
+
+	if d, ok := r.writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+func (r *Repairer) writePacket(pkt Packet) {
+	if !validPacket(pkt) {
+		r.dropped++
+		return
+	}
+
+	pid := pkt.PID()
+	switch {
+	case pid == PID_NULL:
+		return
+	case pid == PID_PAT:
+		r.demuxer.WritePacket(pkt)
+		if singleSection(pkt) {
+			r.pat = append(r.pat[:0], pkt...)
+		}
+		r.append(pkt)
+	case r.demuxer.IsPMT(pid):
+		r.demuxer.WritePacket(pkt)
+		if singleSection(pkt) {
+			r.pmt = append(r.pmt[:0], pkt...)
+		}
+		r.append(pkt)
+		r.sinceTables = 0
+	default:
+		streamType, ok := r.demuxer.StreamType(pid)
+		keyframe := ok && isVideoStreamType(streamType) && IsKeyframeStart(pkt, streamType)
+		// Tables written by the camera right before the keyframe are not repeated
+		if keyframe && r.sinceTables > 0 || r.sinceTables >= PSI_REPEAT_PACKETS {
+			r.writeTables()
+		}
+		r.append(pkt)
+	}
+}
+
+// writeTables repeats the latest program tables, once both are known
+func (r *Repairer) writeTables() {
+	if r.pat == nil || r.pmt == nil {
+		return
+	}
+
+	r.append(r.pat)
+	r.append(r.pmt)
+	r.sinceTables = 0
+}
+
+// append adds a packet to the output with the next continuity counter of its PID
+func (r *Repairer) append(pkt Packet) {
+	start := len(r.out)
+	r.out = append(r.out, pkt...)
+	out := Packet(r.out[start:])
+
+	pid := out.PID()
+	counter, seen := r.counters[pid]
+	// The counter only advances with a payload
+	if out.HasPayload() && seen {
+		counter = (counter + 1) & 0x0f
+	} else if !seen {
+		counter = out.ContinuityCounter()
+	}
+	out[3] = out[3]&0xf0 | counter
+	r.counters[pid] = counter
+	r.sinceTables++
+}
+
+// validPacket returns whether a packet is free of transport errors and its
+// adaptation field fits the packet
+func validPacket(pkt Packet) bool {
+	if pkt.TransportError() {
+		return false
+	}
+	// Packets without an adaptation field or payload are reserved
+	if !pkt.HasAdaptationField() && !pkt.HasPayload() {
+		return false
+	}
+	if pkt.HasAdaptationField() {
+		length := int(pkt[4])
+		if (pkt.HasPayload() && length > PACKET_SIZE-6) || length > PACKET_SIZE-5 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// singleSection returns whether a PSI packet carries a whole section, which can be
+// repeated on its own
+func singleSection(pkt Packet) bool {
+	return psiSection(pkt.Payload(), pkt.PayloadUnitStart()) != nil
+}
@@ -0,0 +1,229 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TELEGRAM_API_URL is the Telegram Bot API
+	TELEGRAM_API_URL = "https://api.telegram.org"
+	// PUSHOVER_API_URL is the Pushover message API
+	PUSHOVER_API_URL = "https://api.pushover.net/1/messages.json"
+	// DEFAULT_REQUEST_TIMEOUT bounds each request to a messaging service
+	DEFAULT_REQUEST_TIMEOUT = 30 * time.Second
+	// TELEGRAM_MAX_CAPTION is the maximum length of the caption of a Telegram photo
+	TELEGRAM_MAX_CAPTION = 1024
+)
+
+type TelegramConfig struct {
+	// The token of the bot sending the messages
+	BotToken string
+	// The chat receiving the messages, a chat ID or "@channel"
+	ChatId string
+	// The Bot API URL (defaults to TELEGRAM_API_URL)
+	APIURL string
+	// Optional HTTP client for the requests
+	HTTPClient *http.Client
+}
+
+// Telegram sends messages through a Telegram bot. Snapshots are sent as photos and
+// GIFs as animations, captioned with the message.
+type Telegram struct {
+	// Configuration options for the notifier
+	config TelegramConfig
+}
+
+// NewTelegram initializes a new Telegram notifier with the provided configuration.
+func NewTelegram(config TelegramConfig) *Telegram {
+	if config.APIURL == "" {
+		config.APIURL = TELEGRAM_API_URL
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: DEFAULT_REQUEST_TIMEOUT}
+	}
+
+	return &Telegram{config: config}
+}
+
+func (t *Telegram) Notify(ctx context.Context, message Message) error {
+	uri := fmt.Sprintf("%s/bot%s/", strings.TrimSuffix(t.config.APIURL, "/"), t.config.BotToken)
+	text := message.Title + "\n" + message.Text
+
+	if message.Attachment == nil {
+		body, contentType, err := multipartBody(map[string]string{"chat_id": t.config.ChatId, "text": text}, "", nil)
+		if err != nil {
+			return err
+		}
+		return post(ctx, t.config.HTTPClient, uri+"sendMessage", contentType, body)
+	}
+
+	method, field := "sendPhoto", "photo"
+	if message.Attachment.ContentType == "image/gif" {
+		method, field = "sendAnimation", "animation"
+	}
+	if len(text) > TELEGRAM_MAX_CAPTION {
+		text = strings.ToValidUTF8(text[:TELEGRAM_MAX_CAPTION], "")
+	}
+	body, contentType, err := multipartBody(map[string]string{"chat_id": t.config.ChatId, "caption": text}, field, message.Attachment)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, t.config.HTTPClient, uri+method, contentType, body)
+}
+
+type PushoverConfig struct {
+	// The token of the Pushover application
+	Token string
+	// The user or group key receiving the messages
+	User string
+	// Optional device names receiving the messages, comma-separated. Empty sends to
+	// every device of the user
+	Device string
+	// The message API URL (defaults to PUSHOVER_API_URL)
+	APIURL string
+	// Optional HTTP client for the requests
+	HTTPClient *http.Client
+}
+
+// Pushover sends messages through Pushover, with the snapshot or GIF attached
+type Pushover struct {
+	// Configuration options for the notifier
+	config PushoverConfig
+}
+
+// NewPushover initializes a new Pushover notifier with the provided configuration.
+func NewPushover(config PushoverConfig) *Pushover {
+	if config.APIURL == "" {
+		config.APIURL = PUSHOVER_API_URL
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: DEFAULT_REQUEST_TIMEOUT}
+	}
+
+	return &Pushover{config: config}
+}
+
+func (p *Pushover) Notify(ctx context.Context, message Message) error {
+	fields := map[string]string{
+		"token":   p.config.Token,
+		"user":    p.config.User,
+		"title":   message.Title,
+		"message": message.Text,
+	}
+	if p.config.Device != "" {
+		fields["device"] = p.config.Device
+	}
+	if !message.Event.Timestamp.IsZero() {
+		fields["timestamp"] = fmt.Sprint(message.Event.Timestamp.Unix())
+	}
+
+	body, contentType, err := multipartBody(fields, "attachment", message.Attachment)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, p.config.HTTPClient, p.config.APIURL, contentType, body)
+}
+
+type SlackConfig struct {
+	// The incoming webhook URL of the channel
+	WebhookURL string
+	// Optional HTTP client for the requests
+	HTTPClient *http.Client
+}
+
+// Slack posts messages to a channel through an incoming webhook. Webhooks cannot
+// upload files, so attachments are left out.
+type Slack struct {
+	// Configuration options for the notifier
+	config SlackConfig
+}
+
+// NewSlack initializes a new Slack notifier with the provided configuration.
+func NewSlack(config SlackConfig) *Slack {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: DEFAULT_REQUEST_TIMEOUT}
+	}
+
+	return &Slack{config: config}
+}
+
+func (s *Slack) Notify(ctx context.Context, message Message) error {
+	body, err := json.Marshal(map[string]string{"text": "*" + message.Title + "*\n" + message.Text})
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, s.config.HTTPClient, s.config.WebhookURL, "application/json", body)
+}
+
+// multipartBody encodes form fields and an optional file as multipart/form-data,
+// returning the body and its content type
+func multipartBody(fields map[string]string, field string, attachment *Attachment) ([]byte, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return nil, "", err
+		}
+	}
+	if attachment != nil {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, attachment.Name))
+		header.Set("Content-Type", attachment.ContentType)
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(attachment.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// post sends a request to a messaging service. A response with a 2xx status code
+// counts as delivered.
+func post(ctx context.Context, client *http.Client, uri string, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "blink-middleware")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL of a Telegram request holds the bot token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP Status Code %d %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Types of the notifiers of a configuration file
+const (
+	TYPE_TELEGRAM = "telegram"
+	TYPE_PUSHOVER = "pushover"
+	TYPE_SLACK    = "slack"
+)
+
+// File is the JSON configuration file of the notifications, e.g.
+//
+//	{
+//	  "notifiers": {
+//	    "phone": {"type": "pushover", "token": "...", "user": "..."},
+//	    "family": {"type": "telegram", "bot_token": "...", "chat_id": "-100123"}
+//	  },
+//	  "default": {"triggers": ["offline", "stream_failed"]},
+//	  "cameras": {
+//	    "Front Door": {"triggers": ["doorbell", "motion"], "attachment": "snapshot", "cooldown": "5m"},
+//	    "123456": {"notifiers": ["phone"], "attachment": "animation"}
+//	  }
+//	}
+type File struct {
+	// The notifiers by name
+	Notifiers map[string]Backend `json:"notifiers"`
+	// Rule of the cameras missing from Cameras. Omitted, only those cameras notify
+	Default *Rule `json:"default,omitempty"`
+	// Rules keyed by camera ID or case-insensitive camera name
+	Cameras map[string]Rule `json:"cameras,omitempty"`
+}
+
+// Backend configures a notifier of a configuration file
+type Backend struct {
+	// TYPE_TELEGRAM, TYPE_PUSHOVER or TYPE_SLACK
+	Type string `json:"type"`
+	// The token of the Telegram bot
+	BotToken string `json:"bot_token,omitempty"`
+	// The Telegram chat receiving the messages
+	ChatId string `json:"chat_id,omitempty"`
+	// The token of the Pushover application
+	Token string `json:"token,omitempty"`
+	// The Pushover user or group key
+	User string `json:"user,omitempty"`
+	// Optional Pushover devices receiving the messages
+	Device string `json:"device,omitempty"`
+	// The Slack incoming webhook URL
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Optional URL of the Telegram or Pushover API, e.g. of a proxy
+	APIURL string `json:"api_url,omitempty"`
+}
+
+// Load reads and validates a configuration file and builds its notifiers. The
+// caller sets the remaining options of the returned configuration, e.g. Attach.
+//
+// path: the JSON configuration file
+//
+// Example: Load("notify.json") = Config{Notifiers: {"phone": &Pushover{...}}, ...}, nil
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Config{}, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	config, err := file.Config()
+	if err != nil {
+		return Config{}, fmt.Errorf("error in %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// Config validates the file and builds its notifiers
+//
+// Example: file.Config() = Config{Notifiers: {"phone": &Pushover{...}}, ...}, nil
+func (f File) Config() (Config, error) {
+	config := Config{
+		Notifiers: map[string]Notifier{},
+		Default:   f.Default,
+		Cameras:   f.Cameras,
+	}
+	if len(f.Notifiers) == 0 {
+		return config, fmt.Errorf("no notifiers are configured")
+	}
+
+	for name, backend := range f.Notifiers {
+		var missing bool
+		switch backend.Type {
+		case TYPE_TELEGRAM:
+			missing = backend.BotToken == "" || backend.ChatId == ""
+			config.Notifiers[name] = NewTelegram(TelegramConfig{BotToken: backend.BotToken, ChatId: backend.ChatId, APIURL: backend.APIURL})
+		case TYPE_PUSHOVER:
+			missing = backend.Token == "" || backend.User == ""
+			config.Notifiers[name] = NewPushover(PushoverConfig{Token: backend.Token, User: backend.User, Device: backend.Device, APIURL: backend.APIURL})
+		case TYPE_SLACK:
+			missing = backend.WebhookURL == ""
+			config.Notifiers[name] = NewSlack(SlackConfig{WebhookURL: backend.WebhookURL})
+		default:
+			return config, fmt.Errorf("notifier %q has unknown type %q, expecting %s, %s or %s", name, backend.Type, TYPE_TELEGRAM, TYPE_PUSHOVER, TYPE_SLACK)
+		}
+		if missing {
+			return config, fmt.Errorf("notifier %q is missing its %s credentials", name, backend.Type)
+		}
+	}
+
+	rules := map[string]Rule{}
+	for camera, rule := range f.Cameras {
+		rules["camera "+camera] = rule
+	}
+	if f.Default != nil {
+		rules["default"] = *f.Default
+	}
+	for name, rule := range rules {
+		for _, trigger := range rule.Triggers {
+			if !slices.Contains([]string{TRIGGER_MOTION, TRIGGER_DOORBELL, TRIGGER_STREAM_FAILED, TRIGGER_OFFLINE}, trigger) {
+				return config, fmt.Errorf("%s has unknown trigger %q", name, trigger)
+			}
+		}
+		for _, notifier := range rule.Notifiers {
+			if _, ok := f.Notifiers[notifier]; !ok {
+				return config, fmt.Errorf("%s sends to unknown notifier %q", name, notifier)
+			}
+		}
+		if rule.Attachment != ATTACH_NONE && rule.Attachment != ATTACH_SNAPSHOT && rule.Attachment != ATTACH_ANIMATION {
+			return config, fmt.Errorf("%s has unknown attachment %q, expecting %s or %s", name, rule.Attachment, ATTACH_SNAPSHOT, ATTACH_ANIMATION)
+		}
+		if rule.Cooldown != "" {
+			if _, err := time.ParseDuration(rule.Cooldown); err != nil {
+				return config, fmt.Errorf("%s has invalid cooldown: %w", name, err)
+			}
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,306 @@
+// Package notify sends messages about camera events to messaging services
+// (Telegram, Pushover and Slack), optionally with a snapshot or an animated image
+// of the event attached.
+//
+// Which triggers notify which services is configured per camera, usually in a JSON
+// file read by Load. The Dispatcher matches each event against the configuration,
+// suppresses repeats within the cooldown of the camera, and delivers the message
+// to every configured notifier concurrently.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Triggers of a notification
+const (
+	// A camera detected motion
+	TRIGGER_MOTION = "motion"
+	// The button of a doorbell was pressed
+	TRIGGER_DOORBELL = "doorbell"
+	// The livestream of a camera failed to start
+	TRIGGER_STREAM_FAILED = "stream_failed"
+	// A camera went offline
+	TRIGGER_OFFLINE = "offline"
+)
+
+// Attachments of a notification
+const (
+	ATTACH_NONE      = ""
+	ATTACH_SNAPSHOT  = "snapshot"
+	ATTACH_ANIMATION = "animation"
+)
+
+// DEFAULT_TIMEOUT bounds the delivery of a notification, including its attachment
+const DEFAULT_TIMEOUT = time.Minute
+
+// Event is an occurrence on a camera that may trigger notifications
+type Event struct {
+	// What happened, one of the TRIGGER_ constants
+	Trigger string
+	// The name of the camera, if known
+	Camera string
+	// The ID of the camera
+	CameraId int
+	// The ID of the network the camera belongs to
+	NetworkId int
+	// When the event occurred (defaults to now)
+	Timestamp time.Time
+	// Optional details added to the message, e.g. the error of a failed livestream
+	Detail string
+	// Optional URL of the thumbnail Blink recorded of the event, for Attach
+	ThumbnailURL string
+	// Optional URL of the clip Blink recorded of the event, for Attach
+	ClipURL string
+}
+
+// Attachment is an image sent with a notification
+type Attachment struct {
+	// The file name, e.g. "snapshot.jpg"
+	Name string
+	// The MIME type, e.g. "image/jpeg"
+	ContentType string
+	// The image
+	Data []byte
+}
+
+// Message is a notification delivered by a Notifier
+type Message struct {
+	// A short summary, e.g. "Front Door: motion"
+	Title string
+	// The notification text
+	Text string
+	// The event the message describes
+	Event Event
+	// Optional image of the event
+	Attachment *Attachment
+}
+
+// Notifier delivers messages to a messaging service
+type Notifier interface {
+	// Notify delivers the message, or returns why it could not
+	Notify(ctx context.Context, message Message) error
+}
+
+// Rule configures the notifications of a camera
+type Rule struct {
+	// The triggers that notify, e.g. TRIGGER_MOTION. Empty notifies on every trigger
+	Triggers []string `json:"triggers,omitempty"`
+	// The names of the notifiers the messages are sent to. Empty sends to every
+	// notifier
+	Notifiers []string `json:"notifiers,omitempty"`
+	// The image attached to motion and doorbell messages, ATTACH_SNAPSHOT or
+	// ATTACH_ANIMATION. Messages without an image are sent when it is unavailable
+	Attachment string `json:"attachment,omitempty"`
+	// Minimum time between messages of a trigger, e.g. "5m". Empty sends every
+	// message
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+type Config struct {
+	// The notifiers by name
+	Notifiers map[string]Notifier
+	// Rule of the cameras missing from Cameras, or nil to notify only about them
+	Default *Rule
+	// Rules keyed by camera ID or case-insensitive camera name
+	Cameras map[string]Rule
+	// Optional callback fetching the attachment of an event, with the attachment
+	// kind of its rule
+	Attach func(ctx context.Context, event Event, kind string) (*Attachment, error)
+	// Maximum time to deliver a notification (defaults to DEFAULT_TIMEOUT)
+	Timeout time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Dispatcher sends notifications about events according to the rule of each camera
+type Dispatcher struct {
+	// Configuration options for the dispatcher
+	config Config
+	// Guards the fields below
+	mu sync.Mutex
+	// When each camera last notified about each trigger, keyed by camera ID and
+	// trigger
+	last map[string]time.Time
+}
+
+// New initializes a new Dispatcher with the provided configuration.
+func New(config Config) *Dispatcher {
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_TIMEOUT
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	return &Dispatcher{
+		config: config,
+		last:   map[string]time.Time{},
+	}
+}
+
+// Wants reports whether any camera rule notifies about a trigger, e.g. to skip
+// watching for it
+//
+// trigger: one of the TRIGGER_ constants
+//
+// Example: Wants("offline") = true
+func (d *Dispatcher) Wants(trigger string) bool {
+	rules := make([]Rule, 0, len(d.config.Cameras)+1)
+	for _, rule := range d.config.Cameras {
+		rules = append(rules, rule)
+	}
+	if d.config.Default != nil {
+		rules = append(rules, *d.config.Default)
+	}
+	for _, rule := range rules {
+		if len(rule.Triggers) == 0 || contains(rule.Triggers, trigger) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Dispatch sends a notification about the event to the notifiers of its camera
+// rule, unless the rule ignores the trigger or it notified within its cooldown.
+// Notifiers are called concurrently; the errors of those that failed are joined.
+//
+// ctx: the context cancelling the delivery
+//
+// event: the event to notify about
+//
+// Example: Dispatch(ctx, Event{Trigger: TRIGGER_MOTION, Camera: "Front Door", CameraId: 123}) = nil
+func (d *Dispatcher) Dispatch(ctx context.Context, event Event) error {
+	rule, ok := d.rule(event)
+	if !ok || (len(rule.Triggers) > 0 && !contains(rule.Triggers, event.Trigger)) {
+		return nil
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	cooldown, _ := time.ParseDuration(rule.Cooldown)
+	key := strconv.Itoa(event.CameraId) + "/" + event.Trigger
+	d.mu.Lock()
+	if last, ok := d.last[key]; ok && event.Timestamp.Sub(last) < cooldown {
+		d.mu.Unlock()
+		return nil
+	}
+	d.last[key] = event.Timestamp
+	d.mu.Unlock()
+
+	notifiers := map[string]Notifier{}
+	for name, notifier := range d.config.Notifiers {
+		if len(rule.Notifiers) == 0 || contains(rule.Notifiers, name) {
+			notifiers[name] = notifier
+		}
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+	defer cancel()
+
+	message := Format(event)
+	// Only events with recorded media have an image of them
+	if rule.Attachment != ATTACH_NONE && d.config.Attach != nil && (event.Trigger == TRIGGER_MOTION || event.Trigger == TRIGGER_DOORBELL) {
+		attachment, err := d.config.Attach(ctx, event, rule.Attachment)
+		if err != nil {
+			d.config.OnLog(fmt.Sprintf("Sending the %s notification of camera %d without a %s: %v", event.Trigger, event.CameraId, rule.Attachment, err))
+		}
+		message.Attachment = attachment
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for name, notifier := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := notifier.Notify(ctx, message); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("error notifying %s: %w", name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// rule returns the rule of the camera of an event, by ID, then by name, then the
+// default rule
+func (d *Dispatcher) rule(event Event) (Rule, bool) {
+	if rule, ok := d.config.Cameras[strconv.Itoa(event.CameraId)]; ok {
+		return rule, true
+	}
+	if event.Camera != "" {
+		for name, rule := range d.config.Cameras {
+			if strings.EqualFold(name, event.Camera) {
+				return rule, true
+			}
+		}
+	}
+	if d.config.Default != nil {
+		return *d.config.Default, true
+	}
+
+	return Rule{}, false
+}
+
+// Format returns the message describing an event, without an attachment
+//
+// event: the event to describe
+//
+// Example: Format(Event{Trigger: TRIGGER_DOORBELL, Camera: "Front Door"}) = Message{Title: "Front Door: doorbell", Text: "Front Door was pressed at 12:34:56", ...}
+func Format(event Event) Message {
+	camera := event.Camera
+	if camera == "" {
+		camera = fmt.Sprintf("Camera %d", event.CameraId)
+	}
+	at := event.Timestamp.Local().Format(time.TimeOnly)
+
+	var text string
+	switch event.Trigger {
+	case TRIGGER_MOTION:
+		text = fmt.Sprintf("Motion detected by %s at %s", camera, at)
+	case TRIGGER_DOORBELL:
+		text = fmt.Sprintf("%s was pressed at %s", camera, at)
+	case TRIGGER_STREAM_FAILED:
+		text = fmt.Sprintf("The livestream of %s failed to start at %s", camera, at)
+	case TRIGGER_OFFLINE:
+		text = fmt.Sprintf("%s went offline at %s", camera, at)
+	default:
+		text = fmt.Sprintf("%s reported %s at %s", camera, event.Trigger, at)
+	}
+	if event.Detail != "" {
+		text += ": " + event.Detail
+	}
+
+	return Message{
+		Title: camera + ": " + strings.ReplaceAll(event.Trigger, "_", " "),
+		Text:  text,
+		Event: event,
+	}
+}
+
+// contains reports whether a list holds a value, ignoring case
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
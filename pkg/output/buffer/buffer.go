@@ -0,0 +1,284 @@
+// Package buffer decouples an output from the livestream with a bounded ring buffer,
+// so a slow output (a slow disk or network client) does not stall the connection
+// to the camera until it times out.
+//
+// Stream data is queued as whole transport stream packets and written to the output
+// by a separate goroutine. When the buffer is full, the overflow policy either drops
+// the new packets or disconnects the output.
+package buffer
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DEFAULT_SIZE is the default capacity of the buffer in bytes
+	DEFAULT_SIZE = 4 << 20
+	// DEFAULT_FLUSH_TIMEOUT is how long Close waits for the output by default
+	DEFAULT_FLUSH_TIMEOUT = 5 * time.Second
+)
+
+// Overflow policies
+const (
+	// POLICY_DROP drops new packets while the buffer is full. The output sees a gap
+	// in the stream but stays connected
+	POLICY_DROP = "drop"
+	// POLICY_DISCONNECT fails the write that overflows the buffer, which ends the
+	// stream for the output
+	POLICY_DISCONNECT = "disconnect"
+)
+
+// ErrOverflow is returned by Write when the buffer overflows with POLICY_DISCONNECT
+var ErrOverflow = errors.New("output buffer overflow")
+
+type Config struct {
+	// The capacity of the buffer in bytes, rounded down to whole packets (defaults
+	// to DEFAULT_SIZE)
+	Size int
+	// The overflow policy, POLICY_DROP or POLICY_DISCONNECT (defaults to POLICY_DROP)
+	Policy string
+	// The name of the output used in logs and metric labels (e.g. "record";
+	// defaults to "default")
+	Name string
+	// How long Close waits for the queued data to be written before giving up on
+	// a stuck output (defaults to DEFAULT_FLUSH_TIMEOUT)
+	FlushTimeout time.Duration
+	// Optional metrics backend for dropped bytes
+	Metrics metrics.Metrics
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Writer queues stream data for the output in a ring buffer
+type Writer struct {
+	// Configuration options for the buffer
+	config Config
+	// The output receiving the stream
+	writer io.Writer
+	// Incomplete packet bytes carried over between writes. Only used by Write
+	pending []byte
+	// Guards the fields below
+	mu sync.Mutex
+	// Signals changes of the fields below
+	cond *sync.Cond
+	// The ring of queued packets
+	ring []byte
+	// The offset of the oldest queued byte
+	head int
+	// The number of queued bytes
+	length int
+	// The number of bytes dropped during the current overflow
+	dropped int
+	// The error of the output or the overflow, returned by later writes
+	err error
+	// Whether Close was called
+	closed bool
+	// Closed when the drain goroutine has returned
+	done chan struct{}
+}
+
+// New initializes a new buffer in front of the writer and starts writing queued
+// data to it.
+//
+// writer: the output receiving the stream
+//
+// config: the buffer configuration
+//
+// Example: New(recorder, Config{Size: 8 << 20, Policy: POLICY_DISCONNECT}) = &Writer{...}, nil
+func New(writer io.Writer, config Config) (*Writer, error) {
+	if config.Size == 0 {
+		config.Size = DEFAULT_SIZE
+	}
+	if config.Size < mpegts.PACKET_SIZE {
+		return nil, fmt.Errorf("buffer size %d is smaller than a packet", config.Size)
+	}
+	switch config.Policy {
+	case "":
+		config.Policy = POLICY_DROP
+	case POLICY_DROP, POLICY_DISCONNECT:
+	default:
+		return nil, fmt.Errorf("unsupported overflow policy %q", config.Policy)
+	}
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = DEFAULT_FLUSH_TIMEOUT
+	}
+	if config.Metrics == nil {
+		config.Metrics = metrics.Noop
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	w := &Writer{
+		config: config,
+		writer: writer,
+		ring:   make([]byte, config.Size/mpegts.PACKET_SIZE*mpegts.PACKET_SIZE),
+		done:   make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.drain()
+
+	return w, nil
+}
+
+// Write queues the whole packets of p without blocking on the output. It fails
+// once the output has failed, the buffer has overflowed with POLICY_DISCONNECT,
+// or the buffer is closed.
+func (w *Writer) Write(p []byte) (int, error) {
+	var packets []byte
+	w.pending = mpegts.AlignPackets(append(w.pending, p...), func(pkt mpegts.Packet) {
+		packets = append(packets, pkt...)
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	for len(packets) > 0 {
+		if len(w.ring)-w.length < mpegts.PACKET_SIZE {
+			if w.config.Policy == POLICY_DISCONNECT {
+				w.err = fmt.Errorf("output %s: %w of %d bytes", w.config.Name, ErrOverflow, len(w.ring))
+				w.config.OnLog(fmt.Sprintf("Disconnecting output %s: it is not keeping up with the stream", w.config.Name))
+				w.cond.Broadcast()
+				return 0, w.err
+			}
+			if w.dropped == 0 {
+				w.config.OnLog(fmt.Sprintf("Output %s is not keeping up with the stream, dropping data", w.config.Name))
+			}
+			w.dropped += len(packets)
+			w.config.Metrics.Counter(metrics.OUTPUT_DROPPED_BYTES_TOTAL, float64(len(packets)), metrics.Labels{"output": w.config.Name})
+			break
+		}
+
+		// Copy as many packets as fit up to the end of the ring
+		tail := (w.head + w.length) % len(w.ring)
+		space := min(len(w.ring)-w.length, len(w.ring)-tail)
+		n := copy(w.ring[tail:tail+space/mpegts.PACKET_SIZE*mpegts.PACKET_SIZE], packets)
+		w.length += n
+		packets = packets[n:]
+	}
+	w.cond.Signal()
+
+	return len(p), nil
+}
+
+// Discontinuity waits until the queued data is written, then signals the output
+// that the stream restarts if it supports it
+func (w *Writer) Discontinuity() {
+	w.mu.Lock()
+	for w.length > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+
+	if d, ok := w.writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// Flush waits until the queued data is written to the output, and returns the error
+// of the output if it failed
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	for w.length > 0 && w.err == nil {
+		w.cond.Wait()
+	}
+	err := w.err
+	w.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+// Buffered returns the number of bytes waiting to be written to the output
+func (w *Writer) Buffered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.length
+}
+
+// Close writes the queued data to the output and stops the buffer. The output
+// itself is not closed. If the output does not accept the data within the flush
+// timeout, the rest is discarded once the output is closed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-time.After(w.config.FlushTimeout):
+		return fmt.Errorf("output %s did not accept %d buffered bytes within %s", w.config.Name, w.Buffered(), w.config.FlushTimeout)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if errors.Is(w.err, ErrOverflow) {
+		return nil
+	}
+
+	return w.err
+}
+
+// drain writes the queued data to the output until the buffer is closed and empty
+// or the output fails
+func (w *Writer) drain() {
+	defer close(w.done)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for {
+		for w.length == 0 && !w.closed && w.err == nil {
+			w.cond.Wait()
+		}
+		if w.err != nil || w.length == 0 {
+			return
+		}
+
+		// Write the queued data up to the end of the ring without holding the lock.
+		// Write only appends after the queued data, so the segment is not modified.
+		segment := w.ring[w.head : w.head+min(w.length, len(w.ring)-w.head)]
+		w.mu.Unlock()
+		_, err := w.writer.Write(segment)
+		w.mu.Lock()
+
+		if err != nil {
+			w.err = err
+			w.cond.Broadcast()
+			return
+		}
+		w.head = (w.head + len(segment)) % len(w.ring)
+		w.length -= len(segment)
+		if w.dropped > 0 && w.length == 0 {
+			w.config.OnLog(fmt.Sprintf("Output %s caught up after dropping %d bytes", w.config.Name, w.dropped))
+			w.dropped = 0
+		}
+		w.cond.Broadcast()
+	}
+}
@@ -0,0 +1,291 @@
+// Package exec provides an output that pipes the livestream into the standard
+// input of an external command such as ffplay, ffmpeg, or vlc.
+//
+// The command is restarted if it crashes mid-stream. When it exits cleanly (e.g.
+// the player window was closed) or keeps crashing, writes fail so that the exit
+// propagates as a stream error.
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	osExec "os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrExited is returned by Write once the command has exited for good
+var ErrExited = errors.New("command exited")
+
+type Config struct {
+	// The command to run (e.g. "ffplay")
+	Command string
+	// The command arguments. "{name}" placeholders are replaced with values from Vars
+	Args []string
+	// Values substituted into the argument template
+	Vars map[string]string
+	// The number of restarts allowed after crashes (defaults to 3, negative disables restarts)
+	MaxRestarts int
+	// The delay before restarting a crashed command (defaults to 1s)
+	RestartDelay time.Duration
+	// The time Close waits for the command to exit after closing its standard input
+	// before killing it (defaults to 2s)
+	ExitTimeout time.Duration
+	// Optional writer receiving the command's stdout and stderr (defaults to discarding them)
+	Output io.Writer
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Sink struct {
+	// Configuration options for the sink
+	config Config
+	// The expanded command arguments
+	args []string
+	// Guards the fields below
+	mu sync.Mutex
+	// The running command and its stdin, or nil while restarting
+	cmd   *osExec.Cmd
+	stdin io.WriteCloser
+	// Closed when the running command exits
+	exited chan struct{}
+	// The number of restarts so far
+	restarts int
+	// The terminal error returned by Write
+	err error
+	// Whether Close was called
+	closed bool
+}
+
+// Start runs the command and returns a sink writing to its standard input.
+//
+// config: the sink configuration
+//
+// Example: Start(Config{Command: "ffplay", Args: []string{"-f", "mpegts", "-"}}) = &Sink{...}, nil
+func Start(config Config) (*Sink, error) {
+	if config.MaxRestarts == 0 {
+		config.MaxRestarts = 3
+	}
+	if config.RestartDelay <= 0 {
+		config.RestartDelay = time.Second
+	}
+	if config.ExitTimeout <= 0 {
+		config.ExitTimeout = 2 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	s := &Sink{
+		config: config,
+		args:   ExpandArgs(config.Args, config.Vars),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write feeds data to the command's standard input. Data written while a crashed
+// command is being restarted is dropped.
+func (s *Sink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		defer s.mu.Unlock()
+		return 0, s.err
+	}
+	if s.stdin == nil {
+		s.mu.Unlock()
+		return len(p), nil
+	}
+
+	stdin, exited := s.stdin, s.exited
+	s.mu.Unlock()
+
+	if _, err := stdin.Write(p); err != nil {
+		// The write fails before the exit is observed; wait to learn whether the
+		// command is being restarted
+		select {
+		case <-exited:
+		case <-time.After(2 * time.Second):
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.err != nil {
+			return 0, s.err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close closes the command's standard input so that it sees the end of the stream,
+// and waits up to ExitTimeout for it to exit before killing it
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.err == nil {
+		s.err = ErrExited
+	}
+
+	cmd, stdin, exited := s.cmd, s.stdin, s.exited
+	s.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	stdin.Close()
+	select {
+	case <-exited:
+	case <-time.After(s.config.ExitTimeout):
+		s.config.OnLog(fmt.Sprintf("%s did not exit within %s, killing it", s.config.Command, s.config.ExitTimeout))
+		cmd.Process.Kill()
+		<-exited
+	}
+
+	return nil
+}
+
+// start runs a new instance of the command. s.mu must be held.
+func (s *Sink) start() error {
+	cmd := osExec.Command(s.config.Command, s.args...)
+	cmd.Stdout = s.config.Output
+	cmd.Stderr = s.config.Output
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating %s stdin pipe: %w", s.config.Command, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %w", s.config.Command, err)
+	}
+
+	exited := make(chan struct{})
+	s.cmd, s.stdin, s.exited = cmd, stdin, exited
+	go s.wait(cmd, exited)
+
+	return nil
+}
+
+// wait observes the command exit and restarts it after a crash
+func (s *Sink) wait(cmd *osExec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(exited)
+
+	s.cmd, s.stdin = nil, nil
+	switch {
+	case s.closed:
+		return
+	case err == nil:
+		s.err = fmt.Errorf("%w: %s exited", ErrExited, s.config.Command)
+		s.config.OnLog(fmt.Sprintf("%s exited", s.config.Command))
+		return
+	case s.config.MaxRestarts < 0 || s.restarts >= s.config.MaxRestarts:
+		s.err = fmt.Errorf("%w: %s: %v", ErrExited, s.config.Command, err)
+		s.config.OnLog(fmt.Sprintf("%s crashed (%v), giving up", s.config.Command, err))
+		return
+	}
+
+	s.restarts++
+	s.config.OnLog(fmt.Sprintf("%s crashed (%v), restarting (%d/%d)", s.config.Command, err, s.restarts, s.config.MaxRestarts))
+
+	go func() {
+		time.Sleep(s.config.RestartDelay)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return
+		}
+		if err := s.start(); err != nil {
+			s.err = fmt.Errorf("%w: %v", ErrExited, err)
+			s.config.OnLog(err.Error())
+		}
+	}()
+}
+
+// ExpandArgs replaces "{name}" placeholders in the arguments with their values
+//
+// args: the argument template
+//
+// vars: the placeholder values
+//
+// Example: ExpandArgs([]string{"-window_title", "{title}"}, map[string]string{"title": "Blink"}) = []string{"-window_title", "Blink"}
+func ExpandArgs(args []string, vars map[string]string) []string {
+	pairs := make([]string, 0, len(vars)*2)
+	for name, value := range vars {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = replacer.Replace(arg)
+	}
+
+	return expanded
+}
+
+// SplitArgs splits a command line into arguments. Single and double quotes group
+// words, and a backslash escapes the next character outside single quotes.
+//
+// line: the command line to split
+//
+// Example: SplitArgs(`-window_title "Front Door" -`) = []string{"-window_title", "Front Door", "-"}, nil
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg, escaped := false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape in arguments")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,270 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+// MOVIE_TIMESCALE is the timescale of the movie header, in units per second
+const MOVIE_TIMESCALE = 1000
+
+// The unity matrix of the movie and track headers
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// box serializes an ISO BMFF box
+func box(kind string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+
+	out := make([]byte, 0, size)
+	out = binary.BigEndian.AppendUint32(out, uint32(size))
+	out = append(out, kind...)
+	for _, payload := range payloads {
+		out = append(out, payload...)
+	}
+
+	return out
+}
+
+// fullBox serializes an ISO BMFF box with a version and flags
+func fullBox(kind string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+
+	return box(kind, append([][]byte{header}, payloads...)...)
+}
+
+// u16, u32, and u64 append big endian integers, for building box payloads
+func u16(b []byte, v uint16) []byte { return binary.BigEndian.AppendUint16(b, v) }
+func u32(b []byte, v uint32) []byte { return binary.BigEndian.AppendUint32(b, v) }
+func u64(b []byte, v uint64) []byte { return binary.BigEndian.AppendUint64(b, v) }
+
+// ftypBox returns the file type box
+func ftypBox() []byte {
+	return box("ftyp", []byte("isom"), u32(nil, 0x200), []byte("isomiso2avc1mp41"))
+}
+
+// moovBox returns the movie box describing the tracks
+func moovBox(tracks []*track) []byte {
+	var duration uint32
+	var traks [][]byte
+	for i, t := range tracks {
+		duration = max(duration, t.movieDuration())
+		traks = append(traks, t.trakBox(uint32(i+1)))
+	}
+
+	mvhd := u32(nil, 0) // Creation time
+	mvhd = u32(mvhd, 0) // Modification time
+	mvhd = u32(mvhd, MOVIE_TIMESCALE)
+	mvhd = u32(mvhd, duration)
+	mvhd = u32(mvhd, 0x00010000) // Rate 1.0
+	mvhd = u16(mvhd, 0x0100)     // Volume 1.0
+	mvhd = append(mvhd, make([]byte, 10)...)
+	for _, v := range unityMatrix {
+		mvhd = u32(mvhd, v)
+	}
+	mvhd = append(mvhd, make([]byte, 24)...)
+	mvhd = u32(mvhd, uint32(len(tracks)+1)) // Next track ID
+
+	return box("moov", append([][]byte{fullBox("mvhd", 0, 0, mvhd)}, traks...)...)
+}
+
+// trakBox returns the track box of a track
+func (t *track) trakBox(id uint32) []byte {
+	tkhd := u32(nil, 0) // Creation time
+	tkhd = u32(tkhd, 0) // Modification time
+	tkhd = u32(tkhd, id)
+	tkhd = u32(tkhd, 0) // Reserved
+	tkhd = u32(tkhd, t.movieDuration())
+	tkhd = append(tkhd, make([]byte, 8)...)
+	tkhd = u16(tkhd, 0) // Layer
+	tkhd = u16(tkhd, 0) // Alternate group
+	if t.video {
+		tkhd = u16(tkhd, 0)
+	} else {
+		tkhd = u16(tkhd, 0x0100) // Volume 1.0
+	}
+	tkhd = u16(tkhd, 0) // Reserved
+	for _, v := range unityMatrix {
+		tkhd = u32(tkhd, v)
+	}
+	tkhd = u32(tkhd, uint32(t.width)<<16)
+	tkhd = u32(tkhd, uint32(t.height)<<16)
+
+	parts := [][]byte{fullBox("tkhd", 0, 3, tkhd)} // Enabled and in movie
+	if edts := t.edtsBox(); edts != nil {
+		parts = append(parts, edts)
+	}
+	parts = append(parts, t.mdiaBox())
+
+	return box("trak", parts...)
+}
+
+// edtsBox returns the edit list delaying a track that starts after the movie, or
+// nil if it starts with the movie
+func (t *track) edtsBox() []byte {
+	if t.start <= 0 {
+		return nil
+	}
+
+	elst := u32(nil, 2)
+	// An empty edit for the delay
+	elst = u32(elst, uint32(t.start*MOVIE_TIMESCALE/t.timescale))
+	elst = u32(elst, 0xffffffff)
+	elst = u32(elst, 0x00010000)
+	// The media from its start
+	elst = u32(elst, t.movieDuration()-uint32(t.start*MOVIE_TIMESCALE/t.timescale))
+	elst = u32(elst, 0)
+	elst = u32(elst, 0x00010000)
+
+	return box("edts", fullBox("elst", 0, 0, elst))
+}
+
+// mdiaBox returns the media box of a track
+func (t *track) mdiaBox() []byte {
+	mdhd := u32(nil, 0) // Creation time
+	mdhd = u32(mdhd, 0) // Modification time
+	mdhd = u32(mdhd, uint32(t.timescale))
+	mdhd = u32(mdhd, uint32(t.mediaDuration()))
+	mdhd = u16(mdhd, 0x55c4) // Language "und"
+	mdhd = u16(mdhd, 0)
+
+	handler, name, header := "soun", "SoundHandler", fullBox("smhd", 0, 0, make([]byte, 4))
+	if t.video {
+		handler, name, header = "vide", "VideoHandler", fullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+	hdlr := u32(nil, 0)
+	hdlr = append(hdlr, handler...)
+	hdlr = append(hdlr, make([]byte, 12)...)
+	hdlr = append(hdlr, name...)
+	hdlr = append(hdlr, 0)
+
+	dref := fullBox("dref", 0, 0, u32(nil, 1), fullBox("url ", 0, 1)) // Self-contained
+	minf := box("minf", header, box("dinf", dref), t.stblBox())
+
+	return box("mdia", fullBox("mdhd", 0, 0, mdhd), fullBox("hdlr", 0, 0, hdlr), minf)
+}
+
+// stblBox returns the sample table of a track
+func (t *track) stblBox() []byte {
+	// Runs of equal sample durations
+	var stts []byte
+	var entries uint32
+	for i := 0; i < len(t.samples); {
+		j := i + 1
+		for j < len(t.samples) && t.samples[j].duration == t.samples[i].duration {
+			j++
+		}
+		stts = u32(stts, uint32(j-i))
+		stts = u32(stts, t.samples[i].duration)
+		entries++
+		i = j
+	}
+
+	// Runs of equal composition offsets, only needed with reordered frames
+	var ctts []byte
+	var cttsEntries uint32
+	reordered := false
+	for i := 0; i < len(t.samples); {
+		j := i + 1
+		for j < len(t.samples) && t.samples[j].cts == t.samples[i].cts {
+			j++
+		}
+		ctts = u32(ctts, uint32(j-i))
+		ctts = u32(ctts, t.samples[i].cts)
+		cttsEntries++
+		reordered = reordered || t.samples[i].cts != 0
+		i = j
+	}
+
+	var stss []byte
+	var keyframes uint32
+	stsz := u32(nil, 0) // Sizes vary per sample
+	stsz = u32(stsz, uint32(len(t.samples)))
+	co64 := u32(nil, uint32(len(t.samples)))
+	for i, s := range t.samples {
+		if s.keyframe {
+			stss = u32(stss, uint32(i+1))
+			keyframes++
+		}
+		stsz = u32(stsz, s.size)
+		co64 = u64(co64, s.position)
+	}
+
+	// Every sample is its own chunk
+	stsc := u32(nil, 1)
+	stsc = u32(stsc, 1)
+	stsc = u32(stsc, 1)
+	stsc = u32(stsc, 1)
+
+	parts := [][]byte{
+		fullBox("stsd", 0, 0, u32(nil, 1), t.sampleEntry()),
+		fullBox("stts", 0, 0, u32(nil, entries), stts),
+	}
+	if reordered {
+		parts = append(parts, fullBox("ctts", 0, 0, u32(nil, cttsEntries), ctts))
+	}
+	if t.video {
+		parts = append(parts, fullBox("stss", 0, 0, u32(nil, keyframes), stss))
+	}
+	parts = append(parts,
+		fullBox("stsc", 0, 0, stsc),
+		fullBox("stsz", 0, 0, stsz),
+		fullBox("co64", 0, 0, co64),
+	)
+
+	return box("stbl", parts...)
+}
+
+// sampleEntry returns the avc1 or mp4a sample description of a track
+func (t *track) sampleEntry() []byte {
+	entry := make([]byte, 6) // Reserved
+	entry = u16(entry, 1)    // Data reference index
+
+	if t.video {
+		entry = append(entry, make([]byte, 16)...) // Pre-defined and reserved
+		entry = u16(entry, uint16(t.width))
+		entry = u16(entry, uint16(t.height))
+		entry = u32(entry, 0x00480000) // 72 dpi
+		entry = u32(entry, 0x00480000)
+		entry = u32(entry, 0)                      // Reserved
+		entry = u16(entry, 1)                      // Frame count
+		entry = append(entry, make([]byte, 32)...) // Compressor name
+		entry = u16(entry, 0x0018)                 // Depth
+		entry = u16(entry, 0xffff)                 // Pre-defined
+
+		return box("avc1", entry, box("avcC", t.config))
+	}
+
+	entry = append(entry, make([]byte, 8)...) // Reserved
+	entry = u16(entry, uint16(t.channels))
+	entry = u16(entry, 16) // Sample size
+	entry = u32(entry, 0)  // Pre-defined and reserved
+	entry = u32(entry, uint32(t.timescale)<<16)
+
+	return box("mp4a", entry, esdsBox(t.config))
+}
+
+// esdsBox returns the elementary stream descriptor of an AAC track
+func esdsBox(audioConfig []byte) []byte {
+	descriptor := func(tag byte, payload []byte) []byte {
+		return append([]byte{tag, 0x80, 0x80, 0x80, byte(len(payload))}, payload...)
+	}
+
+	decoderSpecific := descriptor(0x05, audioConfig)
+	decoderConfig := []byte{
+		0x40,             // MPEG-4 audio
+		0x15,             // Audio stream
+		0x00, 0x00, 0x00, // Buffer size
+	}
+	decoderConfig = u32(decoderConfig, 0) // Max bitrate
+	decoderConfig = u32(decoderConfig, 0) // Average bitrate
+	decoderConfig = descriptor(0x04, append(decoderConfig, decoderSpecific...))
+
+	es := u16(nil, 1) // ES ID
+	es = append(es, 0x00)
+	es = append(es, decoderConfig...)
+	es = append(es, descriptor(0x06, []byte{0x02})...)
+
+	return fullBox("esds", 0, 0, descriptor(0x03, es))
+}
@@ -0,0 +1,412 @@
+// Package mp4 provides an output that remuxes the livestream into an MP4 file
+// without an external ffmpeg process.
+//
+// H.264 video and AAC audio are written to the file as they arrive, and the index
+// (the moov box) is appended when the output is closed. A stream that ends early
+// therefore still leaves a playable file, as long as the output is closed.
+package mp4
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNoVideo is returned by Close when no video was recorded
+var ErrNoVideo = errors.New("no video was recorded")
+
+type Config struct {
+	// The path of the MP4 file to create
+	Path string
+	// Optional length of the recording. Once the video reaches it, Done is closed and
+	// later stream data is discarded
+	Duration time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// sample is a video frame or audio frame written to the file
+type sample struct {
+	// The position of the sample data in the file
+	position uint64
+	// The size of the sample data in bytes
+	size uint32
+	// The decode duration in track timescale units
+	duration uint32
+	// The composition offset (PTS - DTS) in track timescale units
+	cts uint32
+	// Whether the sample is a video keyframe
+	keyframe bool
+}
+
+// track collects the samples of the video or audio stream
+type track struct {
+	// Whether the track is the video track
+	video bool
+	// The units per second of the track timestamps
+	timescale int64
+	// The AVCDecoderConfigurationRecord or the AAC AudioSpecificConfig
+	config []byte
+	// The video dimensions
+	width  int
+	height int
+	// The audio channel count
+	channels int
+	// The samples written so far
+	samples []sample
+	// The DTS of the first sample relative to the start of the movie, in track units
+	start int64
+	// The DTS of the last sample relative to the start of the movie, in 90kHz units
+	lastDTS int64
+}
+
+// add records a sample and completes the duration of the previous one
+func (t *track) add(s sample, dts int64) {
+	if n := len(t.samples); n > 0 && t.video {
+		t.samples[n-1].duration = uint32(max((dts-t.lastDTS)*t.timescale/mpegts.CLOCK_RATE, 1))
+	}
+	t.samples = append(t.samples, s)
+	t.lastDTS = dts
+}
+
+// finish sets the duration of the last video sample, which has no successor
+func (t *track) finish() {
+	if n := len(t.samples); n > 1 && t.video {
+		t.samples[n-1].duration = t.samples[n-2].duration
+	}
+}
+
+// mediaDuration returns the duration of the samples in track units
+func (t *track) mediaDuration() int64 {
+	var duration int64
+	for _, s := range t.samples {
+		duration += int64(s.duration)
+	}
+
+	return duration
+}
+
+// movieDuration returns the end of the track in MOVIE_TIMESCALE units
+func (t *track) movieDuration() uint32 {
+	return uint32((t.start + t.mediaDuration()) * MOVIE_TIMESCALE / t.timescale)
+}
+
+type Writer struct {
+	// Configuration options for the output
+	config Config
+	// Guards the fields below
+	mu sync.Mutex
+	// The file being written
+	file *os.File
+	// Buffered writer for the sample data
+	out *bufio.Writer
+	// The position of the mdat box, whose size is written on close
+	mdat uint64
+	// The position of the next byte written to the file
+	position uint64
+	// Demuxer for the incoming transport stream
+	demuxer *mpegts.Demuxer
+	// Keeps timestamps monotonic across sessions
+	rebaser *mpegts.Rebaser
+	// The unwrapped time of the last access unit and its 33-bit timestamp
+	clock    int64
+	clockRaw int64
+	// The video and audio tracks, or nil until their first sample
+	video *track
+	audio *track
+	// The unwrapped DTS of the first video sample, the start of the movie
+	base int64
+	// Whether the configured duration was reached
+	reached bool
+	// Closed when the configured duration was reached
+	done chan struct{}
+	// The first write error, after which the output stops accepting data
+	err error
+	// Whether Close was called
+	closed bool
+}
+
+// Create creates the MP4 file and returns an output writing the stream to it.
+//
+// config: the output configuration
+//
+// Example: Create(Config{Path: "clip.mp4", Duration: time.Minute}) = &Writer{...}, nil
+func Create(config Config) (*Writer, error) {
+	if config.Path == "" {
+		return nil, errors.New("MP4 file path is required")
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	file, err := os.Create(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		config:  config,
+		file:    file,
+		out:     bufio.NewWriterSize(file, 256<<10),
+		rebaser: mpegts.NewRebaser(mpegts.DISCONTINUITY_THRESHOLD),
+		done:    make(chan struct{}),
+	}
+	w.demuxer = mpegts.NewDemuxer(w.handleAccessUnit)
+
+	// The mdat box uses a 64-bit size, which is written once it is known
+	ftyp := ftypBox()
+	w.write(ftyp)
+	w.mdat = w.position
+	w.write(u64(append(u32(nil, 1), "mdat"...), 0))
+	if w.err != nil {
+		file.Close()
+		return nil, w.err
+	}
+
+	return w, nil
+}
+
+// Write feeds MPEG-TS data from the livestream to the file
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if !w.reached {
+		w.demuxer.Write(p)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	return len(p), nil
+}
+
+// Discontinuity signals that the livestream was re-established. Buffered data is
+// discarded and the next timestamps continue from where the previous session ended.
+func (w *Writer) Discontinuity() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.demuxer.Reset()
+	w.rebaser.Discontinuity()
+}
+
+// Done returns a channel that is closed once the recording reached the configured
+// duration
+func (w *Writer) Done() <-chan struct{} {
+	return w.done
+}
+
+// Duration returns the length of the video recorded so far
+func (w *Writer) Duration() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.video == nil {
+		return 0
+	}
+
+	return mpegts.Duration(w.video.lastDTS)
+}
+
+// Flush writes the buffered sample data to the file
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	if err := w.out.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+
+	return w.err
+}
+
+// Close writes the index of the recorded samples and closes the file. It returns
+// ErrNoVideo when the stream ended before the first keyframe.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if !w.reached {
+		w.demuxer.Flush()
+	}
+
+	var tracks []*track
+	for _, t := range []*track{w.video, w.audio} {
+		if t != nil && len(t.samples) > 0 {
+			t.finish()
+			tracks = append(tracks, t)
+		}
+	}
+
+	// The index follows the sample data
+	size := binary.BigEndian.AppendUint64(nil, w.position-w.mdat)
+	w.write(moovBox(tracks))
+	if err := w.out.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.err == nil {
+		if _, err := w.file.WriteAt(size, int64(w.mdat)+8); err != nil {
+			w.err = err
+		}
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+
+	if w.err != nil {
+		return fmt.Errorf("error writing %s: %w", w.config.Path, w.err)
+	}
+	if w.video == nil {
+		return fmt.Errorf("%s: %w", w.config.Path, ErrNoVideo)
+	}
+	w.config.OnLog(fmt.Sprintf("Wrote %s of video to %s", mpegts.Duration(w.video.lastDTS).Round(time.Millisecond), w.config.Path))
+
+	return nil
+}
+
+// write appends data to the file, remembering the first error. w.mu must be held.
+func (w *Writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.out.Write(data); err != nil {
+		w.err = err
+		return
+	}
+	w.position += uint64(len(data))
+}
+
+// unwrap converts a 33-bit timestamp into a monotonic 64-bit one
+func (w *Writer) unwrap(ts int64) int64 {
+	w.clock += mpegts.TimestampDelta(w.clockRaw, ts)
+	w.clockRaw = ts
+
+	return w.clock
+}
+
+func (w *Writer) handleAccessUnit(au mpegts.AccessUnit) {
+	// The rest of a write that reached the duration is discarded
+	if w.reached {
+		return
+	}
+	if w.rebaser.Rebase(&au) {
+		w.config.OnLog("Timestamp discontinuity detected, rebasing the MP4 recording")
+	}
+	dts := w.unwrap(au.DTS)
+	cts := max(mpegts.TimestampDelta(au.DTS, au.PTS), 0)
+
+	switch au.StreamType {
+	case mpegts.STREAM_TYPE_H264:
+		w.writeVideo(au, dts, cts)
+	case mpegts.STREAM_TYPE_AAC:
+		w.writeAudio(au, dts+cts)
+	}
+}
+
+// writeVideo writes an H.264 access unit as a sample of length-prefixed NAL units
+func (w *Writer) writeVideo(au mpegts.AccessUnit, dts int64, cts int64) {
+	var sps, pps, avcc []byte
+	keyframe := false
+	for _, nalu := range mpegts.SplitAnnexB(au.Data) {
+		switch mpegts.H264NALType(nalu) {
+		case mpegts.H264_NAL_SPS:
+			sps = nalu
+		case mpegts.H264_NAL_PPS:
+			pps = nalu
+		case mpegts.H264_NAL_AUD:
+			// Parameter sets and delimiters are carried in the sample description
+		default:
+			if mpegts.H264NALType(nalu) == mpegts.H264_NAL_IDR {
+				keyframe = true
+			}
+			avcc = binary.BigEndian.AppendUint32(avcc, uint32(len(nalu)))
+			avcc = append(avcc, nalu...)
+		}
+	}
+
+	if w.video == nil {
+		// The recording starts at the first keyframe with parameter sets
+		if !keyframe || sps == nil || pps == nil {
+			return
+		}
+		config, err := mpegts.AVCDecoderConfig(sps, pps)
+		if err != nil {
+			return
+		}
+		width, height, err := mpegts.H264Resolution(sps)
+		if err != nil {
+			w.config.OnLog(fmt.Sprintf("Cannot read the video size: %v", err))
+		}
+		w.video = &track{video: true, timescale: mpegts.CLOCK_RATE, config: config, width: width, height: height}
+		w.base = dts
+	} else if sps != nil && pps != nil {
+		if config, err := mpegts.AVCDecoderConfig(sps, pps); err == nil && !bytes.Equal(config, w.video.config) {
+			w.config.OnLog("The video parameters changed mid-stream, later frames may not play back")
+		}
+	}
+	if len(avcc) == 0 {
+		return
+	}
+
+	elapsed := dts - w.base
+	if w.config.Duration > 0 && elapsed >= mpegts.Ticks(w.config.Duration) {
+		w.reached = true
+		close(w.done)
+		return
+	}
+
+	w.video.add(sample{position: w.position, size: uint32(len(avcc)), cts: uint32(cts), keyframe: keyframe}, elapsed)
+	w.write(avcc)
+}
+
+// writeAudio writes the AAC frames of an access unit as samples
+func (w *Writer) writeAudio(au mpegts.AccessUnit, pts int64) {
+	// Audio is aligned to the start of the video
+	if w.video == nil {
+		return
+	}
+
+	for i, frame := range mpegts.SplitADTS(au.Data) {
+		sampleRate := frame.SampleRate()
+		if sampleRate == 0 {
+			continue
+		}
+		elapsed := pts + int64(i)*1024*mpegts.CLOCK_RATE/int64(sampleRate) - w.base
+		if elapsed < 0 {
+			continue
+		}
+
+		if w.audio == nil {
+			w.audio = &track{
+				timescale: int64(sampleRate),
+				config:    frame.AudioSpecificConfig(),
+				channels:  max(int(frame.Channels), 1),
+				start:     elapsed * int64(sampleRate) / mpegts.CLOCK_RATE,
+			}
+		}
+
+		w.audio.add(sample{position: w.position, size: uint32(len(frame.Data)), duration: 1024}, elapsed)
+		w.write(frame.Data)
+	}
+}
@@ -0,0 +1,24 @@
+// Package namedpipe exposes the livestream on a Windows named pipe so that players
+// such as VLC or OBS can attach to it without relying on stdin piping.
+//
+// Consumers may attach and detach at any time. While no consumer is attached, the
+// stream data is discarded so that the Blink connection is never stalled.
+package namedpipe
+
+import "strings"
+
+// PIPE_PREFIX is the namespace prefix for local Windows named pipes.
+const PIPE_PREFIX = `\\.\pipe\`
+
+// PipePath returns the fully-qualified pipe path for the given name
+//
+// name: the pipe name, with or without the \\.\pipe\ prefix
+//
+// Example: PipePath("blink") = `\\.\pipe\blink`
+func PipePath(name string) string {
+	if strings.HasPrefix(name, PIPE_PREFIX) {
+		return name
+	}
+
+	return PIPE_PREFIX + strings.TrimLeft(name, `\/`)
+}
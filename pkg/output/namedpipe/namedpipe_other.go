@@ -0,0 +1,31 @@
+//go:build !windows
+
+package namedpipe
+
+import (
+	"errors"
+	"runtime"
+)
+
+// ErrUnsupported is returned on platforms without Windows named pipes.
+var ErrUnsupported = errors.New("named pipes are only supported on Windows (GOOS=" + runtime.GOOS + ")")
+
+type Writer struct{}
+
+// Listen is unavailable on this platform. Use a FIFO (mkfifo) or stdout instead.
+func Listen(name string, onLog func(string)) (*Writer, error) {
+	return nil, ErrUnsupported
+}
+
+// Path returns the fully-qualified path of the pipe.
+func (w *Writer) Path() string {
+	return ""
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return 0, ErrUnsupported
+}
+
+func (w *Writer) Close() error {
+	return nil
+}
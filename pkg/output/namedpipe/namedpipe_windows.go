@@ -0,0 +1,210 @@
+//go:build windows
+
+package namedpipe
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW    = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe    = kernel32.NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe = kernel32.NewProc("DisconnectNamedPipe")
+)
+
+const (
+	pipeAccessOutbound     = 0x00000002
+	pipeTypeByte           = 0x00000000
+	pipeWait               = 0x00000000
+	pipeUnlimitedInstances = 255
+	pipeBufferSize         = 64 * 1024
+
+	errorPipeConnected syscall.Errno = 535
+)
+
+type Writer struct {
+	// Fully-qualified path of the pipe
+	path string
+	// Callback for logging consumer attach/detach events
+	onLog func(string)
+	// Guards the fields below
+	mu sync.Mutex
+	// Handle of the pipe instance with an attached consumer
+	handle syscall.Handle
+	// Whether a consumer is currently attached
+	connected bool
+	// Signalled when the attached consumer goes away
+	detached chan struct{}
+	// Whether the writer has been closed
+	closed bool
+}
+
+// Listen creates the named pipe and begins accepting consumers in the background.
+//
+// name: the pipe name (e.g. "blink" or `\\.\pipe\blink`)
+//
+// onLog: optional callback for consumer attach/detach messages
+//
+// Example: Listen("blink", nil) = &Writer{...}, nil
+func Listen(name string, onLog func(string)) (*Writer, error) {
+	if onLog == nil {
+		onLog = func(string) {}
+	}
+
+	w := &Writer{
+		path:     PipePath(name),
+		onLog:    onLog,
+		detached: make(chan struct{}, 1),
+	}
+
+	// Create the first instance up-front so that configuration errors surface immediately
+	handle, err := w.createInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	go w.accept(handle)
+
+	return w, nil
+}
+
+// Path returns the fully-qualified path of the pipe.
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) createInstance() (syscall.Handle, error) {
+	path, err := syscall.UTF16PtrFromString(w.path)
+	if err != nil {
+		return syscall.InvalidHandle, fmt.Errorf("invalid pipe name: %w", err)
+	}
+
+	handle, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(path)),
+		pipeAccessOutbound,
+		pipeTypeByte|pipeWait,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		0,
+		0,
+		0,
+	)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, fmt.Errorf("error creating named pipe %s: %w", w.path, err)
+	}
+
+	return syscall.Handle(handle), nil
+}
+
+// accept waits for consumers one at a time, re-creating the pipe instance after each detach
+func (w *Writer) accept(handle syscall.Handle) {
+	for {
+		ok, _, err := procConnectNamedPipe.Call(uintptr(handle), 0)
+		if ok == 0 && !errors.Is(err, errorPipeConnected) {
+			syscall.CloseHandle(handle)
+			if w.isClosed() {
+				return
+			}
+
+			w.onLog(fmt.Sprintf("Error waiting for pipe consumer: %v", err))
+		} else {
+			w.mu.Lock()
+			if w.closed {
+				w.mu.Unlock()
+				syscall.CloseHandle(handle)
+				return
+			}
+			w.handle = handle
+			w.connected = true
+			w.mu.Unlock()
+
+			w.onLog(fmt.Sprintf("Consumer attached to %s", w.path))
+			<-w.detached
+			w.onLog(fmt.Sprintf("Consumer detached from %s", w.path))
+		}
+
+		if w.isClosed() {
+			return
+		}
+
+		handle, err = w.createInstance()
+		if err != nil {
+			w.onLog(err.Error())
+			return
+		}
+	}
+}
+
+// Write forwards the data to the attached consumer. Data is discarded when no
+// consumer is attached, and a consumer that fails to read is detached.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, errors.New("named pipe is closed")
+	}
+
+	if !w.connected {
+		return len(p), nil
+	}
+
+	var written uint32
+	for len(p[written:]) > 0 {
+		var n uint32
+		if err := syscall.WriteFile(w.handle, p[written:], &n, nil); err != nil {
+			w.detachLocked()
+			break
+		}
+		written += n
+	}
+
+	return len(p), nil
+}
+
+// Close detaches any consumer and removes the pipe.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	if w.connected {
+		w.detachLocked()
+	}
+	w.mu.Unlock()
+
+	// Unblock a pending ConnectNamedPipe by briefly attaching to the pipe ourselves
+	if path, err := syscall.UTF16PtrFromString(w.path); err == nil {
+		if handle, err := syscall.CreateFile(path, syscall.GENERIC_READ, 0, nil, syscall.OPEN_EXISTING, 0, 0); err == nil {
+			syscall.CloseHandle(handle)
+		}
+	}
+
+	return nil
+}
+
+func (w *Writer) detachLocked() {
+	procDisconnectNamedPipe.Call(uintptr(w.handle))
+	syscall.CloseHandle(w.handle)
+	w.handle = syscall.InvalidHandle
+	w.connected = false
+
+	select {
+	case w.detached <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Writer) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.closed
+}
@@ -0,0 +1,111 @@
+// Package obs provides an output profile tuned for OBS and other streaming software.
+//
+// The stream is served by a local RTMP listener that stays up across Blink session
+// drops. Timestamps are rebased so that they start at zero and never jump backwards,
+// so a reconnect appears to the player as a short stall instead of a new stream.
+package obs
+
+import (
+	"amattu2/blink-middleware/internal/rtmp"
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"fmt"
+	"sync"
+)
+
+// DEFAULT_ADDR is the default listen address of the RTMP server
+const DEFAULT_ADDR = "127.0.0.1:1935"
+
+type Config struct {
+	// The TCP address to listen on (defaults to DEFAULT_ADDR)
+	Addr string
+	// The stream name advertised in the playback URL (defaults to "blink")
+	StreamName string
+	// Callback for logging messages
+	OnLog func(string)
+	// Optional metrics backend reporting the number of players
+	Metrics metrics.Metrics
+}
+
+type Output struct {
+	// Configuration options for the output
+	config Config
+	// The RTMP server players connect to
+	server *rtmp.Server
+	// Guards the fields below
+	mu sync.Mutex
+	// Demuxer for the incoming transport stream
+	demuxer *mpegts.Demuxer
+	// Muxer producing FLV-wrapped RTMP messages
+	muxer *rtmp.Muxer
+	// Keeps timestamps monotonic across sessions
+	rebaser *mpegts.Rebaser
+}
+
+// Listen starts the RTMP listener for the OBS output profile.
+//
+// config: the output configuration
+//
+// Example: Listen(Config{Addr: "127.0.0.1:1935"}) = &Output{...}, nil
+func Listen(config Config) (*Output, error) {
+	if config.Addr == "" {
+		config.Addr = DEFAULT_ADDR
+	}
+	if config.StreamName == "" {
+		config.StreamName = "blink"
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	server, err := rtmp.Listen(config.Addr, config.OnLog, config.Metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Output{
+		config:  config,
+		server:  server,
+		muxer:   rtmp.NewMuxer(),
+		rebaser: mpegts.NewRebaser(mpegts.DISCONTINUITY_THRESHOLD),
+	}
+	o.demuxer = mpegts.NewDemuxer(o.handleAccessUnit)
+
+	return o, nil
+}
+
+// URL returns the RTMP URL to configure as the OBS media source input
+func (o *Output) URL() string {
+	return fmt.Sprintf("rtmp://%s/live/%s", o.server.Addr(), o.config.StreamName)
+}
+
+// Write feeds MPEG-TS data from the livestream to the connected players
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.demuxer.Write(p)
+}
+
+// Discontinuity signals that the livestream was re-established. Buffered data is
+// discarded and the next timestamps continue from where the previous session ended.
+func (o *Output) Discontinuity() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.demuxer.Reset()
+	o.rebaser.Discontinuity()
+}
+
+// Close stops the RTMP listener and disconnects all players
+func (o *Output) Close() error {
+	return o.server.Close()
+}
+
+func (o *Output) handleAccessUnit(au mpegts.AccessUnit) {
+	if o.rebaser.Rebase(&au) {
+		o.config.OnLog("Timestamp discontinuity detected, rebasing the RTMP stream")
+	}
+
+	o.server.Broadcast(o.muxer.Mux(au)...)
+}
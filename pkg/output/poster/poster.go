@@ -0,0 +1,199 @@
+// Package poster provides an output that extracts the first keyframe of the
+// livestream and encodes it into a JPEG image, e.g. a preview shown by dashboards
+// before the stream is played.
+//
+// The keyframe is decoded by ffmpeg, as the H.264 and H.265 decoders are not
+// reimplemented here. The rest of the stream is discarded cheaply once the image
+// was captured.
+package poster
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DEFAULT_TIMEOUT bounds the ffmpeg process encoding the image by default
+const DEFAULT_TIMEOUT = 10 * time.Second
+
+// ErrNotReady is returned by Image before a keyframe was encoded
+var ErrNotReady = errors.New("no poster image yet")
+
+type Config struct {
+	// The ffmpeg command decoding the keyframe (defaults to "ffmpeg")
+	FFmpeg string
+	// Maximum time for ffmpeg to encode the image (defaults to DEFAULT_TIMEOUT)
+	Timeout time.Duration
+	// Optional callback receiving the JPEG image once it is encoded
+	OnImage func([]byte)
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Poster struct {
+	// Configuration options for the output
+	config Config
+	// Demuxer for the incoming transport stream
+	demuxer *mpegts.Demuxer
+	// The latest H.264 or H.265 parameter set NAL units, prepended to a keyframe
+	// that does not carry them
+	parameterSets map[byte][]byte
+	// Guards the fields below
+	mu sync.Mutex
+	// Whether a keyframe was handed to ffmpeg
+	captured bool
+	// The encoded JPEG image, or nil until it is ready
+	image []byte
+	// The error of the encoding, if it failed
+	err error
+	// Closed once the encoding finished
+	done chan struct{}
+}
+
+// New initializes a new poster output waiting for the first keyframe.
+//
+// config: the output configuration
+//
+// Example: New(Config{OnImage: func(jpeg []byte) { ... }}) = &Poster{...}
+func New(config Config) *Poster {
+	if config.FFmpeg == "" {
+		config.FFmpeg = "ffmpeg"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_TIMEOUT
+	}
+	if config.OnImage == nil {
+		config.OnImage = func([]byte) {}
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	p := &Poster{
+		config:        config,
+		parameterSets: map[byte][]byte{},
+		done:          make(chan struct{}),
+	}
+	p.demuxer = mpegts.NewDemuxer(p.handleAccessUnit)
+
+	return p
+}
+
+// Write feeds MPEG-TS data from the livestream until the first keyframe is found
+func (p *Poster) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	captured := p.captured
+	p.mu.Unlock()
+
+	if !captured {
+		p.demuxer.Write(b)
+	}
+
+	return len(b), nil
+}
+
+// Done returns a channel that is closed once the image was encoded or failed
+func (p *Poster) Done() <-chan struct{} {
+	return p.done
+}
+
+// Image returns the JPEG image, ErrNotReady before the first keyframe was encoded,
+// or the error of the encoding
+func (p *Poster) Image() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.image == nil && p.err == nil {
+		return nil, ErrNotReady
+	}
+
+	return p.image, p.err
+}
+
+func (p *Poster) handleAccessUnit(au mpegts.AccessUnit) {
+	var format string
+	var parameterTypes []byte
+	switch au.StreamType {
+	case mpegts.STREAM_TYPE_H264:
+		format, parameterTypes = "h264", []byte{mpegts.H264_NAL_SPS, mpegts.H264_NAL_PPS}
+	case mpegts.STREAM_TYPE_H265:
+		format, parameterTypes = "hevc", []byte{32, 33, 34} // VPS, SPS, PPS
+	default:
+		return
+	}
+
+	// Remember the parameter sets, and which of them the access unit carries
+	present := map[byte]bool{}
+	for _, nalu := range mpegts.SplitAnnexB(au.Data) {
+		nalType := mpegts.H264NALType(nalu)
+		if format == "hevc" {
+			nalType = mpegts.H265NALType(nalu)
+		}
+		for _, t := range parameterTypes {
+			if nalType == t {
+				p.parameterSets[t] = append([]byte(nil), nalu...)
+				present[t] = true
+			}
+		}
+	}
+	if !au.IsKeyframe() {
+		return
+	}
+
+	var frame []byte
+	for _, t := range parameterTypes {
+		if present[t] {
+			continue
+		}
+		if p.parameterSets[t] == nil {
+			// The keyframe cannot be decoded without its parameter sets
+			return
+		}
+		frame = append(append(frame, 0, 0, 0, 1), p.parameterSets[t]...)
+	}
+	frame = append(frame, au.Data...)
+
+	p.mu.Lock()
+	p.captured = true
+	p.mu.Unlock()
+
+	go p.encode(format, frame)
+}
+
+// encode decodes the keyframe with ffmpeg into a JPEG image
+func (p *Poster) encode(format string, frame []byte) {
+	defer close(p.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.config.FFmpeg, "-loglevel", "error", "-f", format, "-i", "-", "-frames:v", "1", "-f", "image2", "-c:v", "mjpeg", "-")
+	cmd.Stdin = bytes.NewReader(frame)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil && stdout.Len() == 0 {
+		err = errors.New("no image was produced")
+	}
+
+	p.mu.Lock()
+	if err != nil {
+		p.err = fmt.Errorf("error encoding the poster with %s: %w %s", p.config.FFmpeg, err, bytes.TrimSpace(stderr.Bytes()))
+	} else {
+		p.image = stdout.Bytes()
+	}
+	image, encodeErr := p.image, p.err
+	p.mu.Unlock()
+
+	if encodeErr != nil {
+		p.config.OnLog(encodeErr.Error())
+		return
+	}
+	p.config.OnImage(image)
+}
@@ -0,0 +1,151 @@
+package record
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UPLOAD_ATTEMPTS is the number of times a segment is handed to the backend before
+// it is left in the recording directory
+const UPLOAD_ATTEMPTS = 3
+
+// Backend archives finalized segments, e.g. to another disk or to object storage.
+// Segments are recorded to the local directory first, then stored by the backend
+// once they are rotated.
+type Backend interface {
+	// Store archives the segment file at path under the name, which is relative to
+	// the recording directory. It is called from a single goroutine.
+	Store(ctx context.Context, name string, path string) error
+}
+
+// DiskBackend archives segments to a directory, e.g. on a larger or mounted disk
+type DiskBackend struct {
+	// The directory the segments are copied to
+	Dir string
+}
+
+// Store copies the segment into the archive directory. A partially copied file is
+// removed, and the segment only appears under its name once it is complete.
+func (d DiskBackend) Store(ctx context.Context, name string, path string) error {
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	target := filepath.Join(d.Dir, name)
+	dst, err := os.CreateTemp(d.Dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	err = errors.Join(err, dst.Sync(), dst.Close())
+	if err == nil {
+		err = os.Rename(dst.Name(), target)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	return nil
+}
+
+// uploader hands finalized segments to the backend in order, removing the local
+// copies unless they are kept
+type uploader struct {
+	// The recorder configuration with the backend
+	config Config
+	// The queue of segment names
+	queue chan string
+	// Cancels uploads that are still running when the recorder is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Closed once the queue is drained
+	done chan struct{}
+}
+
+func newUploader(config Config) *uploader {
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &uploader{
+		config: config,
+		queue:  make(chan string, 64),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go u.run()
+
+	return u
+}
+
+// enqueue schedules a segment for upload. When the queue is full, the segment stays
+// in the recording directory and is uploaded on the next start.
+func (u *uploader) enqueue(segment string) {
+	select {
+	case u.queue <- segment:
+	default:
+		u.config.OnLog(fmt.Sprintf("Upload queue is full, keeping %s locally", segment))
+	}
+}
+
+// close waits up to timeout for the queued segments to be stored, then cancels the
+// remaining uploads
+func (u *uploader) close(timeout time.Duration) {
+	close(u.queue)
+	select {
+	case <-u.done:
+	case <-time.After(timeout):
+		u.config.OnLog("Uploads did not finish in time; the remaining segments are uploaded on the next start")
+		u.cancel()
+		<-u.done
+	}
+	u.cancel()
+}
+
+func (u *uploader) run() {
+	defer close(u.done)
+
+	for segment := range u.queue {
+		path := filepath.Join(u.config.Dir, segment)
+
+		var err error
+		for attempt := 0; attempt < UPLOAD_ATTEMPTS; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-u.ctx.Done():
+				case <-time.After(time.Duration(attempt) * 5 * time.Second):
+				}
+			}
+			if u.ctx.Err() != nil {
+				break
+			}
+			if err = u.config.Backend.Store(u.ctx, segment, path); err == nil {
+				break
+			}
+		}
+		if err == nil {
+			err = u.ctx.Err()
+		}
+		if err != nil {
+			u.config.OnLog(fmt.Sprintf("Error uploading %s, keeping it locally: %v", segment, err))
+			continue
+		}
+
+		u.config.OnLog(fmt.Sprintf("Uploaded %s", segment))
+		if !u.config.KeepLocal {
+			if err := os.Remove(path); err != nil {
+				u.config.OnLog(fmt.Sprintf("Error removing uploaded segment %s: %v", segment, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package record
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JOURNAL_FILE is the name of the segment journal within the recording directory
+const JOURNAL_FILE = ".journal"
+
+type journalEntry struct {
+	// The time the entry was written
+	Time time.Time `json:"time"`
+	// The journal event ("open" or "close")
+	Event string `json:"event"`
+	// The segment file name, relative to the recording directory
+	Segment string `json:"segment"`
+}
+
+type RepairResult struct {
+	// The segment file name, relative to the recording directory
+	Segment string
+	// The size of the segment before repair
+	OriginalSize int64
+	// The size of the segment after repair. Zero if the segment was removed
+	RepairedSize int64
+	// Whether the segment contained no usable data and was removed
+	Removed bool
+}
+
+// journal is an append-only log of segment open/close events
+type journal struct {
+	file *os.File
+}
+
+func openJournal(dir string) (*journal, error) {
+	file, err := os.OpenFile(filepath.Join(dir, JOURNAL_FILE), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+
+	return &journal{file: file}, nil
+}
+
+// append writes and syncs a journal entry so it survives power loss
+func (j *journal) append(event string, segment string) error {
+	line, err := json.Marshal(journalEntry{Time: time.Now(), Event: event, Segment: segment})
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+
+	return j.file.Sync()
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// Repair scans the journal in the recording directory for segments that were never
+// finalized (e.g. after a crash or power loss) and truncates them to the last
+// complete transport stream packet so that they are playable. Segments without any
+// complete packet are removed. The journal is compacted afterwards.
+//
+// dir: the recording directory
+//
+// Example: Repair("/recordings") = []RepairResult{...}, nil
+func Repair(dir string) ([]RepairResult, error) {
+	path := filepath.Join(dir, JOURNAL_FILE)
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+
+	var order []string
+	open := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final line is expected after power loss
+			continue
+		}
+
+		switch entry.Event {
+		case "open":
+			if !open[entry.Segment] {
+				order = append(order, entry.Segment)
+			}
+			open[entry.Segment] = true
+		case "close":
+			open[entry.Segment] = false
+		}
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+
+	var results []RepairResult
+	for _, segment := range order {
+		if !open[segment] {
+			continue
+		}
+
+		result, err := repairSegment(dir, segment)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return results, fmt.Errorf("error repairing %s: %w", segment, err)
+		}
+		results = append(results, result)
+	}
+
+	// Every segment is now finalized, so the journal can start over
+	if err := os.Truncate(path, 0); err != nil {
+		return results, fmt.Errorf("error compacting journal: %w", err)
+	}
+
+	return results, nil
+}
+
+// repairSegment truncates a segment to its last complete packet
+func repairSegment(dir string, segment string) (RepairResult, error) {
+	path := filepath.Join(dir, segment)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RepairResult{}, err
+	}
+
+	result := RepairResult{Segment: segment, OriginalSize: int64(len(data))}
+
+	// Segments are written packet-aligned, so any partial packet is at the end
+	size := len(data) / mpegts.PACKET_SIZE * mpegts.PACKET_SIZE
+	for size > 0 && data[size-mpegts.PACKET_SIZE] != mpegts.SYNC_BYTE {
+		size -= mpegts.PACKET_SIZE
+	}
+
+	if size == 0 {
+		result.Removed = true
+		return result, os.Remove(path)
+	}
+
+	if size < len(data) {
+		if err := os.Truncate(path, int64(size)); err != nil {
+			return result, err
+		}
+	}
+	result.RepairedSize = int64(size)
+
+	return result, nil
+}
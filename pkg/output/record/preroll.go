@@ -0,0 +1,222 @@
+package record
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type PreRollConfig struct {
+	// The duration of stream data retained before a trigger (defaults to 10 seconds)
+	Duration time.Duration
+	// The writer receiving the stream while recording, typically a Recorder
+	Writer io.Writer
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// gop is a run of packets starting with a keyframe
+type gop struct {
+	// When the keyframe was received
+	start time.Time
+	// The packets of the run
+	data []byte
+}
+
+// PreRoll is a sink that always retains the last few seconds of the stream in
+// memory. When StartRecording is called, the retained data is flushed to the writer
+// before the live stream, so clips include footage from before the trigger.
+type PreRoll struct {
+	// Configuration options for the sink
+	config PreRollConfig
+	// Guards the fields below
+	mu sync.Mutex
+	// Demuxer used to track the program tables and stream types
+	demuxer *mpegts.Demuxer
+	// The latest PAT and PMT packets, written before the retained data
+	pat []byte
+	pmt []byte
+	// The retained runs, oldest first. Each starts with a keyframe so the flushed
+	// data is decodable from its first packet
+	gops []gop
+	// Incomplete packet bytes carried over between writes
+	pending []byte
+	// Packets forwarded to the writer by the current write
+	out []byte
+	// Whether data is currently forwarded to the writer
+	recording bool
+}
+
+// NewPreRoll initializes a new PreRoll sink. Data is only retained until
+// StartRecording is called.
+//
+// config: the sink configuration
+//
+// Example: NewPreRoll(PreRollConfig{Duration: 10 * time.Second, Writer: recorder}) = &PreRoll{...}, nil
+func NewPreRoll(config PreRollConfig) (*PreRoll, error) {
+	if config.Writer == nil {
+		return nil, errors.New("pre-roll writer is required")
+	}
+	if config.Duration <= 0 {
+		config.Duration = 10 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	return &PreRoll{
+		config:  config,
+		demuxer: mpegts.NewDemuxer(func(mpegts.AccessUnit) {}),
+	}, nil
+}
+
+// Write retains the MPEG-TS data, or forwards it to the writer while recording
+func (p *PreRoll) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.out = p.out[:0]
+	p.pending = mpegts.AlignPackets(append(p.pending, b...), p.writePacket)
+	if len(p.out) > 0 {
+		if _, err := p.config.Writer.Write(p.out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (p *PreRoll) writePacket(pkt mpegts.Packet) {
+	pid := pkt.PID()
+	p.demuxer.WritePacket(pkt)
+	switch {
+	case pid == mpegts.PID_PAT && pkt.PayloadUnitStart():
+		p.pat = append(p.pat[:0], pkt...)
+	case p.demuxer.IsPMT(pid) && pkt.PayloadUnitStart():
+		p.pmt = append(p.pmt[:0], pkt...)
+	}
+
+	if p.recording {
+		p.out = append(p.out, pkt...)
+		return
+	}
+
+	if streamType, ok := p.demuxer.StreamType(pid); ok && mpegts.IsKeyframeStart(pkt, streamType) {
+		now := time.Now()
+		p.gops = append(p.gops, gop{start: now})
+
+		// Keep the newest keyframe at or before the start of the window
+		cutoff := now.Add(-p.config.Duration)
+		for len(p.gops) > 1 && !p.gops[1].start.After(cutoff) {
+			p.gops[0] = gop{}
+			p.gops = p.gops[1:]
+		}
+	}
+
+	// Retained data always starts on a keyframe
+	if len(p.gops) == 0 {
+		return
+	}
+	last := &p.gops[len(p.gops)-1]
+	last.data = append(last.data, pkt...)
+}
+
+// StartRecording flushes the retained data to the writer and forwards the stream
+// from now on. It has no effect while already recording.
+func (p *PreRoll) StartRecording() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.recording {
+		return nil
+	}
+	p.recording = true
+
+	var retained time.Duration
+	if len(p.gops) > 0 {
+		retained = time.Since(p.gops[0].start)
+	}
+	buffered := p.retained()
+	p.gops = nil
+
+	p.config.OnLog(fmt.Sprintf("Recording started with %s of pre-roll", retained.Round(time.Millisecond)))
+	if len(buffered) == 0 {
+		return nil
+	}
+	if _, err := p.config.Writer.Write(buffered); err != nil {
+		return fmt.Errorf("error flushing pre-roll: %w", err)
+	}
+
+	return nil
+}
+
+// Retained returns a copy of the retained stream without starting a recording,
+// e.g. for animating the last seconds before an event. It starts with the program
+// tables and a keyframe, and is empty while recording.
+func (p *PreRoll) Retained() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.retained()
+}
+
+// retained returns the program tables and the retained runs. p.mu must be held.
+func (p *PreRoll) retained() []byte {
+	if len(p.gops) == 0 {
+		return nil
+	}
+
+	var buffered []byte
+	if p.pat != nil && p.pmt != nil {
+		buffered = append(append(buffered, p.pat...), p.pmt...)
+	}
+	for _, g := range p.gops {
+		buffered = append(buffered, g.data...)
+	}
+
+	return buffered
+}
+
+// StopRecording stops forwarding the stream and resumes retaining it. A Recorder
+// writer finalizes its segment, so the next recording starts a new file.
+func (p *PreRoll) StopRecording() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.recording {
+		return nil
+	}
+	p.recording = false
+	p.config.OnLog("Recording stopped")
+
+	if recorder, ok := p.config.Writer.(*Recorder); ok {
+		return recorder.Finalize()
+	}
+
+	return nil
+}
+
+// Recording returns whether the stream is currently forwarded to the writer
+func (p *PreRoll) Recording() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.recording
+}
+
+// Discontinuity discards the retained data after the livestream was re-established,
+// and forwards the signal to the writer if supported
+func (p *PreRoll) Discontinuity() {
+	p.mu.Lock()
+	p.pending = p.pending[:0]
+	p.gops = nil
+	p.demuxer.Reset()
+	p.mu.Unlock()
+
+	if d, ok := p.config.Writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
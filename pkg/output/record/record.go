@@ -0,0 +1,315 @@
+// Package record writes the livestream to rotating MPEG-TS segment files.
+//
+// Segments are opened and finalized through an fsync'd journal, so that segments
+// left open by a crash or power loss can be repaired into playable files on the
+// next startup (see Repair).
+//
+// Finalized segments can be archived by a Backend, e.g. to object storage with
+// S3Backend. Segments that could not be stored are retried on the next startup.
+package record
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// The directory to write segments to
+	Dir string
+	// Prefix for segment file names (defaults to "blink")
+	Prefix string
+	// Target duration of each segment. Segments rotate on the next keyframe (defaults to 5 minutes)
+	SegmentDuration time.Duration
+	// Interval for flushing segment data to stable storage (defaults to 2 seconds)
+	SyncInterval time.Duration
+	// Optional backend archiving finalized segments (e.g. an S3Backend). Without a
+	// backend, segments stay in Dir
+	Backend Backend
+	// Whether segments stay in Dir after the backend stored them
+	KeepLocal bool
+	// How long Close waits for queued segments to be stored (defaults to 30 seconds)
+	UploadTimeout time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Recorder struct {
+	// Configuration options for the recorder
+	config Config
+	// Journal of segment open/close events
+	journal *journal
+	// Hands finalized segments to the backend, or nil without a backend
+	uploader *uploader
+	// Guards the fields below
+	mu sync.Mutex
+	// The current segment file, or nil before the first keyframe
+	file *os.File
+	// Buffered writer for the current segment
+	writer *bufio.Writer
+	// The current segment file name
+	segment string
+	// When the current segment was opened
+	opened time.Time
+	// When the current segment was last synced
+	synced time.Time
+	// Demuxer used to track the program tables and stream types
+	demuxer *mpegts.Demuxer
+	// The latest PAT and PMT packets, repeated at the start of every segment
+	pat []byte
+	pmt []byte
+	// Incomplete packet bytes carried over between writes
+	pending []byte
+	// The first write error, after which the recorder stops accepting data
+	err error
+}
+
+// Open prepares the recording directory, repairs any segments left unfinalized by
+// a previous run, and returns a Recorder ready to receive stream data.
+//
+// config: the recorder configuration
+//
+// Example: Open(Config{Dir: "/recordings"}) = &Recorder{...}, nil
+func Open(config Config) (*Recorder, error) {
+	if config.Dir == "" {
+		return nil, errors.New("recording directory is required")
+	}
+	if config.Prefix == "" {
+		config.Prefix = "blink"
+	}
+	if config.SegmentDuration <= 0 {
+		config.SegmentDuration = 5 * time.Minute
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = 2 * time.Second
+	}
+	if config.UploadTimeout <= 0 {
+		config.UploadTimeout = 30 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating recording directory: %w", err)
+	}
+
+	results, err := Repair(config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("error repairing segments: %w", err)
+	}
+	for _, result := range results {
+		if result.Removed {
+			config.OnLog(fmt.Sprintf("Removed empty segment %s", result.Segment))
+		} else {
+			config.OnLog(fmt.Sprintf("Repaired segment %s (%d -> %d bytes)", result.Segment, result.OriginalSize, result.RepairedSize))
+		}
+	}
+
+	journal, err := openJournal(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		config:  config,
+		journal: journal,
+		demuxer: mpegts.NewDemuxer(func(mpegts.AccessUnit) {}),
+	}
+
+	if config.Backend != nil {
+		r.uploader = newUploader(config)
+		// After the repair, every segment left in the directory is finalized, and
+		// without KeepLocal it is one that was not stored yet
+		if !config.KeepLocal {
+			leftover, _ := filepath.Glob(filepath.Join(config.Dir, "*.ts"))
+			for _, path := range leftover {
+				r.uploader.enqueue(filepath.Base(path))
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Write records the MPEG-TS data, rotating segments on keyframes
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	r.pending = mpegts.AlignPackets(append(r.pending, p...), r.writePacket)
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	if r.file != nil && time.Since(r.synced) > r.config.SyncInterval {
+		if err := r.sync(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (r *Recorder) writePacket(pkt mpegts.Packet) {
+	if r.err != nil {
+		return
+	}
+
+	pid := pkt.PID()
+	r.demuxer.WritePacket(pkt)
+	switch {
+	case pid == mpegts.PID_PAT && pkt.PayloadUnitStart():
+		r.pat = append(r.pat[:0], pkt...)
+	case r.demuxer.IsPMT(pid) && pkt.PayloadUnitStart():
+		r.pmt = append(r.pmt[:0], pkt...)
+	}
+
+	if streamType, ok := r.demuxer.StreamType(pid); ok && mpegts.IsKeyframeStart(pkt, streamType) {
+		if r.file == nil || time.Since(r.opened) >= r.config.SegmentDuration {
+			if err := r.rotate(); err != nil {
+				r.err = err
+				return
+			}
+		}
+	}
+
+	// Segments always start on a keyframe
+	if r.writer == nil {
+		return
+	}
+
+	if _, err := r.writer.Write(pkt); err != nil {
+		r.err = fmt.Errorf("error writing segment: %w", err)
+	}
+}
+
+// rotate finalizes the current segment and opens the next one
+func (r *Recorder) rotate() error {
+	if err := r.finalize(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s", r.config.Prefix, time.Now().UTC().Format("20060102T150405Z"))
+	segment := name + ".ts"
+	file, err := os.OpenFile(filepath.Join(r.config.Dir, segment), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	for i := 1; errors.Is(err, fs.ErrExist); i++ {
+		segment = fmt.Sprintf("%s-%d.ts", name, i)
+		file, err = os.OpenFile(filepath.Join(r.config.Dir, segment), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating segment: %w", err)
+	}
+
+	if err := r.journal.append("open", segment); err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.writer = bufio.NewWriterSize(file, 64*1024)
+	r.segment = segment
+	r.opened = time.Now()
+	r.synced = r.opened
+	r.config.OnLog(fmt.Sprintf("Recording to %s", segment))
+
+	// Make the segment independently decodable
+	if r.pat != nil && r.pmt != nil {
+		r.writer.Write(r.pat)
+		r.writer.Write(r.pmt)
+	}
+
+	return nil
+}
+
+// sync flushes buffered data and commits it to stable storage
+func (r *Recorder) sync() error {
+	if err := r.writer.Flush(); err != nil {
+		return fmt.Errorf("error flushing segment: %w", err)
+	}
+	if err := r.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing segment: %w", err)
+	}
+	r.synced = time.Now()
+
+	return nil
+}
+
+// finalize syncs and closes the current segment, marking it complete in the journal
+func (r *Recorder) finalize() error {
+	if r.file == nil {
+		return nil
+	}
+
+	syncErr := r.sync()
+	closeErr := r.file.Close()
+	r.file = nil
+	r.writer = nil
+	if err := errors.Join(syncErr, closeErr); err != nil {
+		return err
+	}
+
+	if err := r.journal.append("close", r.segment); err != nil {
+		return err
+	}
+	if r.uploader != nil {
+		r.uploader.enqueue(r.segment)
+	}
+
+	return nil
+}
+
+// Flush commits the data recorded so far to stable storage
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.file == nil {
+		return nil
+	}
+
+	return r.sync()
+}
+
+// Finalize closes the current segment. Recording continues in a new segment from
+// the next keyframe.
+func (r *Recorder) Finalize() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.finalize(); err != nil {
+		r.err = err
+		return err
+	}
+
+	return nil
+}
+
+// Close finalizes the current segment and closes the journal. With a backend, it
+// waits up to UploadTimeout for the queued segments to be stored.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := errors.Join(r.finalize(), r.journal.close())
+	if r.uploader != nil {
+		r.uploader.close(r.config.UploadTimeout)
+		r.uploader = nil
+	}
+
+	return err
+}
@@ -0,0 +1,262 @@
+package record
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_RETENTION_INTERVAL is how often Run prunes the recordings by default
+const DEFAULT_RETENTION_INTERVAL = 10 * time.Minute
+
+// RETAINED_EXTENSIONS are the extensions of the recorded files managed by retention
+var RETAINED_EXTENSIONS = []string{".ts", ".mp4"}
+
+type RetentionConfig struct {
+	// The recording directory. Files directly in it and each of its subdirectories
+	// (e.g. the camera-<id> directories of the guard) are pruned separately
+	Dir string
+	// Recordings older than this are removed (0 keeps them regardless of age)
+	MaxAge time.Duration
+	// The maximum size of the recordings of each directory in bytes. The oldest files
+	// are removed until they fit (0 is unlimited)
+	MaxSize int64
+	// How often Run prunes the recordings (defaults to DEFAULT_RETENTION_INTERVAL)
+	Interval time.Duration
+	// Optional metrics backend for the removed and retained bytes
+	Metrics metrics.Metrics
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// PruneResult describes a pruning pass
+type PruneResult struct {
+	// The number of files removed
+	Files int
+	// The number of bytes removed
+	Bytes int64
+}
+
+// Retention removes old recordings by age and total size, per camera directory
+type Retention struct {
+	// Configuration options for the retention
+	config RetentionConfig
+}
+
+// recordedFile is a recording considered for removal
+type recordedFile struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// NewRetention initializes a new retention manager with the provided configuration.
+//
+// config: the retention configuration
+//
+// Example: NewRetention(RetentionConfig{Dir: "/recordings", MaxAge: 7 * 24 * time.Hour}) = &Retention{...}, nil
+func NewRetention(config RetentionConfig) (*Retention, error) {
+	if config.Dir == "" {
+		return nil, errors.New("recording directory is required")
+	}
+	if config.MaxAge < 0 || config.MaxSize < 0 {
+		return nil, errors.New("retention limits cannot be negative")
+	}
+	if config.MaxAge == 0 && config.MaxSize == 0 {
+		return nil, errors.New("retention requires a maximum age or size")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DEFAULT_RETENTION_INTERVAL
+	}
+	if config.Metrics == nil {
+		config.Metrics = metrics.Noop
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	return &Retention{config: config}, nil
+}
+
+// Run prunes the recordings now and then every Interval until the context is done
+func (r *Retention) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Prune(); err != nil {
+			r.config.OnLog(fmt.Sprintf("Error pruning recordings: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune removes the recordings exceeding the limits. The newest file of each
+// directory is never removed by size, as it may still be recording.
+func (r *Retention) Prune() (PruneResult, error) {
+	dirs := []string{r.config.Dir}
+	entries, err := os.ReadDir(r.config.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return PruneResult{}, nil
+		}
+		return PruneResult{}, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			dirs = append(dirs, filepath.Join(r.config.Dir, entry.Name()))
+		}
+	}
+
+	var total PruneResult
+	var errs []error
+	for _, dir := range dirs {
+		result, err := r.pruneDir(dir)
+		total.Files += result.Files
+		total.Bytes += result.Bytes
+		errs = append(errs, err)
+	}
+	if total.Files > 0 {
+		r.config.OnLog(fmt.Sprintf("Removed %d recordings (%s)", total.Files, FormatSize(total.Bytes)))
+	}
+
+	return total, errors.Join(errs...)
+}
+
+// pruneDir applies the limits to the recordings of a single directory
+func (r *Retention) pruneDir(dir string) (PruneResult, error) {
+	files, err := recordedFiles(dir)
+	if err != nil || len(files) == 0 {
+		return PruneResult{}, err
+	}
+
+	var size int64
+	for _, file := range files {
+		size += file.size
+	}
+
+	var result PruneResult
+	var errs []error
+	cutoff := time.Now().Add(-r.config.MaxAge)
+	for i, file := range files {
+		expired := r.config.MaxAge > 0 && file.modified.Before(cutoff)
+		oversize := r.config.MaxSize > 0 && size > r.config.MaxSize && i < len(files)-1
+		if !expired && !oversize {
+			break
+		}
+
+		if err := os.Remove(file.path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		size -= file.size
+		result.Files++
+		result.Bytes += file.size
+	}
+
+	camera := filepath.Base(dir)
+	if result.Files > 0 {
+		r.config.Metrics.Counter(metrics.RECORDING_PRUNED_FILES_TOTAL, float64(result.Files), metrics.Labels{"camera": camera})
+		r.config.Metrics.Counter(metrics.RECORDING_PRUNED_BYTES_TOTAL, float64(result.Bytes), metrics.Labels{"camera": camera})
+	}
+	r.config.Metrics.Gauge(metrics.RECORDING_BYTES, float64(size), metrics.Labels{"camera": camera})
+
+	return result, errors.Join(errs...)
+}
+
+// recordedFiles returns the recordings directly in the directory, oldest first
+func recordedFiles(dir string) ([]recordedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []recordedFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		retained := false
+		for _, ext := range RETAINED_EXTENSIONS {
+			retained = retained || strings.EqualFold(filepath.Ext(name), ext)
+		}
+		if !retained {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, recordedFile{path: filepath.Join(dir, name), size: info.Size(), modified: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modified.Before(files[j].modified)
+	})
+
+	return files, nil
+}
+
+// ParseSize parses a size in bytes with an optional decimal (KB, MB, GB, TB) or
+// binary (KiB, MiB, GiB, TiB) unit
+//
+// value: the size to parse
+//
+// Example: ParseSize("50GB") = 50000000000, nil
+func ParseSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"B", 1},
+	}
+
+	number, multiplier := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return int64(size * float64(multiplier)), nil
+}
+
+// FormatSize formats a size in bytes with a decimal unit
+//
+// size: the size in bytes
+//
+// Example: FormatSize(1500000) = "1.5 MB"
+func FormatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value, unit := float64(size), 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,373 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_PART_SIZE is the default size of the parts of multipart uploads
+	DEFAULT_PART_SIZE = 8 << 20
+	// MIN_PART_SIZE is the smallest part size accepted by S3, except for the last part
+	MIN_PART_SIZE = 5 << 20
+)
+
+// Environment variables the S3 credentials are read from by ParseS3URL
+const (
+	S3_ACCESS_KEY_ENV    = "AWS_ACCESS_KEY_ID"
+	S3_SECRET_KEY_ENV    = "AWS_SECRET_ACCESS_KEY"
+	S3_SESSION_TOKEN_ENV = "AWS_SESSION_TOKEN"
+)
+
+type S3Config struct {
+	// The endpoint of the S3-compatible service (defaults to the AWS endpoint of the
+	// region, e.g. "https://s3.us-east-1.amazonaws.com"). Google Cloud Storage is
+	// reached at "https://storage.googleapis.com" with HMAC keys
+	Endpoint string
+	// The region of the bucket (defaults to "us-east-1"; "auto" for GCS)
+	Region string
+	// The bucket the segments are uploaded to
+	Bucket string
+	// Optional key prefix of the segments (e.g. "front-door/")
+	Prefix string
+	// The access key ID
+	AccessKey string
+	// The secret access key
+	SecretKey string
+	// Optional session token of temporary credentials
+	SessionToken string
+	// The size of the parts of multipart uploads (defaults to DEFAULT_PART_SIZE, at
+	// least MIN_PART_SIZE). Smaller segments are uploaded with a single request
+	PartSize int
+	// Optional HTTP client sending the requests
+	HTTPClient *http.Client
+}
+
+// S3Backend uploads segments to S3-compatible object storage (AWS S3, Google Cloud
+// Storage, MinIO, ...) with requests signed by AWS Signature Version 4. Segments
+// larger than a part are uploaded in parts.
+type S3Backend struct {
+	// Configuration options for the backend
+	config S3Config
+	// The client sending the requests
+	client *http.Client
+}
+
+// NewS3Backend initializes a new S3 backend.
+//
+// config: the backend configuration
+//
+// Example: NewS3Backend(S3Config{Bucket: "recordings", AccessKey: "...", SecretKey: "..."}) = &S3Backend{...}, nil
+func NewS3Backend(config S3Config) (*S3Backend, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("S3 credentials are required (set $%s and $%s)", S3_ACCESS_KEY_ENV, S3_SECRET_KEY_ENV)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if _, err := url.Parse(config.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if config.PartSize == 0 {
+		config.PartSize = DEFAULT_PART_SIZE
+	}
+	if config.PartSize < MIN_PART_SIZE {
+		return nil, fmt.Errorf("S3 part size must be at least %d bytes", MIN_PART_SIZE)
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+
+	return &S3Backend{config: config, client: client}, nil
+}
+
+// ParseS3URL parses an s3://<bucket>/<prefix> URL into a backend configuration. The
+// endpoint and region are taken from the query parameters of the same name, and
+// the credentials from the S3_*_ENV environment variables.
+//
+// spec: the URL of the bucket
+//
+// Example: ParseS3URL("s3://recordings/front?region=eu-west-1") = S3Config{Bucket: "recordings", Prefix: "front/", ...}, nil
+func ParseS3URL(spec string) (S3Config, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return S3Config{}, fmt.Errorf("invalid S3 URL %q, expected s3://<bucket>/<prefix>", spec)
+	}
+
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	config := S3Config{
+		Endpoint:     u.Query().Get("endpoint"),
+		Region:       u.Query().Get("region"),
+		Bucket:       u.Host,
+		Prefix:       prefix,
+		AccessKey:    os.Getenv(S3_ACCESS_KEY_ENV),
+		SecretKey:    os.Getenv(S3_SECRET_KEY_ENV),
+		SessionToken: os.Getenv(S3_SESSION_TOKEN_ENV),
+	}
+	if size := u.Query().Get("part_size"); size != "" {
+		if config.PartSize, err = strconv.Atoi(size); err != nil {
+			return S3Config{}, fmt.Errorf("invalid S3 part size %q", size)
+		}
+	}
+
+	return config, nil
+}
+
+// Store uploads the segment as <prefix><name>
+func (b *S3Backend) Store(ctx context.Context, name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := b.config.Prefix + name
+	if info.Size() <= int64(b.config.PartSize) {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		_, err = b.send(ctx, http.MethodPut, key, nil, data)
+		return err
+	}
+
+	return b.multipartUpload(ctx, key, file)
+}
+
+// multipartUpload uploads the file in parts, aborting the upload if a part fails
+func (b *S3Backend) multipartUpload(ctx context.Context, key string, file io.Reader) error {
+	body, err := b.send(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadId string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body, &initiated); err != nil || initiated.UploadId == "" {
+		return fmt.Errorf("error starting the upload of %s: invalid response", key)
+	}
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+	buf := make([]byte, b.config.PartSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(file, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			b.abort(key, initiated.UploadId)
+			return err
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {initiated.UploadId}}
+		resp, err := b.request(ctx, http.MethodPut, key, query, buf[:n])
+		if err != nil {
+			b.abort(key, initiated.UploadId)
+			return fmt.Errorf("error uploading part %d of %s: %w", number, key, err)
+		}
+		parts = append(parts, part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		if n < len(buf) {
+			break
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	body, err = b.send(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadId}}, complete)
+	if err != nil {
+		b.abort(key, initiated.UploadId)
+		return err
+	}
+	// Completing can fail after the response status was sent
+	if bytes.Contains(body, []byte("<Error>")) {
+		b.abort(key, initiated.UploadId)
+		return fmt.Errorf("error completing the upload of %s: %s", key, body)
+	}
+
+	return nil
+}
+
+// abort discards the parts of a failed upload, so they are not billed
+func (b *S3Backend) abort(key string, uploadId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b.send(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadId}}, nil)
+}
+
+// send sends a signed request and returns the response body
+func (b *S3Backend) send(ctx context.Context, method string, key string, query url.Values, payload []byte) ([]byte, error) {
+	resp, err := b.request(ctx, method, key, query, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// request sends a signed request, failing on error statuses. The body of a
+// successful response is buffered, so it does not need to be closed.
+func (b *S3Backend) request(ctx context.Context, method string, key string, query url.Values, payload []byte) (*http.Response, error) {
+	uri := b.config.Endpoint + "/" + awsEscape(b.config.Bucket, false) + "/" + awsEscape(key, true)
+	if len(query) > 0 {
+		uri += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	b.sign(req, payload, time.Now().UTC())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("S3 %s %s: HTTP Status Code %d: %s", method, key, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 authorization of the request
+func (b *S3Backend) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+	if b.config.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", b.config.SessionToken)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+			values[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + b.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.config.SecretKey), date)
+	key = hmacSHA256(key, b.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes the query parameters sorted by name, as signed by SigV4
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, and slashes
+// if keepSlash is set, as required by SigV4
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
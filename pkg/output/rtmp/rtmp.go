@@ -0,0 +1,202 @@
+// Package rtmp provides an output that remuxes the livestream to FLV and publishes
+// it to a remote RTMP server (nginx-rtmp, MediaMTX, YouTube, Twitch, etc.) without
+// an external ffmpeg process.
+//
+// The connection to the server is re-established automatically when it drops, and
+// timestamps are rebased so that Blink session drops do not restart the stream.
+package rtmp
+
+import (
+	rtmpProto "amattu2/blink-middleware/internal/rtmp"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// The rtmp:// or rtmps:// URL to publish to (e.g. "rtmp://localhost/live/blink")
+	URL string
+	// The maximum time to wait for the server to accept the stream (defaults to 10s)
+	ConnectTimeout time.Duration
+	// The delay between attempts to reconnect to the server (defaults to 5s)
+	ReconnectInterval time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+type Output struct {
+	// Configuration options for the output
+	config Config
+	// Guards the fields below
+	mu sync.Mutex
+	// The active publisher, or nil while reconnecting
+	publisher *rtmpProto.Publisher
+	// Demuxer for the incoming transport stream
+	demuxer *mpegts.Demuxer
+	// Muxer producing FLV-wrapped RTMP messages
+	muxer *rtmpProto.Muxer
+	// Keeps timestamps monotonic across sessions
+	rebaser *mpegts.Rebaser
+	// Latest video/audio sequence headers, replayed after reconnecting
+	videoHeader *rtmpProto.Message
+	audioHeader *rtmpProto.Message
+	// Closed when the output is closed
+	closed chan struct{}
+}
+
+// Push connects to the RTMP server and returns an output publishing to it.
+//
+// config: the output configuration
+//
+// Example: Push(Config{URL: "rtmp://localhost/live/blink"}) = &Output{...}, nil
+func Push(config Config) (*Output, error) {
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = 10 * time.Second
+	}
+	if config.ReconnectInterval <= 0 {
+		config.ReconnectInterval = 5 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	publisher, err := rtmpProto.Publish(config.URL, config.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Output{
+		config:    config,
+		publisher: publisher,
+		muxer:     rtmpProto.NewMuxer(),
+		rebaser:   mpegts.NewRebaser(mpegts.DISCONTINUITY_THRESHOLD),
+		closed:    make(chan struct{}),
+	}
+	o.demuxer = mpegts.NewDemuxer(o.handleAccessUnit)
+	go o.supervise(publisher)
+
+	return o, nil
+}
+
+// Server returns the publish URL without the stream key, which is safe to log
+func (o *Output) Server() string {
+	if index := strings.LastIndex(o.config.URL, "/"); index > 0 {
+		return o.config.URL[:index]
+	}
+
+	return o.config.URL
+}
+
+// Write feeds MPEG-TS data from the livestream to the RTMP server
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.demuxer.Write(p)
+}
+
+// Discontinuity signals that the livestream was re-established. Buffered data is
+// discarded and the next timestamps continue from where the previous session ended.
+func (o *Output) Discontinuity() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.demuxer.Reset()
+	o.rebaser.Discontinuity()
+}
+
+// Close unpublishes the stream and stops reconnecting
+func (o *Output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	select {
+	case <-o.closed:
+		return nil
+	default:
+	}
+	close(o.closed)
+
+	if o.publisher != nil {
+		return o.publisher.Close()
+	}
+
+	return nil
+}
+
+func (o *Output) handleAccessUnit(au mpegts.AccessUnit) {
+	if o.rebaser.Rebase(&au) {
+		o.config.OnLog("Timestamp discontinuity detected, rebasing the RTMP stream")
+	}
+
+	messages := o.muxer.Mux(au)
+	for _, msg := range messages {
+		if rtmpProto.IsSequenceHeader(msg) {
+			header := msg
+			if msg.Type == rtmpProto.MSG_VIDEO {
+				o.videoHeader = &header
+			} else {
+				o.audioHeader = &header
+			}
+		}
+	}
+
+	if o.publisher != nil {
+		if dropped := o.publisher.Send(messages...); dropped > 0 {
+			o.config.OnLog(fmt.Sprintf("RTMP server is not keeping up, dropped %d messages up to the next keyframe", dropped))
+		}
+	}
+}
+
+// supervise waits for the publisher to stop and reconnects until the output is closed
+func (o *Output) supervise(publisher *rtmpProto.Publisher) {
+	for {
+		select {
+		case <-publisher.Done():
+		case <-o.closed:
+			return
+		}
+
+		o.mu.Lock()
+		o.publisher = nil
+		o.mu.Unlock()
+		o.config.OnLog(fmt.Sprintf("RTMP publish to %s stopped: %v", o.Server(), publisher.Err()))
+		publisher.Close()
+
+		for {
+			select {
+			case <-time.After(o.config.ReconnectInterval):
+			case <-o.closed:
+				return
+			}
+
+			next, err := rtmpProto.Publish(o.config.URL, o.config.ConnectTimeout)
+			if err != nil {
+				o.config.OnLog(fmt.Sprintf("RTMP reconnect failed: %v", err))
+				continue
+			}
+
+			o.mu.Lock()
+			select {
+			case <-o.closed:
+				o.mu.Unlock()
+				next.Close()
+				return
+			default:
+			}
+			for _, header := range []*rtmpProto.Message{o.videoHeader, o.audioHeader} {
+				if header != nil {
+					next.Send(*header)
+				}
+			}
+			o.publisher = next
+			o.mu.Unlock()
+
+			o.config.OnLog(fmt.Sprintf("RTMP publish to %s resumed", o.Server()))
+			publisher = next
+			break
+		}
+	}
+}
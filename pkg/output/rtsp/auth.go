@@ -0,0 +1,77 @@
+package rtsp
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+// AUTH_REALM is the realm of the Basic authentication challenge
+const AUTH_REALM = "blink-middleware"
+
+// Credentials are the user name and password of a stream
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Access controls which clients may play the streams. The zero value allows every
+// client. Once a token or any credentials are set, every stream requires either
+// the token or the credentials of its path.
+type Access struct {
+	// Optional token accepted for every stream, as the password of any user or as
+	// the token query parameter (e.g. "rtsp://host:8554/front-door?token=...")
+	Token string
+	// Optional credentials per stream path (e.g. "front-door")
+	Users map[string]Credentials
+}
+
+// enabled reports whether any client needs to authenticate
+func (a Access) enabled() bool {
+	return a.Token != "" || len(a.Users) > 0
+}
+
+// allows reports whether a request for the stream path is authorized
+//
+// path: the stream path
+//
+// req: the request carrying the Authorization header or the token query parameter
+func (a Access) allows(path string, req *request) bool {
+	if !a.enabled() {
+		return true
+	}
+
+	if a.Token != "" && equal(req.url.Query().Get("token"), a.Token) {
+		return true
+	}
+
+	username, password, ok := basicAuth(req.headers.Get("Authorization"))
+	if !ok {
+		return false
+	}
+	if a.Token != "" && equal(password, a.Token) {
+		return true
+	}
+	user, ok := a.Users[path]
+
+	return ok && equal(username, user.Username) && equal(password, user.Password)
+}
+
+// basicAuth decodes the user name and password of a Basic Authorization header
+func basicAuth(header string) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+// equal compares secrets in constant time
+func equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,100 @@
+package rtsp
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"encoding/binary"
+)
+
+// RTP payload types advertised in the SDP
+const (
+	PAYLOAD_TYPE_H264 byte = 96
+	PAYLOAD_TYPE_AAC  byte = 97
+)
+
+// MAX_RTP_PAYLOAD keeps packets below a typical path MTU
+const MAX_RTP_PAYLOAD = 1400
+
+type rtpPacketizer struct {
+	payloadType byte
+	ssrc        uint32
+	sequence    uint16
+}
+
+// packet builds a single RTP packet
+func (p *rtpPacketizer) packet(timestamp uint32, marker bool, payload ...[]byte) []byte {
+	pt := p.payloadType
+	if marker {
+		pt |= 0x80
+	}
+
+	pkt := []byte{0x80, pt}
+	pkt = binary.BigEndian.AppendUint16(pkt, p.sequence)
+	pkt = binary.BigEndian.AppendUint32(pkt, timestamp)
+	pkt = binary.BigEndian.AppendUint32(pkt, p.ssrc)
+	for _, part := range payload {
+		pkt = append(pkt, part...)
+	}
+	p.sequence++
+
+	return pkt
+}
+
+// packetizeH264 splits an H.264 access unit into RTP packets (RFC 6184)
+//
+// nalus: the NAL units of the access unit, without start codes
+//
+// timestamp: the RTP timestamp (90kHz PTS)
+func (p *rtpPacketizer) packetizeH264(nalus [][]byte, timestamp uint32) [][]byte {
+	var packets [][]byte
+
+	for i, nalu := range nalus {
+		last := i == len(nalus)-1
+		if len(nalu) <= MAX_RTP_PAYLOAD {
+			packets = append(packets, p.packet(timestamp, last, nalu))
+			continue
+		}
+
+		// Fragmentation unit (FU-A)
+		indicator := nalu[0]&0xe0 | 28
+		naluType := nalu[0] & 0x1f
+		data := nalu[1:]
+		for offset := 0; offset < len(data); offset += MAX_RTP_PAYLOAD - 2 {
+			end := min(offset+MAX_RTP_PAYLOAD-2, len(data))
+			header := naluType
+			if offset == 0 {
+				header |= 0x80
+			}
+			if end == len(data) {
+				header |= 0x40
+			}
+
+			packets = append(packets, p.packet(timestamp, last && end == len(data), []byte{indicator, header}, data[offset:end]))
+		}
+	}
+
+	return packets
+}
+
+// packetizeAAC wraps a raw AAC frame in an RTP packet (RFC 3640, AAC-hbr)
+//
+// frame: the raw AAC frame
+//
+// timestamp: the RTP timestamp in sample rate units
+func (p *rtpPacketizer) packetizeAAC(frame []byte, timestamp uint32) []byte {
+	header := []byte{0x00, 0x10, byte(len(frame) >> 5), byte(len(frame)<<3) & 0xf8}
+
+	return p.packet(timestamp, true, header, frame)
+}
+
+// filterNALUs removes NAL units that should not be sent over RTP
+func filterNALUs(data []byte) [][]byte {
+	var nalus [][]byte
+	for _, nalu := range mpegts.SplitAnnexB(data) {
+		if len(nalu) == 0 || mpegts.H264NALType(nalu) == mpegts.H264_NAL_AUD {
+			continue
+		}
+		nalus = append(nalus, nalu)
+	}
+
+	return nalus
+}
@@ -0,0 +1,751 @@
+// Package rtsp serves livestreams to RTSP clients (VLC, ffmpeg, NVRs).
+//
+// Each named stream is fed with MPEG-TS data and re-packetized as H.264 (RFC 6184)
+// and AAC (RFC 3640) RTP tracks. Both TCP-interleaved and UDP transports are supported.
+package rtsp
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_ADDR is the default listen address of the RTSP server
+const DEFAULT_ADDR = ":8554"
+
+// SESSION_TIMEOUT is how long a session survives without any request
+const SESSION_TIMEOUT = 60 * time.Second
+
+// SESSION_QUEUE_SIZE is the number of RTP packets buffered per session
+const SESSION_QUEUE_SIZE = 2048
+
+type Config struct {
+	// The TCP address to listen on (defaults to DEFAULT_ADDR)
+	Addr string
+	// Optional callback for client connection messages
+	OnLog func(string)
+	// Optional metrics backend reporting the sessions per stream
+	Metrics metrics.Metrics
+	// Optional access control of the streams. Every client may play them by default
+	Access Access
+	// Optional admission of new sessions by stream path. It returns the function
+	// called once the session ends, or an error refusing the session with 453 Not
+	// Enough Bandwidth
+	Admit func(path string) (func(), error)
+	// Optional callback starting the stream of a path on DESCRIBE, returning once
+	// it is fed. An error answers 404 Not Found for unknown streams, and 503 Service
+	// Unavailable otherwise
+	Demand func(path string) error
+}
+
+type Server struct {
+	// The TCP listener for RTSP connections
+	listener net.Listener
+	// Callback for logging messages
+	onLog func(string)
+	// Metrics backend for session measurements
+	metrics metrics.Metrics
+	// Access control of the streams
+	access Access
+	// Admission of new sessions, or nil to admit every session
+	admit func(path string) (func(), error)
+	// Starts the streams of the paths on demand, or nil
+	demand func(path string) error
+	// Guards the fields below
+	mu sync.Mutex
+	// Streams keyed by path
+	streams map[string]*Stream
+	// Sessions keyed by session ID
+	sessions map[string]*session
+	// Whether the server has been closed
+	closed bool
+}
+
+// Listen starts an RTSP server on the given address.
+//
+// addr: the TCP address to listen on (e.g. ":8554")
+//
+// onLog: optional callback for client connection messages
+//
+// Example: Listen(":8554", nil) = &Server{...}, nil
+func Listen(addr string, onLog func(string)) (*Server, error) {
+	return ListenWithConfig(Config{Addr: addr, OnLog: onLog})
+}
+
+// ListenWithConfig starts an RTSP server with the provided configuration.
+//
+// config: the server configuration
+//
+// Example: ListenWithConfig(Config{Addr: ":8554"}) = &Server{...}, nil
+func ListenWithConfig(config Config) (*Server, error) {
+	if config.Addr == "" {
+		config.Addr = DEFAULT_ADDR
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+	if config.Metrics == nil {
+		config.Metrics = metrics.Noop
+	}
+
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", config.Addr, err)
+	}
+
+	s := &Server{
+		listener: listener,
+		onLog:    config.OnLog,
+		metrics:  config.Metrics,
+		access:   config.Access,
+		admit:    config.Admit,
+		demand:   config.Demand,
+		streams:  map[string]*Stream{},
+		sessions: map[string]*session{},
+	}
+	go s.accept()
+	go s.expireSessions()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stream returns the stream served at the given path, creating it if necessary
+//
+// name: the stream path (e.g. "front-door")
+//
+// Example: Stream("front-door") = &Stream{...}
+func (s *Server) Stream(name string) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = strings.Trim(name, "/")
+	if st, ok := s.streams[name]; ok {
+		return st
+	}
+
+	st := newStream(name, s.metrics)
+	s.streams[name] = st
+
+	return st
+}
+
+// URL returns the RTSP URL of a stream for the given host
+//
+// host: the host clients should connect to (e.g. "192.168.1.10")
+//
+// name: the stream path
+//
+// Example: URL("192.168.1.10", "front-door") = "rtsp://192.168.1.10:8554/front-door"
+func (s *Server) URL(host string, name string) string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+
+	return fmt.Sprintf("rtsp://%s/%s", net.JoinHostPort(host, port), strings.Trim(name, "/"))
+}
+
+// Close stops the server and terminates all sessions
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	sessions := s.sessions
+	s.sessions = map[string]*session{}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.close()
+	}
+
+	return s.listener.Close()
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) expireSessions() {
+	ticker := time.NewTicker(SESSION_TIMEOUT / 4)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		var expired []*session
+		for id, sess := range s.sessions {
+			if sess.expired() {
+				expired = append(expired, sess)
+				delete(s.sessions, id)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, sess := range expired {
+			s.onLog(fmt.Sprintf("RTSP session %s timed out", sess.id))
+			sess.close()
+		}
+	}
+}
+
+type request struct {
+	method  string
+	url     *url.URL
+	headers textproto.MIMEHeader
+}
+
+// rtspConn is a single RTSP control connection
+type rtspConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	// The stream paths the client authenticated for on this connection, so the
+	// requests following DESCRIBE need not repeat a token passed in its URL
+	authorized map[string]bool
+}
+
+func (c *rtspConn) readRequest() (*request, error) {
+	for {
+		// Skip interleaved RTCP data sent by the client
+		first, err := c.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if first[0] == '$' {
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(c.reader, header); err != nil {
+				return nil, err
+			}
+			if _, err := c.reader.Discard(int(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		break
+	}
+
+	tp := textproto.NewReader(c.reader)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "RTSP/") {
+		return nil, fmt.Errorf("malformed request line: %q", line)
+	}
+
+	requestUrl, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed request URL: %w", err)
+	}
+
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// Request bodies (e.g. SET_PARAMETER) are not used
+	if length, _ := strconv.Atoi(headers.Get("Content-Length")); length > 0 {
+		if _, err := c.reader.Discard(length); err != nil {
+			return nil, err
+		}
+	}
+
+	return &request{method: parts[0], url: requestUrl, headers: headers}, nil
+}
+
+func (c *rtspConn) writeResponse(req *request, status int, reason string, headers map[string]string, body string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", status, reason)
+	fmt.Fprintf(&b, "CSeq: %s\r\n", req.headers.Get("CSeq"))
+	b.WriteString("Server: blink-middleware\r\n")
+	for key, value := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	if body != "" {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.WriteString(body)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := io.WriteString(c.conn, b.String())
+
+	return err
+}
+
+// writeInterleaved writes an RTP packet on an interleaved channel
+func (c *rtspConn) writeInterleaved(channel byte, packet []byte) error {
+	frame := append([]byte{'$', channel}, binary.BigEndian.AppendUint16(nil, uint16(len(packet)))...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(append(frame, packet...))
+
+	return err
+}
+
+// serve handles the requests of a single RTSP connection
+func (s *Server) serve(netConn net.Conn) {
+	conn := &rtspConn{conn: netConn, reader: bufio.NewReader(netConn), authorized: map[string]bool{}}
+	defer netConn.Close()
+
+	// Sessions using interleaved transport end with their connection
+	var owned []*session
+	defer func() {
+		for _, sess := range owned {
+			s.teardown(sess)
+		}
+	}()
+
+	for {
+		req, err := conn.readRequest()
+		if err != nil {
+			return
+		}
+
+		sess, err := s.handle(conn, req)
+		if err != nil {
+			s.onLog(fmt.Sprintf("RTSP %s %s from %s: %v", req.method, req.url, netConn.RemoteAddr(), err))
+			return
+		}
+		if sess != nil && sess.interleaved {
+			owned = append(owned, sess)
+		}
+	}
+}
+
+// handle processes a single request, returning a newly created session if any
+func (s *Server) handle(conn *rtspConn, req *request) (*session, error) {
+	switch req.method {
+	case "OPTIONS":
+		return nil, conn.writeResponse(req, 200, "OK", map[string]string{
+			"Public": "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER",
+		}, "")
+	case "DESCRIBE":
+		if s.demand != nil {
+			// Clients are authorized before they start a stream
+			path := strings.Trim(req.url.Path, "/")
+			if !s.authorize(conn, path, req) {
+				return nil, unauthorized(conn, req)
+			}
+			if err := s.demand(path); err != nil {
+				s.onLog(fmt.Sprintf("RTSP DESCRIBE %s from %s: %v", path, conn.conn.RemoteAddr(), err))
+				if st, _ := s.lookup(req.url); st != nil {
+					return nil, conn.writeResponse(req, 503, "Service Unavailable", nil, "")
+				}
+			}
+		}
+		st, baseUrl := s.lookup(req.url)
+		if st == nil {
+			return nil, conn.writeResponse(req, 404, "Not Found", nil, "")
+		}
+		if !s.authorize(conn, st.name, req) {
+			return nil, unauthorized(conn, req)
+		}
+
+		return nil, conn.writeResponse(req, 200, "OK", map[string]string{
+			"Content-Base": baseUrl + "/",
+			"Content-Type": "application/sdp",
+		}, st.sdp(baseUrl))
+	case "SETUP":
+		return s.handleSetup(conn, req)
+	case "PLAY":
+		sess := s.session(req)
+		if sess == nil {
+			return nil, conn.writeResponse(req, 454, "Session Not Found", nil, "")
+		}
+
+		sess.play()
+		s.onLog(fmt.Sprintf("RTSP session %s playing %s", sess.id, sess.stream.name))
+
+		return nil, conn.writeResponse(req, 200, "OK", map[string]string{
+			"Session":  sess.id,
+			"Range":    "npt=now-",
+			"RTP-Info": sess.stream.rtpInfo(sess.baseUrl),
+		}, "")
+	case "TEARDOWN":
+		if sess := s.session(req); sess != nil {
+			s.teardown(sess)
+		}
+
+		return nil, conn.writeResponse(req, 200, "OK", nil, "")
+	case "GET_PARAMETER", "SET_PARAMETER":
+		// Used by clients as a keep-alive
+		headers := map[string]string{}
+		if sess := s.session(req); sess != nil {
+			headers["Session"] = sess.id
+		}
+
+		return nil, conn.writeResponse(req, 200, "OK", headers, "")
+	default:
+		return nil, conn.writeResponse(req, 501, "Not Implemented", nil, "")
+	}
+}
+
+func (s *Server) handleSetup(conn *rtspConn, req *request) (*session, error) {
+	// The track URL is <base>/trackID=N
+	path := strings.Trim(req.url.Path, "/")
+	track := TRACK_VIDEO
+	if i := strings.LastIndex(path, "/trackID="); i >= 0 {
+		track, _ = strconv.Atoi(path[i+len("/trackID="):])
+		path = path[:i]
+	}
+	baseUrl := requestBase(req.url) + "/" + path
+
+	s.mu.Lock()
+	st := s.streams[path]
+	s.mu.Unlock()
+	if st == nil || (track != TRACK_VIDEO && track != TRACK_AUDIO) {
+		return nil, conn.writeResponse(req, 404, "Not Found", nil, "")
+	}
+	if !s.authorize(conn, path, req) {
+		return nil, unauthorized(conn, req)
+	}
+
+	created := false
+	sess := s.session(req)
+	if sess == nil {
+		var release func()
+		if s.admit != nil {
+			var err error
+			if release, err = s.admit(path); err != nil {
+				s.onLog(fmt.Sprintf("RTSP SETUP %s from %s refused: %v", path, conn.conn.RemoteAddr(), err))
+				return nil, conn.writeResponse(req, 453, "Not Enough Bandwidth", nil, "")
+			}
+		}
+		sess = newSession(st, baseUrl)
+		sess.release = release
+		created = true
+	}
+
+	transport, err := sess.setup(conn, track, req.headers.Get("Transport"))
+	if err != nil {
+		if created {
+			sess.close()
+		}
+		return nil, conn.writeResponse(req, 461, "Unsupported Transport", nil, "")
+	}
+
+	if created {
+		s.mu.Lock()
+		s.sessions[sess.id] = sess
+		s.mu.Unlock()
+	}
+
+	if err := conn.writeResponse(req, 200, "OK", map[string]string{
+		"Session":   fmt.Sprintf("%s;timeout=%d", sess.id, int(SESSION_TIMEOUT.Seconds())),
+		"Transport": transport,
+	}, ""); err != nil {
+		return nil, err
+	}
+
+	if created {
+		return sess, nil
+	}
+
+	return nil, nil
+}
+
+// lookup resolves the stream for a request URL and returns its base URL
+func (s *Server) lookup(u *url.URL) (*Stream, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.Trim(u.Path, "/")
+	st := s.streams[path]
+
+	return st, requestBase(u) + "/" + path
+}
+
+// requestBase returns the scheme and host of a request URL, without the path and
+// query (e.g. "rtsp://192.168.1.10:8554")
+func requestBase(u *url.URL) string {
+	base := *u
+	base.Path, base.RawPath, base.RawQuery, base.Fragment = "", "", "", ""
+
+	return base.String()
+}
+
+// authorize checks the access to a stream, remembering it for the connection
+func (s *Server) authorize(conn *rtspConn, path string, req *request) bool {
+	if conn.authorized[path] {
+		return true
+	}
+	if !s.access.allows(path, req) {
+		s.onLog(fmt.Sprintf("RTSP %s %s from %s: unauthorized", req.method, path, conn.conn.RemoteAddr()))
+		return false
+	}
+	conn.authorized[path] = true
+
+	return true
+}
+
+// unauthorized answers a request with the Basic authentication challenge
+func unauthorized(conn *rtspConn, req *request) error {
+	return conn.writeResponse(req, 401, "Unauthorized", map[string]string{
+		"WWW-Authenticate": fmt.Sprintf("Basic realm=%q", AUTH_REALM),
+	}, "")
+}
+
+// session returns the session referenced by the request, refreshing its timeout
+func (s *Server) session(req *request) *session {
+	id, _, _ := strings.Cut(req.headers.Get("Session"), ";")
+	if id == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess := s.sessions[strings.TrimSpace(id)]
+	if sess != nil {
+		sess.touch()
+	}
+
+	return sess
+}
+
+func (s *Server) teardown(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+
+	sess.close()
+}
+
+// session is a single RTSP playback session
+type session struct {
+	// The session identifier
+	id string
+	// The stream being played
+	stream *Stream
+	// The base URL of the stream
+	baseUrl string
+	// Whether the session uses TCP-interleaved transport
+	interleaved bool
+	// Guards the fields below
+	mu sync.Mutex
+	// The control connection for interleaved transport
+	conn *rtspConn
+	// Interleaved RTP channel per track
+	channels map[int]byte
+	// UDP connection per track
+	udp map[int]*net.UDPConn
+	// Time of the last request on the session
+	lastSeen time.Time
+	// Whether the session is waiting for a keyframe
+	waitKeyframe bool
+	// Outgoing packet queue
+	queue chan queuedPacket
+	// Whether the session is playing
+	playing bool
+	// Whether the session has been closed
+	closed bool
+	// Releases the admission of the session, or nil
+	release func()
+}
+
+type queuedPacket struct {
+	track  int
+	packet []byte
+}
+
+func newSession(st *Stream, baseUrl string) *session {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, uint64(randomUint32())<<32|uint64(randomUint32()))
+
+	return &session{
+		id:           hex.EncodeToString(id),
+		stream:       st,
+		baseUrl:      baseUrl,
+		channels:     map[int]byte{},
+		udp:          map[int]*net.UDPConn{},
+		lastSeen:     time.Now(),
+		waitKeyframe: true,
+		queue:        make(chan queuedPacket, SESSION_QUEUE_SIZE),
+	}
+}
+
+// setup configures the transport of a track and returns the Transport response header
+func (sess *session) setup(conn *rtspConn, track int, transport string) (string, error) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	params := map[string]string{}
+	for _, part := range strings.Split(transport, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		params[strings.ToLower(key)] = value
+	}
+
+	if strings.Contains(transport, "RTP/AVP/TCP") || params["interleaved"] != "" {
+		channel := byte(track * 2)
+		if interleaved := params["interleaved"]; interleaved != "" {
+			first, _, _ := strings.Cut(interleaved, "-")
+			n, err := strconv.Atoi(first)
+			if err != nil || n < 0 || n > 254 {
+				return "", errors.New("invalid interleaved channel")
+			}
+			channel = byte(n)
+		}
+
+		sess.interleaved = true
+		sess.conn = conn
+		sess.channels[track] = channel
+
+		return fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1), nil
+	}
+
+	clientPort, _, _ := strings.Cut(params["client_port"], "-")
+	port, err := strconv.Atoi(clientPort)
+	if err != nil || port <= 0 {
+		return "", errors.New("missing client port")
+	}
+
+	host, _, _ := net.SplitHostPort(conn.conn.RemoteAddr().String())
+	udpConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP(host), Port: port})
+	if err != nil {
+		return "", err
+	}
+	if previous, ok := sess.udp[track]; ok {
+		previous.Close()
+	}
+	sess.udp[track] = udpConn
+
+	localPort := udpConn.LocalAddr().(*net.UDPAddr).Port
+	return fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d", port, port+1, localPort, localPort+1), nil
+}
+
+func (sess *session) play() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.playing || sess.closed {
+		return
+	}
+	sess.playing = true
+
+	go sess.send()
+	sess.stream.addSession(sess)
+}
+
+// send writes queued packets to the client until the session is closed
+func (sess *session) send() {
+	for pkt := range sess.queue {
+		sess.mu.Lock()
+		conn := sess.conn
+		channel, interleaved := sess.channels[pkt.track]
+		udpConn := sess.udp[pkt.track]
+		sess.mu.Unlock()
+
+		if interleaved && conn != nil {
+			if err := conn.writeInterleaved(channel, pkt.packet); err != nil {
+				// Unblocks the control connection, which tears the session down
+				conn.conn.Close()
+			}
+		} else if udpConn != nil {
+			udpConn.Write(pkt.packet)
+		}
+	}
+}
+
+// sendVideo queues the packets of a video access unit
+func (sess *session) sendVideo(packets [][]byte, keyframe bool) {
+	if sess.waitKeyframe && !keyframe {
+		return
+	}
+	sess.waitKeyframe = false
+
+	for _, packet := range packets {
+		if !sess.enqueue(queuedPacket{track: TRACK_VIDEO, packet: packet}) {
+			return
+		}
+	}
+}
+
+// sendAudio queues an audio packet once video playback has started
+func (sess *session) sendAudio(packet []byte) {
+	if sess.waitKeyframe {
+		return
+	}
+
+	sess.enqueue(queuedPacket{track: TRACK_AUDIO, packet: packet})
+}
+
+// enqueue queues a packet, dropping the backlog if the client cannot keep up
+func (sess *session) enqueue(pkt queuedPacket) bool {
+	select {
+	case sess.queue <- pkt:
+		return true
+	default:
+		for len(sess.queue) > 0 {
+			<-sess.queue
+		}
+		sess.waitKeyframe = true
+		return false
+	}
+}
+
+func (sess *session) touch() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.lastSeen = time.Now()
+}
+
+// expired returns whether a UDP session has not been refreshed within the timeout.
+// Interleaved sessions live as long as their connection.
+func (sess *session) expired() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	return !sess.interleaved && time.Since(sess.lastSeen) > SESSION_TIMEOUT
+}
+
+func (sess *session) close() {
+	sess.stream.removeSession(sess)
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.closed {
+		return
+	}
+	sess.closed = true
+	if sess.release != nil {
+		sess.release()
+	}
+
+	for _, udpConn := range sess.udp {
+		udpConn.Close()
+	}
+	close(sess.queue)
+}
@@ -0,0 +1,203 @@
+package rtsp
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/mpegts"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Track IDs used in the SDP control attributes
+const (
+	TRACK_VIDEO = 0
+	TRACK_AUDIO = 1
+)
+
+// Stream is a named RTSP stream fed with MPEG-TS data. It implements io.Writer.
+type Stream struct {
+	// The stream path (e.g. "front-door")
+	name string
+	// Guards the fields below
+	mu sync.Mutex
+	// Demuxer for the incoming transport stream
+	demuxer *mpegts.Demuxer
+	// The latest H.264 parameter sets
+	sps []byte
+	pps []byte
+	// The latest AAC configuration, or nil if no audio has been seen
+	aac *mpegts.ADTSFrame
+	// Packetizers for each track
+	video rtpPacketizer
+	audio rtpPacketizer
+	// Sessions currently playing the stream
+	sessions map[*session]struct{}
+	// Metrics backend reporting the number of sessions
+	metrics metrics.Metrics
+}
+
+func newStream(name string, m metrics.Metrics) *Stream {
+	st := &Stream{
+		name:     name,
+		metrics:  m,
+		video:    rtpPacketizer{payloadType: PAYLOAD_TYPE_H264, ssrc: randomUint32(), sequence: uint16(randomUint32())},
+		audio:    rtpPacketizer{payloadType: PAYLOAD_TYPE_AAC, ssrc: randomUint32(), sequence: uint16(randomUint32())},
+		sessions: map[*session]struct{}{},
+	}
+	st.demuxer = mpegts.NewDemuxer(st.handleAccessUnit)
+
+	return st
+}
+
+// Name returns the stream path
+func (st *Stream) Name() string {
+	return st.name
+}
+
+// Viewers returns the number of sessions playing the stream
+func (st *Stream) Viewers() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return len(st.sessions)
+}
+
+// Write feeds MPEG-TS data to the stream
+func (st *Stream) Write(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.demuxer.Write(p)
+}
+
+// Discontinuity discards buffered data after the livestream was re-established
+func (st *Stream) Discontinuity() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.demuxer.Reset()
+}
+
+func (st *Stream) handleAccessUnit(au mpegts.AccessUnit) {
+	switch au.StreamType {
+	case mpegts.STREAM_TYPE_H264:
+		nalus := filterNALUs(au.Data)
+		keyframe := false
+		for _, nalu := range nalus {
+			switch mpegts.H264NALType(nalu) {
+			case mpegts.H264_NAL_SPS:
+				st.sps = nalu
+			case mpegts.H264_NAL_PPS:
+				st.pps = nalu
+			case mpegts.H264_NAL_IDR:
+				keyframe = true
+			}
+		}
+		if len(nalus) == 0 {
+			return
+		}
+
+		packets := st.video.packetizeH264(nalus, uint32(au.PTS))
+		for s := range st.sessions {
+			s.sendVideo(packets, keyframe)
+		}
+	case mpegts.STREAM_TYPE_AAC:
+		for i, frame := range mpegts.SplitADTS(au.Data) {
+			sampleRate := frame.SampleRate()
+			if sampleRate == 0 {
+				continue
+			}
+			if st.aac == nil {
+				config := frame
+				config.Data = nil
+				st.aac = &config
+			}
+
+			timestamp := au.PTS*int64(sampleRate)/mpegts.CLOCK_RATE + int64(i)*1024
+			packet := st.audio.packetizeAAC(frame.Data, uint32(timestamp))
+			for s := range st.sessions {
+				s.sendAudio(packet)
+			}
+		}
+	}
+}
+
+// sdp returns the session description for the stream
+func (st *Stream) sdp(baseUrl string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	lines := []string{
+		"v=0",
+		"o=- 0 0 IN IP4 0.0.0.0",
+		"s=" + st.name,
+		"c=IN IP4 0.0.0.0",
+		"t=0 0",
+		"a=control:*",
+		"a=range:npt=now-",
+		fmt.Sprintf("m=video 0 RTP/AVP %d", PAYLOAD_TYPE_H264),
+		fmt.Sprintf("a=rtpmap:%d H264/90000", PAYLOAD_TYPE_H264),
+	}
+
+	fmtp := fmt.Sprintf("a=fmtp:%d packetization-mode=1", PAYLOAD_TYPE_H264)
+	if len(st.sps) >= 4 && st.pps != nil {
+		fmtp += fmt.Sprintf(";profile-level-id=%s;sprop-parameter-sets=%s,%s",
+			hex.EncodeToString(st.sps[1:4]),
+			base64.StdEncoding.EncodeToString(st.sps),
+			base64.StdEncoding.EncodeToString(st.pps),
+		)
+	}
+	lines = append(lines, fmtp, fmt.Sprintf("a=control:%s/trackID=%d", baseUrl, TRACK_VIDEO))
+
+	if st.aac != nil {
+		lines = append(lines,
+			fmt.Sprintf("m=audio 0 RTP/AVP %d", PAYLOAD_TYPE_AAC),
+			fmt.Sprintf("a=rtpmap:%d MPEG4-GENERIC/%d/%d", PAYLOAD_TYPE_AAC, st.aac.SampleRate(), st.aac.Channels),
+			fmt.Sprintf("a=fmtp:%d streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=%s",
+				PAYLOAD_TYPE_AAC, hex.EncodeToString(st.aac.AudioSpecificConfig())),
+			fmt.Sprintf("a=control:%s/trackID=%d", baseUrl, TRACK_AUDIO),
+		)
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// rtpInfo returns the RTP-Info header value for a PLAY response
+func (st *Stream) rtpInfo(baseUrl string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	info := fmt.Sprintf("url=%s/trackID=%d;seq=%d", baseUrl, TRACK_VIDEO, st.video.sequence)
+	if st.aac != nil {
+		info += fmt.Sprintf(",url=%s/trackID=%d;seq=%d", baseUrl, TRACK_AUDIO, st.audio.sequence)
+	}
+
+	return info
+}
+
+func (st *Stream) addSession(s *session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.sessions[s] = struct{}{}
+	st.metrics.Gauge(metrics.RTSP_SESSIONS, float64(len(st.sessions)), metrics.Labels{"stream": st.name})
+}
+
+func (st *Stream) removeSession(s *session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.sessions, s)
+	st.metrics.Gauge(metrics.RTSP_SESSIONS, float64(len(st.sessions)), metrics.Labels{"stream": st.name})
+}
+
+func randomUint32() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return binary.BigEndian.Uint32(b)
+}
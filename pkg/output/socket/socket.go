@@ -0,0 +1,186 @@
+// Package socket serves the livestream on a Unix domain socket (or TCP address), so
+// that local consumers such as ffmpeg, go2rtc, or custom applications can attach and
+// detach at any time without restarting the Blink session.
+//
+// Every attached consumer receives the stream from the next transport stream packet
+// on. While no consumer is attached, the stream data is discarded so the Blink
+// connection is never stalled, and a consumer that falls behind is disconnected.
+package socket
+
+import (
+	"amattu2/blink-middleware/pkg/output/buffer"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// Networks of the listen address
+const (
+	NETWORK_UNIX = "unix"
+	NETWORK_TCP  = "tcp"
+)
+
+type Config struct {
+	// The network to listen on, NETWORK_UNIX or NETWORK_TCP (defaults to NETWORK_UNIX)
+	Network string
+	// The socket path or TCP address to listen on
+	Address string
+	// The bytes buffered for each consumer before it is disconnected for falling
+	// behind (defaults to buffer.DEFAULT_SIZE)
+	BufferSize int
+	// Callback for logging consumer attach/detach messages
+	OnLog func(string)
+}
+
+type Writer struct {
+	// Configuration options for the writer
+	config Config
+	// The listener consumers connect to
+	listener net.Listener
+	// Guards the fields below
+	mu sync.Mutex
+	// The buffered writers of the attached consumers
+	consumers map[*buffer.Writer]net.Conn
+	// Whether the writer has been closed
+	closed bool
+}
+
+// Listen starts listening for consumers. A Unix socket left behind by a stopped
+// process is replaced, while one still in use is an error.
+//
+// config: the socket configuration
+//
+// Example: Listen(Config{Address: "/tmp/cam.sock"}) = &Writer{...}, nil
+func Listen(config Config) (*Writer, error) {
+	if config.Network == "" {
+		config.Network = NETWORK_UNIX
+	}
+	if config.Network != NETWORK_UNIX && config.Network != NETWORK_TCP {
+		return nil, fmt.Errorf("unsupported socket network %q", config.Network)
+	}
+	if config.Address == "" {
+		return nil, errors.New("no socket address configured")
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	listener, err := net.Listen(config.Network, config.Address)
+	if err != nil && config.Network == NETWORK_UNIX {
+		if _, statErr := os.Stat(config.Address); statErr == nil {
+			if conn, dialErr := net.Dial(config.Network, config.Address); dialErr == nil {
+				conn.Close()
+			} else if os.Remove(config.Address) == nil {
+				listener, err = net.Listen(config.Network, config.Address)
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", config.Address, err)
+	}
+
+	w := &Writer{
+		config:    config,
+		listener:  listener,
+		consumers: map[*buffer.Writer]net.Conn{},
+	}
+	go w.accept()
+
+	return w, nil
+}
+
+// Addr returns the address consumers connect to
+func (w *Writer) Addr() string {
+	return w.listener.Addr().String()
+}
+
+// Consumers returns the number of attached consumers
+func (w *Writer) Consumers() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.consumers)
+}
+
+// accept attaches the consumers connecting to the listener until it is closed
+func (w *Writer) accept() {
+	for {
+		conn, err := w.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		consumer, err := buffer.New(conn, buffer.Config{
+			Size:   w.config.BufferSize,
+			Policy: buffer.POLICY_DISCONNECT,
+			Name:   "socket",
+			OnLog:  w.config.OnLog,
+		})
+		if err != nil {
+			w.config.OnLog(fmt.Sprintf("Error attaching consumer: %v", err))
+			conn.Close()
+			continue
+		}
+
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			consumer.Close()
+			conn.Close()
+			return
+		}
+		w.consumers[consumer] = conn
+		count := len(w.consumers)
+		w.mu.Unlock()
+
+		w.config.OnLog(fmt.Sprintf("Consumer attached to %s (%d attached)", w.config.Address, count))
+	}
+}
+
+// Write passes the data to the attached consumers. Data is discarded when no
+// consumer is attached, and consumers that fail or fall behind are detached.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, errors.New("socket is closed")
+	}
+
+	for consumer, conn := range w.consumers {
+		if _, err := consumer.Write(p); err != nil {
+			delete(w.consumers, consumer)
+			go func() {
+				consumer.Close()
+				conn.Close()
+			}()
+			w.config.OnLog(fmt.Sprintf("Consumer detached from %s: %v", w.config.Address, err))
+		}
+	}
+
+	return len(p), nil
+}
+
+// Close flushes and detaches the consumers and stops listening. A Unix socket is
+// removed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	consumers := w.consumers
+	w.consumers = nil
+	w.mu.Unlock()
+
+	err := w.listener.Close()
+	for consumer, conn := range consumers {
+		consumer.Close()
+		conn.Close()
+	}
+
+	return err
+}
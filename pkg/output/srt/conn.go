@@ -0,0 +1,237 @@
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// KEEPALIVE_INTERVAL is the idle time after which a keepalive is sent
+	KEEPALIVE_INTERVAL = time.Second
+	// PEER_TIMEOUT is the time without any packet from the peer after which the connection is broken
+	PEER_TIMEOUT = 5 * time.Second
+)
+
+type sentPacket struct {
+	packet packet
+	sentAt time.Time
+}
+
+// conn is the sending side of an established SRT connection
+type conn struct {
+	// Sends a raw packet to the peer
+	send func([]byte) error
+	// Our socket ID and the peer's socket ID
+	localId uint32
+	peerId  uint32
+	// The peer's stream ID, if any
+	streamId string
+	// The negotiated TSBPD latency
+	latency time.Duration
+	// The connection start time, used for packet timestamps
+	start time.Time
+	// The handshake response, resent if the peer repeats its conclusion
+	response []byte
+
+	mu       sync.Mutex
+	nextSeq  uint32
+	nextMsg  uint32
+	sent     []sentPacket
+	lastSend time.Time
+	lastRecv time.Time
+	done     chan struct{}
+	err      error
+}
+
+func newConn(send func([]byte) error, localId uint32, peerId uint32, isn uint32, latency time.Duration) *conn {
+	now := time.Now()
+	c := &conn{
+		send:     send,
+		localId:  localId,
+		peerId:   peerId,
+		latency:  latency,
+		start:    now,
+		nextSeq:  isn,
+		nextMsg:  1,
+		lastSend: now,
+		lastRecv: now,
+		done:     make(chan struct{}),
+	}
+	go c.maintain()
+
+	return c
+}
+
+func (c *conn) timestamp() uint32 {
+	return uint32(time.Since(c.start).Microseconds())
+}
+
+// write sends a payload as a single data packet
+func (c *conn) write(payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.err
+	default:
+	}
+
+	p := packet{
+		seq:       c.nextSeq,
+		info:      dataSolo | c.nextMsg&msgNumMask,
+		timestamp: c.timestamp(),
+		destId:    c.peerId,
+		payload:   append([]byte(nil), payload...),
+	}
+	c.nextSeq = (c.nextSeq + 1) & seqMask
+	c.nextMsg = (c.nextMsg + 1) & msgNumMask
+	if c.nextMsg == 0 {
+		c.nextMsg = 1
+	}
+
+	now := time.Now()
+	c.sent = append(c.sent, sentPacket{packet: p, sentAt: now})
+	c.lastSend = now
+
+	// Lost packets are recovered through NAKs, so send errors are not fatal
+	c.send(p.marshal())
+
+	return nil
+}
+
+// handle processes a packet received from the peer
+func (c *conn) handle(p packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastRecv = time.Now()
+	if !p.control {
+		return
+	}
+
+	switch p.ctrlType {
+	case ctrlAck:
+		if len(p.payload) < 4 {
+			return
+		}
+		ack := binary.BigEndian.Uint32(p.payload) & seqMask
+		for len(c.sent) > 0 && seqDiff(c.sent[0].packet.seq, ack) > 0 {
+			c.sent = c.sent[1:]
+		}
+
+		// Full ACKs carry an ACK number that must be acknowledged for RTT estimation
+		if len(p.payload) >= 16 {
+			c.sendControl(ctrlAckAck, p.info, nil)
+		}
+	case ctrlNak:
+		for _, seq := range parseLossList(p.payload) {
+			c.retransmit(seq)
+		}
+	case ctrlHandshake:
+		// The peer did not receive our conclusion response
+		if c.response != nil {
+			c.send(c.response)
+		}
+	case ctrlShutdown:
+		c.stop(errors.New("peer closed the connection"))
+	}
+}
+
+func (c *conn) retransmit(seq uint32) {
+	if len(c.sent) == 0 {
+		return
+	}
+
+	index := int(seqDiff(c.sent[0].packet.seq, seq))
+	if index < 0 || index >= len(c.sent) {
+		return
+	}
+
+	p := c.sent[index].packet
+	p.info |= dataRexmit
+	c.send(p.marshal())
+}
+
+func (c *conn) sendControl(ctrlType uint16, info uint32, payload []byte) {
+	c.send(packet{
+		control:   true,
+		ctrlType:  ctrlType,
+		info:      info,
+		timestamp: c.timestamp(),
+		destId:    c.peerId,
+		payload:   payload,
+	}.marshal())
+	c.lastSend = time.Now()
+}
+
+// maintain sends keepalives, drops packets that are too late to be played, and
+// detects a silent peer
+func (c *conn) maintain() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	// Packets older than this can no longer be played by the receiver
+	dropAge := c.latency + time.Second
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			if now.Sub(c.lastRecv) > PEER_TIMEOUT {
+				c.stop(errors.New("peer timed out"))
+				c.mu.Unlock()
+				return
+			}
+			if now.Sub(c.lastSend) > KEEPALIVE_INTERVAL {
+				c.sendControl(ctrlKeepalive, 0, nil)
+			}
+
+			dropped := 0
+			for dropped < len(c.sent) && now.Sub(c.sent[dropped].sentAt) > dropAge {
+				dropped++
+			}
+			if dropped > 0 {
+				first := c.sent[0].packet
+				last := c.sent[dropped-1].packet
+				payload := binary.BigEndian.AppendUint32(nil, first.seq)
+				payload = binary.BigEndian.AppendUint32(payload, last.seq)
+				c.sendControl(ctrlDropReq, first.info&msgNumMask, payload)
+				c.sent = c.sent[dropped:]
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// close sends a shutdown to the peer and stops the connection
+func (c *conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	c.sendControl(ctrlShutdown, 0, make([]byte, 4))
+	c.stop(nil)
+}
+
+// stop records the error and signals that the connection ended. c.mu must be held.
+func (c *conn) stop(err error) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	c.err = err
+	c.sent = nil
+	close(c.done)
+}
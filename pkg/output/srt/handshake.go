@@ -0,0 +1,234 @@
+package srt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Rejection reasons sent to callers
+const (
+	rejectVersion  uint32 = 1008
+	rejectUnsecure uint32 = 1011
+)
+
+const (
+	defaultMTU    = 1500
+	defaultWindow = 8192
+	// The extension field of an HSv4 induction request (UDT_DGRAM)
+	inductionExtension uint16 = 2
+	// The KMREQ flag in the extension field, set by callers that require encryption
+	extFlagKMReq uint16 = 0x2
+)
+
+// callerHandshake connects to a remote listener over the UDP socket
+func callerHandshake(udp *net.UDPConn, config Config) (*conn, error) {
+	localId := randomUint32()
+	isn := randomUint32() & seqMask
+	start := time.Now()
+	send := func(b []byte) error {
+		_, err := udp.Write(b)
+		return err
+	}
+
+	request := handshake{
+		version:   4,
+		extension: inductionExtension,
+		isn:       isn,
+		mtu:       defaultMTU,
+		window:    defaultWindow,
+		hsType:    hsInduction,
+		socketId:  localId,
+	}
+	extType := uint16(0)
+
+	deadline := start.Add(config.ConnectTimeout)
+	buf := make([]byte, defaultMTU)
+	for {
+		if time.Now().After(deadline) {
+			return nil, errors.New("handshake timed out")
+		}
+
+		send(packet{
+			control:   true,
+			ctrlType:  ctrlHandshake,
+			timestamp: uint32(time.Since(start).Microseconds()),
+			payload:   request.marshal(extType),
+		}.marshal())
+
+		// Requests are repeated until the listener answers
+		udp.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+		n, err := udp.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+
+		p, err := parsePacket(buf[:n])
+		if err != nil || !p.control || p.ctrlType != ctrlHandshake || p.destId != localId {
+			continue
+		}
+		hs, err := parseHandshake(p.payload)
+		if err != nil {
+			continue
+		}
+		if hs.hsType >= hsRejectBase && hs.hsType != hsConclusion {
+			return nil, fmt.Errorf("connection rejected by listener (reason %d)", hs.hsType)
+		}
+
+		switch {
+		case request.hsType == hsInduction && hs.hsType == hsInduction:
+			if hs.version < 5 || hs.extension != srtMagic {
+				return nil, errors.New("listener does not support SRT handshake v5")
+			}
+
+			request = handshake{
+				version:   5,
+				extension: extFlagHSReq,
+				isn:       isn,
+				mtu:       defaultMTU,
+				window:    defaultWindow,
+				hsType:    hsConclusion,
+				socketId:  localId,
+				cookie:    hs.cookie,
+				srtFlags:  flagTSBPDSend | flagTSBPDRecv | flagTLPktDrop | flagPeriodicNak | flagRexmit,
+				recvDelay: uint16(config.Latency.Milliseconds()),
+				sendDelay: uint16(config.Latency.Milliseconds()),
+				streamId:  config.StreamId,
+			}
+			if config.StreamId != "" {
+				request.extension |= extFlagConfig
+			}
+			extType = extHSReq
+		case request.hsType == hsConclusion && hs.hsType == hsConclusion:
+			udp.SetReadDeadline(time.Time{})
+
+			latency := config.Latency
+			if hs.hasSRT {
+				latency = max(latency, time.Duration(max(hs.recvDelay, hs.sendDelay))*time.Millisecond)
+			}
+
+			return newConn(send, localId, hs.socketId, isn, latency), nil
+		}
+	}
+}
+
+// handleHandshake answers a handshake request received by the listener
+func (o *Output) handleHandshake(p packet, addr *net.UDPAddr) {
+	hs, err := parseHandshake(p.payload)
+	if err != nil {
+		return
+	}
+
+	reply := func(response handshake, extType uint16) []byte {
+		b := packet{
+			control:  true,
+			ctrlType: ctrlHandshake,
+			destId:   hs.socketId,
+			payload:  response.marshal(extType),
+		}.marshal()
+		o.socket.WriteToUDP(b, addr)
+
+		return b
+	}
+
+	switch hs.hsType {
+	case hsInduction:
+		reply(handshake{
+			version:   5,
+			extension: srtMagic,
+			isn:       hs.isn,
+			mtu:       hs.mtu,
+			window:    hs.window,
+			hsType:    hsInduction,
+			cookie:    o.cookie(addr, time.Now()),
+		}, 0)
+	case hsConclusion:
+		if hs.cookie != o.cookie(addr, time.Now()) && hs.cookie != o.cookie(addr, time.Now().Add(-time.Minute)) {
+			return
+		}
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		// A repeated conclusion means our response was lost
+		for _, c := range o.conns {
+			if c.peerId == hs.socketId {
+				c.handle(p)
+				return
+			}
+		}
+
+		switch {
+		case hs.version < 5:
+			reply(handshake{version: 5, hsType: rejectVersion}, 0)
+			return
+		case hs.extension&extFlagKMReq != 0:
+			o.config.OnLog(fmt.Sprintf("Rejected SRT caller %s: encryption is not supported", addr))
+			reply(handshake{version: 5, hsType: rejectUnsecure}, 0)
+			return
+		}
+
+		latency := o.config.Latency
+		if hs.hasSRT {
+			latency = max(latency, time.Duration(max(hs.recvDelay, hs.sendDelay))*time.Millisecond)
+		}
+
+		localId := randomUint32()
+		send := func(b []byte) error {
+			_, err := o.socket.WriteToUDP(b, addr)
+			return err
+		}
+		c := newConn(send, localId, hs.socketId, hs.isn, latency)
+		c.streamId = hs.streamId
+		c.response = reply(handshake{
+			version:   5,
+			extension: extFlagHSReq,
+			isn:       hs.isn,
+			mtu:       hs.mtu,
+			window:    hs.window,
+			hsType:    hsConclusion,
+			socketId:  localId,
+			cookie:    hs.cookie,
+			srtFlags:  flagTSBPDSend | flagTLPktDrop | flagPeriodicNak | flagRexmit,
+			recvDelay: uint16(latency.Milliseconds()),
+			sendDelay: uint16(latency.Milliseconds()),
+		}, extHSRsp)
+		o.conns[localId] = c
+
+		o.config.OnLog(fmt.Sprintf("SRT caller %s connected (stream id %q, latency %s)", addr, hs.streamId, latency))
+		go func() {
+			<-c.done
+			o.mu.Lock()
+			delete(o.conns, localId)
+			o.mu.Unlock()
+			if c.err != nil {
+				o.config.OnLog(fmt.Sprintf("SRT caller %s disconnected: %v", addr, c.err))
+			}
+		}()
+	}
+}
+
+// cookie derives the SYN cookie for a caller address within the current minute
+func (o *Output) cookie(addr *net.UDPAddr, now time.Time) uint32 {
+	h := sha256.New()
+	h.Write(o.secret)
+	h.Write([]byte(addr.String()))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(now.Unix()/60)))
+
+	return binary.BigEndian.Uint32(h.Sum(nil))
+}
+
+func randomUint32() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+
+	return binary.BigEndian.Uint32(b) | 1
+}
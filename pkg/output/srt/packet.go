@@ -0,0 +1,268 @@
+package srt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Control packet types
+const (
+	ctrlHandshake uint16 = 0
+	ctrlKeepalive uint16 = 1
+	ctrlAck       uint16 = 2
+	ctrlNak       uint16 = 3
+	ctrlShutdown  uint16 = 5
+	ctrlAckAck    uint16 = 6
+	ctrlDropReq   uint16 = 7
+)
+
+// Handshake types
+const (
+	hsInduction  uint32 = 1
+	hsConclusion uint32 = 0xffffffff
+	// Handshake types at or above this value are rejection reasons
+	hsRejectBase uint32 = 1000
+)
+
+// Handshake extension types and flags
+const (
+	extHSReq    uint16 = 1
+	extHSRsp    uint16 = 2
+	extStreamId uint16 = 5
+
+	extFlagHSReq  uint16 = 0x1
+	extFlagConfig uint16 = 0x4
+
+	// The extension field value of an HSv5 induction response
+	srtMagic uint16 = 0x4a17
+)
+
+// SRT capability flags exchanged in HSREQ/HSRSP
+const (
+	flagTSBPDSend   uint32 = 0x01
+	flagTSBPDRecv   uint32 = 0x02
+	flagTLPktDrop   uint32 = 0x08
+	flagPeriodicNak uint32 = 0x10
+	flagRexmit      uint32 = 0x20
+)
+
+// srtVersion is the protocol version advertised in the handshake (1.5.0)
+const srtVersion uint32 = 0x010500
+
+const (
+	headerSize    = 16
+	handshakeSize = 48
+	seqMask       = 0x7fffffff
+	// Data packet flags: solo message (PP=11) and the retransmission flag
+	dataSolo    uint32 = 0xc0000000
+	dataRexmit  uint32 = 0x04000000
+	msgNumMask  uint32 = 0x03ffffff
+	lossRangeOn uint32 = 0x80000000
+)
+
+var errShortPacket = errors.New("packet is too short")
+
+// packet is a data or control packet
+type packet struct {
+	control bool
+	// The sequence number of a data packet
+	seq uint32
+	// The control type and subtype of a control packet
+	ctrlType uint16
+	subtype  uint16
+	// The message number word of a data packet, or the type-specific information of a control packet
+	info uint32
+	// Microseconds since the connection started
+	timestamp uint32
+	// The socket ID of the receiver
+	destId  uint32
+	payload []byte
+}
+
+func parsePacket(b []byte) (packet, error) {
+	if len(b) < headerSize {
+		return packet{}, errShortPacket
+	}
+
+	p := packet{
+		control:   b[0]&0x80 != 0,
+		info:      binary.BigEndian.Uint32(b[4:]),
+		timestamp: binary.BigEndian.Uint32(b[8:]),
+		destId:    binary.BigEndian.Uint32(b[12:]),
+		payload:   b[headerSize:],
+	}
+	if p.control {
+		p.ctrlType = binary.BigEndian.Uint16(b[0:]) & 0x7fff
+		p.subtype = binary.BigEndian.Uint16(b[2:])
+	} else {
+		p.seq = binary.BigEndian.Uint32(b[0:]) & seqMask
+	}
+
+	return p, nil
+}
+
+func (p packet) marshal() []byte {
+	b := make([]byte, headerSize, headerSize+len(p.payload))
+	if p.control {
+		binary.BigEndian.PutUint16(b[0:], 0x8000|p.ctrlType)
+		binary.BigEndian.PutUint16(b[2:], p.subtype)
+	} else {
+		binary.BigEndian.PutUint32(b[0:], p.seq&seqMask)
+	}
+	binary.BigEndian.PutUint32(b[4:], p.info)
+	binary.BigEndian.PutUint32(b[8:], p.timestamp)
+	binary.BigEndian.PutUint32(b[12:], p.destId)
+
+	return append(b, p.payload...)
+}
+
+// handshake is the handshake control packet body, including the SRT extensions
+type handshake struct {
+	version    uint32
+	encryption uint16
+	extension  uint16
+	isn        uint32
+	mtu        uint32
+	window     uint32
+	hsType     uint32
+	socketId   uint32
+	cookie     uint32
+	// The HSREQ/HSRSP extension, if present
+	hasSRT    bool
+	srtFlags  uint32
+	recvDelay uint16
+	sendDelay uint16
+	streamId  string
+}
+
+func parseHandshake(b []byte) (handshake, error) {
+	if len(b) < handshakeSize {
+		return handshake{}, errShortPacket
+	}
+
+	hs := handshake{
+		version:    binary.BigEndian.Uint32(b[0:]),
+		encryption: binary.BigEndian.Uint16(b[4:]),
+		extension:  binary.BigEndian.Uint16(b[6:]),
+		isn:        binary.BigEndian.Uint32(b[8:]) & seqMask,
+		mtu:        binary.BigEndian.Uint32(b[12:]),
+		window:     binary.BigEndian.Uint32(b[16:]),
+		hsType:     binary.BigEndian.Uint32(b[20:]),
+		socketId:   binary.BigEndian.Uint32(b[24:]),
+		cookie:     binary.BigEndian.Uint32(b[28:]),
+	}
+
+	ext := b[handshakeSize:]
+	for len(ext) >= 4 {
+		extType := binary.BigEndian.Uint16(ext[0:])
+		size := int(binary.BigEndian.Uint16(ext[2:])) * 4
+		if len(ext) < 4+size {
+			break
+		}
+		content := ext[4 : 4+size]
+
+		switch {
+		case (extType == extHSReq || extType == extHSRsp) && size >= 12:
+			hs.hasSRT = true
+			hs.srtFlags = binary.BigEndian.Uint32(content[4:])
+			hs.recvDelay = binary.BigEndian.Uint16(content[8:])
+			hs.sendDelay = binary.BigEndian.Uint16(content[10:])
+		case extType == extStreamId:
+			hs.streamId = decodeStreamId(content)
+		}
+		ext = ext[4+size:]
+	}
+
+	return hs, nil
+}
+
+// marshal encodes the handshake. extType selects the SRT extension to append
+// (extHSReq, extHSRsp, or 0 for none).
+func (hs handshake) marshal(extType uint16) []byte {
+	b := make([]byte, handshakeSize)
+	binary.BigEndian.PutUint32(b[0:], hs.version)
+	binary.BigEndian.PutUint16(b[4:], hs.encryption)
+	binary.BigEndian.PutUint16(b[6:], hs.extension)
+	binary.BigEndian.PutUint32(b[8:], hs.isn)
+	binary.BigEndian.PutUint32(b[12:], hs.mtu)
+	binary.BigEndian.PutUint32(b[16:], hs.window)
+	binary.BigEndian.PutUint32(b[20:], hs.hsType)
+	binary.BigEndian.PutUint32(b[24:], hs.socketId)
+	binary.BigEndian.PutUint32(b[28:], hs.cookie)
+
+	if extType != 0 {
+		b = binary.BigEndian.AppendUint16(b, extType)
+		b = binary.BigEndian.AppendUint16(b, 3)
+		b = binary.BigEndian.AppendUint32(b, srtVersion)
+		b = binary.BigEndian.AppendUint32(b, hs.srtFlags)
+		b = binary.BigEndian.AppendUint16(b, hs.recvDelay)
+		b = binary.BigEndian.AppendUint16(b, hs.sendDelay)
+	}
+	if hs.streamId != "" {
+		content := encodeStreamId(hs.streamId)
+		b = binary.BigEndian.AppendUint16(b, extStreamId)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(content)/4))
+		b = append(b, content...)
+	}
+
+	return b
+}
+
+// encodeStreamId pads the stream ID to 32-bit words, each stored in little-endian order
+func encodeStreamId(id string) []byte {
+	b := make([]byte, (len(id)+3)/4*4)
+	copy(b, id)
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+
+	return b
+}
+
+func decodeStreamId(content []byte) string {
+	b := make([]byte, len(content)/4*4)
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = content[i+3], content[i+2], content[i+1], content[i]
+	}
+
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+
+	return string(b)
+}
+
+// seqDiff returns b - a accounting for sequence number wraparound
+func seqDiff(a uint32, b uint32) int32 {
+	diff := int64((b - a) & seqMask)
+	if diff > seqMask/2 {
+		diff -= seqMask + 1
+	}
+
+	return int32(diff)
+}
+
+// parseLossList expands a NAK loss list into individual sequence numbers
+func parseLossList(b []byte) []uint32 {
+	var seqs []uint32
+	for len(b) >= 4 {
+		first := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		if first&lossRangeOn == 0 {
+			seqs = append(seqs, first)
+			continue
+		}
+		if len(b) < 4 {
+			break
+		}
+
+		first &= seqMask
+		last := binary.BigEndian.Uint32(b) & seqMask
+		b = b[4:]
+		for seq := first; seqDiff(seq, last) >= 0 && len(seqs) < 8192; seq = (seq + 1) & seqMask {
+			seqs = append(seqs, seq)
+		}
+	}
+
+	return seqs
+}
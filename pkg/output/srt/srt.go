@@ -0,0 +1,347 @@
+// Package srt provides an output that carries the livestream over SRT (Secure
+// Reliable Transport) to a remote media server or player.
+//
+// SRT retransmits lost packets within a configurable latency window, which makes
+// it suitable for WAN links where plain TCP stalls and UDP loses data. Both caller
+// mode (connect to a remote listener such as MediaMTX or srt-live-transmit) and
+// listener mode (accept players such as ffplay or VLC) are supported. Only the live
+// transmission mode without encryption is implemented.
+package srt
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Connection modes
+const (
+	MODE_CALLER   = "caller"
+	MODE_LISTENER = "listener"
+)
+
+// DEFAULT_LATENCY is the default receiver buffering latency
+const DEFAULT_LATENCY = 120 * time.Millisecond
+
+// PAYLOAD_SIZE is the data carried by each SRT packet (7 transport stream packets)
+const PAYLOAD_SIZE = 7 * mpegts.PACKET_SIZE
+
+type Config struct {
+	// The connection mode, MODE_CALLER or MODE_LISTENER (defaults to MODE_CALLER)
+	Mode string
+	// The remote address in caller mode or the local address in listener mode (e.g. "example.com:9000" or ":9000")
+	Addr string
+	// Optional stream ID sent to the listener in caller mode (e.g. "publish:blink")
+	StreamId string
+	// The receiver buffering latency. Higher values tolerate more loss (defaults to DEFAULT_LATENCY)
+	Latency time.Duration
+	// The maximum time to wait for the handshake in caller mode (defaults to 5s)
+	ConnectTimeout time.Duration
+	// The delay between attempts to reconnect in caller mode (defaults to 5s)
+	ReconnectInterval time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// ParseURL parses an SRT URL into a configuration. The mode defaults to listener
+// when the URL has no host, and to caller otherwise.
+//
+// rawUrl: the URL, e.g. "srt://example.com:9000?latency=200&streamid=publish:blink" or "srt://:9000"
+//
+// Example: ParseURL("srt://:9000?latency=500") = Config{Mode: "listener", Addr: ":9000", Latency: 500ms}, nil
+func ParseURL(rawUrl string) (Config, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid SRT URL: %w", err)
+	}
+	if u.Scheme != "srt" || u.Port() == "" {
+		return Config{}, fmt.Errorf("SRT URL %q must be of the form srt://[host]:port", rawUrl)
+	}
+
+	query := u.Query()
+	config := Config{
+		Mode:     query.Get("mode"),
+		Addr:     u.Host,
+		StreamId: query.Get("streamid"),
+	}
+	if config.Mode == "" {
+		config.Mode = MODE_CALLER
+		if u.Hostname() == "" {
+			config.Mode = MODE_LISTENER
+		}
+	}
+	if latency := query.Get("latency"); latency != "" {
+		ms, err := strconv.Atoi(latency)
+		if err != nil || ms < 0 {
+			return Config{}, fmt.Errorf("invalid SRT latency %q", latency)
+		}
+		config.Latency = time.Duration(ms) * time.Millisecond
+	}
+
+	return config, nil
+}
+
+type Output struct {
+	// Configuration options for the output
+	config Config
+	// The listening socket in listener mode
+	socket *net.UDPConn
+	// The secret used to derive SYN cookies in listener mode
+	secret []byte
+	// Guards the fields below
+	mu sync.Mutex
+	// Incomplete transport stream packet data
+	remainder []byte
+	// Aligned packets waiting for a full payload
+	pending []byte
+	// Established connections keyed by local socket ID
+	conns map[uint32]*conn
+	// Closed when the output is closed
+	closed chan struct{}
+}
+
+// Open starts the SRT output. In caller mode the first connection must succeed;
+// later drops are reconnected automatically.
+//
+// config: the output configuration
+//
+// Example: Open(Config{Mode: MODE_LISTENER, Addr: ":9000"}) = &Output{...}, nil
+func Open(config Config) (*Output, error) {
+	if config.Mode == "" {
+		config.Mode = MODE_CALLER
+	}
+	if config.Latency <= 0 {
+		config.Latency = DEFAULT_LATENCY
+	}
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = 5 * time.Second
+	}
+	if config.ReconnectInterval <= 0 {
+		config.ReconnectInterval = 5 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	o := &Output{
+		config: config,
+		conns:  map[uint32]*conn{},
+		closed: make(chan struct{}),
+	}
+
+	switch config.Mode {
+	case MODE_CALLER:
+		c, udp, err := o.dial()
+		if err != nil {
+			return nil, err
+		}
+		o.conns[c.localId] = c
+		go o.supervise(c, udp)
+	case MODE_LISTENER:
+		addr, err := net.ResolveUDPAddr("udp", config.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SRT address: %w", err)
+		}
+		o.socket, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen on %s: %w", config.Addr, err)
+		}
+		o.secret = make([]byte, 16)
+		rand.Read(o.secret)
+		go o.listen()
+	default:
+		return nil, fmt.Errorf("unsupported SRT mode %q", config.Mode)
+	}
+
+	return o, nil
+}
+
+// Addr returns the local address of the listener, or nil in caller mode
+func (o *Output) Addr() net.Addr {
+	if o.socket == nil {
+		return nil
+	}
+
+	return o.socket.LocalAddr()
+}
+
+// Peers returns the number of established connections
+func (o *Output) Peers() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.conns)
+}
+
+// Write feeds MPEG-TS data from the livestream to every connected peer
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	select {
+	case <-o.closed:
+		return 0, errors.New("output closed")
+	default:
+	}
+
+	o.remainder = mpegts.AlignPackets(append(o.remainder, p...), func(pkt mpegts.Packet) {
+		o.pending = append(o.pending, pkt...)
+		if len(o.pending) < PAYLOAD_SIZE {
+			return
+		}
+
+		for _, c := range o.conns {
+			c.write(o.pending)
+		}
+		o.pending = o.pending[:0]
+	})
+
+	return len(p), nil
+}
+
+// Close disconnects every peer and stops listening or reconnecting
+func (o *Output) Close() error {
+	o.mu.Lock()
+	select {
+	case <-o.closed:
+		o.mu.Unlock()
+		return nil
+	default:
+	}
+	close(o.closed)
+
+	conns := make([]*conn, 0, len(o.conns))
+	for _, c := range o.conns {
+		conns = append(conns, c)
+	}
+	o.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+	if o.socket != nil {
+		return o.socket.Close()
+	}
+
+	return nil
+}
+
+// dial performs the caller handshake and starts reading from the peer
+func (o *Output) dial() (*conn, *net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", o.config.Addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid SRT address: %w", err)
+	}
+
+	udp, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to %s: %w", o.config.Addr, err)
+	}
+
+	c, err := callerHandshake(udp, o.config)
+	if err != nil {
+		udp.Close()
+		return nil, nil, fmt.Errorf("unable to connect to %s: %w", o.config.Addr, err)
+	}
+
+	go func() {
+		buf := make([]byte, defaultMTU)
+		for {
+			n, err := udp.Read(buf)
+			if err != nil {
+				return
+			}
+			if p, err := parsePacket(buf[:n]); err == nil && p.destId == c.localId {
+				c.handle(p)
+			}
+		}
+	}()
+
+	o.config.OnLog(fmt.Sprintf("SRT connected to %s (latency %s)", o.config.Addr, c.latency))
+
+	return c, udp, nil
+}
+
+// supervise reconnects in caller mode whenever the connection ends
+func (o *Output) supervise(c *conn, udp *net.UDPConn) {
+	for {
+		select {
+		case <-c.done:
+		case <-o.closed:
+			<-c.done
+			udp.Close()
+			return
+		}
+
+		udp.Close()
+		o.mu.Lock()
+		delete(o.conns, c.localId)
+		o.mu.Unlock()
+		o.config.OnLog(fmt.Sprintf("SRT connection to %s ended: %v", o.config.Addr, c.err))
+
+		for {
+			select {
+			case <-time.After(o.config.ReconnectInterval):
+			case <-o.closed:
+				return
+			}
+
+			next, nextUdp, err := o.dial()
+			if err != nil {
+				o.config.OnLog(fmt.Sprintf("SRT reconnect failed: %v", err))
+				continue
+			}
+
+			o.mu.Lock()
+			select {
+			case <-o.closed:
+				o.mu.Unlock()
+				next.close()
+				nextUdp.Close()
+				return
+			default:
+			}
+			o.conns[next.localId] = next
+			o.mu.Unlock()
+
+			c, udp = next, nextUdp
+			break
+		}
+	}
+}
+
+// listen dispatches packets received by the listener socket
+func (o *Output) listen() {
+	buf := make([]byte, defaultMTU)
+	for {
+		n, addr, err := o.socket.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		p, err := parsePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		if p.destId == 0 {
+			if p.control && p.ctrlType == ctrlHandshake {
+				o.handleHandshake(p, addr)
+			}
+			continue
+		}
+
+		o.mu.Lock()
+		c := o.conns[p.destId]
+		o.mu.Unlock()
+		if c != nil {
+			c.handle(p)
+		}
+	}
+}
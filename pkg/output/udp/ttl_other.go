@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package udp
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+// setMulticastTTL is unavailable on this platform
+func setMulticastTTL(conn *net.UDPConn, ipv6 bool, ttl int) error {
+	return errors.New("multicast TTL is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package udp
+
+import (
+	"net"
+	"syscall"
+)
+
+// setMulticastTTL sets the time-to-live (hop limit for IPv6) of multicast datagrams
+func setMulticastTTL(conn *net.UDPConn, ipv6 bool, ttl int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, ttl)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
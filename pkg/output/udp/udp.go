@@ -0,0 +1,190 @@
+// Package udp provides an output that sends the livestream as MPEG-TS over UDP, to
+// a unicast or multicast address. Players such as VLC (udp://@239.0.0.1:1234) and
+// broadcast-style setups receive it without an intermediate server.
+//
+// Each datagram carries whole transport stream packets. UDP does not retransmit, so
+// the output suits local networks where loss is rare.
+package udp
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// DEFAULT_PACKETS is the default number of transport stream packets per datagram,
+// which keeps the 1316 byte datagrams below the usual Ethernet MTU
+const DEFAULT_PACKETS = 7
+
+// DEFAULT_TTL is the default time-to-live of multicast datagrams, which keeps them
+// on the local network
+const DEFAULT_TTL = 1
+
+type Config struct {
+	// The destination address (e.g. "239.0.0.1:1234" or "192.168.1.20:1234")
+	Addr string
+	// The time-to-live (hop limit) of multicast datagrams (defaults to DEFAULT_TTL)
+	TTL int
+	// The number of transport stream packets per datagram (defaults to DEFAULT_PACKETS)
+	PacketsPerDatagram int
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// ParseURL parses a UDP URL into a configuration. The "@" of VLC style URLs is
+// optional.
+//
+// rawUrl: the URL, e.g. "udp://239.0.0.1:1234?ttl=4&pkt_size=1316"
+//
+// Example: ParseURL("udp://@239.0.0.1:1234?ttl=4") = Config{Addr: "239.0.0.1:1234", TTL: 4}, nil
+func ParseURL(rawUrl string) (Config, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid UDP URL: %w", err)
+	}
+	if u.Scheme != "udp" || u.Hostname() == "" || u.Port() == "" {
+		return Config{}, fmt.Errorf("UDP URL %q must be of the form udp://host:port", rawUrl)
+	}
+
+	query := u.Query()
+	config := Config{Addr: u.Host}
+	if ttl := query.Get("ttl"); ttl != "" {
+		config.TTL, err = strconv.Atoi(ttl)
+		if err != nil || config.TTL < 1 || config.TTL > 255 {
+			return Config{}, fmt.Errorf("invalid UDP ttl %q", ttl)
+		}
+	}
+	if size := query.Get("pkt_size"); size != "" {
+		bytes, err := strconv.Atoi(size)
+		if err != nil || bytes < mpegts.PACKET_SIZE {
+			return Config{}, fmt.Errorf("invalid UDP pkt_size %q", size)
+		}
+		config.PacketsPerDatagram = bytes / mpegts.PACKET_SIZE
+	}
+
+	return config, nil
+}
+
+type Output struct {
+	// Configuration options for the output
+	config Config
+	// The socket connected to the destination
+	conn *net.UDPConn
+	// Guards the fields below
+	mu sync.Mutex
+	// Incomplete transport stream packet data
+	remainder []byte
+	// Aligned packets waiting for a full datagram
+	pending []byte
+	// Whether the last datagram failed to send, to log failures once
+	failing bool
+	// Whether the output has been closed
+	closed bool
+}
+
+// Open starts the UDP output.
+//
+// config: the output configuration
+//
+// Example: Open(Config{Addr: "239.0.0.1:1234"}) = &Output{...}, nil
+func Open(config Config) (*Output, error) {
+	if config.TTL <= 0 {
+		config.TTL = DEFAULT_TTL
+	}
+	if config.PacketsPerDatagram <= 0 {
+		config.PacketsPerDatagram = DEFAULT_PACKETS
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UDP address: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open UDP socket: %w", err)
+	}
+	if addr.IP.IsMulticast() {
+		if err := setMulticastTTL(conn, addr.IP.To4() == nil, config.TTL); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to set multicast TTL: %w", err)
+		}
+	}
+
+	return &Output{
+		config: config,
+		conn:   conn,
+	}, nil
+}
+
+// Addr returns the destination address
+func (o *Output) Addr() net.Addr {
+	return o.conn.RemoteAddr()
+}
+
+// Write sends the MPEG-TS data in datagrams of whole packets. Send failures, such as
+// an unreachable unicast receiver, are logged but do not fail the stream.
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return 0, errors.New("output closed")
+	}
+
+	size := o.config.PacketsPerDatagram * mpegts.PACKET_SIZE
+	o.remainder = mpegts.AlignPackets(append(o.remainder, p...), func(pkt mpegts.Packet) {
+		o.pending = append(o.pending, pkt...)
+		if len(o.pending) < size {
+			return
+		}
+
+		o.send(o.pending)
+		o.pending = o.pending[:0]
+	})
+
+	return len(p), nil
+}
+
+// send writes a datagram, logging the first of a series of failures
+func (o *Output) send(datagram []byte) {
+	_, err := o.conn.Write(datagram)
+	if err != nil && !o.failing {
+		o.config.OnLog(fmt.Sprintf("Error sending to %s: %v", o.config.Addr, err))
+	} else if err == nil && o.failing {
+		o.config.OnLog(fmt.Sprintf("Sending to %s again", o.config.Addr))
+	}
+	o.failing = err != nil
+}
+
+// Discontinuity discards the packets of the previous session that do not fill a
+// datagram
+func (o *Output) Discontinuity() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.remainder = nil
+	o.pending = o.pending[:0]
+}
+
+// Close sends the remaining packets and closes the socket
+func (o *Output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	if len(o.pending) > 0 {
+		o.send(o.pending)
+	}
+
+	return o.conn.Close()
+}
@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	execOutput "amattu2/blink-middleware/pkg/output/exec"
+	"amattu2/blink-middleware/pkg/output/mp4"
+	"amattu2/blink-middleware/pkg/output/namedpipe"
+	"amattu2/blink-middleware/pkg/output/record"
+	rtmpOutput "amattu2/blink-middleware/pkg/output/rtmp"
+	"amattu2/blink-middleware/pkg/output/rtsp"
+	"amattu2/blink-middleware/pkg/output/socket"
+	"amattu2/blink-middleware/pkg/output/srt"
+	"amattu2/blink-middleware/pkg/output/udp"
+	"amattu2/blink-middleware/pkg/transcode"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The built-in stages. Sink specs match the --output values of the command line.
+func init() {
+	RegisterSink("stdout", func(spec string, options Options) (Sink, error) {
+		return NopCloser(os.Stdout), nil
+	})
+	RegisterSink("file", func(spec string, options Options) (Sink, error) {
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, errors.New("file sink requires a path (file:<path>)")
+		}
+		return os.Create(path)
+	})
+	RegisterSink("record", func(spec string, options Options) (Sink, error) {
+		return record.Open(record.Config{
+			Dir:   strings.TrimPrefix(spec, "record:"),
+			OnLog: options.OnLog,
+		})
+	})
+	RegisterSink("mp4", func(spec string, options Options) (Sink, error) {
+		return mp4.Create(mp4.Config{
+			Path:  strings.TrimPrefix(spec, "mp4:"),
+			OnLog: options.OnLog,
+		})
+	})
+	RegisterSink("exec", func(spec string, options Options) (Sink, error) {
+		args, err := execOutput.SplitArgs(strings.TrimPrefix(spec, "exec:"))
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, errors.New("exec sink requires a command (exec:<command> [args])")
+		}
+		return execOutput.Start(execOutput.Config{
+			Command: args[0],
+			Args:    args[1:],
+			Vars:    map[string]string{"name": options.StreamName},
+			OnLog:   options.OnLog,
+		})
+	})
+	RegisterSink("stt", func(spec string, options Options) (Sink, error) {
+		args, err := execOutput.SplitArgs(strings.TrimPrefix(spec, "stt:"))
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, errors.New("stt sink requires a speech-to-text command (stt:<command> [args])")
+		}
+		return transcode.NewSpeech(transcode.SpeechConfig{
+			Command: args[0],
+			Args:    args[1:],
+			OnLog:   options.OnLog,
+		})
+	})
+	RegisterSink("rtsp", func(spec string, options Options) (Sink, error) {
+		server, err := rtsp.ListenWithConfig(rtsp.Config{
+			Addr:    strings.TrimPrefix(strings.TrimPrefix(spec, "rtsp"), ":"),
+			OnLog:   options.OnLog,
+			Metrics: options.Metrics,
+		})
+		if err != nil {
+			return nil, err
+		}
+		options.OnLog(fmt.Sprintf("Serving stream on rtsp://%s/%s", server.Addr(), options.StreamName))
+		return &serverSink{Writer: server.Stream(options.StreamName), closer: server}, nil
+	})
+	openRTMP := func(spec string, options Options) (Sink, error) {
+		return rtmpOutput.Push(rtmpOutput.Config{
+			URL:   spec,
+			OnLog: options.OnLog,
+		})
+	}
+	RegisterSink("rtmp", openRTMP)
+	RegisterSink("rtmps", openRTMP)
+	RegisterSink("srt", func(spec string, options Options) (Sink, error) {
+		config, err := srt.ParseURL(spec)
+		if err != nil {
+			return nil, err
+		}
+		config.OnLog = options.OnLog
+		return srt.Open(config)
+	})
+	RegisterSink("udp", func(spec string, options Options) (Sink, error) {
+		config, err := udp.ParseURL(spec)
+		if err != nil {
+			return nil, err
+		}
+		config.OnLog = options.OnLog
+		return udp.Open(config)
+	})
+	RegisterSink("unix", func(spec string, options Options) (Sink, error) {
+		return socket.Listen(socket.Config{
+			Address: strings.TrimPrefix(spec, "unix://"),
+			OnLog:   options.OnLog,
+		})
+	})
+	RegisterSink("pipe", func(spec string, options Options) (Sink, error) {
+		return namedpipe.Listen(strings.TrimPrefix(spec, "pipe:"), options.OnLog)
+	})
+
+	RegisterFilter("streams", func(arg string, options Options) (Filter, error) {
+		if _, err := mpegts.NewFilter(io.Discard, arg); err != nil {
+			return nil, err
+		}
+		return FilterFunc(func(next io.Writer) io.Writer {
+			filter, _ := mpegts.NewFilter(next, arg)
+			return filter
+		}), nil
+	})
+	RegisterFilter("repair", func(arg string, options Options) (Filter, error) {
+		return FilterFunc(func(next io.Writer) io.Writer {
+			return mpegts.NewRepairer(next)
+		}), nil
+	})
+	RegisterFilter("audio", func(arg string, options Options) (Filter, error) {
+		config := transcode.AudioConfig{Codec: arg, OnLog: options.OnLog}
+		if _, err := transcode.NewAudio(io.Discard, config); err != nil {
+			return nil, err
+		}
+		return FilterFunc(func(next io.Writer) io.Writer {
+			audio, _ := transcode.NewAudio(next, config)
+			return audio
+		}), nil
+	})
+	RegisterFilter("bitrate", func(arg string, options Options) (Filter, error) {
+		interval := 10 * time.Second
+		if arg != "" {
+			var err error
+			interval, err = time.ParseDuration(arg)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid bitrate interval %q", arg)
+			}
+		}
+		return FilterFunc(func(next io.Writer) io.Writer {
+			return &bitrateMeter{next: next, interval: interval, start: time.Now(), onLog: options.OnLog}
+		}), nil
+	})
+}
+
+// serverSink is the stream of a server that is closed with the sink
+type serverSink struct {
+	io.Writer
+	closer io.Closer
+}
+
+func (s *serverSink) Close() error {
+	return s.closer.Close()
+}
+
+func (s *serverSink) Discontinuity() {
+	if d, ok := s.Writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// bitrateMeter logs the bitrate of the stream passing through it
+type bitrateMeter struct {
+	// The next stage
+	next io.Writer
+	// How often the bitrate is logged
+	interval time.Duration
+	// Callback for logging messages
+	onLog func(string)
+	// When the current measurement started
+	start time.Time
+	// Bytes received during the current measurement
+	bytes atomic.Int64
+}
+
+func (m *bitrateMeter) Write(p []byte) (int, error) {
+	m.bytes.Add(int64(len(p)))
+	if elapsed := time.Since(m.start); elapsed >= m.interval {
+		kbps := float64(m.bytes.Swap(0)*8) / elapsed.Seconds() / 1000
+		m.start = time.Now()
+		m.onLog(fmt.Sprintf("Stream bitrate: %.1f kbit/s", kbps))
+	}
+
+	return m.next.Write(p)
+}
+
+func (m *bitrateMeter) Discontinuity() {
+	if d, ok := m.next.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
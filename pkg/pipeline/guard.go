@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+)
+
+// guardedSink isolates a sink from the pipeline: a panic in the sink is recovered
+// and returned as an error, and once the sink failed every later call returns the
+// same error without reaching the sink
+type guardedSink struct {
+	// The name of the sink, in the errors
+	name string
+	// The guarded sink
+	sink Sink
+	// Guards the fields below
+	mu sync.Mutex
+	// The first failure of the sink
+	err error
+}
+
+// guard wraps a sink so its panics turn into errors
+func guard(name string, sink Sink) *guardedSink {
+	return &guardedSink{name: name, sink: sink}
+}
+
+func (g *guardedSink) Write(p []byte) (n int, err error) {
+	if err := g.failed(); err != nil {
+		return 0, err
+	}
+	defer g.recoverPanic(&err)
+
+	n, err = g.sink.Write(p)
+	if err != nil {
+		g.fail(err)
+	}
+
+	return n, err
+}
+
+func (g *guardedSink) Flush() (err error) {
+	if err := g.failed(); err != nil {
+		return err
+	}
+	defer g.recoverPanic(&err)
+
+	if flusher, ok := g.sink.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+func (g *guardedSink) Discontinuity() {
+	if g.failed() != nil {
+		return
+	}
+	var err error
+	defer g.recoverPanic(&err)
+
+	if d, ok := g.sink.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// Close closes the sink even if it failed, so it releases its resources
+func (g *guardedSink) Close() (err error) {
+	defer g.recoverPanic(&err)
+
+	return g.sink.Close()
+}
+
+func (g *guardedSink) Name() string {
+	return g.name
+}
+
+func (g *guardedSink) failed() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.err
+}
+
+func (g *guardedSink) fail(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// recoverPanic turns a panic of the sink into its error. It must be deferred.
+func (g *guardedSink) recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("sink %s panicked: %v", g.name, r)
+		g.fail(*err)
+	}
+}
@@ -0,0 +1,340 @@
+// Package pipeline composes the streaming path from stages: a Source producing the
+// MPEG-TS stream (e.g. a liveview.Client), Filters processing it in order, and any
+// number of Sinks consuming it.
+//
+// Each sink is fed through its own buffer, so a slow sink does not hold back the
+// others or the source. A sink that fails, returning an error or panicking, is
+// dropped while the others keep receiving the stream.
+//
+// Pipelines can also be built declaratively with Build, from stage specs such as
+// "streams:video" or "record:/recordings".
+package pipeline
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"amattu2/blink-middleware/pkg/output/buffer"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Source produces the MPEG-TS stream, e.g. a liveview.Client
+type Source interface {
+	// Stream writes the stream to the writer until the context is cancelled or the
+	// stream ends
+	Stream(ctx context.Context, writer io.Writer) error
+}
+
+// Filter is a processing stage of the stream. Writers returned by Wrap should
+// forward Discontinuity to the next stage if they hold stream state, and implement
+// io.Closer if they hold resources (e.g. an external process).
+type Filter interface {
+	// Wrap returns the writer feeding the processed stream to the next stage
+	Wrap(next io.Writer) io.Writer
+}
+
+// FilterFunc adapts a function to a Filter
+type FilterFunc func(next io.Writer) io.Writer
+
+func (f FilterFunc) Wrap(next io.Writer) io.Writer {
+	return f(next)
+}
+
+// Sink consumes the stream. Sinks may implement Discontinuity() to be told when the
+// stream restarts, and Name() string to be identified in logs and metrics.
+type Sink interface {
+	io.Writer
+	io.Closer
+}
+
+type Config struct {
+	// The filters applied to the stream, in order
+	Filters []Filter
+	// The sinks receiving the filtered stream
+	Sinks []Sink
+	// The bytes buffered for each sink (e.g. buffer.DEFAULT_SIZE). Zero writes to the
+	// sinks directly
+	BufferSize int
+	// The overflow policy of the sink buffers (defaults to buffer.POLICY_DROP)
+	Policy string
+	// How long Close waits for each sink buffer to drain (defaults to
+	// buffer.DEFAULT_FLUSH_TIMEOUT)
+	FlushTimeout time.Duration
+	// Optional metrics backend for the sink buffers
+	Metrics metrics.Metrics
+	// Optional callback for the sinks that failed and were dropped, with the error
+	// or recovered panic of the sink
+	OnSinkError func(name string, err error)
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Pipeline writes a stream through the filters to every sink. It is itself an
+// io.Writer, so it can be passed wherever a single output is expected.
+type Pipeline struct {
+	// Configuration options for the pipeline
+	config Config
+	// The writer of the first stage
+	head io.Writer
+	// The writers of the filters, in order
+	stages []io.Writer
+	// Fans the filtered stream out to the sinks
+	tee *tee
+	// The sinks, guarded against panics
+	sinks []*guardedSink
+	// The buffers in front of the sinks, closed before the sinks
+	buffers []*buffer.Writer
+}
+
+// New initializes a new Pipeline.
+//
+// config: the pipeline configuration
+//
+// Example: New(Config{Filters: []Filter{...}, Sinks: []Sink{recorder, server}}) = &Pipeline{...}, nil
+func New(config Config) (*Pipeline, error) {
+	if len(config.Sinks) == 0 {
+		return nil, errors.New("a pipeline needs at least one sink")
+	}
+	if config.Metrics == nil {
+		config.Metrics = metrics.Noop
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	p := &Pipeline{
+		config: config,
+		tee:    &tee{onLog: config.OnLog, onSinkError: config.OnSinkError},
+	}
+
+	for i, sink := range config.Sinks {
+		name := fmt.Sprintf("sink-%d", i+1)
+		if named, ok := sink.(interface{ Name() string }); ok {
+			name = named.Name()
+		}
+
+		guarded := guard(name, sink)
+		p.sinks = append(p.sinks, guarded)
+
+		var writer io.Writer = guarded
+		if config.BufferSize > 0 {
+			buffered, err := buffer.New(guarded, buffer.Config{
+				Size:         config.BufferSize,
+				Policy:       config.Policy,
+				Name:         name,
+				FlushTimeout: config.FlushTimeout,
+				Metrics:      config.Metrics,
+				OnLog:        config.OnLog,
+			})
+			if err != nil {
+				p.closeBuffers()
+				return nil, fmt.Errorf("sink %s: %w", name, err)
+			}
+			p.buffers = append(p.buffers, buffered)
+			writer = buffered
+		}
+		p.tee.outputs = append(p.tee.outputs, teeOutput{name: name, writer: writer})
+	}
+
+	// Wrap from the last filter backwards so the first filter sees the source data
+	p.head = p.tee
+	p.stages = make([]io.Writer, len(config.Filters))
+	for i := len(config.Filters) - 1; i >= 0; i-- {
+		p.head = config.Filters[i].Wrap(p.head)
+		p.stages[i] = p.head
+	}
+
+	return p, nil
+}
+
+// Write feeds stream data through the filters to the sinks. It fails once every
+// sink has failed.
+func (p *Pipeline) Write(data []byte) (int, error) {
+	return p.head.Write(data)
+}
+
+// Discontinuity signals the filters and sinks that the stream restarts
+func (p *Pipeline) Discontinuity() {
+	if d, ok := p.head.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// Flush waits until the sink buffers are written and flushes the sinks that
+// implement Flush() error. Sinks that failed earlier are skipped.
+func (p *Pipeline) Flush() error {
+	return p.tee.Flush()
+}
+
+// Run streams the source through the pipeline until the context is cancelled or
+// the stream ends. The pipeline is not closed.
+//
+// ctx: the context controlling the stream lifecycle
+//
+// source: the source of the stream
+//
+// Example: Run(ctx, client) = nil
+func (p *Pipeline) Run(ctx context.Context, source Source) error {
+	return source.Stream(ctx, p)
+}
+
+// Close closes the filters that hold resources in order, so they write out what
+// they hold, then drains the sink buffers and closes the sinks in reverse order
+func (p *Pipeline) Close() error {
+	var errs []error
+	for _, stage := range p.stages {
+		if closer, ok := stage.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	errs = append(errs, p.closeBuffers())
+	for i := len(p.sinks) - 1; i >= 0; i-- {
+		errs = append(errs, p.sinks[i].Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Pipeline) closeBuffers() error {
+	var errs []error
+	for _, buffered := range p.buffers {
+		errs = append(errs, buffered.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+type teeOutput struct {
+	name   string
+	writer io.Writer
+}
+
+// tee writes the stream to every output, dropping the outputs that fail
+type tee struct {
+	// Callback for logging messages
+	onLog func(string)
+	// Optional callback for the outputs that failed
+	onSinkError func(name string, err error)
+	// Guards the fields below
+	mu sync.Mutex
+	// The outputs still receiving the stream
+	outputs []teeOutput
+}
+
+func (t *tee) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.outputs) == 0 {
+		return 0, errors.New("every sink of the pipeline has failed")
+	}
+
+	var lastErr error
+	remaining := t.outputs[:0]
+	for _, output := range t.outputs {
+		if _, err := output.writer.Write(p); err != nil {
+			t.onLog(fmt.Sprintf("Sink %s failed and was removed from the pipeline: %v", output.name, err))
+			if t.onSinkError != nil {
+				t.onSinkError(output.name, err)
+			}
+			lastErr = err
+			continue
+		}
+		remaining = append(remaining, output)
+	}
+	t.outputs = remaining
+
+	if len(t.outputs) == 0 {
+		return 0, fmt.Errorf("every sink of the pipeline has failed: %w", lastErr)
+	}
+
+	return len(p), nil
+}
+
+func (t *tee) Discontinuity() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, output := range t.outputs {
+		if d, ok := output.writer.(interface{ Discontinuity() }); ok {
+			d.Discontinuity()
+		}
+	}
+}
+
+func (t *tee) Flush() error {
+	t.mu.Lock()
+	outputs := append([]teeOutput(nil), t.outputs...)
+	t.mu.Unlock()
+
+	var errs []error
+	for _, output := range outputs {
+		if flusher, ok := output.writer.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("sink %s: %w", output.name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Named returns the sink with a name used in logs and metric labels
+//
+// name: the name of the sink (e.g. "record")
+//
+// sink: the sink to name
+//
+// Example: Named("record", recorder) = Sink
+func Named(name string, sink Sink) Sink {
+	return &namedSink{Sink: sink, name: name}
+}
+
+type namedSink struct {
+	Sink
+	name string
+}
+
+func (n *namedSink) Name() string {
+	return n.name
+}
+
+func (n *namedSink) Flush() error {
+	if flusher, ok := n.Sink.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+
+	return nil
+}
+
+func (n *namedSink) Discontinuity() {
+	if d, ok := n.Sink.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
+
+// NopCloser returns a sink whose Close does nothing, e.g. for os.Stdout
+//
+// writer: the writer receiving the stream
+//
+// Example: NopCloser(os.Stdout) = Sink
+func NopCloser(writer io.Writer) Sink {
+	return nopCloser{writer}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func (n nopCloser) Discontinuity() {
+	if d, ok := n.Writer.(interface{ Discontinuity() }); ok {
+		d.Discontinuity()
+	}
+}
@@ -0,0 +1,214 @@
+package pipeline
+
+import (
+	"amattu2/blink-middleware/pkg/metrics"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options are passed to the stage factories
+type Options struct {
+	// The name of the stream, used by sinks serving named streams (defaults to "blink")
+	StreamName string
+	// Optional metrics backend for the stages
+	Metrics metrics.Metrics
+	// Optional callback for the sinks that failed and were dropped from the
+	// pipeline
+	OnSinkError func(name string, err error)
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// SinkFactory opens a sink from its spec (e.g. "record:/recordings")
+type SinkFactory func(spec string, options Options) (Sink, error)
+
+// FilterFactory creates a filter from the argument of its spec, which is empty when
+// the spec has none (e.g. "video" for "streams:video")
+type FilterFactory func(arg string, options Options) (Filter, error)
+
+var (
+	registryMu sync.Mutex
+	// Sink factories keyed by the scheme of their spec
+	sinkFactories = map[string]SinkFactory{}
+	// Filter factories keyed by name
+	filterFactories = map[string]FilterFactory{}
+)
+
+// RegisterSink makes a sink available to OpenSink and Build under the scheme of its
+// specs, replacing any sink registered for the scheme
+//
+// scheme: the part of the spec before the first colon (e.g. "record")
+//
+// factory: the function opening the sink
+//
+// Example: RegisterSink("s3", openS3)
+func RegisterSink(scheme string, factory SinkFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	sinkFactories[scheme] = factory
+}
+
+// RegisterFilter makes a filter available to NewFilter and Build under its name,
+// replacing any filter registered with the name
+//
+// name: the part of the spec before the first colon (e.g. "streams")
+//
+// factory: the function creating the filter
+//
+// Example: RegisterFilter("deinterlace", newDeinterlacer)
+func RegisterFilter(name string, factory FilterFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	filterFactories[name] = factory
+}
+
+// Sinks returns the registered sink schemes
+func Sinks() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	schemes := make([]string, 0, len(sinkFactories))
+	for scheme := range sinkFactories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// OpenSink opens the sink described by the spec, named after its scheme
+//
+// spec: the sink spec (e.g. "record:/recordings" or "udp://239.0.0.1:1234")
+//
+// options: the options passed to the factory
+//
+// Example: OpenSink("file:out.ts", Options{}) = Sink, nil
+func OpenSink(spec string, options Options) (Sink, error) {
+	options = withDefaults(options)
+	scheme, _, _ := strings.Cut(spec, ":")
+
+	registryMu.Lock()
+	factory, ok := sinkFactories[scheme]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported sink %q", spec)
+	}
+
+	sink, err := factory(spec, options)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sink.(interface{ Name() string }); !ok {
+		sink = Named(scheme, sink)
+	}
+
+	return sink, nil
+}
+
+// NewFilter creates the filter described by the spec
+//
+// spec: the filter name, optionally followed by a colon and an argument (e.g.
+// "streams:video" or "bitrate")
+//
+// options: the options passed to the factory
+//
+// Example: NewFilter("streams:audio", Options{}) = Filter, nil
+func NewFilter(spec string, options Options) (Filter, error) {
+	options = withDefaults(options)
+	name, arg, _ := strings.Cut(spec, ":")
+
+	registryMu.Lock()
+	factory, ok := filterFactories[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported filter %q", spec)
+	}
+
+	return factory(arg, options)
+}
+
+// Spec describes a pipeline declaratively, e.g. from a configuration file
+type Spec struct {
+	// The filter specs, in order (e.g. "streams:video")
+	Filters []string
+	// The sink specs (e.g. "record:/recordings")
+	Sinks []string
+	// The bytes buffered for each sink. Zero writes to the sinks directly
+	BufferSize int
+	// The overflow policy of the sink buffers (e.g. "drop")
+	Policy string
+	// How long Close waits for each sink buffer to drain
+	FlushTimeout time.Duration
+}
+
+// Build creates the filters and opens the sinks of the spec and composes them into
+// a pipeline. Sinks opened before a failure are closed again.
+//
+// spec: the pipeline description
+//
+// options: the options passed to the stage factories
+//
+// Example: Build(Spec{Filters: []string{"streams:video"}, Sinks: []string{"record:/rec"}}, Options{}) = &Pipeline{...}, nil
+func Build(spec Spec, options Options) (*Pipeline, error) {
+	options = withDefaults(options)
+
+	var filters []Filter
+	for _, filterSpec := range spec.Filters {
+		filter, err := NewFilter(filterSpec, options)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	var sinks []Sink
+	closeSinks := func() {
+		for _, sink := range sinks {
+			sink.Close()
+		}
+	}
+	for _, sinkSpec := range spec.Sinks {
+		sink, err := OpenSink(sinkSpec, options)
+		if err != nil {
+			closeSinks()
+			return nil, fmt.Errorf("error opening sink %q: %w", sinkSpec, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	p, err := New(Config{
+		Filters:      filters,
+		Sinks:        sinks,
+		BufferSize:   spec.BufferSize,
+		Policy:       spec.Policy,
+		FlushTimeout: spec.FlushTimeout,
+		Metrics:      options.Metrics,
+		OnSinkError:  options.OnSinkError,
+		OnLog:        options.OnLog,
+	})
+	if err != nil {
+		closeSinks()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func withDefaults(options Options) Options {
+	if options.StreamName == "" {
+		options.StreamName = "blink"
+	}
+	if options.Metrics == nil {
+		options.Metrics = metrics.Noop
+	}
+	if options.OnLog == nil {
+		options.OnLog = func(string) {}
+	}
+
+	return options
+}
@@ -0,0 +1,97 @@
+// Package systemd reports the service state to systemd for units of Type=notify,
+// without linking against libsystemd.
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NOTIFY_SOCKET_ENV is the environment variable holding the notification socket
+const NOTIFY_SOCKET_ENV = "NOTIFY_SOCKET"
+
+// Service states sent with Notify
+const (
+	// The service finished starting up
+	READY = "READY=1"
+	// The service is reloading its configuration. Send READY when done
+	RELOADING = "RELOADING=1"
+	// The service is shutting down
+	STOPPING = "STOPPING=1"
+)
+
+// Enabled returns whether the process was started by systemd with a notification
+// socket, i.e. in a unit of Type=notify
+func Enabled() bool {
+	return os.Getenv(NOTIFY_SOCKET_ENV) != ""
+}
+
+// Notify sends state lines (e.g. READY) to the service manager. It does nothing when
+// the process was not started with a notification socket.
+//
+// states: the state assignments to send, e.g. READY and Status("Streaming")
+//
+// Example: Notify(READY, Status("Streaming camera 11111")) = nil
+func Notify(states ...string) error {
+	socket := os.Getenv(NOTIFY_SOCKET_ENV)
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are passed with a leading "@"
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialTimeout("unixgram", socket, time.Second)
+	if err != nil {
+		return fmt.Errorf("error connecting to the notification socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return fmt.Errorf("error notifying the service manager: %w", err)
+	}
+
+	return nil
+}
+
+// Status returns the state assignment for a free-form status shown by systemctl
+//
+// status: the status text
+//
+// Example: Status("Streaming") = "STATUS=Streaming"
+func Status(status string) string {
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// WritePIDFile writes the process ID to a file, replacing a file left behind by a
+// process that is no longer running
+//
+// path: the PID file path (e.g. "/run/blink/liveview.pid")
+//
+// Example: WritePIDFile("/run/blink/liveview.pid") = nil
+func WritePIDFile(path string) error {
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processRunning(pid) {
+			return fmt.Errorf("process %d from %s is still running", pid, path)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading PID file: %w", err)
+	}
+
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return fmt.Errorf("error writing PID file: %w", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error writing PID file: %w", err)
+	}
+
+	return nil
+}
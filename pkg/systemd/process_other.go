@@ -0,0 +1,17 @@
+//go:build !unix
+
+package systemd
+
+import "os"
+
+// processRunning returns whether a process with the ID exists. Finding a process
+// fails on Windows when it does not exist
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+
+	return true
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package systemd
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning returns whether a process with the ID exists
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,177 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Animated image formats
+const (
+	FORMAT_GIF  = "gif"
+	FORMAT_WEBP = "webp"
+)
+
+const (
+	// DEFAULT_ANIMATION_WIDTH is the default maximum width of an animation, in pixels
+	DEFAULT_ANIMATION_WIDTH = 480
+	// MAX_ANIMATION_WIDTH bounds the width of an animation, in pixels
+	MAX_ANIMATION_WIDTH = 1280
+	// DEFAULT_ANIMATION_FPS is the default frame rate of an animation
+	DEFAULT_ANIMATION_FPS = 8
+	// MAX_ANIMATION_FPS bounds the frame rate of an animation
+	MAX_ANIMATION_FPS = 15
+	// DEFAULT_ANIMATION_DURATION is the default length of an animation
+	DEFAULT_ANIMATION_DURATION = 5 * time.Second
+	// MAX_ANIMATION_DURATION bounds the length of an animation, keeping it small
+	// enough for notification payloads
+	MAX_ANIMATION_DURATION = 30 * time.Second
+	// DEFAULT_ANIMATION_TIMEOUT bounds the ffmpeg process encoding an animation
+	DEFAULT_ANIMATION_TIMEOUT = time.Minute
+)
+
+// ErrNoFrames is returned when the input contains no video to animate
+var ErrNoFrames = errors.New("no video frames to animate")
+
+type AnimationConfig struct {
+	// The image format, FORMAT_GIF or FORMAT_WEBP (defaults to FORMAT_GIF)
+	Format string
+	// The maximum width in pixels, keeping the aspect ratio. Narrower video keeps
+	// its size (defaults to DEFAULT_ANIMATION_WIDTH, at most MAX_ANIMATION_WIDTH)
+	Width int
+	// The frame rate (defaults to DEFAULT_ANIMATION_FPS, at most MAX_ANIMATION_FPS)
+	FPS int
+	// Optional offset of the animation from the start of the input
+	Start time.Duration
+	// The length of the animation (defaults to DEFAULT_ANIMATION_DURATION, at most
+	// MAX_ANIMATION_DURATION)
+	Duration time.Duration
+	// The ffmpeg command encoding the animation (defaults to "ffmpeg")
+	FFmpeg string
+	// Maximum time for ffmpeg to encode the animation (defaults to
+	// DEFAULT_ANIMATION_TIMEOUT)
+	Timeout time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// AnimationFormat returns the animated image format of a file name by its extension
+//
+// path: the image file name
+//
+// Example: AnimationFormat("motion.webp") = "webp", nil
+func AnimationFormat(path string) (string, error) {
+	switch format := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), ".")); format {
+	case FORMAT_GIF, FORMAT_WEBP:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported animation format %q, expecting .%s or .%s", filepath.Ext(path), FORMAT_GIF, FORMAT_WEBP)
+	}
+}
+
+// Animate encodes MPEG-TS data, e.g. a recorded segment or the footage retained by
+// record.PreRoll, into an animated image.
+//
+// ctx: cancels the encoding
+//
+// config: the animation configuration
+//
+// stream: the MPEG-TS data
+//
+// Example: Animate(ctx, AnimationConfig{Format: "gif"}, bytes.NewReader(preRoll.Retained())) = []byte{...}, nil
+func Animate(ctx context.Context, config AnimationConfig, stream io.Reader) ([]byte, error) {
+	return animate(ctx, config, []string{"-f", "mpegts", "-i", "-"}, stream)
+}
+
+// AnimateFile encodes a recording of any format ffmpeg reads (e.g. an MPEG-TS
+// segment or an MP4 clip) into an animated image.
+//
+// ctx: cancels the encoding
+//
+// config: the animation configuration
+//
+// path: the recording
+//
+// Example: AnimateFile(ctx, AnimationConfig{Format: "webp", Start: 5 * time.Second}, "clip.mp4") = []byte{...}, nil
+func AnimateFile(ctx context.Context, config AnimationConfig, path string) ([]byte, error) {
+	return animate(ctx, config, []string{"-i", path}, nil)
+}
+
+// animate runs ffmpeg on the input and returns the encoded image
+func animate(ctx context.Context, config AnimationConfig, input []string, stdin io.Reader) ([]byte, error) {
+	if config.Format == "" {
+		config.Format = FORMAT_GIF
+	}
+	if config.Width <= 0 {
+		config.Width = DEFAULT_ANIMATION_WIDTH
+	}
+	if config.FPS <= 0 {
+		config.FPS = DEFAULT_ANIMATION_FPS
+	}
+	if config.Duration <= 0 {
+		config.Duration = DEFAULT_ANIMATION_DURATION
+	}
+	if config.FFmpeg == "" {
+		config.FFmpeg = "ffmpeg"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_ANIMATION_TIMEOUT
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+	config.Width = min(config.Width, MAX_ANIMATION_WIDTH)
+	config.FPS = min(config.FPS, MAX_ANIMATION_FPS)
+	config.Duration = min(config.Duration, MAX_ANIMATION_DURATION)
+
+	// The scaled height is rounded to an even number, which the encoders prefer
+	scale := fmt.Sprintf("fps=%d,scale='min(%d,iw)':-2:flags=lanczos", config.FPS, config.Width)
+	var output []string
+	switch config.Format {
+	case FORMAT_GIF:
+		// A palette generated from the clip looks far better than the default one
+		output = []string{"-vf", scale + ",split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer", "-loop", "0", "-f", "gif", "-"}
+	case FORMAT_WEBP:
+		output = []string{"-vf", scale, "-c:v", "libwebp", "-quality", "60", "-loop", "0", "-f", "webp", "-"}
+	default:
+		return nil, fmt.Errorf("unsupported animation format %q, expecting %s or %s", config.Format, FORMAT_GIF, FORMAT_WEBP)
+	}
+
+	args := append([]string{"-loglevel", "error"}, input...)
+	if config.Start > 0 {
+		args = append(args, "-ss", seconds(config.Start))
+	}
+	args = append(args, "-t", seconds(config.Duration), "-an")
+	args = append(args, output...)
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, config.FFmpeg, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	started := time.Now()
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error encoding the animation with %s: %w %s", config.FFmpeg, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, ErrNoFrames
+	}
+	config.OnLog(fmt.Sprintf("Encoded a %s animation of %d bytes in %s", config.Format, stdout.Len(), time.Since(started).Round(time.Millisecond)))
+
+	return stdout.Bytes(), nil
+}
+
+// seconds formats a duration as fractional seconds for ffmpeg
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,321 @@
+// Package transcode converts the audio of the livestream into a codec that browsers
+// and web players can decode, and clips of it into animated images.
+//
+// Some cameras send audio that browsers cannot play. The audio transcoder runs the
+// stream through ffmpeg, copying the video and re-encoding the audio to AAC or
+// Opus. Streams whose audio already is in the target codec, or that carry no audio,
+// are passed through without starting ffmpeg.
+//
+// Notification services (Telegram, Pushover, Home Assistant) often cannot embed
+// video. Animate and AnimateFile encode a short clip into a GIF or WebP image of
+// bounded size and length instead.
+//
+// The speech tap decodes the audio into PCM for a callback or a speech-to-text
+// command, e.g. to transcribe the conversation at a doorbell.
+package transcode
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target audio codecs
+const (
+	CODEC_AAC  = "aac"
+	CODEC_OPUS = "opus"
+)
+
+const (
+	// DEFAULT_AUDIO_BITRATE is the default bitrate of the transcoded audio
+	DEFAULT_AUDIO_BITRATE = "64k"
+	// DETECT_BYTES is how much of the stream is held back while looking for audio.
+	// A stream without audio within it is passed through
+	DETECT_BYTES = 1 << 20
+)
+
+// ErrClosed is returned by Write once the transcoder is closed
+var ErrClosed = errors.New("transcoder closed")
+
+type AudioConfig struct {
+	// The target codec, CODEC_AAC or CODEC_OPUS (defaults to CODEC_AAC)
+	Codec string
+	// The bitrate of the transcoded audio (defaults to DEFAULT_AUDIO_BITRATE)
+	Bitrate string
+	// The ffmpeg command transcoding the audio (defaults to "ffmpeg")
+	FFmpeg string
+	// The time Close waits for ffmpeg to write the rest of the stream (defaults to 2s)
+	ExitTimeout time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Audio transcodes the audio of the stream written to it and writes the result to
+// the next stage
+type Audio struct {
+	// Configuration options for the transcoder
+	config AudioConfig
+	// The stage receiving the stream
+	next io.Writer
+	// Detects the audio codec of the stream
+	demuxer *mpegts.Demuxer
+	// Guards the fields below
+	mu sync.Mutex
+	// The stream held back until the audio codec is known
+	pending []byte
+	// Whether the stream is passed through untouched
+	passthrough bool
+	// The running ffmpeg process and its standard input, or nil
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	// Closed once the output of ffmpeg is written to the next stage
+	copied chan struct{}
+	// Whether Close was called
+	closed bool
+	// Guards err, which the goroutine copying the output of ffmpeg sets without
+	// holding mu, as Write may be blocked on ffmpeg while holding it
+	errMu sync.Mutex
+	// The error of the next stage, returned by later writes
+	err error
+}
+
+// NewAudio initializes a new audio transcoder in front of the next stage.
+//
+// next: the stage receiving the transcoded stream
+//
+// config: the transcoder configuration
+//
+// Example: NewAudio(recorder, AudioConfig{Codec: CODEC_OPUS}) = &Audio{...}, nil
+func NewAudio(next io.Writer, config AudioConfig) (*Audio, error) {
+	switch config.Codec {
+	case "":
+		config.Codec = CODEC_AAC
+	case CODEC_AAC, CODEC_OPUS:
+	default:
+		return nil, fmt.Errorf("unsupported audio codec %q (aac, opus)", config.Codec)
+	}
+	if config.Bitrate == "" {
+		config.Bitrate = DEFAULT_AUDIO_BITRATE
+	}
+	if config.FFmpeg == "" {
+		config.FFmpeg = "ffmpeg"
+	}
+	if config.ExitTimeout <= 0 {
+		config.ExitTimeout = 2 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	a := &Audio{config: config, next: next}
+	a.demuxer = mpegts.NewDemuxer(a.detect)
+
+	return a, nil
+}
+
+// Write transcodes the stream data. Until the audio codec is known, the data is
+// held back, up to DETECT_BYTES.
+func (a *Audio) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case a.closed:
+		return 0, ErrClosed
+	case a.failed() != nil:
+		return 0, a.failed()
+	case a.passthrough:
+		return a.next.Write(p)
+	case a.stdin != nil:
+		if _, err := a.stdin.Write(p); err != nil {
+			a.fallback(fmt.Errorf("%s exited: %w", a.config.FFmpeg, err))
+		}
+		return len(p), nil
+	}
+
+	a.pending = append(a.pending, p...)
+	a.demuxer.Write(p)
+	if !a.passthrough && a.stdin == nil && len(a.pending) >= DETECT_BYTES {
+		a.config.OnLog("No audio found in the stream, passing it through")
+		a.passthrough = true
+	}
+	if a.passthrough || a.stdin != nil {
+		a.release()
+	}
+
+	return len(p), a.failed()
+}
+
+// Discontinuity signals the next stage that the stream restarts. ffmpeg smooths
+// over the restart itself, so it is only forwarded when passing through.
+func (a *Audio) Discontinuity() {
+	a.mu.Lock()
+	passthrough := a.passthrough
+	a.mu.Unlock()
+
+	if d, ok := a.next.(interface{ Discontinuity() }); ok && passthrough {
+		d.Discontinuity()
+	}
+}
+
+// Close ends the input of ffmpeg and waits up to ExitTimeout for it to write the
+// rest of the stream. The next stage is not closed.
+func (a *Audio) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	cmd, stdin, copied := a.cmd, a.stdin, a.copied
+	a.mu.Unlock()
+
+	if cmd == nil {
+		return nil
+	}
+
+	stdin.Close()
+	select {
+	case <-copied:
+	case <-time.After(a.config.ExitTimeout):
+		cmd.Process.Kill()
+		<-copied
+	}
+	cmd.Wait()
+
+	return nil
+}
+
+// detect decides from the first audio access unit whether the stream needs to be
+// transcoded. a.mu is held.
+func (a *Audio) detect(au mpegts.AccessUnit) {
+	if au.IsVideo() || a.passthrough || a.stdin != nil {
+		return
+	}
+
+	if a.config.Codec == CODEC_AAC && au.StreamType == mpegts.STREAM_TYPE_AAC {
+		a.config.OnLog("The audio already is AAC, passing it through")
+		a.passthrough = true
+		return
+	}
+
+	if err := a.start(); err != nil {
+		a.fallback(err)
+		return
+	}
+	a.config.OnLog(fmt.Sprintf("Transcoding audio (stream type 0x%02x) to %s", au.StreamType, a.config.Codec))
+}
+
+// start runs ffmpeg copying the video and re-encoding the audio. a.mu is held.
+func (a *Audio) start() error {
+	encoder := "aac"
+	if a.config.Codec == CODEC_OPUS {
+		encoder = "libopus"
+	}
+
+	var stderr strings.Builder
+	cmd := exec.Command(a.config.FFmpeg,
+		"-loglevel", "error",
+		"-fflags", "+genpts", "-f", "mpegts", "-i", "-",
+		"-map", "0:v?", "-map", "0:a?", "-c:v", "copy",
+		"-c:a", encoder, "-b:a", a.config.Bitrate, "-ac", "1",
+		"-f", "mpegts", "-",
+	)
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %w", a.config.FFmpeg, err)
+	}
+
+	a.cmd, a.stdin, a.copied = cmd, stdin, make(chan struct{})
+	go a.copy(stdout, &stderr)
+
+	return nil
+}
+
+// copy writes the output of ffmpeg to the next stage
+func (a *Audio) copy(stdout io.Reader, stderr *strings.Builder) {
+	defer close(a.copied)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 && a.failed() == nil {
+			if _, err := a.next.Write(buf[:n]); err != nil {
+				a.fail(err)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.closed && a.failed() == nil && !a.passthrough {
+		a.fallback(fmt.Errorf("%s exited: %s", a.config.FFmpeg, strings.TrimSpace(stderr.String())))
+	}
+}
+
+// fallback passes the stream through untranscoded after ffmpeg failed, so the
+// outputs keep receiving it. a.mu is held.
+func (a *Audio) fallback(err error) {
+	a.config.OnLog(fmt.Sprintf("Error transcoding audio, passing it through: %v", err))
+	if a.cmd != nil {
+		a.stdin.Close()
+		a.cmd.Process.Kill()
+	}
+	a.passthrough = true
+	a.cmd, a.stdin = nil, nil
+}
+
+// release writes the held back stream to ffmpeg or the next stage. a.mu is held.
+func (a *Audio) release() {
+	pending := a.pending
+	a.pending = nil
+	if len(pending) == 0 {
+		return
+	}
+
+	if a.passthrough {
+		if _, err := a.next.Write(pending); err != nil {
+			a.fail(err)
+		}
+		return
+	}
+	if _, err := a.stdin.Write(pending); err != nil {
+		a.fallback(fmt.Errorf("%s exited: %w", a.config.FFmpeg, err))
+		a.release()
+	}
+}
+
+// fail records the error of the next stage
+func (a *Audio) fail(err error) {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// failed returns the error of the next stage, if it failed
+func (a *Audio) failed() error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+
+	return a.err
+}
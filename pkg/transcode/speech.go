@@ -0,0 +1,309 @@
+package transcode
+
+import (
+	"amattu2/blink-middleware/pkg/mpegts"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DEFAULT_SPEECH_SAMPLE_RATE is the default sample rate of the decoded audio, the
+	// rate most speech-to-text engines expect
+	DEFAULT_SPEECH_SAMPLE_RATE = 16000
+	// SPEECH_CHUNK_DURATION is the length of the audio of each OnAudio call
+	SPEECH_CHUNK_DURATION = 100 * time.Millisecond
+)
+
+type SpeechConfig struct {
+	// The sample rate of the decoded audio in Hz (defaults to
+	// DEFAULT_SPEECH_SAMPLE_RATE)
+	SampleRate int
+	// Optional callback receiving the decoded audio in chunks of
+	// SPEECH_CHUNK_DURATION, as signed 16-bit little-endian mono PCM. The stream
+	// waits while it runs, so slow consumers should copy the chunk and return
+	OnAudio func(pcm []byte)
+	// Optional speech-to-text command receiving the decoded audio on its standard
+	// input (e.g. a whisper.cpp stream wrapper). Each line it prints is a transcript
+	Command string
+	// The arguments of Command
+	Args []string
+	// Optional callback receiving each transcript printed by Command
+	OnTranscript func(text string)
+	// The ffmpeg command decoding the audio (defaults to "ffmpeg")
+	FFmpeg string
+	// The time Close waits for ffmpeg and Command to finish the audio and
+	// transcripts already received (defaults to 5s)
+	ExitTimeout time.Duration
+	// Callback for logging messages
+	OnLog func(string)
+}
+
+// Speech decodes the audio of the stream written to it into PCM, e.g. to transcribe
+// the conversation at a doorbell. The audio is handed to the OnAudio callback and
+// piped to the speech-to-text command, whose output lines are the transcripts. The
+// video is discarded before decoding.
+type Speech struct {
+	// Configuration options for the tap
+	config SpeechConfig
+	// Passes the audio of the stream on to ffmpeg
+	filter *mpegts.Filter
+	// Guards the fields below
+	mu sync.Mutex
+	// The ffmpeg process decoding the audio and its standard input
+	ffmpeg *exec.Cmd
+	stdin  io.WriteCloser
+	// The speech-to-text process and its standard input, or nil without Command
+	stt      *exec.Cmd
+	sttStdin io.WriteCloser
+	// Closed once the decoded audio and the transcripts were delivered
+	decoded     chan struct{}
+	transcribed chan struct{}
+	// Whether Close was called
+	closed bool
+	// Guards err, which the goroutines reading the processes set
+	errMu sync.Mutex
+	// The error that ended the tap, returned by later writes
+	err error
+}
+
+// NewSpeech starts ffmpeg decoding the audio of the stream and, if configured, the
+// speech-to-text command.
+//
+// config: the tap configuration
+//
+// Example: NewSpeech(SpeechConfig{Command: "./transcribe.sh", OnTranscript: onTranscript}) = &Speech{...}, nil
+func NewSpeech(config SpeechConfig) (*Speech, error) {
+	if config.OnAudio == nil && config.Command == "" {
+		return nil, errors.New("the speech tap requires OnAudio or a command")
+	}
+	if config.SampleRate <= 0 {
+		config.SampleRate = DEFAULT_SPEECH_SAMPLE_RATE
+	}
+	if config.FFmpeg == "" {
+		config.FFmpeg = "ffmpeg"
+	}
+	if config.ExitTimeout <= 0 {
+		config.ExitTimeout = 5 * time.Second
+	}
+	if config.OnLog == nil {
+		config.OnLog = func(string) {}
+	}
+
+	s := &Speech{
+		config:      config,
+		decoded:     make(chan struct{}),
+		transcribed: make(chan struct{}),
+	}
+	if err := s.startSTT(); err != nil {
+		return nil, err
+	}
+	if err := s.startFFmpeg(); err != nil {
+		if s.stt != nil {
+			s.sttStdin.Close()
+			s.stt.Process.Kill()
+			s.stt.Wait()
+		}
+		return nil, err
+	}
+	s.filter, _ = mpegts.NewFilter(s.stdin, mpegts.STREAMS_AUDIO)
+
+	return s, nil
+}
+
+// startSTT runs the speech-to-text command, delivering the lines it prints as
+// transcripts
+func (s *Speech) startSTT() error {
+	if s.config.Command == "" {
+		close(s.transcribed)
+		return nil
+	}
+
+	var stderr strings.Builder
+	cmd := exec.Command(s.config.Command, s.config.Args...)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %w", s.config.Command, err)
+	}
+	s.stt, s.sttStdin = cmd, stdin
+
+	go func() {
+		defer close(s.transcribed)
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			s.config.OnLog("Transcript: " + text)
+			if s.config.OnTranscript != nil {
+				s.config.OnTranscript(text)
+			}
+		}
+
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if !closed {
+			s.fail(fmt.Errorf("%s exited: %s", s.config.Command, strings.TrimSpace(stderr.String())))
+		}
+	}()
+
+	return nil
+}
+
+// startFFmpeg runs ffmpeg decoding the audio into PCM for OnAudio and the
+// speech-to-text command
+func (s *Speech) startFFmpeg() error {
+	var stderr strings.Builder
+	cmd := exec.Command(s.config.FFmpeg,
+		"-loglevel", "error",
+		"-f", "mpegts", "-i", "-",
+		"-map", "0:a?", "-vn",
+		"-f", "s16le", "-acodec", "pcm_s16le", "-ac", "1", "-ar", fmt.Sprint(s.config.SampleRate),
+		"-",
+	)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %w", s.config.FFmpeg, err)
+	}
+	s.ffmpeg, s.stdin = cmd, stdin
+
+	go s.deliver(stdout, &stderr)
+
+	return nil
+}
+
+// deliver reads the decoded audio in chunks of SPEECH_CHUNK_DURATION and hands it
+// to OnAudio and the speech-to-text command
+func (s *Speech) deliver(stdout io.Reader, stderr *strings.Builder) {
+	defer close(s.decoded)
+
+	chunk := make([]byte, s.config.SampleRate*2*int(SPEECH_CHUNK_DURATION/time.Millisecond)/1000)
+	for {
+		n, err := io.ReadFull(stdout, chunk)
+		if n > 0 {
+			if s.config.OnAudio != nil {
+				s.config.OnAudio(chunk[:n])
+			}
+			if s.sttStdin != nil && s.failed() == nil {
+				if _, err := s.sttStdin.Write(chunk[:n]); err != nil {
+					s.fail(fmt.Errorf("%s exited: %w", s.config.Command, err))
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed {
+		s.fail(fmt.Errorf("%s exited: %s", s.config.FFmpeg, strings.TrimSpace(stderr.String())))
+	}
+}
+
+// Write passes the audio of the stream on to ffmpeg
+func (s *Speech) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, ErrClosed
+	}
+	if err := s.failed(); err != nil {
+		return 0, err
+	}
+	if _, err := s.filter.Write(p); err != nil {
+		s.fail(fmt.Errorf("%s exited: %w", s.config.FFmpeg, err))
+		return 0, s.failed()
+	}
+
+	return len(p), nil
+}
+
+// Discontinuity discards the partial packets of the stream after it was
+// re-established
+func (s *Speech) Discontinuity() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filter.Discontinuity()
+}
+
+// Close ends the input of ffmpeg and waits up to ExitTimeout for the remaining
+// audio to be decoded and transcribed, then stops both processes
+func (s *Speech) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	deadline := time.After(s.config.ExitTimeout)
+	s.stdin.Close()
+	select {
+	case <-s.decoded:
+	case <-deadline:
+		s.ffmpeg.Process.Kill()
+		<-s.decoded
+	}
+	s.ffmpeg.Wait()
+
+	if s.stt != nil {
+		s.sttStdin.Close()
+		select {
+		case <-s.transcribed:
+		case <-deadline:
+			s.stt.Process.Kill()
+			<-s.transcribed
+		}
+		s.stt.Wait()
+	}
+
+	return nil
+}
+
+// fail records the error that ended the tap
+func (s *Speech) fail(err error) {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// failed returns the error that ended the tap, if any
+func (s *Speech) failed() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+
+	return s.err
+}